@@ -50,6 +50,8 @@ var (
 	dryRun                 = flag.Bool("dryRun", false, "Whether to check only config files without running vmagent. The following files are checked: "+
 		"-promscrape.config, -remoteWrite.relabelConfig, -remoteWrite.urlRelabelConfig . "+
 		"Unknown config entries are allowed in -promscrape.config by default. This can be changed with -promscrape.config.strictParse")
+	scrapeOnce = flag.Bool("scrapeOnce", false, "Whether to read target urls from stdin, scrape them once, print the scraped series to stdout and exit. "+
+		"Every line in stdin must contain a single url. This may be useful for debugging scrape targets")
 )
 
 var (
@@ -76,6 +78,12 @@ func main() {
 		logger.Infof("-promscrape.config is ok; exitting with 0 status code")
 		return
 	}
+	if promscrape.IsTargetsDryRun() {
+		if err := promscrape.WriteDryRunTargets(os.Stdout); err != nil {
+			logger.Fatalf("error when discovering targets for -promscrape.config: %s", err)
+		}
+		return
+	}
 	if *dryRun {
 		if err := remotewrite.CheckRelabelConfigs(); err != nil {
 			logger.Fatalf("error when checking relabel configs: %s", err)
@@ -86,6 +94,12 @@ func main() {
 		logger.Infof("all the configs are ok; exitting with 0 status code")
 		return
 	}
+	if *scrapeOnce {
+		if err := scrapeOnceFromReader(os.Stdout, os.Stdin); err != nil {
+			logger.Fatalf("error when scraping urls from stdin: %s", err)
+		}
+		return
+	}
 
 	logger.Infof("starting vmagent at %q...", *httpListenAddr)
 	startTime := time.Now()
@@ -223,6 +237,16 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 		state := r.FormValue("state")
 		promscrape.WriteAPIV1Targets(w, state)
 		return true
+	case "/api/v1/scrape_pools":
+		promscrapeAPIV1ScrapePoolsRequests.Inc()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		promscrape.WriteScrapePoolsResponse(w)
+		return true
+	case "/service-discovery":
+		promscrapeServiceDiscoveryRequests.Inc()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		promscrape.WriteServiceDiscoveryResponse(w)
+		return true
 	case "/-/reload":
 		promscrapeConfigReloadRequests.Inc()
 		procutil.SelfSIGHUP()
@@ -263,8 +287,10 @@ var (
 
 	influxQueryRequests = metrics.NewCounter(`vmagent_http_requests_total{path="/query", protocol="influx"}`)
 
-	promscrapeTargetsRequests      = metrics.NewCounter(`vmagent_http_requests_total{path="/targets"}`)
-	promscrapeAPIV1TargetsRequests = metrics.NewCounter(`vmagent_http_requests_total{path="/api/v1/targets"}`)
+	promscrapeTargetsRequests          = metrics.NewCounter(`vmagent_http_requests_total{path="/targets"}`)
+	promscrapeAPIV1TargetsRequests     = metrics.NewCounter(`vmagent_http_requests_total{path="/api/v1/targets"}`)
+	promscrapeAPIV1ScrapePoolsRequests = metrics.NewCounter(`vmagent_http_requests_total{path="/api/v1/scrape_pools"}`)
+	promscrapeServiceDiscoveryRequests = metrics.NewCounter(`vmagent_http_requests_total{path="/service-discovery"}`)
 
 	promscrapeConfigReloadRequests = metrics.NewCounter(`vmagent_http_requests_total{path="/-/reload"}`)
 )
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape"
+)
+
+// scrapeOnceFromReader scrapes urls read from r once and writes the scraped series to w in Prometheus text exposition format.
+//
+// Every line in r must contain a single url. Empty lines and lines starting with `#` are ignored.
+func scrapeOnceFromReader(w io.Writer, r io.Reader) error {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("cannot read urls: %w", err)
+	}
+	results, err := promscrape.ScrapeOnce(urls, promscrape.ScrapeOptions{})
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	failedScrapes := 0
+	for i := range results {
+		result := &results[i]
+		if result.Err != nil {
+			logger.Errorf("cannot scrape %q: %s", result.URL, result.Err)
+			failedScrapes++
+		}
+		for j := range result.Timeseries {
+			writeTimeseries(bw, &result.Timeseries[j])
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("cannot write scraped data: %w", err)
+	}
+	if failedScrapes > 0 {
+		return fmt.Errorf("cannot scrape %d out of %d urls", failedScrapes, len(results))
+	}
+	return nil
+}
+
+func writeTimeseries(w io.Writer, ts *prompbmarshal.TimeSeries) {
+	metricName := ""
+	var labels []string
+	for _, label := range ts.Labels {
+		if label.Name == "__name__" {
+			metricName = label.Value
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+	}
+	for _, s := range ts.Samples {
+		fmt.Fprintf(w, "%s{%s} %g %d\n", metricName, strings.Join(labels, ","), s.Value, s.Timestamp)
+	}
+}
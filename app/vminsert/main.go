@@ -165,6 +165,16 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
 		state := r.FormValue("state")
 		promscrape.WriteAPIV1Targets(w, state)
 		return true
+	case "/api/v1/scrape_pools":
+		promscrapeAPIV1ScrapePoolsRequests.Inc()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		promscrape.WriteScrapePoolsResponse(w)
+		return true
+	case "/service-discovery":
+		promscrapeServiceDiscoveryRequests.Inc()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		promscrape.WriteServiceDiscoveryResponse(w)
+		return true
 	case "/-/reload":
 		promscrapeConfigReloadRequests.Inc()
 		procutil.SelfSIGHUP()
@@ -207,8 +217,10 @@ var (
 
 	influxQueryRequests = metrics.NewCounter(`vm_http_requests_total{path="/query", protocol="influx"}`)
 
-	promscrapeTargetsRequests      = metrics.NewCounter(`vm_http_requests_total{path="/targets"}`)
-	promscrapeAPIV1TargetsRequests = metrics.NewCounter(`vm_http_requests_total{path="/api/v1/targets"}`)
+	promscrapeTargetsRequests          = metrics.NewCounter(`vm_http_requests_total{path="/targets"}`)
+	promscrapeAPIV1TargetsRequests     = metrics.NewCounter(`vm_http_requests_total{path="/api/v1/targets"}`)
+	promscrapeAPIV1ScrapePoolsRequests = metrics.NewCounter(`vm_http_requests_total{path="/api/v1/scrape_pools"}`)
+	promscrapeServiceDiscoveryRequests = metrics.NewCounter(`vm_http_requests_total{path="/service-discovery"}`)
 
 	promscrapeConfigReloadRequests = metrics.NewCounter(`vm_http_requests_total{path="/-/reload"}`)
 
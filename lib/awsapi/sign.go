@@ -1,27 +1,31 @@
-package ec2
+package awsapi
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
 
-// newSignedRequest signed request for apiURL according to aws signature algorithm.
+// newSignedRequest returns signed request for apiURL according to aws signature algorithm.
+//
+// The headers are set in the request and are included into the signature together with the payload.
 //
 // See the algorithm at https://docs.aws.amazon.com/general/latest/gr/sigv4-signed-request-examples.html
-func newSignedRequest(apiURL, service, region string, creds *apiCredentials) (*http.Request, error) {
+func newSignedRequest(method, apiURL, service, region string, headers map[string]string, payload []byte, creds *credentials) (*http.Request, error) {
 	t := time.Now().UTC()
-	return newSignedRequestWithTime(apiURL, service, region, creds, t)
+	return newSignedRequestWithTime(method, apiURL, service, region, headers, payload, creds, t)
 }
 
-func newSignedRequestWithTime(apiURL, service, region string, creds *apiCredentials, t time.Time) (*http.Request, error) {
+func newSignedRequestWithTime(method, apiURL, service, region string, headers map[string]string, payload []byte, creds *credentials, t time.Time) (*http.Request, error) {
 	uri, err := url.Parse(apiURL)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse %q: %w", apiURL, err)
@@ -30,16 +34,31 @@ func newSignedRequestWithTime(apiURL, service, region string, creds *apiCredenti
 	// Create canonicalRequest
 	amzdate := t.Format("20060102T150405Z")
 	datestamp := t.Format("20060102")
-	canonicalURL := uri.Path
+	canonicalURL := uri.EscapedPath()
 	canonicalQS := uri.Query().Encode()
-	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-date:%s\n", uri.Host, amzdate)
-	signedHeaders := "host;x-amz-date"
-	payloadHash := hashHex("")
+	signedHeaderValues := map[string]string{
+		"host":       uri.Host,
+		"x-amz-date": amzdate,
+	}
+	for k, v := range headers {
+		signedHeaderValues[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	headerNames := make([]string, 0, len(signedHeaderValues))
+	for k := range signedHeaderValues {
+		headerNames = append(headerNames, k)
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, k := range headerNames {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, signedHeaderValues[k])
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+	payloadHash := hashHex(string(payload))
 	tmp := []string{
-		"GET",
+		method,
 		canonicalURL,
 		canonicalQS,
-		canonicalHeaders,
+		canonicalHeaders.String(),
 		signedHeaders,
 		payloadHash,
 	}
@@ -63,10 +82,13 @@ func newSignedRequestWithTime(apiURL, service, region string, creds *apiCredenti
 	// Calculate autheader
 	authHeader := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", algorithm, creds.AccessKeyID, credentialScope, signedHeaders, signature)
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequest(method, apiURL, bytes.NewReader(payload))
 	if err != nil {
 		return nil, fmt.Errorf("cannot create request from %q: %w", apiURL, err)
 	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	req.Header.Set("x-amz-date", amzdate)
 	req.Header.Set("Authorization", authHeader)
 	if creds.Token != "" {
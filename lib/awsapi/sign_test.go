@@ -0,0 +1,64 @@
+package awsapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSignedRequest(t *testing.T) {
+	f := func(apiURL string, authHeaderExpected string) {
+		t.Helper()
+		service := "ec2"
+		region := "us-east-1"
+		ac := &credentials{
+			AccessKeyID:     "fake-access-key",
+			SecretAccessKey: "foobar",
+		}
+		ct := time.Unix(0, 0).UTC()
+		req, err := newSignedRequestWithTime("GET", apiURL, service, region, nil, nil, ac, ct)
+		if err != nil {
+			t.Fatalf("error in newSignedRequest: %s", err)
+		}
+		authHeader := req.Header.Get("Authorization")
+		if authHeader != authHeaderExpected {
+			t.Fatalf("unexpected auth header;\ngot\n%s\nwant\n%s", authHeader, authHeaderExpected)
+		}
+	}
+	f("https://ec2.amazonaws.com/?Action=DescribeRegions&Version=2013-10-15",
+		"AWS4-HMAC-SHA256 Credential=fake-access-key/19700101/us-east-1/ec2/aws4_request, SignedHeaders=host;x-amz-date, Signature=79dc8f54719a4c11edcd5811824a071361b3514172a3f5c903b7e279dfa6a710")
+}
+
+func TestNewSignedRequestAWSTestSuite(t *testing.T) {
+	// See https://docs.aws.amazon.com/general/latest/gr/signature-v4-test-suite.html
+	f := func(method string, headers map[string]string, payload, authHeaderExpected string) {
+		t.Helper()
+		ac := &credentials{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		}
+		ct := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		req, err := newSignedRequestWithTime(method, "https://example.amazonaws.com/", "service", "us-east-1", headers, []byte(payload), ac, ct)
+		if err != nil {
+			t.Fatalf("error in newSignedRequest: %s", err)
+		}
+		authHeader := req.Header.Get("Authorization")
+		if authHeader != authHeaderExpected {
+			t.Fatalf("unexpected auth header;\ngot\n%s\nwant\n%s", authHeader, authHeaderExpected)
+		}
+		for k, v := range headers {
+			if req.Header.Get(k) != v {
+				t.Fatalf("unexpected value for header %q; got %q; want %q", k, req.Header.Get(k), v)
+			}
+		}
+	}
+	// get-vanilla
+	f("GET", nil, "", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, "+
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31")
+	// post-vanilla
+	f("POST", nil, "", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, "+
+		"Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b")
+	// post-x-www-form-urlencoded
+	f("POST", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "Param1=value1",
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, "+
+			"Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a")
+}
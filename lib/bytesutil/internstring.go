@@ -0,0 +1,88 @@
+package bytesutil
+
+import (
+	"flag"
+	"sync"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+)
+
+var internStringMaxLen = flag.Int("internStringMaxLen", 300, "The maximum length for strings to intern. Longer strings are copied without interning. "+
+	"Lower limit may save memory at the cost of higher CPU usage. See https://en.wikipedia.org/wiki/String_interning")
+
+// maxInternStrings is the maximum number of interned strings.
+//
+// New strings are copied without interning when this limit is reached, until unused strings are removed from the pool.
+const maxInternStrings = 1 << 20
+
+// internStringExpireSeconds is the duration after which the interned string is removed from the pool if it isn't accessed.
+const internStringExpireSeconds = 5 * 60
+
+var (
+	internStringsMap                sync.Map
+	internStringsCount              int64
+	internStringsMapLastCleanupTime uint64
+)
+
+type ismEntry struct {
+	lastAccessTime uint64
+	s              string
+}
+
+// InternBytes returns interned string for b.
+//
+// The returned string doesn't refer to b, so b may be modified after the call.
+func InternBytes(b []byte) string {
+	return InternString(ToUnsafeString(b))
+}
+
+// InternString returns interned s.
+//
+// Identical strings returned from InternString share the same backing storage.
+// This may be needed for reducing memory usage when the same strings are held in many places.
+// The returned string never refers to s, so s may refer to a byte slice, which is modified after the call.
+// It is safe calling InternString from concurrently running goroutines.
+func InternString(s string) string {
+	if len(s) > *internStringMaxLen {
+		return string(append([]byte{}, s...))
+	}
+	ct := fasttime.UnixTimestamp()
+	if v, ok := internStringsMap.Load(s); ok {
+		e := v.(*ismEntry)
+		if atomic.LoadUint64(&e.lastAccessTime)+10 < ct {
+			// Reduce the frequency of e.lastAccessTime updates to once per 10 seconds
+			// in order to improve the fast path speed on systems with many CPU cores.
+			atomic.StoreUint64(&e.lastAccessTime, ct)
+		}
+		return e.s
+	}
+	// Make a copy of s in order to remove references to possible bigger string s refers to.
+	sCopy := string(append([]byte{}, s...))
+	if atomic.LoadInt64(&internStringsCount) < maxInternStrings {
+		e := &ismEntry{
+			lastAccessTime: ct,
+			s:              sCopy,
+		}
+		v, loaded := internStringsMap.LoadOrStore(sCopy, e)
+		if loaded {
+			// The string has been interned by concurrent goroutine.
+			sCopy = v.(*ismEntry).s
+		} else {
+			atomic.AddInt64(&internStringsCount, 1)
+		}
+	}
+	if lastCleanupTime := atomic.LoadUint64(&internStringsMapLastCleanupTime); lastCleanupTime+60 < ct &&
+		atomic.CompareAndSwapUint64(&internStringsMapLastCleanupTime, lastCleanupTime, ct) {
+		// Remove strings, which weren't accessed during the last internStringExpireSeconds.
+		internStringsMap.Range(func(k, v interface{}) bool {
+			e := v.(*ismEntry)
+			if atomic.LoadUint64(&e.lastAccessTime)+internStringExpireSeconds < ct {
+				internStringsMap.Delete(k)
+				atomic.AddInt64(&internStringsCount, -1)
+			}
+			return true
+		})
+	}
+	return sCopy
+}
@@ -0,0 +1,77 @@
+package bytesutil
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func isSameString(a, b string) bool {
+	return len(a) == len(b) && (len(a) == 0 || (*(*[2]uintptr)(unsafe.Pointer(&a)))[0] == (*(*[2]uintptr)(unsafe.Pointer(&b)))[0])
+}
+
+func TestInternString(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		b := []byte(s)
+		result := InternBytes(b)
+		if result != s {
+			t.Fatalf("unexpected string returned; got %q; want %q", result, s)
+		}
+		// The interned string mustn't refer to b.
+		for i := range b {
+			b[i] = 'x'
+		}
+		if result != s {
+			t.Fatalf("the interned string refers to the original byte slice; got %q; want %q", result, s)
+		}
+		resultNew := InternString(s)
+		if !isSameString(result, resultNew) {
+			t.Fatalf("expecting the same backing storage for repeated interning of %q", s)
+		}
+	}
+	f("")
+	f("foo")
+	f("job")
+	f("kubernetes-pods")
+}
+
+func TestInternStringMaxLen(t *testing.T) {
+	b := make([]byte, *internStringMaxLen+1)
+	for i := range b {
+		b[i] = 'a'
+	}
+	s1 := InternBytes(b)
+	s2 := InternBytes(b)
+	if s1 != string(b) || s2 != string(b) {
+		t.Fatalf("unexpected strings returned")
+	}
+	if isSameString(s1, s2) {
+		t.Fatalf("strings longer than -internStringMaxLen mustn't be interned")
+	}
+}
+
+func TestInternStringConcurrent(t *testing.T) {
+	const goroutines = 5
+	results := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s := fmt.Sprintf("concurrent_%d", j)
+				results[n] = append(results[n], InternString(s))
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 1; i < goroutines; i++ {
+		for j := range results[i] {
+			if !isSameString(results[0][j], results[i][j]) {
+				t.Fatalf("expecting the same backing storage for %q interned from distinct goroutines", results[i][j])
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package bytesutil
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func BenchmarkInternBytes(b *testing.B) {
+	// Label values, which are shared among big number of series.
+	values := make([][]byte, 100)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("cluster-%d", i))
+	}
+	f := func(b *testing.B, convert func(b []byte) string) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(values)))
+		b.RunParallel(func(pb *testing.PB) {
+			// Hold the converted strings like the scraped series hold label values.
+			held := make([]string, len(values))
+			n := uint64(0)
+			for pb.Next() {
+				for i, v := range values {
+					held[i] = convert(v)
+				}
+				n += uint64(len(held[0]))
+			}
+			atomic.AddUint64(&Sink, n)
+		})
+	}
+	b.Run("intern", func(b *testing.B) {
+		f(b, InternBytes)
+	})
+	b.Run("copy", func(b *testing.B) {
+		f(b, func(b []byte) string { return string(b) })
+	})
+}
+
+// Sink should prevent from code elimination by optimizing compiler
+var Sink uint64
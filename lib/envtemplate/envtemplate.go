@@ -2,8 +2,10 @@ package envtemplate
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/valyala/fasttemplate"
 )
@@ -23,3 +25,104 @@ func Replace(b []byte) []byte {
 	})
 	return []byte(s)
 }
+
+// ReplaceShellVars replaces `$ENV_VAR` and `${ENV_VAR}` placeholders in b with the corresponding ENV_VAR values.
+//
+// The following forms with default values are supported additionally:
+//
+//   - `${ENV_VAR:-default}` is replaced with `default` if ENV_VAR is missing or empty.
+//   - `${ENV_VAR:?message}` results in error with the given message if ENV_VAR is missing or empty.
+//
+// An error is returned if ENV_VAR is missing in other forms. Use `$$` for `$` char.
+// `$` chars, which aren't followed by valid env var name such as `$1`, are left as is.
+func ReplaceShellVars(b []byte) ([]byte, error) {
+	if !bytes.Contains(b, []byte("$")) {
+		// Fast path - nothing to replace.
+		return b, nil
+	}
+	s := string(b)
+	var dst []byte
+	for {
+		n := strings.IndexByte(s, '$')
+		if n < 0 {
+			dst = append(dst, s...)
+			return dst, nil
+		}
+		dst = append(dst, s[:n]...)
+		s = s[n+1:]
+		if strings.HasPrefix(s, "$") {
+			dst = append(dst, '$')
+			s = s[1:]
+			continue
+		}
+		if !strings.HasPrefix(s, "{") {
+			name := getEnvVarName(s)
+			if len(name) == 0 {
+				dst = append(dst, '$')
+				continue
+			}
+			s = s[len(name):]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, fmt.Errorf("missing %q env var for `$%s`; use `${%s:-default}` form for the default value or `$$` for `$` char", name, name, name)
+			}
+			dst = append(dst, v...)
+			continue
+		}
+		n = strings.IndexByte(s, '}')
+		if n < 0 {
+			return nil, fmt.Errorf("missing `}` after `${` in %q", "${"+s)
+		}
+		expr := s[1:n]
+		name := getEnvVarName(expr)
+		if len(name) == 0 {
+			// Leave non-env var placeholders such as `${1}` as is.
+			dst = append(dst, '$')
+			continue
+		}
+		s = s[n+1:]
+		v, err := expandEnvVarExpr(name, expr[len(name):])
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, v...)
+	}
+}
+
+func expandEnvVarExpr(name, modifier string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	switch {
+	case modifier == "":
+		if !ok {
+			return "", fmt.Errorf("missing %q env var for `${%s}`; use `${%s:-default}` form for the default value", name, name, name)
+		}
+		return v, nil
+	case strings.HasPrefix(modifier, ":-"):
+		if v == "" {
+			v = modifier[len(":-"):]
+		}
+		return v, nil
+	case strings.HasPrefix(modifier, ":?"):
+		if v == "" {
+			msg := modifier[len(":?"):]
+			if msg == "" {
+				msg = "the env var is missing or empty"
+			}
+			return "", fmt.Errorf("%s: %s", name, msg)
+		}
+		return v, nil
+	default:
+		return "", fmt.Errorf("unsupported modifier %q for %q env var in `${%s%s}`; supported modifiers: `:-default`, `:?message`", modifier, name, name, modifier)
+	}
+}
+
+func getEnvVarName(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return s[:i]
+	}
+	return s
+}
@@ -1,6 +1,7 @@
 package envtemplate
 
 import (
+	"os"
 	"testing"
 )
 
@@ -17,3 +18,75 @@ func TestReplace(t *testing.T) {
 	f("%{foo}", "%{foo}")
 	f("foo %{bar} %{baz}", "foo %{bar} %{baz}")
 }
+
+func TestReplaceShellVarsSuccess(t *testing.T) {
+	if err := os.Setenv("VM_TEST_DEFINED", "foo"); err != nil {
+		t.Fatalf("cannot set env var: %s", err)
+	}
+	defer os.Unsetenv("VM_TEST_DEFINED")
+	if err := os.Setenv("VM_TEST_EMPTY", ""); err != nil {
+		t.Fatalf("cannot set env var: %s", err)
+	}
+	defer os.Unsetenv("VM_TEST_EMPTY")
+
+	f := func(s, resultExpected string) {
+		t.Helper()
+		result, err := ReplaceShellVars([]byte(s))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+	f("", "")
+	f("foo", "foo")
+	f("%{VM_TEST_DEFINED}", "%{VM_TEST_DEFINED}")
+
+	// Defined vars
+	f("$VM_TEST_DEFINED", "foo")
+	f("${VM_TEST_DEFINED}", "foo")
+	f("a $VM_TEST_DEFINED-b ${VM_TEST_DEFINED}c", "a foo-b fooc")
+	f("${VM_TEST_EMPTY}", "")
+	f("${VM_TEST_DEFINED:-bar}", "foo")
+	f("${VM_TEST_DEFINED:?must be set}", "foo")
+
+	// Defaulted vars
+	f("${VM_TEST_MISSING:-bar}", "bar")
+	f("${VM_TEST_EMPTY:-bar}", "bar")
+	f("${VM_TEST_MISSING:-}", "")
+	f("port: ${VM_TEST_MISSING:-9100}", "port: 9100")
+
+	// Escaping and non-var placeholders
+	f("$$VM_TEST_DEFINED", "$VM_TEST_DEFINED")
+	f("pass$$word", "pass$word")
+	f("replacement: $1", "replacement: $1")
+	f("replacement: ${1}-${2}", "replacement: ${1}-${2}")
+	f("foo $", "foo $")
+	f("$ foo", "$ foo")
+}
+
+func TestReplaceShellVarsFailure(t *testing.T) {
+	if err := os.Setenv("VM_TEST_EMPTY", ""); err != nil {
+		t.Fatalf("cannot set env var: %s", err)
+	}
+	defer os.Unsetenv("VM_TEST_EMPTY")
+
+	f := func(s string) {
+		t.Helper()
+		result, err := ReplaceShellVars([]byte(s))
+		if err == nil {
+			t.Fatalf("expecting non-nil error; got result %q", result)
+		}
+	}
+
+	// Undefined vars
+	f("$VM_TEST_MISSING")
+	f("${VM_TEST_MISSING}")
+	f("${VM_TEST_MISSING:?must be set}")
+	f("${VM_TEST_EMPTY:?}")
+
+	// Invalid syntax
+	f("${VM_TEST_MISSING")
+	f("${VM_TEST_MISSING-foo}")
+}
@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Field is a structured field for log message.
+//
+// Fields are written as separate keys after `msg` key when `-loggerFormat=json` is set,
+// while they are ignored in the default format, since the message must contain all the essential information.
+type Field struct {
+	// Key is the field name. It mustn't clash with `ts`, `level`, `caller` and `msg` keys.
+	Key string
+
+	// Value is the field value. It is encoded with encoding/json.
+	Value interface{}
+}
+
+// FieldsLogger logs messages with structured fields.
+type FieldsLogger struct {
+	fields []Field
+}
+
+// WithFields returns FieldsLogger for logging messages with the given fields.
+func WithFields(fields ...Field) *FieldsLogger {
+	return &FieldsLogger{
+		fields: fields,
+	}
+}
+
+// Infof logs info message with fl fields.
+func (fl *FieldsLogger) Infof(format string, args ...interface{}) {
+	logLevelSkipframesFields(0, "INFO", fl.fields, format, args...)
+}
+
+// Warnf logs warn message with fl fields.
+func (fl *FieldsLogger) Warnf(format string, args ...interface{}) {
+	logLevelSkipframesFields(0, "WARN", fl.fields, format, args...)
+}
+
+// Errorf logs error message with fl fields.
+func (fl *FieldsLogger) Errorf(format string, args ...interface{}) {
+	logLevelSkipframesFields(0, "ERROR", fl.fields, format, args...)
+}
+
+// ErrorfSkipframes logs error message with fl fields and skips the given number of frames for the caller.
+func (fl *FieldsLogger) ErrorfSkipframes(skipframes int, format string, args ...interface{}) {
+	logLevelSkipframesFields(skipframes, "ERROR", fl.fields, format, args...)
+}
+
+// marshalFields appends fields to dst in JSON object key-value format, e.g. `,"key1":value1,"key2":value2`.
+func marshalFields(dst []byte, fields []Field) []byte {
+	for _, f := range fields {
+		dst = append(dst, ',')
+		dst = append(dst, fmt.Sprintf("%q", f.Key)...)
+		dst = append(dst, ':')
+		data, err := json.Marshal(f.Value)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%q", fmt.Sprintf("%v", f.Value)))
+		}
+		dst = append(dst, data...)
+	}
+	return dst
+}
+
+// SetOutputForTests sets log output to w and sets log format to the given format.
+//
+// It returns a func, which restores the previous output and format. It mustn't be used outside tests.
+func SetOutputForTests(w io.Writer, format string) func() {
+	mu.Lock()
+	outputOrig := output
+	formatOrig := *loggerFormat
+	output = w
+	*loggerFormat = strings.ToLower(format)
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		output = outputOrig
+		*loggerFormat = formatOrig
+		mu.Unlock()
+	}
+}
@@ -123,7 +123,15 @@ func logLevelSkipframes(skipframes int, level, format string, args ...interface{
 		return
 	}
 	msg := fmt.Sprintf(format, args...)
-	logMessage(level, msg, 3+skipframes)
+	logMessage(level, msg, nil, 3+skipframes)
+}
+
+func logLevelSkipframesFields(skipframes int, level string, fields []Field, format string, args ...interface{}) {
+	if shouldSkipLog(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	logMessage(level, msg, fields, 3+skipframes)
 }
 
 func logLimiterCleaner() {
@@ -189,7 +197,7 @@ func (lw *logWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func logMessage(level, msg string, skipframes int) {
+func logMessage(level, msg string, fields []Field, skipframes int) {
 	timestamp := ""
 	if !*disableTimestamps {
 		timestamp = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
@@ -228,10 +236,11 @@ func logMessage(level, msg string, skipframes int) {
 	switch *loggerFormat {
 	case "json":
 		if *disableTimestamps {
-			logMsg = fmt.Sprintf(`{"level":%q,"caller":%q,"msg":%q}`+"\n", levelLowercase, location, msg)
+			logMsg = fmt.Sprintf(`{"level":%q,"caller":%q,"msg":%q`, levelLowercase, location, msg)
 		} else {
-			logMsg = fmt.Sprintf(`{"ts":%q,"level":%q,"caller":%q,"msg":%q}`+"\n", timestamp, levelLowercase, location, msg)
+			logMsg = fmt.Sprintf(`{"ts":%q,"level":%q,"caller":%q,"msg":%q`, timestamp, levelLowercase, location, msg)
 		}
+		logMsg = string(marshalFields([]byte(logMsg), fields)) + "}\n"
 	default:
 		if *disableTimestamps {
 			logMsg = fmt.Sprintf("%s\t%s\t%s\n", levelLowercase, location, msg)
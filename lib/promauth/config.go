@@ -43,6 +43,9 @@ type Config struct {
 
 // String returns human-(un)readable representation for cfg.
 func (ac *Config) String() string {
+	if ac == nil {
+		return ""
+	}
 	return fmt.Sprintf("Authorization=%s, TLSRootCA=%s, TLSCertificate=%s, TLSServerName=%s, TLSInsecureSkipVerify=%v",
 		ac.Authorization, ac.tlsRootCAString(), ac.tlsCertificateString(), ac.TLSServerName, ac.TLSInsecureSkipVerify)
 }
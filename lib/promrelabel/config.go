@@ -21,6 +21,9 @@ type RelabelConfig struct {
 	Modulus      uint64   `yaml:"modulus,omitempty"`
 	Replacement  *string  `yaml:"replacement,omitempty"`
 	Action       string   `yaml:"action,omitempty"`
+
+	// Function is the built-in function for `action: transform`. See parseTransformFunc for supported functions.
+	Function string `yaml:"function,omitempty"`
 }
 
 // LoadRelabelConfigs loads relabel configs from the given path.
@@ -54,6 +57,12 @@ func ParseRelabelConfigs(dst []ParsedRelabelConfig, rcs []RelabelConfig) ([]Pars
 
 var defaultRegexForRelabelConfig = regexp.MustCompile("^(.*)$")
 
+var validLabelNameRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+func isValidLabelName(s string) bool {
+	return validLabelNameRegexp.MatchString(s)
+}
+
 func parseRelabelConfig(dst []ParsedRelabelConfig, rc *RelabelConfig) ([]ParsedRelabelConfig, error) {
 	sourceLabels := rc.SourceLabels
 	separator := ";"
@@ -82,6 +91,10 @@ func parseRelabelConfig(dst []ParsedRelabelConfig, rc *RelabelConfig) ([]ParsedR
 	if action == "" {
 		action = "replace"
 	}
+	if rc.Function != "" && action != "transform" {
+		return dst, fmt.Errorf("`function` can be set only for `action=transform`; got `action=%s`", action)
+	}
+	var transform *transformFunc
 	switch action {
 	case "replace":
 		if targetLabel == "" {
@@ -120,13 +133,36 @@ func parseRelabelConfig(dst []ParsedRelabelConfig, rc *RelabelConfig) ([]ParsedR
 		if modulus < 1 {
 			return dst, fmt.Errorf("unexpected `modulus` for `action=hashmod`: %d; must be greater than 0", modulus)
 		}
+	case "transform":
+		if len(sourceLabels) == 0 {
+			return dst, fmt.Errorf("missing `source_labels` for `action=transform`")
+		}
+		if targetLabel == "" {
+			return dst, fmt.Errorf("missing `target_label` for `action=transform`")
+		}
+		if rc.Function == "" {
+			return dst, fmt.Errorf("missing `function` for `action=transform`")
+		}
+		tf, err := parseTransformFunc(rc.Function)
+		if err != nil {
+			return dst, fmt.Errorf("invalid `function` for `action=transform`: %w", err)
+		}
+		transform = tf
 	case "labelmap":
 	case "labelmap_all":
 	case "labeldrop":
+	case "labeldrop_by_value":
+		if len(sourceLabels) != 1 {
+			return dst, fmt.Errorf("`source_labels` must contain exactly one entry for `action=labeldrop_by_value`; got %q", sourceLabels)
+		}
 	case "labelkeep":
+	case "sanitize_label_name":
 	default:
 		return dst, fmt.Errorf("unknown `action` %q", action)
 	}
+	if targetLabel != "" && !strings.Contains(targetLabel, "$") && !isValidLabelName(targetLabel) {
+		return dst, fmt.Errorf("invalid `target_label` %q; it must match %q", targetLabel, validLabelNameRegexp)
+	}
 	dst = append(dst, ParsedRelabelConfig{
 		SourceLabels: sourceLabels,
 		Separator:    separator,
@@ -136,6 +172,8 @@ func parseRelabelConfig(dst []ParsedRelabelConfig, rc *RelabelConfig) ([]ParsedR
 		Replacement:  replacement,
 		Action:       action,
 
+		transform: transform,
+
 		hasCaptureGroupInTargetLabel: strings.Contains(targetLabel, "$"),
 		hasCaptureGroupInReplacement: strings.Contains(replacement, "$"),
 	})
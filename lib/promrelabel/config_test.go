@@ -2,6 +2,7 @@ package promrelabel
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -64,6 +65,68 @@ func TestParseRelabelConfigsSuccess(t *testing.T) {
 			hasCaptureGroupInReplacement: true,
 		},
 	})
+
+	// Multi-char and empty separators must be preserved.
+	separator := "::"
+	emptySeparator := ""
+	f([]RelabelConfig{
+		{
+			SourceLabels: []string{"foo", "bar"},
+			Separator:    &separator,
+			TargetLabel:  "xxx",
+		},
+		{
+			SourceLabels: []string{"foo", "bar"},
+			Separator:    &emptySeparator,
+			TargetLabel:  "yyy",
+		},
+	}, []ParsedRelabelConfig{
+		{
+			SourceLabels: []string{"foo", "bar"},
+			Separator:    "::",
+			TargetLabel:  "xxx",
+			Regex:        defaultRegexForRelabelConfig,
+			Replacement:  "$1",
+			Action:       "replace",
+
+			hasCaptureGroupInReplacement: true,
+		},
+		{
+			SourceLabels: []string{"foo", "bar"},
+			Separator:    "",
+			TargetLabel:  "yyy",
+			Regex:        defaultRegexForRelabelConfig,
+			Replacement:  "$1",
+			Action:       "replace",
+
+			hasCaptureGroupInReplacement: true,
+		},
+	})
+}
+
+func TestParseRelabelConfigsTransform(t *testing.T) {
+	prcs, err := ParseRelabelConfigs(nil, []RelabelConfig{
+		{
+			Action:       "transform",
+			SourceLabels: []string{"foo"},
+			TargetLabel:  "bar",
+			Function:     "split(::,2)",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tfExpected := &transformFunc{
+		name: "split",
+		n:    2,
+		sep:  "::",
+	}
+	if !reflect.DeepEqual(prcs[0].transform, tfExpected) {
+		t.Fatalf("unexpected transform; got %#v; want %#v", prcs[0].transform, tfExpected)
+	}
+	if s := prcs[0].String(); !strings.HasSuffix(s, "Action=transform, Function=split(::,2)") {
+		t.Fatalf("unexpected string representation: %s", s)
+	}
 }
 
 func TestParseRelabelConfigsFailure(t *testing.T) {
@@ -147,6 +210,39 @@ func TestParseRelabelConfigsFailure(t *testing.T) {
 			},
 		})
 	})
+	t.Run("labeldrop_by_value-missing-source-labels", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				Action: "labeldrop_by_value",
+			},
+		})
+	})
+	t.Run("labeldrop_by_value-multiple-source-labels", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				Action:       "labeldrop_by_value",
+				SourceLabels: []string{"foo", "bar"},
+			},
+		})
+	})
+	t.Run("replace-invalid-target-label", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				Action:      "replace",
+				TargetLabel: "foo.bar-baz",
+			},
+		})
+	})
+	t.Run("hashmod-invalid-target-label", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				Action:       "hashmod",
+				SourceLabels: []string{"foo"},
+				TargetLabel:  "1abc",
+				Modulus:      10,
+			},
+		})
+	})
 	t.Run("drop-missing-source-labels", func(t *testing.T) {
 		f([]RelabelConfig{
 			{
@@ -181,6 +277,55 @@ func TestParseRelabelConfigsFailure(t *testing.T) {
 			},
 		})
 	})
+	t.Run("transform-missing-function", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"foo"},
+				TargetLabel:  "bar",
+			},
+		})
+	})
+	t.Run("transform-missing-target-label", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"foo"},
+				Function:     "truncate(3)",
+			},
+		})
+	})
+	t.Run("transform-unknown-function", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"foo"},
+				TargetLabel:  "bar",
+				Function:     "lower()",
+			},
+		})
+	})
+	t.Run("transform-invalid-args", func(t *testing.T) {
+		for _, function := range []string{"truncate", "truncate(0)", "truncate(x)", "cidr(129)", "cidr(-1)", "split(.)", "split(,1)", "split(.,-1)"} {
+			f([]RelabelConfig{
+				{
+					Action:       "transform",
+					SourceLabels: []string{"foo"},
+					TargetLabel:  "bar",
+					Function:     function,
+				},
+			})
+		}
+	})
+	t.Run("function-without-transform", func(t *testing.T) {
+		f([]RelabelConfig{
+			{
+				SourceLabels: []string{"foo"},
+				TargetLabel:  "bar",
+				Function:     "truncate(3)",
+			},
+		})
+	})
 	t.Run("invalid-action", func(t *testing.T) {
 		f([]RelabelConfig{
 			{
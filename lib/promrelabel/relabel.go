@@ -24,14 +24,17 @@ type ParsedRelabelConfig struct {
 	Replacement  string
 	Action       string
 
+	// transform is the function for `action: transform`.
+	transform *transformFunc
+
 	hasCaptureGroupInTargetLabel bool
 	hasCaptureGroupInReplacement bool
 }
 
 // String returns human-readable representation for prc.
 func (prc *ParsedRelabelConfig) String() string {
-	return fmt.Sprintf("SourceLabels=%s, Separator=%s, TargetLabel=%s, Regex=%s, Modulus=%d, Replacement=%s, Action=%s",
-		prc.SourceLabels, prc.Separator, prc.TargetLabel, prc.Regex.String(), prc.Modulus, prc.Replacement, prc.Action)
+	return fmt.Sprintf("SourceLabels=%s, Separator=%s, TargetLabel=%s, Regex=%s, Modulus=%d, Replacement=%s, Action=%s, Function=%s",
+		prc.SourceLabels, prc.Separator, prc.TargetLabel, prc.Regex.String(), prc.Modulus, prc.Replacement, prc.Action, prc.transform.String())
 }
 
 // ApplyRelabelConfigs applies prcs to labels starting from the labelsOffset.
@@ -197,6 +200,23 @@ func applyRelabelConfig(labels []prompbmarshal.Label, labelsOffset int, prc *Par
 		value := strconv.Itoa(int(h))
 		relabelBufPool.Put(bb)
 		return setLabelValue(labels, labelsOffset, prc.TargetLabel, value)
+	case "transform":
+		// Set target_label to the result of the function applied to the concatenated source_labels. For example:
+		//
+		//   - source_labels: [pod]
+		//     target_label: pod_prefix
+		//     function: truncate(8)
+		//     action: transform
+		//
+		// Would set `pod_prefix` label to the first 8 chars of `pod` label value.
+		bb := relabelBufPool.Get()
+		bb.B = concatLabelValues(bb.B[:0], src, prc.SourceLabels, prc.Separator)
+		value, ok := prc.transform.apply(string(bb.B))
+		relabelBufPool.Put(bb)
+		if !ok {
+			return labels
+		}
+		return setLabelValue(labels, labelsOffset, prc.TargetLabel, value)
 	case "labelmap":
 		for i := range src {
 			label := &src[i]
@@ -239,6 +259,28 @@ func applyRelabelConfig(labels []prompbmarshal.Label, labelsOffset int, prc *Par
 			}
 		}
 		return dst
+	case "labeldrop_by_value":
+		// Drop the label from source_labels if its value matches the regex.
+		// For example:
+		//
+		//   - source_labels: [pod_template_hash]
+		//     regex: "[0-9a-f]{8,10}"
+		//     action: labeldrop_by_value
+		//
+		// Would drop `pod_template_hash` label only if its value looks like a hash.
+		labelName := prc.SourceLabels[0]
+		for i := range src {
+			label := &src[i]
+			if label.Name != labelName {
+				continue
+			}
+			if !prc.Regex.MatchString(label.Value) {
+				return labels
+			}
+			dst := labels[:labelsOffset+i]
+			return append(dst, src[i+1:]...)
+		}
+		return labels
 	case "labelkeep":
 		keepSrc := true
 		for i := range src {
@@ -258,6 +300,41 @@ func applyRelabelConfig(labels []prompbmarshal.Label, labelsOffset int, prc *Par
 			}
 		}
 		return dst
+	case "sanitize_label_name":
+		// Replace chars, which are illegal in Prometheus label names, with underscores
+		// for all the labels with names matching the regex. For example:
+		//
+		//   - action: sanitize_label_name
+		//     regex: "__meta_.+"
+		//
+		// Would convert `__meta_foo.bar-baz` label name to `__meta_foo_bar_baz`.
+		//
+		// Labels with names, which become equal after sanitizing, are merged into a single label.
+		// The last label wins in this case like for `labelmap` action.
+		needsSanitizing := false
+		for i := range src {
+			label := &src[i]
+			if prc.Regex.MatchString(label.Name) && sanitizeLabelName(label.Name) != label.Name {
+				needsSanitizing = true
+				break
+			}
+		}
+		if !needsSanitizing {
+			// Fast path - nothing to sanitize.
+			return labels
+		}
+		srcCopy := append([]prompbmarshal.Label{}, src...)
+		labels = labels[:labelsOffset]
+		for i := range srcCopy {
+			label := &srcCopy[i]
+			labelName := label.Name
+			if prc.Regex.MatchString(labelName) {
+				labelName = sanitizeLabelName(labelName)
+			}
+			labels = setLabelValue(labels, labelsOffset, labelName, label.Value)
+		}
+		SortLabels(labels[labelsOffset:])
+		return labels
 	default:
 		logger.Panicf("BUG: unknown `action`: %q", prc.Action)
 		return labels
@@ -274,6 +351,22 @@ func (prc *ParsedRelabelConfig) expandCaptureGroups(template, source string, mat
 
 var relabelBufPool bytesutil.ByteBufferPool
 
+// sanitizeLabelName replaces chars, which are illegal in Prometheus label names, with underscores.
+//
+// Label names starting with a digit are prefixed with an underscore.
+func sanitizeLabelName(name string) string {
+	if isValidLabelName(name) {
+		return name
+	}
+	name = invalidLabelCharRegexp.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+var invalidLabelCharRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
 func areEqualLabelValues(labels []prompbmarshal.Label, labelNames []string) bool {
 	if len(labelNames) < 2 {
 		logger.Panicf("BUG: expecting at least 2 labelNames; got %d", len(labelNames))
@@ -289,6 +382,9 @@ func areEqualLabelValues(labels []prompbmarshal.Label, labelNames []string) bool
 	return true
 }
 
+// concatLabelValues appends values for labelNames from labels to dst, delimited by separator.
+//
+// separator may be empty or may contain multiple chars. Missing labels are treated as labels with empty values.
 func concatLabelValues(dst []byte, labels []prompbmarshal.Label, labelNames []string, separator string) []byte {
 	if len(labelNames) == 0 {
 		return dst
@@ -1,6 +1,7 @@
 package promrelabel
 
 import (
+	"fmt"
 	"reflect"
 	"regexp"
 	"testing"
@@ -170,6 +171,103 @@ func TestApplyRelabelConfigs(t *testing.T) {
 			},
 		})
 	})
+	t.Run("replace-hit-multichar-separator", func(t *testing.T) {
+		// Label values containing the default `;` separator mustn't break matching with multi-char separator.
+		f([]ParsedRelabelConfig{
+			{
+				Action:                       "replace",
+				SourceLabels:                 []string{"xxx", "foo"},
+				Separator:                    "::",
+				TargetLabel:                  "bar",
+				Regex:                        regexp.MustCompile("^(.*)::(.*)$"),
+				Replacement:                  "$2-$1",
+				hasCaptureGroupInReplacement: true,
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "foo",
+				Value: "c;d",
+			},
+			{
+				Name:  "xxx",
+				Value: "a;b",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "c;d-a;b",
+			},
+			{
+				Name:  "foo",
+				Value: "c;d",
+			},
+			{
+				Name:  "xxx",
+				Value: "a;b",
+			},
+		})
+		// Missing trailing label must leave the whole separator without truncation.
+		f([]ParsedRelabelConfig{
+			{
+				Action:                       "replace",
+				SourceLabels:                 []string{"xxx", "foo"},
+				Separator:                    "::",
+				TargetLabel:                  "bar",
+				Regex:                        defaultRegexForRelabelConfig,
+				Replacement:                  "$1",
+				hasCaptureGroupInReplacement: true,
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "yyy::",
+			},
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+		})
+	})
+	t.Run("replace-hit-empty-separator", func(t *testing.T) {
+		f([]ParsedRelabelConfig{
+			{
+				Action:                       "replace",
+				SourceLabels:                 []string{"xxx", "foo", "zzz"},
+				Separator:                    "",
+				TargetLabel:                  "bar",
+				Regex:                        defaultRegexForRelabelConfig,
+				Replacement:                  "$1",
+				hasCaptureGroupInReplacement: true,
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+			{
+				Name:  "zzz",
+				Value: "qwe",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "yyyqwe",
+			},
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+			{
+				Name:  "zzz",
+				Value: "qwe",
+			},
+		})
+	})
 	t.Run("replace_all-miss", func(t *testing.T) {
 		f([]ParsedRelabelConfig{
 			{
@@ -502,6 +600,45 @@ func TestApplyRelabelConfigs(t *testing.T) {
 			},
 		}, true, []prompbmarshal.Label{})
 	})
+	t.Run("keep-multichar-separator", func(t *testing.T) {
+		prcs := []ParsedRelabelConfig{
+			{
+				Action:       "keep",
+				SourceLabels: []string{"foo", "bar"},
+				Separator:    "||",
+				Regex:        regexp.MustCompile("^(?:a;b\\|\\|c)$"),
+			},
+		}
+		f(prcs, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "c",
+			},
+			{
+				Name:  "foo",
+				Value: "a;b",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "c",
+			},
+			{
+				Name:  "foo",
+				Value: "a;b",
+			},
+		})
+		f(prcs, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "b||c",
+			},
+			{
+				Name:  "foo",
+				Value: "a",
+			},
+		}, false, []prompbmarshal.Label{})
+	})
 	t.Run("hashmod-miss", func(t *testing.T) {
 		f([]ParsedRelabelConfig{
 			{
@@ -550,6 +687,220 @@ func TestApplyRelabelConfigs(t *testing.T) {
 			},
 		})
 	})
+	t.Run("transform-truncate", func(t *testing.T) {
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"pod", "ns"},
+				Separator:    ";",
+				TargetLabel:  "pod_prefix",
+				transform:    mustParseTransformFunc("truncate(6)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "pod",
+				Value: "vmagent-7f9c",
+			},
+			{
+				Name:  "ns",
+				Value: "monitoring",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "ns",
+				Value: "monitoring",
+			},
+			{
+				Name:  "pod",
+				Value: "vmagent-7f9c",
+			},
+			{
+				Name:  "pod_prefix",
+				Value: "vmagen",
+			},
+		})
+		// Short values are left as is.
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"pod"},
+				TargetLabel:  "pod_prefix",
+				transform:    mustParseTransformFunc("truncate(100)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "pod",
+				Value: "абв",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "pod",
+				Value: "абв",
+			},
+			{
+				Name:  "pod_prefix",
+				Value: "абв",
+			},
+		})
+		// Multi-byte chars are counted as a single char.
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"pod"},
+				TargetLabel:  "pod",
+				transform:    mustParseTransformFunc("truncate(2)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "pod",
+				Value: "абв",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "pod",
+				Value: "аб",
+			},
+		})
+	})
+	t.Run("transform-split", func(t *testing.T) {
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"__address__"},
+				TargetLabel:  "zone",
+				transform:    mustParseTransformFunc("split(.,1)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "__address__",
+				Value: "node1.us-east1.example.com:9100",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "__address__",
+				Value: "node1.us-east1.example.com:9100",
+			},
+			{
+				Name:  "zone",
+				Value: "us-east1",
+			},
+		})
+		// The separator may contain commas.
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"foo"},
+				TargetLabel:  "bar",
+				transform:    mustParseTransformFunc("split(,,,2)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "foo",
+				Value: "a,,b,,c",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "c",
+			},
+			{
+				Name:  "foo",
+				Value: "a,,b,,c",
+			},
+		})
+		// The target label isn't changed if there is no part with the given index.
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"foo"},
+				TargetLabel:  "bar",
+				transform:    mustParseTransformFunc("split(.,3)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "foo",
+				Value: "a.b",
+			},
+			{
+				Name:  "bar",
+				Value: "old",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "bar",
+				Value: "old",
+			},
+			{
+				Name:  "foo",
+				Value: "a.b",
+			},
+		})
+	})
+	t.Run("transform-cidr", func(t *testing.T) {
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"ip"},
+				TargetLabel:  "subnet",
+				transform:    mustParseTransformFunc("cidr(24)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "ip",
+				Value: "10.1.2.3",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "ip",
+				Value: "10.1.2.3",
+			},
+			{
+				Name:  "subnet",
+				Value: "10.1.2.0/24",
+			},
+		})
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"ip"},
+				TargetLabel:  "subnet",
+				transform:    mustParseTransformFunc("cidr(64)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "ip",
+				Value: "2001:db8:1:2:3::4",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "ip",
+				Value: "2001:db8:1:2:3::4",
+			},
+			{
+				Name:  "subnet",
+				Value: "2001:db8:1:2::/64",
+			},
+		})
+		// Non-IP values are ignored.
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "transform",
+				SourceLabels: []string{"ip"},
+				TargetLabel:  "subnet",
+				transform:    mustParseTransformFunc("cidr(24)"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "ip",
+				Value: "foo",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "ip",
+				Value: "foo",
+			},
+		})
+	})
 	t.Run("labelmap", func(t *testing.T) {
 		f([]ParsedRelabelConfig{
 			{
@@ -654,6 +1005,169 @@ func TestApplyRelabelConfigs(t *testing.T) {
 			},
 		})
 	})
+	t.Run("labeldrop_by_value", func(t *testing.T) {
+		// Matching value - the label must be dropped
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "labeldrop_by_value",
+				SourceLabels: []string{"pod_template_hash"},
+				Regex:        regexp.MustCompile("^(?:[0-9a-f]{8,10})$"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+			{
+				Name:  "pod_template_hash",
+				Value: "5d8f7c6b9a",
+			},
+			{
+				Name:  "foo",
+				Value: "bar",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "foo",
+				Value: "bar",
+			},
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+		})
+		// Non-matching value - the label must be kept
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "labeldrop_by_value",
+				SourceLabels: []string{"pod_template_hash"},
+				Regex:        regexp.MustCompile("^(?:[0-9a-f]{8,10})$"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+			{
+				Name:  "pod_template_hash",
+				Value: "canary",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "pod_template_hash",
+				Value: "canary",
+			},
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+		})
+		// Missing label
+		f([]ParsedRelabelConfig{
+			{
+				Action:       "labeldrop_by_value",
+				SourceLabels: []string{"pod_template_hash"},
+				Regex:        regexp.MustCompile("^(?:.*)$"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "xxx",
+				Value: "yyy",
+			},
+		})
+	})
+	t.Run("sanitize_label_name", func(t *testing.T) {
+		f([]ParsedRelabelConfig{
+			{
+				Action: "sanitize_label_name",
+				Regex:  regexp.MustCompile("^(?:__meta_.+)$"),
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "__meta_kubernetes_pod_label_app.kubernetes.io/name",
+				Value: "foo",
+			},
+			{
+				Name:  "__meta_consul_tag-prod",
+				Value: "bar",
+			},
+			{
+				Name:  "xxx.yyy",
+				Value: "zzz",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "__meta_consul_tag_prod",
+				Value: "bar",
+			},
+			{
+				Name:  "__meta_kubernetes_pod_label_app_kubernetes_io_name",
+				Value: "foo",
+			},
+			{
+				Name:  "xxx.yyy",
+				Value: "zzz",
+			},
+		})
+		f([]ParsedRelabelConfig{
+			{
+				Action: "sanitize_label_name",
+				Regex:  defaultRegexForRelabelConfig,
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "1foo.bar",
+				Value: "baz",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "_1foo_bar",
+				Value: "baz",
+			},
+		})
+		// Label names colliding after sanitizing
+		f([]ParsedRelabelConfig{
+			{
+				Action: "sanitize_label_name",
+				Regex:  defaultRegexForRelabelConfig,
+			},
+		}, []prompbmarshal.Label{
+			{
+				Name:  "a.b",
+				Value: "1",
+			},
+			{
+				Name:  "z",
+				Value: "3",
+			},
+			{
+				Name:  "a-b",
+				Value: "2",
+			},
+			{
+				Name:  "b.c",
+				Value: "4",
+			},
+		}, false, []prompbmarshal.Label{
+			{
+				Name:  "a_b",
+				Value: "2",
+			},
+			{
+				Name:  "b_c",
+				Value: "4",
+			},
+			{
+				Name:  "z",
+				Value: "3",
+			},
+		})
+	})
 	t.Run("labelkeep", func(t *testing.T) {
 		f([]ParsedRelabelConfig{
 			{
@@ -805,3 +1319,11 @@ func TestRemoveMetaLabels(t *testing.T) {
 		},
 	})
 }
+
+func mustParseTransformFunc(s string) *transformFunc {
+	tf, err := parseTransformFunc(s)
+	if err != nil {
+		panic(fmt.Errorf("BUG: cannot parse %q: %w", s, err))
+	}
+	return tf
+}
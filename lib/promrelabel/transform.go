@@ -0,0 +1,123 @@
+package promrelabel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// transformFunc is a built-in function for `action: transform`.
+type transformFunc struct {
+	// name is one of truncate, cidr or split.
+	name string
+
+	// n is the number of chars to leave for truncate, the number of prefix bits for cidr and the index of the part for split.
+	n int
+
+	// sep is the separator for split.
+	sep string
+}
+
+// parseTransformFunc parses s in the form `truncate(n)`, `cidr(bits)` or `split(sep,index)`.
+func parseTransformFunc(s string) (*transformFunc, error) {
+	n := strings.IndexByte(s, '(')
+	if n <= 0 || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("`function` %q must be in the form `name(args)`", s)
+	}
+	name := s[:n]
+	args := s[n+1 : len(s)-1]
+	switch name {
+	case "truncate":
+		n, err := strconv.Atoi(args)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("the arg for `truncate(n)` must be positive integer; got %q", args)
+		}
+		return &transformFunc{
+			name: name,
+			n:    n,
+		}, nil
+	case "cidr":
+		bits, err := strconv.Atoi(args)
+		if err != nil || bits < 0 || bits > 128 {
+			return nil, fmt.Errorf("the arg for `cidr(bits)` must be integer in the range [0..128]; got %q", args)
+		}
+		return &transformFunc{
+			name: name,
+			n:    bits,
+		}, nil
+	case "split":
+		// The separator may contain commas, so the index is searched after the last comma.
+		n := strings.LastIndexByte(args, ',')
+		if n <= 0 {
+			return nil, fmt.Errorf("`split(sep,index)` must contain non-empty separator and index; got %q", args)
+		}
+		index, err := strconv.Atoi(args[n+1:])
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("the index for `split(sep,index)` must be non-negative integer; got %q", args[n+1:])
+		}
+		return &transformFunc{
+			name: name,
+			n:    index,
+			sep:  args[:n],
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown `function` %q; supported functions: truncate(n), cidr(bits), split(sep,index)", name)
+	}
+}
+
+// apply returns the result of tf applied to s.
+//
+// false is returned if s cannot be transformed, e.g. if it isn't an IP address for cidr or it has not enough parts for split.
+func (tf *transformFunc) apply(s string) (string, bool) {
+	switch tf.name {
+	case "truncate":
+		n := 0
+		for i := range s {
+			if n == tf.n {
+				return s[:i], true
+			}
+			n++
+		}
+		return s, true
+	case "cidr":
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return "", false
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		if tf.n > bits {
+			return "", false
+		}
+		ipNet := net.IPNet{
+			IP:   ip.Mask(net.CIDRMask(tf.n, bits)),
+			Mask: net.CIDRMask(tf.n, bits),
+		}
+		return ipNet.String(), true
+	case "split":
+		parts := strings.Split(s, tf.sep)
+		if tf.n >= len(parts) {
+			return "", false
+		}
+		return parts[tf.n], true
+	default:
+		return "", false
+	}
+}
+
+// String returns string representation for tf.
+func (tf *transformFunc) String() string {
+	if tf == nil {
+		return ""
+	}
+	switch tf.name {
+	case "split":
+		return fmt.Sprintf("split(%s,%d)", tf.sep, tf.n)
+	default:
+		return fmt.Sprintf("%s(%d)", tf.name, tf.n)
+	}
+}
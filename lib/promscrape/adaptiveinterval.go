@@ -0,0 +1,73 @@
+package promscrape
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// scrapeIntervalHint holds the value of ScrapeWork.IntervalHeader from the last response of the target.
+//
+// It is shared among all the clients of the target and it must be used only by the goroutine performing the scrapes.
+type scrapeIntervalHint struct {
+	header string
+	value  string
+}
+
+// newScrapeIntervalHint returns scrapeIntervalHint for sw. nil is returned if sw.IntervalHeader isn't set.
+func newScrapeIntervalHint(sw *ScrapeWork) *scrapeIntervalHint {
+	if sw.IntervalHeader == "" {
+		return nil
+	}
+	return &scrapeIntervalHint{
+		header: sw.IntervalHeader,
+	}
+}
+
+// parseScrapeIntervalHint parses scrape interval from s.
+//
+// s may contain duration such as `30s` or `1m30s` or the number of seconds such as `30` or `0.5`.
+func parseScrapeIntervalHint(s string) (time.Duration, bool) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		d := time.Duration(f * float64(time.Second))
+		return d, d > 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, false
+	}
+	return d, d > 0
+}
+
+// getAdaptiveInterval returns the interval for the next scrape according to Config.IntervalHeader value from the last response.
+//
+// The returned interval is limited by Config.MinInterval and Config.MaxInterval.
+// The current interval is returned if the last response has no header or if the header value is malformed.
+func (sw *scrapeWork) getAdaptiveInterval(current time.Duration) time.Duration {
+	hint := sw.intervalHint
+	if hint == nil || hint.value == "" {
+		return current
+	}
+	d, ok := parseScrapeIntervalHint(hint.value)
+	hint.value = ""
+	if !ok {
+		scrapeIntervalHintErrors.Inc()
+		return current
+	}
+	if d < sw.Config.MinInterval {
+		d = sw.Config.MinInterval
+	}
+	if d > sw.Config.MaxInterval {
+		d = sw.Config.MaxInterval
+	}
+	if d != current {
+		scrapeIntervalAdjustments.Inc()
+	}
+	return d
+}
+
+var (
+	scrapeIntervalHintErrors  = metrics.NewCounter(`vm_promscrape_scrape_interval_header_errors_total`)
+	scrapeIntervalAdjustments = metrics.NewCounter(`vm_promscrape_scrape_interval_adjustments_total`)
+)
@@ -0,0 +1,117 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestGetAdaptiveInterval(t *testing.T) {
+	var sw scrapeWork
+	sw.Config = ScrapeWork{
+		IntervalHeader: "X-Prometheus-Scrape-Interval",
+		MinInterval:    10 * time.Second,
+		MaxInterval:    time.Minute,
+	}
+	sw.intervalHint = newScrapeIntervalHint(&sw.Config)
+	f := func(value string, current, resultExpected time.Duration) {
+		t.Helper()
+		sw.intervalHint.value = value
+		result := sw.getAdaptiveInterval(current)
+		if result != resultExpected {
+			t.Fatalf("unexpected interval for header value %q; got %s; want %s", value, result, resultExpected)
+		}
+	}
+
+	// The interval from the header within bounds.
+	f("30s", 15*time.Second, 30*time.Second)
+	f("30", 15*time.Second, 30*time.Second)
+	f("1m", 15*time.Second, time.Minute)
+
+	// The interval is limited by bounds.
+	f("5s", 15*time.Second, 10*time.Second)
+	f("0.5", 15*time.Second, 10*time.Second)
+	f("1h", 15*time.Second, time.Minute)
+
+	// Missing and malformed header values are ignored.
+	f("", 15*time.Second, 15*time.Second)
+	f("foo", 15*time.Second, 15*time.Second)
+	f("-30s", 15*time.Second, 15*time.Second)
+	f("0", 15*time.Second, 15*time.Second)
+}
+
+func TestScrapeWorkAdaptiveInterval(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Prometheus-Scrape-Interval", "30s")
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+
+	// The advertised 30s interval is limited by max_scrape_interval, so the test doesn't take long.
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: adaptive
+  scrape_interval: 50ms
+  scrape_timeout: 40ms
+  scrape_interval_header: X-Prometheus-Scrape-Interval
+  min_scrape_interval: 100ms
+  max_scrape_interval: 300ms
+  static_configs:
+  - targets: [%q]
+`, s.Listener.Addr().String())
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	var mu sync.Mutex
+	var scrapeTimes []time.Time
+	pushData := func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "up" {
+				mu.Lock()
+				scrapeTimes = append(scrapeTimes, time.Now())
+				mu.Unlock()
+			}
+		}
+	}
+	sc := newScraper(&sws[0], "test", pushData)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sc.sw.run(sc.stopCh)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(scrapeTimes)
+		mu.Unlock()
+		if n >= 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout while waiting for scrapes; got %d scrapes; want 4", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(sc.stopCh)
+	wg.Wait()
+
+	// Scrapes after the first one must be performed at max_scrape_interval instead of the configured scrape_interval.
+	for i := 1; i < len(scrapeTimes); i++ {
+		d := scrapeTimes[i].Sub(scrapeTimes[i-1])
+		if d < 250*time.Millisecond {
+			t.Fatalf("too small interval between scrapes #%d and #%d; got %s; want at least 250ms", i-1, i, d)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package promscrape
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+)
+
+// responseCharset contains UTF-8 runes for bytes 0x80-0xFF of a single-byte charset.
+//
+// Bytes 0x00-0x7F are the same as in ASCII for all the supported charsets.
+type responseCharset [128]rune
+
+// newLatin1Charset returns ISO-8859-1 charset with the given overrides for bytes 0x80-0xFF.
+func newLatin1Charset(overrides map[byte]rune) *responseCharset {
+	var cs responseCharset
+	for i := range cs {
+		cs[i] = rune(0x80 + i)
+	}
+	for b, r := range overrides {
+		cs[b-0x80] = r
+	}
+	return &cs
+}
+
+var (
+	charsetISO88591 = newLatin1Charset(nil)
+
+	// Undefined bytes 0x81, 0x8D, 0x8F, 0x90 and 0x9D are mapped to the corresponding C1 control chars in the same way as web browsers do.
+	charsetWindows1252 = newLatin1Charset(map[byte]rune{
+		0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡',
+		0x88: 'ˆ', 0x89: '‰', 0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ', 0x8e: 'Ž',
+		0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+		0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›', 0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+	})
+
+	charsetISO885915 = newLatin1Charset(map[byte]rune{
+		0xa4: '€', 0xa6: 'Š', 0xa8: 'š', 0xb4: 'Ž', 0xb8: 'ž', 0xbc: 'Œ', 0xbd: 'œ', 0xbe: 'Ÿ',
+	})
+)
+
+// responseCharsets maps the supported `response_charset` names to charsets. Nil charset means the response is decoded as is.
+var responseCharsets = map[string]*responseCharset{
+	"utf-8":        nil,
+	"utf8":         nil,
+	"iso-8859-1":   charsetISO88591,
+	"latin1":       charsetISO88591,
+	"windows-1252": charsetWindows1252,
+	"cp1252":       charsetWindows1252,
+	"iso-8859-15":  charsetISO885915,
+	"latin9":       charsetISO885915,
+}
+
+// validateResponseCharset verifies whether name is supported `response_charset` value.
+func validateResponseCharset(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := responseCharsets[strings.ToLower(name)]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(responseCharsets))
+	for name := range responseCharsets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unsupported charset %q; supported values: %s", name, strings.Join(names, ", "))
+}
+
+// getResponseCharset returns the charset for `response_charset` with the given name. Nil is returned if the response mustn't be transcoded.
+//
+// The name must be verified with validateResponseCharset.
+func getResponseCharset(name string) *responseCharset {
+	return responseCharsets[strings.ToLower(name)]
+}
+
+// decode transcodes data from cs to UTF-8. The result is stored in data.
+func (cs *responseCharset) decode(data []byte) []byte {
+	if cs == nil {
+		return data
+	}
+	if isASCII(data) {
+		// Fast path - ASCII data is the same in UTF-8.
+		return data
+	}
+	bb := charsetBufPool.Get()
+	var buf [utf8.UTFMax]byte
+	for _, b := range data {
+		if b < 0x80 {
+			bb.B = append(bb.B, b)
+			continue
+		}
+		n := utf8.EncodeRune(buf[:], cs[b-0x80])
+		bb.B = append(bb.B, buf[:n]...)
+	}
+	data = append(data[:0], bb.B...)
+	charsetBufPool.Put(bb)
+	return data
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+var charsetBufPool bytesutil.ByteBufferPool
@@ -0,0 +1,96 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestResponseCharsetDecode(t *testing.T) {
+	f := func(name, data, resultExpected string) {
+		t.Helper()
+		if err := validateResponseCharset(name); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := getResponseCharset(name).decode([]byte(data))
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for charset %q; got %q; want %q", name, result, resultExpected)
+		}
+	}
+	f("", "M\xfcnchen", "M\xfcnchen")
+	f("UTF-8", "München", "München")
+	f("ISO-8859-1", "foo", "foo")
+	f("ISO-8859-1", "M\xfcnchen \xa4", "München ¤")
+	f("latin1", "Jos\xe9", "José")
+	f("windows-1252", "\x80 \x93Jos\xe9\x94 \x81", "€ “José” \u0081")
+	f("iso-8859-15", "\xa4 \xbd", "€ œ")
+
+	// Unsupported charset
+	if err := validateResponseCharset("koi8-r"); err == nil {
+		t.Fatalf("expecting non-nil error for unsupported charset")
+	}
+}
+
+func TestScrapeWorkResponseCharset(t *testing.T) {
+	var mu sync.Mutex
+	var acceptCharset string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		acceptCharset = r.Header.Get("Accept-Charset")
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; charset=iso-8859-1")
+		// The response body is in Latin-1.
+		w.Write([]byte("foo{city=\"M\xfcnchen\",name=\"Jos\xe9\"} 1\n"))
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: latin1
+  response_charset: ISO-8859-1
+  static_configs:
+  - targets: [%q]
+`, u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	defer tsmGlobal.Unregister(&sws[0])
+	var labels []prompbmarshal.Label
+	sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			for _, label := range ts.Labels {
+				if label.Name == "__name__" && label.Value == "foo" {
+					labels = append([]prompbmarshal.Label{}, ts.Labels...)
+				}
+			}
+		}
+	})
+	timestamp := time.Now().UnixNano() / 1e6
+	if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	labelsExpected := fmt.Sprintf(`{__name__="foo",city="München",instance=%q,job="latin1",name="José"}`, u.Host)
+	if s := promLabelsString(labels); s != labelsExpected {
+		t.Fatalf("unexpected labels; got %s; want %s", s, labelsExpected)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if acceptCharset != "ISO-8859-1" {
+		t.Fatalf("unexpected Accept-Charset header; got %q; want %q", acceptCharset, "ISO-8859-1")
+	}
+}
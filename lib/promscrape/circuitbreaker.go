@@ -0,0 +1,100 @@
+package promscrape
+
+import (
+	"errors"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Circuit breaker states shown in target status.
+const (
+	circuitBreakerClosed   = "closed"
+	circuitBreakerOpen     = "open"
+	circuitBreakerHalfOpen = "half-open"
+)
+
+// errCircuitBreakerOpen is returned instead of performing the scrape request while the circuit breaker for the target is open.
+var errCircuitBreakerOpen = errors.New("the scrape is skipped, since the circuit breaker is open because of consecutive connection failures")
+
+// circuitBreaker stops scraping the target after the given number of consecutive connection failures.
+//
+// The breaker stays open for the cooldown duration, then it becomes half-open and allows a single probe scrape.
+// The breaker is closed if the probe succeeds, otherwise it is opened again for the cooldown duration.
+//
+// circuitBreaker isn't safe for concurrent use, since it must be used only by the goroutine performing the scrapes.
+// nil circuitBreaker allows all the scrapes.
+type circuitBreaker struct {
+	maxFailures int
+	cooldown    time.Duration
+
+	state string
+
+	// failures contains the number of consecutive connection failures.
+	failures int
+
+	// openDeadline is the timestamp in milliseconds when the open breaker becomes half-open.
+	openDeadline int64
+}
+
+func newCircuitBreaker(maxFailures int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+		state:       circuitBreakerClosed,
+	}
+}
+
+// allow returns true if the scrape request may be performed at the given timestamp in milliseconds.
+func (cb *circuitBreaker) allow(timestamp int64) bool {
+	if cb == nil {
+		return true
+	}
+	if cb.state == circuitBreakerOpen {
+		if timestamp < cb.openDeadline {
+			return false
+		}
+		cb.state = circuitBreakerHalfOpen
+	}
+	return true
+}
+
+// registerResult must be called with the result of the scrape request allowed by allow.
+//
+// Only connection-level errors are counted as failures, since other errors mean the target is reachable.
+func (cb *circuitBreaker) registerResult(err error, timestamp int64) {
+	if cb == nil {
+		return
+	}
+	if err == nil || !isConnectionError(err) {
+		cb.state = circuitBreakerClosed
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.state == circuitBreakerHalfOpen || cb.failures >= cb.maxFailures {
+		cb.state = circuitBreakerOpen
+		cb.openDeadline = timestamp + cb.cooldown.Milliseconds()
+		circuitBreakerOpens.Inc()
+	}
+}
+
+// getState returns the current state for cb. An empty string is returned for nil cb.
+func (cb *circuitBreaker) getState() string {
+	if cb == nil {
+		return ""
+	}
+	return cb.state
+}
+
+// isConnectionError returns true if err means the scrape target cannot be reached.
+func isConnectionError(err error) bool {
+	switch getScrapeErrorReason(err) {
+	case scrapeErrorReasonDNS, scrapeErrorReasonConnect, scrapeErrorReasonTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+var circuitBreakerOpens = metrics.NewCounter(`vm_promscrape_circuit_breaker_opens_total`)
@@ -0,0 +1,139 @@
+package promscrape
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkCircuitBreaker(t *testing.T) {
+	labels := []prompbmarshal.Label{
+		{
+			Name:  "instance",
+			Value: "foo.bar:1234",
+		},
+		{
+			Name:  "job",
+			Value: "test_circuit_breaker",
+		},
+	}
+	var sw scrapeWork
+	sw.Config = ScrapeWork{
+		ID:              atomic.AddUint64(&nextScrapeWorkID, 1),
+		ScrapeURL:       "http://foo.bar:1234/metrics",
+		ScrapeInterval:  time.Second,
+		Labels:          labels,
+		BreakerFailures: 3,
+		BreakerCooldown: 10 * time.Second,
+	}
+	tsmGlobal.Register(&sw.Config)
+	defer tsmGlobal.Unregister(&sw.Config)
+
+	var readErr error
+	readCalls := 0
+	sw.ReadData = func(dst []byte) ([]byte, error) {
+		readCalls++
+		if readErr != nil {
+			return dst, readErr
+		}
+		return append(dst, "foo 1\n"...), nil
+	}
+	up := float64(-1)
+	sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "up" {
+				up = ts.Samples[0].Value
+			}
+		}
+	}
+	connErr := fmt.Errorf("cannot connect: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED})
+
+	timestamp := int64(1600000000000)
+	f := func(upExpected float64, readCallsExpected int, stateExpected string) {
+		t.Helper()
+		up = -1
+		_ = sw.scrapeInternal(timestamp, timestamp)
+		if up != upExpected {
+			t.Fatalf("unexpected up; got %v; want %v", up, upExpected)
+		}
+		if readCalls != readCallsExpected {
+			t.Fatalf("unexpected number of scrape requests; got %d; want %d", readCalls, readCallsExpected)
+		}
+		ts, ok := TargetStatusByLabels(labels)
+		if !ok {
+			t.Fatalf("cannot find target status")
+		}
+		if ts.CircuitBreakerState != stateExpected {
+			t.Fatalf("unexpected circuit breaker state; got %q; want %q", ts.CircuitBreakerState, stateExpected)
+		}
+		timestamp += 1000
+	}
+
+	// The breaker stays closed on successful scrapes and until the given number of consecutive connection failures.
+	f(1, 1, circuitBreakerClosed)
+	readErr = connErr
+	f(0, 2, circuitBreakerClosed)
+	f(0, 3, circuitBreakerClosed)
+
+	// The breaker opens after 3 consecutive connection failures.
+	f(0, 4, circuitBreakerOpen)
+
+	// Scrape requests aren't performed during the cooldown, while up=0 is still pushed.
+	for i := 0; i < 9; i++ {
+		f(0, 4, circuitBreakerOpen)
+	}
+
+	// The failed probe after the cooldown opens the breaker again.
+	f(0, 5, circuitBreakerOpen)
+	for i := 0; i < 9; i++ {
+		f(0, 5, circuitBreakerOpen)
+	}
+
+	// The successful probe closes the breaker.
+	readErr = nil
+	f(1, 6, circuitBreakerClosed)
+	f(1, 7, circuitBreakerClosed)
+
+	// Non-connection errors do not open the breaker, since the target is reachable.
+	readErr = &statusCodeError{statusCode: 500}
+	for i := 0; i < 5; i++ {
+		f(0, 8+i, circuitBreakerClosed)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cb := newCircuitBreaker(1, time.Second)
+	connErr := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	if !cb.allow(1000) {
+		t.Fatalf("closed breaker must allow scrapes")
+	}
+	cb.registerResult(connErr, 1000)
+	if cb.getState() != circuitBreakerOpen {
+		t.Fatalf("unexpected state; got %q; want %q", cb.getState(), circuitBreakerOpen)
+	}
+	if cb.allow(1999) {
+		t.Fatalf("open breaker mustn't allow scrapes during the cooldown")
+	}
+	if !cb.allow(2000) {
+		t.Fatalf("breaker must allow the probe scrape after the cooldown")
+	}
+	if cb.getState() != circuitBreakerHalfOpen {
+		t.Fatalf("unexpected state; got %q; want %q", cb.getState(), circuitBreakerHalfOpen)
+	}
+
+	// nil breaker allows all the scrapes.
+	var cbNil *circuitBreaker
+	if !cbNil.allow(0) {
+		t.Fatalf("nil breaker must allow scrapes")
+	}
+	cbNil.registerResult(connErr, 0)
+	if cbNil.getState() != "" {
+		t.Fatalf("unexpected state for nil breaker: %q", cbNil.getState())
+	}
+}
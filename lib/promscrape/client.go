@@ -1,33 +1,47 @@
 package promscrape
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
 	"github.com/VictoriaMetrics/fasthttp"
 	"github.com/VictoriaMetrics/metrics"
+	kzstd "github.com/klauspost/compress/zstd"
 )
 
 var (
 	maxScrapeSize = flagutil.NewBytes("promscrape.maxScrapeSize", 16*1024*1024, "The maximum size of scrape response in bytes to process from Prometheus targets. "+
 		"Bigger responses are rejected")
-	disableCompression = flag.Bool("promscrape.disableCompression", false, "Whether to disable sending 'Accept-Encoding: gzip' request headers to all the scrape targets. "+
+	acceptEncoding = flag.String("promscrape.acceptEncoding", "zstd, gzip", "The value for 'Accept-Encoding' request header to send to scrape targets. "+
+		"Responses with 'Content-Encoding: zstd' and 'Content-Encoding: gzip' are decompressed, while uncompressed responses are processed as is. "+
+		"See also -promscrape.disableCompression")
+	disableCompression = flag.Bool("promscrape.disableCompression", false, "Whether to disable sending 'Accept-Encoding' request headers to all the scrape targets. "+
 		"This may reduce CPU usage on scrape targets at the cost of higher network bandwidth utilization. "+
 		"It is possible to set 'disable_compression: true' individually per each 'scrape_config' section in '-promscrape.config' for fine grained control")
 	disableKeepAlive = flag.Bool("promscrape.disableKeepAlive", false, "Whether to disable HTTP keep-alive connections when scraping all the targets. "+
 		"This may be useful when targets has no support for HTTP keep-alive connection. "+
 		"It is possible to set `disable_keepalive: true` individually per each 'scrape_config` section in '-promscrape.config' for fine grained control. "+
 		"Note that disabling HTTP keep-alive may increase load on both vmagent and scrape targets")
+	maxDecompressedSize = flagutil.NewBytes("promscrape.maxDecompressedSize", 0, "The maximum size of decompressed gzip or zstd response in bytes from Prometheus targets. "+
+		"Bigger responses are rejected. By default the limit is 10x of -promscrape.maxScrapeSize, so compressed responses, which fit -promscrape.maxScrapeSize "+
+		"when uncompressed, are processed as usual. It is possible to set `max_decompressed_size` individually per each `scrape_config` section in `-promscrape.config`")
 	streamParse = flag.Bool("promscrape.streamParse", false, "Whether to enable stream parsing for metrics obtained from scrape targets. This may be useful "+
 		"for reducing memory usage when millions of metrics are exposed per each scrape target. "+
 		"It is posible to set `stream_parse: true` individually per each `scrape_config` section in `-promscrape.config` for fine grained control")
@@ -41,19 +55,87 @@ type client struct {
 	// It may be useful for scraping targets with millions of metrics per target.
 	sc *http.Client
 
+	// useStreamClient is set if sc must be used instead of hc for all the scrapes.
+	useStreamClient bool
+
+	// phaseTimings is used for registering timings for scrape phases made via sc if -promscrape.traceTimings is set.
+	phaseTimings *scrapePhaseTimings
+
 	scrapeURL          string
+	filePath           string
+	objectStore        *objectStoreClient
 	host               string
 	requestURI         string
+	additionalURLs     []additionalURL
 	authHeader         string
+	scrapeTimeout      time.Duration
+	scrapeRetries      int
+	acceptHeader       string
+	acceptCharset      string
 	disableCompression bool
 	disableKeepAlive   bool
+
+	// maxDecompressedSize limits the size of decompressed gzip and zstd responses. See getMaxDecompressedSize.
+	maxDecompressedSize int
+
+	// compressionRatio checks the compression ratio for gzipped responses. See -promscrape.compressionRatioWarnThreshold.
+	compressionRatio compressionRatioChecker
+
+	// method, body and contentType are used for sending scrape requests. body and contentType are set only for POST requests.
+	method      string
+	body        string
+	contentType string
+
+	// conditionalScrape enables sending `If-None-Match` and `If-Modified-Since` headers to scrapeURL
+	// according to etag and lastModified from the previous successful response.
+	conditionalScrape bool
+	etag              string
+	lastModified      string
+
+	// checkContentType enables failing scrapes for responses with unexpected Content-Type and body. See ScrapeWork.CheckContentType.
+	checkContentType bool
+
+	// intervalHint receives the value of ScrapeWork.IntervalHeader from responses. It is nil if the header isn't set.
+	intervalHint *scrapeIntervalHint
+
+	// login obtains session cookie for scrape requests. It is nil if ScrapeWork.Login isn't set.
+	login *sessionLogin
+
+	// cloudflareAccess contains credentials for Cloudflare Access. It is nil if ScrapeWork.CloudflareAccess isn't set.
+	cloudflareAccess *CloudflareAccessConfig
+
+	// redirects limits redirects followed by hc. It is nil if neither ScrapeWork.MaxRedirects nor ScrapeWork.RedirectHosts is set.
+	redirects *redirectPolicy
+
+	// redirectClient follows redirects to hosts other than the scrape target, since hc sends requests only to the scrape target.
+	redirectClient *fasthttp.Client
 }
 
 func newClient(sw *ScrapeWork) *client {
+	if strings.HasPrefix(sw.ScrapeURL, "file://") {
+		// Metrics are read from the local file instead of http target.
+		return &client{
+			scrapeURL: sw.ScrapeURL,
+			filePath:  strings.TrimPrefix(sw.ScrapeURL, "file://"),
+		}
+	}
+	if isKafkaTarget(sw.ScrapeURL) {
+		// Metrics are consumed from Kafka topic by runKafkaConsumer, which substitutes ReadData for such targets.
+		return &client{
+			scrapeURL: sw.ScrapeURL,
+		}
+	}
+	if isObjectStoreTarget(sw.ScrapeURL) {
+		// Metrics are read from the newest object in the object storage instead of http target.
+		return &client{
+			scrapeURL:   sw.ScrapeURL,
+			objectStore: newObjectStoreClient(sw),
+		}
+	}
 	var u fasthttp.URI
 	u.Update(sw.ScrapeURL)
 	host := string(u.Host())
-	requestURI := string(u.RequestURI())
+	requestURI := getRequestURI(&u)
 	isTLS := string(u.Scheme()) == "https"
 	var tlsCfg *tls.Config
 	if isTLS {
@@ -66,20 +148,48 @@ func newClient(sw *ScrapeWork) *client {
 			host += ":443"
 		}
 	}
+	// IPv6 zone identifier is escaped in the scrape url according to RFC 6874.
+	// It must be unescaped for dialing and it mustn't be sent in the Host header.
+	dialAddr := strings.Replace(host, "%25", "%", 1)
+	host = removeIPv6Zone(host)
+	requestTimeout := sw.ScrapeTimeout - sw.ScrapeTimeoutOffset
+	dialFunc := statDial
+	if sw.ProxyURL != nil {
+		dialFunc = sw.ProxyURL.NewDialFunc(statStdDial, requestTimeout)
+	}
+	dialContextFunc := sw.ProxyURL.NewDialContextFunc(statStdDial)
+	if sw.SSHTunnel != nil {
+		dialContextFunc = newSSHTunnelDialContextFunc(sw.SSHTunnel)
+		dialFunc = func(addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			defer cancel()
+			return dialContextFunc(ctx, "tcp", addr)
+		}
+	}
 	hc := &fasthttp.HostClient{
-		Addr:                         host,
+		Addr:                         dialAddr,
 		Name:                         "vm_promscrape",
-		Dial:                         statDial,
+		Dial:                         dialFunc,
 		IsTLS:                        isTLS,
 		TLSConfig:                    tlsCfg,
 		MaxIdleConnDuration:          2 * sw.ScrapeInterval,
-		ReadTimeout:                  sw.ScrapeTimeout,
+		ReadTimeout:                  requestTimeout,
+		WriteTimeout:                 10 * time.Second,
+		MaxResponseBodySize:          maxScrapeSize.N,
+		MaxIdempotentRequestAttempts: 1,
+	}
+	redirectClient := &fasthttp.Client{
+		Name:                         "vm_promscrape",
+		Dial:                         dialFunc,
+		TLSConfig:                    tlsCfg,
+		MaxIdleConnDuration:          2 * sw.ScrapeInterval,
+		ReadTimeout:                  requestTimeout,
 		WriteTimeout:                 10 * time.Second,
 		MaxResponseBodySize:          maxScrapeSize.N,
 		MaxIdempotentRequestAttempts: 1,
 	}
 	var sc *http.Client
-	if *streamParse || sw.StreamParse {
+	if *streamParse || sw.StreamParse || *traceTimings {
 		sc = &http.Client{
 			Transport: &http.Transport{
 				TLSClientConfig:     tlsCfg,
@@ -87,74 +197,784 @@ func newClient(sw *ScrapeWork) *client {
 				IdleConnTimeout:     2 * sw.ScrapeInterval,
 				DisableCompression:  *disableCompression || sw.DisableCompression,
 				DisableKeepAlives:   *disableKeepAlive || sw.DisableKeepAlive,
-				DialContext:         statStdDial,
+				DialContext:         dialContextFunc,
 			},
-			Timeout: sw.ScrapeTimeout,
+			Timeout: requestTimeout,
 		}
 	}
+	redirects := newRedirectPolicy(sw, host)
+	if sc != nil {
+		sc.CheckRedirect = redirects.newCheckRedirectFunc()
+	}
+	var login *sessionLogin
+	if sw.Login != nil {
+		transport := &http.Transport{
+			TLSClientConfig:     tlsCfg,
+			TLSHandshakeTimeout: 10 * time.Second,
+			DialContext:         dialContextFunc,
+		}
+		login = newSessionLogin(sw.Login, sw.ScrapeURL, sw.AuthConfig.Authorization, transport, requestTimeout)
+	}
+	var additionalURLs []additionalURL
+	for _, scrapeURL := range sw.AdditionalScrapeURLs {
+		var u fasthttp.URI
+		u.Update(scrapeURL)
+		additionalURLs = append(additionalURLs, additionalURL{
+			scrapeURL:  scrapeURL,
+			requestURI: getRequestURI(&u),
+		})
+	}
 	return &client{
-		hc: hc,
-		sc: sc,
+		hc:              hc,
+		sc:              sc,
+		useStreamClient: *traceTimings,
 
 		scrapeURL:          sw.ScrapeURL,
 		host:               host,
 		requestURI:         requestURI,
+		additionalURLs:     additionalURLs,
 		authHeader:         sw.AuthConfig.Authorization,
+		scrapeTimeout:      requestTimeout,
+		scrapeRetries:      sw.ScrapeRetries,
+		acceptHeader:       getClientAcceptHeader(sw),
+		acceptCharset:      sw.ResponseCharset,
 		disableCompression: sw.DisableCompression,
 		disableKeepAlive:   sw.DisableKeepAlive,
+		method:             sw.Method,
+		body:               sw.Body,
+		contentType:        sw.ContentType,
+		conditionalScrape:  sw.ConditionalScrape,
+		checkContentType:   sw.CheckContentType && sw.ExpositionFormat != expositionFormatRemoteWrite,
+
+		maxDecompressedSize: getMaxDecompressedSize(sw),
+		login:               login,
+		cloudflareAccess:    sw.CloudflareAccess,
+		redirects:           redirects,
+		redirectClient:      redirectClient,
 	}
 }
 
+// The following `Accept` header has been copied from Prometheus sources.
+// See https://github.com/prometheus/prometheus/blob/f9d21f10ecd2a343a381044f131ea4e46381ce09/scrape/scrape.go#L532 .
+// This is needed as a workaround for scraping stupid Java-based servers such as Spring Boot.
+// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/608 for details.
+// Do not bloat the `Accept` header with OpenMetrics shit, since it looks like dead standard now.
+const defaultAcceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// scrapeProtocolHeaders maps the supported `scrape_protocols` names to the corresponding media types.
+//
+// `PrometheusProto` isn't supported, since lib/promscrape can parse only text exposition formats.
+var scrapeProtocolHeaders = map[string]string{
+	"PrometheusText0.0.4":  "text/plain;version=0.0.4",
+	"OpenMetricsText0.0.1": "application/openmetrics-text;version=0.0.1",
+	"OpenMetricsText1.0.0": "application/openmetrics-text;version=1.0.0",
+}
+
+// getClientAcceptHeader returns `Accept` header for scraping sw.
+func getClientAcceptHeader(sw *ScrapeWork) string {
+	if sw.ExpositionFormat == expositionFormatRemoteWrite {
+		return remoteWriteAcceptHeader
+	}
+	return getAcceptHeader(sw.ScrapeProtocols)
+}
+
+// validateScrapeProtocols verifies whether protocols contain only supported unique `scrape_protocols` names.
+func validateScrapeProtocols(protocols []string) error {
+	seen := make(map[string]bool, len(protocols))
+	for _, protocol := range protocols {
+		if _, ok := scrapeProtocolHeaders[protocol]; !ok {
+			if protocol == "PrometheusProto" {
+				return fmt.Errorf("%q protocol isn't supported; supported protocols: PrometheusText0.0.4, OpenMetricsText0.0.1, OpenMetricsText1.0.0", protocol)
+			}
+			return fmt.Errorf("unknown protocol %q; supported protocols: PrometheusText0.0.4, OpenMetricsText0.0.1, OpenMetricsText1.0.0", protocol)
+		}
+		if seen[protocol] {
+			return fmt.Errorf("duplicate protocol %q", protocol)
+		}
+		seen[protocol] = true
+	}
+	return nil
+}
+
+// getAcceptHeader returns `Accept` header value, which lists the given protocols in the order of preference.
+//
+// protocols must be validated with validateScrapeProtocols.
+func getAcceptHeader(protocols []string) string {
+	if len(protocols) == 0 {
+		return defaultAcceptHeader
+	}
+	// Weights are assigned in the same way as Prometheus does.
+	weight := len(scrapeProtocolHeaders) + 1
+	var a []string
+	for _, protocol := range protocols {
+		a = append(a, fmt.Sprintf("%s;q=0.%d", scrapeProtocolHeaders[protocol], weight))
+		weight--
+	}
+	a = append(a, fmt.Sprintf("*/*;q=0.%d", weight))
+	return strings.Join(a, ",")
+}
+
+// checkContentType returns an error if the response with the given contentType cannot be parsed.
+//
+// Both Prometheus text and OpenMetrics text formats are parsed by the same text parser.
+func checkContentType(contentType string) error {
+	if strings.HasPrefix(contentType, "application/vnd.google.protobuf") {
+		return fmt.Errorf("unsupported Content-Type=%q; only text exposition formats are supported", contentType)
+	}
+	return nil
+}
+
+// getRequestURI returns request uri for u.
+//
+// The original path is used instead of u.RequestURI(), since the latter unescapes chars such as `%2F`.
+func getRequestURI(u *fasthttp.URI) string {
+	path := string(u.PathOriginal())
+	if path == "" {
+		path = "/"
+	}
+	if qs := u.QueryString(); len(qs) > 0 {
+		return path + "?" + string(qs)
+	}
+	return path
+}
+
+// removeIPv6Zone removes zone identifier from bracketed IPv6 host such as `[fe80::1%25eth0]:80`.
+func removeIPv6Zone(host string) string {
+	if !strings.HasPrefix(host, "[") {
+		return host
+	}
+	n := strings.IndexByte(host, '%')
+	if n < 0 {
+		return host
+	}
+	m := strings.IndexByte(host[n:], ']')
+	if m < 0 {
+		return host
+	}
+	return host[:n] + host[n+m:]
+}
+
+// additionalURL contains an additional url to scrape for the target. See ScrapeWork.AdditionalScrapeURLs.
+type additionalURL struct {
+	scrapeURL  string
+	requestURI string
+}
+
 func (c *client) GetStreamReader() (*streamReader, error) {
-	deadline := time.Now().Add(c.hc.ReadTimeout)
+	return c.getStreamReaderWithDeadline(time.Time{})
+}
+
+// getStreamReaderWithDeadline returns stream reader for c.scrapeURL. Scrape attempts cannot exceed the given deadline if it is non-zero.
+func (c *client) getStreamReaderWithDeadline(deadline time.Time) (*streamReader, error) {
+	if c.filePath != "" {
+		return getFileStreamReader(c.filePath)
+	}
+	if c.objectStore != nil {
+		return c.objectStore.GetStreamReader()
+	}
+	return c.getStreamReader(c.scrapeURL, deadline)
+}
+
+// GetAdditionalStreamReader returns stream reader for ScrapeWork.AdditionalScrapeURLs[idx].
+func (c *client) GetAdditionalStreamReader(idx int) (*streamReader, error) {
+	return c.getStreamReader(c.additionalURLs[idx].scrapeURL, time.Time{})
+}
+
+func (c *client) getStreamReader(scrapeURL string, scrapeDeadline time.Time) (*streamReader, error) {
+	retryDeadline := c.getRetryDeadline(scrapeDeadline)
+	relogin := false
+	for attempt := 0; ; attempt++ {
+		deadline := getAttemptDeadline(c.hc.ReadTimeout, retryDeadline)
+		sr, statusCode, err := c.getStreamReaderOnce(scrapeURL, deadline)
+		if c.needRelogin(statusCode, &relogin) {
+			continue
+		}
+		if !c.needRetry(attempt, statusCode, err, retryDeadline) {
+			return sr, err
+		}
+		scrapeRetries.Inc()
+	}
+}
+
+func (c *client) getStreamReaderOnce(scrapeURL string, deadline time.Time) (*streamReader, int, error) {
+	cookie, err := c.getLoginCookie(scrapeURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	cfHeaders, err := c.getCloudflareAccessHeaders(scrapeURL)
+	if err != nil {
+		return nil, 0, err
+	}
 	ctx, cancel := context.WithDeadline(context.Background(), deadline)
-	req, err := http.NewRequestWithContext(ctx, "GET", c.scrapeURL, nil)
+	onClose := func() {}
+	if c.phaseTimings != nil {
+		ctx, onClose = c.phaseTimings.withClientTrace(ctx)
+	}
+	method := "GET"
+	var body io.Reader
+	if c.method == "POST" {
+		method = "POST"
+		body = strings.NewReader(c.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, scrapeURL, body)
 	if err != nil {
 		cancel()
-		return nil, fmt.Errorf("cannot create request for %q: %w", c.scrapeURL, err)
-	}
-	// The following `Accept` header has been copied from Prometheus sources.
-	// See https://github.com/prometheus/prometheus/blob/f9d21f10ecd2a343a381044f131ea4e46381ce09/scrape/scrape.go#L532 .
-	// This is needed as a workaround for scraping stupid Java-based servers such as Spring Boot.
-	// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/608 for details.
-	// Do not bloat the `Accept` header with OpenMetrics shit, since it looks like dead standard now.
-	req.Header.Set("Accept", "text/plain;version=0.0.4;q=1,*/*;q=0.1")
+		return nil, 0, fmt.Errorf("cannot create request for %q: %w", scrapeURL, err)
+	}
+	if c.method == "POST" {
+		req.Header.Set("Content-Type", c.contentType)
+	}
+	req.Header.Set("Accept", c.acceptHeader)
+	if c.acceptCharset != "" {
+		req.Header.Set("Accept-Charset", c.acceptCharset)
+	}
+	if !*disableCompression && !c.disableCompression {
+		// The response must be decompressed manually, since net/http transparently decompresses only gzip responses
+		// when Accept-Encoding header isn't set explicitly.
+		req.Header.Set("Accept-Encoding", *acceptEncoding)
+	}
 	if c.authHeader != "" {
 		req.Header.Set("Authorization", c.authHeader)
 	}
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	for _, h := range cfHeaders {
+		req.Header.Set(h.name, h.value)
+	}
 	resp, err := c.sc.Do(req)
 	if err != nil {
 		cancel()
-		return nil, fmt.Errorf("cannot scrape %q: %w", c.scrapeURL, err)
+		return nil, 0, fmt.Errorf("cannot scrape %q: %w", scrapeURL, err)
+	}
+	if c.intervalHint != nil && scrapeURL == c.scrapeURL {
+		c.intervalHint.value = resp.Header.Get(c.intervalHint.header)
 	}
 	if resp.StatusCode != http.StatusOK {
 		metrics.GetOrCreateCounter(fmt.Sprintf(`vm_promscrape_scrapes_total{status_code="%d"}`, resp.StatusCode)).Inc()
 		respBody, _ := ioutil.ReadAll(resp.Body)
 		_ = resp.Body.Close()
 		cancel()
-		return nil, fmt.Errorf("unexpected status code returned when scraping %q: %d; expecting %d; response body: %q",
-			c.scrapeURL, resp.StatusCode, http.StatusOK, respBody)
+		return nil, resp.StatusCode, newStatusCodeError(scrapeURL, resp.StatusCode, respBody)
+	}
+	if err := checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, resp.StatusCode, fmt.Errorf("cannot parse response from %q: %w", scrapeURL, err)
+	}
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	var respBody io.ReadCloser = resp.Body
+	var compressedBody *countingReadCloser
+	if contentEncoding == "gzip" {
+		compressedBody = &countingReadCloser{
+			ReadCloser: resp.Body,
+		}
+		respBody = compressedBody
+	}
+	r, err := newDecompressReader(respBody, contentEncoding, c.maxDecompressedSize)
+	if err != nil {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, 0, fmt.Errorf("cannot read response from %q: %w", scrapeURL, err)
+	}
+	if c.checkContentType {
+		rc, err := newContentTypeCheckReader(r, resp.Header.Get("Content-Type"))
+		if err != nil {
+			_ = r.Close()
+			cancel()
+			return nil, resp.StatusCode, fmt.Errorf("cannot read response from %q: %w", scrapeURL, err)
+		}
+		r = rc
+	}
+	scrapesOK.Inc()
+	sr := &streamReader{
+		r:       r,
+		cancel:  cancel,
+		onClose: onClose,
+	}
+	if compressedBody != nil {
+		sr.onClose = func() {
+			onClose()
+			c.compressionRatio.check(scrapeURL, compressedBody.n, sr.bytesRead)
+		}
+	}
+	return sr, resp.StatusCode, nil
+}
+
+// newDecompressReader returns a reader, which decompresses body according to the given contentEncoding.
+//
+// Reading of gzipped or zstd-compressed body fails when the decompressed data exceeds maxDecompressedSize bytes.
+func newDecompressReader(body io.ReadCloser, contentEncoding string, maxDecompressedSize int) (io.ReadCloser, error) {
+	switch contentEncoding {
+	case "gzip":
+		zr, err := common.GetGzipReader(body)
+		if err != nil {
+			scrapesGunzipFailed.Inc()
+			return nil, fmt.Errorf("cannot read gzipped response: %w", err)
+		}
+		scrapesGunzipped.Inc()
+		return &decompressReader{
+			Reader: &decompressedSizeLimiter{
+				r:       zr,
+				maxSize: maxDecompressedSize,
+			},
+			body: body,
+			closeFunc: func() {
+				common.PutGzipReader(zr)
+			},
+		}, nil
+	case "zstd":
+		zr, err := newZstdReader(body, maxDecompressedSize)
+		if err != nil {
+			scrapesZstdDecompressFailed.Inc()
+			return nil, fmt.Errorf("cannot read zstd response: %w", err)
+		}
+		scrapesZstdDecompressed.Inc()
+		return &decompressReader{
+			Reader: &decompressedSizeLimiter{
+				r:       zr,
+				maxSize: maxDecompressedSize,
+				newErr:  newZstdDecompressedSizeError,
+			},
+			body:      body,
+			closeFunc: zr.Close,
+		}, nil
+	default:
+		return body, nil
+	}
+}
+
+type decompressReader struct {
+	io.Reader
+	body      io.Closer
+	closeFunc func()
+}
+
+func (dr *decompressReader) Close() error {
+	dr.closeFunc()
+	return dr.body.Close()
+}
+
+// defaultMaxDecompressedSizeRatio is the ratio between the default limit for the size of decompressed responses and -promscrape.maxScrapeSize.
+const defaultMaxDecompressedSizeRatio = 10
+
+// getMaxDecompressedSize returns the limit for the size of decompressed gzip and zstd responses for sw.
+//
+// -promscrape.maxDecompressedSize is used if `max_decompressed_size` isn't set, so tiny gzip bombs cannot exhaust the memory.
+// The limit defaults to defaultMaxDecompressedSizeRatio*-promscrape.maxScrapeSize if -promscrape.maxDecompressedSize isn't set.
+func getMaxDecompressedSize(sw *ScrapeWork) int {
+	if sw.MaxDecompressedSize > 0 {
+		return sw.MaxDecompressedSize
+	}
+	if maxDecompressedSize.N > 0 {
+		return maxDecompressedSize.N
+	}
+	return defaultMaxDecompressedSizeRatio * maxScrapeSize.N
+}
+
+// decompressedSizeLimiter returns an error when more than maxSize bytes are read from r.
+type decompressedSizeLimiter struct {
+	r       io.Reader
+	size    int
+	maxSize int
+
+	// newErr returns the error for exceeded maxSize. newDecompressedSizeError is used if it is nil.
+	newErr func(maxSize int) error
+}
+
+func (dl *decompressedSizeLimiter) Read(p []byte) (int, error) {
+	n, err := dl.r.Read(p)
+	dl.size += n
+	if dl.size > dl.maxSize {
+		scrapesDecompressedSizeExceeded.Inc()
+		if dl.newErr != nil {
+			return n, dl.newErr(dl.maxSize)
+		}
+		return n, newDecompressedSizeError(dl.maxSize)
+	}
+	return n, err
+}
+
+func newDecompressedSizeError(maxSize int) error {
+	return fmt.Errorf("the decompressed gzip response exceeds %d bytes; either reduce the response size for the target "+
+		"or increase `max_decompressed_size` in `scrape_config`", maxSize)
+}
+
+// appendGunzipBytesLimited appends gunzipped src to dst.
+//
+// An error is returned if the gunzipped data exceeds maxSize bytes. The decompression is stopped at this point.
+func appendGunzipBytesLimited(dst, src []byte, maxSize int) ([]byte, error) {
+	zr, err := common.GetGzipReader(bytes.NewReader(src))
+	if err != nil {
+		return dst, err
+	}
+	defer common.PutGzipReader(zr)
+	bb := bytes.NewBuffer(dst)
+	n, err := io.Copy(bb, io.LimitReader(zr, int64(maxSize)+1))
+	dst = bb.Bytes()
+	if err != nil {
+		return dst, err
+	}
+	if n > int64(maxSize) {
+		scrapesDecompressedSizeExceeded.Inc()
+		return dst, newDecompressedSizeError(maxSize)
+	}
+	return dst, nil
+}
+
+func newZstdDecompressedSizeError(maxSize int) error {
+	return fmt.Errorf("the decompressed zstd response exceeds %d bytes; either reduce the response size for the target "+
+		"or increase `max_decompressed_size` in `scrape_config`", maxSize)
+}
+
+// minZstdDecoderMaxMemory is the minimum memory limit for zstd decoder.
+//
+// It matches the default window size for streaming zstd encoders, which don't know the response size in advance.
+const minZstdDecoderMaxMemory = 8 * 1024 * 1024
+
+// newZstdReader returns zstd decoder for r with the memory limit suitable for reading up to maxSize decompressed bytes.
+//
+// The limit doesn't prevent from decompressing more than maxSize bytes, so the caller must limit the size of the read data.
+func newZstdReader(r io.Reader, maxSize int) (*kzstd.Decoder, error) {
+	maxMemory := uint64(maxSize)
+	if maxMemory < minZstdDecoderMaxMemory {
+		maxMemory = minZstdDecoderMaxMemory
+	}
+	return kzstd.NewReader(r, kzstd.WithDecoderConcurrency(1), kzstd.WithDecoderMaxMemory(maxMemory))
+}
+
+// appendZstdBytesLimited appends zstd-decompressed src to dst.
+//
+// An error is returned if the decompressed data exceeds maxSize bytes. The decompression is stopped at this point,
+// so responses with small compressed size cannot exhaust the memory.
+func appendZstdBytesLimited(dst, src []byte, maxSize int) ([]byte, error) {
+	zr, err := newZstdReader(bytes.NewReader(src), maxSize)
+	if err != nil {
+		return dst, err
+	}
+	defer zr.Close()
+	bb := bytes.NewBuffer(dst)
+	n, err := io.Copy(bb, io.LimitReader(zr, int64(maxSize)+1))
+	dst = bb.Bytes()
+	if err != nil {
+		return dst, err
+	}
+	if n > int64(maxSize) {
+		scrapesDecompressedSizeExceeded.Inc()
+		return dst, newZstdDecompressedSizeError(maxSize)
+	}
+	return dst, nil
+}
+
+func (c *client) ReadData(dst []byte) ([]byte, error) {
+	return c.readDataWithDeadline(dst, time.Time{})
+}
+
+// readDataWithDeadline reads data from c.scrapeURL and appends it to dst. Scrape attempts cannot exceed the given deadline if it is non-zero.
+func (c *client) readDataWithDeadline(dst []byte, deadline time.Time) ([]byte, error) {
+	if c.filePath != "" {
+		return readFileData(dst, c.filePath)
+	}
+	if c.objectStore != nil {
+		return c.objectStore.ReadData(dst)
+	}
+	return c.readData(dst, c.scrapeURL, c.requestURI, deadline)
+}
+
+// openFile opens the file at path for reading metrics from it.
+//
+// Files with `.gz` extension are transparently decompressed.
+func openFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file for scraping: %w", err)
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	r, err := newDecompressReader(f, "gzip", maxScrapeSize.N)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot read %q: %w", path, err)
+	}
+	return r, nil
+}
+
+func readFileData(dst []byte, path string) ([]byte, error) {
+	r, err := openFile(path)
+	if err != nil {
+		return dst, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxScrapeSize.N)+1))
+	if err != nil {
+		return dst, fmt.Errorf("cannot read %q: %w", path, err)
+	}
+	if len(data) > maxScrapeSize.N {
+		return dst, fmt.Errorf("the file %q exceeds -promscrape.maxScrapeSize=%d; "+
+			"either reduce the file size or increase -promscrape.maxScrapeSize", path, maxScrapeSize.N)
+	}
+	scrapesOK.Inc()
+	return append(dst, data...), nil
+}
+
+func getFileStreamReader(path string) (*streamReader, error) {
+	r, err := openFile(path)
+	if err != nil {
+		return nil, err
 	}
 	scrapesOK.Inc()
 	return &streamReader{
-		r:      resp.Body,
-		cancel: cancel,
+		r:      r,
+		cancel: func() {},
 	}, nil
 }
 
-func (c *client) ReadData(dst []byte) ([]byte, error) {
-	deadline := time.Now().Add(c.hc.ReadTimeout)
+// ReadAdditionalData reads data from ScrapeWork.AdditionalScrapeURLs[idx].
+func (c *client) ReadAdditionalData(idx int, dst []byte) ([]byte, error) {
+	au := &c.additionalURLs[idx]
+	return c.readData(dst, au.scrapeURL, au.requestURI, time.Time{})
+}
+
+func (c *client) readData(dst []byte, scrapeURL, requestURI string, scrapeDeadline time.Time) ([]byte, error) {
+	if c.useStreamClient {
+		return c.readStreamData(dst, scrapeURL, scrapeDeadline)
+	}
+	dstLen := len(dst)
+	retryDeadline := c.getRetryDeadline(scrapeDeadline)
+	relogin := false
+	for attempt := 0; ; attempt++ {
+		deadline := getAttemptDeadline(c.hc.ReadTimeout, retryDeadline)
+		var statusCode int
+		var err error
+		dst, statusCode, err = c.readDataOnce(dst[:dstLen], scrapeURL, requestURI, deadline)
+		if c.needRelogin(statusCode, &relogin) {
+			continue
+		}
+		if !c.needRetry(attempt, statusCode, err, retryDeadline) {
+			return dst, err
+		}
+		scrapeRetries.Inc()
+	}
+}
+
+// readStreamData reads the response from scrapeURL via sc and appends it to dst.
+func (c *client) readStreamData(dst []byte, scrapeURL string, scrapeDeadline time.Time) ([]byte, error) {
+	sr, err := c.getStreamReader(scrapeURL, scrapeDeadline)
+	if err != nil {
+		return dst, err
+	}
+	defer sr.MustClose()
+	data, err := ioutil.ReadAll(io.LimitReader(sr, int64(maxScrapeSize.N)+1))
+	if err != nil {
+		return dst, fmt.Errorf("cannot read response from %q: %w", scrapeURL, err)
+	}
+	if len(data) > maxScrapeSize.N {
+		return dst, fmt.Errorf("the response from %q exceeds -promscrape.maxScrapeSize=%d; "+
+			"either reduce the response size for the target or increase -promscrape.maxScrapeSize", scrapeURL, maxScrapeSize.N)
+	}
+	return append(dst, data...), nil
+}
+
+// needRetry returns true if the scrape attempt, which finished with the given statusCode and err, must be retried.
+//
+// Only transient errors such as connection errors, timeouts and 5xx responses are retried
+// up to ScrapeWork.ScrapeRetries times until retryDeadline.
+func (c *client) needRetry(attempt, statusCode int, err error, retryDeadline time.Time) bool {
+	if err == nil || attempt >= c.scrapeRetries || time.Until(retryDeadline) <= 0 {
+		return false
+	}
+	return isRetryableScrapeError(statusCode, err)
+}
+
+func isRetryableScrapeError(statusCode int, err error) bool {
+	if statusCode >= 500 {
+		return true
+	}
+	if statusCode > 0 {
+		// Do not retry on non-5xx responses, since they aren't transient.
+		return false
+	}
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// statusCodeError is returned when scrape target responds with unexpected status code.
+type statusCodeError struct {
+	statusCode int
+	msg        string
+}
+
+func newStatusCodeError(scrapeURL string, statusCode int, respBody []byte) error {
+	return &statusCodeError{
+		statusCode: statusCode,
+		msg: fmt.Sprintf("unexpected status code returned when scraping %q: %d; expecting %d; response body: %q",
+			scrapeURL, statusCode, http.StatusOK, respBody),
+	}
+}
+
+// Error implements error interface.
+func (e *statusCodeError) Error() string {
+	return e.msg
+}
+
+// Reasons for failed scrapes. They are used as `reason` label values in `vm_promscrape_scrape_errors_total` metric.
+const (
+	scrapeErrorReasonDNS                   = "dns"
+	scrapeErrorReasonConnect               = "connect"
+	scrapeErrorReasonTLS                   = "tls"
+	scrapeErrorReasonTimeout               = "timeout"
+	scrapeErrorReasonHTTP4xx               = "http_4xx"
+	scrapeErrorReasonHTTP5xx               = "http_5xx"
+	scrapeErrorReasonParse                 = "parse"
+	scrapeErrorReasonParseTimeout          = "parse_timeout"
+	scrapeErrorReasonCircuitOpen           = "circuit_open"
+	scrapeErrorReasonSchemaValidation      = "schema_validation"
+	scrapeErrorReasonUnexpectedContentType = "unexpected_content_type"
+	scrapeErrorReasonOther                 = "other"
+)
+
+// scrapeErrorReasons contains all the reasons for failed scrapes.
+var scrapeErrorReasons = []string{
+	scrapeErrorReasonDNS,
+	scrapeErrorReasonConnect,
+	scrapeErrorReasonTLS,
+	scrapeErrorReasonTimeout,
+	scrapeErrorReasonHTTP4xx,
+	scrapeErrorReasonHTTP5xx,
+	scrapeErrorReasonParse,
+	scrapeErrorReasonParseTimeout,
+	scrapeErrorReasonCircuitOpen,
+	scrapeErrorReasonSchemaValidation,
+	scrapeErrorReasonUnexpectedContentType,
+	scrapeErrorReasonOther,
+}
+
+// getScrapeErrorReason returns normalized reason for the given scrape error.
+//
+// The returned reason is used as `reason` label value in `vm_promscrape_scrape_errors_total` metric,
+// so it must be one of scrapeErrorReason* constants. scrapeErrorReasonParse is never returned,
+// since parse errors are registered directly when parsing the scraped response.
+func getScrapeErrorReason(err error) string {
+	if errors.Is(err, errParseTimeout) {
+		return scrapeErrorReasonParseTimeout
+	}
+	if errors.Is(err, errCircuitBreakerOpen) {
+		return scrapeErrorReasonCircuitOpen
+	}
+	if errors.Is(err, errRequiredMetricsMissing) {
+		return scrapeErrorReasonSchemaValidation
+	}
+	if errors.Is(err, errUnexpectedContentType) {
+		return scrapeErrorReasonUnexpectedContentType
+	}
+	var sce *statusCodeError
+	if errors.As(err, &sce) {
+		switch {
+		case sce.statusCode >= 400 && sce.statusCode < 500:
+			return scrapeErrorReasonHTTP4xx
+		case sce.statusCode >= 500 && sce.statusCode < 600:
+			return scrapeErrorReasonHTTP5xx
+		default:
+			return scrapeErrorReasonOther
+		}
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return scrapeErrorReasonDNS
+	}
+	if isTLSError(err) {
+		return scrapeErrorReasonTLS
+	}
+	var ne net.Error
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, fasthttp.ErrDialTimeout) || errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &ne) && ne.Timeout()) {
+		return scrapeErrorReasonTimeout
+	}
+	var opErr *net.OpError
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, fasthttp.ErrConnectionClosed) ||
+		(errors.As(err, &opErr) && opErr.Op == "dial") {
+		return scrapeErrorReasonConnect
+	}
+	return scrapeErrorReasonOther
+}
+
+func isTLSError(err error) bool {
+	var rhe tls.RecordHeaderError
+	var uae x509.UnknownAuthorityError
+	var he x509.HostnameError
+	var cie x509.CertificateInvalidError
+	if errors.As(err, &rhe) || errors.As(err, &uae) || errors.As(err, &he) || errors.As(err, &cie) {
+		return true
+	}
+	// TLS handshake errors are usually returned without dedicated types.
+	s := err.Error()
+	return strings.Contains(s, "tls: ") || strings.Contains(s, "x509: ")
+}
+
+// isTargetURI returns true if uri points to the scrape target, so it can be requested via c.hc.
+func (c *client) isTargetURI(uri *fasthttp.URI) bool {
+	isTLS := string(uri.Scheme()) == "https"
+	if isTLS != c.hc.IsTLS {
+		return false
+	}
+	hostname, port := splitRedirectHost(string(uri.Scheme()), string(uri.Host()))
+	return strings.EqualFold(net.JoinHostPort(hostname, port), c.host)
+}
+
+// getRetryDeadline returns the deadline for retrying scrape attempts.
+//
+// All the attempts must fit scrape_timeout, so retries cannot delay the scrape past its timeout.
+// The returned deadline cannot exceed scrapeDeadline if it is non-zero.
+func (c *client) getRetryDeadline(scrapeDeadline time.Time) time.Time {
+	retryDeadline := time.Now().Add(c.scrapeTimeout)
+	if !scrapeDeadline.IsZero() && retryDeadline.After(scrapeDeadline) {
+		retryDeadline = scrapeDeadline
+	}
+	return retryDeadline
+}
+
+// getAttemptDeadline returns deadline for a single scrape attempt with the given timeout.
+//
+// The returned deadline cannot exceed retryDeadline.
+func getAttemptDeadline(timeout time.Duration, retryDeadline time.Time) time.Time {
+	deadline := time.Now().Add(timeout)
+	if deadline.After(retryDeadline) {
+		deadline = retryDeadline
+	}
+	return deadline
+}
+
+func (c *client) readDataOnce(dst []byte, scrapeURL, requestURI string, deadline time.Time) ([]byte, int, error) {
+	bodyStart := len(dst)
+	cookie, err := c.getLoginCookie(scrapeURL)
+	if err != nil {
+		return dst, 0, err
+	}
+	cfHeaders, err := c.getCloudflareAccessHeaders(scrapeURL)
+	if err != nil {
+		return dst, 0, err
+	}
 	req := fasthttp.AcquireRequest()
-	req.SetRequestURI(c.requestURI)
-	req.SetHost(c.host)
-	// The following `Accept` header has been copied from Prometheus sources.
-	// See https://github.com/prometheus/prometheus/blob/f9d21f10ecd2a343a381044f131ea4e46381ce09/scrape/scrape.go#L532 .
-	// This is needed as a workaround for scraping stupid Java-based servers such as Spring Boot.
-	// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/608 for details.
-	// Do not bloat the `Accept` header with OpenMetrics shit, since it looks like dead standard now.
-	req.Header.Set("Accept", "text/plain;version=0.0.4;q=1,*/*;q=0.1")
+	req.SetRequestURI(requestURI)
+	// Set Host header directly instead of req.SetHost, since the latter parses requestURI and unescapes chars such as `%2F` in it.
+	req.Header.SetHost(c.host)
+	if c.method == "POST" {
+		req.Header.SetMethod("POST")
+		req.Header.SetContentType(c.contentType)
+		req.SetBodyString(c.body)
+	}
+	req.Header.Set("Accept", c.acceptHeader)
+	if c.acceptCharset != "" {
+		req.Header.Set("Accept-Charset", c.acceptCharset)
+	}
 	if !*disableCompression && !c.disableCompression {
-		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Accept-Encoding", *acceptEncoding)
 	}
 	if *disableKeepAlive || c.disableKeepAlive {
 		req.SetConnectionClose()
@@ -162,6 +982,21 @@ func (c *client) ReadData(dst []byte) ([]byte, error) {
 	if c.authHeader != "" {
 		req.Header.Set("Authorization", c.authHeader)
 	}
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	for _, h := range cfHeaders {
+		req.Header.Set(h.name, h.value)
+	}
+	isConditional := c.conditionalScrape && scrapeURL == c.scrapeURL
+	if isConditional {
+		if c.etag != "" {
+			req.Header.Set("If-None-Match", c.etag)
+		}
+		if c.lastModified != "" {
+			req.Header.Set("If-Modified-Since", c.lastModified)
+		}
+	}
 	resp := fasthttp.AcquireResponse()
 	swapResponseBodies := len(dst) == 0
 	if swapResponseBodies {
@@ -169,17 +1004,32 @@ func (c *client) ReadData(dst []byte) ([]byte, error) {
 		// This should reduce memory uage when scraping big targets.
 		dst = resp.SwapBody(dst)
 	}
-	err := doRequestWithPossibleRetry(c.hc, req, resp, deadline)
+	err = doRequestWithPossibleRetry(c.hc, req, resp, deadline)
 	statusCode := resp.StatusCode()
-	if err == nil && (statusCode == fasthttp.StatusMovedPermanently || statusCode == fasthttp.StatusFound) {
-		// Allow a single redirect.
-		// It is expected that the redirect is made on the same host.
-		// Otherwise it won't work.
-		if location := resp.Header.Peek("Location"); len(location) > 0 {
-			req.URI().UpdateBytes(location)
+	for redirects := 0; err == nil && (statusCode == fasthttp.StatusMovedPermanently || statusCode == fasthttp.StatusFound); redirects++ {
+		// Allow up to `max_redirects` redirects. A single redirect is allowed by default.
+		location := resp.Header.Peek("Location")
+		if len(location) == 0 {
+			break
+		}
+		if c.redirects == nil && redirects >= defaultMaxRedirects {
+			// Return the redirect response as is in order to preserve the behavior when `max_redirects` isn't set.
+			break
+		}
+		req.URI().UpdateBytes(location)
+		uri := req.URI()
+		if err = c.redirects.checkRedirect(uri.String(), string(uri.Scheme()), string(uri.Host()), redirects, defaultMaxRedirects); err != nil {
+			break
+		}
+		if c.isTargetURI(uri) {
 			err = c.hc.DoDeadline(req, resp, deadline)
-			statusCode = resp.StatusCode()
+		} else {
+			// Do not send credentials to other hosts.
+			req.Header.Del("Authorization")
+			req.Header.Del("Cookie")
+			err = c.redirectClient.DoDeadline(req, resp, deadline)
 		}
+		statusCode = resp.StatusCode()
 	}
 	if swapResponseBodies {
 		dst = resp.SwapBody(dst)
@@ -189,50 +1039,113 @@ func (c *client) ReadData(dst []byte) ([]byte, error) {
 		fasthttp.ReleaseResponse(resp)
 		if err == fasthttp.ErrTimeout {
 			scrapesTimedout.Inc()
-			return dst, fmt.Errorf("error when scraping %q with timeout %s: %w", c.scrapeURL, c.hc.ReadTimeout, err)
+			return dst, 0, fmt.Errorf("error when scraping %q with timeout %s: %w", scrapeURL, c.hc.ReadTimeout, err)
 		}
 		if err == fasthttp.ErrBodyTooLarge {
-			return dst, fmt.Errorf("the response from %q exceeds -promscrape.maxScrapeSize=%d; "+
-				"either reduce the response size for the target or increase -promscrape.maxScrapeSize", c.scrapeURL, maxScrapeSize.N)
+			return dst, 0, fmt.Errorf("the response from %q exceeds -promscrape.maxScrapeSize=%d; "+
+				"either reduce the response size for the target or increase -promscrape.maxScrapeSize", scrapeURL, maxScrapeSize.N)
 		}
-		return dst, fmt.Errorf("error when scraping %q: %w", c.scrapeURL, err)
+		return dst, 0, fmt.Errorf("error when scraping %q: %w", scrapeURL, err)
 	}
-	if ce := resp.Header.Peek("Content-Encoding"); string(ce) == "gzip" {
+	switch ce := resp.Header.Peek("Content-Encoding"); string(ce) {
+	case "gzip":
 		var err error
+		compressedLen := 0
+		decompressedLen := 0
 		if swapResponseBodies {
 			zb := gunzipBufPool.Get()
-			zb.B, err = fasthttp.AppendGunzipBytes(zb.B[:0], dst)
+			compressedLen = len(dst)
+			zb.B, err = appendGunzipBytesLimited(zb.B[:0], dst, c.maxDecompressedSize)
 			dst = append(dst[:0], zb.B...)
+			decompressedLen = len(zb.B)
 			gunzipBufPool.Put(zb)
 		} else {
-			dst, err = fasthttp.AppendGunzipBytes(dst, resp.Body())
+			dstLen := len(dst)
+			compressedLen = len(resp.Body())
+			dst, err = appendGunzipBytesLimited(dst, resp.Body(), c.maxDecompressedSize)
+			decompressedLen = len(dst) - dstLen
 		}
 		if err != nil {
 			fasthttp.ReleaseResponse(resp)
 			scrapesGunzipFailed.Inc()
-			return dst, fmt.Errorf("cannot ungzip response from %q: %w", c.scrapeURL, err)
+			return dst, 0, fmt.Errorf("cannot ungzip response from %q: %w", scrapeURL, err)
 		}
 		scrapesGunzipped.Inc()
-	} else if !swapResponseBodies {
-		dst = append(dst, resp.Body()...)
+		c.compressionRatio.check(scrapeURL, int64(compressedLen), int64(decompressedLen))
+	case "zstd":
+		var err error
+		if swapResponseBodies {
+			zb := gunzipBufPool.Get()
+			zb.B, err = appendZstdBytesLimited(zb.B[:0], dst, c.maxDecompressedSize)
+			dst = append(dst[:0], zb.B...)
+			gunzipBufPool.Put(zb)
+		} else {
+			dst, err = appendZstdBytesLimited(dst, resp.Body(), c.maxDecompressedSize)
+		}
+		if err != nil {
+			fasthttp.ReleaseResponse(resp)
+			scrapesZstdDecompressFailed.Inc()
+			return dst, 0, fmt.Errorf("cannot decompress zstd response from %q: %w", scrapeURL, err)
+		}
+		scrapesZstdDecompressed.Inc()
+	default:
+		if !swapResponseBodies {
+			dst = append(dst, resp.Body()...)
+		}
+	}
+	contentType := string(resp.Header.ContentType())
+	if c.intervalHint != nil && scrapeURL == c.scrapeURL {
+		c.intervalHint.value = string(resp.Header.Peek(c.intervalHint.header))
+	}
+	if isConditional && statusCode == fasthttp.StatusOK {
+		c.etag = string(resp.Header.Peek("ETag"))
+		c.lastModified = string(resp.Header.Peek("Last-Modified"))
 	}
 	fasthttp.ReleaseResponse(resp)
+	if isConditional && statusCode == fasthttp.StatusNotModified {
+		scrapesNotModified.Inc()
+		return dst, statusCode, errNotModified
+	}
 	if statusCode != fasthttp.StatusOK {
 		metrics.GetOrCreateCounter(fmt.Sprintf(`vm_promscrape_scrapes_total{status_code="%d"}`, statusCode)).Inc()
-		return dst, fmt.Errorf("unexpected status code returned when scraping %q: %d; expecting %d; response body: %q",
-			c.scrapeURL, statusCode, fasthttp.StatusOK, dst)
+		return dst, statusCode, newStatusCodeError(scrapeURL, statusCode, dst)
+	}
+	if err := checkContentType(contentType); err != nil {
+		return dst, statusCode, fmt.Errorf("cannot parse response from %q: %w", scrapeURL, err)
+	}
+	if c.checkContentType {
+		prefix := dst[bodyStart:]
+		if len(prefix) > contentTypeSniffLen {
+			prefix = prefix[:contentTypeSniffLen]
+		}
+		if err := checkExpositionContentType(contentType, prefix); err != nil {
+			return dst[:bodyStart], statusCode, fmt.Errorf("cannot parse response from %q: %w", scrapeURL, err)
+		}
 	}
 	scrapesOK.Inc()
-	return dst, nil
+	return dst, statusCode, nil
 }
 
 var gunzipBufPool bytesutil.ByteBufferPool
 
+// errNotModified is returned by client.ReadData if the target responds with `304 Not Modified` to conditional scrape.
+//
+// The previously scraped data must be re-used in this case.
+var errNotModified = errors.New("not modified")
+
 var (
 	scrapesTimedout     = metrics.NewCounter(`vm_promscrape_scrapes_timed_out_total`)
 	scrapesOK           = metrics.NewCounter(`vm_promscrape_scrapes_total{status_code="200"}`)
+	scrapesNotModified  = metrics.NewCounter(`vm_promscrape_scrapes_total{status_code="304"}`)
 	scrapesGunzipped    = metrics.NewCounter(`vm_promscrape_scrapes_gunziped_total`)
 	scrapesGunzipFailed = metrics.NewCounter(`vm_promscrape_scrapes_gunzip_failed_total`)
+
+	scrapesDecompressedSizeExceeded = metrics.NewCounter(`vm_promscrape_scrapes_decompressed_size_exceeded_total`)
+
+	scrapesZstdDecompressed     = metrics.NewCounter(`vm_promscrape_scrapes_zstd_decompressed_total`)
+	scrapesZstdDecompressFailed = metrics.NewCounter(`vm_promscrape_scrapes_zstd_decompress_failed_total`)
+
+	scrapeRetries = metrics.NewCounter(`vm_promscrape_scrape_retries_total`)
 )
 
 func doRequestWithPossibleRetry(hc *fasthttp.HostClient, req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
@@ -257,6 +1170,9 @@ type streamReader struct {
 	r         io.ReadCloser
 	cancel    context.CancelFunc
 	bytesRead int64
+
+	// onClose is called in MustClose if set.
+	onClose func()
 }
 
 func (sr *streamReader) Read(p []byte) (int, error) {
@@ -266,6 +1182,9 @@ func (sr *streamReader) Read(p []byte) (int, error) {
 }
 
 func (sr *streamReader) MustClose() {
+	if sr.onClose != nil {
+		sr.onClose()
+	}
 	sr.cancel()
 	if err := sr.r.Close(); err != nil {
 		logger.Errorf("cannot close reader: %s", err)
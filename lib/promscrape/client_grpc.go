@@ -0,0 +1,186 @@
+//go:build grpc
+// +build grpc
+
+package promscrape
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func init() {
+	newGRPCReadData = func(sw *ScrapeWork) func(dst []byte) ([]byte, error) {
+		return newGRPCClient(sw).ReadData
+	}
+}
+
+// grpcClient obtains metrics by calling gRPC method, which returns messages with Prometheus text exposition in the field 1.
+//
+// The method is called with an empty request message. Both unary and server-streaming methods are supported.
+type grpcClient struct {
+	scrapeURL string
+	addr      string
+	method    string
+	isTLS     bool
+	ac        *promauth.Config
+	proxyURL  *proxy.URL
+	timeout   time.Duration
+}
+
+func newGRPCClient(sw *ScrapeWork) *grpcClient {
+	scheme, host := "http", sw.ScrapeURL
+	if n := strings.Index(host, "://"); n >= 0 {
+		scheme, host = host[:n], host[n+3:]
+	}
+	if n := strings.IndexByte(host, '/'); n >= 0 {
+		host = host[:n]
+	}
+	isTLS := scheme == "https"
+	if !strings.Contains(host, ":") {
+		if !isTLS {
+			host += ":80"
+		} else {
+			host += ":443"
+		}
+	}
+	return &grpcClient{
+		scrapeURL: sw.ScrapeURL,
+		addr:      strings.Replace(host, "%25", "%", 1),
+		method:    sw.GRPCMethod,
+		isTLS:     isTLS,
+		ac:        sw.AuthConfig,
+		proxyURL:  sw.ProxyURL,
+		timeout:   sw.ScrapeTimeout - sw.ScrapeTimeoutOffset,
+	}
+}
+
+// ReadData appends Prometheus text exposition obtained from gc.method to dst and returns the result.
+//
+// A new connection is established for every call, since the scraped targets are usually called once per scrape interval.
+func (gc *grpcClient) ReadData(dst []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gc.timeout)
+	defer cancel()
+	if gc.ac.Authorization != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", gc.ac.Authorization)
+	}
+	dialContext := gc.proxyURL.NewDialContextFunc(statStdDial)
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialContext(ctx, "tcp", addr)
+		}),
+	}
+	if gc.isTLS {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(gc.ac.NewTLSConfig())))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.DialContext(ctx, gc.addr, opts...)
+	if err != nil {
+		return dst, fmt.Errorf("cannot connect to %q for calling gRPC method %q: %w", gc.scrapeURL, gc.method, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	maxSize := maxScrapeSize.N
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, gc.method, grpc.ForceCodec(grpcRawCodec{}), grpc.MaxCallRecvMsgSize(maxSize))
+	if err != nil {
+		return dst, fmt.Errorf("cannot call gRPC method %q at %q: %w", gc.method, gc.scrapeURL, err)
+	}
+	req := []byte{}
+	if err := stream.SendMsg(&req); err != nil && err != io.EOF {
+		return dst, fmt.Errorf("cannot send request to gRPC method %q at %q: %w", gc.method, gc.scrapeURL, err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return dst, fmt.Errorf("cannot close request stream for gRPC method %q at %q: %w", gc.method, gc.scrapeURL, err)
+	}
+	dstLen := len(dst)
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				return dst, nil
+			}
+			return dst[:dstLen], fmt.Errorf("cannot read response from gRPC method %q at %q: %w", gc.method, gc.scrapeURL, err)
+		}
+		dst, err = appendGRPCExposition(dst, msg)
+		if err != nil {
+			return dst[:dstLen], fmt.Errorf("cannot parse response from gRPC method %q at %q: %w", gc.method, gc.scrapeURL, err)
+		}
+		if len(dst)-dstLen > maxSize {
+			return dst[:dstLen], fmt.Errorf("the response from gRPC method %q at %q exceeds -promscrape.maxScrapeSize=%d; "+
+				"either reduce the response size for the target or increase -promscrape.maxScrapeSize", gc.method, gc.scrapeURL, maxSize)
+		}
+	}
+}
+
+// appendGRPCExposition appends Prometheus text exposition from the field 1 of protobuf-encoded msg to dst.
+//
+// The field must have `string` or `bytes` type. Other fields are ignored.
+func appendGRPCExposition(dst, msg []byte) ([]byte, error) {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return dst, fmt.Errorf("cannot read field tag: %w", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		if num != 1 {
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+			if n < 0 {
+				return dst, fmt.Errorf("cannot read field %d: %w", num, protowire.ParseError(n))
+			}
+			msg = msg[n:]
+			continue
+		}
+		if typ != protowire.BytesType {
+			return dst, fmt.Errorf("unexpected wire type for field 1; got %d; want %d (string or bytes)", typ, protowire.BytesType)
+		}
+		data, n := protowire.ConsumeBytes(msg)
+		if n < 0 {
+			return dst, fmt.Errorf("cannot read field 1: %w", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		dst = append(dst, data...)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			dst = append(dst, '\n')
+		}
+	}
+	return dst, nil
+}
+
+// grpcRawCodec passes protobuf-encoded messages as is, so gRPC methods can be called without generated code.
+//
+// Messages must have *[]byte type.
+type grpcRawCodec struct{}
+
+func (grpcRawCodec) Marshal(v interface{}) ([]byte, error) {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T; want *[]byte", v)
+	}
+	return *p, nil
+}
+
+func (grpcRawCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T; want *[]byte", v)
+	}
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+// Name returns "proto", so requests are sent with `application/grpc+proto` content-type accepted by gRPC servers.
+func (grpcRawCodec) Name() string {
+	return "proto"
+}
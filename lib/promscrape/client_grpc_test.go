@@ -0,0 +1,154 @@
+//go:build grpc
+// +build grpc
+
+package promscrape
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcRawServerCodec implements deprecated grpc.Codec interface required by grpc.CustomCodec.
+type grpcRawServerCodec struct {
+	grpcRawCodec
+}
+
+func (grpcRawServerCodec) String() string {
+	return "proto"
+}
+
+func newTestGRPCServer(t *testing.T, payloads ...string) (string, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start listener: %s", err)
+	}
+	s := grpc.NewServer(grpc.CustomCodec(grpcRawServerCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		if method != "/test.Metrics/Get" {
+			return fmt.Errorf("unexpected method %q", method)
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		if auth := md.Get("authorization"); len(auth) != 1 || auth[0] != "Bearer secret" {
+			return fmt.Errorf("unexpected authorization metadata: %q", auth)
+		}
+		var req []byte
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		for _, payload := range payloads {
+			resp := protowire.AppendTag(nil, 2, protowire.VarintType)
+			resp = protowire.AppendVarint(resp, 42)
+			resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+			resp = protowire.AppendString(resp, payload)
+			if err := stream.SendMsg(&resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	return ln.Addr().String(), s.Stop
+}
+
+func TestGRPCScrape(t *testing.T) {
+	addr, stop := newTestGRPCServer(t, "foo 1\nbar{x=\"y\"} 2", "baz 3\n")
+	defer stop()
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: grpc
+  grpc_method: /test.Metrics/Get
+  bearer_token: secret
+  static_configs:
+  - targets: [%q]
+`, addr)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	sws[0].ScrapeTimeout = 5 * time.Second
+	var series []string
+	pushData := func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			series = append(series, fmt.Sprintf("%s %v", promLabelsString(ts.Labels), ts.Samples[0].Value))
+		}
+	}
+	sc := newScraper(&sws[0], "test", pushData)
+	timestamp := int64(123000)
+	if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result := strings.Join(series, "\n")
+	for _, s := range []string{
+		fmt.Sprintf(`{__name__="foo",instance=%q,job="grpc"} 1`, addr),
+		fmt.Sprintf(`{__name__="bar",instance=%q,job="grpc",x="y"} 2`, addr),
+		fmt.Sprintf(`{__name__="baz",instance=%q,job="grpc"} 3`, addr),
+		fmt.Sprintf(`{__name__="up",instance=%q,job="grpc"} 1`, addr),
+		fmt.Sprintf(`{__name__="scrape_samples_scraped",instance=%q,job="grpc"} 3`, addr),
+	} {
+		if !strings.Contains(result, s) {
+			t.Fatalf("missing series %s in the result:\n%s", s, result)
+		}
+	}
+}
+
+func TestGRPCScrapeFailure(t *testing.T) {
+	addr, stop := newTestGRPCServer(t, "foo 1\n")
+	defer stop()
+
+	ac, err := promauth.NewConfig(".", nil, "secret", "", nil)
+	if err != nil {
+		t.Fatalf("cannot create auth config: %s", err)
+	}
+	sw := &ScrapeWork{
+		ScrapeURL:     "http://" + addr + "/metrics",
+		ScrapeTimeout: 5 * time.Second,
+		GRPCMethod:    "/test.Metrics/Missing",
+		AuthConfig:    ac,
+	}
+	data, err := newGRPCClient(sw).ReadData(nil)
+	if err == nil {
+		t.Fatalf("expecting non-nil error for unknown method")
+	}
+	if len(data) > 0 {
+		t.Fatalf("unexpected data on error: %q", data)
+	}
+}
+
+func TestAppendGRPCExposition(t *testing.T) {
+	f := func(msg []byte, resultExpected string) {
+		t.Helper()
+		result, err := appendGRPCExposition(nil, msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result; got %q; want %q", result, resultExpected)
+		}
+	}
+	f(nil, "")
+	f(protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "foo 1"), "foo 1\n")
+	f(protowire.AppendString(protowire.AppendTag(nil, 3, protowire.BytesType), "foo 1"), "")
+
+	// Field 1 with unexpected type
+	msg := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1)
+	if _, err := appendGRPCExposition(nil, msg); err == nil {
+		t.Fatalf("expecting non-nil error for varint field 1")
+	}
+}
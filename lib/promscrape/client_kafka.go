@@ -0,0 +1,165 @@
+//go:build kafka
+// +build kafka
+
+package promscrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/kafkaconsumer"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func init() {
+	runKafkaConsumer = func(sw *scrapeWork, stopCh <-chan struct{}) {
+		newKafkaTopicConsumer(sw).run(stopCh)
+	}
+}
+
+// kafkaMessageReader reads messages from Kafka topic. It is implemented by kafkaconsumer.Consumer.
+type kafkaMessageReader interface {
+	FetchMessage(ctx context.Context) (*kafkaconsumer.Message, error)
+	CommitMessage(ctx context.Context, msg *kafkaconsumer.Message) error
+	LastError() error
+	Rebalances() int64
+	Close() error
+}
+
+// newKafkaMessageReader returns kafkaMessageReader for the given cfg. It may be overridden in tests.
+var newKafkaMessageReader = func(cfg *kafkaconsumer.Config) kafkaMessageReader {
+	return kafkaconsumer.NewConsumer(cfg)
+}
+
+// kafkaTopicConsumer processes messages consumed from the topic of `kafka://topic` target.
+//
+// Every message is processed by sw.scrapeInternal as if it has been scraped from the target.
+type kafkaTopicConsumer struct {
+	sw      *scrapeWork
+	topic   string
+	groupID string
+	r       kafkaMessageReader
+
+	// msg is the currently processed message. It is returned from readData.
+	msg *kafkaconsumer.Message
+	// err is returned from readData instead of msg if it is set.
+	err error
+}
+
+func newKafkaTopicConsumer(sw *scrapeWork) *kafkaTopicConsumer {
+	kcc := sw.Config.KafkaConsumer
+	topic := strings.TrimPrefix(sw.Config.ScrapeURL, "kafka://")
+	groupID := kcc.getGroupID()
+	r := newKafkaMessageReader(&kafkaconsumer.Config{
+		Brokers:       kcc.Brokers,
+		Topic:         topic,
+		GroupID:       groupID,
+		ClientID:      kcc.getClientID(),
+		InitialOffset: kcc.InitialOffset,
+		DialContext:   sw.Config.ProxyURL.NewDialContextFunc(statStdDial),
+		MaxFetchSize:  maxScrapeSize.N,
+	})
+	return &kafkaTopicConsumer{
+		sw:      sw,
+		topic:   topic,
+		groupID: groupID,
+		r:       r,
+	}
+}
+
+// run processes consumed messages until stopCh is closed.
+//
+// Consuming errors are reported as failed scrapes if no messages are consumed during scrape_interval,
+// so they are visible via `up` metric and at `/targets` page.
+func (tc *kafkaTopicConsumer) run(stopCh <-chan struct{}) {
+	tc.sw.ReadData = tc.readData
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	defer func() {
+		if err := tc.r.Close(); err != nil {
+			logger.Warnf("cannot close kafka consumer for topic %q with group_id=%q: %s", tc.topic, tc.groupID, err)
+		}
+	}()
+	for {
+		fetchCtx, fetchCancel := context.WithTimeout(ctx, tc.sw.Config.ScrapeInterval)
+		msg, err := tc.r.FetchMessage(fetchCtx)
+		fetchCancel()
+		kafkaRebalances.Add(int(tc.r.Rebalances()))
+		if ctx.Err() != nil {
+			// The consumer has been stopped.
+			return
+		}
+		if err == nil {
+			if !tc.processMessage(ctx, msg, stopCh) {
+				return
+			}
+			continue
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// There are no new messages during scrape_interval. Report consuming errors if any.
+			if err = tc.r.LastError(); err == nil {
+				continue
+			}
+		}
+		timestamp, ok := tc.sw.acquireScrapeSlot(time.Now().UnixNano()/1e6, stopCh)
+		if !ok {
+			return
+		}
+		tc.err = fmt.Errorf("cannot consume messages from kafka topic %q for group_id=%q: %w", tc.topic, tc.groupID, err)
+		tc.sw.scrapeAndLogError(timestamp, timestamp)
+		tc.sw.limiter.release()
+		tc.err = nil
+		t := timerpool.Get(time.Second)
+		select {
+		case <-stopCh:
+			timerpool.Put(t)
+			return
+		case <-t.C:
+			timerpool.Put(t)
+		}
+	}
+}
+
+func (tc *kafkaTopicConsumer) readData(dst []byte) ([]byte, error) {
+	if tc.err != nil {
+		return dst, tc.err
+	}
+	return append(dst, tc.msg.Value...), nil
+}
+
+// processMessage processes msg as if it has been scraped from the target.
+//
+// The message timestamp is used as the scrape timestamp.
+// False is returned if stopCh is closed before msg is processed. msg isn't committed then, so it is consumed again after restart.
+func (tc *kafkaTopicConsumer) processMessage(ctx context.Context, msg *kafkaconsumer.Message, stopCh <-chan struct{}) bool {
+	realTimestamp, ok := tc.sw.acquireScrapeSlot(time.Now().UnixNano()/1e6, stopCh)
+	if !ok {
+		return false
+	}
+	tc.msg = msg
+	timestamp := msg.Timestamp
+	if timestamp <= 0 {
+		timestamp = realTimestamp
+	}
+	tc.sw.scrapeAndLogError(timestamp, realTimestamp)
+	tc.sw.limiter.release()
+	tc.msg = nil
+	kafkaMessagesConsumed.Inc()
+	if err := tc.r.CommitMessage(ctx, msg); err != nil && ctx.Err() == nil {
+		logger.Errorf("cannot commit offset for the message from kafka topic %q with group_id=%q: %s", tc.topic, tc.groupID, err)
+	}
+	return true
+}
+
+var (
+	kafkaMessagesConsumed = metrics.NewCounter(`vm_promscrape_kafka_messages_consumed_total`)
+	kafkaRebalances       = metrics.NewCounter(`vm_promscrape_kafka_rebalances_total`)
+)
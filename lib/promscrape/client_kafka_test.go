@@ -0,0 +1,183 @@
+//go:build kafka
+// +build kafka
+
+package promscrape
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/kafkaconsumer"
+)
+
+// testKafkaMessageReader returns messages sent to msgs. It returns errors sent to errs from LastError.
+type testKafkaMessageReader struct {
+	cfg  *kafkaconsumer.Config
+	msgs chan *kafkaconsumer.Message
+	errs chan error
+
+	mu        sync.Mutex
+	committed []int64
+	closed    bool
+}
+
+func (r *testKafkaMessageReader) FetchMessage(ctx context.Context) (*kafkaconsumer.Message, error) {
+	select {
+	case msg := <-r.msgs:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *testKafkaMessageReader) CommitMessage(ctx context.Context, msg *kafkaconsumer.Message) error {
+	r.mu.Lock()
+	r.committed = append(r.committed, msg.Timestamp)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *testKafkaMessageReader) LastError() error {
+	select {
+	case err := <-r.errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (r *testKafkaMessageReader) Rebalances() int64 {
+	return 0
+}
+
+func (r *testKafkaMessageReader) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return nil
+}
+
+func TestScrapeWorkKafkaConsumer(t *testing.T) {
+	tr := &testKafkaMessageReader{
+		msgs: make(chan *kafkaconsumer.Message),
+		errs: make(chan error, 1),
+	}
+	newKafkaMessageReaderOrig := newKafkaMessageReader
+	newKafkaMessageReader = func(cfg *kafkaconsumer.Config) kafkaMessageReader {
+		tr.cfg = cfg
+		return tr
+	}
+	defer func() {
+		newKafkaMessageReader = newKafkaMessageReaderOrig
+	}()
+
+	data := `
+scrape_configs:
+- job_name: kafka
+  scrape_interval: 100ms
+  kafka_consumer:
+    brokers: ["kafka1:9092", "kafka2:9092"]
+    group_id: test
+    initial_offset: oldest
+  static_configs:
+  - targets: ["kafka://metrics"]
+    labels:
+      env: test
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+
+	var mu sync.Mutex
+	var samples []string
+	ups := make(map[float64]int)
+	pushData := func(wr *prompbmarshal.WriteRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ts := range wr.Timeseries {
+			name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+			if name == "up" {
+				ups[ts.Samples[0].Value]++
+				continue
+			}
+			if len(name) > 7 && name[:7] == "scrape_" {
+				continue
+			}
+			samples = append(samples, fmt.Sprintf("%s %g %d", promLabelsString(ts.Labels), ts.Samples[0].Value, ts.Samples[0].Timestamp))
+		}
+	}
+	sc := newScraper(&sws[0], "test", pushData)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sc.sw.run(sc.stopCh)
+	}()
+
+	tr.msgs <- &kafkaconsumer.Message{
+		Value:     []byte("foo 1\n"),
+		Timestamp: 1000,
+	}
+	tr.msgs <- &kafkaconsumer.Message{
+		Value:     []byte("bar{x=\"y\"} 2\n"),
+		Timestamp: 2000,
+	}
+
+	// Consuming errors must be reported as failed scrapes.
+	tr.errs <- fmt.Errorf("cannot connect to broker")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		upsFailed := ups[0]
+		mu.Unlock()
+		if upsFailed > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for failed scrape")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tr.msgs <- &kafkaconsumer.Message{
+		Value:     []byte("foo 3\n"),
+		Timestamp: 3000,
+	}
+	close(sc.stopCh)
+	wg.Wait()
+
+	if tr.cfg.Topic != "metrics" || tr.cfg.GroupID != "test" || tr.cfg.InitialOffset != "oldest" || !reflect.DeepEqual(tr.cfg.Brokers, []string{"kafka1:9092", "kafka2:9092"}) {
+		t.Fatalf("unexpected kafka consumer config: %+v", tr.cfg)
+	}
+	if tr.cfg.MaxFetchSize != maxScrapeSize.N {
+		t.Fatalf("unexpected MaxFetchSize; got %d; want %d", tr.cfg.MaxFetchSize, maxScrapeSize.N)
+	}
+	resultExpected := []string{
+		`{__name__="foo",env="test",instance="kafka://metrics",job="kafka"} 1 1000`,
+		`{__name__="bar",env="test",instance="kafka://metrics",job="kafka",x="y"} 2 2000`,
+		`{__name__="foo",env="test",instance="kafka://metrics",job="kafka"} 3 3000`,
+	}
+	if !reflect.DeepEqual(samples, resultExpected) {
+		t.Fatalf("unexpected samples\ngot\n%q\nwant\n%q", samples, resultExpected)
+	}
+	if ups[1] != 3 || ups[0] != 1 {
+		t.Fatalf("unexpected up values; got %v; want 3 successful scrapes and 1 failed scrape", ups)
+	}
+	committedExpected := []int64{1000, 2000, 3000}
+	if !reflect.DeepEqual(tr.committed, committedExpected) {
+		t.Fatalf("unexpected committed messages; got %v; want %v", tr.committed, committedExpected)
+	}
+	if !tr.closed {
+		t.Fatalf("the kafka consumer must be closed after stop")
+	}
+}
@@ -0,0 +1,318 @@
+//go:build ssh
+// +build ssh
+
+package promscrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	sshTunnelDial = func(ctx context.Context, stc *SSHTunnelConfig, addr string) (net.Conn, error) {
+		return sshTunnelsGlobal.get(stc).dial(ctx, addr)
+	}
+}
+
+// sshTunnelIdleTimeout is the duration after which SSH connection without tunneled connections is closed.
+//
+// This frees up SSH connections for the targets, which are no longer scraped.
+const sshTunnelIdleTimeout = 5 * time.Minute
+
+// sshTunnels contains SSH tunnels shared among targets with the same SSHTunnelConfig.
+type sshTunnels struct {
+	mu sync.Mutex
+	m  map[string]*sshTunnel
+}
+
+var sshTunnelsGlobal = &sshTunnels{
+	m: make(map[string]*sshTunnel),
+}
+
+func (sts *sshTunnels) get(stc *SSHTunnelConfig) *sshTunnel {
+	key := stc.String()
+	sts.mu.Lock()
+	defer sts.mu.Unlock()
+	st := sts.m[key]
+	if st == nil {
+		st = &sshTunnel{
+			stc: stc,
+		}
+		sts.m[key] = st
+	}
+	return st
+}
+
+// sshTunnel multiplexes connections to scrape targets over a single SSH connection.
+type sshTunnel struct {
+	stc *SSHTunnelConfig
+
+	mu sync.Mutex
+
+	// client is the current SSH connection. It is nil if the connection isn't established yet or if it has been broken.
+	client *ssh.Client
+
+	// conns is the number of active tunneled connections via client.
+	conns int
+
+	idleTimer *time.Timer
+}
+
+// dial opens tunneled connection to addr. The SSH connection is established if needed.
+//
+// Broken SSH connection is re-established once per dial call.
+func (st *sshTunnel) dial(ctx context.Context, addr string) (net.Conn, error) {
+	c, err := st.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialSSHClient(ctx, c, addr)
+	if err != nil {
+		var oce *ssh.OpenChannelError
+		if errors.As(err, &oce) || ctx.Err() != nil {
+			// The SSH server rejected the connection to addr or the dial timed out. There is no need in re-establishing SSH connection.
+			return nil, fmt.Errorf("cannot dial %q via ssh tunnel to %q: %w", addr, st.stc.Host, err)
+		}
+		// The SSH connection looks broken. Re-establish it.
+		st.resetClient(c)
+		if c, err = st.getClient(ctx); err != nil {
+			return nil, err
+		}
+		if conn, err = dialSSHClient(ctx, c, addr); err != nil {
+			return nil, fmt.Errorf("cannot dial %q via ssh tunnel to %q: %w", addr, st.stc.Host, err)
+		}
+	}
+	st.mu.Lock()
+	st.conns++
+	if st.idleTimer != nil {
+		st.idleTimer.Stop()
+		st.idleTimer = nil
+	}
+	st.mu.Unlock()
+	return &sshTunnelConn{
+		Conn: conn,
+		st:   st,
+	}, nil
+}
+
+// dialSSHClient opens tunneled connection to addr via c.
+//
+// ssh.Client doesn't support context in the vendored golang.org/x/crypto, so the dial is abandoned when ctx is done.
+func dialSSHClient(ctx context.Context, c *ssh.Client, addr string) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan dialResult, 1)
+	go func() {
+		conn, err := c.Dial("tcp", addr)
+		ch <- dialResult{
+			conn: conn,
+			err:  err,
+		}
+	}()
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			// Close the connection if it is established after the dial has been abandoned.
+			if r := <-ch; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (st *sshTunnel) getClient(ctx context.Context) (*ssh.Client, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.client != nil {
+		return st.client, nil
+	}
+	c, err := st.connect(ctx)
+	if err != nil {
+		sshTunnelErrors.Inc()
+		return nil, fmt.Errorf("cannot establish ssh tunnel to %q: %w", st.stc.Host, err)
+	}
+	sshTunnelConnects.Inc()
+	st.client = c
+	go func() {
+		// Forget the broken SSH connection, so it is re-established on the next dial.
+		_ = c.Wait()
+		st.resetClient(c)
+	}()
+	return c, nil
+}
+
+func (st *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	stc := st.stc
+	key, err := ioutil.ReadFile(stc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read `key_file`: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key from `key_file` %q: %w", stc.KeyFile, err)
+	}
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if stc.KnownHostsFile != "" {
+		hostKeyCallback, err = knownhosts.New(stc.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read `known_hosts_file`: %w", err)
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", stc.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	cfg := &ssh.ClientConfig{
+		User: stc.User,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+		},
+		HostKeyCallback: hostKeyCallback,
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(conn, stc.Host, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if stc.KnownHostsFile == "" {
+		logger.Warnf("the host key for ssh tunnel to %q isn't verified; set `known_hosts_file` in `ssh_tunnel` in order to verify it", stc.Host)
+	}
+	return ssh.NewClient(sc, chans, reqs), nil
+}
+
+// resetClient closes c if it is the current SSH connection for st.
+func (st *sshTunnel) resetClient(c *ssh.Client) {
+	st.mu.Lock()
+	if st.client == c {
+		st.client = nil
+	}
+	st.mu.Unlock()
+	_ = c.Close()
+}
+
+func (st *sshTunnel) releaseConn() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.conns--
+	if st.conns > 0 || st.client == nil {
+		return
+	}
+	c := st.client
+	st.idleTimer = time.AfterFunc(sshTunnelIdleTimeout, func() {
+		st.mu.Lock()
+		idle := st.conns == 0
+		st.mu.Unlock()
+		if idle {
+			st.resetClient(c)
+		}
+	})
+}
+
+// sshTunnelConn is a connection to scrape target via sshTunnel.
+//
+// SSH channels don't support deadlines, so the connection is closed when the read or write deadline is exceeded.
+type sshTunnelConn struct {
+	net.Conn
+	st        *sshTunnel
+	closeOnce sync.Once
+
+	// timedOut is set to 1 when the connection is closed because of exceeded deadline.
+	timedOut uint32
+
+	mu            sync.Mutex
+	readDeadline  *time.Timer
+	writeDeadline *time.Timer
+}
+
+// errSSHTunnelTimeout is returned from sshTunnelConn when the read or write deadline is exceeded.
+var errSSHTunnelTimeout net.Error = sshTunnelTimeoutError{}
+
+type sshTunnelTimeoutError struct{}
+
+func (sshTunnelTimeoutError) Error() string   { return "i/o timeout" }
+func (sshTunnelTimeoutError) Timeout() bool   { return true }
+func (sshTunnelTimeoutError) Temporary() bool { return true }
+
+func (tc *sshTunnelConn) Read(p []byte) (int, error) {
+	n, err := tc.Conn.Read(p)
+	if err != nil && atomic.LoadUint32(&tc.timedOut) == 1 {
+		err = errSSHTunnelTimeout
+	}
+	return n, err
+}
+
+func (tc *sshTunnelConn) Write(p []byte) (int, error) {
+	n, err := tc.Conn.Write(p)
+	if err != nil && atomic.LoadUint32(&tc.timedOut) == 1 {
+		err = errSSHTunnelTimeout
+	}
+	return n, err
+}
+
+func (tc *sshTunnelConn) SetDeadline(t time.Time) error {
+	tc.mu.Lock()
+	tc.setDeadlineLocked(&tc.readDeadline, t)
+	tc.setDeadlineLocked(&tc.writeDeadline, t)
+	tc.mu.Unlock()
+	return nil
+}
+
+func (tc *sshTunnelConn) SetReadDeadline(t time.Time) error {
+	tc.mu.Lock()
+	tc.setDeadlineLocked(&tc.readDeadline, t)
+	tc.mu.Unlock()
+	return nil
+}
+
+func (tc *sshTunnelConn) SetWriteDeadline(t time.Time) error {
+	tc.mu.Lock()
+	tc.setDeadlineLocked(&tc.writeDeadline, t)
+	tc.mu.Unlock()
+	return nil
+}
+
+func (tc *sshTunnelConn) setDeadlineLocked(timer **time.Timer, t time.Time) {
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	*timer = time.AfterFunc(time.Until(t), func() {
+		atomic.StoreUint32(&tc.timedOut, 1)
+		_ = tc.Close()
+	})
+}
+
+func (tc *sshTunnelConn) Close() error {
+	err := tc.Conn.Close()
+	tc.closeOnce.Do(tc.st.releaseConn)
+	return err
+}
+
+var (
+	sshTunnelConnects = metrics.NewCounter(`vm_promscrape_ssh_tunnel_connects_total`)
+	sshTunnelErrors   = metrics.NewCounter(`vm_promscrape_ssh_tunnel_errors_total`)
+)
@@ -0,0 +1,268 @@
+//go:build ssh
+// +build ssh
+
+package promscrape
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is SSH server, which forwards `direct-tcpip` channels to the requested addresses.
+type testSSHServer struct {
+	ln  net.Listener
+	cfg *ssh.ServerConfig
+	wg  sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	accepted int
+}
+
+func newTestSSHServer(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) *testSSHServer {
+	t.Helper()
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "scraper" || !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, fmt.Errorf("unexpected user %q or key", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start ssh server: %s", err)
+	}
+	s := &testSSHServer{
+		ln:  ln,
+		cfg: cfg,
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.accepted++
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serveConn(conn)
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serveConn(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.cfg)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		var payload struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, "cannot parse payload")
+			continue
+		}
+		target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+		if err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			_ = target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			_, _ = io.Copy(ch, target)
+			_ = ch.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(target, ch)
+			_ = target.Close()
+		}()
+	}
+}
+
+// closeConns breaks all the SSH connections to s.
+func (s *testSSHServer) closeConns() {
+	s.mu.Lock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+	s.mu.Unlock()
+}
+
+func (s *testSSHServer) acceptedConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+func (s *testSSHServer) stop() {
+	_ = s.ln.Close()
+	s.closeConns()
+	s.wg.Wait()
+}
+
+func TestScrapeWorkSSHTunnel(t *testing.T) {
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer exporter.Close()
+	u, err := url.Parse(exporter.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", exporter.URL, err)
+	}
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate host key: %s", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("cannot create host key signer: %s", err)
+	}
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate client key: %s", err)
+	}
+	clientKey, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatalf("cannot create client public key: %s", err)
+	}
+	s := newTestSSHServer(t, hostKey, clientKey)
+	defer s.stop()
+
+	dir, err := ioutil.TempDir("", "promscrape-ssh-tunnel")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	clientPrivPKCS8, err := x509.MarshalPKCS8PrivateKey(clientPriv)
+	if err != nil {
+		t.Fatalf("cannot marshal client key: %s", err)
+	}
+	block := &pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: clientPrivPKCS8,
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "id_ed25519"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("cannot write client key: %s", err)
+	}
+	knownHosts := knownhosts.Line([]string{s.ln.Addr().String()}, hostKey.PublicKey()) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownHosts), 0600); err != nil {
+		t.Fatalf("cannot write known_hosts: %s", err)
+	}
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: ssh
+  ssh_tunnel:
+    host: %q
+    user: scraper
+    key_file: id_ed25519
+    known_hosts_file: known_hosts
+  static_configs:
+  - targets: [%q]
+    labels:
+      target: a
+  - targets: [%q]
+    labels:
+      target: b
+`, s.ln.Addr().String(), u.Host, u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), filepath.Join(dir, "scrape.yml")); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+
+	var mu sync.Mutex
+	values := make(map[string]float64)
+	pushData := func(wr *prompbmarshal.WriteRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ts := range wr.Timeseries {
+			name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+			values[name] = ts.Samples[0].Value
+		}
+	}
+	var scs []*scraper
+	for i := range sws {
+		scs = append(scs, newScraper(&sws[i], "test", pushData))
+	}
+	scrape := func(timestamp int64) {
+		t.Helper()
+		for _, sc := range scs {
+			values = make(map[string]float64)
+			if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if values["up"] != 1 || values["foo"] != 1 {
+				t.Fatalf("unexpected values scraped via ssh tunnel: %v", values)
+			}
+		}
+	}
+
+	// Targets of the scrape pool must share a single SSH connection.
+	timestamp := time.Now().UnixNano() / 1e6
+	scrape(timestamp)
+	scrape(timestamp + 10000)
+	if n := s.acceptedConns(); n != 1 {
+		t.Fatalf("unexpected number of ssh connections; got %d; want 1", n)
+	}
+
+	// Broken SSH connection must be re-established.
+	s.closeConns()
+	scrape(timestamp + 20000)
+	if n := s.acceptedConns(); n != 2 {
+		t.Fatalf("unexpected number of ssh connections after reconnect; got %d; want 2", n)
+	}
+}
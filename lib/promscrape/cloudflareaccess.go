@@ -0,0 +1,128 @@
+package promscrape
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// CloudflareAccessConfig contains settings for scraping targets protected by Cloudflare Access.
+//
+// Either service token with `client_id` and `client_secret_file` or `token_file` with Cloudflare Access token must be set.
+// The files are re-read on every scrape, so the credentials may be rotated without reloading -promscrape.config.
+type CloudflareAccessConfig struct {
+	ClientID         string `yaml:"client_id,omitempty"`
+	ClientIDFile     string `yaml:"client_id_file,omitempty"`
+	ClientSecretFile string `yaml:"client_secret_file,omitempty"`
+
+	// TokenFile is the path to file with Cloudflare Access token, which is sent in `cf-access-token` request header.
+	TokenFile string `yaml:"token_file,omitempty"`
+}
+
+// String returns string representation for cac, which is used in ScrapeWork.key.
+//
+// The contents of files isn't included, since it is re-read on every scrape.
+func (cac *CloudflareAccessConfig) String() string {
+	if cac == nil {
+		return ""
+	}
+	return fmt.Sprintf("client_id=%s, client_id_file=%s, client_secret_file=%s, token_file=%s", cac.ClientID, cac.ClientIDFile, cac.ClientSecretFile, cac.TokenFile)
+}
+
+func (cac *CloudflareAccessConfig) validate() error {
+	if cac.TokenFile != "" {
+		if cac.ClientID != "" || cac.ClientIDFile != "" || cac.ClientSecretFile != "" {
+			return fmt.Errorf("`token_file` cannot be used with `client_id`, `client_id_file` and `client_secret_file`")
+		}
+		return nil
+	}
+	if cac.ClientID == "" && cac.ClientIDFile == "" {
+		return fmt.Errorf("missing `client_id`, `client_id_file` or `token_file`")
+	}
+	if cac.ClientID != "" && cac.ClientIDFile != "" {
+		return fmt.Errorf("both `client_id`=%q and `client_id_file`=%q are set", cac.ClientID, cac.ClientIDFile)
+	}
+	if cac.ClientSecretFile == "" {
+		return fmt.Errorf("missing `client_secret_file`")
+	}
+	return nil
+}
+
+// getCloudflareAccessConfig returns a copy of cac with file paths relative to baseDir.
+//
+// The files are read in order to verify they are accessible during config load.
+func getCloudflareAccessConfig(cac *CloudflareAccessConfig, baseDir string) (*CloudflareAccessConfig, error) {
+	cacCopy := *cac
+	for _, path := range []*string{&cacCopy.ClientIDFile, &cacCopy.ClientSecretFile, &cacCopy.TokenFile} {
+		if *path != "" {
+			*path = getFilepath(baseDir, *path)
+		}
+	}
+	if _, err := cacCopy.getHeaders(); err != nil {
+		return nil, err
+	}
+	return &cacCopy, nil
+}
+
+// cloudflareAccessHeader is a request header for Cloudflare Access.
+type cloudflareAccessHeader struct {
+	name  string
+	value string
+}
+
+// getHeaders returns request headers for Cloudflare Access according to cac. The files from cac are re-read on every call.
+func (cac *CloudflareAccessConfig) getHeaders() ([]cloudflareAccessHeader, error) {
+	if cac.TokenFile != "" {
+		token, err := readCloudflareAccessFile(cac.TokenFile, "token_file")
+		if err != nil {
+			return nil, err
+		}
+		return []cloudflareAccessHeader{{"cf-access-token", token}}, nil
+	}
+	clientID := cac.ClientID
+	if cac.ClientIDFile != "" {
+		s, err := readCloudflareAccessFile(cac.ClientIDFile, "client_id_file")
+		if err != nil {
+			return nil, err
+		}
+		clientID = s
+	}
+	clientSecret, err := readCloudflareAccessFile(cac.ClientSecretFile, "client_secret_file")
+	if err != nil {
+		return nil, err
+	}
+	return []cloudflareAccessHeader{
+		{"CF-Access-Client-Id", clientID},
+		{"CF-Access-Client-Secret", clientSecret},
+	}, nil
+}
+
+// getCloudflareAccessHeaders returns request headers for scraping scrapeURL via Cloudflare Access. Nil is returned if `cloudflare_access` isn't configured for the target.
+func (c *client) getCloudflareAccessHeaders(scrapeURL string) ([]cloudflareAccessHeader, error) {
+	if c.cloudflareAccess == nil {
+		return nil, nil
+	}
+	headers, err := c.cloudflareAccess.getHeaders()
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain Cloudflare Access credentials for scraping %q: %w", scrapeURL, err)
+	}
+	return headers, nil
+}
+
+func readCloudflareAccessFile(path, option string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		cloudflareAccessReadErrors.Inc()
+		return "", fmt.Errorf("cannot read `%s` from `cloudflare_access`: %w", option, err)
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "" {
+		cloudflareAccessReadErrors.Inc()
+		return "", fmt.Errorf("`%s` %q from `cloudflare_access` is empty", option, path)
+	}
+	return s, nil
+}
+
+var cloudflareAccessReadErrors = metrics.NewCounter(`vm_promscrape_cloudflare_access_read_errors_total`)
@@ -0,0 +1,105 @@
+package promscrape
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestScrapeWorkCloudflareAccess(t *testing.T) {
+	var mu sync.Mutex
+	var headers http.Header
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = r.Header.Clone()
+		mu.Unlock()
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	dir := t.TempDir()
+	writeFile := func(name, data string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatalf("cannot write %q: %s", name, err)
+		}
+	}
+	newScraperForConfig := func(cloudflareAccess string) *scraper {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: cloudflare
+  cloudflare_access:
+%s
+  static_configs:
+  - targets: [%q]
+`, cloudflareAccess, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), filepath.Join(dir, "scrape.yml")); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		return newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+	}
+	f := func(sc *scraper, headersExpected map[string]string) {
+		t.Helper()
+		timestamp := time.Now().UnixNano() / 1e6
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for name, valueExpected := range headersExpected {
+			if value := headers.Get(name); value != valueExpected {
+				t.Fatalf("unexpected %s header; got %q; want %q", name, value, valueExpected)
+			}
+		}
+	}
+
+	// Service token
+	writeFile("secret", "secret-1\n")
+	sc := newScraperForConfig(`
+    client_id: id.access
+    client_secret_file: secret`)
+	f(sc, map[string]string{
+		"CF-Access-Client-Id":     "id.access",
+		"CF-Access-Client-Secret": "secret-1",
+		"cf-access-token":         "",
+	})
+
+	// The rotated secret must be sent without re-creating the scraper.
+	writeFile("secret", "secret-2\n")
+	f(sc, map[string]string{
+		"CF-Access-Client-Id":     "id.access",
+		"CF-Access-Client-Secret": "secret-2",
+	})
+
+	// Token
+	writeFile("token", "token-1")
+	sc = newScraperForConfig(`
+    token_file: token`)
+	f(sc, map[string]string{
+		"CF-Access-Client-Id":     "",
+		"CF-Access-Client-Secret": "",
+		"cf-access-token":         "token-1",
+	})
+	writeFile("token", "token-2")
+	f(sc, map[string]string{
+		"cf-access-token": "token-2",
+	})
+}
@@ -0,0 +1,66 @@
+package promscrape
+
+import (
+	"flag"
+	"io"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var compressionRatioWarnThreshold = flag.Float64("promscrape.compressionRatioWarnThreshold", 100, "The compression ratio for gzipped responses from scrape targets, "+
+	"which results in a warning in logs and in incrementing vm_promscrape_high_compression_ratio_total metric. Such responses are processed as usual. "+
+	"Suspiciously high compression ratio may indicate gzip bomb or misconfigured target. See also `max_decompressed_size` option in `scrape_config`. "+
+	"Zero value disables the check")
+
+// minCompressionRatioCheckSize is the minimum decompressed size for checking the compression ratio.
+//
+// Small responses may have high compression ratio, while they are harmless.
+const minCompressionRatioCheckSize = 64 * 1024
+
+// compressionRatioWarnInterval is the minimum interval in seconds between warnings about high compression ratio for a single target.
+const compressionRatioWarnInterval = 60
+
+// compressionRatioChecker checks the compression ratio for gzipped responses from a single target.
+type compressionRatioChecker struct {
+	lastWarnTime uint64
+}
+
+// check returns true if decompressedSize / compressedSize exceeds -promscrape.compressionRatioWarnThreshold.
+//
+// A warning is logged in this case at most once per compressionRatioWarnInterval.
+func (crc *compressionRatioChecker) check(scrapeURL string, compressedSize, decompressedSize int64) bool {
+	threshold := *compressionRatioWarnThreshold
+	if threshold <= 0 || compressedSize <= 0 || decompressedSize < minCompressionRatioCheckSize {
+		return false
+	}
+	ratio := float64(decompressedSize) / float64(compressedSize)
+	if ratio <= threshold {
+		return false
+	}
+	highCompressionRatio.Inc()
+	ct := fasttime.UnixTimestamp()
+	if lastWarnTime := atomic.LoadUint64(&crc.lastWarnTime); lastWarnTime+compressionRatioWarnInterval <= ct &&
+		atomic.CompareAndSwapUint64(&crc.lastWarnTime, lastWarnTime, ct) {
+		logger.Warnf("suspiciously high compression ratio %.1f for the gzipped response from %q: %d compressed bytes vs %d decompressed bytes; "+
+			"this may indicate gzip bomb or misconfigured target; the ratio exceeds -promscrape.compressionRatioWarnThreshold=%g",
+			ratio, scrapeURL, compressedSize, decompressedSize, threshold)
+	}
+	return true
+}
+
+// countingReadCloser counts the number of bytes read from the underlying io.ReadCloser.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+var highCompressionRatio = metrics.NewCounter(`vm_promscrape_high_compression_ratio_total`)
@@ -0,0 +1,136 @@
+package promscrape
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+)
+
+func TestCompressionRatioCheckerCheck(t *testing.T) {
+	f := func(compressedSize, decompressedSize int64, resultExpected bool) {
+		t.Helper()
+		var crc compressionRatioChecker
+		result := crc.check("http://foo/metrics", compressedSize, decompressedSize)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for compressedSize=%d, decompressedSize=%d; got %v; want %v", compressedSize, decompressedSize, result, resultExpected)
+		}
+	}
+	f(1000, 1000*1000, true)
+	f(100*1000, 1000*1000, false)
+	f(10*1000, 1000*1000, false)
+
+	// Small responses aren't checked.
+	f(10, 10*1000, false)
+
+	// Empty responses aren't checked.
+	f(0, 0, false)
+}
+
+func TestScrapeWorkGzipHighCompressionRatio(t *testing.T) {
+	gzipBody := func(body string) []byte {
+		var bb bytes.Buffer
+		zw := gzip.NewWriter(&bb)
+		fmt.Fprintf(zw, "%s", body)
+		_ = zw.Close()
+		return bb.Bytes()
+	}
+	// The highly compressible body.
+	highRatioBody := gzipBody(strings.Repeat("# padding\n", 100*1024) + "foo 1\n")
+	// The body with the usual compression ratio for scraped metrics.
+	rnd := rand.New(rand.NewSource(1))
+	var sb strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "foo{id=\"%x\"} %d\n", rnd.Uint64(), rnd.Int63())
+	}
+	normalBody := gzipBody(sb.String())
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.URL.Path == "/high" {
+			w.Write(highRatioBody)
+			return
+		}
+		w.Write(normalBody)
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	var logs bytes.Buffer
+	restore := logger.SetOutputForTests(&logs, "json")
+	defer restore()
+
+	f := func(streamParse bool, path string, warningExpected bool) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: compression_ratio
+  stream_parse: %v
+  metrics_path: %s
+  static_configs:
+  - targets: [%q]
+`, streamParse, path, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		up := float64(-1)
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "up" {
+					up = ts.Samples[0].Value
+				}
+			}
+		})
+		warningsExpected := 0
+		countExpected := uint64(0)
+		if warningExpected {
+			// The warning is throttled, while the metric is incremented on every scrape.
+			warningsExpected = 1
+			countExpected = 2
+		}
+		logs.Reset()
+		countPrev := highCompressionRatio.Get()
+		for i := 0; i < 2; i++ {
+			timestamp := int64(123000 + i*1000)
+			if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			// The scrape mustn't fail because of high compression ratio.
+			if up != 1 {
+				t.Fatalf("unexpected up value; got %v; want 1", up)
+			}
+		}
+		if n := strings.Count(logs.String(), "suspiciously high compression ratio"); n != warningsExpected {
+			t.Fatalf("unexpected number of warnings for path=%s, stream_parse=%v; got %d; want %d; logs:\n%s", path, streamParse, n, warningsExpected, logs.String())
+		}
+		if n := highCompressionRatio.Get() - countPrev; n != countExpected {
+			t.Fatalf("unexpected vm_promscrape_high_compression_ratio_total increase for path=%s, stream_parse=%v; got %d; want %d", path, streamParse, n, countExpected)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		f(streamParse, "/high", true)
+		f(streamParse, "/normal", false)
+	}
+}
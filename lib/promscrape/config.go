@@ -1,15 +1,23 @@
 package promscrape
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envtemplate"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
@@ -19,10 +27,13 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/dns"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/dockerswarm"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/ec2"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/ecs"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/eureka"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/gce"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/kubernetes"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/openstack"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
+	"github.com/valyala/fasttemplate"
 	"gopkg.in/yaml.v2"
 )
 
@@ -35,6 +46,11 @@ var (
 	dropOriginalLabels = flag.Bool("promscrape.dropOriginalLabels", false, "Whether to drop original labels for scrape targets at /targets and /api/v1/targets pages. "+
 		"This may be needed for reducing memory usage when original labels for big number of scrape targets occupy big amounts of memory. "+
 		"Note that this reduces debuggability for improper per-target relabeling configs")
+	expandEnvVars = flag.Bool("promscrape.config.expandEnvVars", false, "Whether to expand `$ENV_VAR`, `${ENV_VAR}` and `${ENV_VAR:-default}` placeholders "+
+		"in '-promscrape.config' with the corresponding environment variables. Use `$$` for `$` char in config values such as passwords when this option is enabled")
+	allowFileTargets = flag.Bool("promscrape.allowFileTargets", false, "Whether to allow reading metrics from local files via `file://` targets. "+
+		"It is disabled by default, since `__address__` may be set by service discovery or relabeling from untrusted sources such as Kubernetes annotations, "+
+		"which would allow reading arbitrary local files readable by vmagent")
 )
 
 // Config represents essential parts from Prometheus config defined at https://prometheus.io/docs/prometheus/latest/configuration/configuration/
@@ -42,8 +58,17 @@ type Config struct {
 	Global        GlobalConfig   `yaml:"global"`
 	ScrapeConfigs []ScrapeConfig `yaml:"scrape_configs"`
 
+	// Defaults contains `scrape_config` options, which are applied to all the `scrape_configs` unless they are overridden there.
+	//
+	// This option is supported only by lib/promscrape.
+	Defaults yaml.MapSlice `yaml:"defaults,omitempty"`
+
 	// This is set to the directory from where the config has been loaded.
 	baseDir string
+
+	// relabelFilesData contains the contents of all the `relabel_config_files` referred by the config.
+	// It is used for detecting changes in these files on config reload.
+	relabelFilesData []byte
 }
 
 // GlobalConfig represents essential parts for `global` section of Prometheus config.
@@ -53,8 +78,36 @@ type GlobalConfig struct {
 	ScrapeInterval time.Duration     `yaml:"scrape_interval,omitempty"`
 	ScrapeTimeout  time.Duration     `yaml:"scrape_timeout,omitempty"`
 	ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
+
+	// DefaultLabels are added to all the discovered targets with the lowest precedence.
+	//
+	// This option is supported only by lib/promscrape.
+	DefaultLabels map[string]string `yaml:"default_labels,omitempty"`
+
+	// AddScrapePoolLabel enables adding ScrapePoolLabelName label with `job_name` value to all the scraped series.
+	//
+	// These options are supported only by lib/promscrape.
+	AddScrapePoolLabel  bool   `yaml:"add_scrape_pool_label,omitempty"`
+	ScrapePoolLabelName string `yaml:"scrape_pool_label_name,omitempty"`
+
+	// RelabelConfigFiles contains paths or glob patterns for files with relabeling rules, which are appended to `relabel_configs`
+	// for all the scrape configs without `relabel_config_files` option.
+	//
+	// This option is supported only by lib/promscrape.
+	RelabelConfigFiles []string `yaml:"relabel_config_files,omitempty"`
 }
 
+// AuthProfile represents auth settings, which may be selected per each target via `__auth_profile__` label.
+type AuthProfile struct {
+	BasicAuth       *promauth.BasicAuthConfig `yaml:"basic_auth,omitempty"`
+	BearerToken     string                    `yaml:"bearer_token,omitempty"`
+	BearerTokenFile string                    `yaml:"bearer_token_file,omitempty"`
+	TLSConfig       *promauth.TLSConfig       `yaml:"tls_config,omitempty"`
+}
+
+// defaultScrapePoolLabelName is the default name for the label added when `add_scrape_pool_label` is set.
+const defaultScrapePoolLabelName = "scrape_pool"
+
 // ScrapeConfig represents essential parts for `scrape_config` section of Prometheus config.
 //
 // See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#scrape_config
@@ -71,6 +124,7 @@ type ScrapeConfig struct {
 	BearerToken          string                      `yaml:"bearer_token,omitempty"`
 	BearerTokenFile      string                      `yaml:"bearer_token_file,omitempty"`
 	TLSConfig            *promauth.TLSConfig         `yaml:"tls_config,omitempty"`
+	ProxyURL             *proxy.URL                  `yaml:"proxy_url,omitempty"`
 	StaticConfigs        []StaticConfig              `yaml:"static_configs,omitempty"`
 	FileSDConfigs        []FileSDConfig              `yaml:"file_sd_configs,omitempty"`
 	KubernetesSDConfigs  []kubernetes.SDConfig       `yaml:"kubernetes_sd_configs,omitempty"`
@@ -80,15 +134,159 @@ type ScrapeConfig struct {
 	DockerSwarmConfigs   []dockerswarm.SDConfig      `yaml:"dockerswarm_sd_configs,omitempty"`
 	DNSSDConfigs         []dns.SDConfig              `yaml:"dns_sd_configs,omitempty"`
 	EC2SDConfigs         []ec2.SDConfig              `yaml:"ec2_sd_configs,omitempty"`
+	ECSSDConfigs         []ecs.SDConfig              `yaml:"ecs_sd_configs,omitempty"`
 	GCESDConfigs         []gce.SDConfig              `yaml:"gce_sd_configs,omitempty"`
 	RelabelConfigs       []promrelabel.RelabelConfig `yaml:"relabel_configs,omitempty"`
 	MetricRelabelConfigs []promrelabel.RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
 	SampleLimit          int                         `yaml:"sample_limit,omitempty"`
+	TargetLimit          int                         `yaml:"target_limit,omitempty"`
+	ScrapeProtocols      []string                    `yaml:"scrape_protocols,omitempty"`
+
+	// ScrapeClassicHistograms isn't supported, since lib/promscrape scrapes targets only in text exposition format without native histograms.
+	// Classic histograms with `_bucket`, `_sum` and `_count` series are always ingested, so the config is rejected if it is set.
+	ScrapeClassicHistograms bool `yaml:"scrape_classic_histograms,omitempty"`
 
 	// These options are supported only by lib/promscrape.
-	DisableCompression bool `yaml:"disable_compression,omitempty"`
-	DisableKeepAlive   bool `yaml:"disable_keepalive,omitempty"`
-	StreamParse        bool `yaml:"stream_parse,omitempty"`
+	DisableCompression bool     `yaml:"disable_compression,omitempty"`
+	DisableKeepAlive   bool     `yaml:"disable_keepalive,omitempty"`
+	StreamParse        bool     `yaml:"stream_parse,omitempty"`
+	Enabled            *bool    `yaml:"enabled,omitempty"`
+	MetricsPaths       []string `yaml:"metrics_paths,omitempty"`
+	ScrapeRetries      int      `yaml:"scrape_retries,omitempty"`
+	AddScrapePoolLabel *bool    `yaml:"add_scrape_pool_label,omitempty"`
+	DropNaNInf         bool     `yaml:"drop_nan_inf,omitempty"`
+	ConditionalScrape  bool     `yaml:"conditional_scrape,omitempty"`
+	CheckContentType   bool     `yaml:"check_content_type,omitempty"`
+	ExpositionFormat   string   `yaml:"exposition_format,omitempty"`
+	GRPCMethod         string   `yaml:"grpc_method,omitempty"`
+
+	// ObjectStore contains settings for reading the newest object from `s3://bucket/prefix` targets.
+	// Such targets are allowed only if ObjectStore is set.
+	ObjectStore *ObjectStoreConfig `yaml:"object_store,omitempty"`
+
+	// MetricsPathsConcurrency limits the number of concurrent requests per target when scraping `metrics_paths`.
+	// defaultMetricsPathsConcurrency is used if it isn't set. The paths are scraped sequentially if it is set to 1.
+	MetricsPathsConcurrency int `yaml:"metrics_paths_concurrency,omitempty"`
+
+	// TargetLimitPriorityLabel is the target label with numeric priority, which is used for selecting targets to keep when TargetLimit is exceeded.
+	// Targets with bigger priority are kept. Targets are selected by their labels hash if TargetLimitPriorityLabel isn't set.
+	TargetLimitPriorityLabel string `yaml:"target_limit_priority_label,omitempty"`
+
+	// TimestampLimits contains limits for timestamps of the scraped samples. It can be used only with `honor_timestamps: true`.
+	TimestampLimits *TimestampLimitsConfig `yaml:"timestamp_limits,omitempty"`
+
+	// KafkaConsumer contains settings for consuming metrics from `kafka://topic` targets.
+	// Such targets are allowed only if KafkaConsumer is set.
+	KafkaConsumer *KafkaConsumerConfig `yaml:"kafka_consumer,omitempty"`
+
+	// SSHTunnel contains settings for dialing targets via SSH tunnel. This option is experimental.
+	SSHTunnel *SSHTunnelConfig `yaml:"ssh_tunnel,omitempty"`
+
+	// Login contains settings for obtaining session cookie from the target before scraping it.
+	Login *LoginConfig `yaml:"login,omitempty"`
+
+	// CloudflareAccess contains credentials for scraping targets protected by Cloudflare Access.
+	CloudflareAccess *CloudflareAccessConfig `yaml:"cloudflare_access,omitempty"`
+
+	// ScrapeTimeoutOffset is subtracted from scrape_timeout when limiting the duration of scrape requests,
+	// so the scraped response can be processed before scrape_timeout.
+	// defaultScrapeTimeoutOffset is used if it isn't set.
+	ScrapeTimeoutOffset *time.Duration `yaml:"scrape_timeout_offset,omitempty"`
+
+	// ParseTimeout limits the duration of parsing the scraped response in Prometheus text exposition format.
+	// The scrape fails if the parsing takes longer. The parsing duration isn't limited if ParseTimeout isn't set.
+	ParseTimeout time.Duration `yaml:"parse_timeout,omitempty"`
+
+	// CreatedSeries controls OpenMetrics `<name>_created` series. Supported values: forward, drop and attach.
+	// `_created` series are forwarded as is if CreatedSeries isn't set.
+	CreatedSeries string `yaml:"created_series,omitempty"`
+
+	// DedupWithinScrape controls how samples for the same series exposed multiple times in a single scrape response are collapsed.
+	// Supported values: last, max and sum. All the samples are pushed if DedupWithinScrape isn't set.
+	DedupWithinScrape string `yaml:"dedup_within_scrape,omitempty"`
+
+	// RequireMetrics contains metric names, which must be present in every scrape response. Scrapes without any of these metrics fail
+	// and their samples aren't ingested. This allows detecting responses with unrelated data such as login page.
+	RequireMetrics []string `yaml:"require_metrics,omitempty"`
+
+	// CardinalityTopN is the number of metric names with the biggest number of series per scrape, which are shown per each target at /api/v1/targets.
+	// The number of series per metric name isn't tracked if CardinalityTopN isn't set.
+	CardinalityTopN int `yaml:"cardinality_top_n,omitempty"`
+
+	// MaxDecompressedSize limits the size in bytes of decompressed gzip and zstd responses, while -promscrape.maxScrapeSize limits the size of compressed responses.
+	// Scrapes with bigger decompressed responses fail. -promscrape.maxScrapeSize is used if MaxDecompressedSize isn't set.
+	MaxDecompressedSize int `yaml:"max_decompressed_size,omitempty"`
+
+	// MaxRedirects limits the number of redirects followed per scrape. Redirects aren't followed if it is set to 0.
+	// A single redirect is followed if MaxRedirects isn't set, while net/http default policy is used for `stream_parse: true` targets.
+	MaxRedirects *int `yaml:"max_redirects,omitempty"`
+
+	// RedirectHostAllowlist contains hosts in the form `host` or `host:port`, which are allowed in redirects during scrapes.
+	// The scrape fails on redirect to other hosts except of the scrape target host. Redirects to any host are allowed if RedirectHostAllowlist isn't set.
+	RedirectHostAllowlist []string `yaml:"redirect_host_allowlist,omitempty"`
+
+	// Priority is used for obtaining free slots for scrapes if -promscrape.maxConcurrentScrapes is set.
+	// Targets with bigger priority are scraped first when the number of concurrent scrapes reaches the limit. Priority may be negative.
+	Priority int `yaml:"priority,omitempty"`
+
+	// ResponseCharset is the charset of scrape responses, which are transcoded to UTF-8 before parsing. It is sent in `Accept-Charset` request header.
+	// Responses are parsed as is if ResponseCharset isn't set. Stream parsing is disabled for non-UTF-8 charsets.
+	ResponseCharset string `yaml:"response_charset,omitempty"`
+
+	// InstanceTemplate overrides `instance` label for targets after relabeling. It may refer to `${host}` and `${port}` from `__address__`.
+	// `instance` label is set to `__address__` only if it is missing after relabeling when InstanceTemplate isn't set.
+	InstanceTemplate string `yaml:"instance_template,omitempty"`
+
+	// RelabelConfigFiles contains paths or glob patterns for files with relabeling rules, which are appended to RelabelConfigs in the declared order.
+	// It overrides `relabel_config_files` from `global` section.
+	RelabelConfigFiles []string `yaml:"relabel_config_files,omitempty"`
+
+	// KeepLabelNames and DropLabelNames are regexps for label names to keep and to drop in all the scraped series after metric_relabel_configs.
+	KeepLabelNames string `yaml:"keep_label_names,omitempty"`
+	DropLabelNames string `yaml:"drop_label_names,omitempty"`
+
+	// MetricNameValidation may be set to legacy or utf8. Series with metric names, which are invalid according to legacy Prometheus naming rules,
+	// are dropped or sanitized according to MetricNameValidationAction in legacy mode. Metric names are passed as is by default.
+	MetricNameValidation       string `yaml:"metric_name_validation,omitempty"`
+	MetricNameValidationAction string `yaml:"metric_name_validation_action,omitempty"`
+
+	// HealthMetrics may be set to per_target or aggregated. Pool-level `scrape_pool_*` series are added instead of per-target
+	// `up`, `scrape_duration_seconds`, etc. series in aggregated mode. HealthMetricsLabels are added to these series.
+	HealthMetrics       string            `yaml:"health_metrics,omitempty"`
+	HealthMetricsLabels map[string]string `yaml:"health_metrics_labels,omitempty"`
+
+	// SecretsFile is the path to file with `basic_auth`, `bearer_token`, `bearer_token_file` and `tls_config` sections.
+	// The file is watched for changes, so scrape clients are re-created without config reload when the file is changed.
+	// See -promscrape.secretsFileCheckInterval.
+	SecretsFile string `yaml:"secrets_file,omitempty"`
+
+	// ScrapeIntervalHeader is the name of response header with the scrape interval hint from the target.
+	// The target is scraped at the interval from the header limited by MinScrapeInterval and MaxScrapeInterval.
+	// MinScrapeInterval defaults to scrape_timeout, while MaxScrapeInterval defaults to 10 scrape intervals.
+	ScrapeIntervalHeader string        `yaml:"scrape_interval_header,omitempty"`
+	MinScrapeInterval    time.Duration `yaml:"min_scrape_interval,omitempty"`
+	MaxScrapeInterval    time.Duration `yaml:"max_scrape_interval,omitempty"`
+
+	// Method is the http method for scrape requests. Supported values: GET and POST. GET is used by default.
+	// Body or the contents of BodyFile is sent with ContentType in POST requests. ContentType defaults to application/json.
+	Method      string `yaml:"method,omitempty"`
+	Body        string `yaml:"body,omitempty"`
+	BodyFile    string `yaml:"body_file,omitempty"`
+	ContentType string `yaml:"content_type,omitempty"`
+
+	// CircuitBreakerFailures is the number of consecutive connection failures, after which the target isn't scraped
+	// for CircuitBreakerCooldown. CircuitBreakerCooldown defaults to 5 scrape intervals.
+	CircuitBreakerFailures int           `yaml:"circuit_breaker_failures,omitempty"`
+	CircuitBreakerCooldown time.Duration `yaml:"circuit_breaker_cooldown,omitempty"`
+
+	// AuthProfiles contains named auth settings, which may be selected per each target
+	// by setting `__auth_profile__` label during relabeling.
+	AuthProfiles map[string]AuthProfile `yaml:"auth_profiles,omitempty"`
+
+	// MetricRelabelProfiles contains named `metric_relabel_configs`, which may be selected per each target
+	// by setting `__metric_relabel_profile__` label during relabeling.
+	// The selected profile is applied after `metric_relabel_configs`.
+	MetricRelabelProfiles map[string][]promrelabel.RelabelConfig `yaml:"metric_relabel_profiles,omitempty"`
 
 	// This is set in loadConfig
 	swc *scrapeWorkConfig
@@ -124,13 +322,22 @@ func loadStaticConfigs(path string) ([]StaticConfig, error) {
 }
 
 // loadConfig loads Prometheus config from the given path.
+//
+// The path may point either to local file or to http(s) url.
 func loadConfig(path string) (cfg *Config, data []byte, err error) {
-	data, err = ioutil.ReadFile(path)
+	data, err = readConfigData(path)
 	if err != nil {
 		return nil, nil, fmt.Errorf("cannot read Prometheus config from %q: %w", path, err)
 	}
+	dataExpanded := data
+	if *expandEnvVars {
+		dataExpanded, err = envtemplate.ReplaceShellVars(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot expand env vars in Prometheus config from %q: %w", path, err)
+		}
+	}
 	var cfgObj Config
-	if err := cfgObj.parse(data, path); err != nil {
+	if err := cfgObj.parse(dataExpanded, path); err != nil {
 		return nil, nil, fmt.Errorf("cannot parse Prometheus config from %q: %w", path, err)
 	}
 	return &cfgObj, data, nil
@@ -145,6 +352,15 @@ func (cfg *Config) parse(data []byte, path string) error {
 	if err := unmarshalMaybeStrict(data, cfg); err != nil {
 		return fmt.Errorf("cannot unmarshal data: %w", err)
 	}
+	if len(cfg.Defaults) > 0 {
+		if err := cfg.applyDefaults(data); err != nil {
+			return fmt.Errorf("cannot apply `defaults` section: %w", err)
+		}
+	}
+	if isHTTPURL(path) {
+		// Relative paths in the config fetched from url are resolved against the current working directory.
+		path = "./config.yml"
+	}
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return fmt.Errorf("cannot obtain abs path for %q: %w", path, err)
@@ -156,11 +372,73 @@ func (cfg *Config) parse(data []byte, path string) error {
 		if err != nil {
 			return fmt.Errorf("cannot parse `scrape_config` #%d: %w", i+1, err)
 		}
+		if len(sc.StaticConfigs) > 0 {
+			swc.staticHash, swc.staticHashOK = getStaticConfigsHash(sc, swc, &cfg.Global)
+		}
 		sc.swc = swc
+		cfg.relabelFilesData = append(cfg.relabelFilesData, swc.relabelFilesData...)
 	}
 	return nil
 }
 
+// applyDefaults merges cfg.Defaults into every scrape config from data.
+//
+// Options set in scrape config take precedence over options from cfg.Defaults. Options are merged only at the top level,
+// e.g. `tls_config` from scrape config replaces the whole `tls_config` from cfg.Defaults.
+func (cfg *Config) applyDefaults(data []byte) error {
+	var raw struct {
+		Defaults      yaml.MapSlice   `yaml:"defaults"`
+		ScrapeConfigs []yaml.MapSlice `yaml:"scrape_configs"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, item := range raw.Defaults {
+		if item.Key == "job_name" {
+			return fmt.Errorf("`job_name` cannot be set in `defaults` section")
+		}
+	}
+	// Verify defaults, since they may remain unused if scrape_configs are empty.
+	var scDefaults ScrapeConfig
+	if err := unmarshalMapSlice(raw.Defaults, &scDefaults); err != nil {
+		return err
+	}
+	if len(raw.ScrapeConfigs) != len(cfg.ScrapeConfigs) {
+		logger.Panicf("BUG: unexpected number of scrape configs; got %d; want %d", len(raw.ScrapeConfigs), len(cfg.ScrapeConfigs))
+	}
+	for i, rsc := range raw.ScrapeConfigs {
+		merged := append(yaml.MapSlice{}, rsc...)
+		for _, item := range raw.Defaults {
+			if !hasMapSliceKey(rsc, item.Key) {
+				merged = append(merged, item)
+			}
+		}
+		var sc ScrapeConfig
+		if err := unmarshalMapSlice(merged, &sc); err != nil {
+			return fmt.Errorf("cannot apply `defaults` to `scrape_config` #%d: %w", i+1, err)
+		}
+		cfg.ScrapeConfigs[i] = sc
+	}
+	return nil
+}
+
+func unmarshalMapSlice(ms yaml.MapSlice, dst interface{}) error {
+	data, err := yaml.Marshal(ms)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %v: %w", ms, err)
+	}
+	return unmarshalMaybeStrict(data, dst)
+}
+
+func hasMapSliceKey(ms yaml.MapSlice, key interface{}) bool {
+	for _, item := range ms {
+		if item.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
 func unmarshalMaybeStrict(data []byte, dst interface{}) error {
 	data = envtemplate.Replace(data)
 	var err error
@@ -172,6 +450,51 @@ func unmarshalMaybeStrict(data []byte, dst interface{}) error {
 	return err
 }
 
+// getScrapePools returns `job_name/type` entries for all the enabled scrape configs in cfg.
+//
+// An entry is returned per each service discovery type configured in the scrape config, where type is the name of the corresponding section
+// such as `static_configs` or `kubernetes_sd_configs`. Entries are returned regardless of whether the scrape config has discovered targets.
+func (cfg *Config) getScrapePools() []string {
+	var pools []string
+	for i := range cfg.ScrapeConfigs {
+		sc := &cfg.ScrapeConfigs[i]
+		if sc.swc != nil && !sc.swc.enabled {
+			continue
+		}
+		add := func(sdType string, n int) {
+			if n > 0 {
+				pools = append(pools, sc.JobName+"/"+sdType)
+			}
+		}
+		add("static_configs", len(sc.StaticConfigs))
+		add("file_sd_configs", len(sc.FileSDConfigs))
+		add("kubernetes_sd_configs", len(sc.KubernetesSDConfigs))
+		add("openstack_sd_configs", len(sc.OpenStackSDConfigs))
+		add("consul_sd_configs", len(sc.ConsulSDConfigs))
+		add("eureka_sd_configs", len(sc.EurekaSDConfigs))
+		add("dns_sd_configs", len(sc.DNSSDConfigs))
+		add("ec2_sd_configs", len(sc.EC2SDConfigs))
+		add("ecs_sd_configs", len(sc.ECSSDConfigs))
+		add("gce_sd_configs", len(sc.GCESDConfigs))
+		add("dockerswarm_sd_configs", len(sc.DockerSwarmConfigs))
+	}
+	return pools
+}
+
+// getScrapePoolNames returns sorted `job_name` values for all the enabled scrape configs in cfg.
+func (cfg *Config) getScrapePoolNames() []string {
+	var names []string
+	for i := range cfg.ScrapeConfigs {
+		sc := &cfg.ScrapeConfigs[i]
+		if sc.swc != nil && !sc.swc.enabled {
+			continue
+		}
+		names = append(names, sc.JobName)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func getSWSByJob(sws []ScrapeWork) map[string][]ScrapeWork {
 	m := make(map[string][]ScrapeWork)
 	for _, sw := range sws {
@@ -186,6 +509,9 @@ func (cfg *Config) getKubernetesSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.KubernetesSDConfigs {
@@ -214,6 +540,9 @@ func (cfg *Config) getOpenStackSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.OpenStackSDConfigs {
@@ -242,6 +571,9 @@ func (cfg *Config) getDockerSwarmSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.DockerSwarmConfigs {
@@ -270,6 +602,9 @@ func (cfg *Config) getConsulSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.ConsulSDConfigs {
@@ -298,6 +633,9 @@ func (cfg *Config) getEurekaSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.EurekaSDConfigs {
@@ -326,6 +664,9 @@ func (cfg *Config) getDNSSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.DNSSDConfigs {
@@ -354,6 +695,9 @@ func (cfg *Config) getEC2SDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.EC2SDConfigs {
@@ -376,12 +720,46 @@ func (cfg *Config) getEC2SDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	return dst
 }
 
+// getECSSDScrapeWork returns `ecs_sd_configs` ScrapeWork from cfg.
+func (cfg *Config) getECSSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
+	swsPrevByJob := getSWSByJob(prev)
+	dst := make([]ScrapeWork, 0, len(prev))
+	for i := range cfg.ScrapeConfigs {
+		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
+		dstLen := len(dst)
+		ok := true
+		for j := range sc.ECSSDConfigs {
+			sdc := &sc.ECSSDConfigs[j]
+			var okLocal bool
+			dst, okLocal = appendECSScrapeWork(dst, sdc, sc.swc)
+			if ok {
+				ok = okLocal
+			}
+		}
+		if ok {
+			continue
+		}
+		swsPrev := swsPrevByJob[sc.swc.jobName]
+		if len(swsPrev) > 0 {
+			logger.Errorf("there were errors when discovering ecs targets for job %q, so preserving the previous targets", sc.swc.jobName)
+			dst = append(dst[:dstLen], swsPrev...)
+		}
+	}
+	return dst
+}
+
 // getGCESDScrapeWork returns `gce_sd_configs` ScrapeWork from cfg.
 func (cfg *Config) getGCESDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	swsPrevByJob := getSWSByJob(prev)
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		dstLen := len(dst)
 		ok := true
 		for j := range sc.GCESDConfigs {
@@ -420,6 +798,9 @@ func (cfg *Config) getFileSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 	dst := make([]ScrapeWork, 0, len(prev))
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		for j := range sc.FileSDConfigs {
 			sdc := &sc.FileSDConfigs[j]
 			dst = sdc.appendScrapeWork(dst, swsMapPrev, cfg.baseDir, sc.swc)
@@ -430,102 +811,569 @@ func (cfg *Config) getFileSDScrapeWork(prev []ScrapeWork) []ScrapeWork {
 
 // getStaticScrapeWork returns `static_configs` ScrapeWork from from cfg.
 func (cfg *Config) getStaticScrapeWork() []ScrapeWork {
+	key, ok := cfg.getStaticScrapeWorkKey()
+	if ok {
+		if sws, ok := staticScrapeWorkCacheGlobal.get(key); ok {
+			// Fast path - re-use ScrapeWork for unchanged `static_configs`.
+			return sws
+		}
+	}
 	var dst []ScrapeWork
+	var dts []droppedTarget
 	for i := range cfg.ScrapeConfigs {
 		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled {
+			continue
+		}
 		for j := range sc.StaticConfigs {
 			stc := &sc.StaticConfigs[j]
-			dst = stc.appendScrapeWork(dst, sc.swc, nil)
+			dst = stc.appendScrapeWork(dst, sc.swc, nil, &dts)
 		}
 	}
+	if ok {
+		staticScrapeWorkCacheGlobal.set(key, dst, dts)
+	}
 	return dst
 }
 
+// getScrapeRequestBody returns the http method, the request body and the content-type for scrape requests according to sc.
+//
+// Empty method is returned for GET requests.
+// Relative path in `body_file` is resolved against baseDir.
+func getScrapeRequestBody(sc *ScrapeConfig, baseDir string) (string, string, string, error) {
+	method := strings.ToUpper(sc.Method)
+	switch method {
+	case "", "GET":
+		if sc.Body != "" || sc.BodyFile != "" || sc.ContentType != "" {
+			return "", "", "", fmt.Errorf("`body`, `body_file` and `content_type` can be set only for `method: POST`")
+		}
+		return "", "", "", nil
+	case "POST":
+		if sc.Body != "" && sc.BodyFile != "" {
+			return "", "", "", fmt.Errorf("`body` and `body_file` cannot be set simultaneously")
+		}
+		body := sc.Body
+		if sc.BodyFile != "" {
+			path := getFilepath(baseDir, sc.BodyFile)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", "", "", fmt.Errorf("cannot read `body_file` %q: %w", path, err)
+			}
+			body = string(data)
+		}
+		contentType := sc.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		return method, body, contentType, nil
+	default:
+		return "", "", "", fmt.Errorf("unsupported `method` %q; supported values: GET, POST", sc.Method)
+	}
+}
+
 func getScrapeWorkConfig(sc *ScrapeConfig, baseDir string, globalCfg *GlobalConfig) (*scrapeWorkConfig, error) {
 	jobName := sc.JobName
 	if jobName == "" {
 		return nil, fmt.Errorf("missing `job_name` field in `scrape_config`")
 	}
-	scrapeInterval := sc.ScrapeInterval
-	if scrapeInterval <= 0 {
-		scrapeInterval = globalCfg.ScrapeInterval
-		if scrapeInterval <= 0 {
-			scrapeInterval = defaultScrapeInterval
-		}
+	scrapeInterval, scrapeTimeout := getScrapeIntervalTimeout(sc, globalCfg)
+	scrapeTimeoutOffset, err := getScrapeTimeoutOffset(sc.ScrapeTimeoutOffset, scrapeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `scrape_timeout_offset` for `job_name` %q: %w", jobName, err)
 	}
-	scrapeTimeout := sc.ScrapeTimeout
-	if scrapeTimeout <= 0 {
-		scrapeTimeout = globalCfg.ScrapeTimeout
-		if scrapeTimeout <= 0 {
-			scrapeTimeout = defaultScrapeTimeout
-		}
+	parseTimeout := sc.ParseTimeout
+	if parseTimeout < 0 {
+		return nil, fmt.Errorf("`parse_timeout` for `job_name` %q cannot be negative; got %s", jobName, parseTimeout)
+	}
+	if parseTimeout > 0 && sc.ConditionalScrape {
+		// Rows for `304 Not Modified` responses cannot be obtained if the parsing of the original response has been aborted.
+		return nil, fmt.Errorf("`parse_timeout` for `job_name` %q cannot be used with `conditional_scrape`", jobName)
 	}
 	honorLabels := sc.HonorLabels
 	honorTimestamps := sc.HonorTimestamps
+	metricsPaths := sc.MetricsPaths
+	for _, path := range metricsPaths {
+		if path == "" {
+			return nil, fmt.Errorf("`metrics_paths` for `job_name` %q cannot contain empty paths", jobName)
+		}
+	}
 	metricsPath := sc.MetricsPath
 	if metricsPath == "" {
 		metricsPath = "/metrics"
+		if len(metricsPaths) > 0 {
+			metricsPath = metricsPaths[0]
+		}
+	}
+	if sc.MetricsPathsConcurrency < 0 {
+		return nil, fmt.Errorf("`metrics_paths_concurrency` for `job_name` %q cannot be negative; got %d", jobName, sc.MetricsPathsConcurrency)
+	}
+	if sc.MetricsPathsConcurrency > 0 && len(metricsPaths) == 0 {
+		return nil, fmt.Errorf("`metrics_paths_concurrency` for `job_name` %q requires `metrics_paths`", jobName)
+	}
+	if sc.ScrapeRetries < 0 {
+		return nil, fmt.Errorf("`scrape_retries` for `job_name` %q cannot be negative; got %d", jobName, sc.ScrapeRetries)
+	}
+	addScrapePoolLabel := globalCfg.AddScrapePoolLabel
+	if sc.AddScrapePoolLabel != nil {
+		addScrapePoolLabel = *sc.AddScrapePoolLabel
+	}
+	scrapePoolLabelName := ""
+	if addScrapePoolLabel {
+		scrapePoolLabelName = globalCfg.ScrapePoolLabelName
+		if scrapePoolLabelName == "" {
+			scrapePoolLabelName = defaultScrapePoolLabelName
+		}
+	}
+	if err := validateScrapeProtocols(sc.ScrapeProtocols); err != nil {
+		return nil, fmt.Errorf("invalid `scrape_protocols` for `job_name` %q: %w", jobName, err)
+	}
+	if sc.ScrapeClassicHistograms {
+		return nil, fmt.Errorf("`scrape_classic_histograms` for `job_name` %q isn't supported, since native histograms aren't scraped; "+
+			"classic histograms are always ingested, so just remove this option", jobName)
+	}
+	if err := validateExpositionFormat(sc.ExpositionFormat); err != nil {
+		return nil, fmt.Errorf("invalid `exposition_format` for `job_name` %q: %w", jobName, err)
+	}
+	if sc.ExpositionFormat == expositionFormatRemoteWrite && sc.ConditionalScrape {
+		return nil, fmt.Errorf("`conditional_scrape` for `job_name` %q cannot be used with `exposition_format: %s`", jobName, expositionFormatRemoteWrite)
+	}
+	keepLabelNames, err := compileLabelNamesRegexp(sc.KeepLabelNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `keep_label_names` for `job_name` %q: %w", jobName, err)
+	}
+	dropLabelNames, err := compileLabelNamesRegexp(sc.DropLabelNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `drop_label_names` for `job_name` %q: %w", jobName, err)
+	}
+	if err := validateMetricNameValidation(sc.MetricNameValidation, sc.MetricNameValidationAction); err != nil {
+		return nil, fmt.Errorf("invalid `metric_name_validation` for `job_name` %q: %w", jobName, err)
+	}
+	nameAction := sc.MetricNameValidationAction
+	if sc.MetricNameValidation == metricNameValidationLegacy && nameAction == "" {
+		nameAction = metricNameActionDrop
+	}
+	if err := validateHealthMetrics(sc.HealthMetrics); err != nil {
+		return nil, fmt.Errorf("invalid `health_metrics` for `job_name` %q: %w", jobName, err)
+	}
+	if err := validateCreatedSeries(sc.CreatedSeries); err != nil {
+		return nil, fmt.Errorf("invalid `created_series` for `job_name` %q: %w", jobName, err)
+	}
+	if needCreatedSeriesProcessing(sc.CreatedSeries) && sc.StreamParse {
+		return nil, fmt.Errorf("`created_series: %s` cannot be used together with `stream_parse: true` for `job_name` %q", sc.CreatedSeries, jobName)
+	}
+	if err := validateDedupWithinScrape(sc.DedupWithinScrape); err != nil {
+		return nil, fmt.Errorf("invalid `dedup_within_scrape` for `job_name` %q: %w", jobName, err)
+	}
+	if sc.CardinalityTopN < 0 {
+		return nil, fmt.Errorf("`cardinality_top_n` for `job_name` %q cannot be negative; got %d", jobName, sc.CardinalityTopN)
+	}
+	if sc.MaxDecompressedSize < 0 {
+		return nil, fmt.Errorf("`max_decompressed_size` for `job_name` %q cannot be negative; got %d", jobName, sc.MaxDecompressedSize)
+	}
+	if sc.MaxRedirects != nil && *sc.MaxRedirects < 0 {
+		return nil, fmt.Errorf("`max_redirects` for `job_name` %q cannot be negative; got %d", jobName, *sc.MaxRedirects)
+	}
+	if err := validateRedirectHostAllowlist(sc.RedirectHostAllowlist); err != nil {
+		return nil, fmt.Errorf("invalid `redirect_host_allowlist` for `job_name` %q: %w", jobName, err)
+	}
+	if err := validateResponseCharset(sc.ResponseCharset); err != nil {
+		return nil, fmt.Errorf("invalid `response_charset` for `job_name` %q: %w", jobName, err)
+	}
+	if sc.ResponseCharset != "" && sc.ExpositionFormat == expositionFormatRemoteWrite {
+		return nil, fmt.Errorf("`response_charset` for `job_name` %q cannot be used with `exposition_format: %s`", jobName, expositionFormatRemoteWrite)
+	}
+	if sc.InstanceTemplate != "" {
+		if err := validateInstanceTemplate(sc.InstanceTemplate); err != nil {
+			return nil, fmt.Errorf("invalid `instance_template`=%q for `job_name` %q: %w", sc.InstanceTemplate, jobName, err)
+		}
+	}
+	if sc.DedupWithinScrape != "" && sc.StreamParse {
+		return nil, fmt.Errorf("`dedup_within_scrape` cannot be used together with `stream_parse: true` for `job_name` %q", jobName)
+	}
+	if err := validateRequireMetrics(sc.RequireMetrics); err != nil {
+		return nil, fmt.Errorf("invalid `require_metrics` for `job_name` %q: %w", jobName, err)
+	}
+	if len(sc.RequireMetrics) > 0 && sc.StreamParse {
+		return nil, fmt.Errorf("`require_metrics` cannot be used together with `stream_parse: true` for `job_name` %q", jobName)
+	}
+	cbFailures := sc.CircuitBreakerFailures
+	if cbFailures < 0 {
+		return nil, fmt.Errorf("`circuit_breaker_failures` for `job_name` %q cannot be negative; got %d", jobName, cbFailures)
+	}
+	cbCooldown := sc.CircuitBreakerCooldown
+	if cbCooldown < 0 {
+		return nil, fmt.Errorf("`circuit_breaker_cooldown` for `job_name` %q cannot be negative; got %s", jobName, cbCooldown)
+	}
+	if cbFailures > 0 && cbCooldown == 0 {
+		cbCooldown = 5 * scrapeInterval
+	}
+	minInterval := sc.MinScrapeInterval
+	maxInterval := sc.MaxScrapeInterval
+	if sc.ScrapeIntervalHeader == "" {
+		if minInterval != 0 || maxInterval != 0 {
+			return nil, fmt.Errorf("`min_scrape_interval` and `max_scrape_interval` for `job_name` %q can be set only together with `scrape_interval_header`", jobName)
+		}
+	} else {
+		if minInterval < 0 || maxInterval < 0 {
+			return nil, fmt.Errorf("`min_scrape_interval` and `max_scrape_interval` for `job_name` %q cannot be negative; got %s and %s", jobName, minInterval, maxInterval)
+		}
+		if minInterval == 0 {
+			minInterval = scrapeTimeout
+		}
+		if maxInterval == 0 {
+			maxInterval = 10 * scrapeInterval
+		}
+		if minInterval > maxInterval {
+			return nil, fmt.Errorf("`min_scrape_interval`=%s for `job_name` %q cannot exceed `max_scrape_interval`=%s", minInterval, jobName, maxInterval)
+		}
 	}
 	scheme := sc.Scheme
 	if scheme == "" {
 		scheme = "http"
 	}
-	if scheme != "http" && scheme != "https" {
-		return nil, fmt.Errorf("unexpected `scheme` for `job_name` %q: %q; supported values: http or https", jobName, scheme)
+	if scheme != "http" && scheme != "https" && scheme != "auto" {
+		return nil, fmt.Errorf("unexpected `scheme` for `job_name` %q: %q; supported values: http, https or auto", jobName, scheme)
 	}
-	params := sc.Params
-	ac, err := promauth.NewConfig(baseDir, sc.BasicAuth, sc.BearerToken, sc.BearerTokenFile, sc.TLSConfig)
+	if scheme == "auto" && len(metricsPaths) > 0 {
+		return nil, fmt.Errorf("`scheme: auto` for `job_name` %q cannot be used with `metrics_paths`", jobName)
+	}
+	method, body, contentType, err := getScrapeRequestBody(sc, baseDir)
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse auth config for `job_name` %q: %w", jobName, err)
+		return nil, fmt.Errorf("invalid `method` for `job_name` %q: %w", jobName, err)
+	}
+	if method == "POST" && (sc.ConditionalScrape || sc.GRPCMethod != "") {
+		return nil, fmt.Errorf("`method: POST` for `job_name` %q cannot be used with `conditional_scrape` and `grpc_method`", jobName)
+	}
+	if sc.GRPCMethod != "" {
+		if newGRPCReadData == nil {
+			return nil, fmt.Errorf("`grpc_method` for `job_name` %q requires building vmagent with `grpc` build tag", jobName)
+		}
+		if err := validateGRPCMethod(sc.GRPCMethod); err != nil {
+			return nil, fmt.Errorf("invalid `grpc_method` for `job_name` %q: %w", jobName, err)
+		}
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("`grpc_method` for `job_name` %q requires `scheme: http` or `scheme: https`; got %q", jobName, scheme)
+		}
+		if sc.StreamParse || len(metricsPaths) > 0 || sc.ConditionalScrape || sc.ExpositionFormat != "" {
+			return nil, fmt.Errorf("`grpc_method` for `job_name` %q cannot be used with `stream_parse`, `metrics_paths`, `conditional_scrape` and `exposition_format`", jobName)
+		}
+	}
+	if sc.ObjectStore != nil && sc.ObjectStore.Region == "" {
+		return nil, fmt.Errorf("missing `region` in `object_store` for `job_name` %q", jobName)
+	}
+	if sc.TargetLimit < 0 {
+		return nil, fmt.Errorf("`target_limit` for `job_name` %q cannot be negative; got %d", jobName, sc.TargetLimit)
+	}
+	if sc.TargetLimitPriorityLabel != "" && sc.TargetLimit == 0 {
+		return nil, fmt.Errorf("`target_limit_priority_label` for `job_name` %q requires `target_limit`", jobName)
+	}
+	if sc.TimestampLimits != nil {
+		if !honorTimestamps {
+			return nil, fmt.Errorf("`timestamp_limits` for `job_name` %q requires `honor_timestamps: true`", jobName)
+		}
+		if err := sc.TimestampLimits.validate(); err != nil {
+			return nil, fmt.Errorf("invalid `timestamp_limits` for `job_name` %q: %w", jobName, err)
+		}
+	}
+	if sc.KafkaConsumer != nil {
+		if err := sc.KafkaConsumer.validate(); err != nil {
+			return nil, fmt.Errorf("invalid `kafka_consumer` for `job_name` %q: %w", jobName, err)
+		}
+		if runKafkaConsumer == nil {
+			return nil, fmt.Errorf("`kafka_consumer` for `job_name` %q requires building vmagent with `kafka` build tag", jobName)
+		}
+	}
+	var sshTunnel *SSHTunnelConfig
+	if sc.SSHTunnel != nil {
+		if sshTunnelDial == nil {
+			return nil, fmt.Errorf("`ssh_tunnel` for `job_name` %q requires building vmagent with `ssh` build tag", jobName)
+		}
+		if err := sc.SSHTunnel.validate(); err != nil {
+			return nil, fmt.Errorf("invalid `ssh_tunnel` for `job_name` %q: %w", jobName, err)
+		}
+		if sc.ProxyURL != nil || sc.GRPCMethod != "" {
+			return nil, fmt.Errorf("`ssh_tunnel` for `job_name` %q cannot be used with `proxy_url` and `grpc_method`", jobName)
+		}
+		sshTunnel = getSSHTunnelConfig(sc.SSHTunnel, baseDir)
+	}
+	if sc.Login != nil {
+		if err := sc.Login.validate(); err != nil {
+			return nil, fmt.Errorf("invalid `login` for `job_name` %q: %w", jobName, err)
+		}
+		if sc.GRPCMethod != "" || sc.ObjectStore != nil {
+			return nil, fmt.Errorf("`login` for `job_name` %q cannot be used with `grpc_method` and `object_store`", jobName)
+		}
+	}
+	var cloudflareAccess *CloudflareAccessConfig
+	if sc.CloudflareAccess != nil {
+		if err := sc.CloudflareAccess.validate(); err != nil {
+			return nil, fmt.Errorf("invalid `cloudflare_access` for `job_name` %q: %w", jobName, err)
+		}
+		if sc.GRPCMethod != "" || sc.ObjectStore != nil {
+			return nil, fmt.Errorf("`cloudflare_access` for `job_name` %q cannot be used with `grpc_method` and `object_store`", jobName)
+		}
+		cac, err := getCloudflareAccessConfig(sc.CloudflareAccess, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid `cloudflare_access` for `job_name` %q: %w", jobName, err)
+		}
+		cloudflareAccess = cac
+	}
+	params := sc.Params
+	tlsConfig := sc.TLSConfig
+	var tlsCertFileTemplate, tlsKeyFileTemplate, tlsServerNameTemplate string
+	if tlsConfig != nil && (hasLabelsTemplate(tlsConfig.CertFile) || hasLabelsTemplate(tlsConfig.KeyFile)) {
+		// Client certificate is loaded per each target after the relabeling. See getTargetAuthConfig.
+		tlsCertFileTemplate = tlsConfig.CertFile
+		tlsKeyFileTemplate = tlsConfig.KeyFile
+		tlsConfigCopy := *tlsConfig
+		tlsConfigCopy.CertFile = ""
+		tlsConfigCopy.KeyFile = ""
+		tlsConfig = &tlsConfigCopy
+	}
+	if tlsConfig != nil && hasLabelsTemplate(tlsConfig.ServerName) {
+		// Server name is set per each target after the relabeling. See getTargetAuthConfig.
+		tlsServerNameTemplate = tlsConfig.ServerName
+		tlsConfigCopy := *tlsConfig
+		tlsConfigCopy.ServerName = ""
+		tlsConfig = &tlsConfigCopy
+	}
+	var secretsFile string
+	var ac *promauth.Config
+	if sc.SecretsFile != "" {
+		if sc.BasicAuth != nil || sc.BearerToken != "" || sc.BearerTokenFile != "" || sc.TLSConfig != nil {
+			return nil, fmt.Errorf("`secrets_file` for `job_name` %q cannot be used with `basic_auth`, `bearer_token`, `bearer_token_file` and `tls_config`", jobName)
+		}
+		secretsFile = getFilepath(baseDir, sc.SecretsFile)
+		ac, err = loadSecretsFile(secretsFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load auth config for `job_name` %q: %w", jobName, err)
+		}
+	} else {
+		ac, err = promauth.NewConfig(baseDir, sc.BasicAuth, sc.BearerToken, sc.BearerTokenFile, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse auth config for `job_name` %q: %w", jobName, err)
+		}
 	}
 	var relabelConfigs []promrelabel.ParsedRelabelConfig
 	relabelConfigs, err = promrelabel.ParseRelabelConfigs(relabelConfigs[:0], sc.RelabelConfigs)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse `relabel_configs` for `job_name` %q: %w", jobName, err)
 	}
+	relabelConfigFiles := globalCfg.RelabelConfigFiles
+	if sc.RelabelConfigFiles != nil {
+		relabelConfigFiles = sc.RelabelConfigFiles
+	}
+	var relabelFilesData []byte
+	if len(relabelConfigFiles) > 0 {
+		prcs, data, err := loadRelabelConfigFiles(jobName, baseDir, relabelConfigFiles)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load `relabel_config_files` for `job_name` %q: %w", jobName, err)
+		}
+		relabelConfigs = append(relabelConfigs, prcs...)
+		relabelFilesData = data
+	}
+	authProfiles := make(map[string]*promauth.Config, len(sc.AuthProfiles))
+	for name, ap := range sc.AuthProfiles {
+		if name == "" {
+			return nil, fmt.Errorf("`auth_profiles` for `job_name` %q cannot contain empty profile name", jobName)
+		}
+		apc, err := promauth.NewConfig(baseDir, ap.BasicAuth, ap.BearerToken, ap.BearerTokenFile, ap.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `auth_profiles` entry %q for `job_name` %q: %w", name, jobName, err)
+		}
+		authProfiles[name] = apc
+	}
+	if err := checkProfileRefs(relabelConfigs, "__auth_profile__", "auth_profiles", func(name string) bool { return authProfiles[name] != nil }); err != nil {
+		return nil, fmt.Errorf("invalid `relabel_configs` for `job_name` %q: %w", jobName, err)
+	}
 	var metricRelabelConfigs []promrelabel.ParsedRelabelConfig
 	metricRelabelConfigs, err = promrelabel.ParseRelabelConfigs(metricRelabelConfigs[:0], sc.MetricRelabelConfigs)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse `metric_relabel_configs` for `job_name` %q: %w", jobName, err)
 	}
+	metricRelabelProfiles := make(map[string][]promrelabel.ParsedRelabelConfig, len(sc.MetricRelabelProfiles))
+	for name, rcs := range sc.MetricRelabelProfiles {
+		if name == "" {
+			return nil, fmt.Errorf("`metric_relabel_profiles` for `job_name` %q cannot contain empty profile name", jobName)
+		}
+		// Every profile contains `metric_relabel_configs` followed by the profile relabeling rules,
+		// so the resulting rules can be shared among targets without per-target allocations.
+		prcs := append([]promrelabel.ParsedRelabelConfig{}, metricRelabelConfigs...)
+		prcs, err = promrelabel.ParseRelabelConfigs(prcs, rcs)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `metric_relabel_profiles` entry %q for `job_name` %q: %w", name, jobName, err)
+		}
+		metricRelabelProfiles[name] = prcs
+	}
+	hasMetricRelabelProfile := func(name string) bool {
+		_, ok := metricRelabelProfiles[name]
+		return ok
+	}
+	if err := checkProfileRefs(relabelConfigs, "__metric_relabel_profile__", "metric_relabel_profiles", hasMetricRelabelProfile); err != nil {
+		return nil, fmt.Errorf("invalid `relabel_configs` for `job_name` %q: %w", jobName, err)
+	}
+	enabled := true
+	if sc.Enabled != nil {
+		enabled = *sc.Enabled
+	}
 	swc := &scrapeWorkConfig{
+		enabled:              enabled,
 		scrapeInterval:       scrapeInterval,
 		scrapeTimeout:        scrapeTimeout,
+		scrapeTimeoutOffset:  scrapeTimeoutOffset,
+		timeoutOffsetCfg:     sc.ScrapeTimeoutOffset,
+		parseTimeout:         parseTimeout,
 		jobName:              jobName,
 		metricsPath:          metricsPath,
+		metricsPaths:         metricsPaths,
+		pathsConcurrency:     sc.MetricsPathsConcurrency,
 		scheme:               scheme,
 		params:               params,
 		authConfig:           ac,
+		baseDir:              baseDir,
+		tlsCertFileTemplate:  tlsCertFileTemplate,
+		tlsKeyFileTemplate:   tlsKeyFileTemplate,
+		serverNameTemplate:   tlsServerNameTemplate,
 		honorLabels:          honorLabels,
 		honorTimestamps:      honorTimestamps,
+		timestampLimits:      sc.TimestampLimits,
 		externalLabels:       globalCfg.ExternalLabels,
+		defaultLabels:        globalCfg.DefaultLabels,
 		relabelConfigs:       relabelConfigs,
 		metricRelabelConfigs: metricRelabelConfigs,
 		sampleLimit:          sc.SampleLimit,
+		targetLimit:          sc.TargetLimit,
+		targetPriorityLabel:  sc.TargetLimitPriorityLabel,
 		disableCompression:   sc.DisableCompression,
 		disableKeepAlive:     sc.DisableKeepAlive,
 		streamParse:          sc.StreamParse,
+		scrapeRetries:        sc.ScrapeRetries,
+		dropNaNInf:           sc.DropNaNInf,
+		conditionalScrape:    sc.ConditionalScrape,
+		checkContentType:     sc.CheckContentType,
+		expositionFormat:     sc.ExpositionFormat,
+		grpcMethod:           sc.GRPCMethod,
+		method:               method,
+		body:                 body,
+		contentType:          contentType,
+		objectStore:          sc.ObjectStore,
+		kafkaConsumer:        sc.KafkaConsumer,
+		sshTunnel:            sshTunnel,
+		login:                sc.Login,
+		cloudflareAccess:     cloudflareAccess,
+		keepLabelNames:       keepLabelNames,
+		dropLabelNames:       dropLabelNames,
+		nameValidation:       sc.MetricNameValidation,
+		nameAction:           nameAction,
+		healthMetrics:        sc.HealthMetrics,
+		healthLabels:         getHealthLabels(sc.HealthMetricsLabels),
+		createdSeries:        sc.CreatedSeries,
+		dedupWithinScrape:    sc.DedupWithinScrape,
+		requireMetrics:       sc.RequireMetrics,
+		cardinalityTopN:      sc.CardinalityTopN,
+		maxDecompressedSize:  sc.MaxDecompressedSize,
+		maxRedirects:         sc.MaxRedirects,
+		redirectHosts:        sc.RedirectHostAllowlist,
+		priority:             sc.Priority,
+		responseCharset:      sc.ResponseCharset,
+		instanceTemplate:     sc.InstanceTemplate,
+		relabelFilesData:     relabelFilesData,
+		intervalHeader:       sc.ScrapeIntervalHeader,
+		minInterval:          minInterval,
+		maxInterval:          maxInterval,
+		cbFailures:           cbFailures,
+		cbCooldown:           cbCooldown,
+		scrapeProtocols:      sc.ScrapeProtocols,
+		proxyURL:             sc.ProxyURL,
+		scrapePoolLabelName:  scrapePoolLabelName,
+		authProfiles:         authProfiles,
+		secretsFile:          secretsFile,
+		metricProfiles:       metricRelabelProfiles,
+		needResolvedIP:       needResolvedIP(relabelConfigs),
+		needScrapePool:       needRelabelLabel(relabelConfigs, scrapePoolLabel),
+		needTargetIndex:      needRelabelLabel(relabelConfigs, targetIndexLabel),
 	}
 	return swc, nil
 }
 
 type scrapeWorkConfig struct {
+	enabled              bool
 	scrapeInterval       time.Duration
 	scrapeTimeout        time.Duration
+	scrapeTimeoutOffset  time.Duration
+	timeoutOffsetCfg     *time.Duration
+	parseTimeout         time.Duration
 	jobName              string
 	metricsPath          string
+	metricsPaths         []string
+	pathsConcurrency     int
 	scheme               string
 	params               map[string][]string
 	authConfig           *promauth.Config
+	baseDir              string
+	tlsCertFileTemplate  string
+	tlsKeyFileTemplate   string
+	serverNameTemplate   string
 	honorLabels          bool
 	honorTimestamps      bool
+	timestampLimits      *TimestampLimitsConfig
 	externalLabels       map[string]string
+	defaultLabels        map[string]string
 	relabelConfigs       []promrelabel.ParsedRelabelConfig
 	metricRelabelConfigs []promrelabel.ParsedRelabelConfig
 	sampleLimit          int
+	targetLimit          int
+	targetPriorityLabel  string
 	disableCompression   bool
 	disableKeepAlive     bool
 	streamParse          bool
+	scrapeRetries        int
+	dropNaNInf           bool
+	conditionalScrape    bool
+	checkContentType     bool
+	expositionFormat     string
+	grpcMethod           string
+	method               string
+	body                 string
+	contentType          string
+	objectStore          *ObjectStoreConfig
+	kafkaConsumer        *KafkaConsumerConfig
+	sshTunnel            *SSHTunnelConfig
+	login                *LoginConfig
+	cloudflareAccess     *CloudflareAccessConfig
+	keepLabelNames       *regexp.Regexp
+	dropLabelNames       *regexp.Regexp
+	nameValidation       string
+	nameAction           string
+	healthMetrics        string
+	healthLabels         []prompbmarshal.Label
+	createdSeries        string
+	dedupWithinScrape    string
+	requireMetrics       []string
+	cardinalityTopN      int
+	maxDecompressedSize  int
+	maxRedirects         *int
+	redirectHosts        []string
+	priority             int
+	responseCharset      string
+	instanceTemplate     string
+	relabelFilesData     []byte
+	intervalHeader       string
+	minInterval          time.Duration
+	maxInterval          time.Duration
+	cbFailures           int
+	cbCooldown           time.Duration
+	scrapeProtocols      []string
+	proxyURL             *proxy.URL
+	scrapePoolLabelName  string
+	authProfiles         map[string]*promauth.Config
+	secretsFile          string
+	metricProfiles       map[string][]promrelabel.ParsedRelabelConfig
+	needResolvedIP       bool
+	needScrapePool       bool
+	needTargetIndex      bool
+	staticHash           uint64
+	staticHashOK         bool
 }
 
 func appendKubernetesScrapeWork(dst []ScrapeWork, sdc *kubernetes.SDConfig, baseDir string, swc *scrapeWorkConfig) ([]ScrapeWork, bool) {
@@ -591,6 +1439,15 @@ func appendEC2ScrapeWork(dst []ScrapeWork, sdc *ec2.SDConfig, swc *scrapeWorkCon
 	return appendScrapeWorkForTargetLabels(dst, swc, targetLabels, "ec2_sd_config"), true
 }
 
+func appendECSScrapeWork(dst []ScrapeWork, sdc *ecs.SDConfig, swc *scrapeWorkConfig) ([]ScrapeWork, bool) {
+	targetLabels, err := ecs.GetLabels(sdc)
+	if err != nil {
+		logger.Errorf("error when discovering ecs targets for `job_name` %q: %s; skipping it", swc.jobName, err)
+		return dst, false
+	}
+	return appendScrapeWorkForTargetLabels(dst, swc, targetLabels, "ecs_sd_config"), true
+}
+
 func appendGCEScrapeWork(dst []ScrapeWork, sdc *gce.SDConfig, swc *scrapeWorkConfig) ([]ScrapeWork, bool) {
 	targetLabels, err := gce.GetLabels(sdc)
 	if err != nil {
@@ -604,7 +1461,7 @@ func appendScrapeWorkForTargetLabels(dst []ScrapeWork, swc *scrapeWorkConfig, ta
 	for _, metaLabels := range targetLabels {
 		target := metaLabels["__address__"]
 		var err error
-		dst, err = appendScrapeWork(dst, swc, target, nil, metaLabels)
+		dst, err = appendScrapeWork(dst, swc, target, nil, metaLabels, nil)
 		if err != nil {
 			logger.Errorf("error when parsing `%s` target %q for `job_name` %q: %s; skipping it", sectionName, target, swc.jobName, err)
 			continue
@@ -651,14 +1508,14 @@ func (sdc *FileSDConfig) appendScrapeWork(dst []ScrapeWork, swsMapPrev map[strin
 				"__vm_filepath":   path, // This label is needed for internal promscrape logic
 			}
 			for i := range stcs {
-				dst = stcs[i].appendScrapeWork(dst, swc, metaLabels)
+				dst = stcs[i].appendScrapeWork(dst, swc, metaLabels, nil)
 			}
 		}
 	}
 	return dst
 }
 
-func (stc *StaticConfig) appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfig, metaLabels map[string]string) []ScrapeWork {
+func (stc *StaticConfig) appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfig, metaLabels map[string]string, dts *[]droppedTarget) []ScrapeWork {
 	for _, target := range stc.Targets {
 		if target == "" {
 			// Do not return this error, since other targets may be valid
@@ -666,7 +1523,7 @@ func (stc *StaticConfig) appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfi
 			continue
 		}
 		var err error
-		dst, err = appendScrapeWork(dst, swc, target, stc.Labels, metaLabels)
+		dst, err = appendScrapeWork(dst, swc, target, stc.Labels, metaLabels, dts)
 		if err != nil {
 			// Do not return this error, since other targets may be valid
 			logger.Errorf("error when parsing `static_configs` target %q for `job_name` %q: %s; skipping it", target, swc.jobName, err)
@@ -676,14 +1533,37 @@ func (stc *StaticConfig) appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfi
 	return dst
 }
 
-func appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfig, target string, extraLabels, metaLabels map[string]string) ([]ScrapeWork, error) {
-	labels := mergeLabels(swc.jobName, swc.scheme, target, swc.metricsPath, extraLabels, swc.externalLabels, metaLabels, swc.params)
+// appendScrapeWork appends ScrapeWork for the given target to dst.
+//
+// Targets dropped during relabeling are registered in droppedTargetsMap. They are also appended to dts if it isn't nil.
+func appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfig, target string, extraLabels, metaLabels map[string]string, dts *[]droppedTarget) ([]ScrapeWork, error) {
+	labels := mergeLabels(swc.jobName, swc.scheme, target, swc.metricsPath, extraLabels, swc.defaultLabels, swc.externalLabels, metaLabels, swc.params)
+	if swc.needTargetIndex {
+		// Obtain the index before adding other meta labels, so it depends only on the discovered target.
+		labels = setReadOnlyLabel(labels, targetIndexLabel, getTargetIndex(swc.jobName, labels))
+	}
+	if swc.needScrapePool {
+		labels = setReadOnlyLabel(labels, scrapePoolLabel, swc.jobName)
+	}
+	if swc.needResolvedIP {
+		// Resolve the target host only if `relabel_configs` refer to `__resolved_ip__`, since this requires DNS lookups.
+		address := promrelabel.GetLabelValueByName(labels, "__address__")
+		labels = append(labels, prompbmarshal.Label{
+			Name:  resolvedIPLabel,
+			Value: getResolvedIP(address),
+		})
+	}
 	var originalLabels []prompbmarshal.Label
 	if !*dropOriginalLabels {
 		originalLabels = append([]prompbmarshal.Label{}, labels...)
 		promrelabel.SortLabels(originalLabels)
+		internLabelStrings(originalLabels)
 	}
 	labels = promrelabel.ApplyRelabelConfigs(labels, 0, swc.relabelConfigs, false)
+	// Expand label references in tls_config before removing meta labels, since they may refer to meta labels.
+	tlsCertFile := expandLabelsTemplate(swc.tlsCertFileTemplate, labels)
+	tlsKeyFile := expandLabelsTemplate(swc.tlsKeyFileTemplate, labels)
+	tlsServerName := expandLabelsTemplate(swc.serverNameTemplate, labels)
 	labels = promrelabel.RemoveMetaLabels(labels[:0], labels)
 	// Remove references to already deleted labels, so GC could clean strings for label name and label value past len(labels).
 	// This should reduce memory usage when relabeling creates big number of temporary labels with long names and/or values.
@@ -692,7 +1572,7 @@ func appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfig, target string, ex
 
 	if len(labels) == 0 {
 		// Drop target without labels.
-		droppedTargetsMap.Register(originalLabels)
+		registerDroppedTarget(dts, originalLabels, "relabeling")
 		return dst, nil
 	}
 	// See https://www.robustperception.io/life-of-a-label
@@ -703,32 +1583,142 @@ func appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfig, target string, ex
 	addressRelabeled := promrelabel.GetLabelValueByName(labels, "__address__")
 	if len(addressRelabeled) == 0 {
 		// Drop target without scrape address.
-		droppedTargetsMap.Register(originalLabels)
+		registerDroppedTarget(dts, originalLabels, "missing_address")
 		return dst, nil
 	}
-	if strings.Contains(addressRelabeled, "/") {
+	isFileTarget := strings.HasPrefix(addressRelabeled, "file://")
+	isObjectStore := isObjectStoreTarget(addressRelabeled)
+	isKafka := isKafkaTarget(addressRelabeled)
+	if !isFileTarget && !isObjectStore && !isKafka && strings.Contains(addressRelabeled, "/") {
 		// Drop target with '/'
-		droppedTargetsMap.Register(originalLabels)
+		registerDroppedTarget(dts, originalLabels, "invalid_address")
 		return dst, nil
 	}
-	addressRelabeled = addMissingPort(schemeRelabeled, addressRelabeled)
-	metricsPathRelabeled := promrelabel.GetLabelValueByName(labels, "__metrics_path__")
-	if metricsPathRelabeled == "" {
-		metricsPathRelabeled = "/metrics"
+	var scrapeURL string
+	var additionalScrapeURLs, backendScrapeURLs, fallbackScrapeURLs []string
+	schemeAuto := false
+	if isFileTarget {
+		if !*allowFileTargets {
+			return dst, fmt.Errorf("target=%q (%q) for `job_name` %q cannot be scraped, since reading local files via `file://` targets is disabled; "+
+				"pass -promscrape.allowFileTargets command-line flag for enabling it", target, addressRelabeled, swc.jobName)
+		}
+		// Read metrics from the local file. `__scheme__`, `__metrics_path__`, `params` and `metrics_paths` are ignored for such targets.
+		scrapeURL = getFileScrapeURL(swc.baseDir, addressRelabeled)
+	} else if isObjectStore {
+		// Read metrics from the newest object under the given prefix. `__scheme__`, `__metrics_path__`, `params` and `metrics_paths` are ignored for such targets.
+		if swc.objectStore == nil {
+			return dst, fmt.Errorf("target=%q (%q) for `job_name` %q requires `object_store` section in `scrape_config`", target, addressRelabeled, swc.jobName)
+		}
+		if len(strings.TrimPrefix(addressRelabeled, "s3://")) == 0 || strings.HasPrefix(addressRelabeled, "s3:///") {
+			return dst, fmt.Errorf("missing bucket name in target=%q (%q) for `job_name` %q", target, addressRelabeled, swc.jobName)
+		}
+		scrapeURL = addressRelabeled
+	} else if isKafka {
+		// Consume metrics from the given topic. `__scheme__`, `__metrics_path__`, `params` and `metrics_paths` are ignored for such targets.
+		if swc.kafkaConsumer == nil {
+			return dst, fmt.Errorf("target=%q (%q) for `job_name` %q requires `kafka_consumer` section in `scrape_config`", target, addressRelabeled, swc.jobName)
+		}
+		if err := validateKafkaTopic(strings.TrimPrefix(addressRelabeled, "kafka://")); err != nil {
+			return dst, fmt.Errorf("invalid target=%q (%q) for `job_name` %q: %w", target, addressRelabeled, swc.jobName, err)
+		}
+		scrapeURL = addressRelabeled
+	} else {
+		if schemeRelabeled == "auto" {
+			// The default port depends on the scheme, so it must be set explicitly in order to scrape the same address via https and http.
+			if _, _, err := net.SplitHostPort(addressRelabeled); err != nil {
+				return dst, fmt.Errorf("missing port in target=%q (%q) with `scheme: auto` for `job_name` %q", target, addressRelabeled, swc.jobName)
+			}
+			if len(swc.metricsPaths) > 0 || promrelabel.GetLabelValueByName(labels, "__addresses__") != "" {
+				return dst, fmt.Errorf("`scheme: auto` cannot be used with `metrics_paths` or `__addresses__` for target=%q (%q) for `job_name` %q",
+					target, addressRelabeled, swc.jobName)
+			}
+			schemeAuto = true
+			schemeRelabeled = "https"
+		}
+		addressRelabeled = addMissingPort(schemeRelabeled, addressRelabeled)
+		metricsPathRelabeled := promrelabel.GetLabelValueByName(labels, "__metrics_path__")
+		if metricsPathRelabeled == "" {
+			metricsPathRelabeled = "/metrics"
+		}
+		if !strings.HasPrefix(metricsPathRelabeled, "/") {
+			metricsPathRelabeled = "/" + metricsPathRelabeled
+		}
+		paramsRelabeled := getParamsFromLabels(labels, swc.params)
+		scrapeURL = getScrapeURL(schemeRelabeled, addressRelabeled, metricsPathRelabeled, paramsRelabeled)
+		if _, err := url.Parse(scrapeURL); err != nil {
+			return dst, fmt.Errorf("invalid url %q for scheme=%q (%q), target=%q (%q), metrics_path=%q (%q) for `job_name` %q: %w",
+				scrapeURL, swc.scheme, schemeRelabeled, target, addressRelabeled, swc.metricsPath, metricsPathRelabeled, swc.jobName, err)
+		}
+		for _, metricsPath := range swc.metricsPaths {
+			if !strings.HasPrefix(metricsPath, "/") {
+				metricsPath = "/" + metricsPath
+			}
+			u := getScrapeURL(schemeRelabeled, addressRelabeled, metricsPath, paramsRelabeled)
+			if u == scrapeURL || hasString(additionalScrapeURLs, u) {
+				continue
+			}
+			if _, err := url.Parse(u); err != nil {
+				return dst, fmt.Errorf("invalid url %q for scheme=%q (%q), target=%q (%q), metrics_paths entry %q for `job_name` %q: %w",
+					u, swc.scheme, schemeRelabeled, target, addressRelabeled, metricsPath, swc.jobName, err)
+			}
+			additionalScrapeURLs = append(additionalScrapeURLs, u)
+		}
+		if addresses := promrelabel.GetLabelValueByName(labels, "__addresses__"); addresses != "" {
+			for _, backend := range strings.Split(addresses, ",") {
+				backend = strings.TrimSpace(backend)
+				if backend == "" {
+					continue
+				}
+				if strings.Contains(backend, "/") {
+					return dst, fmt.Errorf("invalid `__addresses__` entry %q for target=%q (%q) for `job_name` %q; it mustn't contain '/'", backend, target, addressRelabeled, swc.jobName)
+				}
+				backend = addMissingPort(schemeRelabeled, backend)
+				u := getScrapeURL(schemeRelabeled, backend, metricsPathRelabeled, paramsRelabeled)
+				if _, err := url.Parse(u); err != nil {
+					return dst, fmt.Errorf("invalid url %q for `__addresses__` entry %q for target=%q (%q) for `job_name` %q: %w", u, backend, target, addressRelabeled, swc.jobName, err)
+				}
+				backendScrapeURLs = append(backendScrapeURLs, u)
+			}
+		}
+		if fallbackURLs := promrelabel.GetLabelValueByName(labels, "__fallback_scrape_urls__"); fallbackURLs != "" {
+			if schemeAuto || len(backendScrapeURLs) > 0 {
+				return dst, fmt.Errorf("`__fallback_scrape_urls__` cannot be used with `scheme: auto` or `__addresses__` for target=%q (%q) for `job_name` %q", target, addressRelabeled, swc.jobName)
+			}
+			for _, u := range strings.Split(fallbackURLs, ",") {
+				u = strings.TrimSpace(u)
+				if u == "" || u == scrapeURL || hasString(fallbackScrapeURLs, u) {
+					continue
+				}
+				pu, err := url.Parse(u)
+				if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+					return dst, fmt.Errorf("invalid `__fallback_scrape_urls__` entry %q for target=%q (%q) for `job_name` %q; it must be an absolute http or https url", u, target, addressRelabeled, swc.jobName)
+				}
+				fallbackScrapeURLs = append(fallbackScrapeURLs, u)
+			}
+		}
 	}
-	if !strings.HasPrefix(metricsPathRelabeled, "/") {
-		metricsPathRelabeled = "/" + metricsPathRelabeled
+	scrapeInterval, scrapeTimeout, scrapeTimeoutOffset, err := getTargetScrapeIntervalTimeout(swc, labels)
+	if err != nil {
+		return dst, fmt.Errorf("invalid scrape interval for target=%q (%q) for `job_name` %q: %w", target, addressRelabeled, swc.jobName, err)
 	}
-	paramsRelabeled := getParamsFromLabels(labels, swc.params)
-	optionalQuestion := "?"
-	if len(paramsRelabeled) == 0 || strings.Contains(metricsPathRelabeled, "?") {
-		optionalQuestion = ""
+	authProfile := promrelabel.GetLabelValueByName(labels, "__auth_profile__")
+	ac, err := swc.getTargetAuthConfig(authProfile, tlsCertFile, tlsKeyFile, tlsServerName)
+	if err != nil {
+		return dst, fmt.Errorf("cannot initialize auth config for target=%q (%q) for `job_name` %q: %w", target, addressRelabeled, swc.jobName, err)
+	}
+	secretsFile := swc.secretsFile
+	if authProfile != "" {
+		// The auth config from `auth_profiles` doesn't depend on `secrets_file`.
+		secretsFile = ""
 	}
-	paramsStr := url.Values(paramsRelabeled).Encode()
-	scrapeURL := fmt.Sprintf("%s://%s%s%s%s", schemeRelabeled, addressRelabeled, metricsPathRelabeled, optionalQuestion, paramsStr)
-	if _, err := url.Parse(scrapeURL); err != nil {
-		return dst, fmt.Errorf("invalid url %q for scheme=%q (%q), target=%q (%q), metrics_path=%q (%q) for `job_name` %q: %w",
-			scrapeURL, swc.scheme, schemeRelabeled, target, addressRelabeled, swc.metricsPath, metricsPathRelabeled, swc.jobName, err)
+	metricRelabelConfigs := swc.metricRelabelConfigs
+	if metricRelabelProfile := promrelabel.GetLabelValueByName(labels, "__metric_relabel_profile__"); metricRelabelProfile != "" {
+		prcs, ok := swc.metricProfiles[metricRelabelProfile]
+		if !ok {
+			return dst, fmt.Errorf("unknown `__metric_relabel_profile__` %q for target=%q (%q) for `job_name` %q; it must refer to `metric_relabel_profiles` entry",
+				metricRelabelProfile, target, addressRelabeled, swc.jobName)
+		}
+		metricRelabelConfigs = prcs
 	}
 	// Set missing "instance" label according to https://www.robustperception.io/life-of-a-label
 	if promrelabel.GetLabelByName(labels, "instance") == nil {
@@ -738,27 +1728,332 @@ func appendScrapeWork(dst []ScrapeWork, swc *scrapeWorkConfig, target string, ex
 		})
 		promrelabel.SortLabels(labels)
 	}
+	if swc.instanceTemplate != "" && !isFileTarget && !isObjectStore && !isKafka {
+		// Normalize "instance" label, since distinct service discovery mechanisms may set it in distinct formats.
+		promrelabel.GetLabelByName(labels, "instance").Value = getInstanceFromTemplate(swc.instanceTemplate, addressRelabeled)
+	}
+	// Set missing scrape pool label if `add_scrape_pool_label` is enabled.
+	// The label is merged with scraped labels according to `honor_labels` in the same way as other target labels.
+	if swc.scrapePoolLabelName != "" && promrelabel.GetLabelByName(labels, swc.scrapePoolLabelName) == nil {
+		labels = append(labels, prompbmarshal.Label{
+			Name:  swc.scrapePoolLabelName,
+			Value: swc.jobName,
+		})
+		promrelabel.SortLabels(labels)
+	}
+	// Target labels are held during the whole lifetime of the target, while the same label names and values
+	// such as `job` or `cluster` are usually repeated across big number of targets.
+	internLabelStrings(labels)
 	dst = append(dst, ScrapeWork{
 		ID:                   atomic.AddUint64(&nextScrapeWorkID, 1),
 		ScrapeURL:            scrapeURL,
-		ScrapeInterval:       swc.scrapeInterval,
-		ScrapeTimeout:        swc.scrapeTimeout,
+		AdditionalScrapeURLs: additionalScrapeURLs,
+		PathsConcurrency:     swc.pathsConcurrency,
+		BackendScrapeURLs:    backendScrapeURLs,
+		FallbackScrapeURLs:   fallbackScrapeURLs,
+		SchemeAuto:           schemeAuto,
+		ScrapeInterval:       scrapeInterval,
+		ScrapeTimeout:        scrapeTimeout,
+		ScrapeTimeoutOffset:  scrapeTimeoutOffset,
+		ParseTimeout:         swc.parseTimeout,
 		HonorLabels:          swc.honorLabels,
 		HonorTimestamps:      swc.honorTimestamps,
+		TimestampLimits:      swc.timestampLimits,
 		OriginalLabels:       originalLabels,
 		Labels:               labels,
-		AuthConfig:           swc.authConfig,
-		MetricRelabelConfigs: swc.metricRelabelConfigs,
+		AuthConfig:           ac,
+		SecretsFile:          secretsFile,
+		MetricRelabelConfigs: metricRelabelConfigs,
 		SampleLimit:          swc.sampleLimit,
 		DisableCompression:   swc.disableCompression,
 		DisableKeepAlive:     swc.disableKeepAlive,
 		StreamParse:          swc.streamParse,
+		ScrapeRetries:        swc.scrapeRetries,
+		DropNaNInf:           swc.dropNaNInf,
+		ConditionalScrape:    swc.conditionalScrape,
+		CheckContentType:     swc.checkContentType,
+		ExpositionFormat:     swc.expositionFormat,
+		GRPCMethod:           swc.grpcMethod,
+		Method:               swc.method,
+		Body:                 swc.body,
+		ContentType:          swc.contentType,
+		ObjectStore:          swc.objectStore,
+		KafkaConsumer:        swc.kafkaConsumer,
+		SSHTunnel:            swc.sshTunnel,
+		Login:                swc.login,
+		CloudflareAccess:     swc.cloudflareAccess,
+		KeepLabelNames:       swc.keepLabelNames,
+		DropLabelNames:       swc.dropLabelNames,
+		MetricNameValidation: swc.nameValidation,
+		MetricNameAction:     swc.nameAction,
+		HealthMetrics:        swc.healthMetrics,
+		HealthLabels:         swc.healthLabels,
+		CreatedSeries:        swc.createdSeries,
+		DedupWithinScrape:    swc.dedupWithinScrape,
+		RequireMetrics:       swc.requireMetrics,
+		CardinalityTopN:      swc.cardinalityTopN,
+		MaxDecompressedSize:  swc.maxDecompressedSize,
+		MaxRedirects:         swc.maxRedirects,
+		RedirectHosts:        swc.redirectHosts,
+		Priority:             swc.priority,
+		ResponseCharset:      swc.responseCharset,
+		IntervalHeader:       swc.intervalHeader,
+		MinInterval:          swc.minInterval,
+		MaxInterval:          swc.maxInterval,
+		BreakerFailures:      swc.cbFailures,
+		BreakerCooldown:      swc.cbCooldown,
+		ScrapeProtocols:      swc.scrapeProtocols,
+		ProxyURL:             swc.proxyURL,
 
 		jobNameOriginal: swc.jobName,
 	})
 	return dst, nil
 }
 
+// getFileScrapeURL returns scrape url for the given `file://` address.
+//
+// Relative file paths are resolved against baseDir.
+func getFileScrapeURL(baseDir, address string) string {
+	path := strings.TrimPrefix(address, "file://")
+	return "file://" + getFilepath(baseDir, path)
+}
+
+func getScrapeURL(scheme, address, metricsPath string, params map[string][]string) string {
+	optionalQuestion := "?"
+	if len(params) == 0 || strings.Contains(metricsPath, "?") {
+		optionalQuestion = ""
+	}
+	paramsStr := url.Values(params).Encode()
+	address = escapeIPv6Zone(address)
+	metricsPath = escapeMetricsPath(metricsPath)
+	return fmt.Sprintf("%s://%s%s%s%s", scheme, address, metricsPath, optionalQuestion, paramsStr)
+}
+
+// escapeMetricsPath escapes chars, which cannot be put into url path, in the path part of metricsPath.
+//
+// This allows building metricsPath from arbitrary label values during the relabeling.
+// Already escaped chars such as `%2F` are left as is, so `/` may be passed inside path segment as `%2F`.
+// The query string after `?` is left as is.
+func escapeMetricsPath(metricsPath string) string {
+	path := metricsPath
+	query := ""
+	if n := strings.IndexByte(metricsPath, '?'); n >= 0 {
+		path = metricsPath[:n]
+		query = metricsPath[n:]
+	}
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '%' && i+2 < len(path) && isHex(path[i+1]) && isHex(path[i+2]) {
+			sb.WriteString(path[i : i+3])
+			i += 2
+			continue
+		}
+		if isPathChar(c) {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	sb.WriteString(query)
+	return sb.String()
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// isPathChar returns true if c may be put into url path without escaping according to https://tools.ietf.org/html/rfc3986#section-3.3 .
+func isPathChar(c byte) bool {
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/", c) >= 0
+}
+
+// escapeIPv6Zone escapes zone identifier in bracketed IPv6 address such as `[fe80::1%eth0]:80`,
+// so it could be put into url according to https://tools.ietf.org/html/rfc6874 .
+func escapeIPv6Zone(address string) string {
+	if !strings.HasPrefix(address, "[") {
+		return address
+	}
+	n := strings.IndexByte(address, ']')
+	if n < 0 {
+		return address
+	}
+	host := address[:n]
+	zoneIdx := strings.IndexByte(host, '%')
+	if zoneIdx < 0 || strings.HasPrefix(host[zoneIdx:], "%25") {
+		return address
+	}
+	return host[:zoneIdx] + "%25" + address[zoneIdx+1:]
+}
+
+func hasString(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// getTargetAuthConfig returns auth config for the target with the given authProfile, client certificate files and TLS server name.
+//
+// The auth config from `auth_profiles` is returned if authProfile isn't empty.
+// swc.authConfig is returned if tls_config for swc doesn't contain label references in `cert_file`, `key_file` and `server_name`.
+func (swc *scrapeWorkConfig) getTargetAuthConfig(authProfile, certFile, keyFile, serverName string) (*promauth.Config, error) {
+	if authProfile != "" {
+		ac := swc.authProfiles[authProfile]
+		if ac == nil {
+			return nil, fmt.Errorf("unknown `__auth_profile__` %q; it must refer to `auth_profiles` entry", authProfile)
+		}
+		return ac, nil
+	}
+	if swc.tlsCertFileTemplate == "" && swc.tlsKeyFileTemplate == "" && swc.serverNameTemplate == "" {
+		return swc.authConfig, nil
+	}
+	ac := *swc.authConfig
+	if swc.tlsCertFileTemplate != "" || swc.tlsKeyFileTemplate != "" {
+		cert, err := tlsCertsCacheGlobal.get(getFilepath(swc.baseDir, certFile), getFilepath(swc.baseDir, keyFile))
+		if err != nil {
+			return nil, fmt.Errorf("cannot load TLS certificate from `cert_file`=%q (%q), `key_file`=%q (%q): %w",
+				swc.tlsCertFileTemplate, certFile, swc.tlsKeyFileTemplate, keyFile, err)
+		}
+		ac.TLSCertificate = cert
+	}
+	if swc.serverNameTemplate != "" {
+		if !isValidHostname(serverName) {
+			return nil, fmt.Errorf("invalid `server_name`=%q (%q); it must be a valid hostname", swc.serverNameTemplate, serverName)
+		}
+		ac.TLSServerName = serverName
+	}
+	return &ac, nil
+}
+
+// isValidHostname returns true if s is a valid DNS hostname according to RFC 1123.
+func isValidHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) == 0 || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkProfileRefs verifies whether prcs set labelName label only to the existing profiles from profilesSection.
+//
+// Only constant replacements can be verified before the relabeling, while the remaining values are verified per each target.
+func checkProfileRefs(prcs []promrelabel.ParsedRelabelConfig, labelName, profilesSection string, hasProfile func(name string) bool) error {
+	for i := range prcs {
+		prc := &prcs[i]
+		if prc.Action != "replace" || prc.TargetLabel != labelName || strings.Contains(prc.Replacement, "$") {
+			continue
+		}
+		if prc.Replacement != "" && !hasProfile(prc.Replacement) {
+			return fmt.Errorf("unknown `%s` %q; it must refer to `%s` entry", labelName, prc.Replacement, profilesSection)
+		}
+	}
+	return nil
+}
+
+// hasLabelsTemplate returns true if s contains `${label_name}` references.
+func hasLabelsTemplate(s string) bool {
+	return strings.Contains(s, "${")
+}
+
+// expandLabelsTemplate replaces `${label_name}` references in template with the corresponding label values.
+//
+// Missing labels are replaced with empty strings.
+func expandLabelsTemplate(template string, labels []prompbmarshal.Label) string {
+	if !hasLabelsTemplate(template) {
+		return template
+	}
+	return fasttemplate.ExecuteFuncString(template, "${", "}", func(w io.Writer, tag string) (int, error) {
+		v := promrelabel.GetLabelValueByName(labels, tag)
+		return w.Write([]byte(v))
+	})
+}
+
+var tlsCertsCacheGlobal = newTLSCertsCache(maxTLSCertsCacheSize)
+
+// maxTLSCertsCacheSize is the maximum number of entries in tlsCertsCache. The cache is reset when the limit is reached.
+const maxTLSCertsCacheSize = 10000
+
+// tlsCertsCache caches client certificates loaded for distinct targets by `cert_file` and `key_file` paths.
+//
+// Certificates are re-loaded when the files are modified, so rotated certificates are picked up on config reload.
+type tlsCertsCache struct {
+	// maxSize is the maximum number of cached certificates.
+	maxSize int
+
+	mu sync.Mutex
+	m  map[string]*tlsCertsCacheEntry
+}
+
+func newTLSCertsCache(maxSize int) *tlsCertsCache {
+	return &tlsCertsCache{
+		maxSize: maxSize,
+		m:       make(map[string]*tlsCertsCacheEntry),
+	}
+}
+
+type tlsCertsCacheEntry struct {
+	cert *tls.Certificate
+
+	// certModTime and keyModTime are modification times for the files the cert has been loaded from.
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func (tcc *tlsCertsCache) get(certPath, keyPath string) (*tls.Certificate, error) {
+	certModTime, err := getFileModTime(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyModTime, err := getFileModTime(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key := certPath + "\x00" + keyPath
+	tcc.mu.Lock()
+	defer tcc.mu.Unlock()
+	if e := tcc.m[key]; e != nil && e.certModTime.Equal(certModTime) && e.keyModTime.Equal(keyModTime) {
+		return e.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(tcc.m) >= tcc.maxSize {
+		tcc.m = make(map[string]*tlsCertsCacheEntry)
+	}
+	tcc.m[key] = &tlsCertsCacheEntry{
+		cert:        &cert,
+		certModTime: certModTime,
+		keyModTime:  keyModTime,
+	}
+	return &cert, nil
+}
+
+func getFileModTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
 // Each ScrapeWork has an ID, which is used for locating it when updating its status.
 var nextScrapeWorkID uint64
 
@@ -780,9 +2075,21 @@ func getParamsFromLabels(labels []prompbmarshal.Label, paramsOrig map[string][]s
 	return m
 }
 
-func mergeLabels(job, scheme, target, metricsPath string, extraLabels, externalLabels, metaLabels map[string]string, params map[string][]string) []prompbmarshal.Label {
+// internLabelStrings replaces label names and values in labels with interned strings.
+func internLabelStrings(labels []prompbmarshal.Label) {
+	for i := range labels {
+		label := &labels[i]
+		label.Name = bytesutil.InternString(label.Name)
+		label.Value = bytesutil.InternString(label.Value)
+	}
+}
+
+func mergeLabels(job, scheme, target, metricsPath string, extraLabels, defaultLabels, externalLabels, metaLabels map[string]string, params map[string][]string) []prompbmarshal.Label {
 	// See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#relabel_config
 	m := make(map[string]string)
+	for k, v := range defaultLabels {
+		m[k] = v
+	}
 	for k, v := range externalLabels {
 		m[k] = v
 	}
@@ -822,7 +2129,15 @@ func getFilepath(baseDir, path string) string {
 }
 
 func addMissingPort(scheme, target string) string {
-	if strings.Contains(target, ":") {
+	if strings.HasPrefix(target, "[") {
+		// Bracketed IPv6 address such as `[::1]:80` or `[fe80::1%eth0]`.
+		if strings.Contains(target, "]:") {
+			return target
+		}
+	} else if strings.Count(target, ":") > 1 {
+		// Bare IPv6 address such as `::1` or `fe80::1%eth0`.
+		target = "[" + target + "]"
+	} else if strings.Contains(target, ":") {
 		return target
 	}
 	if scheme == "https" {
@@ -836,4 +2151,7 @@ func addMissingPort(scheme, target string) string {
 const (
 	defaultScrapeInterval = time.Minute
 	defaultScrapeTimeout  = 10 * time.Second
+
+	// defaultScrapeTimeoutOffset is capped by 10% of scrape_timeout.
+	defaultScrapeTimeoutOffset = 100 * time.Millisecond
 )
@@ -1,12 +1,24 @@
 package promscrape
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
@@ -69,171 +81,1569 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfigExpandEnvVars(t *testing.T) {
+	*expandEnvVars = true
+	defer func() {
+		*expandEnvVars = false
+	}()
+
+	// Undefined var
+	cfg, _, err := loadConfig("testdata/prometheus-env-vars.yml")
+	if err == nil {
+		t.Fatalf("expecting non-nil error for undefined env var")
+	}
+	if cfg != nil {
+		t.Fatalf("unexpected non-nil config: %#v", cfg)
+	}
+
+	// Defined and defaulted vars
+	if err := os.Setenv("VM_TEST_JOB_NAME", "foo"); err != nil {
+		t.Fatalf("cannot set env var: %s", err)
+	}
+	defer os.Unsetenv("VM_TEST_JOB_NAME")
+	cfg, _, err = loadConfig("testdata/prometheus-env-vars.yml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	if sws[0].jobNameOriginal != "foo" {
+		t.Fatalf("unexpected job name; got %q; want %q", sws[0].jobNameOriginal, "foo")
+	}
+	if sws[0].ScrapeURL != "http://host1:9100/metrics" {
+		t.Fatalf("unexpected ScrapeURL; got %q; want %q", sws[0].ScrapeURL, "http://host1:9100/metrics")
+	}
+}
+
+func TestLoadConfigFromURL(t *testing.T) {
+	var mu sync.Mutex
+	body := `
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo:1234"]
+`
+	etag := `"v1"`
+	statusCode := http.StatusOK
+	notModifiedResponses := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if statusCode != http.StatusOK {
+			w.WriteHeader(statusCode)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			notModifiedResponses++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, "%s", body)
+	}))
+	defer s.Close()
+
+	*configURLBearerToken = "secret"
+	defer func() {
+		*configURLBearerToken = ""
+	}()
+	configURL := s.URL + "/config.yml"
+
+	f := func(jobNameExpected string, notModifiedResponsesExpected int) []byte {
+		t.Helper()
+		cfg, data, err := loadConfig(configURL)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		if sws[0].jobNameOriginal != jobNameExpected {
+			t.Fatalf("unexpected job name; got %q; want %q", sws[0].jobNameOriginal, jobNameExpected)
+		}
+		mu.Lock()
+		n := notModifiedResponses
+		mu.Unlock()
+		if n != notModifiedResponsesExpected {
+			t.Fatalf("unexpected number of conditional requests with unchanged config; got %d; want %d", n, notModifiedResponsesExpected)
+		}
+		return data
+	}
+	data1 := f("foo", 0)
+
+	// Unchanged config must be obtained via conditional request.
+	data2 := f("foo", 1)
+	if !bytes.Equal(data1, data2) {
+		t.Fatalf("unexpected config data change;\ngot\n%s\nwant\n%s", data2, data1)
+	}
+
+	// Changed config must be fetched.
+	mu.Lock()
+	body = strings.ReplaceAll(body, "foo", "bar")
+	etag = `"v2"`
+	mu.Unlock()
+	f("bar", 1)
+
+	// Fetch failure must result in error, so the caller could continue using the previous config.
+	mu.Lock()
+	statusCode = http.StatusServiceUnavailable
+	mu.Unlock()
+	if _, _, err := loadConfig(configURL); err == nil {
+		t.Fatalf("expecting non-nil error when the config url is unavailable")
+	}
+
+	// Missing auth must result in error.
+	mu.Lock()
+	statusCode = http.StatusOK
+	mu.Unlock()
+	*configURLBearerToken = ""
+	if _, _, err := loadConfig(configURL); err == nil {
+		t.Fatalf("expecting non-nil error for missing auth")
+	}
+}
+
 func TestBlackboxExporter(t *testing.T) {
 	// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/684
 	data := `
 scrape_configs:
-  - job_name: 'blackbox'
-    metrics_path: /probe
-    params:
-      module: [dns_udp_example]  # Look for  dns response
-    static_configs:
-      - targets:
-        - 8.8.8.8
-    relabel_configs:
-      - source_labels: [__address__]
-        target_label: __param_target
-      - source_labels: [__param_target]
-        target_label: instance
-      - target_label: __address__
-        replacement: black:9115  # The blackbox exporter's real hostname:port.%
-`
-	var cfg Config
-	if err := cfg.parse([]byte(data), "sss"); err != nil {
-		t.Fatalf("cannot parase data: %s", err)
-	}
-	sws := cfg.getStaticScrapeWork()
-	resetNonEssentialFields(sws)
-	swsExpected := []ScrapeWork{{
-		ScrapeURL:      "http://black:9115/probe?module=dns_udp_example&target=8.8.8.8",
-		ScrapeInterval: defaultScrapeInterval,
-		ScrapeTimeout:  defaultScrapeTimeout,
-		Labels: []prompbmarshal.Label{
-			{
-				Name:  "__address__",
-				Value: "black:9115",
-			},
-			{
-				Name:  "__metrics_path__",
-				Value: "/probe",
-			},
-			{
-				Name:  "__param_module",
-				Value: "dns_udp_example",
-			},
-			{
-				Name:  "__param_target",
-				Value: "8.8.8.8",
-			},
-			{
-				Name:  "__scheme__",
-				Value: "http",
-			},
-			{
-				Name:  "instance",
-				Value: "8.8.8.8",
-			},
-			{
-				Name:  "job",
-				Value: "blackbox",
-			},
-		},
-		AuthConfig:      &promauth.Config{},
-		jobNameOriginal: "blackbox",
-	}}
-	if !reflect.DeepEqual(sws, swsExpected) {
-		t.Fatalf("unexpected scrapeWork;\ngot\n%+v\nwant\n%+v", sws, swsExpected)
-	}
-}
+  - job_name: 'blackbox'
+    metrics_path: /probe
+    params:
+      module: [dns_udp_example]  # Look for  dns response
+    static_configs:
+      - targets:
+        - 8.8.8.8
+    relabel_configs:
+      - source_labels: [__address__]
+        target_label: __param_target
+      - source_labels: [__param_target]
+        target_label: instance
+      - target_label: __address__
+        replacement: black:9115  # The blackbox exporter's real hostname:port.%
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parase data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	resetNonEssentialFields(sws)
+	swsExpected := []ScrapeWork{{
+		ScrapeURL:           "http://black:9115/probe?module=dns_udp_example&target=8.8.8.8",
+		ScrapeInterval:      defaultScrapeInterval,
+		ScrapeTimeout:       defaultScrapeTimeout,
+		ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+		Labels: []prompbmarshal.Label{
+			{
+				Name:  "__address__",
+				Value: "black:9115",
+			},
+			{
+				Name:  "__metrics_path__",
+				Value: "/probe",
+			},
+			{
+				Name:  "__param_module",
+				Value: "dns_udp_example",
+			},
+			{
+				Name:  "__param_target",
+				Value: "8.8.8.8",
+			},
+			{
+				Name:  "__scheme__",
+				Value: "http",
+			},
+			{
+				Name:  "instance",
+				Value: "8.8.8.8",
+			},
+			{
+				Name:  "job",
+				Value: "blackbox",
+			},
+		},
+		AuthConfig:      &promauth.Config{},
+		jobNameOriginal: "blackbox",
+	}}
+	if !reflect.DeepEqual(sws, swsExpected) {
+		t.Fatalf("unexpected scrapeWork;\ngot\n%+v\nwant\n%+v", sws, swsExpected)
+	}
+}
+
+func TestGetFileSDScrapeWork(t *testing.T) {
+	data := `
+scrape_configs:
+- job_name: foo
+  file_sd_configs:
+  - files: [testdata/file_sd.json]
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parase data: %s", err)
+	}
+	sws := cfg.getFileSDScrapeWork(nil)
+	if !equalStaticConfigForScrapeWorks(sws, sws) {
+		t.Fatalf("unexpected non-equal static configs;\nsws:\n%#v", sws)
+	}
+
+	// Load another static config
+	dataNew := `
+scrape_configs:
+- job_name: foo
+  file_sd_configs:
+  - files: [testdata/file_sd_1.yml]
+`
+	var cfgNew Config
+	if err := cfgNew.parse([]byte(dataNew), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	swsNew := cfgNew.getFileSDScrapeWork(sws)
+	if equalStaticConfigForScrapeWorks(swsNew, sws) {
+		t.Fatalf("unexpected equal static configs;\nswsNew:\n%#v\nsws:\n%#v", swsNew, sws)
+	}
+
+	// Try loading invalid static config
+	data = `
+scrape_configs:
+- job_name: foo
+  file_sd_configs:
+  - files: [testdata/prometheus.yml]
+`
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws = cfg.getFileSDScrapeWork(swsNew)
+	if len(sws) != 0 {
+		t.Fatalf("unexpected non-empty sws:\n%#v", sws)
+	}
+
+	// Empty target in static config
+	data = `
+scrape_configs:
+- job_name: foo
+  file_sd_configs:
+  - files: [testdata/empty_target_file_sd.yml]
+`
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws = cfg.getFileSDScrapeWork(swsNew)
+	if len(sws) != 0 {
+		t.Fatalf("unexpected non-empty sws:\n%#v", sws)
+	}
+}
+
+func TestScrapeConfigDisabled(t *testing.T) {
+	f := func(enabled string, targetsExpected int) {
+		t.Helper()
+		data := `
+scrape_configs:
+- job_name: foo
+  ` + enabled + `
+  static_configs:
+  - targets: ["foo.bar:1234", "foo.bar:5678"]
+  file_sd_configs:
+  - files: [testdata/file_sd.json]
+- job_name: bar
+  static_configs:
+  - targets: ["bar:1234"]
+`
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		n := 0
+		for i := range sws {
+			if sws[i].jobNameOriginal == "foo" {
+				n++
+			}
+		}
+		if n != targetsExpected {
+			t.Fatalf("unexpected number of static targets for job foo; got %d; want %d", n, targetsExpected)
+		}
+		if len(sws)-n != 1 {
+			t.Fatalf("unexpected number of static targets for job bar; got %d; want 1", len(sws)-n)
+		}
+		swsFileSD := cfg.getFileSDScrapeWork(nil)
+		if targetsExpected == 0 && len(swsFileSD) != 0 {
+			t.Fatalf("unexpected non-empty file_sd targets for disabled job:\n%#v", swsFileSD)
+		}
+		if targetsExpected > 0 && len(swsFileSD) == 0 {
+			t.Fatalf("expecting non-empty file_sd targets for enabled job")
+		}
+	}
+	f(``, 2)
+	f(`enabled: true`, 2)
+	f(`enabled: false`, 0)
+
+	// Disabling the job on config reload must remove all its targets from the scraper group.
+	data := `
+scrape_configs:
+- job_name: foo
+  enabled: %v
+  static_configs:
+  - targets: ["foo.bar:1234"]
+`
+	var cfg Config
+	if err := cfg.parse([]byte(fmt.Sprintf(data, true)), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sg := newScraperGroup("test_disabled_static_configs", func(wr *prompbmarshal.WriteRequest) {})
+	defer sg.stop()
+	sg.update(cfg.getStaticScrapeWork())
+	if len(sg.m) != 1 {
+		t.Fatalf("unexpected number of active targets; got %d; want 1", len(sg.m))
+	}
+	var cfgNew Config
+	if err := cfgNew.parse([]byte(fmt.Sprintf(data, false)), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sg.update(cfgNew.getStaticScrapeWork())
+	if len(sg.m) != 0 {
+		t.Fatalf("unexpected number of active targets after disabling the job; got %d; want 0", len(sg.m))
+	}
+}
+
+func TestGetStaticScrapeWorkIPv6(t *testing.T) {
+	f := func(target, scrapeURLExpected, instanceExpected, dialAddrExpected, hostExpected string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: [%q]
+`, target)
+		sws, err := getStaticScrapeWork([]byte(data), "non-existing-file")
+		if err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sw := &sws[0]
+		if sw.ScrapeURL != scrapeURLExpected {
+			t.Fatalf("unexpected ScrapeURL; got %q; want %q", sw.ScrapeURL, scrapeURLExpected)
+		}
+		if _, err := url.Parse(sw.ScrapeURL); err != nil {
+			t.Fatalf("invalid ScrapeURL %q: %s", sw.ScrapeURL, err)
+		}
+		instance := promrelabel.GetLabelValueByName(sw.Labels, "instance")
+		if instance != instanceExpected {
+			t.Fatalf("unexpected instance label; got %q; want %q", instance, instanceExpected)
+		}
+		c := newClient(sw)
+		if c.hc.Addr != dialAddrExpected {
+			t.Fatalf("unexpected dial address; got %q; want %q", c.hc.Addr, dialAddrExpected)
+		}
+		if c.host != hostExpected {
+			t.Fatalf("unexpected Host header; got %q; want %q", c.host, hostExpected)
+		}
+	}
+
+	// Plain IPv6
+	f("::1", "http://[::1]:80/metrics", "[::1]:80", "[::1]:80", "[::1]:80")
+	f("[::1]", "http://[::1]:80/metrics", "[::1]:80", "[::1]:80", "[::1]:80")
+
+	// IPv6 with port
+	f("[::1]:9100", "http://[::1]:9100/metrics", "[::1]:9100", "[::1]:9100", "[::1]:9100")
+
+	// IPv6 with zone id
+	f("fe80::1%eth0", "http://[fe80::1%25eth0]:80/metrics", "[fe80::1%eth0]:80", "[fe80::1%eth0]:80", "[fe80::1]:80")
+	f("[fe80::1%eth0]:9100", "http://[fe80::1%25eth0]:9100/metrics", "[fe80::1%eth0]:9100", "[fe80::1%eth0]:9100", "[fe80::1]:9100")
+}
+
+func TestGetStaticScrapeWorkDefaultLabels(t *testing.T) {
+	f := func(data string, labelsExpected map[string]string) {
+		t.Helper()
+		sws, err := getStaticScrapeWork([]byte(data), "non-existing-file")
+		if err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		for name, valueExpected := range labelsExpected {
+			value := promrelabel.GetLabelValueByName(sws[0].Labels, name)
+			if value != valueExpected {
+				t.Fatalf("unexpected value for label %q; got %q; want %q", name, value, valueExpected)
+			}
+		}
+	}
+
+	// Default labels are added to all the targets
+	f(`
+global:
+  default_labels:
+    cluster: c1
+    region: r1
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo.bar:1234"]
+`, map[string]string{
+		"cluster":  "c1",
+		"region":   "r1",
+		"job":      "foo",
+		"instance": "foo.bar:1234",
+	})
+
+	// Default labels are overridden by static_config labels
+	f(`
+global:
+  default_labels:
+    cluster: c1
+    region: r1
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo.bar:1234"]
+    labels:
+      cluster: c2
+`, map[string]string{
+		"cluster": "c2",
+		"region":  "r1",
+	})
+
+	// Default labels are overridden by relabeling
+	f(`
+global:
+  default_labels:
+    cluster: c1
+    region: r1
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo.bar:1234"]
+  relabel_configs:
+  - target_label: region
+    replacement: r2
+`, map[string]string{
+		"cluster": "c1",
+		"region":  "r2",
+	})
+
+	// Default labels cannot override job label
+	f(`
+global:
+  default_labels:
+    job: bar
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo.bar:1234"]
+`, map[string]string{
+		"job": "foo",
+	})
+}
+
+func TestGetStaticScrapeWorkYAMLAnchors(t *testing.T) {
+	sws, err := getStaticScrapeWork([]byte(`
+scrape_configs:
+- job_name: foo
+  scheme: https
+  tls_config: &tls
+    server_name: foo.bar
+    insecure_skip_verify: true
+  relabel_configs: &relabel
+  - target_label: env
+    replacement: prod
+  static_configs:
+  - targets: ["foo:1234"]
+- job_name: bar
+  scheme: https
+  tls_config: *tls
+  relabel_configs: *relabel
+  static_configs:
+  - targets: ["bar:1234"]
+- job_name: baz
+  tls_config:
+    <<: *tls
+    server_name: baz.bar
+  static_configs:
+  - targets: ["baz:1234"]
+`), "non-existing-file")
+	if err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	if len(sws) != 3 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 3", len(sws))
+	}
+	for _, sw := range sws[:2] {
+		if sw.AuthConfig.TLSServerName != "foo.bar" || !sw.AuthConfig.TLSInsecureSkipVerify {
+			t.Fatalf("unexpected tls config for job %q: %s", sw.jobNameOriginal, sw.AuthConfig.String())
+		}
+		if env := promrelabel.GetLabelValueByName(sw.Labels, "env"); env != "prod" {
+			t.Fatalf("unexpected env label for job %q; got %q; want %q", sw.jobNameOriginal, env, "prod")
+		}
+	}
+	if ac := sws[2].AuthConfig; ac.TLSServerName != "baz.bar" || !ac.TLSInsecureSkipVerify {
+		t.Fatalf("unexpected tls config for job %q: %s", sws[2].jobNameOriginal, ac.String())
+	}
+}
+
+func TestGetStaticScrapeWorkDefaults(t *testing.T) {
+	sws, err := getStaticScrapeWork([]byte(`
+global:
+  scrape_interval: 10s
+defaults:
+  scrape_interval: 30s
+  scrape_timeout: 5s
+  honor_labels: true
+  tls_config:
+    insecure_skip_verify: true
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo:1234"]
+- job_name: bar
+  scrape_interval: 1m
+  tls_config:
+    server_name: bar.baz
+  static_configs:
+  - targets: ["bar:1234"]
+`), "non-existing-file")
+	if err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+	f := func(sw *ScrapeWork, scrapeIntervalExpected time.Duration, serverNameExpected string, insecureSkipVerifyExpected bool) {
+		t.Helper()
+		if sw.ScrapeInterval != scrapeIntervalExpected {
+			t.Fatalf("unexpected scrape_interval for job %q; got %s; want %s", sw.jobNameOriginal, sw.ScrapeInterval, scrapeIntervalExpected)
+		}
+		if sw.ScrapeTimeout != 5*time.Second {
+			t.Fatalf("unexpected scrape_timeout for job %q; got %s; want 5s", sw.jobNameOriginal, sw.ScrapeTimeout)
+		}
+		if !sw.HonorLabels {
+			t.Fatalf("expecting honor_labels for job %q", sw.jobNameOriginal)
+		}
+		ac := sw.AuthConfig
+		if ac.TLSServerName != serverNameExpected || ac.TLSInsecureSkipVerify != insecureSkipVerifyExpected {
+			t.Fatalf("unexpected tls config for job %q: %s", sw.jobNameOriginal, ac.String())
+		}
+	}
+	// Defaults take precedence over global section.
+	f(&sws[0], 30*time.Second, "", true)
+	// Scrape config options take precedence over defaults. Nested sections aren't merged.
+	f(&sws[1], time.Minute, "bar.baz", false)
+}
+
+func TestGetStaticScrapeWorkResolvedIP(t *testing.T) {
+	lookupIPAddrOrig := lookupIPAddr
+	resolvedIPCacheOrig := resolvedIPCacheGlobal
+	defer func() {
+		lookupIPAddr = lookupIPAddrOrig
+		resolvedIPCacheGlobal = resolvedIPCacheOrig
+	}()
+	lookups := 0
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		switch host {
+		case "foo.bar":
+			return []net.IPAddr{{IP: net.ParseIP("10.1.2.3")}, {IP: net.ParseIP("10.1.2.4")}}, nil
+		case "baz.bar":
+			return []net.IPAddr{{IP: net.ParseIP("10.2.0.1")}}, nil
+		default:
+			return nil, fmt.Errorf("cannot resolve %q", host)
+		}
+	}
+	resolvedIPCacheGlobal = &resolvedIPCache{
+		m: make(map[string]resolvedIPEntry),
+	}
+
+	f := func(data string, labelsExpected []map[string]string, lookupsExpected int) {
+		t.Helper()
+		lookups = 0
+		sws, err := getStaticScrapeWork([]byte(data), "non-existing-file")
+		if err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		if len(sws) != len(labelsExpected) {
+			t.Fatalf("unexpected number of scrape works; got %d; want %d", len(sws), len(labelsExpected))
+		}
+		for i, sw := range sws {
+			for name, valueExpected := range labelsExpected[i] {
+				value := promrelabel.GetLabelValueByName(sw.Labels, name)
+				if value != valueExpected {
+					t.Fatalf("unexpected value for label %q at scrape work #%d; got %q; want %q", name, i, value, valueExpected)
+				}
+			}
+		}
+		if lookups != lookupsExpected {
+			t.Fatalf("unexpected number of dns lookups; got %d; want %d", lookups, lookupsExpected)
+		}
+	}
+
+	// The resolved ip is available to relabel_configs. The first address is used.
+	f(`
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo.bar:1234", "[::1]:80", "unknown.host"]
+  relabel_configs:
+  - source_labels: [__resolved_ip__]
+    target_label: ip
+`, []map[string]string{
+		{"instance": "foo.bar:1234", "ip": "10.1.2.3"},
+		{"instance": "[::1]:80", "ip": "::1"},
+		{"instance": "unknown.host:80", "ip": ""},
+	}, 2)
+
+	// Targets can be filtered by the resolved ip. Results for foo.bar and unknown.host are cached.
+	f(`
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo.bar:1234", "baz.bar:1234", "unknown.host"]
+  relabel_configs:
+  - source_labels: [__resolved_ip__]
+    regex: "10\\.1\\..+"
+    action: keep
+`, []map[string]string{
+		{"instance": "foo.bar:1234"},
+	}, 1)
+
+	// DNS lookups aren't performed if relabel_configs don't refer to __resolved_ip__
+	f(`
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo.bar:1234"]
+  relabel_configs:
+  - source_labels: [__address__]
+    target_label: ip
+`, []map[string]string{
+		{"ip": "foo.bar:1234"},
+	}, 0)
+}
+
+func TestAppendScrapeWorkTLSCertPerTarget(t *testing.T) {
+	data := `
+scrape_configs:
+- job_name: foo
+  scheme: https
+  tls_config:
+    cert_file: tenants/${__meta_tenant}/client.crt
+    key_file: tenants/${__meta_tenant}/client.key
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "testdata/prometheus.yml"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	swc := cfg.ScrapeConfigs[0].swc
+
+	f := func(tenant string) *ScrapeWork {
+		t.Helper()
+		sws, err := appendScrapeWork(nil, swc, "foo.bar:1234", nil, map[string]string{
+			"__meta_tenant": tenant,
+		}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sw := &sws[0]
+		certExpected, err := tls.LoadX509KeyPair("testdata/tenants/"+tenant+"/client.crt", "testdata/tenants/"+tenant+"/client.key")
+		if err != nil {
+			t.Fatalf("cannot load cert: %s", err)
+		}
+		if sw.AuthConfig.TLSCertificate == nil {
+			t.Fatalf("missing TLS certificate for tenant %q", tenant)
+		}
+		if !reflect.DeepEqual(sw.AuthConfig.TLSCertificate.Certificate, certExpected.Certificate) {
+			t.Fatalf("unexpected TLS certificate for tenant %q", tenant)
+		}
+		if promrelabel.GetLabelByName(sw.Labels, "__meta_tenant") != nil {
+			t.Fatalf("meta labels must be removed from target labels; got %s", sw.LabelsString())
+		}
+		return sw
+	}
+	swA := f("a")
+	swB := f("b")
+	if swA.key() == swB.key() {
+		t.Fatalf("targets with distinct client certificates must have distinct keys")
+	}
+
+	// Loaded certificates must be cached
+	swA2 := f("a")
+	if swA2.AuthConfig.TLSCertificate != swA.AuthConfig.TLSCertificate {
+		t.Fatalf("expecting cached TLS certificate for tenant %q", "a")
+	}
+
+	// Missing cert file
+	if _, err := appendScrapeWork(nil, swc, "foo.bar:1234", nil, map[string]string{"__meta_tenant": "missing"}, nil); err == nil {
+		t.Fatalf("expecting non-nil error for missing cert file")
+	}
+}
+
+func TestTLSCertsCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tls_certs_cache_test")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	copyFile := func(src, dst string) {
+		t.Helper()
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatalf("cannot read %q: %s", src, err)
+		}
+		if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+			t.Fatalf("cannot write %q: %s", dst, err)
+		}
+	}
+	for _, tenant := range []string{"a", "b"} {
+		copyFile("testdata/tenants/"+tenant+"/client.crt", filepath.Join(tmpDir, tenant+".crt"))
+		copyFile("testdata/tenants/"+tenant+"/client.key", filepath.Join(tmpDir, tenant+".key"))
+	}
+
+	tcc := newTLSCertsCache(2)
+	get := func(tenant string) *tls.Certificate {
+		t.Helper()
+		cert, err := tcc.get(filepath.Join(tmpDir, tenant+".crt"), filepath.Join(tmpDir, tenant+".key"))
+		if err != nil {
+			t.Fatalf("cannot load certificate for tenant %q: %s", tenant, err)
+		}
+		return cert
+	}
+
+	// Loaded certificates must be cached
+	certA := get("a")
+	if get("a") != certA {
+		t.Fatalf("expecting cached certificate for tenant %q", "a")
+	}
+
+	// Modified certificate must be re-loaded
+	copyFile("testdata/tenants/b/client.crt", filepath.Join(tmpDir, "a.crt"))
+	copyFile("testdata/tenants/b/client.key", filepath.Join(tmpDir, "a.key"))
+	modTime := time.Now().Add(time.Hour)
+	for _, path := range []string{filepath.Join(tmpDir, "a.crt"), filepath.Join(tmpDir, "a.key")} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("cannot update modification time for %q: %s", path, err)
+		}
+	}
+	certA2 := get("a")
+	if certA2 == certA {
+		t.Fatalf("expecting re-loaded certificate for tenant %q after modifying its files", "a")
+	}
+	if !reflect.DeepEqual(certA2.Certificate, get("b").Certificate) {
+		t.Fatalf("unexpected certificate loaded after modifying files for tenant %q", "a")
+	}
+
+	// The cache is reset when it reaches maxSize
+	_, err = tcc.get("testdata/tenants/a/client.crt", filepath.Join(tmpDir, "b.key"))
+	if err == nil {
+		t.Fatalf("expecting non-nil error for mismatched cert and key")
+	}
+	if n := len(tcc.m); n != 2 {
+		t.Fatalf("unexpected number of cached certificates; got %d; want 2", n)
+	}
+	copyFile(filepath.Join(tmpDir, "b.crt"), filepath.Join(tmpDir, "c.crt"))
+	copyFile(filepath.Join(tmpDir, "b.key"), filepath.Join(tmpDir, "c.key"))
+	get("c")
+	if n := len(tcc.m); n != 1 {
+		t.Fatalf("unexpected number of cached certificates after reaching maxSize; got %d; want 1", n)
+	}
+}
+
+func TestAppendScrapeWorkTLSServerNamePerTarget(t *testing.T) {
+	var serverNamesLock sync.Mutex
+	var serverNames []string
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	s.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNamesLock.Lock()
+			serverNames = append(serverNames, hello.ServerName)
+			serverNamesLock.Unlock()
+			return nil, nil
+		},
+	}
+	s.StartTLS()
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	data := `
+scrape_configs:
+- job_name: foo
+  scheme: https
+  tls_config:
+    server_name: ${__meta_tenant}.gateway.local
+    insecure_skip_verify: true
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "testdata/prometheus.yml"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	swc := cfg.ScrapeConfigs[0].swc
+
+	f := func(tenant, serverNameExpected string) *ScrapeWork {
+		t.Helper()
+		sws, err := appendScrapeWork(nil, swc, u.Host, nil, map[string]string{
+			"__meta_tenant": tenant,
+		}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sw := &sws[0]
+		if sw.AuthConfig.TLSServerName != serverNameExpected {
+			t.Fatalf("unexpected server_name; got %q; want %q", sw.AuthConfig.TLSServerName, serverNameExpected)
+		}
+
+		// Verify that the handshake uses the expected SNI.
+		serverNamesLock.Lock()
+		serverNames = nil
+		serverNamesLock.Unlock()
+		c := newClient(sw)
+		if _, err := c.ReadData(nil); err != nil {
+			t.Fatalf("unexpected error when scraping: %s", err)
+		}
+		serverNamesLock.Lock()
+		defer serverNamesLock.Unlock()
+		if len(serverNames) != 1 || serverNames[0] != serverNameExpected {
+			t.Fatalf("unexpected SNI sent during the handshake; got %q; want %q", serverNames, serverNameExpected)
+		}
+		return sw
+	}
+	swA := f("a", "a.gateway.local")
+	swB := f("b", "b.gateway.local")
+	if swA.key() == swB.key() {
+		t.Fatalf("targets with distinct server names must have distinct keys")
+	}
+
+	// Invalid hostnames
+	for _, tenant := range []string{"", "foo_bar", "-foo", "foo bar"} {
+		if _, err := appendScrapeWork(nil, swc, u.Host, nil, map[string]string{"__meta_tenant": tenant}, nil); err == nil {
+			t.Fatalf("expecting non-nil error for invalid server_name for tenant %q", tenant)
+		}
+	}
+}
+
+func getFileSDScrapeWork(data []byte, path string) ([]ScrapeWork, error) {
+	var cfg Config
+	if err := cfg.parse(data, path); err != nil {
+		return nil, fmt.Errorf("cannot parse data: %w", err)
+	}
+	return cfg.getFileSDScrapeWork(nil), nil
+}
+
+func getStaticScrapeWork(data []byte, path string) ([]ScrapeWork, error) {
+	var cfg Config
+	if err := cfg.parse(data, path); err != nil {
+		return nil, fmt.Errorf("cannot parse data: %w", err)
+	}
+	return cfg.getStaticScrapeWork(), nil
+}
+
+func TestEscapeMetricsPath(t *testing.T) {
+	f := func(metricsPath, resultExpected string) {
+		t.Helper()
+		result := escapeMetricsPath(metricsPath)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for escapeMetricsPath(%q); got %q; want %q", metricsPath, result, resultExpected)
+		}
+	}
+	f("", "")
+	f("/metrics", "/metrics")
+	f("/tenant/foo-bar_1.2~/metrics", "/tenant/foo-bar_1.2~/metrics")
+
+	// Path segment with a space
+	f("/tenant/foo bar/metrics", "/tenant/foo%20bar/metrics")
+
+	// Path segment with a slash. It is treated as path separator unless it is escaped.
+	f("/tenant/foo/bar/metrics", "/tenant/foo/bar/metrics")
+	f("/tenant/foo%2Fbar/metrics", "/tenant/foo%2Fbar/metrics")
+
+	// Chars, which are special in urls
+	f("/tenant/a#b/metrics", "/tenant/a%23b/metrics")
+	f("/tenant/100%/metrics", "/tenant/100%25/metrics")
+	f("/tenant/\u00e9/metrics", "/tenant/%C3%A9/metrics")
+
+	// Query string is left as is
+	f("/tenant/a b/metrics?x=y z", "/tenant/a%20b/metrics?x=y z")
+}
+
+func TestGetStaticScrapeWorkFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		sws, err := getStaticScrapeWork([]byte(data), "non-existing-file")
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if sws != nil {
+			t.Fatalf("expecting nil sws")
+		}
+	}
+
+	// incorrect yaml
+	f(`foo bar baz`)
+
+	// Missing job_name
+	f(`
+scrape_configs:
+- static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unknown scrape_protocols
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_protocols: [foobar]
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Negative scrape_timeout_offset
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_timeout_offset: -1s
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// job_name in defaults
+	f(`
+defaults:
+  job_name: x
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid defaults
+	f(`
+defaults:
+  scrape_interval: foo
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unsupported exposition_format
+	f(`
+scrape_configs:
+- job_name: x
+  exposition_format: foobar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// conditional_scrape with promremotewrite exposition_format
+	f(`
+scrape_configs:
+- job_name: x
+  exposition_format: promremotewrite
+  conditional_scrape: true
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Too big scrape_timeout_offset
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_timeout: 5s
+  scrape_timeout_offset: 5s
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unsupported scrape_protocols
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_protocols: [PrometheusProto]
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unsupported scrape_classic_histograms
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_classic_histograms: true
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unknown __auth_profile__ reference
+	f(`
+scrape_configs:
+- job_name: x
+  auth_profiles:
+    foo:
+      bearer_token: xyz
+  relabel_configs:
+  - target_label: __auth_profile__
+    replacement: bar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Negative parse_timeout
+	f(`
+scrape_configs:
+- job_name: x
+  parse_timeout: -1s
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// parse_timeout with conditional_scrape
+	f(`
+scrape_configs:
+- job_name: x
+  parse_timeout: 1s
+  conditional_scrape: true
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Missing secrets_file
+	f(`
+scrape_configs:
+- job_name: x
+  secrets_file: non-existing-file
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// secrets_file with inline auth
+	f(`
+scrape_configs:
+- job_name: x
+  secrets_file: testdata/secrets.yml
+  bearer_token: foo
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid metric_name_validation
+	f(`
+scrape_configs:
+- job_name: x
+  metric_name_validation: foobar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// metric_name_validation_action without legacy metric_name_validation
+	f(`
+scrape_configs:
+- job_name: x
+  metric_name_validation_action: sanitize
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid metric_name_validation_action
+	f(`
+scrape_configs:
+- job_name: x
+  metric_name_validation: legacy
+  metric_name_validation_action: foobar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid health_metrics
+	f(`
+scrape_configs:
+- job_name: x
+  health_metrics: foobar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid keep_label_names
+	f(`
+scrape_configs:
+- job_name: x
+  keep_label_names: "a("
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid drop_label_names
+	f(`
+scrape_configs:
+- job_name: x
+  drop_label_names: "a("
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// min_scrape_interval without scrape_interval_header
+	f(`
+scrape_configs:
+- job_name: x
+  min_scrape_interval: 10s
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// min_scrape_interval exceeding max_scrape_interval
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_interval_header: X-Prometheus-Scrape-Interval
+  min_scrape_interval: 1m
+  max_scrape_interval: 10s
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid grpc_method
+	f(`
+scrape_configs:
+- job_name: x
+  grpc_method: foo.Bar/Baz
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// grpc_method with stream_parse
+	f(`
+scrape_configs:
+- job_name: x
+  grpc_method: /foo.Bar/Baz
+  stream_parse: true
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unsupported method
+	f(`
+scrape_configs:
+- job_name: x
+  method: PUT
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// body without method: POST
+	f(`
+scrape_configs:
+- job_name: x
+  body: foo
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// body and body_file simultaneously
+	f(`
+scrape_configs:
+- job_name: x
+  method: POST
+  body: foo
+  body_file: testdata/body.json
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Missing body_file
+	f(`
+scrape_configs:
+- job_name: x
+  method: POST
+  body_file: testdata/missing-body.json
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// method: POST with conditional_scrape
+	f(`
+scrape_configs:
+- job_name: x
+  method: POST
+  conditional_scrape: true
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// object_store without region
+	f(`
+scrape_configs:
+- job_name: x
+  object_store:
+    endpoint: http://minio:9000
+  static_configs:
+  - targets: ["s3://bucket/prefix"]
+`)
+
+	// Unsupported dedup_within_scrape
+	f(`
+scrape_configs:
+- job_name: x
+  dedup_within_scrape: min
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// created_series with stream_parse
+	f(`
+scrape_configs:
+- job_name: x
+  created_series: attach
+  stream_parse: true
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// dedup_within_scrape with stream_parse
+	f(`
+scrape_configs:
+- job_name: x
+  dedup_within_scrape: last
+  stream_parse: true
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Negative max_decompressed_size
+	f(`
+scrape_configs:
+- job_name: x
+  max_decompressed_size: -1
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Missing url in login
+	f(`
+scrape_configs:
+- job_name: x
+  login:
+    body: foo
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unsupported method in login
+	f(`
+scrape_configs:
+- job_name: x
+  login:
+    url: /login
+    method: PUT
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid url in login
+	f(`
+scrape_configs:
+- job_name: x
+  login:
+    url: ftp://foo/login
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid instance_template
+	f(`
+scrape_configs:
+- job_name: x
+  instance_template: "${address}"
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Missing brokers in kafka_consumer
+	f(`
+scrape_configs:
+- job_name: x
+  kafka_consumer:
+    group_id: foo
+  static_configs:
+  - targets: ["kafka://metrics"]
+`)
+
+	// Negative target_limit
+	f(`
+scrape_configs:
+- job_name: x
+  target_limit: -1
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// target_limit_priority_label without target_limit
+	f(`
+scrape_configs:
+- job_name: x
+  target_limit_priority_label: priority
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// timestamp_limits without honor_timestamps
+	f(`
+scrape_configs:
+- job_name: x
+  timestamp_limits:
+    max_future: 1h
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Missing limits in timestamp_limits
+	f(`
+scrape_configs:
+- job_name: x
+  honor_timestamps: true
+  timestamp_limits:
+    action: clamp
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unsupported action in timestamp_limits
+	f(`
+scrape_configs:
+- job_name: x
+  honor_timestamps: true
+  timestamp_limits:
+    max_past: 1h
+    action: foobar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Missing user in ssh_tunnel
+	f(`
+scrape_configs:
+- job_name: x
+  ssh_tunnel:
+    host: bastion:22
+    key_file: id_rsa
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// require_metrics with stream_parse
+	f(`
+scrape_configs:
+- job_name: x
+  stream_parse: true
+  require_metrics: [process_start_time_seconds]
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Negative metrics_paths_concurrency
+	f(`
+scrape_configs:
+- job_name: x
+  metrics_paths: [/a, /b]
+  metrics_paths_concurrency: -1
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// metrics_paths_concurrency without metrics_paths
+	f(`
+scrape_configs:
+- job_name: x
+  metrics_paths_concurrency: 2
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// cloudflare_access without credentials
+	f(`
+scrape_configs:
+- job_name: x
+  cloudflare_access:
+    client_secret_file: secret
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// cloudflare_access with both token_file and client_id
+	f(`
+scrape_configs:
+- job_name: x
+  cloudflare_access:
+    client_id: foo
+    token_file: token
+  static_configs:
+  - targets: ["foo"]
+`)
 
-func TestGetFileSDScrapeWork(t *testing.T) {
-	data := `
+	// cloudflare_access with missing client_secret_file
+	f(`
 scrape_configs:
-- job_name: foo
-  file_sd_configs:
-  - files: [testdata/file_sd.json]
-`
-	var cfg Config
-	if err := cfg.parse([]byte(data), "sss"); err != nil {
-		t.Fatalf("cannot parase data: %s", err)
-	}
-	sws := cfg.getFileSDScrapeWork(nil)
-	if !equalStaticConfigForScrapeWorks(sws, sws) {
-		t.Fatalf("unexpected non-equal static configs;\nsws:\n%#v", sws)
-	}
+- job_name: x
+  cloudflare_access:
+    client_id: foo
+    client_secret_file: non-existing-file
+  static_configs:
+  - targets: ["foo"]
+`)
 
-	// Load another static config
-	dataNew := `
+	// Negative cardinality_top_n
+	f(`
 scrape_configs:
-- job_name: foo
-  file_sd_configs:
-  - files: [testdata/file_sd_1.yml]
-`
-	var cfgNew Config
-	if err := cfgNew.parse([]byte(dataNew), "sss"); err != nil {
-		t.Fatalf("cannot parse data: %s", err)
-	}
-	swsNew := cfgNew.getFileSDScrapeWork(sws)
-	if equalStaticConfigForScrapeWorks(swsNew, sws) {
-		t.Fatalf("unexpected equal static configs;\nswsNew:\n%#v\nsws:\n%#v", swsNew, sws)
-	}
+- job_name: x
+  cardinality_top_n: -1
+  static_configs:
+  - targets: ["foo"]
+`)
 
-	// Try loading invalid static config
-	data = `
+	// Negative max_redirects
+	f(`
 scrape_configs:
-- job_name: foo
-  file_sd_configs:
-  - files: [testdata/prometheus.yml]
-`
-	if err := cfg.parse([]byte(data), "sss"); err != nil {
-		t.Fatalf("cannot parse data: %s", err)
-	}
-	sws = cfg.getFileSDScrapeWork(swsNew)
-	if len(sws) != 0 {
-		t.Fatalf("unexpected non-empty sws:\n%#v", sws)
-	}
+- job_name: x
+  max_redirects: -1
+  static_configs:
+  - targets: ["foo"]
+`)
 
-	// Empty target in static config
-	data = `
+	// Invalid redirect_host_allowlist
+	f(`
 scrape_configs:
-- job_name: foo
-  file_sd_configs:
-  - files: [testdata/empty_target_file_sd.yml]
-`
-	if err := cfg.parse([]byte(data), "sss"); err != nil {
-		t.Fatalf("cannot parse data: %s", err)
-	}
-	sws = cfg.getFileSDScrapeWork(swsNew)
-	if len(sws) != 0 {
-		t.Fatalf("unexpected non-empty sws:\n%#v", sws)
-	}
-}
+- job_name: x
+  redirect_host_allowlist: ["http://foo"]
+  static_configs:
+  - targets: ["foo"]
+`)
 
-func getFileSDScrapeWork(data []byte, path string) ([]ScrapeWork, error) {
-	var cfg Config
-	if err := cfg.parse(data, path); err != nil {
-		return nil, fmt.Errorf("cannot parse data: %w", err)
-	}
-	return cfg.getFileSDScrapeWork(nil), nil
-}
+	// Unsupported response_charset
+	f(`
+scrape_configs:
+- job_name: x
+  response_charset: koi8-r
+  static_configs:
+  - targets: ["foo"]
+`)
 
-func getStaticScrapeWork(data []byte, path string) ([]ScrapeWork, error) {
-	var cfg Config
-	if err := cfg.parse(data, path); err != nil {
-		return nil, fmt.Errorf("cannot parse data: %w", err)
-	}
-	return cfg.getStaticScrapeWork(), nil
-}
+	// response_charset with exposition_format: promremotewrite
+	f(`
+scrape_configs:
+- job_name: x
+  response_charset: latin1
+  exposition_format: promremotewrite
+  static_configs:
+  - targets: ["foo"]
+`)
 
-func TestGetStaticScrapeWorkFailure(t *testing.T) {
-	f := func(data string) {
-		t.Helper()
-		sws, err := getStaticScrapeWork([]byte(data), "non-existing-file")
-		if err == nil {
-			t.Fatalf("expecting non-nil error")
-		}
-		if sws != nil {
-			t.Fatalf("expecting nil sws")
-		}
-	}
+	// Negative circuit_breaker_failures
+	f(`
+scrape_configs:
+- job_name: x
+  circuit_breaker_failures: -1
+  static_configs:
+  - targets: ["foo"]
+`)
 
-	// incorrect yaml
-	f(`foo bar baz`)
+	// Negative circuit_breaker_cooldown
+	f(`
+scrape_configs:
+- job_name: x
+  circuit_breaker_failures: 3
+  circuit_breaker_cooldown: -1s
+  static_configs:
+  - targets: ["foo"]
+`)
 
-	// Missing job_name
+	// Unsupported created_series
 	f(`
 scrape_configs:
-- static_configs:
+- job_name: x
+  created_series: foobar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Unknown scheme
+	f(`
+scrape_configs:
+- job_name: x
+  scheme: ftp
+  static_configs:
+  - targets: ["foo:1234"]
+`)
+
+	// scheme: auto with metrics_paths
+	f(`
+scrape_configs:
+- job_name: x
+  scheme: auto
+  metrics_paths: [/metrics, /other]
+  static_configs:
+  - targets: ["foo:1234"]
+`)
+
+	// Unknown __metric_relabel_profile__ reference
+	f(`
+scrape_configs:
+- job_name: x
+  metric_relabel_profiles:
+    foo:
+    - action: drop
+      source_labels: [__name__]
+      regex: foo
+  relabel_configs:
+  - target_label: __metric_relabel_profile__
+    replacement: bar
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid metric_relabel_profiles entry
+	f(`
+scrape_configs:
+- job_name: x
+  metric_relabel_profiles:
+    foo:
+    - action: unknown
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Invalid auth_profiles entry
+	f(`
+scrape_configs:
+- job_name: x
+  auth_profiles:
+    foo:
+      bearer_token: xyz
+      bearer_token_file: /path/to/file
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// Duplicate scrape_protocols
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_protocols: [OpenMetricsText1.0.0, OpenMetricsText1.0.0]
+  static_configs:
   - targets: ["foo"]
 `)
 
@@ -484,11 +1894,12 @@ scrape_configs:
   - files: ["testdata/file_sd.json", "testdata/file_sd*.yml"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:       "http://host1:80/abc/de",
-			ScrapeInterval:  defaultScrapeInterval,
-			ScrapeTimeout:   defaultScrapeTimeout,
-			HonorLabels:     false,
-			HonorTimestamps: false,
+			ScrapeURL:           "http://host1:80/abc/de",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         false,
+			HonorTimestamps:     false,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -523,11 +1934,12 @@ scrape_configs:
 			jobNameOriginal: "foo",
 		},
 		{
-			ScrapeURL:       "http://host2:80/abc/de",
-			ScrapeInterval:  defaultScrapeInterval,
-			ScrapeTimeout:   defaultScrapeTimeout,
-			HonorLabels:     false,
-			HonorTimestamps: false,
+			ScrapeURL:           "http://host2:80/abc/de",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         false,
+			HonorTimestamps:     false,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -562,11 +1974,12 @@ scrape_configs:
 			jobNameOriginal: "foo",
 		},
 		{
-			ScrapeURL:       "http://localhost:9090/abc/de",
-			ScrapeInterval:  defaultScrapeInterval,
-			ScrapeTimeout:   defaultScrapeTimeout,
-			HonorLabels:     false,
-			HonorTimestamps: false,
+			ScrapeURL:           "http://localhost:9090/abc/de",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         false,
+			HonorTimestamps:     false,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -623,11 +2036,12 @@ scrape_configs:
   - targets: ["foo.bar:1234"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:       "http://foo.bar:1234/metrics",
-			ScrapeInterval:  defaultScrapeInterval,
-			ScrapeTimeout:   defaultScrapeTimeout,
-			HonorLabels:     false,
-			HonorTimestamps: false,
+			ScrapeURL:           "http://foo.bar:1234/metrics",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         false,
+			HonorTimestamps:     false,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -665,11 +2079,12 @@ scrape_configs:
   - targets: ["foo.bar:1234"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:       "http://foo.bar:1234/metrics",
-			ScrapeInterval:  defaultScrapeInterval,
-			ScrapeTimeout:   defaultScrapeTimeout,
-			HonorLabels:     false,
-			HonorTimestamps: false,
+			ScrapeURL:           "http://foo.bar:1234/metrics",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         false,
+			HonorTimestamps:     false,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -735,11 +2150,12 @@ scrape_configs:
   - targets: [1.2.3.4]
 `, []ScrapeWork{
 		{
-			ScrapeURL:       "https://foo.bar:443/foo/bar?p=x%26y&p=%3D",
-			ScrapeInterval:  543 * time.Second,
-			ScrapeTimeout:   12 * time.Second,
-			HonorLabels:     true,
-			HonorTimestamps: true,
+			ScrapeURL:           "https://foo.bar:443/foo/bar?p=x%26y&p=%3D",
+			ScrapeInterval:      543 * time.Second,
+			ScrapeTimeout:       12 * time.Second,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         true,
+			HonorTimestamps:     true,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -776,11 +2192,12 @@ scrape_configs:
 			jobNameOriginal: "foo",
 		},
 		{
-			ScrapeURL:       "https://aaa:443/foo/bar?p=x%26y&p=%3D",
-			ScrapeInterval:  543 * time.Second,
-			ScrapeTimeout:   12 * time.Second,
-			HonorLabels:     true,
-			HonorTimestamps: true,
+			ScrapeURL:           "https://aaa:443/foo/bar?p=x%26y&p=%3D",
+			ScrapeInterval:      543 * time.Second,
+			ScrapeTimeout:       12 * time.Second,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         true,
+			HonorTimestamps:     true,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -817,11 +2234,12 @@ scrape_configs:
 			jobNameOriginal: "foo",
 		},
 		{
-			ScrapeURL:       "http://1.2.3.4:80/metrics",
-			ScrapeInterval:  8 * time.Second,
-			ScrapeTimeout:   34 * time.Second,
-			HonorLabels:     false,
-			HonorTimestamps: false,
+			ScrapeURL:           "http://1.2.3.4:80/metrics",
+			ScrapeInterval:      8 * time.Second,
+			ScrapeTimeout:       34 * time.Second,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
+			HonorLabels:         false,
+			HonorTimestamps:     false,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -889,9 +2307,10 @@ scrape_configs:
   - targets: ["foo.bar:1234", "drop-this-target"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://foo.bar:1234/metrics?x=keep_me",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://foo.bar:1234/metrics?x=keep_me",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -959,9 +2378,10 @@ scrape_configs:
   - targets: ["foo.bar:1234"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "mailto://foo.bar:1234/abc.de?a=b",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "mailto://foo.bar:1234/abc.de?a=b",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1014,9 +2434,10 @@ scrape_configs:
   - targets: ["foo.bar:1234", "xyz"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://foo.bar:1234/metrics",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://foo.bar:1234/metrics",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1053,9 +2474,10 @@ scrape_configs:
   - targets: ["foo.bar:1234"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://foo.bar:1234/metrics",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://foo.bar:1234/metrics",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1093,9 +2515,10 @@ scrape_configs:
   - targets: ["foo.bar:1234"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://foo.bar:1234/metrics",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://foo.bar:1234/metrics",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1132,9 +2555,10 @@ scrape_configs:
   - targets: ["foo.bar:1234"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://foo.bar:1234/metrics",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://foo.bar:1234/metrics",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1177,9 +2601,10 @@ scrape_configs:
   - targets: ["foo.bar:1234"]
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://foo.bar:1234/metrics",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://foo.bar:1234/metrics",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1229,9 +2654,10 @@ scrape_configs:
       job: yyy
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://pp:80/metrics?a=c&a=xy",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://pp:80/metrics?a=c&a=xy",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1292,9 +2718,10 @@ scrape_configs:
         replacement: 127.0.0.1:9116  # The SNMP exporter's real hostname:port.
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://127.0.0.1:9116/snmp?module=if_mib&target=192.168.1.2",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://127.0.0.1:9116/snmp?module=if_mib&target=192.168.1.2",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1343,9 +2770,10 @@ scrape_configs:
     target_label: __metrics_path__
 `, []ScrapeWork{
 		{
-			ScrapeURL:      "http://foo.bar:1234/metricspath",
-			ScrapeInterval: defaultScrapeInterval,
-			ScrapeTimeout:  defaultScrapeTimeout,
+			ScrapeURL:           "http://foo.bar:1234/metricspath",
+			ScrapeInterval:      defaultScrapeInterval,
+			ScrapeTimeout:       defaultScrapeTimeout,
+			ScrapeTimeoutOffset: defaultScrapeTimeoutOffset,
 			Labels: []prompbmarshal.Label{
 				{
 					Name:  "__address__",
@@ -1389,3 +2817,38 @@ func equalStaticConfigForScrapeWorks(a, b []ScrapeWork) bool {
 	}
 	return true
 }
+
+func TestAppendScrapeWorkInternsLabels(t *testing.T) {
+	data := `
+scrape_configs:
+- job_name: intern
+  static_configs:
+  - targets: ["foo"]
+    labels:
+      cluster: prod
+  - targets: ["bar"]
+    labels:
+      cluster: prod
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+	stringData := func(s string) uintptr {
+		return (*(*[2]uintptr)(unsafe.Pointer(&s)))[0]
+	}
+	for _, name := range []string{"cluster", "job"} {
+		v1 := promrelabel.GetLabelByName(sws[0].Labels, name)
+		v2 := promrelabel.GetLabelByName(sws[1].Labels, name)
+		if v1 == nil || v2 == nil {
+			t.Fatalf("missing %q label", name)
+		}
+		if stringData(v1.Name) != stringData(v2.Name) || stringData(v1.Value) != stringData(v2.Value) {
+			t.Fatalf("expecting the same backing storage for %q label shared by distinct targets", name)
+		}
+	}
+}
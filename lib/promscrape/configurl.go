@@ -0,0 +1,122 @@
+package promscrape
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+)
+
+var (
+	configURLBasicAuthUsername = flag.String("promscrape.config.basicAuth.username", "", "Optional basic auth username to use when '-promscrape.config' "+
+		"points to http or https url")
+	configURLBasicAuthPassword = flag.String("promscrape.config.basicAuth.password", "", "Optional basic auth password to use when '-promscrape.config' "+
+		"points to http or https url")
+	configURLBearerToken = flag.String("promscrape.config.bearerToken", "", "Optional bearer auth token to use when '-promscrape.config' points to http or https url")
+	configURLTimeout     = flag.Duration("promscrape.config.fetchTimeout", 30*time.Second, "Timeout for fetching '-promscrape.config' when it points to http or https url")
+)
+
+// isHTTPURL returns true if path is http or https url.
+func isHTTPURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// readConfigData reads config data from the given path, which may point either to local file or to http(s) url.
+func readConfigData(path string) ([]byte, error) {
+	if !isHTTPURL(path) {
+		return ioutil.ReadFile(path)
+	}
+	return configURLCacheGlobal.fetch(path)
+}
+
+var configURLCacheGlobal = &configURLCache{
+	m: make(map[string]*configURLEntry),
+}
+
+// configURLCache holds the last config data obtained from config urls.
+//
+// It is used for conditional requests, so the config isn't transferred
+// over the network if it remains unchanged since the previous fetch.
+type configURLCache struct {
+	mu sync.Mutex
+	m  map[string]*configURLEntry
+}
+
+type configURLEntry struct {
+	data         []byte
+	etag         string
+	lastModified string
+}
+
+func (cuc *configURLCache) fetch(configURL string) ([]byte, error) {
+	cuc.mu.Lock()
+	defer cuc.mu.Unlock()
+
+	e := cuc.m[configURL]
+	req, err := http.NewRequest("GET", configURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request for %q: %w", configURL, err)
+	}
+	if err := setConfigURLAuthHeader(req); err != nil {
+		return nil, err
+	}
+	if e != nil {
+		if e.etag != "" {
+			req.Header.Set("If-None-Match", e.etag)
+		}
+		if e.lastModified != "" {
+			req.Header.Set("If-Modified-Since", e.lastModified)
+		}
+	}
+	c := &http.Client{
+		Timeout: *configURLTimeout,
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch %q: %w", configURL, err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response from %q: %w", configURL, err)
+	}
+	if resp.StatusCode == http.StatusNotModified && e != nil {
+		return e.data, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code returned from %q: %d; expecting %d; response body: %q",
+			configURL, resp.StatusCode, http.StatusOK, data)
+	}
+	cuc.m[configURL] = &configURLEntry{
+		data:         data,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	return data, nil
+}
+
+func setConfigURLAuthHeader(req *http.Request) error {
+	var basicAuth *promauth.BasicAuthConfig
+	if *configURLBasicAuthUsername != "" || *configURLBasicAuthPassword != "" {
+		basicAuth = &promauth.BasicAuthConfig{
+			Username: *configURLBasicAuthUsername,
+			Password: *configURLBasicAuthPassword,
+		}
+	}
+	if basicAuth != nil && *configURLBearerToken != "" {
+		return fmt.Errorf("`-promscrape.config.bearerToken` cannot be set together with `-promscrape.config.basicAuth.*` flags")
+	}
+	ac, err := promauth.NewConfig(".", basicAuth, *configURLBearerToken, "", nil)
+	if err != nil {
+		return fmt.Errorf("cannot initialize auth config for `-promscrape.config`: %w", err)
+	}
+	if ac.Authorization != "" {
+		req.Header.Set("Authorization", ac.Authorization)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package promscrape
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errUnexpectedContentType is returned if the response has unexpected Content-Type and its body doesn't look like metrics.
+//
+// Such responses are usually returned by misconfigured reverse proxies with 200 status code, e.g. HTML error pages.
+var errUnexpectedContentType = errors.New("unexpected Content-Type")
+
+// contentTypeSniffLen is the maximum number of bytes from the start of the response body, which are inspected by looksLikeExposition.
+const contentTypeSniffLen = 512
+
+// checkExpositionContentType returns an error if the response with the given contentType and body prefix doesn't look like metrics in text exposition format.
+//
+// The body isn't inspected for responses with recognized exposition content types.
+func checkExpositionContentType(contentType string, prefix []byte) error {
+	if isExpositionContentType(contentType) || looksLikeExposition(prefix) {
+		return nil
+	}
+	if len(prefix) > 64 {
+		prefix = prefix[:64]
+	}
+	return fmt.Errorf("%w %q; the response body doesn't look like metrics in text exposition format; the body starts with %q", errUnexpectedContentType, contentType, prefix)
+}
+
+// isExpositionContentType returns true if contentType refers to Prometheus text or OpenMetrics text format.
+func isExpositionContentType(contentType string) bool {
+	n := strings.IndexByte(contentType, ';')
+	if n >= 0 {
+		contentType = contentType[:n]
+	}
+	switch strings.ToLower(strings.TrimSpace(contentType)) {
+	case "text/plain", "application/openmetrics-text":
+		return true
+	default:
+		return false
+	}
+}
+
+// looksLikeExposition returns true if prefix looks like the start of metrics in text exposition format.
+//
+// The first non-empty line must be either a comment or must start with a metric name followed by labels or by value.
+func looksLikeExposition(prefix []byte) bool {
+	s := strings.TrimLeft(string(prefix), " \t\r\n")
+	if len(s) == 0 || s[0] == '#' {
+		return true
+	}
+	n := 0
+	for n < len(s) && isMetricNameChar(s[n], n == 0) {
+		n++
+	}
+	if n == 0 {
+		return false
+	}
+	if n == len(s) {
+		// The metric name may be truncated by the prefix length.
+		return len(prefix) >= contentTypeSniffLen
+	}
+	switch s[n] {
+	case '{', ' ', '\t':
+		return true
+	default:
+		return false
+	}
+}
+
+func isMetricNameChar(c byte, isFirst bool) bool {
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == ':' {
+		return true
+	}
+	return !isFirst && c >= '0' && c <= '9'
+}
+
+// contentTypeCheckReader checks Content-Type for stream parsing mode by peeking the start of the response body.
+type contentTypeCheckReader struct {
+	*bufio.Reader
+	body io.Closer
+}
+
+func (r *contentTypeCheckReader) Close() error {
+	return r.body.Close()
+}
+
+// newContentTypeCheckReader returns a reader for body, which has been checked with checkExpositionContentType.
+func newContentTypeCheckReader(body io.ReadCloser, contentType string) (io.ReadCloser, error) {
+	if isExpositionContentType(contentType) {
+		return body, nil
+	}
+	br := bufio.NewReaderSize(body, contentTypeSniffLen)
+	prefix, err := br.Peek(contentTypeSniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if err := checkExpositionContentType(contentType, prefix); err != nil {
+		return nil, err
+	}
+	return &contentTypeCheckReader{
+		Reader: br,
+		body:   body,
+	}, nil
+}
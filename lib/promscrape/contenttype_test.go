@@ -0,0 +1,128 @@
+package promscrape
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+)
+
+func TestCheckExpositionContentType(t *testing.T) {
+	f := func(contentType, body string, resultExpected bool) {
+		t.Helper()
+		err := checkExpositionContentType(contentType, []byte(body))
+		if result := err == nil; result != resultExpected {
+			t.Fatalf("unexpected result for Content-Type=%q, body=%q; got %v; want %v; err: %v", contentType, body, result, resultExpected, err)
+		}
+		if err != nil && !errors.Is(err, errUnexpectedContentType) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// Recognized exposition content types
+	f("text/plain; version=0.0.4; charset=utf-8", "<html></html>", true)
+	f("application/openmetrics-text; version=1.0.0", "", true)
+	f("Text/Plain", "foo 1", true)
+
+	// Unrecognized content types with exposition body
+	f("", "foo 1\n", true)
+	f("text/html", "\n\n# HELP foo bar\nfoo 1\n", true)
+	f("application/octet-stream", `foo{bar="baz"} 1`, true)
+	f("text/html", "", true)
+
+	// Unrecognized content types with non-exposition body
+	f("text/html", "<!DOCTYPE html><html><body>Bad Gateway</body></html>", false)
+	f("text/html; charset=utf-8", "  <html>", false)
+	f("application/json", `{"status":"error"}`, false)
+	f("", "404 page not found", false)
+	f("", "foo", false)
+}
+
+func TestScrapeWorkCheckContentType(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, "<html><body>Login: <input name=user> 1</body></html>\n")
+		case "/misconfigured":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "foo 1\n")
+		}
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse, checkContentType bool, path string, upExpected float64) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: content_type
+  stream_parse: %v
+  check_content_type: %v
+  metrics_path: %s
+  static_configs:
+  - targets: [%q]
+`, streamParse, checkContentType, path, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		up := -1.0
+		samplesScraped := -1.0
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				switch promrelabel.GetLabelValueByName(ts.Labels, "__name__") {
+				case "up":
+					up = ts.Samples[0].Value
+				case "scrape_samples_scraped":
+					samplesScraped = ts.Samples[0].Value
+				}
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		err := sc.sw.scrapeInternal(123000, 123000)
+		if upExpected == 0 {
+			if !errors.Is(err, errUnexpectedContentType) {
+				t.Fatalf("unexpected error; got %v; want %v", err, errUnexpectedContentType)
+			}
+			if reason := getScrapeErrorReason(err); reason != "unexpected_content_type" {
+				t.Fatalf("unexpected error reason; got %q; want %q", reason, "unexpected_content_type")
+			}
+		} else if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if up != upExpected {
+			t.Fatalf("unexpected up; got %v; want %v", up, upExpected)
+		}
+		if upExpected == 0 && samplesScraped != 0 {
+			t.Fatalf("unexpected scrape_samples_scraped for HTML response; got %v; want 0", samplesScraped)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		// HTML response with 200 status code must fail the scrape.
+		f(streamParse, true, "/html", 0)
+
+		// Exposition body with unexpected Content-Type must be scraped.
+		f(streamParse, true, "/misconfigured", 1)
+
+		// HTML response is passed to the parser if the check is disabled.
+		f(streamParse, false, "/html", 1)
+	}
+}
@@ -0,0 +1,179 @@
+package promscrape
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+)
+
+// Supported values for `created_series` option in `scrape_config`.
+//
+// The option controls OpenMetrics `<name>_created` series exposed for counters, histograms and summaries.
+const (
+	// createdSeriesForward forwards `_created` series as ordinary series. This is the default.
+	createdSeriesForward = "forward"
+
+	// createdSeriesDrop drops `_created` series.
+	createdSeriesDrop = "drop"
+
+	// createdSeriesAttach drops `_created` series for counters and attaches a sample with zero value
+	// at the created time to the corresponding `<name>_total` series.
+	// The zero sample is pushed only when the created time changes, e.g. on the first scrape or after counter reset.
+	// `_created` series for histograms and summaries are forwarded as is.
+	createdSeriesAttach = "attach"
+)
+
+// validateCreatedSeries verifies whether mode is supported `created_series` value.
+func validateCreatedSeries(mode string) error {
+	switch mode {
+	case "", createdSeriesForward, createdSeriesDrop, createdSeriesAttach:
+		return nil
+	default:
+		return fmt.Errorf("unsupported `created_series` %q; supported values: %q, %q, %q", mode, createdSeriesForward, createdSeriesDrop, createdSeriesAttach)
+	}
+}
+
+// needCreatedSeriesProcessing returns true if `_created` series must be modified according to the given `created_series` mode.
+func needCreatedSeriesProcessing(mode string) bool {
+	return mode == createdSeriesDrop || mode == createdSeriesAttach
+}
+
+// processCreatedRows handles `_created` rows according to Config.CreatedSeries and returns the remaining rows.
+//
+// rows are modified in place.
+func (sw *scrapeWork) processCreatedRows(rows []parser.Row) []parser.Row {
+	mode := sw.Config.CreatedSeries
+	if !needCreatedSeriesProcessing(mode) {
+		return rows
+	}
+	if sw.createdSeries == nil {
+		sw.createdSeries = newCreatedSeriesState()
+	}
+	return sw.createdSeries.processRows(rows, mode)
+}
+
+// createdSeriesState contains the state for handling `_created` series according to ScrapeWork.CreatedSeries.
+type createdSeriesState struct {
+	// families contains `<name>_total` and `<name>_count` families seen during the current processRows call.
+	// The key is obtained from <name> and labels, while the value is the family suffix.
+	families map[string]string
+
+	// startTimestamps contains start timestamps in milliseconds for counters during the current scrape.
+	// The key is obtained from <name> and labels of `<name>_total` series.
+	startTimestamps map[string]int64
+
+	// pushedStartTimestamps contains start timestamps already pushed as zero samples for the corresponding counters.
+	pushedStartTimestamps map[string]int64
+
+	keyBuf []byte
+}
+
+func newCreatedSeriesState() *createdSeriesState {
+	return &createdSeriesState{
+		families:              make(map[string]string),
+		startTimestamps:       make(map[string]int64),
+		pushedStartTimestamps: make(map[string]int64),
+	}
+}
+
+// processRows removes `_created` rows for counters, histograms and summaries from rows according to mode.
+//
+// Start timestamps for counters are collected for attaching them via getStartTimestamp if mode is createdSeriesAttach.
+// rows are modified in place.
+func (css *createdSeriesState) processRows(rows []parser.Row, mode string) []parser.Row {
+	for k := range css.families {
+		delete(css.families, k)
+	}
+	for i := range rows {
+		r := &rows[i]
+		switch {
+		case strings.HasSuffix(r.Metric, "_total"):
+			css.keyBuf = appendCreatedSeriesKey(css.keyBuf[:0], strings.TrimSuffix(r.Metric, "_total"), r.Tags)
+			css.families[string(css.keyBuf)] = "_total"
+		case strings.HasSuffix(r.Metric, "_count"):
+			css.keyBuf = appendCreatedSeriesKey(css.keyBuf[:0], strings.TrimSuffix(r.Metric, "_count"), r.Tags)
+			css.families[string(css.keyBuf)] = "_count"
+		}
+	}
+	if len(css.families) == 0 {
+		return rows
+	}
+	dst := rows[:0]
+	for i := range rows {
+		r := &rows[i]
+		if !strings.HasSuffix(r.Metric, "_created") {
+			dst = append(dst, *r)
+			continue
+		}
+		css.keyBuf = appendCreatedSeriesKey(css.keyBuf[:0], strings.TrimSuffix(r.Metric, "_created"), r.Tags)
+		suffix, ok := css.families[string(css.keyBuf)]
+		if !ok {
+			// This isn't `_created` series for counter, histogram or summary.
+			dst = append(dst, *r)
+			continue
+		}
+		if mode == createdSeriesDrop {
+			continue
+		}
+		if suffix != "_total" {
+			dst = append(dst, *r)
+			continue
+		}
+		if r.Value > 0 && !math.IsInf(r.Value, 0) {
+			// OpenMetrics exposes the created time in Unix seconds.
+			css.startTimestamps[string(css.keyBuf)] = int64(r.Value * 1e3)
+		}
+	}
+	return dst
+}
+
+// getStartTimestamp returns start timestamp for the counter row r, which must be pushed as zero sample before the sample with the given timestamp.
+//
+// false is returned if r isn't a counter with `_created` series or if its start timestamp has been already pushed.
+func (css *createdSeriesState) getStartTimestamp(r *parser.Row, timestamp int64) (int64, bool) {
+	if len(css.startTimestamps) == 0 || !strings.HasSuffix(r.Metric, "_total") {
+		return 0, false
+	}
+	css.keyBuf = appendCreatedSeriesKey(css.keyBuf[:0], strings.TrimSuffix(r.Metric, "_total"), r.Tags)
+	startTimestamp, ok := css.startTimestamps[string(css.keyBuf)]
+	if !ok || startTimestamp >= timestamp {
+		return 0, false
+	}
+	if css.pushedStartTimestamps[string(css.keyBuf)] == startTimestamp {
+		return 0, false
+	}
+	css.pushedStartTimestamps[string(css.keyBuf)] = startTimestamp
+	return startTimestamp, true
+}
+
+// finalizeScrape must be called after all the rows for the current scrape are pushed.
+//
+// It removes pushed start timestamps for counters, which disappeared from the current scrape.
+// The pushed start timestamps are preserved if keepPushed is set, e.g. when the previously scraped rows are re-used.
+func (css *createdSeriesState) finalizeScrape(keepPushed bool) {
+	if !keepPushed {
+		for k := range css.pushedStartTimestamps {
+			if _, ok := css.startTimestamps[k]; !ok {
+				delete(css.pushedStartTimestamps, k)
+			}
+		}
+	}
+	for k := range css.startTimestamps {
+		delete(css.startTimestamps, k)
+	}
+}
+
+// appendCreatedSeriesKey appends key for the metric family with the given name and tags to dst.
+func appendCreatedSeriesKey(dst []byte, name string, tags []parser.Tag) []byte {
+	dst = append(dst, name...)
+	for i := range tags {
+		tag := &tags[i]
+		dst = append(dst, 0)
+		dst = append(dst, tag.Key...)
+		dst = append(dst, 0)
+		dst = append(dst, tag.Value...)
+	}
+	return dst
+}
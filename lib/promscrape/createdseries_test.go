@@ -0,0 +1,115 @@
+package promscrape
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkCreatedSeries(t *testing.T) {
+	const openMetricsData = `# TYPE foo counter
+foo_total{a="b"} 5
+foo_created{a="b"} 1600000000
+# TYPE bar histogram
+bar_bucket{le="+Inf"} 3
+bar_count 3
+bar_sum 1.5
+bar_created 1600000001
+# TYPE baz_created gauge
+baz_created 1600000002
+`
+	f := func(mode string, bodies []string, resultsExpected []string) {
+		t.Helper()
+		var sw scrapeWork
+		sw.Config = ScrapeWork{
+			ScrapeURL:     "http://foo.bar/metrics",
+			CreatedSeries: mode,
+		}
+		var body string
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			return append(dst, body...), nil
+		}
+		var samples []string
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if strings.HasPrefix(name, "scrape_") || name == "up" {
+					continue
+				}
+				for _, s := range ts.Samples {
+					samples = append(samples, fmt.Sprintf("%s %v %d", name, s.Value, s.Timestamp))
+				}
+			}
+		}
+		for i, b := range bodies {
+			body = b
+			samples = samples[:0]
+			timestamp := int64(1700000000000 + i*1000)
+			if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			sort.Strings(samples)
+			result := strings.Join(samples, "\n")
+			if result != resultsExpected[i] {
+				t.Fatalf("unexpected samples on scrape #%d for created_series=%q;\ngot\n%s\nwant\n%s", i, mode, result, resultsExpected[i])
+			}
+		}
+	}
+
+	// `_created` series are forwarded as is by default.
+	resultForward := `bar_bucket 3 1700000000000
+bar_count 3 1700000000000
+bar_created 1.600000001e+09 1700000000000
+bar_sum 1.5 1700000000000
+baz_created 1.600000002e+09 1700000000000
+foo_created 1.6e+09 1700000000000
+foo_total 5 1700000000000`
+	f("", []string{openMetricsData}, []string{resultForward})
+	f("forward", []string{openMetricsData}, []string{resultForward})
+
+	// `_created` series for counters, histograms and summaries are dropped.
+	// Gauge with `_created` suffix is left as is.
+	resultDrop := `bar_bucket 3 1700000000000
+bar_count 3 1700000000000
+bar_sum 1.5 1700000000000
+baz_created 1.600000002e+09 1700000000000
+foo_total 5 1700000000000`
+	f("drop", []string{openMetricsData}, []string{resultDrop})
+
+	// Stream parsing enabled via -promscrape.streamParse is disabled for targets with created_series, so it is still applied.
+	streamParseOrig := *streamParse
+	*streamParse = true
+	defer func() {
+		*streamParse = streamParseOrig
+	}()
+	f("drop", []string{openMetricsData}, []string{resultDrop})
+
+	// Zero sample at the created time is attached to the counter only when the created time changes.
+	counterReset := strings.Replace(openMetricsData, `foo_created{a="b"} 1600000000`, `foo_created{a="b"} 1650000000`, 1)
+	f("attach", []string{openMetricsData, openMetricsData, counterReset}, []string{
+		`bar_bucket 3 1700000000000
+bar_count 3 1700000000000
+bar_created 1.600000001e+09 1700000000000
+bar_sum 1.5 1700000000000
+baz_created 1.600000002e+09 1700000000000
+foo_total 0 1600000000000
+foo_total 5 1700000000000`,
+		`bar_bucket 3 1700000001000
+bar_count 3 1700000001000
+bar_created 1.600000001e+09 1700000001000
+bar_sum 1.5 1700000001000
+baz_created 1.600000002e+09 1700000001000
+foo_total 5 1700000001000`,
+		`bar_bucket 3 1700000002000
+bar_count 3 1700000002000
+bar_created 1.600000001e+09 1700000002000
+bar_sum 1.5 1700000002000
+baz_created 1.600000002e+09 1700000002000
+foo_total 0 1650000000000
+foo_total 5 1700000002000`,
+	})
+}
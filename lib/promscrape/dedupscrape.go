@@ -0,0 +1,90 @@
+package promscrape
+
+import (
+	"fmt"
+	"math"
+
+	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+)
+
+// Supported values for `dedup_within_scrape` option in `scrape_config`.
+//
+// The option controls how samples for the same series exposed multiple times in a single scrape response are collapsed.
+// All the samples are pushed if the option isn't set.
+const (
+	// dedupWithinScrapeLast leaves the last sample for the series.
+	dedupWithinScrapeLast = "last"
+
+	// dedupWithinScrapeMax leaves the sample with the maximum value for the series.
+	dedupWithinScrapeMax = "max"
+
+	// dedupWithinScrapeSum leaves a single sample with the sum of values for the series and the timestamp of the last sample.
+	dedupWithinScrapeSum = "sum"
+)
+
+// validateDedupWithinScrape verifies whether policy is supported `dedup_within_scrape` value.
+func validateDedupWithinScrape(policy string) error {
+	switch policy {
+	case "", dedupWithinScrapeLast, dedupWithinScrapeMax, dedupWithinScrapeSum:
+		return nil
+	default:
+		return fmt.Errorf("unsupported `dedup_within_scrape` %q; supported values: %q, %q, %q", policy, dedupWithinScrapeLast, dedupWithinScrapeMax, dedupWithinScrapeSum)
+	}
+}
+
+// dedupRowsState contains the state for collapsing duplicate rows according to ScrapeWork.DedupWithinScrape.
+type dedupRowsState struct {
+	// rowIdxs contains indexes of the collapsed rows. The key is obtained from metric name and labels.
+	rowIdxs map[string]int
+
+	keyBuf []byte
+}
+
+// dedupRows collapses rows for the same series according to Config.DedupWithinScrape and returns the remaining rows.
+//
+// The remaining rows preserve the order of the first occurrence of every series. rows are modified in place.
+func (sw *scrapeWork) dedupRows(rows []parser.Row) []parser.Row {
+	policy := sw.Config.DedupWithinScrape
+	if policy == "" || len(rows) < 2 {
+		return rows
+	}
+	if sw.dedupState == nil {
+		sw.dedupState = &dedupRowsState{
+			rowIdxs: make(map[string]int),
+		}
+	}
+	return sw.dedupState.dedupRows(rows, policy)
+}
+
+func (drs *dedupRowsState) dedupRows(rows []parser.Row, policy string) []parser.Row {
+	for k := range drs.rowIdxs {
+		delete(drs.rowIdxs, k)
+	}
+	dst := rows[:0]
+	for i := range rows {
+		r := rows[i]
+		drs.keyBuf = appendCreatedSeriesKey(drs.keyBuf[:0], r.Metric, r.Tags)
+		idx, ok := drs.rowIdxs[string(drs.keyBuf)]
+		if !ok {
+			drs.rowIdxs[string(drs.keyBuf)] = len(dst)
+			dst = append(dst, r)
+			continue
+		}
+		d := &dst[idx]
+		switch policy {
+		case dedupWithinScrapeLast:
+			d.Value = r.Value
+			d.Timestamp = r.Timestamp
+		case dedupWithinScrapeMax:
+			// NaN values lose to any other value.
+			if r.Value > d.Value || math.IsNaN(d.Value) {
+				d.Value = r.Value
+				d.Timestamp = r.Timestamp
+			}
+		case dedupWithinScrapeSum:
+			d.Value += r.Value
+			d.Timestamp = r.Timestamp
+		}
+	}
+	return dst
+}
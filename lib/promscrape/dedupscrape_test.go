@@ -0,0 +1,84 @@
+package promscrape
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkDedupWithinScrape(t *testing.T) {
+	const body = `foo{a="b"} 3 1700000001000
+bar 1
+foo{a="b"} 7 1700000002000
+foo{a="c"} 10
+foo{a="b"} 5 1700000003000
+`
+	f := func(policy string, resultExpected string) {
+		t.Helper()
+		var sw scrapeWork
+		sw.Config = ScrapeWork{
+			ScrapeURL:         "http://foo.bar/metrics",
+			HonorTimestamps:   true,
+			DedupWithinScrape: policy,
+		}
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			return append(dst, body...), nil
+		}
+		var samples []string
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if strings.HasPrefix(name, "scrape_") && name != "scrape_samples_scraped" || name == "up" {
+					continue
+				}
+				a := promrelabel.GetLabelValueByName(ts.Labels, "a")
+				for _, s := range ts.Samples {
+					samples = append(samples, fmt.Sprintf("%s{a=%q} %v %d", name, a, s.Value, s.Timestamp))
+				}
+			}
+		}
+		// Run the scrape twice in order to verify the state is reset between scrapes.
+		for i := 0; i < 2; i++ {
+			samples = samples[:0]
+			timestamp := int64(1700000000000)
+			if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			sort.Strings(samples)
+			result := strings.Join(samples, "\n")
+			if result != resultExpected {
+				t.Fatalf("unexpected samples on scrape #%d for dedup_within_scrape=%q;\ngot\n%s\nwant\n%s", i, policy, result, resultExpected)
+			}
+		}
+	}
+
+	// All the samples are pushed by default.
+	f("", `bar{a=""} 1 1700000000000
+foo{a="b"} 3 1700000001000
+foo{a="b"} 5 1700000003000
+foo{a="b"} 7 1700000002000
+foo{a="c"} 10 1700000000000
+scrape_samples_scraped{a=""} 5 1700000000000`)
+
+	// The last sample is left for duplicate series.
+	f("last", `bar{a=""} 1 1700000000000
+foo{a="b"} 5 1700000003000
+foo{a="c"} 10 1700000000000
+scrape_samples_scraped{a=""} 3 1700000000000`)
+
+	// The sample with the maximum value is left for duplicate series.
+	f("max", `bar{a=""} 1 1700000000000
+foo{a="b"} 7 1700000002000
+foo{a="c"} 10 1700000000000
+scrape_samples_scraped{a=""} 3 1700000000000`)
+
+	// Values for duplicate series are summed up at the timestamp of the last sample.
+	f("sum", `bar{a=""} 1 1700000000000
+foo{a="b"} 15 1700000003000
+foo{a="c"} 10 1700000000000
+scrape_samples_scraped{a=""} 3 1700000000000`)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
 )
 
 // SDConfig represents service discovery config for Consul.
@@ -17,6 +18,7 @@ type SDConfig struct {
 	Username     string              `yaml:"username"`
 	Password     string              `yaml:"password"`
 	TLSConfig    *promauth.TLSConfig `yaml:"tls_config,omitempty"`
+	ProxyURL     *proxy.URL          `yaml:"proxy_url,omitempty"`
 	Services     []string            `yaml:"services,omitempty"`
 	Tags         []string            `yaml:"tags,omitempty"`
 	NodeMeta     map[string]string   `yaml:"node_meta,omitempty"`
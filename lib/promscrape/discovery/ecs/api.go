@@ -0,0 +1,89 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/awsapi"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discoveryutils"
+)
+
+type apiConfig struct {
+	awsConfig *awsapi.Config
+	clusters  []string
+	port      int
+
+	ecsEndpoint string
+	ec2Endpoint string
+
+	// taskDefinitions caches task definitions by ARN, since they are immutable.
+	taskDefinitions     map[string]*TaskDefinition
+	taskDefinitionsLock sync.Mutex
+}
+
+var configMap = discoveryutils.NewConfigMap()
+
+func getAPIConfig(sdc *SDConfig) (*apiConfig, error) {
+	v, err := configMap.Get(sdc, func() (interface{}, error) { return newAPIConfig(sdc) })
+	if err != nil {
+		return nil, err
+	}
+	return v.(*apiConfig), nil
+}
+
+func newAPIConfig(sdc *SDConfig) (*apiConfig, error) {
+	awsCfg, err := awsapi.NewConfig(sdc.Endpoint, sdc.Region, sdc.RoleARN, sdc.AccessKey, sdc.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize AWS API config for `ecs_sd_configs`: %w", err)
+	}
+	port := 80
+	if sdc.Port != nil {
+		port = *sdc.Port
+	}
+	cfg := &apiConfig{
+		awsConfig:       awsCfg,
+		clusters:        sdc.Clusters,
+		port:            port,
+		ecsEndpoint:     awsCfg.GetAPIEndpoint("ecs"),
+		ec2Endpoint:     awsCfg.GetAPIEndpoint("ec2"),
+		taskDefinitions: make(map[string]*TaskDefinition),
+	}
+	return cfg, nil
+}
+
+// getECSAPIResponse performs ECS API request with the given action and JSON-encoded req and unmarshals the response into resp.
+//
+// See https://docs.aws.amazon.com/AmazonECS/latest/APIReference/Welcome.html
+func getECSAPIResponse(cfg *apiConfig, action string, req, resp interface{}) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %s request: %w", action, err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/x-amz-json-1.1",
+		"X-Amz-Target": "AmazonEC2ContainerServiceV20141113." + action,
+	}
+	data, err := cfg.awsConfig.GetAPIResponse("POST", cfg.ecsEndpoint, "ecs", headers, payload)
+	if err != nil {
+		return fmt.Errorf("cannot perform %s request: %w", action, err)
+	}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("cannot parse %s response from %q: %w", action, data, err)
+	}
+	return nil
+}
+
+// getEC2APIResponse performs EC2 DescribeInstances request for the given instanceIDs.
+//
+// See https://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeInstances.html
+func getEC2APIResponse(cfg *apiConfig, instanceIDs []string) ([]byte, error) {
+	args := make([]string, 0, len(instanceIDs))
+	for i, id := range instanceIDs {
+		args = append(args, fmt.Sprintf("InstanceId.%d=%s", i+1, url.QueryEscape(id)))
+	}
+	apiURL := fmt.Sprintf("%s?Action=DescribeInstances&%s&Version=2013-10-15", cfg.ec2Endpoint, strings.Join(args, "&"))
+	return cfg.awsConfig.GetAPIResponse("GET", apiURL, "ec2", nil, nil)
+}
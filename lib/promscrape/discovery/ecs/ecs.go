@@ -0,0 +1,33 @@
+package ecs
+
+import (
+	"fmt"
+)
+
+// SDConfig represents service discovery config for AWS ECS.
+//
+// Targets are generated for containers of running ECS tasks.
+type SDConfig struct {
+	Region    string `yaml:"region,omitempty"`
+	Endpoint  string `yaml:"endpoint,omitempty"`
+	AccessKey string `yaml:"access_key,omitempty"`
+	SecretKey string `yaml:"secret_key,omitempty"`
+	RoleARN   string `yaml:"role_arn,omitempty"`
+	// Clusters contains names or ARNs of ECS clusters to discover tasks in. All the clusters are used if it is empty.
+	Clusters []string `yaml:"clusters,omitempty"`
+	// refresh_interval is obtained from `-promscrape.ecsSDCheckInterval` command-line option.
+	Port *int `yaml:"port,omitempty"`
+}
+
+// GetLabels returns ecs labels according to sdc.
+func GetLabels(sdc *SDConfig) ([]map[string]string, error) {
+	cfg, err := getAPIConfig(sdc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get API config: %w", err)
+	}
+	ms, err := getTasksLabels(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error when fetching tasks data from ECS: %w", err)
+	}
+	return ms, nil
+}
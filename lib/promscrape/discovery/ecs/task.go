@@ -0,0 +1,442 @@
+package ecs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discovery/ec2"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discoveryutils"
+)
+
+// maxDescribeItems is the maximum number of items, which can be passed to a single Describe* call.
+//
+// See https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DescribeTasks.html
+const maxDescribeItems = 100
+
+// getTasksLabels returns labels for containers of ECS tasks obtained from the given cfg
+func getTasksLabels(cfg *apiConfig) ([]map[string]string, error) {
+	clusters := cfg.clusters
+	if len(clusters) == 0 {
+		cs, err := listClusters(cfg)
+		if err != nil {
+			return nil, err
+		}
+		clusters = cs
+	}
+	taskDefinitions := make(map[string]*TaskDefinition)
+	var ms []map[string]string
+	for _, cluster := range clusters {
+		tasks, err := getTasks(cfg, cluster)
+		if err != nil {
+			return nil, err
+		}
+		instanceIPs, err := getContainerInstanceIPs(cfg, cluster, tasks)
+		if err != nil {
+			return nil, err
+		}
+		for i := range tasks {
+			task := &tasks[i]
+			var td *TaskDefinition
+			if task.needsTaskDefinition() {
+				td, err = cfg.getTaskDefinition(task.TaskDefinitionArn)
+				if err != nil {
+					return nil, err
+				}
+				taskDefinitions[task.TaskDefinitionArn] = td
+			}
+			ms = task.appendTargetLabels(ms, td, instanceIPs[task.ContainerInstanceArn], cfg.port)
+		}
+	}
+	// Drop cached task definitions for tasks, which no longer exist.
+	cfg.taskDefinitionsLock.Lock()
+	cfg.taskDefinitions = taskDefinitions
+	cfg.taskDefinitionsLock.Unlock()
+	return ms, nil
+}
+
+func listClusters(cfg *apiConfig) ([]string, error) {
+	// See https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_ListClusters.html
+	var clusters []string
+	pageToken := ""
+	for {
+		req := map[string]interface{}{}
+		if len(pageToken) > 0 {
+			req["nextToken"] = pageToken
+		}
+		var resp ListClustersResponse
+		if err := getECSAPIResponse(cfg, "ListClusters", req, &resp); err != nil {
+			return nil, fmt.Errorf("cannot obtain clusters: %w", err)
+		}
+		clusters = append(clusters, resp.ClusterArns...)
+		if len(resp.NextToken) == 0 {
+			return clusters, nil
+		}
+		pageToken = resp.NextToken
+	}
+}
+
+func getTasks(cfg *apiConfig, cluster string) ([]Task, error) {
+	// See https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_ListTasks.html
+	var taskArns []string
+	pageToken := ""
+	for {
+		req := map[string]interface{}{
+			"cluster":       cluster,
+			"desiredStatus": "RUNNING",
+		}
+		if len(pageToken) > 0 {
+			req["nextToken"] = pageToken
+		}
+		var resp ListTasksResponse
+		if err := getECSAPIResponse(cfg, "ListTasks", req, &resp); err != nil {
+			return nil, fmt.Errorf("cannot obtain tasks for cluster %q: %w", cluster, err)
+		}
+		taskArns = append(taskArns, resp.TaskArns...)
+		if len(resp.NextToken) == 0 {
+			break
+		}
+		pageToken = resp.NextToken
+	}
+
+	// See https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DescribeTasks.html
+	var tasks []Task
+	for len(taskArns) > 0 {
+		n := len(taskArns)
+		if n > maxDescribeItems {
+			n = maxDescribeItems
+		}
+		req := map[string]interface{}{
+			"cluster": cluster,
+			"tasks":   taskArns[:n],
+			"include": []string{"TAGS"},
+		}
+		var resp DescribeTasksResponse
+		if err := getECSAPIResponse(cfg, "DescribeTasks", req, &resp); err != nil {
+			return nil, fmt.Errorf("cannot describe tasks for cluster %q: %w", cluster, err)
+		}
+		tasks = append(tasks, resp.Tasks...)
+		taskArns = taskArns[n:]
+	}
+	return tasks, nil
+}
+
+// getContainerInstanceIPs returns private IPs of EC2 instances for container instances the given tasks run on.
+//
+// The IPs are needed for tasks in `bridge` and `host` network modes, since these tasks have no own network interfaces.
+func getContainerInstanceIPs(cfg *apiConfig, cluster string, tasks []Task) (map[string]string, error) {
+	var containerInstanceArns []string
+	seen := make(map[string]bool)
+	for i := range tasks {
+		task := &tasks[i]
+		arn := task.ContainerInstanceArn
+		if len(arn) == 0 || seen[arn] || task.getENI() != nil {
+			continue
+		}
+		seen[arn] = true
+		containerInstanceArns = append(containerInstanceArns, arn)
+	}
+
+	// See https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DescribeContainerInstances.html
+	instanceIDs := make(map[string]string)
+	var ec2InstanceIDs []string
+	for len(containerInstanceArns) > 0 {
+		n := len(containerInstanceArns)
+		if n > maxDescribeItems {
+			n = maxDescribeItems
+		}
+		req := map[string]interface{}{
+			"cluster":            cluster,
+			"containerInstances": containerInstanceArns[:n],
+		}
+		var resp DescribeContainerInstancesResponse
+		if err := getECSAPIResponse(cfg, "DescribeContainerInstances", req, &resp); err != nil {
+			return nil, fmt.Errorf("cannot describe container instances for cluster %q: %w", cluster, err)
+		}
+		for _, ci := range resp.ContainerInstances {
+			instanceIDs[ci.ContainerInstanceArn] = ci.EC2InstanceID
+			ec2InstanceIDs = append(ec2InstanceIDs, ci.EC2InstanceID)
+		}
+		containerInstanceArns = containerInstanceArns[n:]
+	}
+
+	ipsByInstanceID := make(map[string]string)
+	for len(ec2InstanceIDs) > 0 {
+		n := len(ec2InstanceIDs)
+		if n > maxDescribeItems {
+			n = maxDescribeItems
+		}
+		data, err := getEC2APIResponse(cfg, ec2InstanceIDs[:n])
+		if err != nil {
+			return nil, fmt.Errorf("cannot obtain ec2 instances for container instances in cluster %q: %w", cluster, err)
+		}
+		var ir ec2.InstancesResponse
+		if err := xml.Unmarshal(data, &ir); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal InstancesResponse from %q: %w", data, err)
+		}
+		for _, r := range ir.ReservationSet.Items {
+			for _, inst := range r.InstanceSet.Items {
+				ipsByInstanceID[inst.ID] = inst.PrivateIPAddress
+			}
+		}
+		ec2InstanceIDs = ec2InstanceIDs[n:]
+	}
+
+	ips := make(map[string]string, len(instanceIDs))
+	for arn, id := range instanceIDs {
+		ips[arn] = ipsByInstanceID[id]
+	}
+	return ips, nil
+}
+
+// getTaskDefinition returns task definition for the given arn.
+func (cfg *apiConfig) getTaskDefinition(arn string) (*TaskDefinition, error) {
+	cfg.taskDefinitionsLock.Lock()
+	td := cfg.taskDefinitions[arn]
+	cfg.taskDefinitionsLock.Unlock()
+	if td != nil {
+		return td, nil
+	}
+
+	// See https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DescribeTaskDefinition.html
+	req := map[string]interface{}{
+		"taskDefinition": arn,
+	}
+	var resp DescribeTaskDefinitionResponse
+	if err := getECSAPIResponse(cfg, "DescribeTaskDefinition", req, &resp); err != nil {
+		return nil, fmt.Errorf("cannot describe task definition %q: %w", arn, err)
+	}
+	cfg.taskDefinitionsLock.Lock()
+	cfg.taskDefinitions[arn] = &resp.TaskDefinition
+	cfg.taskDefinitionsLock.Unlock()
+	return &resp.TaskDefinition, nil
+}
+
+// ListClustersResponse represents response to https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_ListClusters.html
+type ListClustersResponse struct {
+	ClusterArns []string `json:"clusterArns"`
+	NextToken   string   `json:"nextToken"`
+}
+
+// ListTasksResponse represents response to https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_ListTasks.html
+type ListTasksResponse struct {
+	TaskArns  []string `json:"taskArns"`
+	NextToken string   `json:"nextToken"`
+}
+
+// DescribeTasksResponse represents response to https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DescribeTasks.html
+type DescribeTasksResponse struct {
+	Tasks []Task `json:"tasks"`
+}
+
+// Task represents Task from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_Task.html
+type Task struct {
+	TaskArn              string       `json:"taskArn"`
+	ClusterArn           string       `json:"clusterArn"`
+	TaskDefinitionArn    string       `json:"taskDefinitionArn"`
+	ContainerInstanceArn string       `json:"containerInstanceArn"`
+	LaunchType           string       `json:"launchType"`
+	AvailabilityZone     string       `json:"availabilityZone"`
+	Group                string       `json:"group"`
+	LastStatus           string       `json:"lastStatus"`
+	Attachments          []Attachment `json:"attachments"`
+	Containers           []Container  `json:"containers"`
+	Tags                 []Tag        `json:"tags"`
+}
+
+// Attachment represents Attachment from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_Attachment.html
+type Attachment struct {
+	ID      string     `json:"id"`
+	Type    string     `json:"type"`
+	Status  string     `json:"status"`
+	Details []KeyValue `json:"details"`
+}
+
+// KeyValue represents KeyValuePair from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_KeyValuePair.html
+type KeyValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Container represents Container from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_Container.html
+type Container struct {
+	Name              string             `json:"name"`
+	Image             string             `json:"image"`
+	LastStatus        string             `json:"lastStatus"`
+	NetworkBindings   []NetworkBinding   `json:"networkBindings"`
+	NetworkInterfaces []NetworkInterface `json:"networkInterfaces"`
+}
+
+// NetworkBinding represents NetworkBinding from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_NetworkBinding.html
+type NetworkBinding struct {
+	ContainerPort int    `json:"containerPort"`
+	HostPort      int    `json:"hostPort"`
+	Protocol      string `json:"protocol"`
+}
+
+// NetworkInterface represents NetworkInterface from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_NetworkInterface.html
+type NetworkInterface struct {
+	PrivateIPv4Address string `json:"privateIpv4Address"`
+}
+
+// Tag represents Tag from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_Tag.html
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// DescribeContainerInstancesResponse represents response to https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DescribeContainerInstances.html
+type DescribeContainerInstancesResponse struct {
+	ContainerInstances []ContainerInstance `json:"containerInstances"`
+}
+
+// ContainerInstance represents ContainerInstance from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_ContainerInstance.html
+type ContainerInstance struct {
+	ContainerInstanceArn string `json:"containerInstanceArn"`
+	EC2InstanceID        string `json:"ec2InstanceId"`
+}
+
+// DescribeTaskDefinitionResponse represents response to https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_DescribeTaskDefinition.html
+type DescribeTaskDefinitionResponse struct {
+	TaskDefinition TaskDefinition `json:"taskDefinition"`
+}
+
+// TaskDefinition represents TaskDefinition from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_TaskDefinition.html
+type TaskDefinition struct {
+	NetworkMode          string                `json:"networkMode"`
+	ContainerDefinitions []ContainerDefinition `json:"containerDefinitions"`
+}
+
+// ContainerDefinition represents ContainerDefinition from https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_ContainerDefinition.html
+type ContainerDefinition struct {
+	Name         string           `json:"name"`
+	PortMappings []NetworkBinding `json:"portMappings"`
+}
+
+// getENI returns the elastic network interface attached to the task in `awsvpc` network mode.
+//
+// nil is returned if the task has no attached network interfaces.
+func (task *Task) getENI() *Attachment {
+	for i := range task.Attachments {
+		a := &task.Attachments[i]
+		if a.Type == "ElasticNetworkInterface" {
+			return a
+		}
+	}
+	return nil
+}
+
+// needsTaskDefinition returns true if port mappings for task containers must be obtained from the task definition.
+//
+// ECS doesn't return network bindings for containers in `awsvpc` network mode, so they are obtained from the task definition.
+func (task *Task) needsTaskDefinition() bool {
+	if task.getENI() == nil {
+		return false
+	}
+	for _, c := range task.Containers {
+		if len(c.NetworkBindings) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Attachment) getDetail(name string) string {
+	for _, kv := range a.Details {
+		if kv.Name == name {
+			return kv.Value
+		}
+	}
+	return ""
+}
+
+func (td *TaskDefinition) getPortMappings(containerName string) []NetworkBinding {
+	if td == nil {
+		return nil
+	}
+	for _, cd := range td.ContainerDefinitions {
+		if cd.Name == containerName {
+			return cd.PortMappings
+		}
+	}
+	return nil
+}
+
+func (task *Task) appendTargetLabels(ms []map[string]string, td *TaskDefinition, instanceIP string, port int) []map[string]string {
+	common := map[string]string{
+		"__meta_ecs_cluster":           getNameFromARN(task.ClusterArn),
+		"__meta_ecs_cluster_arn":       task.ClusterArn,
+		"__meta_ecs_task_arn":          task.TaskArn,
+		"__meta_ecs_task_definition":   task.TaskDefinitionArn,
+		"__meta_ecs_task_group":        task.Group,
+		"__meta_ecs_launch_type":       task.LaunchType,
+		"__meta_ecs_availability_zone": task.AvailabilityZone,
+	}
+	ip := instanceIP
+	if eni := task.getENI(); eni != nil {
+		ip = eni.getDetail("privateIPv4Address")
+		common["__meta_ecs_eni_id"] = eni.getDetail("networkInterfaceId")
+		common["__meta_ecs_subnet_id"] = eni.getDetail("subnetId")
+	} else if len(task.ContainerInstanceArn) > 0 {
+		common["__meta_ecs_container_instance_arn"] = task.ContainerInstanceArn
+	}
+	for _, t := range task.Tags {
+		if len(t.Key) == 0 || len(t.Value) == 0 {
+			continue
+		}
+		name := discoveryutils.SanitizeLabelName(t.Key)
+		common["__meta_ecs_tag_"+name] = t.Value
+	}
+	for _, c := range task.Containers {
+		containerIP := ip
+		if len(c.NetworkInterfaces) > 0 && len(c.NetworkInterfaces[0].PrivateIPv4Address) > 0 {
+			containerIP = c.NetworkInterfaces[0].PrivateIPv4Address
+		}
+		if len(containerIP) == 0 {
+			// Cannot scrape container without IP address
+			continue
+		}
+		bindings := c.NetworkBindings
+		if len(bindings) == 0 {
+			bindings = td.getPortMappings(c.Name)
+		}
+		if len(bindings) == 0 {
+			m := c.newLabels(common, containerIP)
+			m["__address__"] = discoveryutils.JoinHostPort(containerIP, port)
+			ms = append(ms, m)
+			continue
+		}
+		for _, nb := range bindings {
+			hostPort := nb.HostPort
+			if hostPort == 0 {
+				hostPort = nb.ContainerPort
+			}
+			m := c.newLabels(common, containerIP)
+			m["__address__"] = discoveryutils.JoinHostPort(containerIP, hostPort)
+			m["__meta_ecs_container_port"] = strconv.Itoa(nb.ContainerPort)
+			m["__meta_ecs_host_port"] = strconv.Itoa(hostPort)
+			m["__meta_ecs_port_protocol"] = nb.Protocol
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+func (c *Container) newLabels(common map[string]string, ip string) map[string]string {
+	m := make(map[string]string, len(common)+8)
+	for k, v := range common {
+		m[k] = v
+	}
+	m["__meta_ecs_container_name"] = c.Name
+	m["__meta_ecs_container_image"] = c.Image
+	m["__meta_ecs_container_status"] = c.LastStatus
+	m["__meta_ecs_ip_address"] = ip
+	return m
+}
+
+// getNameFromARN returns resource name from arn in the form `arn:aws:ecs:region:account:cluster/name`.
+func getNameFromARN(arn string) string {
+	n := strings.LastIndexByte(arn, '/')
+	return arn[n+1:]
+}
@@ -0,0 +1,209 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discoveryutils"
+)
+
+const testClusterArn = "arn:aws:ecs:us-east-1:123456789012:cluster/prod"
+
+var testECSResponses = map[string]string{
+	"ListClusters": `{"clusterArns":["` + testClusterArn + `"]}`,
+	"ListTasks":    `{"taskArns":["arn:aws:ecs:us-east-1:123456789012:task/prod/fargate","arn:aws:ecs:us-east-1:123456789012:task/prod/bridge"]}`,
+	"DescribeTasks": `{"tasks":[
+  {
+    "taskArn": "arn:aws:ecs:us-east-1:123456789012:task/prod/fargate",
+    "clusterArn": "` + testClusterArn + `",
+    "taskDefinitionArn": "arn:aws:ecs:us-east-1:123456789012:task-definition/api:3",
+    "launchType": "FARGATE",
+    "availabilityZone": "us-east-1a",
+    "group": "service:api",
+    "lastStatus": "RUNNING",
+    "attachments": [{
+      "id": "a1",
+      "type": "ElasticNetworkInterface",
+      "status": "ATTACHED",
+      "details": [
+        {"name": "subnetId", "value": "subnet-1"},
+        {"name": "networkInterfaceId", "value": "eni-1"},
+        {"name": "privateIPv4Address", "value": "10.0.0.5"}
+      ]
+    }],
+    "containers": [
+      {"name": "api", "image": "api:1.0", "lastStatus": "RUNNING", "networkInterfaces": [{"privateIpv4Address": "10.0.0.5"}]},
+      {"name": "sidecar", "image": "envoy:1.16", "lastStatus": "RUNNING"}
+    ],
+    "tags": [{"key": "team", "value": "core"}]
+  },
+  {
+    "taskArn": "arn:aws:ecs:us-east-1:123456789012:task/prod/bridge",
+    "clusterArn": "` + testClusterArn + `",
+    "taskDefinitionArn": "arn:aws:ecs:us-east-1:123456789012:task-definition/web:7",
+    "containerInstanceArn": "arn:aws:ecs:us-east-1:123456789012:container-instance/prod/ci1",
+    "launchType": "EC2",
+    "availabilityZone": "us-east-1b",
+    "group": "service:web",
+    "lastStatus": "RUNNING",
+    "containers": [
+      {"name": "web", "image": "nginx", "lastStatus": "RUNNING", "networkBindings": [{"bindIP": "0.0.0.0", "containerPort": 80, "hostPort": 32768, "protocol": "tcp"}]}
+    ]
+  }
+]}`,
+	"DescribeTaskDefinition": `{"taskDefinition":{"networkMode":"awsvpc","containerDefinitions":[
+  {"name": "api", "portMappings": [{"containerPort": 8080, "hostPort": 8080, "protocol": "tcp"}]},
+  {"name": "sidecar"}
+]}}`,
+	"DescribeContainerInstances": `{"containerInstances":[{"containerInstanceArn":"arn:aws:ecs:us-east-1:123456789012:container-instance/prod/ci1","ec2InstanceId":"i-123"}]}`,
+}
+
+const testEC2Response = `<?xml version="1.0" encoding="UTF-8"?>
+<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2013-10-15/">
+    <reservationSet>
+        <item>
+            <instancesSet>
+                <item>
+                    <instanceId>i-123</instanceId>
+                    <privateIpAddress>172.31.1.2</privateIpAddress>
+                </item>
+            </instancesSet>
+        </item>
+    </reservationSet>
+</DescribeInstancesResponse>`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+			http.Error(w, fmt.Sprintf("missing signature; headers: %v", r.Header), http.StatusForbidden)
+			return
+		}
+		if r.Method == "GET" {
+			if r.URL.Query().Get("Action") != "DescribeInstances" || r.URL.Query().Get("InstanceId.1") != "i-123" {
+				http.Error(w, fmt.Sprintf("unexpected request %q", r.URL), http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(testEC2Response))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var req map[string]interface{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonEC2ContainerServiceV20141113.")
+		resp, ok := testECSResponses[action]
+		if !ok || r.Header.Get("Content-Type") != "application/x-amz-json-1.1" {
+			http.Error(w, fmt.Sprintf("unexpected action %q", action), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(resp))
+	}))
+}
+
+func TestGetLabels(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	port := 9100
+	sdc := &SDConfig{
+		Region:    "us-east-1",
+		Endpoint:  s.URL,
+		AccessKey: "test-key",
+		SecretKey: "test-secret",
+		Port:      &port,
+	}
+	ms, err := GetLabels(sdc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var sortedLabelss [][]prompbmarshal.Label
+	for _, m := range ms {
+		sortedLabelss = append(sortedLabelss, discoveryutils.GetSortedLabels(m))
+	}
+	fargateLabels := map[string]string{
+		"__meta_ecs_cluster":           "prod",
+		"__meta_ecs_cluster_arn":       testClusterArn,
+		"__meta_ecs_task_arn":          "arn:aws:ecs:us-east-1:123456789012:task/prod/fargate",
+		"__meta_ecs_task_definition":   "arn:aws:ecs:us-east-1:123456789012:task-definition/api:3",
+		"__meta_ecs_task_group":        "service:api",
+		"__meta_ecs_launch_type":       "FARGATE",
+		"__meta_ecs_availability_zone": "us-east-1a",
+		"__meta_ecs_eni_id":            "eni-1",
+		"__meta_ecs_subnet_id":         "subnet-1",
+		"__meta_ecs_ip_address":        "10.0.0.5",
+		"__meta_ecs_container_status":  "RUNNING",
+		"__meta_ecs_tag_team":          "core",
+	}
+	expectedLabels := [][]prompbmarshal.Label{
+		discoveryutils.GetSortedLabels(mergeLabels(fargateLabels, map[string]string{
+			"__address__":                "10.0.0.5:8080",
+			"__meta_ecs_container_name":  "api",
+			"__meta_ecs_container_image": "api:1.0",
+			"__meta_ecs_container_port":  "8080",
+			"__meta_ecs_host_port":       "8080",
+			"__meta_ecs_port_protocol":   "tcp",
+		})),
+		discoveryutils.GetSortedLabels(mergeLabels(fargateLabels, map[string]string{
+			"__address__":                "10.0.0.5:9100",
+			"__meta_ecs_container_name":  "sidecar",
+			"__meta_ecs_container_image": "envoy:1.16",
+		})),
+		discoveryutils.GetSortedLabels(map[string]string{
+			"__address__":                       "172.31.1.2:32768",
+			"__meta_ecs_cluster":                "prod",
+			"__meta_ecs_cluster_arn":            testClusterArn,
+			"__meta_ecs_task_arn":               "arn:aws:ecs:us-east-1:123456789012:task/prod/bridge",
+			"__meta_ecs_task_definition":        "arn:aws:ecs:us-east-1:123456789012:task-definition/web:7",
+			"__meta_ecs_task_group":             "service:web",
+			"__meta_ecs_launch_type":            "EC2",
+			"__meta_ecs_availability_zone":      "us-east-1b",
+			"__meta_ecs_container_instance_arn": "arn:aws:ecs:us-east-1:123456789012:container-instance/prod/ci1",
+			"__meta_ecs_container_name":         "web",
+			"__meta_ecs_container_image":        "nginx",
+			"__meta_ecs_container_status":       "RUNNING",
+			"__meta_ecs_container_port":         "80",
+			"__meta_ecs_host_port":              "32768",
+			"__meta_ecs_port_protocol":          "tcp",
+			"__meta_ecs_ip_address":             "172.31.1.2",
+		}),
+	}
+	if !reflect.DeepEqual(sortedLabelss, expectedLabels) {
+		t.Fatalf("unexpected labels:\ngot\n%v\nwant\n%v", sortedLabelss, expectedLabels)
+	}
+}
+
+func TestGetLabelsFailure(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	sdc := &SDConfig{
+		Region:    "us-east-1",
+		Endpoint:  s.URL,
+		AccessKey: "invalid-key",
+		SecretKey: "test-secret",
+	}
+	if _, err := GetLabels(sdc); err == nil {
+		t.Fatalf("expecting non-nil error for rejected credentials")
+	}
+}
+
+func mergeLabels(a, b map[string]string) map[string]string {
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discoveryutils"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
 )
 
 const appsAPIPath = "/apps"
@@ -23,6 +24,7 @@ type SDConfig struct {
 	Username   string              `yaml:"username"`
 	Password   string              `yaml:"password"`
 	TLSConfig  *promauth.TLSConfig `yaml:"tls_config,omitempty"`
+	ProxyURL   *proxy.URL          `yaml:"proxy_url,omitempty"`
 	// RefreshInterval time.Duration `yaml:"refresh_interval"`
 	// refresh_interval is obtained from `-promscrape.ec2SDCheckInterval` command-line option.
 	Port *int `yaml:"port,omitempty"`
@@ -26,13 +26,33 @@ func getAPIConfig(sdc *SDConfig, baseDir string) (*apiConfig, error) {
 	return v.(*apiConfig), nil
 }
 
+// In-cluster service account paths.
+//
+// They may be overridden in tests.
+var (
+	inClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
 func newAPIConfig(sdc *SDConfig, baseDir string) (*apiConfig, error) {
-	ac, err := promauth.NewConfig(baseDir, sdc.BasicAuth, sdc.BearerToken, sdc.BearerTokenFile, sdc.TLSConfig)
-	if err != nil {
-		return nil, fmt.Errorf("cannot parse auth config: %w", err)
-	}
 	apiServer := sdc.APIServer
-	if len(apiServer) == 0 {
+	var ac *promauth.Config
+	tokenFile := ""
+	switch {
+	case sdc.KubeConfigFile != "":
+		if sdc.BasicAuth != nil || sdc.BearerToken != "" || sdc.BearerTokenFile != "" || sdc.TLSConfig != nil {
+			return nil, fmt.Errorf("`kubeconfig_file` cannot be set together with `basic_auth`, `bearer_token`, `bearer_token_file` or `tls_config`")
+		}
+		kca, err := readKubeConfig(getFilepath(baseDir, sdc.KubeConfigFile))
+		if err != nil {
+			return nil, err
+		}
+		if len(apiServer) == 0 {
+			apiServer = kca.server
+		}
+		ac = kca.ac
+		tokenFile = kca.tokenFile
+	case len(apiServer) == 0:
 		// Assume we run at k8s pod.
 		// Discover apiServer and auth config according to k8s docs.
 		// See https://kubernetes.io/docs/reference/access-authn-authz/service-accounts-admin/#service-account-admission-controller
@@ -40,7 +60,7 @@ func newAPIConfig(sdc *SDConfig, baseDir string) (*apiConfig, error) {
 		port := os.Getenv("KUBERNETES_SERVICE_PORT")
 		if len(host) == 0 {
 			return nil, fmt.Errorf("cannot find KUBERNETES_SERVICE_HOST env var; it must be defined when running in k8s; " +
-				"probably, `kubernetes_sd_config->api_server` is missing in Prometheus configs?")
+				"probably, `kubernetes_sd_config->api_server` or `kubernetes_sd_config->kubeconfig_file` is missing in Prometheus configs?")
 		}
 		if len(port) == 0 {
 			return nil, fmt.Errorf("cannot find KUBERNETES_SERVICE_PORT env var; it must be defined when running in k8s; "+
@@ -48,18 +68,33 @@ func newAPIConfig(sdc *SDConfig, baseDir string) (*apiConfig, error) {
 		}
 		apiServer = "https://" + net.JoinHostPort(host, port)
 		tlsConfig := promauth.TLSConfig{
-			CAFile: "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+			CAFile: inClusterCAFile,
 		}
-		acNew, err := promauth.NewConfig(".", nil, "", "/var/run/secrets/kubernetes.io/serviceaccount/token", &tlsConfig)
+		acNew, err := promauth.NewConfig(".", nil, "", "", &tlsConfig)
 		if err != nil {
 			return nil, fmt.Errorf("cannot initialize service account auth: %w; probably, `kubernetes_sd_config->api_server` is missing in Prometheus configs?", err)
 		}
 		ac = acNew
+		// The service account token is re-read periodically, since it may be rotated by kubelet.
+		tokenFile = inClusterTokenFile
+	default:
+		acNew, err := promauth.NewConfig(baseDir, sdc.BasicAuth, sdc.BearerToken, sdc.BearerTokenFile, sdc.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse auth config: %w", err)
+		}
+		ac = acNew
 	}
-	client, err := discoveryutils.NewClient(apiServer, ac)
+	client, err := discoveryutils.NewClient(apiServer, ac, sdc.ProxyURL)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create HTTP client for %q: %w", apiServer, err)
 	}
+	if tokenFile != "" {
+		tr, err := newTokenReader(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot initialize bearer token auth for %q: %w", apiServer, err)
+		}
+		client.SetAuthorizationFunc(tr.getAuthorization)
+	}
 	cfg := &apiConfig{
 		client:     client,
 		namespaces: sdc.Namespaces.Names,
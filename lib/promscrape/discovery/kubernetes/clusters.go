@@ -0,0 +1,121 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
+)
+
+// APIServerConfig represents a single k8s API server in `api_servers` list of kubernetes_sd_config.
+type APIServerConfig struct {
+	// Cluster is the `__meta_kubernetes_cluster` label value for targets discovered via the API server.
+	//
+	// APIServer or KubeConfigFile value is used if Cluster is empty.
+	Cluster string `yaml:"cluster,omitempty"`
+
+	APIServer       string                    `yaml:"api_server,omitempty"`
+	KubeConfigFile  string                    `yaml:"kubeconfig_file,omitempty"`
+	BasicAuth       *promauth.BasicAuthConfig `yaml:"basic_auth,omitempty"`
+	BearerToken     string                    `yaml:"bearer_token,omitempty"`
+	BearerTokenFile string                    `yaml:"bearer_token_file,omitempty"`
+	TLSConfig       *promauth.TLSConfig       `yaml:"tls_config,omitempty"`
+	ProxyURL        *proxy.URL                `yaml:"proxy_url,omitempty"`
+}
+
+func (asc *APIServerConfig) clusterName() string {
+	if asc.Cluster != "" {
+		return asc.Cluster
+	}
+	if asc.APIServer != "" {
+		return asc.APIServer
+	}
+	return asc.KubeConfigFile
+}
+
+// getSDConfig returns SDConfig for asc with the remaining options from sdc.
+func (asc *APIServerConfig) getSDConfig(sdc *SDConfig) *SDConfig {
+	sdcCopy := *sdc
+	sdcCopy.APIServers = nil
+	sdcCopy.APIServer = asc.APIServer
+	sdcCopy.KubeConfigFile = asc.KubeConfigFile
+	sdcCopy.BasicAuth = asc.BasicAuth
+	sdcCopy.BearerToken = asc.BearerToken
+	sdcCopy.BearerTokenFile = asc.BearerTokenFile
+	sdcCopy.TLSConfig = asc.TLSConfig
+	sdcCopy.ProxyURL = asc.ProxyURL
+	return &sdcCopy
+}
+
+func validateAPIServers(sdc *SDConfig) error {
+	if sdc.APIServer != "" || sdc.KubeConfigFile != "" || sdc.BasicAuth != nil || sdc.BearerToken != "" || sdc.BearerTokenFile != "" ||
+		sdc.TLSConfig != nil || sdc.ProxyURL != nil {
+		return fmt.Errorf("`api_servers` cannot be set together with `api_server`, `kubeconfig_file`, `basic_auth`, `bearer_token`, " +
+			"`bearer_token_file`, `tls_config` or `proxy_url`; put these options into `api_servers` entries instead")
+	}
+	clusters := make(map[string]bool, len(sdc.APIServers))
+	for i := range sdc.APIServers {
+		asc := &sdc.APIServers[i]
+		if asc.APIServer == "" && asc.KubeConfigFile == "" {
+			return fmt.Errorf("missing `api_server` and `kubeconfig_file` in `api_servers` entry #%d", i+1)
+		}
+		cluster := asc.clusterName()
+		if clusters[cluster] {
+			return fmt.Errorf("duplicate `cluster` %q in `api_servers`", cluster)
+		}
+		clusters[cluster] = true
+	}
+	return nil
+}
+
+// getClustersLabels returns labels for targets discovered via all the `api_servers` from sdc.
+//
+// API servers are queried concurrently. Every target gets `__meta_kubernetes_cluster` label.
+// An error is returned if targets cannot be obtained from at least a single API server,
+// so the caller could preserve the previously discovered targets.
+func getClustersLabels(sdc *SDConfig, baseDir string) ([]map[string]string, error) {
+	if err := validateAPIServers(sdc); err != nil {
+		return nil, err
+	}
+	type result struct {
+		ms  []map[string]string
+		err error
+	}
+	results := make([]result, len(sdc.APIServers))
+	var wg sync.WaitGroup
+	for i := range sdc.APIServers {
+		wg.Add(1)
+		go func(asc *APIServerConfig, r *result) {
+			defer wg.Done()
+			r.ms, r.err = getClusterLabels(sdc, asc, baseDir)
+		}(&sdc.APIServers[i], &results[i])
+	}
+	wg.Wait()
+
+	var ms []map[string]string
+	for i, r := range results {
+		if r.err != nil {
+			return nil, fmt.Errorf("cannot discover targets in cluster %q: %w", sdc.APIServers[i].clusterName(), r.err)
+		}
+		ms = append(ms, r.ms...)
+	}
+	return ms, nil
+}
+
+func getClusterLabels(sdc *SDConfig, asc *APIServerConfig, baseDir string) ([]map[string]string, error) {
+	// Use asc as a key for configMap, since it remains the same until the config is reloaded.
+	v, err := configMap.Get(asc, func() (interface{}, error) { return newAPIConfig(asc.getSDConfig(sdc), baseDir) })
+	if err != nil {
+		return nil, fmt.Errorf("cannot create API config: %w", err)
+	}
+	ms, err := getRoleLabels(v.(*apiConfig), sdc)
+	if err != nil {
+		return nil, err
+	}
+	cluster := asc.clusterName()
+	for _, m := range ms {
+		m["__meta_kubernetes_cluster"] = cluster
+	}
+	return ms, nil
+}
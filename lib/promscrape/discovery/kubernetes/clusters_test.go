@@ -0,0 +1,129 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestValidateAPIServers(t *testing.T) {
+	f := func(sdc *SDConfig, resultExpected bool) {
+		t.Helper()
+		err := validateAPIServers(sdc)
+		if result := err == nil; result != resultExpected {
+			t.Fatalf("unexpected validation result; got %v; want %v; err: %v", result, resultExpected, err)
+		}
+	}
+	f(&SDConfig{
+		APIServers: []APIServerConfig{{APIServer: "http://foo"}, {APIServer: "http://bar"}},
+	}, true)
+	f(&SDConfig{
+		APIServers: []APIServerConfig{{Cluster: "foo", APIServer: "http://foo"}, {Cluster: "foo-kubeconfig", KubeConfigFile: "foo"}},
+	}, true)
+
+	// Missing api_server and kubeconfig_file
+	f(&SDConfig{
+		APIServers: []APIServerConfig{{Cluster: "foo"}},
+	}, false)
+
+	// Duplicate cluster
+	f(&SDConfig{
+		APIServers: []APIServerConfig{{Cluster: "foo", APIServer: "http://foo"}, {Cluster: "foo", APIServer: "http://bar"}},
+	}, false)
+	f(&SDConfig{
+		APIServers: []APIServerConfig{{APIServer: "http://foo"}, {APIServer: "http://foo"}},
+	}, false)
+
+	// api_server together with api_servers
+	f(&SDConfig{
+		APIServer:  "http://foo",
+		APIServers: []APIServerConfig{{APIServer: "http://bar"}},
+	}, false)
+	f(&SDConfig{
+		BearerToken: "foo",
+		APIServers:  []APIServerConfig{{APIServer: "http://bar"}},
+	}, false)
+}
+
+func TestGetLabelsMultipleAPIServers(t *testing.T) {
+	newAPIServer := func(podIP, token string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/pods" {
+				http.Error(w, "unexpected path", http.StatusNotFound)
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer "+token {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `{"items":[{"metadata":{"name":"app","namespace":"default"},"spec":{"containers":[{"name":"app","ports":[{"containerPort":8080}]}]},"status":{"podIP":%q}}]}`, podIP)
+		}))
+	}
+	s1 := newAPIServer("10.0.0.1", "token-1")
+	defer s1.Close()
+	s2 := newAPIServer("10.1.0.1", "token-2")
+	defer s2.Close()
+
+	f := func(sdc *SDConfig, targetsExpected []string) {
+		t.Helper()
+		ms, err := GetLabels(sdc, ".")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var targets []string
+		for _, m := range ms {
+			targets = append(targets, m["__meta_kubernetes_cluster"]+"/"+m["__address__"])
+		}
+		sort.Strings(targets)
+		if !reflect.DeepEqual(targets, targetsExpected) {
+			t.Fatalf("unexpected targets; got %q; want %q", targets, targetsExpected)
+		}
+	}
+	f(&SDConfig{
+		Role: "pod",
+		APIServers: []APIServerConfig{
+			{
+				Cluster:     "eu",
+				APIServer:   s1.URL,
+				BearerToken: "token-1",
+			},
+			{
+				APIServer:   s2.URL,
+				BearerToken: "token-2",
+			},
+		},
+	}, []string{
+		"eu/10.0.0.1:8080",
+		s2.URL + "/10.1.0.1:8080",
+	})
+
+	// Single api_server mustn't set __meta_kubernetes_cluster label.
+	f(&SDConfig{
+		Role:        "pod",
+		APIServer:   s1.URL,
+		BearerToken: "token-1",
+	}, []string{
+		"/10.0.0.1:8080",
+	})
+
+	// Discovery error in a single cluster must result in error, so the previously discovered targets are preserved.
+	sdc := &SDConfig{
+		Role: "pod",
+		APIServers: []APIServerConfig{
+			{
+				APIServer:   s1.URL,
+				BearerToken: "token-1",
+			},
+			{
+				APIServer:   s2.URL,
+				BearerToken: "invalid-token",
+			},
+		},
+	}
+	if _, err := GetLabels(sdc, "."); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
@@ -0,0 +1,262 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discoveryutils"
+)
+
+// CustomResourceConfig represents config for `role: custom` in kubernetes_sd_config.
+//
+// It allows discovering targets from arbitrary k8s resources such as custom resources defined via CRD.
+type CustomResourceConfig struct {
+	// Group, Version and Resource identify the resource to discover. Group must be empty for core resources.
+	Group    string `yaml:"group,omitempty"`
+	Version  string `yaml:"version"`
+	Resource string `yaml:"resource"`
+
+	// AddressPath is the path to the object field containing `__address__` label value such as `spec.endpoint`.
+	AddressPath string `yaml:"address_path"`
+
+	// Labels maps label names to object field paths.
+	// Every label is exported as `__meta_kubernetes_custom_field_<name>`.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+func (crc *CustomResourceConfig) validate() error {
+	if crc == nil {
+		return fmt.Errorf("missing `custom` section for `role: custom`")
+	}
+	if crc.Version == "" {
+		return fmt.Errorf("missing `version` in `custom` section")
+	}
+	if crc.Resource == "" {
+		return fmt.Errorf("missing `resource` in `custom` section")
+	}
+	if crc.AddressPath == "" {
+		return fmt.Errorf("missing `address_path` in `custom` section")
+	}
+	if _, err := parseFieldPath(crc.AddressPath); err != nil {
+		return fmt.Errorf("cannot parse `address_path`: %w", err)
+	}
+	for name, path := range crc.Labels {
+		if _, err := parseFieldPath(path); err != nil {
+			return fmt.Errorf("cannot parse path for label %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// apiPrefix returns API path prefix for crc.
+//
+// See https://kubernetes.io/docs/reference/using-api/api-concepts/#resource-uris
+func (crc *CustomResourceConfig) apiPrefix() string {
+	if crc.Group == "" {
+		return "/api/" + crc.Version
+	}
+	return "/apis/" + crc.Group + "/" + crc.Version
+}
+
+// getCustomResourcesLabels returns labels for k8s objects described by crc obtained from the given cfg.
+func getCustomResourcesLabels(cfg *apiConfig, crc *CustomResourceConfig) ([]map[string]string, error) {
+	if err := crc.validate(); err != nil {
+		return nil, err
+	}
+	crs, err := getCustomResources(cfg, crc)
+	if err != nil {
+		return nil, err
+	}
+	var ms []map[string]string
+	for _, cr := range crs {
+		ms = cr.appendTargetLabels(ms, crc)
+	}
+	return ms, nil
+}
+
+func getCustomResources(cfg *apiConfig, crc *CustomResourceConfig) ([]CustomResource, error) {
+	prefix := crc.apiPrefix()
+	if len(cfg.namespaces) == 0 {
+		return getCustomResourcesByPath(cfg, prefix+"/"+crc.Resource)
+	}
+	// Query /namespaces/* for each namespace.
+	// This fixes authorization issue at https://github.com/VictoriaMetrics/VictoriaMetrics/issues/432
+	cfgCopy := *cfg
+	namespaces := cfgCopy.namespaces
+	cfgCopy.namespaces = nil
+	cfg = &cfgCopy
+	var result []CustomResource
+	for _, ns := range namespaces {
+		path := fmt.Sprintf("%s/namespaces/%s/%s", prefix, ns, crc.Resource)
+		crs, err := getCustomResourcesByPath(cfg, path)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, crs...)
+	}
+	return result, nil
+}
+
+func getCustomResourcesByPath(cfg *apiConfig, path string) ([]CustomResource, error) {
+	data, err := getAPIResponse(cfg, "custom", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain custom resources data from API server: %w", err)
+	}
+	crl, err := parseCustomResourceList(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse custom resources response from API server: %w", err)
+	}
+	return crl.Items, nil
+}
+
+// CustomResourceList represents a list of arbitrary k8s objects.
+type CustomResourceList struct {
+	Items []CustomResource
+}
+
+// CustomResource represents an arbitrary k8s object.
+type CustomResource struct {
+	Metadata ObjectMeta
+
+	// Object contains all the object fields.
+	Object map[string]interface{}
+}
+
+// UnmarshalJSON unmarshals cr from data.
+func (cr *CustomResource) UnmarshalJSON(data []byte) error {
+	var meta struct {
+		Metadata ObjectMeta
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	cr.Metadata = meta.Metadata
+	cr.Object = obj
+	return nil
+}
+
+// parseCustomResourceList parses CustomResourceList from data.
+func parseCustomResourceList(data []byte) (*CustomResourceList, error) {
+	var crl CustomResourceList
+	if err := json.Unmarshal(data, &crl); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal CustomResourceList from %q: %w", data, err)
+	}
+	return &crl, nil
+}
+
+// appendTargetLabels appends labels for CustomResource cr to ms and returns the result.
+//
+// The object is skipped if it has no value at crc.AddressPath.
+func (cr *CustomResource) appendTargetLabels(ms []map[string]string, crc *CustomResourceConfig) []map[string]string {
+	addr, ok := getFieldValue(cr.Object, crc.AddressPath)
+	if !ok || addr == "" {
+		return ms
+	}
+	m := map[string]string{
+		"__address__":                   addr,
+		"__meta_kubernetes_namespace":   cr.Metadata.Namespace,
+		"__meta_kubernetes_custom_name": cr.Metadata.Name,
+	}
+	cr.Metadata.registerLabelsAndAnnotations("__meta_kubernetes_custom", m)
+	names := make([]string, 0, len(crc.Labels))
+	for name := range crc.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v, ok := getFieldValue(cr.Object, crc.Labels[name]); ok {
+			m["__meta_kubernetes_custom_field_"+discoveryutils.SanitizeLabelName(name)] = v
+		}
+	}
+	ms = append(ms, m)
+	return ms
+}
+
+// parseFieldPath parses path to object field into path segments.
+//
+// The path may be written either in dotted form such as `spec.endpoints[0].host`
+// or in kubectl JSONPath form such as `{.spec.endpoints[0].host}`.
+func parseFieldPath(path string) ([]string, error) {
+	s := strings.TrimSpace(path)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	var segments []string
+	for _, part := range strings.Split(s, ".") {
+		n := strings.IndexByte(part, '[')
+		if n < 0 {
+			n = len(part)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("missing field name in %q", path)
+		}
+		segments = append(segments, part[:n])
+		tail := part[n:]
+		for tail != "" {
+			m := strings.IndexByte(tail, ']')
+			if tail[0] != '[' || m < 0 {
+				return nil, fmt.Errorf("unexpected index %q in %q", tail, path)
+			}
+			idx := tail[1:m]
+			if _, err := strconv.ParseUint(idx, 10, 64); err != nil {
+				return nil, fmt.Errorf("unsupported index %q in %q; only non-negative integer indexes are supported", idx, path)
+			}
+			segments = append(segments, idx)
+			tail = tail[m+1:]
+		}
+	}
+	return segments, nil
+}
+
+// getFieldValue returns the value at the given path for obj.
+//
+// Strings are returned as is, while other values are returned in JSON representation.
+// false is returned if the path is missing in obj.
+func getFieldValue(obj map[string]interface{}, path string) (string, bool) {
+	segments, err := parseFieldPath(path)
+	if err != nil {
+		return "", false
+	}
+	var v interface{} = obj
+	for _, segment := range segments {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			x, ok := t[segment]
+			if !ok {
+				return "", false
+			}
+			v = x
+		case []interface{}:
+			n, err := strconv.Atoi(segment)
+			if err != nil || n >= len(t) {
+				return "", false
+			}
+			v = t[n]
+		default:
+			return "", false
+		}
+	}
+	switch t := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return t, true
+	default:
+		data, err := json.Marshal(t)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+}
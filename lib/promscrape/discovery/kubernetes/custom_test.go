@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discoveryutils"
+)
+
+func TestParseCustomResourceListFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		crl, err := parseCustomResourceList([]byte(s))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if crl != nil {
+			t.Fatalf("unexpected non-nil CustomResourceList: %v", crl)
+		}
+	}
+	f(``)
+	f(`[1,23]`)
+	f(`{"items":[1]}`)
+	f(`{"items":[{"metadata":1}]}`)
+	f(`{"items":[{"metadata":{"labels":[1]}}]}`)
+}
+
+func TestParseFieldPathFailure(t *testing.T) {
+	f := func(path string) {
+		t.Helper()
+		if _, err := parseFieldPath(path); err == nil {
+			t.Fatalf("expecting non-nil error for path %q", path)
+		}
+	}
+	f(``)
+	f(`{}`)
+	f(`.`)
+	f(`foo..bar`)
+	f(`[0]`)
+	f(`foo[bar]`)
+	f(`foo[-1]`)
+	f(`foo[0`)
+	f(`foo[0]x`)
+}
+
+func TestCustomResourceConfigValidate(t *testing.T) {
+	f := func(crc *CustomResourceConfig, resultExpected bool) {
+		t.Helper()
+		err := crc.validate()
+		if result := err == nil; result != resultExpected {
+			t.Fatalf("unexpected validation result; got %v; want %v; err: %v", result, resultExpected, err)
+		}
+	}
+	f(nil, false)
+	f(&CustomResourceConfig{}, false)
+	f(&CustomResourceConfig{Version: "v1", Resource: "foos"}, false)
+	f(&CustomResourceConfig{Version: "v1", Resource: "foos", AddressPath: "foo[x]"}, false)
+	f(&CustomResourceConfig{Version: "v1", Resource: "foos", AddressPath: "spec.host", Labels: map[string]string{"a": ""}}, false)
+	f(&CustomResourceConfig{Version: "v1", Resource: "foos", AddressPath: "spec.host"}, true)
+	f(&CustomResourceConfig{Group: "example.com", Version: "v1", Resource: "foos", AddressPath: "{.spec.host}", Labels: map[string]string{"a": "spec.x[1]"}}, true)
+}
+
+func TestCustomResourceConfigAPIPrefix(t *testing.T) {
+	f := func(crc *CustomResourceConfig, prefixExpected string) {
+		t.Helper()
+		if prefix := crc.apiPrefix(); prefix != prefixExpected {
+			t.Fatalf("unexpected api prefix; got %q; want %q", prefix, prefixExpected)
+		}
+	}
+	f(&CustomResourceConfig{Version: "v1", Resource: "configmaps"}, "/api/v1")
+	f(&CustomResourceConfig{Group: "monitoring.example.com", Version: "v1alpha1", Resource: "exporters"}, "/apis/monitoring.example.com/v1alpha1")
+}
+
+func TestParseCustomResourceListSuccess(t *testing.T) {
+	data := `
+{
+  "apiVersion": "monitoring.example.com/v1alpha1",
+  "kind": "ExporterList",
+  "metadata": {
+    "resourceVersion": "12345"
+  },
+  "items": [
+    {
+      "apiVersion": "monitoring.example.com/v1alpha1",
+      "kind": "Exporter",
+      "metadata": {
+        "name": "node-exporter",
+        "namespace": "monitoring",
+        "uid": "a5f4c1f2-3b5e-4b8d-9a4e-6b1f2f9d1c3e",
+        "labels": {
+          "app.kubernetes.io/name": "node-exporter"
+        },
+        "annotations": {
+          "example.com/owner": "team-a"
+        }
+      },
+      "spec": {
+        "endpoint": "10.0.0.1:9100",
+        "path": "/metrics",
+        "replicas": 3,
+        "ports": [
+          {
+            "name": "http",
+            "port": 9100
+          }
+        ]
+      },
+      "status": {
+        "ready": true
+      }
+    },
+    {
+      "apiVersion": "monitoring.example.com/v1alpha1",
+      "kind": "Exporter",
+      "metadata": {
+        "name": "pending-exporter",
+        "namespace": "monitoring"
+      },
+      "spec": {
+        "path": "/metrics"
+      }
+    }
+  ]
+}`
+	crl, err := parseCustomResourceList([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(crl.Items) != 2 {
+		t.Fatalf("unexpected length of CustomResourceList.Items; got %d; want %d", len(crl.Items), 2)
+	}
+	crc := &CustomResourceConfig{
+		Group:       "monitoring.example.com",
+		Version:     "v1alpha1",
+		Resource:    "exporters",
+		AddressPath: "{.spec.endpoint}",
+		Labels: map[string]string{
+			"metrics_path": "spec.path",
+			"replicas":     "spec.replicas",
+			"port-name":    "spec.ports[0].name",
+			"port":         "spec.ports[0].port",
+			"ready":        "status.ready",
+			"missing":      "spec.ports[1].name",
+		},
+	}
+
+	// Check cr.appendTargetLabels()
+	var labelss []map[string]string
+	for _, cr := range crl.Items {
+		labelss = cr.appendTargetLabels(labelss, crc)
+	}
+	var sortedLabelss [][]prompbmarshal.Label
+	for _, labels := range labelss {
+		sortedLabelss = append(sortedLabelss, discoveryutils.GetSortedLabels(labels))
+	}
+	expectedLabelss := [][]prompbmarshal.Label{
+		discoveryutils.GetSortedLabels(map[string]string{
+			"__address__": "10.0.0.1:9100",
+			"__meta_kubernetes_custom_annotation_example_com_owner":        "team-a",
+			"__meta_kubernetes_custom_annotationpresent_example_com_owner": "true",
+			"__meta_kubernetes_custom_field_metrics_path":                  "/metrics",
+			"__meta_kubernetes_custom_field_port":                          "9100",
+			"__meta_kubernetes_custom_field_port_name":                     "http",
+			"__meta_kubernetes_custom_field_ready":                         "true",
+			"__meta_kubernetes_custom_field_replicas":                      "3",
+			"__meta_kubernetes_custom_label_app_kubernetes_io_name":        "node-exporter",
+			"__meta_kubernetes_custom_labelpresent_app_kubernetes_io_name": "true",
+			"__meta_kubernetes_custom_name":                                "node-exporter",
+			"__meta_kubernetes_namespace":                                  "monitoring",
+		}),
+	}
+	if !reflect.DeepEqual(sortedLabelss, expectedLabelss) {
+		t.Fatalf("unexpected labels:\ngot\n%v\nwant\n%v", sortedLabelss, expectedLabelss)
+	}
+}
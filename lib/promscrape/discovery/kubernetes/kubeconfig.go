@@ -0,0 +1,261 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"gopkg.in/yaml.v2"
+)
+
+// kubeConfig represents kubeconfig file contents.
+//
+// See https://kubernetes.io/docs/concepts/configuration/organize-cluster-access-kubeconfig/
+type kubeConfig struct {
+	CurrentContext string              `yaml:"current-context"`
+	Clusters       []kubeConfigCluster `yaml:"clusters"`
+	AuthInfos      []kubeConfigUser    `yaml:"users"`
+	Contexts       []kubeConfigContext `yaml:"contexts"`
+}
+
+type kubeConfigCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server                   string `yaml:"server"`
+		TLSServerName            string `yaml:"tls-server-name"`
+		InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		CertificateAuthority     string `yaml:"certificate-authority"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	} `yaml:"cluster"`
+}
+
+type kubeConfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		ClientCertificate     string `yaml:"client-certificate"`
+		ClientCertificateData string `yaml:"client-certificate-data"`
+		ClientKey             string `yaml:"client-key"`
+		ClientKeyData         string `yaml:"client-key-data"`
+		Token                 string `yaml:"token"`
+		TokenFile             string `yaml:"tokenFile"`
+		Username              string `yaml:"username"`
+		Password              string `yaml:"password"`
+	} `yaml:"user"`
+}
+
+type kubeConfigContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user"`
+	} `yaml:"context"`
+}
+
+// kubeConfigAuth contains API server connection settings obtained from kubeconfig file for `current-context`.
+type kubeConfigAuth struct {
+	server string
+	ac     *promauth.Config
+
+	// tokenFile is set if the bearer token must be re-read from the file on every request.
+	tokenFile string
+}
+
+// readKubeConfig reads kubeconfig from the given path and returns API server connection settings for `current-context`.
+//
+// Relative paths in kubeconfig are resolved relative to the directory with kubeconfig in the same way as kubectl does.
+func readKubeConfig(path string) (*kubeConfigAuth, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read `kubeconfig_file` %q: %w", path, err)
+	}
+	kca, err := parseKubeConfig(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse `kubeconfig_file` %q: %w", path, err)
+	}
+	return kca, nil
+}
+
+func parseKubeConfig(data []byte, baseDir string) (*kubeConfigAuth, error) {
+	var kc kubeConfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, err
+	}
+	if kc.CurrentContext == "" {
+		return nil, fmt.Errorf("missing `current-context`")
+	}
+	var kctx *kubeConfigContext
+	for i := range kc.Contexts {
+		if kc.Contexts[i].Name == kc.CurrentContext {
+			kctx = &kc.Contexts[i]
+			break
+		}
+	}
+	if kctx == nil {
+		return nil, fmt.Errorf("cannot find context %q set in `current-context`", kc.CurrentContext)
+	}
+	var cluster *kubeConfigCluster
+	for i := range kc.Clusters {
+		if kc.Clusters[i].Name == kctx.Context.Cluster {
+			cluster = &kc.Clusters[i]
+			break
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cannot find cluster %q for context %q", kctx.Context.Cluster, kctx.Name)
+	}
+	if cluster.Cluster.Server == "" {
+		return nil, fmt.Errorf("missing `server` for cluster %q", cluster.Name)
+	}
+	ac := &promauth.Config{
+		TLSServerName:         cluster.Cluster.TLSServerName,
+		TLSInsecureSkipVerify: cluster.Cluster.InsecureSkipTLSVerify,
+	}
+	caData, err := getKubeConfigData(baseDir, cluster.Cluster.CertificateAuthority, cluster.Cluster.CertificateAuthorityData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain certificate authority for cluster %q: %w", cluster.Name, err)
+	}
+	if len(caData) > 0 {
+		ac.TLSRootCA = x509.NewCertPool()
+		if !ac.TLSRootCA.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("cannot parse certificate authority for cluster %q", cluster.Name)
+		}
+	}
+	kca := &kubeConfigAuth{
+		server: cluster.Cluster.Server,
+		ac:     ac,
+	}
+	if kctx.Context.User == "" {
+		return kca, nil
+	}
+	var user *kubeConfigUser
+	for i := range kc.AuthInfos {
+		if kc.AuthInfos[i].Name == kctx.Context.User {
+			user = &kc.AuthInfos[i]
+			break
+		}
+	}
+	if user == nil {
+		return nil, fmt.Errorf("cannot find user %q for context %q", kctx.Context.User, kctx.Name)
+	}
+	u := &user.User
+	certData, err := getKubeConfigData(baseDir, u.ClientCertificate, u.ClientCertificateData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain client certificate for user %q: %w", user.Name, err)
+	}
+	keyData, err := getKubeConfigData(baseDir, u.ClientKey, u.ClientKeyData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain client key for user %q: %w", user.Name, err)
+	}
+	if len(certData) > 0 || len(keyData) > 0 {
+		cert, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate for user %q: %w", user.Name, err)
+		}
+		ac.TLSCertificate = &cert
+	}
+	switch {
+	case u.Token != "" && u.TokenFile != "":
+		return nil, fmt.Errorf("both `token` and `tokenFile` are set for user %q", user.Name)
+	case u.Token != "":
+		ac.Authorization = "Bearer " + u.Token
+	case u.TokenFile != "":
+		kca.tokenFile = getFilepath(baseDir, u.TokenFile)
+	}
+	if u.Username != "" {
+		if ac.Authorization != "" || kca.tokenFile != "" {
+			return nil, fmt.Errorf("cannot use both token and basic auth for user %q", user.Name)
+		}
+		token := u.Username + ":" + u.Password
+		ac.Authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(token))
+	}
+	return kca, nil
+}
+
+// getKubeConfigData returns either base64-decoded data or the contents of the file at path.
+func getKubeConfigData(baseDir, path, data string) ([]byte, error) {
+	if data != "" {
+		if path != "" {
+			return nil, fmt.Errorf("both file %q and inline data are set", path)
+		}
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode base64-encoded data: %w", err)
+		}
+		return b, nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(getFilepath(baseDir, path))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func getFilepath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// tokenReader reads bearer token from file and re-reads it periodically.
+//
+// This is needed for picking up rotated tokens such as bound service account tokens in k8s.
+// See https://kubernetes.io/docs/reference/access-authn-authz/service-accounts-admin/#bound-service-account-token-volume
+type tokenReader struct {
+	path string
+
+	mu            sync.Mutex
+	authorization string
+	lastReadTime  time.Time
+}
+
+// tokenReadInterval is the interval for re-reading bearer token file.
+//
+// It matches the interval used by k8s client-go.
+const tokenReadInterval = time.Minute
+
+func newTokenReader(path string) (*tokenReader, error) {
+	tr := &tokenReader{
+		path: path,
+	}
+	if _, err := tr.getAuthorization(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// getAuthorization returns `Authorization` header value with the bearer token from tr.path.
+//
+// The previously read token is returned if the file cannot be re-read, so temporary errors during token rotation are ignored.
+func (tr *tokenReader) getAuthorization() (string, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.authorization != "" && time.Since(tr.lastReadTime) < tokenReadInterval {
+		return tr.authorization, nil
+	}
+	data, err := ioutil.ReadFile(tr.path)
+	if err != nil || len(data) == 0 {
+		if tr.authorization != "" {
+			return tr.authorization, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("the file is empty")
+		}
+		return "", fmt.Errorf("cannot read bearer token from %q: %w", tr.path, err)
+	}
+	tr.authorization = "Bearer " + strings.TrimRightFunc(string(data), unicode.IsSpace)
+	tr.lastReadTime = time.Now()
+	return tr.authorization, nil
+}
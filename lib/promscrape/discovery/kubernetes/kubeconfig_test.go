@@ -0,0 +1,431 @@
+package kubernetes
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseKubeConfigFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		kca, err := parseKubeConfig([]byte(data), ".")
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if kca != nil {
+			t.Fatalf("unexpected non-nil kubeConfigAuth: %v", kca)
+		}
+	}
+	f(`[1,2]`)
+
+	// Missing current-context
+	f(`
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+`)
+
+	// Missing context
+	f(`
+current-context: bar
+contexts:
+- name: foo
+  context:
+    cluster: foo
+`)
+
+	// Missing cluster
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: bar
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+`)
+
+	// Missing server
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+clusters:
+- name: foo
+`)
+
+	// Missing user
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+    user: bar
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+`)
+
+	// Invalid certificate-authority-data
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+    certificate-authority-data: foobar
+`)
+
+	// Missing certificate-authority file
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+    certificate-authority: /non-existing/ca.crt
+`)
+
+	// Both token and basic auth
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+    user: foo
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+users:
+- name: foo
+  user:
+    token: abc
+    username: admin
+`)
+}
+
+func TestParseKubeConfigSuccess(t *testing.T) {
+	f := func(data, serverExpected, authorizationExpected, tokenFileExpected string, insecureSkipVerifyExpected bool) {
+		t.Helper()
+		kca, err := parseKubeConfig([]byte(data), "/etc/kube")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if kca.server != serverExpected {
+			t.Fatalf("unexpected server; got %q; want %q", kca.server, serverExpected)
+		}
+		if kca.ac.Authorization != authorizationExpected {
+			t.Fatalf("unexpected authorization; got %q; want %q", kca.ac.Authorization, authorizationExpected)
+		}
+		if kca.tokenFile != tokenFileExpected {
+			t.Fatalf("unexpected tokenFile; got %q; want %q", kca.tokenFile, tokenFileExpected)
+		}
+		if kca.ac.TLSInsecureSkipVerify != insecureSkipVerifyExpected {
+			t.Fatalf("unexpected insecureSkipVerify; got %v; want %v", kca.ac.TLSInsecureSkipVerify, insecureSkipVerifyExpected)
+		}
+	}
+
+	// Context without user
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+    insecure-skip-tls-verify: true
+`, "https://foo:6443", "", "", true)
+
+	// Bearer token for current-context
+	f(`
+current-context: dev
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+clusters:
+- name: prod
+  cluster:
+    server: https://prod:6443
+- name: dev
+  cluster:
+    server: https://dev:6443
+users:
+- name: prod
+  user:
+    token: prod-token
+- name: dev
+  user:
+    token: dev-token
+`, "https://dev:6443", "Bearer dev-token", "", false)
+
+	// Token file relative to kubeconfig dir
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+    user: foo
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+users:
+- name: foo
+  user:
+    tokenFile: secrets/token
+`, "https://foo:6443", "", "/etc/kube/secrets/token", false)
+
+	// Basic auth
+	f(`
+current-context: foo
+contexts:
+- name: foo
+  context:
+    cluster: foo
+    user: foo
+clusters:
+- name: foo
+  cluster:
+    server: https://foo:6443
+users:
+- name: foo
+  user:
+    username: admin
+    password: secret
+`, "https://foo:6443", "Basic YWRtaW46c2VjcmV0", "", false)
+}
+
+func TestNewAPIConfigKubeConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	data := `
+current-context: local
+contexts:
+- name: local
+  context:
+    cluster: local
+    user: local
+clusters:
+- name: local
+  cluster:
+    server: https://kind-control-plane:6443
+users:
+- name: local
+  user:
+    token: local-token
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubeconfig"), []byte(data), 0600); err != nil {
+		t.Fatalf("cannot write kubeconfig: %s", err)
+	}
+	// In-cluster env vars mustn't be used when kubeconfig_file is set.
+	defer setInClusterEnv(t, "10.0.0.1", "443")()
+
+	f := func(sdc *SDConfig, apiServerExpected string) {
+		t.Helper()
+		cfg, err := newAPIConfig(sdc, dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if apiServer := cfg.client.APIServer(); apiServer != apiServerExpected {
+			t.Fatalf("unexpected api server; got %q; want %q", apiServer, apiServerExpected)
+		}
+	}
+	f(&SDConfig{
+		KubeConfigFile: "kubeconfig",
+	}, "https://kind-control-plane:6443")
+
+	// api_server overrides the server from kubeconfig
+	f(&SDConfig{
+		APIServer:      "https://127.0.0.1:6443",
+		KubeConfigFile: "kubeconfig",
+	}, "https://127.0.0.1:6443")
+
+	// kubeconfig_file cannot be used together with other auth options
+	if _, err := newAPIConfig(&SDConfig{KubeConfigFile: "kubeconfig", BearerToken: "foo"}, dir); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	// Missing kubeconfig_file
+	if _, err := newAPIConfig(&SDConfig{KubeConfigFile: "missing"}, dir); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func TestNewAPIConfigInCluster(t *testing.T) {
+	var authorizationsLock sync.Mutex
+	var authorizations []string
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizationsLock.Lock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		authorizationsLock.Unlock()
+		fmt.Fprintf(w, `{"items":[]}`)
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	caData := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: s.Certificate().Raw,
+	})
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, caData, 0600); err != nil {
+		t.Fatalf("cannot write ca file: %s", err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("token-1\n"), 0600); err != nil {
+		t.Fatalf("cannot write token file: %s", err)
+	}
+	tokenFileOrig, caFileOrig := inClusterTokenFile, inClusterCAFile
+	inClusterTokenFile, inClusterCAFile = tokenFile, caFile
+	defer func() {
+		inClusterTokenFile, inClusterCAFile = tokenFileOrig, caFileOrig
+	}()
+
+	// Missing KUBERNETES_SERVICE_HOST
+	defer setInClusterEnv(t, "", "")()
+	if _, err := newAPIConfig(&SDConfig{}, "."); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	// The server certificate is issued for 127.0.0.1 and example.com, so connect via localhost ip.
+	setInClusterEnv(t, "127.0.0.1", u.Port())
+	cfg, err := newAPIConfig(&SDConfig{}, ".")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	apiServerExpected := "https://127.0.0.1:" + u.Port()
+	if apiServer := cfg.client.APIServer(); apiServer != apiServerExpected {
+		t.Fatalf("unexpected api server; got %q; want %q", apiServer, apiServerExpected)
+	}
+	if _, err := cfg.client.GetAPIResponse("/api/v1/pods"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	authorizationsLock.Lock()
+	defer authorizationsLock.Unlock()
+	authorizationsExpected := []string{"Bearer token-1"}
+	if fmt.Sprintf("%q", authorizations) != fmt.Sprintf("%q", authorizationsExpected) {
+		t.Fatalf("unexpected authorizations; got %q; want %q", authorizations, authorizationsExpected)
+	}
+}
+
+func TestTokenReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "token")
+	if _, err := newTokenReader(path); err == nil {
+		t.Fatalf("expecting non-nil error for missing token file")
+	}
+	if err := ioutil.WriteFile(path, []byte("foo\n"), 0600); err != nil {
+		t.Fatalf("cannot write token file: %s", err)
+	}
+	tr, err := newTokenReader(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f := func(authorizationExpected string) {
+		t.Helper()
+		authorization, err := tr.getAuthorization()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if authorization != authorizationExpected {
+			t.Fatalf("unexpected authorization; got %q; want %q", authorization, authorizationExpected)
+		}
+	}
+	f("Bearer foo")
+
+	// The token isn't re-read until tokenReadInterval passes.
+	if err := ioutil.WriteFile(path, []byte("bar"), 0600); err != nil {
+		t.Fatalf("cannot write token file: %s", err)
+	}
+	f("Bearer foo")
+	tr.lastReadTime = time.Now().Add(-tokenReadInterval)
+	f("Bearer bar")
+
+	// The previous token is returned if the file is temporarily missing during rotation.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("cannot remove token file: %s", err)
+	}
+	tr.lastReadTime = time.Now().Add(-tokenReadInterval)
+	f("Bearer bar")
+}
+
+func setInClusterEnv(t *testing.T, host, port string) func() {
+	t.Helper()
+	hostOrig, hostOK := os.LookupEnv("KUBERNETES_SERVICE_HOST")
+	portOrig, portOK := os.LookupEnv("KUBERNETES_SERVICE_PORT")
+	set := func(name, value string) {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatalf("cannot set %s: %s", name, err)
+		}
+	}
+	set("KUBERNETES_SERVICE_HOST", host)
+	set("KUBERNETES_SERVICE_PORT", port)
+	return func() {
+		restore := func(name, value string, ok bool) {
+			if ok {
+				set(name, value)
+			} else {
+				_ = os.Unsetenv(name)
+			}
+		}
+		restore("KUBERNETES_SERVICE_HOST", hostOrig, hostOK)
+		restore("KUBERNETES_SERVICE_PORT", portOrig, portOK)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
 )
 
 // SDConfig represents kubernetes-based service discovery config.
@@ -11,13 +12,21 @@ import (
 // See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#kubernetes_sd_config
 type SDConfig struct {
 	APIServer       string                    `yaml:"api_server,omitempty"`
+	KubeConfigFile  string                    `yaml:"kubeconfig_file,omitempty"`
 	Role            string                    `yaml:"role"`
 	BasicAuth       *promauth.BasicAuthConfig `yaml:"basic_auth,omitempty"`
 	BearerToken     string                    `yaml:"bearer_token,omitempty"`
 	BearerTokenFile string                    `yaml:"bearer_token_file,omitempty"`
 	TLSConfig       *promauth.TLSConfig       `yaml:"tls_config,omitempty"`
+	ProxyURL        *proxy.URL                `yaml:"proxy_url,omitempty"`
 	Namespaces      Namespaces                `yaml:"namespaces,omitempty"`
 	Selectors       []Selector                `yaml:"selectors,omitempty"`
+
+	// Custom must be set for `role: custom`.
+	Custom *CustomResourceConfig `yaml:"custom,omitempty"`
+
+	// APIServers may contain multiple API servers for discovering targets across multiple k8s clusters.
+	APIServers []APIServerConfig `yaml:"api_servers,omitempty"`
 }
 
 // Namespaces represents namespaces for SDConfig
@@ -37,10 +46,17 @@ type Selector struct {
 
 // GetLabels returns labels for the given sdc and baseDir.
 func GetLabels(sdc *SDConfig, baseDir string) ([]map[string]string, error) {
+	if len(sdc.APIServers) > 0 {
+		return getClustersLabels(sdc, baseDir)
+	}
 	cfg, err := getAPIConfig(sdc, baseDir)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create API config: %w", err)
 	}
+	return getRoleLabels(cfg, sdc)
+}
+
+func getRoleLabels(cfg *apiConfig, sdc *SDConfig) ([]map[string]string, error) {
 	switch sdc.Role {
 	case "node":
 		return getNodesLabels(cfg)
@@ -54,7 +70,9 @@ func GetLabels(sdc *SDConfig, baseDir string) ([]map[string]string, error) {
 		return getEndpointSlicesLabels(cfg)
 	case "ingress":
 		return getIngressesLabels(cfg)
+	case "custom":
+		return getCustomResourcesLabels(cfg, sdc.Custom)
 	default:
-		return nil, fmt.Errorf("unexpected `role`: %q; must be one of `node`, `service`, `pod`, `endpoints` or `ingress`; skipping it", sdc.Role)
+		return nil, fmt.Errorf("unexpected `role`: %q; must be one of `node`, `service`, `pod`, `endpoints`, `endpointslices`, `ingress` or `custom`; skipping it", sdc.Role)
 	}
 }
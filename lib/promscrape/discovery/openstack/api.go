@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promscrape/discoveryutils"
 )
@@ -76,14 +78,24 @@ func newAPIConfig(sdc *SDConfig, baseDir string) (*apiConfig, error) {
 		allTenants:   sdc.AllTenants,
 		port:         sdc.Port,
 	}
-	if sdc.TLSConfig != nil {
-		ac, err := promauth.NewConfig(baseDir, nil, "", "", sdc.TLSConfig)
-		if err != nil {
-			return nil, err
+	if sdc.TLSConfig != nil || sdc.ProxyURL != nil {
+		tr := &http.Transport{}
+		if sdc.TLSConfig != nil {
+			ac, err := promauth.NewConfig(baseDir, nil, "", "", sdc.TLSConfig)
+			if err != nil {
+				return nil, err
+			}
+			tr.TLSClientConfig = ac.NewTLSConfig()
 		}
-		cfg.client.Transport = &http.Transport{
-			TLSClientConfig: ac.NewTLSConfig(),
+		if sdc.ProxyURL != nil {
+			d := &net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				DualStack: netutil.TCP6Enabled(),
+			}
+			tr.DialContext = sdc.ProxyURL.NewDialContextFunc(d.DialContext)
 		}
+		cfg.client.Transport = tr
 	}
 	// use public compute endpoint by default
 	if len(cfg.availability) == 0 {
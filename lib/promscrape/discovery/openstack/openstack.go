@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
 )
 
 // SDConfig is the configuration for OpenStack based service discovery.
@@ -29,6 +30,7 @@ type SDConfig struct {
 	AllTenants   bool                `yaml:"all_tenants,omitempty"`
 	TLSConfig    *promauth.TLSConfig `yaml:"tls_config,omitempty"`
 	Availability string              `yaml:"availability,omitempty"`
+	ProxyURL     *proxy.URL          `yaml:"proxy_url,omitempty"`
 }
 
 // GetLabels returns gce labels according to sdc.
@@ -12,6 +12,7 @@ import (
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
 	"github.com/VictoriaMetrics/fasthttp"
 )
@@ -37,10 +38,14 @@ type Client struct {
 	ac        *promauth.Config
 	apiServer string
 	hostPort  string
+
+	getAuthorization func() (string, error)
 }
 
 // NewClient returns new Client for the given apiServer and the given ac.
-func NewClient(apiServer string, ac *promauth.Config) (*Client, error) {
+//
+// Connections to apiServer are established via proxyURL if it isn't nil.
+func NewClient(apiServer string, ac *promauth.Config, proxyURL *proxy.URL) (*Client, error) {
 	var (
 		dialFunc fasthttp.DialFunc
 		tlsCfg   *tls.Config
@@ -55,6 +60,13 @@ func NewClient(apiServer string, ac *promauth.Config) (*Client, error) {
 		dialFunc = func(_ string) (net.Conn, error) {
 			return net.Dial("unix", dialAddr)
 		}
+	} else if proxyURL != nil {
+		d := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: netutil.TCP6Enabled(),
+		}
+		dialFunc = proxyURL.NewDialFunc(d.DialContext, 30*time.Second)
 	}
 	hostPort := string(u.Host())
 	isTLS := string(u.Scheme()) == "https"
@@ -88,6 +100,20 @@ func NewClient(apiServer string, ac *promauth.Config) (*Client, error) {
 	}, nil
 }
 
+// SetAuthorizationFunc sets f for obtaining `Authorization` header value on every request.
+//
+// This allows picking up rotated credentials such as k8s service account tokens.
+// The header value obtained from f overrides the Authorization from promauth.Config passed to NewClient.
+// SetAuthorizationFunc must be called before the first request.
+func (c *Client) SetAuthorizationFunc(f func() (string, error)) {
+	c.getAuthorization = f
+}
+
+// APIServer returns API server address for c.
+func (c *Client) APIServer() string {
+	return c.apiServer
+}
+
 var (
 	concurrencyLimitCh     chan struct{}
 	concurrencyLimitChOnce sync.Once
@@ -112,6 +138,18 @@ func (c *Client) GetAPIResponse(path string) ([]byte, error) {
 	}
 	defer func() { <-concurrencyLimitCh }()
 
+	authorization := ""
+	if c.ac != nil {
+		authorization = c.ac.Authorization
+	}
+	if c.getAuthorization != nil {
+		auth, err := c.getAuthorization()
+		if err != nil {
+			return nil, fmt.Errorf("cannot obtain Authorization for %q: %w", c.apiServer, err)
+		}
+		authorization = auth
+	}
+
 	requestURL := c.apiServer + path
 	var u fasthttp.URI
 	u.Update(requestURL)
@@ -119,8 +157,8 @@ func (c *Client) GetAPIResponse(path string) ([]byte, error) {
 	req.SetRequestURIBytes(u.RequestURI())
 	req.SetHost(c.hostPort)
 	req.Header.Set("Accept-Encoding", "gzip")
-	if c.ac != nil && c.ac.Authorization != "" {
-		req.Header.Set("Authorization", c.ac.Authorization)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
 	}
 	var resp fasthttp.Response
 	deadline := time.Now().Add(c.hc.ReadTimeout)
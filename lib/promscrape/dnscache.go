@@ -0,0 +1,121 @@
+package promscrape
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var dnsCacheTTL = flag.Duration("promscrape.dnsCacheTTL", 0, "The duration for caching resolved IP addresses for scrape target hostnames. "+
+	"This reduces scrape latency and DNS load when scraping targets by hostnames. The cached address is dropped on connection failure. "+
+	"Zero value disables caching in vmagent, so target hostnames are resolved by the underlying dialer")
+
+// resolveDialAddr returns addr with the host replaced by the cached IP address if -promscrape.dnsCacheTTL is set.
+//
+// The returned host must be passed to dnsCacheGlobal.invalidate on dial error.
+// Empty host is returned if addr isn't resolved via the cache.
+func resolveDialAddr(addr string) (dialAddr, host string, err error) {
+	if *dnsCacheTTL <= 0 {
+		return addr, "", nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		// Let the dialer deal with invalid addresses and IP literals.
+		return addr, "", nil
+	}
+	ip, err := dnsCacheGlobal.get(host, *dnsCacheTTL)
+	if err != nil {
+		return "", "", err
+	}
+	return net.JoinHostPort(ip, port), host, nil
+}
+
+type dnsCache struct {
+	mu sync.Mutex
+	m  map[string]dnsCacheEntry
+
+	lastCleanupTime time.Time
+}
+
+type dnsCacheEntry struct {
+	ip       string
+	deadline time.Time
+}
+
+var dnsCacheGlobal = &dnsCache{
+	m: make(map[string]dnsCacheEntry),
+}
+
+// get returns IP address for the given host.
+//
+// The address is resolved at most once per ttl unless it is invalidated.
+func (dc *dnsCache) get(host string, ttl time.Duration) (string, error) {
+	currentTime := time.Now()
+	dc.mu.Lock()
+	e, ok := dc.m[host]
+	dc.mu.Unlock()
+	if ok && currentTime.Before(e.deadline) {
+		dnsCacheHits.Inc()
+		return e.ip, nil
+	}
+	dnsCacheMisses.Inc()
+
+	// Errors aren't cached, so the dial error is returned to the caller and the next dial attempt resolves the host again.
+	ip, err := lookupDialIP(host)
+	if err != nil {
+		return "", err
+	}
+	dc.mu.Lock()
+	dc.m[host] = dnsCacheEntry{
+		ip:       ip,
+		deadline: currentTime.Add(ttl),
+	}
+	if currentTime.Sub(dc.lastCleanupTime) > time.Minute {
+		for k, v := range dc.m {
+			if !currentTime.Before(v.deadline) {
+				delete(dc.m, k)
+			}
+		}
+		dc.lastCleanupTime = currentTime
+	}
+	dc.mu.Unlock()
+	return ip, nil
+}
+
+// invalidate removes the cached IP address for the given host, so it is resolved again on the next dial.
+//
+// This prevents from pinning to dead IP addresses.
+func (dc *dnsCache) invalidate(host string) {
+	dc.mu.Lock()
+	delete(dc.m, host)
+	dc.mu.Unlock()
+}
+
+func lookupDialIP(host string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip.IP.To4() != nil || netutil.TCP6Enabled() {
+			return ip.IP.String(), nil
+		}
+	}
+	if len(ips) > 0 {
+		return "", fmt.Errorf("cannot find IPv4 address for %q; try -enableTCP6 command-line flag", host)
+	}
+	return "", fmt.Errorf("cannot find IP addresses for %q", host)
+}
+
+var (
+	dnsCacheHits   = metrics.NewCounter(`vm_promscrape_dns_cache_hits_total`)
+	dnsCacheMisses = metrics.NewCounter(`vm_promscrape_dns_cache_misses_total`)
+)
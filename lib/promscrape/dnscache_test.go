@@ -0,0 +1,127 @@
+package promscrape
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	var lookups uint64
+	lookupIPAddrOrig := lookupIPAddr
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		atomic.AddUint64(&lookups, 1)
+		if host == "unknown" {
+			return nil, fmt.Errorf("no such host")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	}
+	defer func() {
+		lookupIPAddr = lookupIPAddrOrig
+	}()
+
+	dc := &dnsCache{
+		m: make(map[string]dnsCacheEntry),
+	}
+	f := func(host, ipExpected string, lookupsExpected uint64) {
+		t.Helper()
+		ip, err := dc.get(host, time.Hour)
+		if ipExpected == "" {
+			if err == nil {
+				t.Fatalf("expecting non-nil error for host %q", host)
+			}
+		} else if err != nil {
+			t.Fatalf("unexpected error for host %q: %s", host, err)
+		}
+		if ip != ipExpected {
+			t.Fatalf("unexpected ip for host %q; got %q; want %q", host, ip, ipExpected)
+		}
+		if n := atomic.LoadUint64(&lookups); n != lookupsExpected {
+			t.Fatalf("unexpected number of lookups; got %d; want %d", n, lookupsExpected)
+		}
+	}
+
+	// The resolver must be called at most once per TTL window.
+	f("foo", "10.0.0.1", 1)
+	f("foo", "10.0.0.1", 1)
+	f("foo", "10.0.0.1", 1)
+	f("bar", "10.0.0.1", 2)
+
+	// Errors mustn't be cached.
+	f("unknown", "", 3)
+	f("unknown", "", 4)
+
+	// Invalidated entries must be resolved again.
+	dc.invalidate("foo")
+	f("foo", "10.0.0.1", 5)
+	f("foo", "10.0.0.1", 5)
+
+	// Expired entries must be resolved again.
+	dc.mu.Lock()
+	e := dc.m["foo"]
+	e.deadline = time.Now().Add(-time.Second)
+	dc.m["foo"] = e
+	dc.mu.Unlock()
+	f("foo", "10.0.0.1", 6)
+	f("foo", "10.0.0.1", 6)
+}
+
+func TestStatDialDNSCache(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	var lookups uint64
+	lookupIPAddrOrig := lookupIPAddr
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		atomic.AddUint64(&lookups, 1)
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+	dnsCacheTTLOrig := *dnsCacheTTL
+	*dnsCacheTTL = time.Hour
+	defer func() {
+		lookupIPAddr = lookupIPAddrOrig
+		*dnsCacheTTL = dnsCacheTTLOrig
+		dnsCacheGlobal.invalidate("dns-cache-test")
+	}()
+
+	addr := net.JoinHostPort("dns-cache-test", u.Port())
+	f := func(dial func() (net.Conn, error), okExpected bool, lookupsExpected uint64) {
+		t.Helper()
+		conn, err := dial()
+		if okExpected {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			_ = conn.Close()
+		} else if err == nil {
+			_ = conn.Close()
+			t.Fatalf("expecting non-nil error")
+		}
+		if n := atomic.LoadUint64(&lookups); n != lookupsExpected {
+			t.Fatalf("unexpected number of lookups; got %d; want %d", n, lookupsExpected)
+		}
+	}
+	fasthttpDial := func() (net.Conn, error) {
+		return statDial(addr)
+	}
+	stdDial := func() (net.Conn, error) {
+		return statStdDial(context.Background(), "tcp", addr)
+	}
+	f(fasthttpDial, true, 1)
+	f(fasthttpDial, true, 1)
+	f(stdDial, true, 1)
+
+	// Connection failure must invalidate the cached address.
+	s.Close()
+	f(fasthttpDial, false, 1)
+	f(stdDial, false, 2)
+	f(stdDial, false, 3)
+}
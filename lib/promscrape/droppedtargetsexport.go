@@ -0,0 +1,89 @@
+package promscrape
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+var (
+	exportDroppedTargets = flag.Bool("promscrape.exportDroppedTargets", false, "Whether to push `vm_promscrape_target_dropped` series with value 1 "+
+		"for targets dropped during relabeling. The series contain original target labels with stripped `__` prefix and suffix. "+
+		"The number of exported targets is limited by -promscrape.maxDroppedTargets")
+	exportDroppedTargetsInterval = flag.Duration("promscrape.exportDroppedTargetsInterval", time.Minute, "The interval for pushing `vm_promscrape_target_dropped` series "+
+		"if -promscrape.exportDroppedTargets is set")
+)
+
+func runDroppedTargetsExporter(pushData func(wr *prompbmarshal.WriteRequest), stopCh <-chan struct{}) {
+	ticker := time.NewTicker(*exportDroppedTargetsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			timestamp := time.Now().UnixNano() / 1e6
+			pushDroppedTargets(pushData, timestamp)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// pushDroppedTargets pushes `vm_promscrape_target_dropped` series for targets dropped during relabeling to pushData.
+func pushDroppedTargets(pushData func(wr *prompbmarshal.WriteRequest), timestamp int64) {
+	targets := droppedTargetsMap.getOriginalLabels("relabeling")
+	if len(targets) == 0 {
+		return
+	}
+	var wr prompbmarshal.WriteRequest
+	for _, originalLabels := range targets {
+		if len(originalLabels) == 0 {
+			// Original labels aren't tracked if -promscrape.dropOriginalLabels is set.
+			continue
+		}
+		wr.Timeseries = append(wr.Timeseries, prompbmarshal.TimeSeries{
+			Labels: getDroppedTargetLabels(originalLabels),
+			Samples: []prompbmarshal.Sample{{
+				Value:     1,
+				Timestamp: timestamp,
+			}},
+		})
+	}
+	if len(wr.Timeseries) > 0 {
+		pushData(&wr)
+	}
+}
+
+// getDroppedTargetLabels returns labels for `vm_promscrape_target_dropped` series from originalLabels.
+//
+// `__` prefix and suffix are stripped from label names, since such labels are reserved for internal use.
+// For example, `__address__` is converted to `address`, while `__meta_kubernetes_pod_name` is converted to `meta_kubernetes_pod_name`.
+// Labels without `__` prefix take precedence over the converted labels with the same name.
+func getDroppedTargetLabels(originalLabels []prompbmarshal.Label) []prompbmarshal.Label {
+	labels := make([]prompbmarshal.Label, 0, len(originalLabels)+1)
+	labels = append(labels, prompbmarshal.Label{
+		Name:  "__name__",
+		Value: "vm_promscrape_target_dropped",
+	})
+	for _, label := range originalLabels {
+		if !strings.HasPrefix(label.Name, "__") && label.Value != "" {
+			labels = append(labels, label)
+		}
+	}
+	for _, label := range originalLabels {
+		if !strings.HasPrefix(label.Name, "__") || label.Value == "" {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(label.Name, "__"), "__")
+		if name == "" || name == "name" || promrelabel.GetLabelByName(labels, name) != nil {
+			continue
+		}
+		labels = append(labels, prompbmarshal.Label{
+			Name:  name,
+			Value: label.Value,
+		})
+	}
+	return labels
+}
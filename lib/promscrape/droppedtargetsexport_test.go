@@ -0,0 +1,60 @@
+package promscrape
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestPushDroppedTargets(t *testing.T) {
+	sws, err := getStaticScrapeWork([]byte(`
+scrape_configs:
+- job_name: dropped_targets_export
+  static_configs:
+  - targets: ["foo:1234", "bar:1234", "baz:1234"]
+    labels:
+      __job__: xxx
+      env: prod
+  relabel_configs:
+  - source_labels: [__address__]
+    regex: "foo:.+|bar:.+"
+    action: drop
+`), "non-existing-file")
+	if err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+
+	var tss []prompbmarshal.TimeSeries
+	pushDroppedTargets(func(wr *prompbmarshal.WriteRequest) {
+		tss = append(tss, wr.Timeseries...)
+	}, 123000)
+
+	// The `__job__` label mustn't override `job` label.
+	// Other tests may register dropped targets too, so filter the exported series by job.
+	var result []string
+	for _, ts := range tss {
+		if promrelabel.GetLabelValueByName(ts.Labels, "job") != "dropped_targets_export" {
+			continue
+		}
+		if len(ts.Samples) != 1 || ts.Samples[0].Value != 1 || ts.Samples[0].Timestamp != 123000 {
+			t.Fatalf("unexpected samples for %s: %v", timeseriesToString(&ts), ts.Samples)
+		}
+		result = append(result, timeseriesToString(&ts))
+	}
+	resultExpected := map[string]bool{
+		`{__name__="vm_promscrape_target_dropped",address="bar:1234",env="prod",job="dropped_targets_export",metrics_path="/metrics",scheme="http"} 1 123000`: true,
+		`{__name__="vm_promscrape_target_dropped",address="foo:1234",env="prod",job="dropped_targets_export",metrics_path="/metrics",scheme="http"} 1 123000`: true,
+	}
+	if len(result) != len(resultExpected) {
+		t.Fatalf("unexpected number of exported series; got %d; want %d\n%s", len(result), len(resultExpected), result)
+	}
+	for _, s := range result {
+		if !resultExpected[s] {
+			t.Fatalf("unexpected series exported: %s", s)
+		}
+	}
+}
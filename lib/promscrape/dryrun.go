@@ -0,0 +1,117 @@
+package promscrape
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+var dryRunDuration = flag.Duration("promscrape.dryRunDuration", 0, "If set to positive value, then service discovery and relabeling are performed once for -promscrape.config, "+
+	"the resulting active and dropped targets are printed to stdout and the process exits without scraping the targets. "+
+	"The value limits the duration of service discovery. This may be useful for verifying the discovered targets before the deployment")
+
+// IsTargetsDryRun returns true if -promscrape.dryRunDuration command-line flag is set.
+func IsTargetsDryRun() bool {
+	return *dryRunDuration > 0
+}
+
+// WriteDryRunTargets performs service discovery and relabeling for -promscrape.config once and writes the resulting targets to w.
+//
+// The targets aren't scraped. An error is returned if the discovery doesn't finish in -promscrape.dryRunDuration.
+func WriteDryRunTargets(w io.Writer) error {
+	if *promscrapeConfigFile == "" {
+		return fmt.Errorf("missing -promscrape.config option")
+	}
+	cfg, _, err := loadConfig(*promscrapeConfigFile)
+	if err != nil {
+		return err
+	}
+	return writeDryRunTargets(w, cfg, *dryRunDuration)
+}
+
+// dryRunTarget is an active target discovered by writeDryRunTargets.
+type dryRunTarget struct {
+	sw     *ScrapeWork
+	sdName string
+}
+
+// writeDryRunTargets discovers targets for cfg by all the service discoveries without starting scrapers and writes them to w.
+//
+// Active targets are grouped by job, while dropped targets are written with the reason for dropping.
+func writeDryRunTargets(w io.Writer, cfg *Config, timeout time.Duration) error {
+	sds := append(getBuiltinDiscoveries(), getSDProviderDiscoveries()...)
+	resultCh := make(chan []dryRunTarget, 1)
+	go func() {
+		swss := make([][]ScrapeWork, len(sds))
+		tl := newTargetLimiter()
+		for i, sd := range sds {
+			swss[i] = sd.getScrapeWork(cfg, nil)
+			// Register targets for every service discovery type before applying `target_limit` below,
+			// since the limit is applied to the targets discovered by all the service discovery types.
+			tl.register(cfg, sd.name, swss[i])
+		}
+		var targets []dryRunTarget
+		for i, sd := range sds {
+			sws := tl.apply(cfg, sd.name, swss[i])
+			targets = appendDryRunTargets(targets, sws, sd.name)
+		}
+		resultCh <- targets
+	}()
+	var targets []dryRunTarget
+	select {
+	case targets = <-resultCh:
+	case <-time.After(timeout):
+		return fmt.Errorf("service discovery didn't finish in %s; try increasing -promscrape.dryRunDuration", timeout)
+	}
+
+	byJob := make(map[string][]dryRunTarget)
+	for _, t := range targets {
+		job := t.sw.Job()
+		byJob[job] = append(byJob[job], t)
+	}
+	jobs := make([]string, 0, len(byJob))
+	for job := range byJob {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		ts := byJob[job]
+		sort.Slice(ts, func(i, j int) bool {
+			return ts[i].sw.ScrapeURL < ts[j].sw.ScrapeURL
+		})
+		fmt.Fprintf(w, "job=%q (%d active targets)\n", job, len(ts))
+		for _, t := range ts {
+			fmt.Fprintf(w, "\ttype=%s, endpoint=%s, labels=%s, scrape_interval=%s, scrape_timeout=%s\n",
+				t.sdName, t.sw.ScrapeURL, t.sw.LabelsString(), t.sw.ScrapeInterval, t.sw.ScrapeTimeout)
+		}
+	}
+	droppedTargetsMap.WriteHumanReadable(w)
+	return nil
+}
+
+// appendDryRunTargets appends targets from sws, which would be scraped by the current cluster member, to dst.
+//
+// Targets for other cluster members and duplicate targets are registered as dropped in the same way as scraperGroup.update does.
+func appendDryRunTargets(dst []dryRunTarget, sws []ScrapeWork, sdName string) []dryRunTarget {
+	keys := make(map[string]bool, len(sws))
+	for i := range sws {
+		sw := &sws[i]
+		if *clusterMembersCount > 1 && !isClusterMemberTarget(sw, *clusterMembersCount, *clusterMemberNum, *clusterReplicationFactor) {
+			droppedTargetsMap.Register(sw.OriginalLabels, "other_shard")
+			continue
+		}
+		key := sw.key()
+		if keys[key] {
+			droppedTargetsMap.Register(sw.OriginalLabels, "duplicate")
+			continue
+		}
+		keys[key] = true
+		dst = append(dst, dryRunTarget{
+			sw:     sw,
+			sdName: sdName,
+		})
+	}
+	return dst
+}
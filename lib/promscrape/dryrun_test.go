@@ -0,0 +1,97 @@
+package promscrape
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type blockingSDProvider struct {
+	stopCh <-chan struct{}
+}
+
+func (p *blockingSDProvider) GetScrapeWork(swsPrev []ScrapeWork) ([]ScrapeWork, error) {
+	<-p.stopCh
+	return nil, nil
+}
+
+func TestWriteDryRunTargets(t *testing.T) {
+	var requests uint64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&requests, 1)
+	}))
+	defer s.Close()
+	target := s.Listener.Addr().String()
+
+	data := `
+scrape_configs:
+- job_name: dry_run
+  scrape_interval: 10ms
+  relabel_configs:
+  - source_labels: [__address__]
+    regex: "dropped:.+"
+    action: drop
+  static_configs:
+  - targets: ["` + target + `", "dropped:1234"]
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	pendingScrapeConfigs := atomic.LoadInt32(&PendingScrapeConfigs)
+	var bb bytes.Buffer
+	if err := writeDryRunTargets(&bb, &cfg, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result := bb.String()
+	for _, s := range []string{
+		`job="dry_run" (1 active targets)`,
+		`type=static_configs, endpoint=http://` + target + `/metrics, labels={instance="` + target + `",job="dry_run"}, scrape_interval=10ms, scrape_timeout=10s`,
+		`reason="relabeling", discoveredLabels={__address__="dropped:1234"`,
+	} {
+		if !strings.Contains(result, s) {
+			t.Fatalf("missing %q in the output:\n%s", s, result)
+		}
+	}
+
+	// Scrapers mustn't be started for the discovered targets.
+	if n := atomic.LoadInt32(&PendingScrapeConfigs); n != pendingScrapeConfigs {
+		t.Fatalf("unexpected number of pending scrape configs; got %d; want %d", n, pendingScrapeConfigs)
+	}
+	if n := tsmGlobal.StatusByGroup("static_configs", true) + tsmGlobal.StatusByGroup("static_configs", false); n != 0 {
+		t.Fatalf("unexpected number of target statuses registered for dry run; got %d; want 0", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadUint64(&requests); n != 0 {
+		t.Fatalf("unexpected number of scrapes during dry run; got %d; want 0", n)
+	}
+}
+
+func TestWriteDryRunTargetsTimeout(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	err := RegisterSDProvider("test_dry_run_timeout", func(cfg *Config) SDProvider {
+		return &blockingSDProvider{
+			stopCh: stopCh,
+		}
+	})
+	if err != nil {
+		t.Fatalf("cannot register custom service discovery: %s", err)
+	}
+	defer func() {
+		_ = RegisterSDProvider("test_dry_run_timeout", nil)
+	}()
+
+	var cfg Config
+	if err := cfg.parse([]byte(``), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	var bb bytes.Buffer
+	if err := writeDryRunTargets(&bb, &cfg, 100*time.Millisecond); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
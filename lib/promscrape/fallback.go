@@ -0,0 +1,148 @@
+package promscrape
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// fallbackClient reads data from targets with `__fallback_scrape_urls__` label.
+//
+// It scrapes ScrapeWork.ScrapeURL and, on failure, ScrapeWork.FallbackScrapeURLs in order until the first successful scrape.
+// The next fallback url is tried only if ScrapeWork.ScrapeTimeout isn't exceeded since the start of the scrape.
+// Every attempt is limited by the remaining time until ScrapeWork.ScrapeTimeout is exceeded,
+// so all the attempts cannot exceed ScrapeWork.ScrapeTimeout in total.
+//
+// fallbackClient must be used only by the goroutine performing the scrapes.
+type fallbackClient struct {
+	// clients contains the client for ScrapeURL followed by clients for FallbackScrapeURLs.
+	clients []*client
+
+	// autoLabels contains labels for automatically generated series per each client.
+	// They contain `scrape_url` label with the url of the corresponding client.
+	autoLabels [][]prompbmarshal.Label
+
+	timeout time.Duration
+
+	// idx is the index of the client used for the last scrape.
+	idx int
+}
+
+// newFallbackClient returns fallbackClient for sw with FallbackScrapeURLs.
+//
+// The client c for sw.ScrapeURL must be already created.
+func newFallbackClient(sw *ScrapeWork, c *client) *fallbackClient {
+	fc := &fallbackClient{
+		timeout: sw.ScrapeTimeout,
+	}
+	fc.addClient(sw.Labels, sw.ScrapeURL, c)
+	for _, u := range sw.FallbackScrapeURLs {
+		swCopy := *sw
+		swCopy.ScrapeURL = u
+		swCopy.AdditionalScrapeURLs = nil
+		fbc := newClient(&swCopy)
+		fbc.phaseTimings = c.phaseTimings
+		fbc.intervalHint = c.intervalHint
+		fc.addClient(sw.Labels, u, fbc)
+	}
+	return fc
+}
+
+func (fc *fallbackClient) addClient(targetLabels []prompbmarshal.Label, scrapeURL string, c *client) {
+	labels := make([]prompbmarshal.Label, 0, len(targetLabels)+1)
+	for _, label := range targetLabels {
+		if label.Name != "scrape_url" {
+			labels = append(labels, label)
+		}
+	}
+	labels = append(labels, prompbmarshal.Label{
+		Name:  "scrape_url",
+		Value: scrapeURL,
+	})
+	promrelabel.SortLabels(labels)
+	fc.clients = append(fc.clients, c)
+	fc.autoLabels = append(fc.autoLabels, labels)
+}
+
+// scrapeURL returns the url used for the last scrape.
+func (fc *fallbackClient) scrapeURL() string {
+	return fc.clients[fc.idx].scrapeURL
+}
+
+// getAutoLabels returns labels for automatically generated series for the last scrape.
+func (fc *fallbackClient) getAutoLabels() []prompbmarshal.Label {
+	return fc.autoLabels[fc.idx]
+}
+
+func (fc *fallbackClient) ReadData(dst []byte) ([]byte, error) {
+	deadline := time.Now().Add(fc.timeout)
+	dstLen := len(dst)
+	var firstErr error
+	var fallbackErrs []string
+	for i, c := range fc.clients {
+		if i > 0 && time.Until(deadline) <= 0 {
+			fallbackErrs = append(fallbackErrs, fmt.Sprintf("skipped %q, since scrape_timeout=%s is exceeded", c.scrapeURL, fc.timeout))
+			break
+		}
+		var err error
+		dst, err = c.readDataWithDeadline(dst[:dstLen], deadline)
+		if err == nil || err == errNotModified {
+			fc.registerSuccess(i)
+			return dst, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		} else {
+			fallbackErrs = append(fallbackErrs, err.Error())
+		}
+	}
+	fc.idx = 0
+	return dst[:dstLen], newFallbackError(firstErr, fallbackErrs)
+}
+
+func (fc *fallbackClient) GetStreamReader() (*streamReader, error) {
+	deadline := time.Now().Add(fc.timeout)
+	var firstErr error
+	var fallbackErrs []string
+	for i, c := range fc.clients {
+		if i > 0 && time.Until(deadline) <= 0 {
+			fallbackErrs = append(fallbackErrs, fmt.Sprintf("skipped %q, since scrape_timeout=%s is exceeded", c.scrapeURL, fc.timeout))
+			break
+		}
+		sr, err := c.getStreamReaderWithDeadline(deadline)
+		if err == nil {
+			fc.registerSuccess(i)
+			return sr, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		} else {
+			fallbackErrs = append(fallbackErrs, err.Error())
+		}
+	}
+	fc.idx = 0
+	return nil, newFallbackError(firstErr, fallbackErrs)
+}
+
+func (fc *fallbackClient) registerSuccess(idx int) {
+	if idx > 0 {
+		fallbackScrapes.Inc()
+	}
+	fc.idx = idx
+}
+
+// newFallbackError returns an error for the failed scrape of the primary url with err and fallback urls with fallbackErrs.
+//
+// The returned error wraps err, so its reason is detected in the same way as for targets without fallback urls.
+func newFallbackError(err error, fallbackErrs []string) error {
+	if len(fallbackErrs) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w; errors for fallback urls: %s", err, strings.Join(fallbackErrs, "; "))
+}
+
+var fallbackScrapes = metrics.NewCounter(`vm_promscrape_fallback_scrapes_total`)
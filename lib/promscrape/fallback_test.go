@@ -0,0 +1,170 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkFallbackScrapeURLs(t *testing.T) {
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer fallback.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	primaryAddr := primary.Listener.Addr().String()
+	// The primary url is down.
+	primary.Close()
+
+	f := func(fallbackURLs string, upExpected float64, scrapeURLExpected string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: fallback
+  static_configs:
+  - targets: [%q]
+    labels:
+      __fallback_scrape_urls__: %q
+`, primaryAddr, fallbackURLs)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		up := float64(-1)
+		scrapeURL := ""
+		samples := 0
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				switch promrelabel.GetLabelValueByName(ts.Labels, "__name__") {
+				case "up":
+					up = ts.Samples[0].Value
+					scrapeURL = promrelabel.GetLabelValueByName(ts.Labels, "scrape_url")
+				case "foo":
+					samples++
+				}
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(1700000000000)
+		_ = sc.sw.scrapeInternal(timestamp, timestamp)
+		if up != upExpected {
+			t.Fatalf("unexpected up; got %v; want %v", up, upExpected)
+		}
+		if scrapeURL != scrapeURLExpected {
+			t.Fatalf("unexpected scrape_url label; got %q; want %q", scrapeURL, scrapeURLExpected)
+		}
+		if up == 1 && samples != 1 {
+			t.Fatalf("unexpected number of scraped samples; got %d; want 1", samples)
+		}
+		if sc.sw.getStatusConfig().ScrapeURL != scrapeURLExpected {
+			t.Fatalf("unexpected url in target status; got %q; want %q", sc.sw.getStatusConfig().ScrapeURL, scrapeURLExpected)
+		}
+	}
+
+	primaryURL := "http://" + primaryAddr + "/metrics"
+	fallbackURL := fallback.URL + "/metrics"
+
+	// The fallback url is scraped when the primary url is down.
+	f(fallbackURL, 1, fallbackURL)
+
+	// Fallback urls are tried in order.
+	f(primaryURL+","+fallbackURL, 1, fallbackURL)
+
+	// The scrape is marked down only if all the urls fail.
+	f(primaryURL+"/foo", 0, primaryURL)
+}
+
+func TestScrapeWorkFallbackScrapeURLsTimeout(t *testing.T) {
+	stopCh := make(chan struct{})
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The fallback url hangs.
+		<-stopCh
+	}))
+	defer fallback.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The primary url fails after the most of scrape_timeout.
+		time.Sleep(600 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	defer close(stopCh)
+
+	f := func(streamParse bool) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: fallback
+  scrape_timeout: 1s
+  stream_parse: %v
+  static_configs:
+  - targets: [%q]
+    labels:
+      __fallback_scrape_urls__: %q
+`, streamParse, primary.Listener.Addr().String(), fallback.URL+"/metrics")
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+		startTime := time.Now()
+		timestamp := startTime.UnixNano() / 1e6
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		// The fallback url must be scraped only during the remaining time until scrape_timeout.
+		if d := time.Since(startTime); d > 1400*time.Millisecond {
+			t.Fatalf("the scrape with fallback urls exceeds scrape_timeout=1s; it took %s", d)
+		}
+	}
+	f(false)
+	f(true)
+}
+
+func TestFallbackScrapeURLsInvalid(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		// Targets with invalid `__fallback_scrape_urls__` must be skipped.
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 0 {
+			t.Fatalf("unexpected non-empty sws:\n%#v", sws)
+		}
+	}
+
+	// Fallback url without scheme
+	f(`
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+    labels:
+      __fallback_scrape_urls__: "bar:1234"
+`)
+
+	// Fallback urls with __addresses__
+	f(`
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+    labels:
+      __addresses__: "foo1,foo2"
+      __fallback_scrape_urls__: "http://bar:1234/metrics"
+`)
+}
@@ -0,0 +1,23 @@
+package promscrape
+
+import (
+	"fmt"
+	"strings"
+)
+
+// newGRPCReadData returns ScrapeWork.ReadData-compatible func, which obtains metrics by calling sw.GRPCMethod at sw.ScrapeURL host.
+//
+// It is set only when building with `grpc` build tag in order to keep gRPC dependencies out of the default build.
+var newGRPCReadData func(sw *ScrapeWork) func(dst []byte) ([]byte, error)
+
+// validateGRPCMethod verifies that method is in the form `/package.Service/Method`.
+func validateGRPCMethod(method string) error {
+	if !strings.HasPrefix(method, "/") {
+		return fmt.Errorf("%q must start with `/`", method)
+	}
+	n := strings.IndexByte(method[1:], '/')
+	if n <= 0 || n == len(method)-2 || strings.IndexByte(method[n+2:], '/') >= 0 {
+		return fmt.Errorf("%q must be in the form `/package.Service/Method`", method)
+	}
+	return nil
+}
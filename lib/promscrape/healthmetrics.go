@@ -0,0 +1,209 @@
+package promscrape
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+)
+
+// Supported values for `health_metrics` option in `scrape_config`.
+const (
+	// healthMetricsPerTarget adds `up`, `scrape_duration_seconds`, etc. series for every target. This is the default.
+	healthMetricsPerTarget = "per_target"
+
+	// healthMetricsAggregated adds `scrape_pool_*` series with the health of all the targets in the scrape pool
+	// instead of per-target series.
+	healthMetricsAggregated = "aggregated"
+)
+
+func validateHealthMetrics(mode string) error {
+	switch mode {
+	case "", healthMetricsPerTarget, healthMetricsAggregated:
+		return nil
+	default:
+		return fmt.Errorf("unsupported `health_metrics` %q; supported values: %q, %q", mode, healthMetricsPerTarget, healthMetricsAggregated)
+	}
+}
+
+// getHealthLabels returns sorted labels from `health_metrics_labels` option.
+func getHealthLabels(m map[string]string) []prompbmarshal.Label {
+	if len(m) == 0 {
+		return nil
+	}
+	labels := make([]prompbmarshal.Label, 0, len(m))
+	for k, v := range m {
+		labels = append(labels, prompbmarshal.Label{
+			Name:  k,
+			Value: v,
+		})
+	}
+	promrelabel.SortLabels(labels)
+	return labels
+}
+
+// targetHealth contains the health of a single target after the last scrape.
+type targetHealth struct {
+	up                    bool
+	duration              float64
+	samplesScraped        int
+	samplesPostRelabeling int
+	seriesAdded           int
+
+	// bodySize is the size of the uncompressed response in bytes. It is zero for failed scrapes.
+	bodySize int64
+}
+
+// addHealthTimeseries adds automatically generated series with the target health th to wc.
+//
+// Per-target series are added if Config.HealthMetrics isn't set to aggregated.
+// Otherwise th is registered in the scrape pool for the target, and the pool-level series are added
+// once per Config.ScrapeInterval by the first target scraped after the interval.
+func (sw *scrapeWork) addHealthTimeseries(wc *writeRequestCtx, th *targetHealth, timestamp int64) {
+	if sw.Config.HealthMetrics != healthMetricsAggregated {
+		up := 0
+		if th.up {
+			up = 1
+		}
+		sw.addAutoTimeseries(wc, "up", float64(up), timestamp)
+		sw.addAutoTimeseries(wc, "scrape_duration_seconds", th.duration, timestamp)
+		sw.addAutoTimeseries(wc, "scrape_samples_scraped", float64(th.samplesScraped), timestamp)
+		sw.addAutoTimeseries(wc, "scrape_samples_post_metric_relabeling", float64(th.samplesPostRelabeling), timestamp)
+		sw.addAutoTimeseries(wc, "scrape_series_added", float64(th.seriesAdded), timestamp)
+		sw.addAutoTimeseries(wc, "scrape_body_size_bytes", float64(th.bodySize), timestamp)
+		return
+	}
+	hp := healthPoolsGlobal.get(sw.Config.jobNameOriginal, timestamp)
+	ph, ok := hp.update(sw, th, timestamp, sw.Config.ScrapeInterval.Milliseconds())
+	if !ok {
+		return
+	}
+	sw.addPoolTimeseries(wc, "scrape_pool_targets", "up", float64(ph.targetsUp), timestamp)
+	sw.addPoolTimeseries(wc, "scrape_pool_targets", "down", float64(ph.targetsDown), timestamp)
+	sw.addPoolTimeseries(wc, "scrape_pool_scrape_duration_seconds_max", "", ph.durationMax, timestamp)
+	sw.addPoolTimeseries(wc, "scrape_pool_samples_scraped", "", float64(ph.samplesScraped), timestamp)
+	sw.addPoolTimeseries(wc, "scrape_pool_samples_post_metric_relabeling", "", float64(ph.samplesPostRelabeling), timestamp)
+	sw.addPoolTimeseries(wc, "scrape_pool_series_added", "", float64(ph.seriesAdded), timestamp)
+}
+
+// addPoolTimeseries adds pool-level series with the given name, optional status label, value and timestamp to wc.
+func (sw *scrapeWork) addPoolTimeseries(wc *writeRequestCtx, name, status string, value float64, timestamp int64) {
+	sw.tmpRow.Metric = name
+	sw.tmpRow.Tags = nil
+	if status != "" {
+		sw.tmpRow.Tags = []parser.Tag{{
+			Key:   "status",
+			Value: status,
+		}}
+	}
+	sw.tmpRow.Value = value
+	sw.tmpRow.Timestamp = timestamp
+	if sw.poolLabels == nil {
+		labels := []prompbmarshal.Label{{
+			Name:  "job",
+			Value: sw.Config.jobNameOriginal,
+		}}
+		sw.poolLabels = append(labels, sw.Config.HealthLabels...)
+	}
+	sw.addRowToTimeseries(wc, &sw.tmpRow, sw.poolLabels, timestamp, false)
+}
+
+// healthPool contains the health of the targets in a single scrape pool for `health_metrics: aggregated`.
+type healthPool struct {
+	mu sync.Mutex
+
+	targets map[*scrapeWork]targetHealth
+
+	// lastPushTimestamp is the timestamp in milliseconds when the pool-level series have been added for the last time.
+	lastPushTimestamp int64
+}
+
+// poolHealth contains the aggregated health of the targets in a scrape pool.
+type poolHealth struct {
+	targetsUp             int
+	targetsDown           int
+	durationMax           float64
+	samplesScraped        int
+	samplesPostRelabeling int
+	seriesAdded           int
+}
+
+// update registers th for sw in hp.
+//
+// It returns the aggregated health for all the targets in hp and true if at least interval milliseconds passed since the last call returning true.
+func (hp *healthPool) update(sw *scrapeWork, th *targetHealth, timestamp, interval int64) (poolHealth, bool) {
+	var ph poolHealth
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.targets[sw] = *th
+	if timestamp-hp.lastPushTimestamp < interval {
+		return ph, false
+	}
+	hp.lastPushTimestamp = timestamp
+	for _, th := range hp.targets {
+		if th.up {
+			ph.targetsUp++
+		} else {
+			ph.targetsDown++
+		}
+		if th.duration > ph.durationMax {
+			ph.durationMax = th.duration
+		}
+		ph.samplesScraped += th.samplesScraped
+		ph.samplesPostRelabeling += th.samplesPostRelabeling
+		ph.seriesAdded += th.seriesAdded
+	}
+	return ph, true
+}
+
+// healthPools contains healthPool per job_name.
+type healthPools struct {
+	mu sync.Mutex
+	m  map[string]*healthPool
+}
+
+var healthPoolsGlobal = newHealthPools()
+
+func newHealthPools() *healthPools {
+	return &healthPools{
+		m: make(map[string]*healthPool),
+	}
+}
+
+// get returns healthPool for the given jobName. The pool is created at the given timestamp in milliseconds if it is missing.
+//
+// The first pool-level series are added a scrape interval after the pool creation, so all the targets in the pool are scraped at least once.
+func (hps *healthPools) get(jobName string, timestamp int64) *healthPool {
+	hps.mu.Lock()
+	hp := hps.m[jobName]
+	if hp == nil {
+		hp = &healthPool{
+			targets:           make(map[*scrapeWork]targetHealth),
+			lastPushTimestamp: timestamp,
+		}
+		hps.m[jobName] = hp
+	}
+	hps.mu.Unlock()
+	return hp
+}
+
+// unregister removes sw from the scrape pool, so it no longer affects the pool-level series.
+func (hps *healthPools) unregister(sw *scrapeWork) {
+	if sw.Config.HealthMetrics != healthMetricsAggregated {
+		return
+	}
+	jobName := sw.Config.jobNameOriginal
+	hps.mu.Lock()
+	if hp := hps.m[jobName]; hp != nil {
+		hp.mu.Lock()
+		delete(hp.targets, sw)
+		n := len(hp.targets)
+		hp.mu.Unlock()
+		if n == 0 {
+			delete(hps.m, jobName)
+		}
+	}
+	hps.mu.Unlock()
+}
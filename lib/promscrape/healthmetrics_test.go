@@ -0,0 +1,196 @@
+package promscrape
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkHealthMetricsAggregated(t *testing.T) {
+	data := `
+scrape_configs:
+- job_name: health_aggregated
+  scrape_interval: 10s
+  health_metrics: aggregated
+  health_metrics_labels:
+    source: health
+  static_configs:
+  - targets: ["foo:1234", "bar:1234", "baz:1234"]
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 3 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 3", len(sws))
+	}
+	var series []string
+	pushData := func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "scrape_pool_scrape_duration_seconds_max" {
+				// The scrape duration depends on the test environment.
+				continue
+			}
+			series = append(series, fmt.Sprintf("%s %v", promLabelsString(ts.Labels), ts.Samples[0].Value))
+		}
+	}
+	var scs []*scraper
+	for i := range sws {
+		sc := newScraper(&sws[i], "test", pushData)
+		if i == 0 {
+			sc.sw.ReadData = func(dst []byte) ([]byte, error) {
+				return dst, fmt.Errorf("cannot connect")
+			}
+		} else {
+			sc.sw.ReadData = func(dst []byte) ([]byte, error) {
+				return append(dst, "foo 1\nbar 2\n"...), nil
+			}
+		}
+		scs = append(scs, sc)
+	}
+	defer func() {
+		for _, sc := range scs {
+			healthPoolsGlobal.unregister(&sc.sw)
+		}
+	}()
+	f := func(sc *scraper, timestamp int64, resultExpected string) {
+		t.Helper()
+		series = series[:0]
+		_ = sc.sw.scrapeInternal(timestamp, timestamp)
+		sort.Strings(series)
+		result := strings.Join(series, "\n")
+		if result != resultExpected {
+			t.Fatalf("unexpected series;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// Per-target health series mustn't be added.
+	timestamp := int64(1700000000000)
+	targetSeries := func(instance string) string {
+		return fmt.Sprintf(`{__name__="bar",instance=%q,job="health_aggregated"} 2`+"\n"+
+			`{__name__="foo",instance=%q,job="health_aggregated"} 1`, instance, instance)
+	}
+	f(scs[0], timestamp, "")
+	f(scs[1], timestamp+1000, targetSeries("bar:1234"))
+	f(scs[2], timestamp+2000, targetSeries("baz:1234"))
+
+	// Pool-level series for all the targets are added once per scrape interval.
+	f(scs[1], timestamp+10000, targetSeries("bar:1234")+"\n"+
+		`{__name__="scrape_pool_samples_post_metric_relabeling",job="health_aggregated",source="health"} 4`+"\n"+
+		`{__name__="scrape_pool_samples_scraped",job="health_aggregated",source="health"} 4`+"\n"+
+		`{__name__="scrape_pool_series_added",job="health_aggregated",source="health"} 2`+"\n"+
+		`{__name__="scrape_pool_targets",job="health_aggregated",source="health",status="down"} 1`+"\n"+
+		`{__name__="scrape_pool_targets",job="health_aggregated",source="health",status="up"} 2`)
+	f(scs[2], timestamp+12000, targetSeries("baz:1234"))
+
+	// Stopped targets are excluded from pool-level series.
+	healthPoolsGlobal.unregister(&scs[0].sw)
+	f(scs[1], timestamp+20000, targetSeries("bar:1234")+"\n"+
+		`{__name__="scrape_pool_samples_post_metric_relabeling",job="health_aggregated",source="health"} 4`+"\n"+
+		`{__name__="scrape_pool_samples_scraped",job="health_aggregated",source="health"} 4`+"\n"+
+		`{__name__="scrape_pool_series_added",job="health_aggregated",source="health"} 0`+"\n"+
+		`{__name__="scrape_pool_targets",job="health_aggregated",source="health",status="down"} 0`+"\n"+
+		`{__name__="scrape_pool_targets",job="health_aggregated",source="health",status="up"} 2`)
+}
+
+func TestScrapeWorkHealthMetricsLabels(t *testing.T) {
+	var sw scrapeWork
+	sw.Config = ScrapeWork{
+		ScrapeURL: "http://foo.bar/metrics",
+		Labels: []prompbmarshal.Label{{
+			Name:  "instance",
+			Value: "foo.bar",
+		}},
+		HealthLabels: []prompbmarshal.Label{{
+			Name:  "source",
+			Value: "health",
+		}},
+	}
+	sw.ReadData = func(dst []byte) ([]byte, error) {
+		return append(dst, "foo 1\n"...), nil
+	}
+	var series []string
+	sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			series = append(series, promLabelsString(ts.Labels))
+		}
+	}
+	timestamp := int64(1700000000000)
+	if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sort.Strings(series)
+	result := strings.Join(series, "\n")
+	resultExpected := `{__name__="foo",instance="foo.bar"}
+{__name__="scrape_body_size_bytes",instance="foo.bar",source="health"}
+{__name__="scrape_duration_seconds",instance="foo.bar",source="health"}
+{__name__="scrape_samples_post_metric_relabeling",instance="foo.bar",source="health"}
+{__name__="scrape_samples_scraped",instance="foo.bar",source="health"}
+{__name__="scrape_series_added",instance="foo.bar",source="health"}
+{__name__="up",instance="foo.bar",source="health"}`
+	if result != resultExpected {
+		t.Fatalf("unexpected series;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestScrapeWorkBodySizeAndSeriesAdded(t *testing.T) {
+	f := func(honorLabels bool, labelsExpected string) {
+		t.Helper()
+		var sw scrapeWork
+		sw.Config = ScrapeWork{
+			ScrapeURL:   "http://foo.bar/metrics",
+			HonorLabels: honorLabels,
+			Labels: []prompbmarshal.Label{
+				{
+					Name:  "instance",
+					Value: "foo.bar",
+				},
+				{
+					Name:  "job",
+					Value: "xxx",
+				},
+			},
+		}
+		var body string
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			return append(dst, body...), nil
+		}
+		values := make(map[string]string)
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if name == "scrape_body_size_bytes" || name == "scrape_series_added" {
+					values[name] = fmt.Sprintf("%s %g", promLabelsString(ts.Labels), ts.Samples[0].Value)
+				}
+			}
+		}
+		scrape := func(timestamp int64, data string, bodySizeExpected, seriesAddedExpected int) {
+			t.Helper()
+			body = data
+			if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			bodySize := fmt.Sprintf(`{__name__="scrape_body_size_bytes",%s} %d`, labelsExpected, bodySizeExpected)
+			if values["scrape_body_size_bytes"] != bodySize {
+				t.Fatalf("unexpected scrape_body_size_bytes;\ngot\n%s\nwant\n%s", values["scrape_body_size_bytes"], bodySize)
+			}
+			seriesAdded := fmt.Sprintf(`{__name__="scrape_series_added",%s} %d`, labelsExpected, seriesAddedExpected)
+			if values["scrape_series_added"] != seriesAdded {
+				t.Fatalf("unexpected scrape_series_added;\ngot\n%s\nwant\n%s", values["scrape_series_added"], seriesAdded)
+			}
+		}
+		data := `foo{job="a"} 1` + "\n" + `bar 2` + "\n"
+		scrape(1700000000000, data, len(data), 2)
+
+		// Only the new series must be counted during the second scrape.
+		data = `foo{job="a"} 3` + "\n" + `bar 4` + "\n" + `baz{x="y"} 5` + "\n"
+		scrape(1700000010000, data, len(data), 1)
+	}
+	f(false, `instance="foo.bar",job="xxx"`)
+	f(true, `instance="foo.bar",job="xxx"`)
+}
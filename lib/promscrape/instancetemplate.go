@@ -0,0 +1,58 @@
+package promscrape
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasttemplate"
+)
+
+// validateInstanceTemplate verifies `instance_template` option.
+//
+// The template may refer only to `${host}` and `${port}` placeholders and it must refer to at least one of them.
+// Otherwise all the targets would end up with the same `instance` label.
+func validateInstanceTemplate(template string) error {
+	tagsCount := 0
+	_, err := fasttemplate.ExecuteFuncStringWithErr(template, "${", "}", func(w io.Writer, tag string) (int, error) {
+		switch tag {
+		case "host", "port":
+			tagsCount++
+			return 0, nil
+		default:
+			return 0, fmt.Errorf("unsupported placeholder `${%s}`; supported placeholders: `${host}`, `${port}`", tag)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if tagsCount != strings.Count(template, "${") {
+		return fmt.Errorf("missing `}` for placeholder")
+	}
+	if tagsCount == 0 {
+		return fmt.Errorf("the template must contain `${host}` or `${port}` placeholder")
+	}
+	return nil
+}
+
+// getInstanceFromTemplate returns `instance` label value for the given address according to the template validated by validateInstanceTemplate.
+//
+// The address must contain the port, e.g. it must be obtained via addMissingPort.
+// IPv6 hosts are enclosed in brackets, so `${host}:${port}` results in a valid address.
+func getInstanceFromTemplate(template, address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+		port = ""
+	}
+	if strings.IndexByte(host, ':') >= 0 {
+		host = "[" + host + "]"
+	}
+	return fasttemplate.ExecuteFuncString(template, "${", "}", func(w io.Writer, tag string) (int, error) {
+		if tag == "host" {
+			return io.WriteString(w, host)
+		}
+		return io.WriteString(w, port)
+	})
+}
@@ -0,0 +1,96 @@
+package promscrape
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestValidateInstanceTemplate(t *testing.T) {
+	f := func(template string, resultExpected bool) {
+		t.Helper()
+		err := validateInstanceTemplate(template)
+		if result := err == nil; result != resultExpected {
+			t.Fatalf("unexpected validation result for %q; got %v; want %v; err: %v", template, result, resultExpected, err)
+		}
+	}
+	f("${host}:${port}", true)
+	f("${host}", true)
+	f("node-${port}", true)
+	f("host:${port}", true)
+
+	// Missing placeholders
+	f("", false)
+	f("foo", false)
+
+	// Unsupported placeholder
+	f("${host}:${scheme}", false)
+	f("${__address__}", false)
+
+	// Missing closing brace
+	f("${host}:${port", false)
+}
+
+func TestGetInstanceFromTemplate(t *testing.T) {
+	f := func(template, address, resultExpected string) {
+		t.Helper()
+		result := getInstanceFromTemplate(template, address)
+		if result != resultExpected {
+			t.Fatalf("unexpected instance for template=%q, address=%q; got %q; want %q", template, address, result, resultExpected)
+		}
+	}
+	f("${host}:${port}", "foo:9100", "foo:9100")
+	f("${host}", "foo:9100", "foo")
+	f("${host}.example.com:${port}", "foo:80", "foo.example.com:80")
+	f("${host}:${port}", "[::1]:9100", "[::1]:9100")
+	f("${host}", "[fe80::1]:443", "[fe80::1]")
+}
+
+func TestInstanceTemplateForDistinctDiscoveries(t *testing.T) {
+	// The file_sd target has already `instance` label without port, while the static target has no port at all.
+	f, err := ioutil.TempFile("", "instance_template_file_sd_*.yml")
+	if err != nil {
+		t.Fatalf("cannot create temporary file: %s", err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.WriteString(`
+- targets: ["host2:9100"]
+  labels:
+    instance: host2
+`); err != nil {
+		t.Fatalf("cannot write to temporary file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("cannot close temporary file: %s", err)
+	}
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: normalized
+  instance_template: "${host}:${port}"
+  static_configs:
+  - targets: ["host1", "[::1]:9100"]
+  file_sd_configs:
+  - files: [%q]
+`, f.Name())
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := append(cfg.getStaticScrapeWork(), cfg.getFileSDScrapeWork(nil)...)
+	var instances []string
+	for i := range sws {
+		instances = append(instances, promrelabel.GetLabelValueByName(sws[i].Labels, "instance"))
+	}
+	sort.Strings(instances)
+	instancesExpected := []string{"[::1]:9100", "host1:80", "host2:9100"}
+	if !reflect.DeepEqual(instances, instancesExpected) {
+		t.Fatalf("unexpected instance labels;\ngot\n%q\nwant\n%q", instances, instancesExpected)
+	}
+}
@@ -0,0 +1,95 @@
+package promscrape
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KafkaConsumerConfig contains settings for consuming metrics from `kafka://topic` targets.
+//
+// Every message in the topic must contain metrics in Prometheus text exposition format.
+// Every message is processed as if it has been scraped from the target.
+type KafkaConsumerConfig struct {
+	Brokers []string `yaml:"brokers"`
+	// GroupID is the consumer group, which is used for distributing topic partitions among consumers and for committing offsets.
+	// defaultKafkaGroupID is used if it isn't set.
+	GroupID  string `yaml:"group_id,omitempty"`
+	ClientID string `yaml:"client_id,omitempty"`
+	// InitialOffset is the offset to start consuming partitions without committed offsets from. Supported values: newest and oldest.
+	// Partitions are consumed from the newest offset if it isn't set.
+	InitialOffset string `yaml:"initial_offset,omitempty"`
+}
+
+const (
+	defaultKafkaGroupID  = "vmagent"
+	defaultKafkaClientID = "vmagent"
+)
+
+// String returns string representation for kcc, which is used in ScrapeWork.key.
+func (kcc *KafkaConsumerConfig) String() string {
+	if kcc == nil {
+		return ""
+	}
+	return fmt.Sprintf("brokers=%s, group_id=%s, client_id=%s, initial_offset=%s", kcc.Brokers, kcc.GroupID, kcc.ClientID, kcc.InitialOffset)
+}
+
+func (kcc *KafkaConsumerConfig) validate() error {
+	if len(kcc.Brokers) == 0 {
+		return fmt.Errorf("missing `brokers`")
+	}
+	for _, broker := range kcc.Brokers {
+		if !strings.Contains(broker, ":") {
+			return fmt.Errorf("missing port in broker address %q", broker)
+		}
+	}
+	switch kcc.InitialOffset {
+	case "", "newest", "oldest":
+	default:
+		return fmt.Errorf("unsupported `initial_offset`: %q; supported values: newest, oldest", kcc.InitialOffset)
+	}
+	return nil
+}
+
+func (kcc *KafkaConsumerConfig) getGroupID() string {
+	if kcc.GroupID == "" {
+		return defaultKafkaGroupID
+	}
+	return kcc.GroupID
+}
+
+func (kcc *KafkaConsumerConfig) getClientID() string {
+	if kcc.ClientID == "" {
+		return defaultKafkaClientID
+	}
+	return kcc.ClientID
+}
+
+// isKafkaTarget returns true if address refers to Kafka topic.
+func isKafkaTarget(address string) bool {
+	return strings.HasPrefix(address, "kafka://")
+}
+
+// validateKafkaTopic verifies topic name from `kafka://topic` address.
+//
+// See https://kafka.apache.org/documentation/#topicconfigs for the allowed topic names.
+func validateKafkaTopic(topic string) error {
+	if len(topic) == 0 {
+		return fmt.Errorf("missing topic name")
+	}
+	if len(topic) > 249 {
+		return fmt.Errorf("too long topic name; it cannot exceed 249 chars")
+	}
+	for i := 0; i < len(topic); i++ {
+		c := topic[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '.' || c == '_' || c == '-' {
+			continue
+		}
+		return fmt.Errorf("invalid char %q in topic name %q; only ASCII alphanumerics, '.', '_' and '-' are allowed", c, topic)
+	}
+	return nil
+}
+
+// runKafkaConsumer consumes messages from the topic of `kafka://topic` sw.Config.ScrapeURL until stopCh is closed.
+//
+// It is set only when building with `kafka` build tag in order to keep Kafka client dependencies out of the default build.
+var runKafkaConsumer func(sw *scrapeWork, stopCh <-chan struct{})
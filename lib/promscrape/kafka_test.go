@@ -0,0 +1,114 @@
+package promscrape
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKafkaConsumerConfigValidate(t *testing.T) {
+	f := func(kcc *KafkaConsumerConfig, errExpected string) {
+		t.Helper()
+		err := kcc.validate()
+		if errExpected == "" {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			return
+		}
+		if err == nil {
+			t.Fatalf("expecting non-nil error containing %q", errExpected)
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want it to contain %q", err, errExpected)
+		}
+	}
+
+	// Valid configs
+	f(&KafkaConsumerConfig{
+		Brokers: []string{"kafka:9092"},
+	}, "")
+	f(&KafkaConsumerConfig{
+		Brokers:       []string{"kafka1:9092", "kafka2:9093"},
+		GroupID:       "foo",
+		ClientID:      "bar",
+		InitialOffset: "oldest",
+	}, "")
+	f(&KafkaConsumerConfig{
+		Brokers:       []string{"kafka:9092"},
+		InitialOffset: "newest",
+	}, "")
+
+	// Missing brokers
+	f(&KafkaConsumerConfig{}, "missing `brokers`")
+
+	// Missing port in broker address
+	f(&KafkaConsumerConfig{
+		Brokers: []string{"kafka:9092", "kafka"},
+	}, `missing port in broker address "kafka"`)
+
+	// Unsupported initial_offset
+	f(&KafkaConsumerConfig{
+		Brokers:       []string{"kafka:9092"},
+		InitialOffset: "latest",
+	}, "unsupported `initial_offset`")
+}
+
+func TestKafkaConsumerConfigDefaults(t *testing.T) {
+	var kcc KafkaConsumerConfig
+	if groupID := kcc.getGroupID(); groupID != defaultKafkaGroupID {
+		t.Fatalf("unexpected group_id; got %q; want %q", groupID, defaultKafkaGroupID)
+	}
+	if clientID := kcc.getClientID(); clientID != defaultKafkaClientID {
+		t.Fatalf("unexpected client_id; got %q; want %q", clientID, defaultKafkaClientID)
+	}
+	kcc.GroupID = "foo"
+	kcc.ClientID = "bar"
+	if groupID := kcc.getGroupID(); groupID != "foo" {
+		t.Fatalf("unexpected group_id; got %q; want %q", groupID, "foo")
+	}
+	if clientID := kcc.getClientID(); clientID != "bar" {
+		t.Fatalf("unexpected client_id; got %q; want %q", clientID, "bar")
+	}
+}
+
+func TestValidateKafkaTopic(t *testing.T) {
+	f := func(topic string, isValid bool) {
+		t.Helper()
+		err := validateKafkaTopic(topic)
+		if isValid && err != nil {
+			t.Fatalf("unexpected error for topic %q: %s", topic, err)
+		}
+		if !isValid && err == nil {
+			t.Fatalf("expecting non-nil error for topic %q", topic)
+		}
+	}
+	f("metrics", true)
+	f("foo.bar_baz-1", true)
+	f(strings.Repeat("a", 249), true)
+
+	f("", false)
+	f(strings.Repeat("a", 250), false)
+	f("foo/bar", false)
+	f("foo bar", false)
+	f("метрики", false)
+}
+
+func TestConfigKafkaConsumerValidation(t *testing.T) {
+	// Invalid kafka_consumer must be rejected regardless of the `kafka` build tag.
+	data := `
+scrape_configs:
+- job_name: x
+  kafka_consumer:
+    brokers: ["kafka"]
+  static_configs:
+  - targets: ["kafka://metrics"]
+`
+	var cfg Config
+	err := cfg.parse([]byte(data), "sss")
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if !strings.Contains(err.Error(), "invalid `kafka_consumer`") {
+		t.Fatalf("unexpected error; got %q; want it to contain %q", err, "invalid `kafka_consumer`")
+	}
+}
@@ -0,0 +1,77 @@
+package kafkaconsumer
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// defaultMaxDecompressedSize is the default limit on the decompressed size of a single record batch.
+const defaultMaxDecompressedSize = 16 * 1024 * 1024
+
+var maxDecompressedSize = int64(defaultMaxDecompressedSize)
+
+// SetMaxDecompressedSize sets the limit on the decompressed size of a single record batch received from Kafka brokers.
+//
+// The limit is shared among all the consumers, since compression codecs are global in github.com/segmentio/kafka-go.
+// Non-positive n is ignored.
+func SetMaxDecompressedSize(n int) {
+	if n > 0 {
+		atomic.StoreInt64(&maxDecompressedSize, int64(n))
+	}
+}
+
+func init() {
+	// Wrap all the compression codecs, so an attacker cannot exhaust memory with a small record batch,
+	// which decompresses to huge amounts of data.
+	for i, codec := range compress.Codecs {
+		if codec != nil {
+			compress.Codecs[i] = &limitedCodec{
+				Codec: codec,
+			}
+		}
+	}
+}
+
+// limitedCodec limits the size of data decompressed by Codec to maxDecompressedSize.
+type limitedCodec struct {
+	compress.Codec
+}
+
+func (lc *limitedCodec) NewReader(r io.Reader) io.ReadCloser {
+	return &limitedReader{
+		rc:        lc.Codec.NewReader(r),
+		remaining: atomic.LoadInt64(&maxDecompressedSize),
+	}
+}
+
+type limitedReader struct {
+	rc        io.ReadCloser
+	remaining int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, lr.tooBigError()
+	}
+	// Read up to a byte above the limit in order to detect whether the limit is exceeded.
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.rc.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return 0, lr.tooBigError()
+	}
+	return n, err
+}
+
+func (lr *limitedReader) tooBigError() error {
+	return fmt.Errorf("the decompressed record batch exceeds %d bytes; increase -promscrape.maxScrapeSize if needed", atomic.LoadInt64(&maxDecompressedSize))
+}
+
+func (lr *limitedReader) Close() error {
+	return lr.rc.Close()
+}
@@ -0,0 +1,56 @@
+package kafkaconsumer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/segmentio/kafka-go/compress"
+)
+
+func TestLimitedCodec(t *testing.T) {
+	defer SetMaxDecompressedSize(defaultMaxDecompressedSize)
+
+	data := bytes.Repeat([]byte("foo 1\n"), 1000)
+	f := func(c compress.Compression, maxSize int, resultExpected []byte) {
+		t.Helper()
+		codec := c.Codec()
+		if _, ok := codec.(*limitedCodec); !ok {
+			t.Fatalf("codec %s isn't wrapped into limitedCodec", c)
+		}
+		var bb bytes.Buffer
+		w := codec.NewWriter(&bb)
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("cannot compress data with %s: %s", c, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("cannot close %s writer: %s", c, err)
+		}
+
+		SetMaxDecompressedSize(maxSize)
+		r := codec.NewReader(&bb)
+		result, err := ioutil.ReadAll(r)
+		_ = r.Close()
+		if resultExpected == nil {
+			if err == nil {
+				t.Fatalf("expecting non-nil error when decompressing %d bytes with %s and limit %d", len(data), c, maxSize)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error when decompressing data with %s: %s", c, err)
+		}
+		if !bytes.Equal(result, resultExpected) {
+			t.Fatalf("unexpected data decompressed with %s; got %d bytes; want %d bytes", c, len(result), len(resultExpected))
+		}
+	}
+	for _, c := range []compress.Compression{compress.Gzip, compress.Snappy, compress.Lz4, compress.Zstd} {
+		// The limit isn't exceeded
+		f(c, len(data), data)
+		f(c, 2*len(data), data)
+
+		// The limit is exceeded
+		f(c, len(data)-1, nil)
+		f(c, 100, nil)
+	}
+}
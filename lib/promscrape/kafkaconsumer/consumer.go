@@ -0,0 +1,152 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Config contains settings for Consumer.
+type Config struct {
+	// Brokers contains Kafka broker addresses in the form `host:port`.
+	Brokers []string
+
+	// Topic is the topic to consume messages from.
+	Topic string
+
+	// GroupID is the consumer group, which is used for distributing topic partitions among consumers and for committing offsets.
+	GroupID string
+
+	// ClientID is sent to Kafka brokers in every request.
+	ClientID string
+
+	// InitialOffset is the offset to start consuming partitions without committed offsets from. Supported values: newest and oldest.
+	// Partitions are consumed from the newest offset if it isn't set.
+	InitialOffset string
+
+	// DialContext is used for connecting to Kafka brokers. The default dialer is used if it is nil.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// MaxFetchSize is the maximum size of a single fetch response.
+	//
+	// It also limits the decompressed size of every record batch received from Kafka brokers. See SetMaxDecompressedSize.
+	MaxFetchSize int
+}
+
+// Message is a message consumed from Kafka topic.
+type Message struct {
+	Value []byte
+
+	// Timestamp is the message timestamp in milliseconds. It is zero if the message has no timestamp.
+	Timestamp int64
+
+	m kafka.Message
+}
+
+// Consumer consumes messages from all the partitions of a topic assigned to it by the consumer group.
+//
+// Partitions are assigned to consumers according to range assignment strategy.
+// Offsets for the messages passed to CommitMessage are committed to the group coordinator every second and when the Consumer is closed.
+type Consumer struct {
+	r *kafka.Reader
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+const (
+	kafkaDialTimeout      = 10 * time.Second
+	kafkaCommitInterval   = time.Second
+	kafkaMaxWait          = 500 * time.Millisecond
+	kafkaSessionTimeout   = 30 * time.Second
+	kafkaRebalanceTimeout = 15 * time.Second
+)
+
+// NewConsumer returns new Consumer for the given cfg.
+//
+// The Consumer must be closed with Close when it is no longer needed.
+func NewConsumer(cfg *Config) *Consumer {
+	SetMaxDecompressedSize(cfg.MaxFetchSize)
+	startOffset := kafka.LastOffset
+	if cfg.InitialOffset == "oldest" {
+		startOffset = kafka.FirstOffset
+	}
+	c := &Consumer{}
+	c.r = kafka.NewReader(kafka.ReaderConfig{
+		Brokers: cfg.Brokers,
+		GroupID: cfg.GroupID,
+		Topic:   cfg.Topic,
+		Dialer: &kafka.Dialer{
+			ClientID: cfg.ClientID,
+			Timeout:  kafkaDialTimeout,
+			DialFunc: cfg.DialContext,
+		},
+		MinBytes:         1,
+		MaxBytes:         cfg.MaxFetchSize,
+		MaxWait:          kafkaMaxWait,
+		GroupBalancers:   []kafka.GroupBalancer{kafka.RangeGroupBalancer{}},
+		CommitInterval:   kafkaCommitInterval,
+		SessionTimeout:   kafkaSessionTimeout,
+		RebalanceTimeout: kafkaRebalanceTimeout,
+		StartOffset:      startOffset,
+		ErrorLogger:      kafka.LoggerFunc(c.setLastError),
+	})
+	return c
+}
+
+// FetchMessage returns the next message from the topic.
+//
+// It blocks until the message is available or ctx is done. Errors, which occur when consuming messages,
+// are retried under the hood. They can be obtained via LastError.
+//
+// CommitMessage must be called after the message is processed.
+func (c *Consumer) FetchMessage(ctx context.Context) (*Message, error) {
+	m, err := c.r.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var timestamp int64
+	if !m.Time.IsZero() {
+		timestamp = m.Time.UnixNano() / 1e6
+	}
+	return &Message{
+		Value:     m.Value,
+		Timestamp: timestamp,
+		m:         m,
+	}, nil
+}
+
+// CommitMessage marks msg obtained via FetchMessage as processed, so consuming is resumed after msg on the next start.
+func (c *Consumer) CommitMessage(ctx context.Context, msg *Message) error {
+	return c.r.CommitMessages(ctx, msg.m)
+}
+
+// LastError returns the last error occurred when consuming messages since the previous LastError call.
+func (c *Consumer) LastError() error {
+	c.mu.Lock()
+	err := c.lastErr
+	c.lastErr = nil
+	c.mu.Unlock()
+	return err
+}
+
+func (c *Consumer) setLastError(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	c.mu.Lock()
+	c.lastErr = err
+	c.mu.Unlock()
+}
+
+// Rebalances returns the number of consumer group rebalances since the previous Rebalances call.
+func (c *Consumer) Rebalances() int64 {
+	return c.r.Stats().Rebalances
+}
+
+// Close commits offsets for the consumed messages, leaves the consumer group and closes connections to Kafka brokers.
+func (c *Consumer) Close() error {
+	return c.r.Close()
+}
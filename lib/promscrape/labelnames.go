@@ -0,0 +1,78 @@
+package promscrape
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// compileLabelNamesRegexp compiles expr from `keep_label_names` or `drop_label_names` option.
+//
+// The regexp is anchored to both ends of label name like `regex` in relabeling rules. nil is returned for empty expr.
+func compileLabelNamesRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse regexp %q: %w", expr, err)
+	}
+	return re, nil
+}
+
+func regexpString(re *regexp.Regexp) string {
+	if re == nil {
+		return ""
+	}
+	return re.String()
+}
+
+// maxLabelNamesCacheSize is the maximum number of entries in scrapeWork.labelNamesCache.
+//
+// The cache is reset when it exceeds this size, so it cannot grow indefinitely for targets with high number of unique label names.
+const maxLabelNamesCacheSize = 10000
+
+// filterLabelNames removes labels[labelsLen:] with names not matching Config.KeepLabelNames or matching Config.DropLabelNames.
+//
+// `__name__` label is always kept.
+func (sw *scrapeWork) filterLabelNames(labels []prompbmarshal.Label, labelsLen int) []prompbmarshal.Label {
+	if sw.Config.KeepLabelNames == nil && sw.Config.DropLabelNames == nil {
+		return labels
+	}
+	dst := labels[:labelsLen]
+	for _, label := range labels[labelsLen:] {
+		if label.Name == "__name__" || sw.needKeepLabelName(label.Name) {
+			dst = append(dst, label)
+			continue
+		}
+		droppedLabels.Inc()
+	}
+	return dst
+}
+
+// needKeepLabelName returns true if the label with the given name must be kept according to Config.KeepLabelNames and Config.DropLabelNames.
+//
+// The result is cached, since the same label names are repeated across the scraped series.
+func (sw *scrapeWork) needKeepLabelName(name string) bool {
+	if keep, ok := sw.labelNamesCache[name]; ok {
+		return keep
+	}
+	keep := true
+	if re := sw.Config.KeepLabelNames; re != nil && !re.MatchString(name) {
+		keep = false
+	}
+	if re := sw.Config.DropLabelNames; keep && re != nil && re.MatchString(name) {
+		keep = false
+	}
+	if sw.labelNamesCache == nil || len(sw.labelNamesCache) >= maxLabelNamesCacheSize {
+		sw.labelNamesCache = make(map[string]bool)
+	}
+	// Intern the name, since it may refer to the scraped response body, which is re-used by the next scrape.
+	sw.labelNamesCache[bytesutil.InternString(name)] = keep
+	return keep
+}
+
+var droppedLabels = metrics.NewCounter(`vm_promscrape_dropped_labels_total`)
@@ -0,0 +1,76 @@
+package promscrape
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkFilterLabelNames(t *testing.T) {
+	f := func(keepLabelNames, dropLabelNames, resultExpected string, droppedExpected uint64) {
+		t.Helper()
+		keepRe, err := compileLabelNamesRegexp(keepLabelNames)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		dropRe, err := compileLabelNamesRegexp(dropLabelNames)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var sw scrapeWork
+		sw.Config = ScrapeWork{
+			ScrapeURL: "http://foo.bar/metrics",
+			Labels: []prompbmarshal.Label{{
+				Name:  "instance",
+				Value: "foo.bar",
+			}},
+			KeepLabelNames: keepRe,
+			DropLabelNames: dropRe,
+		}
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			return append(dst, "foo{a=\"1\",b=\"2\",c=\"3\"} 1\nbar{a=\"4\",cc=\"5\"} 2\n"...), nil
+		}
+		var series []string
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if strings.HasPrefix(name, "scrape_") || name == "up" {
+					continue
+				}
+				series = append(series, promLabelsString(ts.Labels))
+			}
+		}
+		droppedStart := droppedLabels.Get()
+		timestamp := int64(1700000000000)
+		if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sort.Strings(series)
+		result := strings.Join(series, "\n")
+		if result != resultExpected {
+			t.Fatalf("unexpected series for keep_label_names=%q, drop_label_names=%q;\ngot\n%s\nwant\n%s", keepLabelNames, dropLabelNames, result, resultExpected)
+		}
+		if n := droppedLabels.Get() - droppedStart; n != droppedExpected {
+			t.Fatalf("unexpected number of dropped labels; got %d; want %d", n, droppedExpected)
+		}
+	}
+
+	// All the labels are kept by default.
+	f("", "", `{__name__="bar",a="4",cc="5",instance="foo.bar"}
+{__name__="foo",a="1",b="2",c="3",instance="foo.bar"}`, 0)
+
+	// Allowlist keeps only the given labels. Regexp must match the whole label name.
+	f("a|c", "", `{__name__="bar",a="4"}
+{__name__="foo",a="1",c="3"}`, 4)
+
+	// Denylist drops only the given labels.
+	f("", "c", `{__name__="bar",a="4",cc="5",instance="foo.bar"}
+{__name__="foo",a="1",b="2",instance="foo.bar"}`, 1)
+
+	// Denylist is applied after allowlist.
+	f("a|c.*", "cc", `{__name__="bar",a="4"}
+{__name__="foo",a="1",c="3"}`, 4)
+}
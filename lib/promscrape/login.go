@@ -0,0 +1,211 @@
+package promscrape
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// LoginConfig contains settings for obtaining session cookie from `url` before scraping the target.
+//
+// The obtained cookie is sent with scrape requests until it expires or until the target responds with `401 Unauthorized`.
+type LoginConfig struct {
+	// URL may be either absolute url or a path such as `/login`, which is resolved against the scrape url.
+	URL string `yaml:"url"`
+
+	// Method is the http method for login requests. Supported values: GET and POST. POST is used by default.
+	Method      string `yaml:"method,omitempty"`
+	Body        string `yaml:"body,omitempty"`
+	ContentType string `yaml:"content_type,omitempty"`
+
+	// CookieName is the name of session cookie to extract from `Set-Cookie` headers of login response.
+	// All the cookies from the login response are sent with scrape requests if CookieName isn't set.
+	CookieName string `yaml:"cookie_name,omitempty"`
+}
+
+// String returns string representation for lc, which is used in ScrapeWork.key.
+//
+// The body is included, so the change of credentials results in restarting the affected scrapers.
+func (lc *LoginConfig) String() string {
+	if lc == nil {
+		return ""
+	}
+	return fmt.Sprintf("url=%s, method=%s, body=%q, content_type=%s, cookie_name=%s", lc.URL, lc.Method, lc.Body, lc.ContentType, lc.CookieName)
+}
+
+func (lc *LoginConfig) validate() error {
+	if lc.URL == "" {
+		return fmt.Errorf("missing `url`")
+	}
+	if !strings.HasPrefix(lc.URL, "/") {
+		u, err := url.Parse(lc.URL)
+		if err != nil {
+			return fmt.Errorf("cannot parse `url` %q: %w", lc.URL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("`url` %q must be either a path starting with `/` or an absolute http or https url", lc.URL)
+		}
+	}
+	switch lc.Method {
+	case "", "GET", "POST":
+	default:
+		return fmt.Errorf("unsupported `method` %q; supported values: GET, POST", lc.Method)
+	}
+	return nil
+}
+
+// sessionLogin obtains and caches session cookie according to LoginConfig.
+type sessionLogin struct {
+	loginURL    string
+	method      string
+	body        string
+	contentType string
+	cookieName  string
+	authHeader  string
+	hc          *http.Client
+
+	mu       sync.Mutex
+	cookie   string
+	deadline time.Time
+}
+
+func newSessionLogin(lc *LoginConfig, scrapeURL, authHeader string, transport *http.Transport, timeout time.Duration) *sessionLogin {
+	loginURL := lc.URL
+	if strings.HasPrefix(loginURL, "/") {
+		if u, err := url.Parse(scrapeURL); err == nil {
+			loginURL = u.Scheme + "://" + u.Host + loginURL
+		}
+	}
+	method := lc.Method
+	if method == "" {
+		method = "POST"
+	}
+	return &sessionLogin{
+		loginURL:    loginURL,
+		method:      method,
+		body:        lc.Body,
+		contentType: lc.ContentType,
+		cookieName:  lc.CookieName,
+		authHeader:  authHeader,
+		hc: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			// Session cookies are frequently set in redirect responses, so redirects aren't followed.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// getCookie returns the cached session cookie or obtains a new one if the cached cookie is missing or expired.
+func (sl *sessionLogin) getCookie() (string, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.cookie != "" && (sl.deadline.IsZero() || time.Now().Before(sl.deadline)) {
+		return sl.cookie, nil
+	}
+	cookie, deadline, err := sl.login()
+	if err != nil {
+		loginErrors.Inc()
+		return "", err
+	}
+	sl.cookie = cookie
+	sl.deadline = deadline
+	return cookie, nil
+}
+
+// resetCookie drops the cached session cookie, so the next getCookie call performs login.
+//
+// It must be called when the target rejects the cookie with `401 Unauthorized`.
+func (sl *sessionLogin) resetCookie() {
+	sl.mu.Lock()
+	sl.cookie = ""
+	sl.deadline = time.Time{}
+	sl.mu.Unlock()
+}
+
+func (sl *sessionLogin) login() (string, time.Time, error) {
+	loginRequests.Inc()
+	var body io.Reader
+	if sl.method == "POST" {
+		body = strings.NewReader(sl.body)
+	}
+	req, err := http.NewRequest(sl.method, sl.loginURL, body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("cannot create login request for %q: %w", sl.loginURL, err)
+	}
+	if sl.contentType != "" {
+		req.Header.Set("Content-Type", sl.contentType)
+	}
+	if sl.authHeader != "" {
+		req.Header.Set("Authorization", sl.authHeader)
+	}
+	resp, err := sl.hc.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("cannot perform login request to %q: %w", sl.loginURL, err)
+	}
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return "", time.Time{}, fmt.Errorf("unexpected status code returned from login url %q: %d; response body: %q", sl.loginURL, resp.StatusCode, respBody)
+	}
+	var cookies []string
+	var deadline time.Time
+	for _, c := range resp.Cookies() {
+		if sl.cookieName != "" && c.Name != sl.cookieName {
+			continue
+		}
+		cookies = append(cookies, c.Name+"="+c.Value)
+		expires := c.Expires
+		if c.MaxAge > 0 {
+			expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		if !expires.IsZero() && (deadline.IsZero() || expires.Before(deadline)) {
+			deadline = expires
+		}
+	}
+	if len(cookies) == 0 {
+		if sl.cookieName != "" {
+			return "", time.Time{}, fmt.Errorf("missing %q cookie in the response from login url %q", sl.cookieName, sl.loginURL)
+		}
+		return "", time.Time{}, fmt.Errorf("missing cookies in the response from login url %q", sl.loginURL)
+	}
+	return strings.Join(cookies, "; "), deadline, nil
+}
+
+// getLoginCookie returns session cookie for scraping scrapeURL. Empty string is returned if `login` isn't configured for the target.
+func (c *client) getLoginCookie(scrapeURL string) (string, error) {
+	if c.login == nil {
+		return "", nil
+	}
+	cookie, err := c.login.getCookie()
+	if err != nil {
+		return "", fmt.Errorf("cannot obtain session cookie for scraping %q: %w", scrapeURL, err)
+	}
+	return cookie, nil
+}
+
+// needRelogin returns true if the scrape, which finished with the given statusCode, must be repeated with a new session cookie.
+//
+// The login is repeated at most once per scrape after `401 Unauthorized` response. relogin is set to true in this case.
+func (c *client) needRelogin(statusCode int, relogin *bool) bool {
+	if c.login == nil || statusCode != http.StatusUnauthorized || *relogin {
+		return false
+	}
+	*relogin = true
+	c.login.resetCookie()
+	return true
+}
+
+var (
+	loginRequests = metrics.NewCounter(`vm_promscrape_login_requests_total`)
+	loginErrors   = metrics.NewCounter(`vm_promscrape_login_errors_total`)
+)
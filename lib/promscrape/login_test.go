@@ -0,0 +1,122 @@
+package promscrape
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+)
+
+func TestScrapeWorkLogin(t *testing.T) {
+	var logins, sessionID uint64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != "POST" || string(body) != "user=foo&password=bar" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+				http.Error(w, "invalid credentials", http.StatusForbidden)
+				return
+			}
+			atomic.AddUint64(&logins, 1)
+			http.SetCookie(w, &http.Cookie{
+				Name:  "theme",
+				Value: "dark",
+			})
+			http.SetCookie(w, &http.Cookie{
+				Name:  "session",
+				Value: fmt.Sprintf("s%d", atomic.LoadUint64(&sessionID)),
+			})
+		case "/metrics":
+			cookie, err := r.Cookie("session")
+			if err != nil || cookie.Value != fmt.Sprintf("s%d", atomic.LoadUint64(&sessionID)) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, "foo 1\n")
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	target := s.Listener.Addr().String()
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool) {
+		t.Helper()
+		atomic.StoreUint64(&logins, 0)
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: login
+  stream_parse: %v
+  login:
+    url: /login
+    body: user=foo&password=bar
+    content_type: application/x-www-form-urlencoded
+    cookie_name: session
+  static_configs:
+  - targets: [%q]
+`, streamParse, target)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		var up float64
+		var tss []string
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if name == "up" {
+					up = ts.Samples[0].Value
+				}
+				if !strings.HasPrefix(name, "scrape_") && name != "up" {
+					tss = append(tss, name)
+				}
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		scrape := func(loginsExpected uint64) {
+			t.Helper()
+			up = -1
+			tss = tss[:0]
+			timestamp := int64(123000)
+			if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if up != 1 {
+				t.Fatalf("unexpected up value; got %v; want 1", up)
+			}
+			if len(tss) != 1 || tss[0] != "foo" {
+				t.Fatalf("unexpected scraped series; got %q; want [foo]", tss)
+			}
+			if n := atomic.LoadUint64(&logins); n != loginsExpected {
+				t.Fatalf("unexpected number of logins for stream_parse=%v; got %d; want %d", streamParse, n, loginsExpected)
+			}
+		}
+
+		// The login is performed before the first scrape, while the session cookie is re-used by the next scrapes.
+		scrape(1)
+		scrape(1)
+		scrape(1)
+
+		// The login is repeated when the target rejects the session cookie with 401.
+		atomic.AddUint64(&sessionID, 1)
+		scrape(2)
+		scrape(2)
+	}
+	f(false)
+	f(true)
+}
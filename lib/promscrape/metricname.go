@@ -0,0 +1,116 @@
+package promscrape
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Supported values for `metric_name_validation` option in `scrape_config`.
+const (
+	// metricNameValidationLegacy allows only metric names matching `[a-zA-Z_:][a-zA-Z0-9_:]*` regexp.
+	metricNameValidationLegacy = "legacy"
+
+	// metricNameValidationUTF8 allows arbitrary metric names. This is the default.
+	metricNameValidationUTF8 = "utf8"
+)
+
+// Supported values for `metric_name_validation_action` option in `scrape_config`.
+const (
+	// metricNameActionDrop drops series with invalid metric names. This is the default.
+	metricNameActionDrop = "drop"
+
+	// metricNameActionSanitize replaces invalid chars in metric names with underscores.
+	metricNameActionSanitize = "sanitize"
+)
+
+// validateMetricNameValidation verifies `metric_name_validation` mode and `metric_name_validation_action` action.
+func validateMetricNameValidation(mode, action string) error {
+	switch mode {
+	case "", metricNameValidationUTF8:
+		if action != "" {
+			return fmt.Errorf("`metric_name_validation_action` can be set only for `metric_name_validation: %s`", metricNameValidationLegacy)
+		}
+		return nil
+	case metricNameValidationLegacy:
+		switch action {
+		case "", metricNameActionDrop, metricNameActionSanitize:
+			return nil
+		default:
+			return fmt.Errorf("unsupported `metric_name_validation_action` %q; supported values: %q, %q", action, metricNameActionDrop, metricNameActionSanitize)
+		}
+	default:
+		return fmt.Errorf("unsupported `metric_name_validation` %q; supported values: %q, %q", mode, metricNameValidationLegacy, metricNameValidationUTF8)
+	}
+}
+
+// validateMetricName verifies metric name in labels according to Config.MetricNameValidation.
+//
+// It returns false if the series with the given labels must be dropped.
+// The metric name in labels is sanitized if Config.MetricNameAction is set to sanitize.
+func (sw *scrapeWork) validateMetricName(labels []prompbmarshal.Label) bool {
+	if sw.Config.MetricNameValidation != metricNameValidationLegacy {
+		return true
+	}
+	for i := range labels {
+		label := &labels[i]
+		if label.Name != "__name__" {
+			continue
+		}
+		if isValidLegacyMetricName(label.Value) {
+			return true
+		}
+		if sw.Config.MetricNameAction == metricNameActionSanitize {
+			label.Value = sanitizeLegacyMetricName(label.Value)
+			invalidMetricNamesSanitized.Inc()
+			return true
+		}
+		invalidMetricNamesDropped.Inc()
+		return false
+	}
+	return true
+}
+
+// isValidLegacyMetricName returns true if s matches `[a-zA-Z_:][a-zA-Z0-9_:]*` regexp.
+func isValidLegacyMetricName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isLegacyMetricNameChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeLegacyMetricName replaces chars, which are illegal in legacy Prometheus metric names, with underscores.
+//
+// Metric names starting with a digit are prefixed with an underscore.
+func sanitizeLegacyMetricName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && isLegacyMetricNameChar(byte(r), false) {
+			return r
+		}
+		return '_'
+	}, s)
+	if len(s) == 0 || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+func isLegacyMetricNameChar(c byte, isFirst bool) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' {
+		return true
+	}
+	return !isFirst && c >= '0' && c <= '9'
+}
+
+var (
+	invalidMetricNamesDropped   = metrics.NewCounter(`vm_promscrape_invalid_metric_names_dropped_total`)
+	invalidMetricNamesSanitized = metrics.NewCounter(`vm_promscrape_invalid_metric_names_sanitized_total`)
+)
@@ -0,0 +1,82 @@
+package promscrape
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkMetricNameValidation(t *testing.T) {
+	f := func(mode, action, resultExpected string) {
+		t.Helper()
+		var sw scrapeWork
+		sw.Config = ScrapeWork{
+			ScrapeURL:            "http://foo.bar/metrics",
+			MetricNameValidation: mode,
+			MetricNameAction:     action,
+		}
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			return append(dst, "foo.bar 1\nfoo_baz 2\n"...), nil
+		}
+		var samples []string
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if strings.HasPrefix(name, "scrape_") || name == "up" {
+					continue
+				}
+				samples = append(samples, fmt.Sprintf("%s %v", name, ts.Samples[0].Value))
+			}
+		}
+		timestamp := int64(1700000000000)
+		if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sort.Strings(samples)
+		result := strings.Join(samples, "\n")
+		if result != resultExpected {
+			t.Fatalf("unexpected samples for metric_name_validation=%q, action=%q;\ngot\n%s\nwant\n%s", mode, action, result, resultExpected)
+		}
+	}
+
+	// Metric names are passed as is by default and in utf8 mode.
+	f("", "", "foo.bar 1\nfoo_baz 2")
+	f("utf8", "", "foo.bar 1\nfoo_baz 2")
+
+	// Series with invalid metric names are dropped in legacy mode by default.
+	droppedStart := invalidMetricNamesDropped.Get()
+	f("legacy", "", "foo_baz 2")
+	f("legacy", "drop", "foo_baz 2")
+	if n := invalidMetricNamesDropped.Get() - droppedStart; n != 2 {
+		t.Fatalf("unexpected number of dropped series; got %d; want 2", n)
+	}
+
+	// Invalid metric names are sanitized.
+	sanitizedStart := invalidMetricNamesSanitized.Get()
+	f("legacy", "sanitize", "foo_bar 1\nfoo_baz 2")
+	if n := invalidMetricNamesSanitized.Get() - sanitizedStart; n != 1 {
+		t.Fatalf("unexpected number of sanitized series; got %d; want 1", n)
+	}
+}
+
+func TestSanitizeLegacyMetricName(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+		result := sanitizeLegacyMetricName(s)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for sanitizeLegacyMetricName(%q); got %q; want %q", s, result, resultExpected)
+		}
+		if !isValidLegacyMetricName(result) {
+			t.Fatalf("sanitizeLegacyMetricName(%q) returned invalid metric name %q", s, result)
+		}
+	}
+	f("", "_")
+	f("foo.bar", "foo_bar")
+	f("foo:bar_1", "foo:bar_1")
+	f("1foo", "_1foo")
+	f("foo-bär", "foo_b_r")
+}
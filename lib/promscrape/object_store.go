@@ -0,0 +1,292 @@
+package promscrape
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/awsapi"
+)
+
+var objectStoreListInterval = flag.Duration("promscrape.objectStoreListInterval", time.Minute, "The interval for listing objects under the prefix of `s3://bucket/prefix` targets "+
+	"in order to find the newest object. The key of the newest object is cached between scrapes during this interval. "+
+	"Zero value disables caching, so objects are listed on every scrape")
+
+// ObjectStoreConfig contains settings for scraping `s3://bucket/prefix` targets from S3-compatible object storage.
+//
+// The newest object under the prefix is scraped. Gzip-compressed objects are decompressed automatically.
+type ObjectStoreConfig struct {
+	Region string `yaml:"region"`
+	// Endpoint is an optional S3-compatible endpoint such as `https://storage.googleapis.com` for GCS.
+	// Objects are accessed with path-style urls if it is set.
+	Endpoint  string `yaml:"endpoint,omitempty"`
+	AccessKey string `yaml:"access_key,omitempty"`
+	SecretKey string `yaml:"secret_key,omitempty"`
+	RoleARN   string `yaml:"role_arn,omitempty"`
+}
+
+// String returns string representation for osc, which is used in ScrapeWork.key.
+//
+// The secret key is included, so its change results in restarting the affected scrapers.
+func (osc *ObjectStoreConfig) String() string {
+	if osc == nil {
+		return ""
+	}
+	return fmt.Sprintf("region=%s, endpoint=%s, access_key=%s, secret_key=%s, role_arn=%s", osc.Region, osc.Endpoint, osc.AccessKey, osc.SecretKey, osc.RoleARN)
+}
+
+// isObjectStoreTarget returns true if address refers to the object storage.
+func isObjectStoreTarget(address string) bool {
+	return strings.HasPrefix(address, "s3://")
+}
+
+// emptyPayloadHash is sha256 hash for empty request body, which must be passed to S3 in `X-Amz-Content-Sha256` header.
+var emptyPayloadHash = func() string {
+	h := sha256.Sum256(nil)
+	return hex.EncodeToString(h[:])
+}()
+
+// objectStoreClient reads the newest object under the prefix of `s3://bucket/prefix` scrape url.
+type objectStoreClient struct {
+	scrapeURL string
+	bucketURL string
+	prefix    string
+	awsCfg    *awsapi.Config
+	hc        *http.Client
+
+	// initErr is the error from awsapi.NewConfig. It is returned on every scrape if it is set.
+	initErr error
+
+	// maxDecompressedSize limits the size of decompressed gzip objects.
+	maxDecompressedSize int
+
+	// mu protects newestKey and newestKeyDeadline.
+	mu sync.Mutex
+
+	// newestKey is the cached key of the newest object under prefix. It is refreshed after newestKeyDeadline.
+	newestKey         string
+	newestKeyDeadline time.Time
+}
+
+func newObjectStoreClient(sw *ScrapeWork) *objectStoreClient {
+	osc := sw.ObjectStore
+	if osc == nil {
+		osc = &ObjectStoreConfig{}
+	}
+	bucketPrefix := strings.TrimPrefix(sw.ScrapeURL, "s3://")
+	bucket, prefix := bucketPrefix, ""
+	if n := strings.IndexByte(bucketPrefix, '/'); n >= 0 {
+		bucket, prefix = bucketPrefix[:n], bucketPrefix[n+1:]
+	}
+	var bucketURL string
+	if osc.Endpoint == "" {
+		bucketURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", bucket, osc.Region)
+	} else {
+		endpoint := osc.Endpoint
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		bucketURL = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/"
+	}
+	awsCfg, err := awsapi.NewConfig(osc.Endpoint, osc.Region, osc.RoleARN, osc.AccessKey, osc.SecretKey)
+	if err != nil {
+		err = fmt.Errorf("cannot initialize object storage client for %q: %w", sw.ScrapeURL, err)
+	}
+	return &objectStoreClient{
+		scrapeURL: sw.ScrapeURL,
+		bucketURL: bucketURL,
+		prefix:    prefix,
+		awsCfg:    awsCfg,
+		hc: &http.Client{
+			Timeout: sw.ScrapeTimeout - sw.ScrapeTimeoutOffset,
+		},
+		initErr:             err,
+		maxDecompressedSize: getMaxDecompressedSize(sw),
+	}
+}
+
+// ReadData appends the decompressed contents of the newest object under oc.prefix to dst.
+func (oc *objectStoreClient) ReadData(dst []byte) ([]byte, error) {
+	sr, err := oc.GetStreamReader()
+	if err != nil {
+		return dst, err
+	}
+	defer sr.MustClose()
+	data, err := ioutil.ReadAll(io.LimitReader(sr, int64(maxScrapeSize.N)+1))
+	if err != nil {
+		return dst, fmt.Errorf("cannot read object for %q: %w", oc.scrapeURL, err)
+	}
+	if len(data) > maxScrapeSize.N {
+		return dst, fmt.Errorf("the object for %q exceeds -promscrape.maxScrapeSize=%d; "+
+			"either reduce the object size or increase -promscrape.maxScrapeSize", oc.scrapeURL, maxScrapeSize.N)
+	}
+	return append(dst, data...), nil
+}
+
+// GetStreamReader returns stream reader for the decompressed contents of the newest object under oc.prefix.
+func (oc *objectStoreClient) GetStreamReader() (*streamReader, error) {
+	if oc.initErr != nil {
+		return nil, oc.initErr
+	}
+	key, isCached, err := oc.getNewestKeyCached()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oc.doRequest(oc.getObjectURL(key))
+	if err != nil && isCached {
+		// The cached object may be deleted, so list objects again.
+		oc.resetNewestKey()
+		key, _, err = oc.getNewestKeyCached()
+		if err != nil {
+			return nil, err
+		}
+		resp, err = oc.doRequest(oc.getObjectURL(key))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch object %q for %q: %w", key, oc.scrapeURL, err)
+	}
+	// Gzip-compressed objects are detected by the gzip magic header, since they may be stored without `.gz` extension.
+	br := bufio.NewReader(resp.Body)
+	var r io.ReadCloser = struct {
+		io.Reader
+		io.Closer
+	}{br, resp.Body}
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		r, err = newDecompressReader(r, "gzip", oc.maxDecompressedSize)
+		if err != nil {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("cannot read object %q for %q: %w", key, oc.scrapeURL, err)
+		}
+	}
+	scrapesOK.Inc()
+	return &streamReader{
+		r:      r,
+		cancel: func() {},
+	}, nil
+}
+
+func (oc *objectStoreClient) getObjectURL(key string) string {
+	return oc.bucketURL + (&url.URL{Path: key}).EscapedPath()
+}
+
+// getNewestKeyCached returns the key of the most recently modified object under oc.prefix.
+//
+// The key is cached for -promscrape.objectStoreListInterval, so objects aren't listed on every scrape.
+// true is returned if the key is obtained from the cache.
+func (oc *objectStoreClient) getNewestKeyCached() (string, bool, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	if oc.newestKey != "" && time.Now().Before(oc.newestKeyDeadline) {
+		return oc.newestKey, true, nil
+	}
+	key, err := oc.getNewestKey()
+	if err != nil {
+		return "", false, err
+	}
+	oc.newestKey = key
+	oc.newestKeyDeadline = time.Now().Add(*objectStoreListInterval)
+	return key, false, nil
+}
+
+func (oc *objectStoreClient) resetNewestKey() {
+	oc.mu.Lock()
+	oc.newestKey = ""
+	oc.mu.Unlock()
+}
+
+// getNewestKey returns the key of the most recently modified object under oc.prefix.
+//
+// The size of every page with the list of objects is limited by -promscrape.maxScrapeSize.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+func (oc *objectStoreClient) getNewestKey() (string, error) {
+	var newestKey string
+	var newestTime time.Time
+	continuationToken := ""
+	for {
+		args := url.Values{}
+		args.Set("list-type", "2")
+		args.Set("prefix", oc.prefix)
+		if continuationToken != "" {
+			args.Set("continuation-token", continuationToken)
+		}
+		listURL := oc.bucketURL + "?" + args.Encode()
+		resp, err := oc.doRequest(listURL)
+		if err != nil {
+			return "", fmt.Errorf("cannot list objects for %q: %w", oc.scrapeURL, err)
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(maxScrapeSize.N)+1))
+		_ = resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("cannot read the list of objects for %q: %w", oc.scrapeURL, err)
+		}
+		if len(data) > maxScrapeSize.N {
+			return "", fmt.Errorf("the list of objects for %q exceeds -promscrape.maxScrapeSize=%d; "+
+				"either use more specific prefix or increase -promscrape.maxScrapeSize", oc.scrapeURL, maxScrapeSize.N)
+		}
+		var lr listBucketResult
+		if err := xml.Unmarshal(data, &lr); err != nil {
+			return "", fmt.Errorf("cannot parse the list of objects for %q: %w", oc.scrapeURL, err)
+		}
+		for _, obj := range lr.Contents {
+			if strings.HasSuffix(obj.Key, "/") {
+				// Skip directory placeholders.
+				continue
+			}
+			if newestKey == "" || obj.LastModified.After(newestTime) || (obj.LastModified.Equal(newestTime) && obj.Key > newestKey) {
+				newestKey = obj.Key
+				newestTime = obj.LastModified
+			}
+		}
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			break
+		}
+		continuationToken = lr.NextContinuationToken
+	}
+	if newestKey == "" {
+		return "", fmt.Errorf("cannot find objects for %q", oc.scrapeURL)
+	}
+	return newestKey, nil
+}
+
+// doRequest performs signed GET request to apiURL and returns the response with 200 status code.
+func (oc *objectStoreClient) doRequest(apiURL string) (*http.Response, error) {
+	headers := map[string]string{
+		"X-Amz-Content-Sha256": emptyPayloadHash,
+	}
+	req, err := oc.awsCfg.NewSignedRequest("GET", apiURL, "s3", headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oc.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code returned from %q: %d; expecting %d; response body: %q", apiURL, resp.StatusCode, http.StatusOK, body)
+	}
+	return resp, nil
+}
+
+// listBucketResult represents response to https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
@@ -0,0 +1,283 @@
+package promscrape
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+)
+
+// testObjectStoreServer is S3-compatible server for tests, which serves the given objects.
+type testObjectStoreServer struct {
+	*httptest.Server
+
+	// listRequests is the number of requests for listing objects.
+	listRequests uint64
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (ts *testObjectStoreServer) deleteObject(key string) {
+	ts.mu.Lock()
+	delete(ts.objects, key)
+	ts.mu.Unlock()
+}
+
+func newTestObjectStoreServer(t *testing.T, objects map[string][]byte) *testObjectStoreServer {
+	t.Helper()
+	ts := &testObjectStoreServer{
+		objects: objects,
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") || r.Header.Get("X-Amz-Content-Sha256") != emptyPayloadHash {
+			http.Error(w, "missing signature", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/bucket/" {
+			atomic.AddUint64(&ts.listRequests, 1)
+			// The objects are returned in two pages in order to verify pagination.
+			if r.URL.Query().Get("list-type") != "2" || r.URL.Query().Get("prefix") != "exporters/" {
+				http.Error(w, fmt.Sprintf("unexpected list request %q", r.URL), http.StatusBadRequest)
+				return
+			}
+			if r.URL.Query().Get("continuation-token") == "" {
+				fmt.Fprintf(w, `<ListBucketResult>
+  <IsTruncated>true</IsTruncated>
+  <NextContinuationToken>page2</NextContinuationToken>
+  <Contents><Key>exporters/</Key><LastModified>2020-11-02T10:00:00.000Z</LastModified></Contents>
+  <Contents><Key>exporters/node-1.prom</Key><LastModified>2020-11-01T10:00:00.000Z</LastModified></Contents>
+</ListBucketResult>`)
+				return
+			}
+			fmt.Fprintf(w, `<ListBucketResult>
+  <IsTruncated>false</IsTruncated>
+  <Contents><Key>exporters/node-2.prom.gz</Key><LastModified>2020-11-01T11:00:00.000Z</LastModified></Contents>
+</ListBucketResult>`)
+			return
+		}
+		ts.mu.Lock()
+		data, ok := ts.objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+		ts.mu.Unlock()
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	return ts
+}
+
+func TestScrapeWorkObjectStoreScrape(t *testing.T) {
+	var bb bytes.Buffer
+	zw := gzip.NewWriter(&bb)
+	if _, err := zw.Write([]byte("foo{bar=\"baz\"} 1\nqwe 2.5\n")); err != nil {
+		t.Fatalf("cannot compress data: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close gzip writer: %s", err)
+	}
+	s := newTestObjectStoreServer(t, map[string][]byte{
+		"exporters/node-1.prom":    []byte("old 1\n"),
+		"exporters/node-2.prom.gz": bb.Bytes(),
+	})
+	defer s.Close()
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	address := "s3://bucket/exporters/"
+	f := func(streamParse bool) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: batch
+  stream_parse: %v
+  object_store:
+    region: us-east-1
+    endpoint: %q
+    access_key: test-key
+    secret_key: test-secret
+  static_configs:
+  - targets: [%q]
+`, streamParse, s.URL, address)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		if sws[0].ScrapeURL != address {
+			t.Fatalf("unexpected scrape url; got %q; want %q", sws[0].ScrapeURL, address)
+		}
+		sws[0].ScrapeTimeout = 5 * time.Second
+		var tss []prompbmarshal.TimeSeries
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				labels := append([]prompbmarshal.Label{}, ts.Labels...)
+				samples := append([]prompbmarshal.Sample{}, ts.Samples...)
+				tss = append(tss, prompbmarshal.TimeSeries{
+					Labels:  labels,
+					Samples: samples,
+				})
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		dataExpected := strings.ReplaceAll(`
+		foo{bar="baz",instance="ADDRESS",job="batch"} 1 123
+		qwe{instance="ADDRESS",job="batch"} 2.5 123
+		up{instance="ADDRESS",job="batch"} 1 123
+		scrape_samples_scraped{instance="ADDRESS",job="batch"} 2 123
+		scrape_duration_seconds{instance="ADDRESS",job="batch"} 0 123
+		scrape_samples_post_metric_relabeling{instance="ADDRESS",job="batch"} 2 123
+		scrape_series_added{instance="ADDRESS",job="batch"} 2 123
+		scrape_body_size_bytes{instance="ADDRESS",job="batch"} 25 123
+`, "ADDRESS", address)
+		timeseriesExpected := parseData(dataExpected)
+		if err := expectEqualTimeseries(tss, timeseriesExpected); err != nil {
+			t.Fatalf("unexpected data pushed: %s\ngot\n%v\nwant\n%v", err, tss, timeseriesExpected)
+		}
+	}
+	f(false)
+	f(true)
+}
+
+func TestObjectStoreClientFailure(t *testing.T) {
+	s := newTestObjectStoreServer(t, nil)
+	defer s.Close()
+
+	f := func(scrapeURL, accessKey string) {
+		t.Helper()
+		sw := &ScrapeWork{
+			ScrapeURL:     scrapeURL,
+			ScrapeTimeout: 5 * time.Second,
+			ObjectStore: &ObjectStoreConfig{
+				Region:    "us-east-1",
+				Endpoint:  s.URL,
+				AccessKey: accessKey,
+				SecretKey: "test-secret",
+			},
+		}
+		data, err := newObjectStoreClient(sw).ReadData(nil)
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if len(data) > 0 {
+			t.Fatalf("unexpected data on error: %q", data)
+		}
+	}
+	// Rejected credentials
+	f("s3://bucket/exporters/", "invalid-key")
+	// Missing object
+	f("s3://bucket/exporters/", "test-key")
+	// Unknown bucket
+	f("s3://missing/exporters/", "test-key")
+}
+
+func TestObjectStoreClientNewestKeyCache(t *testing.T) {
+	s := newTestObjectStoreServer(t, map[string][]byte{
+		"exporters/node-1.prom":    []byte("old 1\n"),
+		"exporters/node-2.prom.gz": []byte("new 1\n"),
+	})
+	defer s.Close()
+
+	sw := &ScrapeWork{
+		ScrapeURL:     "s3://bucket/exporters/",
+		ScrapeTimeout: 5 * time.Second,
+		ObjectStore: &ObjectStoreConfig{
+			Region:    "us-east-1",
+			Endpoint:  s.URL,
+			AccessKey: "test-key",
+			SecretKey: "test-secret",
+		},
+	}
+	oc := newObjectStoreClient(sw)
+	f := func(dataExpected string, listRequestsExpected uint64) {
+		t.Helper()
+		data, err := oc.ReadData(nil)
+		if dataExpected == "" {
+			if err == nil {
+				t.Fatalf("expecting non-nil error")
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(data) != dataExpected {
+				t.Fatalf("unexpected data; got %q; want %q", data, dataExpected)
+			}
+		}
+		if n := atomic.LoadUint64(&s.listRequests); n != listRequestsExpected {
+			t.Fatalf("unexpected number of list requests; got %d; want %d", n, listRequestsExpected)
+		}
+	}
+
+	// The first scrape lists both pages with objects.
+	f("new 1\n", 2)
+
+	// The newest key is cached between scrapes.
+	f("new 1\n", 2)
+
+	// Objects are listed again after the cached key expires.
+	oc.newestKeyDeadline = time.Now()
+	f("new 1\n", 4)
+
+	// Objects are listed on every scrape if caching is disabled.
+	listIntervalOrig := *objectStoreListInterval
+	*objectStoreListInterval = 0
+	oc.newestKeyDeadline = time.Now()
+	f("new 1\n", 6)
+	f("new 1\n", 8)
+	*objectStoreListInterval = listIntervalOrig
+
+	// Objects are listed again if the cached object cannot be fetched.
+	oc.newestKeyDeadline = time.Now().Add(time.Hour)
+	s.deleteObject("exporters/node-2.prom.gz")
+	f("", 10)
+}
+
+func TestObjectStoreClientListSizeLimit(t *testing.T) {
+	s := newTestObjectStoreServer(t, map[string][]byte{
+		"exporters/node-2.prom.gz": []byte("new 1\n"),
+	})
+	defer s.Close()
+
+	maxScrapeSizeOrig := maxScrapeSize.N
+	maxScrapeSize.N = 100
+	defer func() {
+		maxScrapeSize.N = maxScrapeSizeOrig
+	}()
+	sw := &ScrapeWork{
+		ScrapeURL:     "s3://bucket/exporters/",
+		ScrapeTimeout: 5 * time.Second,
+		ObjectStore: &ObjectStoreConfig{
+			Region:    "us-east-1",
+			Endpoint:  s.URL,
+			AccessKey: "test-key",
+			SecretKey: "test-secret",
+		},
+	}
+	_, err := newObjectStoreClient(sw).ReadData(nil)
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if !strings.Contains(err.Error(), "the list of objects") {
+		t.Fatalf("unexpected error; got %q; want the error about too big list of objects", err)
+	}
+}
@@ -0,0 +1,105 @@
+package promscrape
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	maxPushSamplesPerSecond = flag.Int("promscrape.maxPushSamplesPerSecond", 0, "The maximum number of scraped samples per second to push to remote storage. "+
+		"Pushes are delayed when the limit is exceeded. This may be useful for protecting remote storage during scrape target storms. "+
+		"By default the rate is unlimited. See also -promscrape.dropSamplesOnPushRateLimit")
+	dropSamplesOnPushRateLimit = flag.Bool("promscrape.dropSamplesOnPushRateLimit", false, "Whether to drop scraped samples instead of delaying pushes "+
+		"when -promscrape.maxPushSamplesPerSecond is exceeded. The number of dropped samples is exposed via vm_promscrape_push_samples_dropped_total metric")
+)
+
+// pushRateLimiter limits the rate of samples passed to pushData.
+type pushRateLimiter struct {
+	perSecondLimit int64
+	dropOnLimit    bool
+
+	// stopCh unblocks the waiting pushes on shutdown, so scrapers could be stopped.
+	stopCh <-chan struct{}
+
+	mu sync.Mutex
+
+	// budget is the number of samples, which may be pushed until deadline.
+	// It may become negative if big WriteRequest has been pushed. The debt is paid after the deadline.
+	budget   int64
+	deadline time.Time
+}
+
+func newPushRateLimiter(perSecondLimit int, dropOnLimit bool, stopCh <-chan struct{}) *pushRateLimiter {
+	return &pushRateLimiter{
+		perSecondLimit: int64(perSecondLimit),
+		dropOnLimit:    dropOnLimit,
+		stopCh:         stopCh,
+	}
+}
+
+// wrapPushData returns pushData wrapper, which limits the rate of pushed samples.
+func (rl *pushRateLimiter) wrapPushData(pushData func(wr *prompbmarshal.WriteRequest)) func(wr *prompbmarshal.WriteRequest) {
+	return func(wr *prompbmarshal.WriteRequest) {
+		samples := 0
+		for i := range wr.Timeseries {
+			samples += len(wr.Timeseries[i].Samples)
+		}
+		if !rl.register(samples) {
+			pushSamplesDropped.Add(samples)
+			return
+		}
+		pushData(wr)
+	}
+}
+
+// register registers n samples for pushing.
+//
+// It waits until the samples may be pushed if dropOnLimit isn't set.
+// It returns false if the samples must be dropped.
+func (rl *pushRateLimiter) register(n int) bool {
+	for {
+		rl.mu.Lock()
+		now := time.Now()
+		if !now.Before(rl.deadline) {
+			rl.budget += rl.perSecondLimit
+			if rl.budget > rl.perSecondLimit {
+				rl.budget = rl.perSecondLimit
+			}
+			rl.deadline = now.Add(time.Second)
+		}
+		if rl.budget > 0 {
+			// Pass WriteRequest exceeding the remaining budget as a whole, since splitting it isn't worth it.
+			// The subsequent pushes will be delayed until the debt is paid.
+			rl.budget -= int64(n)
+			rl.mu.Unlock()
+			return true
+		}
+		if rl.dropOnLimit {
+			rl.mu.Unlock()
+			return false
+		}
+		d := rl.deadline.Sub(now)
+		rl.mu.Unlock()
+
+		// Do not hold rl.mu while waiting, so concurrent pushes aren't blocked on it.
+		pushDelays.Inc()
+		t := timerpool.Get(d)
+		select {
+		case <-rl.stopCh:
+			timerpool.Put(t)
+			return true
+		case <-t.C:
+			timerpool.Put(t)
+		}
+	}
+}
+
+var (
+	pushSamplesDropped = metrics.NewCounter(`vm_promscrape_push_samples_dropped_total`)
+	pushDelays         = metrics.NewCounter(`vm_promscrape_push_delays_total`)
+)
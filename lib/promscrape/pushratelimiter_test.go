@@ -0,0 +1,93 @@
+package promscrape
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func newTestWriteRequest(samples int) *prompbmarshal.WriteRequest {
+	var wr prompbmarshal.WriteRequest
+	for i := 0; i < samples; i++ {
+		wr.Timeseries = append(wr.Timeseries, prompbmarshal.TimeSeries{
+			Samples: []prompbmarshal.Sample{{Value: float64(i)}},
+		})
+	}
+	return &wr
+}
+
+func TestPushRateLimiterBlock(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	rl := newPushRateLimiter(1000, false, stopCh)
+	var samplesPushed uint64
+	pushData := rl.wrapPushData(func(wr *prompbmarshal.WriteRequest) {
+		atomic.AddUint64(&samplesPushed, uint64(len(wr.Timeseries)))
+	})
+
+	// Push 2500 samples from concurrent goroutines at high rate.
+	// The first 1000 samples are pushed immediately, while the rest must be delayed by at least a second.
+	wr := newTestWriteRequest(50)
+	startTime := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				pushData(wr)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadUint64(&samplesPushed); n != 2500 {
+		t.Fatalf("unexpected number of pushed samples; got %d; want 2500", n)
+	}
+	if d := time.Since(startTime); d < time.Second {
+		t.Fatalf("too small duration for pushing samples over the limit; got %s; want at least 1s", d)
+	}
+}
+
+func TestPushRateLimiterDrop(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	rl := newPushRateLimiter(1000, true, stopCh)
+	samplesPushed := 0
+	pushData := rl.wrapPushData(func(wr *prompbmarshal.WriteRequest) {
+		samplesPushed += len(wr.Timeseries)
+	})
+	droppedBefore := pushSamplesDropped.Get()
+	wr := newTestWriteRequest(100)
+	for i := 0; i < 100; i++ {
+		pushData(wr)
+	}
+	if samplesPushed != 1000 {
+		t.Fatalf("unexpected number of pushed samples; got %d; want 1000", samplesPushed)
+	}
+	if n := pushSamplesDropped.Get() - droppedBefore; n != 9000 {
+		t.Fatalf("unexpected number of dropped samples; got %d; want 9000", n)
+	}
+}
+
+func TestPushRateLimiterStop(t *testing.T) {
+	stopCh := make(chan struct{})
+	rl := newPushRateLimiter(10, false, stopCh)
+	pushData := rl.wrapPushData(func(wr *prompbmarshal.WriteRequest) {})
+	wr := newTestWriteRequest(1000)
+	// The first push exceeds the limit, so the second push must wait for 100 seconds until the debt is paid.
+	pushData(wr)
+	doneCh := make(chan struct{})
+	go func() {
+		pushData(wr)
+		close(doneCh)
+	}()
+	close(stopCh)
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the blocked push hasn't been unblocked on stop")
+	}
+}
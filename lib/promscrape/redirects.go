@@ -0,0 +1,118 @@
+package promscrape
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultMaxRedirects is the maximum number of redirects followed by fasthttp client if `max_redirects` isn't set.
+	defaultMaxRedirects = 1
+
+	// defaultMaxStreamRedirects is the maximum number of redirects followed by net/http client if `redirect_host_allowlist` is set without `max_redirects`.
+	// net/http default redirect policy is used if neither of these options is set.
+	defaultMaxStreamRedirects = 10
+)
+
+// validateRedirectHostAllowlist verifies whether hosts contain valid `redirect_host_allowlist` entries.
+func validateRedirectHostAllowlist(hosts []string) error {
+	for _, host := range hosts {
+		if host == "" {
+			return fmt.Errorf("host cannot be empty")
+		}
+		if strings.Contains(host, "/") {
+			return fmt.Errorf("host %q cannot contain scheme or path; it must be in the form `host` or `host:port`", host)
+		}
+	}
+	return nil
+}
+
+// redirectPolicy limits redirects followed during scrapes according to ScrapeWork.MaxRedirects and ScrapeWork.RedirectHosts.
+type redirectPolicy struct {
+	// maxRedirects is the maximum number of redirects per scrape. The default limit is used if it is nil.
+	maxRedirects *int
+
+	// allowedHosts contains hosts in the form `host` or `host:port`, which are allowed in redirects.
+	// Redirects to any host are allowed if it is empty.
+	allowedHosts []string
+
+	// targetHost is the `host:port` of the scrape url, redirects to it are always allowed.
+	targetHost string
+}
+
+// newRedirectPolicy returns redirect policy for sw with the given targetHost.
+//
+// Nil is returned if neither `max_redirects` nor `redirect_host_allowlist` is set for sw.
+func newRedirectPolicy(sw *ScrapeWork, targetHost string) *redirectPolicy {
+	if sw.MaxRedirects == nil && len(sw.RedirectHosts) == 0 {
+		return nil
+	}
+	return &redirectPolicy{
+		maxRedirects: sw.MaxRedirects,
+		allowedHosts: sw.RedirectHosts,
+		targetHost:   targetHost,
+	}
+}
+
+// getMaxRedirects returns the maximum number of redirects per scrape. defaultMax is returned if the limit isn't configured.
+func (rp *redirectPolicy) getMaxRedirects(defaultMax int) int {
+	if rp == nil || rp.maxRedirects == nil {
+		return defaultMax
+	}
+	return *rp.maxRedirects
+}
+
+// maxRedirectsString returns string representation for maxRedirects, which is used in ScrapeWork.key.
+func maxRedirectsString(maxRedirects *int) string {
+	if maxRedirects == nil {
+		return ""
+	}
+	return strconv.Itoa(*maxRedirects)
+}
+
+// checkRedirect returns an error if the redirect to location with the given scheme and host isn't allowed after the given number of redirects.
+func (rp *redirectPolicy) checkRedirect(location, scheme, host string, redirects, defaultMax int) error {
+	if maxRedirects := rp.getMaxRedirects(defaultMax); redirects >= maxRedirects {
+		return fmt.Errorf("cannot follow redirect to %q, since the number of redirects exceeds `max_redirects: %d`", location, maxRedirects)
+	}
+	if rp == nil || len(rp.allowedHosts) == 0 {
+		return nil
+	}
+	hostname, port := splitRedirectHost(scheme, host)
+	hostPort := net.JoinHostPort(hostname, port)
+	if strings.EqualFold(hostPort, rp.targetHost) {
+		return nil
+	}
+	for _, allowedHost := range rp.allowedHosts {
+		if strings.EqualFold(allowedHost, hostname) || strings.EqualFold(allowedHost, hostPort) {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot follow redirect to %q, since host %q is missing in `redirect_host_allowlist`", location, hostname)
+}
+
+// newCheckRedirectFunc returns http.Client.CheckRedirect for rp. Nil is returned if rp is nil, so net/http default policy is used.
+func (rp *redirectPolicy) newCheckRedirectFunc() func(req *http.Request, via []*http.Request) error {
+	if rp == nil {
+		return nil
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		return rp.checkRedirect(req.URL.String(), req.URL.Scheme, req.URL.Host, len(via)-1, defaultMaxStreamRedirects)
+	}
+}
+
+// splitRedirectHost returns hostname and port for the given host from redirect location with the given scheme.
+func splitRedirectHost(scheme, host string) (string, string) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err == nil {
+		return hostname, port
+	}
+	hostname = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if scheme == "https" {
+		return hostname, "443"
+	}
+	return hostname, "80"
+}
@@ -0,0 +1,151 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+)
+
+func TestScrapeWorkRedirects(t *testing.T) {
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	var metricsRequests uint64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/backend/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "" {
+			// Credentials for the scrape target mustn't be sent to other hosts.
+			http.Error(w, "unexpected Authorization header", http.StatusForbidden)
+			return
+		}
+		atomic.AddUint64(&metricsRequests, 1)
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer backend.Close()
+	var target *httptest.Server
+	target = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/metrics":
+			atomic.AddUint64(&metricsRequests, 1)
+			fmt.Fprintf(w, "foo 1\n")
+		case r.URL.Path == "/backend":
+			// Redirect to another host.
+			http.Redirect(w, r, backend.URL+"/backend/metrics", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/chain/"):
+			// Redirect /chain/N to /chain/N-1 until /metrics.
+			var n int
+			fmt.Sscanf(r.URL.Path, "/chain/%d", &n)
+			location := "/metrics"
+			if n > 1 {
+				location = fmt.Sprintf("/chain/%d", n-1)
+			}
+			http.Redirect(w, r, target.URL+location, http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer target.Close()
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", target.URL, err)
+	}
+
+	f := func(options, metricsPath, errExpected string) {
+		t.Helper()
+		atomic.StoreUint64(&metricsRequests, 0)
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: redirects
+  metrics_path: %s
+%s
+  static_configs:
+  - targets: [%q]
+`, metricsPath, options, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		defer tsmGlobal.Unregister(&sws[0])
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+		timestamp := time.Now().UnixNano() / 1e6
+		err := sc.sw.scrapeInternal(timestamp, timestamp)
+		if errExpected == "" {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if n := atomic.LoadUint64(&metricsRequests); n != 1 {
+				t.Fatalf("unexpected number of metrics requests; got %d; want 1", n)
+			}
+			return
+		}
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want %q", err, errExpected)
+		}
+		if n := atomic.LoadUint64(&metricsRequests); n != 0 {
+			t.Fatalf("unexpected number of metrics requests; got %d; want 0", n)
+		}
+	}
+
+	// Redirect to allowed host
+	f(`
+  redirect_host_allowlist: ["127.0.0.1"]`, "/backend", "")
+	f(`
+  stream_parse: true
+  redirect_host_allowlist: ["127.0.0.1"]`, "/backend", "")
+
+	// Redirect to other host with the default redirect policy
+	f(``, "/backend", "")
+
+	// Credentials aren't sent to other hosts
+	f(`
+  bearer_token: secret`, "/backend", "")
+
+	// Redirect to the scrape target host is allowed
+	f(`
+  redirect_host_allowlist: [example.com]`, "/chain/1", "")
+	f(`
+  stream_parse: true
+  redirect_host_allowlist: [example.com]`, "/chain/1", "")
+
+	// Redirect to disallowed host
+	f(`
+  stream_parse: true
+  redirect_host_allowlist: [example.com]`, "/backend", "missing in `redirect_host_allowlist`")
+	f(`
+  redirect_host_allowlist: ["127.0.0.1:1"]`, "/backend", "missing in `redirect_host_allowlist`")
+
+	// Redirects within max_redirects
+	f(`
+  max_redirects: 3`, "/chain/3", "")
+	f(`
+  stream_parse: true
+  max_redirects: 3`, "/chain/3", "")
+
+	// Redirects exceed max_redirects
+	f(`
+  max_redirects: 2`, "/chain/3", "exceeds `max_redirects: 2`")
+	f(`
+  stream_parse: true
+  max_redirects: 2`, "/chain/3", "exceeds `max_redirects: 2`")
+	f(`
+  max_redirects: 0`, "/chain/1", "exceeds `max_redirects: 0`")
+}
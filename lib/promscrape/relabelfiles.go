@@ -0,0 +1,97 @@
+package promscrape
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envtemplate"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"gopkg.in/yaml.v2"
+)
+
+var relabelFilesCacheGlobal = &relabelFilesCache{
+	m: make(map[string][]promrelabel.ParsedRelabelConfig),
+}
+
+// relabelFilesCache holds the last successfully loaded rules from `relabel_config_files` per each `job_name`.
+//
+// The cached rules are used when some of the files become malformed, so a broken file owned by a single team
+// doesn't drop relabeling rules for the whole job.
+type relabelFilesCache struct {
+	mu sync.Mutex
+	m  map[string][]promrelabel.ParsedRelabelConfig
+}
+
+func (rfc *relabelFilesCache) get(jobName string) ([]promrelabel.ParsedRelabelConfig, bool) {
+	rfc.mu.Lock()
+	defer rfc.mu.Unlock()
+	prcs, ok := rfc.m[jobName]
+	return prcs, ok
+}
+
+func (rfc *relabelFilesCache) set(jobName string, prcs []promrelabel.ParsedRelabelConfig) {
+	rfc.mu.Lock()
+	rfc.m[jobName] = prcs
+	rfc.mu.Unlock()
+}
+
+// loadRelabelConfigFiles loads relabeling rules from files matching the given patterns in the declared order.
+//
+// Relative patterns are resolved against baseDir. Files matching a single pattern are loaded in lexicographical order.
+// The raw contents of the loaded files is returned in order to detect changes in these files on config reload.
+// The previously loaded rules for jobName are returned if some of the files cannot be loaded.
+func loadRelabelConfigFiles(jobName, baseDir string, patterns []string) ([]promrelabel.ParsedRelabelConfig, []byte, error) {
+	var prcs []promrelabel.ParsedRelabelConfig
+	var data []byte
+	var loadErr error
+	for _, pattern := range patterns {
+		pathPattern := getFilepath(baseDir, pattern)
+		paths := []string{pathPattern}
+		if strings.Contains(pathPattern, "*") {
+			var err error
+			paths, err = filepath.Glob(pathPattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid pattern %q in `relabel_config_files`: %w", pattern, err)
+			}
+		}
+		for _, path := range paths {
+			b, err := ioutil.ReadFile(path)
+			data = append(data, path...)
+			data = append(data, '\n')
+			if err != nil {
+				data = append(data, err.Error()...)
+				if loadErr == nil {
+					loadErr = fmt.Errorf("cannot read `relabel_config_files` entry %q: %w", path, err)
+				}
+				continue
+			}
+			data = append(data, b...)
+			if loadErr != nil {
+				continue
+			}
+			var rcs []promrelabel.RelabelConfig
+			if err := yaml.UnmarshalStrict(envtemplate.Replace(b), &rcs); err != nil {
+				loadErr = fmt.Errorf("cannot unmarshal relabeling rules from `relabel_config_files` entry %q: %w", path, err)
+				continue
+			}
+			prcs, err = promrelabel.ParseRelabelConfigs(prcs, rcs)
+			if err != nil {
+				loadErr = fmt.Errorf("cannot parse relabeling rules from `relabel_config_files` entry %q: %w", path, err)
+			}
+		}
+	}
+	if loadErr != nil {
+		prcsPrev, ok := relabelFilesCacheGlobal.get(jobName)
+		if !ok {
+			return nil, nil, loadErr
+		}
+		logger.Errorf("keeping the previously loaded `relabel_config_files` rules for `job_name` %q because of error: %s", jobName, loadErr)
+		return prcsPrev, data, nil
+	}
+	relabelFilesCacheGlobal.set(jobName, prcs)
+	return prcs, data, nil
+}
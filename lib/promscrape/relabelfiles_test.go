@@ -0,0 +1,128 @@
+package promscrape
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestRelabelConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relabel_config_files")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	writeFile := func(name, data string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("cannot write %q: %s", name, err)
+		}
+	}
+	writeFile("a.yml", `
+- source_labels: [team]
+  target_label: team
+  replacement: "$1,a"
+`)
+	writeFile("b.yml", `
+- source_labels: [team]
+  target_label: team
+  replacement: "$1,b"
+- target_label: owner
+  replacement: b
+`)
+	data := fmt.Sprintf(`
+global:
+  relabel_config_files: [%q]
+scrape_configs:
+- job_name: relabel_files
+  relabel_configs:
+  - target_label: team
+    replacement: inline
+  relabel_config_files: [%q]
+  static_configs:
+  - targets: ["foo"]
+- job_name: relabel_files_global
+  static_configs:
+  - targets: ["bar"]
+`, filepath.Join(dir, "b.yml"), filepath.Join(dir, "*.yml"))
+	parseConfig := func() *Config {
+		t.Helper()
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		return &cfg
+	}
+	checkLabels := func(cfg *Config, teamExpected, ownerExpected, globalTeamExpected string) {
+		t.Helper()
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 2 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+		}
+		if team := promrelabel.GetLabelValueByName(sws[0].Labels, "team"); team != teamExpected {
+			t.Fatalf("unexpected team label; got %q; want %q", team, teamExpected)
+		}
+		if owner := promrelabel.GetLabelValueByName(sws[0].Labels, "owner"); owner != ownerExpected {
+			t.Fatalf("unexpected owner label; got %q; want %q", owner, ownerExpected)
+		}
+		// The job without `relabel_config_files` uses the files from `global` section.
+		if team := promrelabel.GetLabelValueByName(sws[1].Labels, "team"); team != globalTeamExpected {
+			t.Fatalf("unexpected team label for the job with global `relabel_config_files`; got %q; want %q", team, globalTeamExpected)
+		}
+	}
+
+	// The rules from files are appended to `relabel_configs` in the declared order.
+	cfg := parseConfig()
+	checkLabels(cfg, "inline,a,b", "b", ",b")
+
+	// The previous rules are kept for the job with malformed file, while the change is detected.
+	writeFile("b.yml", "- foo: bar")
+	cfgNew := parseConfig()
+	checkLabels(cfgNew, "inline,a,b", "b", ",b")
+	if bytes.Equal(cfg.relabelFilesData, cfgNew.relabelFilesData) {
+		t.Fatalf("expecting changed relabelFilesData after changing the file")
+	}
+
+	// The fixed file is loaded on the next config reload.
+	writeFile("b.yml", `
+- source_labels: [team]
+  target_label: team
+  replacement: "$1,c"
+`)
+	checkLabels(parseConfig(), "inline,a,c", "", ",c")
+}
+
+func TestRelabelConfigFilesFailure(t *testing.T) {
+	f, err := ioutil.TempFile("", "relabel_config_files_*.yml")
+	if err != nil {
+		t.Fatalf("cannot create temporary file: %s", err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.WriteString("- action: foobar"); err != nil {
+		t.Fatalf("cannot write to temporary file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("cannot close temporary file: %s", err)
+	}
+	// The malformed file without previously loaded rules for the job results in error.
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: relabel_files_failure
+  relabel_config_files: [%q]
+  static_configs:
+  - targets: ["foo"]
+`, f.Name())
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err == nil {
+		t.Fatalf("expecting non-nil error for malformed `relabel_config_files`")
+	}
+}
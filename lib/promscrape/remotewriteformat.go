@@ -0,0 +1,94 @@
+package promscrape
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompb"
+	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+	"github.com/golang/snappy"
+)
+
+// expositionFormatRemoteWrite is `exposition_format` value for targets exposing snappy-compressed Prometheus remote_write `WriteRequest` protobuf messages.
+const expositionFormatRemoteWrite = "promremotewrite"
+
+// remoteWriteAcceptHeader is sent in `Accept` header to targets with expositionFormatRemoteWrite.
+const remoteWriteAcceptHeader = "application/x-protobuf"
+
+// validateExpositionFormat verifies whether format is supported `exposition_format` value.
+func validateExpositionFormat(format string) error {
+	switch format {
+	case "", expositionFormatRemoteWrite:
+		return nil
+	default:
+		return fmt.Errorf("unsupported `exposition_format` %q; supported values: %q", format, expositionFormatRemoteWrite)
+	}
+}
+
+// remoteWriteUnmarshaler converts snappy-compressed prompb.WriteRequest messages to parser.Rows.
+//
+// The converted rows refer to remoteWriteUnmarshaler internal buffers, so they cannot be used after reset call.
+type remoteWriteUnmarshaler struct {
+	buf  []byte
+	wr   prompb.WriteRequest
+	tags []parser.Tag
+}
+
+func (ru *remoteWriteUnmarshaler) reset() {
+	ru.buf = ru.buf[:0]
+	ru.wr.Reset()
+	for i := range ru.tags {
+		ru.tags[i] = parser.Tag{}
+	}
+	ru.tags = ru.tags[:0]
+}
+
+// unmarshal appends rows for samples from snappy-compressed prompb.WriteRequest in data to dst.
+//
+// Series without `__name__` label are skipped.
+func (ru *remoteWriteUnmarshaler) unmarshal(dst *parser.Rows, data []byte) error {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return fmt.Errorf("cannot decompress snappy-encoded response with length %d: %w", len(data), err)
+	}
+	if n > maxScrapeSize.N {
+		return fmt.Errorf("too big unpacked response; it mustn't exceed -promscrape.maxScrapeSize=%d bytes; got %d bytes", maxScrapeSize.N, n)
+	}
+	ru.buf, err = snappy.Decode(ru.buf[:cap(ru.buf)], data)
+	if err != nil {
+		return fmt.Errorf("cannot decompress snappy-encoded response with length %d: %w", len(data), err)
+	}
+	if err := ru.wr.Unmarshal(ru.buf); err != nil {
+		return fmt.Errorf("cannot unmarshal prompb.WriteRequest with size %d bytes: %w", len(ru.buf), err)
+	}
+	tss := ru.wr.Timeseries
+	for i := range tss {
+		ts := &tss[i]
+		metric := ""
+		tagsStart := len(ru.tags)
+		for _, label := range ts.Labels {
+			if string(label.Name) == "__name__" {
+				metric = bytesutil.ToUnsafeString(label.Value)
+				continue
+			}
+			ru.tags = append(ru.tags, parser.Tag{
+				Key:   bytesutil.ToUnsafeString(label.Name),
+				Value: bytesutil.ToUnsafeString(label.Value),
+			})
+		}
+		if metric == "" {
+			ru.tags = ru.tags[:tagsStart]
+			continue
+		}
+		tags := ru.tags[tagsStart:len(ru.tags):len(ru.tags)]
+		for _, sample := range ts.Samples {
+			dst.Rows = append(dst.Rows, parser.Row{
+				Metric:    metric,
+				Tags:      tags,
+				Value:     sample.Value,
+				Timestamp: sample.Timestamp,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package promscrape
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/golang/snappy"
+)
+
+func TestScrapeWorkRemoteWriteFormat(t *testing.T) {
+	wr := &prompbmarshal.WriteRequest{
+		Timeseries: []prompbmarshal.TimeSeries{
+			{
+				Labels: []prompbmarshal.Label{
+					{Name: "__name__", Value: "foo"},
+					{Name: "bar", Value: "baz"},
+				},
+				Samples: []prompbmarshal.Sample{
+					{Value: 1, Timestamp: 100000},
+					{Value: 2, Timestamp: 110000},
+				},
+			},
+			{
+				Labels: []prompbmarshal.Label{
+					{Name: "__name__", Value: "secret"},
+				},
+				Samples: []prompbmarshal.Sample{
+					{Value: 3, Timestamp: 100000},
+				},
+			},
+			{
+				// Series without name must be skipped
+				Labels: []prompbmarshal.Label{
+					{Name: "x", Value: "y"},
+				},
+				Samples: []prompbmarshal.Sample{
+					{Value: 4, Timestamp: 100000},
+				},
+			},
+		},
+	}
+	data, err := wr.Marshal()
+	if err != nil {
+		t.Fatalf("cannot marshal WriteRequest: %s", err)
+	}
+	var acceptHeader string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptHeader = r.Header.Get("Accept")
+		if r.URL.Path == "/invalid" {
+			_, _ = w.Write([]byte("foo 123"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(snappy.Encode(nil, data))
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	prcs, err := promrelabel.ParseRelabelConfigs(nil, []promrelabel.RelabelConfig{{
+		Action:       "drop",
+		SourceLabels: []string{"__name__"},
+		Regex:        strPtr("secret"),
+	}})
+	if err != nil {
+		t.Fatalf("cannot parse relabel configs: %s", err)
+	}
+	f := func(path, dataExpected string) {
+		t.Helper()
+		result := scrapeOnce(&ScrapeWork{
+			ScrapeURL:       s.URL + path,
+			ScrapeInterval:  time.Second,
+			ScrapeTimeout:   time.Second,
+			HonorTimestamps: true,
+			Labels: []prompbmarshal.Label{
+				{Name: "instance", Value: u.Host},
+				{Name: "job", Value: "rw"},
+			},
+			AuthConfig:           &promauth.Config{},
+			MetricRelabelConfigs: prcs,
+			ExpositionFormat:     expositionFormatRemoteWrite,
+		})
+		if acceptHeader != remoteWriteAcceptHeader {
+			t.Fatalf("unexpected Accept header; got %q; want %q", acceptHeader, remoteWriteAcceptHeader)
+		}
+		// Reset values and timestamps for auto-generated series, since they depend on the current time.
+		tss := result.Timeseries
+		for i := range tss {
+			ts := &tss[i]
+			name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+			if name == "scrape_duration_seconds" {
+				ts.Samples[0].Value = 0
+			}
+			if name == "up" || strings.HasPrefix(name, "scrape_") {
+				ts.Samples[0].Timestamp = 123000
+			}
+		}
+		dataExpected = strings.ReplaceAll(dataExpected, "HOST", u.Host)
+		if err := expectEqualTimeseries(tss, parseData(dataExpected)); err != nil {
+			t.Fatalf("unexpected data: %s\ngot\n%v", err, tss)
+		}
+	}
+	f("/metrics", `
+		foo{bar="baz",instance="HOST",job="rw"} 1 100
+		foo{bar="baz",instance="HOST",job="rw"} 2 110
+		up{instance="HOST",job="rw"} 1 123
+		scrape_samples_scraped{instance="HOST",job="rw"} 3 123
+		scrape_duration_seconds{instance="HOST",job="rw"} 0 123
+		scrape_samples_post_metric_relabeling{instance="HOST",job="rw"} 2 123
+		scrape_series_added{instance="HOST",job="rw"} 1 123
+		scrape_body_size_bytes{instance="HOST",job="rw"} 106 123
+	`)
+
+	// Invalid response
+	f("/invalid", `
+		up{instance="HOST",job="rw"} 0 123
+		scrape_samples_scraped{instance="HOST",job="rw"} 0 123
+		scrape_duration_seconds{instance="HOST",job="rw"} 0 123
+		scrape_samples_post_metric_relabeling{instance="HOST",job="rw"} 0 123
+		scrape_series_added{instance="HOST",job="rw"} 0 123
+		scrape_body_size_bytes{instance="HOST",job="rw"} 0 123
+	`)
+}
@@ -0,0 +1,59 @@
+package promscrape
+
+import (
+	"errors"
+	"fmt"
+
+	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+)
+
+// errRequiredMetricsMissing is returned if the scraped response doesn't contain metrics from ScrapeWork.RequireMetrics.
+var errRequiredMetricsMissing = errors.New("required metrics are missing in the response")
+
+func validateRequireMetrics(names []string) error {
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("metric names cannot be empty")
+		}
+	}
+	return nil
+}
+
+// checkRequiredMetrics returns an error if rows parsed from the responses for sw don't contain all the metrics from sw.Config.RequireMetrics.
+//
+// This allows detecting responses with unrelated data such as login page returned with 200 status code.
+func (sw *scrapeWork) checkRequiredMetrics(rows []parser.Row) error {
+	if len(sw.Config.RequireMetrics) == 0 {
+		return nil
+	}
+	var missing []string
+	for _, name := range sw.Config.RequireMetrics {
+		if !sw.hasMetric(rows, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing metrics %q in the response from %q: %w", missing, sw.Config.ScrapeURL, errRequiredMetricsMissing)
+}
+
+func (sw *scrapeWork) hasMetric(rows []parser.Row, name string) bool {
+	for i := range rows {
+		if rows[i].Metric == name {
+			return true
+		}
+	}
+	for i := range sw.additionalScrapes {
+		as := &sw.additionalScrapes[i]
+		if as.err != nil {
+			continue
+		}
+		for j := range as.rows.Rows {
+			if as.rows.Rows[j].Metric == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package promscrape
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func TestScrapeWorkRequireMetrics(t *testing.T) {
+	var sw scrapeWork
+	sw.Config = ScrapeWork{
+		ScrapeURL:      "http://foo.bar/metrics",
+		RequireMetrics: []string{"process_start_time_seconds"},
+	}
+	sw.ScrapeGroup = "require_metrics"
+	var body string
+	sw.ReadData = func(dst []byte) ([]byte, error) {
+		return append(dst, body...), nil
+	}
+	var names []string
+	up := -1.0
+	sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+			names = append(names, name)
+			if name == "up" {
+				up = ts.Samples[0].Value
+			}
+		}
+	}
+	errorsCounter := metrics.GetOrCreateCounter(`vm_promscrape_scrape_errors_total{type="require_metrics", reason="schema_validation"}`)
+
+	// 200 response without the required metric must fail the scrape without ingesting the scraped samples.
+	body = "foo 1\nbar 2\n"
+	errorsPrev := errorsCounter.Get()
+	err := sw.scrapeInternal(1700000000000, 1700000000000)
+	if !errors.Is(err, errRequiredMetricsMissing) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errRequiredMetricsMissing)
+	}
+	if reason := getScrapeErrorReason(err); reason != "schema_validation" {
+		t.Fatalf("unexpected error reason; got %q; want %q", reason, "schema_validation")
+	}
+	if n := errorsCounter.Get() - errorsPrev; n != 1 {
+		t.Fatalf("unexpected number of schema_validation errors; got %d; want 1", n)
+	}
+	if up != 0 {
+		t.Fatalf("unexpected up value; got %v; want 0", up)
+	}
+	for _, name := range names {
+		if name == "foo" || name == "bar" {
+			t.Fatalf("unexpected metric %q ingested from response without required metrics; ingested metrics: %q", name, names)
+		}
+	}
+
+	// The response with the required metric must be ingested.
+	body = "foo 1\nprocess_start_time_seconds 1.7e9\n"
+	names = nil
+	if err := sw.scrapeInternal(1700000010000, 1700000010000); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if up != 1 {
+		t.Fatalf("unexpected up value; got %v; want 1", up)
+	}
+	if fmt.Sprintf("%q", names[:2]) != `["foo" "process_start_time_seconds"]` {
+		t.Fatalf("unexpected ingested metrics: %q", names)
+	}
+}
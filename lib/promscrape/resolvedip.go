@@ -0,0 +1,120 @@
+package promscrape
+
+import (
+	"context"
+	"flag"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var resolvedIPCacheTTL = flag.Duration("promscrape.resolvedIPCacheTTL", time.Minute, "The duration for caching the resolved IP addresses for targets "+
+	"with `__resolved_ip__` label referred in `relabel_configs`")
+
+// resolvedIPLabel is a meta label containing the IP address for the host from `__address__` label.
+//
+// It is set only if it is referred in `source_labels` of `relabel_configs`, since it requires DNS lookups.
+const resolvedIPLabel = "__resolved_ip__"
+
+// needResolvedIP returns true if prcs refer to resolvedIPLabel.
+func needResolvedIP(prcs []promrelabel.ParsedRelabelConfig) bool {
+	for i := range prcs {
+		for _, label := range prcs[i].SourceLabels {
+			if label == resolvedIPLabel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getResolvedIP returns the first IP address for the host from the given address in `host:port` or `host` form.
+//
+// Empty string is returned if the host cannot be resolved.
+// Results are cached for -promscrape.resolvedIPCacheTTL in order to reduce DNS load on service discovery updates.
+func getResolvedIP(address string) string {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return resolvedIPCacheGlobal.get(host)
+}
+
+type resolvedIPCache struct {
+	mu sync.Mutex
+	m  map[string]resolvedIPEntry
+
+	lastCleanupTime uint64
+}
+
+type resolvedIPEntry struct {
+	ip       string
+	deadline uint64
+}
+
+func (rc *resolvedIPCache) get(host string) string {
+	currentTime := fasttime.UnixTimestamp()
+	rc.mu.Lock()
+	e, ok := rc.m[host]
+	rc.mu.Unlock()
+	if ok && currentTime < e.deadline {
+		return e.ip
+	}
+
+	// Negative results are cached too, so unresolvable hosts do not generate DNS requests on every call.
+	ip := lookupIP(host)
+	resolvedIPLookups.Inc()
+	rc.mu.Lock()
+	rc.m[host] = resolvedIPEntry{
+		ip:       ip,
+		deadline: currentTime + uint64(resolvedIPCacheTTL.Seconds()),
+	}
+	if currentTime-rc.lastCleanupTime > 60 {
+		for k, v := range rc.m {
+			if currentTime >= v.deadline {
+				delete(rc.m, k)
+			}
+		}
+		rc.lastCleanupTime = currentTime
+	}
+	rc.mu.Unlock()
+	return ip
+}
+
+var resolvedIPCacheGlobal = &resolvedIPCache{
+	m: make(map[string]resolvedIPEntry),
+}
+
+// lookupIPAddr may be overridden in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+func lookupIP(host string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		resolvedIPLookupErrors.Inc()
+		return ""
+	}
+	for _, ip := range ips {
+		if ip.IP.To4() != nil || netutil.TCP6Enabled() {
+			return ip.IP.String()
+		}
+	}
+	return ""
+}
+
+var (
+	resolvedIPLookups      = metrics.NewCounter(`vm_promscrape_resolved_ip_lookups_total`)
+	resolvedIPLookupErrors = metrics.NewCounter(`vm_promscrape_resolved_ip_lookup_errors_total`)
+)
@@ -0,0 +1,102 @@
+package promscrape
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// schemeAutoClient reads data from targets with `scheme: auto`.
+//
+// It scrapes the target via https and falls back to http if the target doesn't speak TLS.
+// Certificate verification errors do not result in the fallback, since this would silently downgrade the scrape to plaintext.
+// The working scheme is cached, so the target is scraped via http without https attempts until http scrape fails.
+type schemeAutoClient struct {
+	https *client
+	http  *client
+
+	// useHTTP is set to 1 if the target must be scraped via http.
+	useHTTP uint32
+}
+
+// newSchemeAutoClient returns schemeAutoClient for sw with `scheme: auto`.
+//
+// The https client c must be already created for sw.
+func newSchemeAutoClient(sw *ScrapeWork, c *client) *schemeAutoClient {
+	swCopy := *sw
+	swCopy.ScrapeURL = "http://" + strings.TrimPrefix(sw.ScrapeURL, "https://")
+	hc := newClient(&swCopy)
+	hc.phaseTimings = c.phaseTimings
+	hc.intervalHint = c.intervalHint
+	return &schemeAutoClient{
+		https: c,
+		http:  hc,
+	}
+}
+
+// scrapeURL returns the url for the working scheme.
+func (sac *schemeAutoClient) scrapeURL() string {
+	if atomic.LoadUint32(&sac.useHTTP) != 0 {
+		return sac.http.scrapeURL
+	}
+	return sac.https.scrapeURL
+}
+
+func (sac *schemeAutoClient) ReadData(dst []byte) ([]byte, error) {
+	if atomic.LoadUint32(&sac.useHTTP) != 0 {
+		dst, err := sac.http.ReadData(dst)
+		if err != nil && err != errNotModified {
+			// The target may be switched to https. Try https on the next scrape.
+			atomic.StoreUint32(&sac.useHTTP, 0)
+		}
+		return dst, err
+	}
+	dstLen := len(dst)
+	dst, err := sac.https.ReadData(dst)
+	if err == nil || !isNonTLSServerError(err) {
+		return dst, err
+	}
+	dstHTTP, errHTTP := sac.http.ReadData(dst[:dstLen])
+	if errHTTP != nil {
+		// Return the original error, since the target may be https target with misconfigured TLS.
+		return dst[:dstLen], err
+	}
+	schemeAutoFallbacks.Inc()
+	atomic.StoreUint32(&sac.useHTTP, 1)
+	return dstHTTP, nil
+}
+
+func (sac *schemeAutoClient) GetStreamReader() (*streamReader, error) {
+	if atomic.LoadUint32(&sac.useHTTP) != 0 {
+		sr, err := sac.http.GetStreamReader()
+		if err != nil {
+			atomic.StoreUint32(&sac.useHTTP, 0)
+		}
+		return sr, err
+	}
+	sr, err := sac.https.GetStreamReader()
+	if err == nil || !isNonTLSServerError(err) {
+		return sr, err
+	}
+	srHTTP, errHTTP := sac.http.GetStreamReader()
+	if errHTTP != nil {
+		return nil, err
+	}
+	schemeAutoFallbacks.Inc()
+	atomic.StoreUint32(&sac.useHTTP, 1)
+	return srHTTP, nil
+}
+
+var schemeAutoFallbacks = metrics.NewCounter(`vm_promscrape_scheme_auto_fallbacks_total`)
+
+// isNonTLSServerError returns true if err is returned by server, which doesn't speak TLS.
+//
+// Other TLS errors such as certificate verification errors mustn't result in http fallback,
+// since they may be caused by man-in-the-middle attack.
+func isNonTLSServerError(err error) bool {
+	var rhe tls.RecordHeaderError
+	return errors.As(err, &rhe)
+}
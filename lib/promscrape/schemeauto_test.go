@@ -0,0 +1,217 @@
+package promscrape
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkSchemeAuto(t *testing.T) {
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "requests{server=%q} 1\n", name)
+		}
+	}
+	getHost := func(s *httptest.Server) string {
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s.URL, err)
+		}
+		return u.Host
+	}
+	sTLS := httptest.NewTLSServer(handler("tls"))
+	defer sTLS.Close()
+	sPlain := httptest.NewServer(handler("plain"))
+	defer sPlain.Close()
+	hostTLS := getHost(sTLS)
+	hostPlain := getHost(sPlain)
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: mixed
+  scheme: auto
+  tls_config:
+    insecure_skip_verify: true
+  static_configs:
+  - targets: [%q, %q]
+`, hostTLS, hostPlain)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+
+	f := func(sw *ScrapeWork, serverExpected, scrapeURLExpected string, fallbacksExpected uint64) {
+		t.Helper()
+		if !sw.SchemeAuto {
+			t.Fatalf("expecting SchemeAuto for %q", sw.ScrapeURL)
+		}
+		if sw.ScrapeURL != "https://"+promrelabel.GetLabelValueByName(sw.Labels, "instance")+"/metrics" {
+			t.Fatalf("unexpected ScrapeURL: %q", sw.ScrapeURL)
+		}
+		server := ""
+		sc := newScraper(sw, "test", func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "requests" {
+					server = promrelabel.GetLabelValueByName(ts.Labels, "server")
+				}
+			}
+		})
+		fallbacksStart := schemeAutoFallbacks.Get()
+		// The working scheme must be detected on the first scrape and then re-used on subsequent scrapes.
+		for i := 0; i < 3; i++ {
+			server = ""
+			timestamp := int64(123000)
+			if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if server != serverExpected {
+				t.Fatalf("unexpected server; got %q; want %q", server, serverExpected)
+			}
+			if scrapeURL := sc.sw.getStatusConfig().ScrapeURL; scrapeURL != scrapeURLExpected {
+				t.Fatalf("unexpected scrape url in target status; got %q; want %q", scrapeURL, scrapeURLExpected)
+			}
+		}
+		if n := schemeAutoFallbacks.Get() - fallbacksStart; n != fallbacksExpected {
+			t.Fatalf("unexpected number of fallbacks to http; got %d; want %d", n, fallbacksExpected)
+		}
+	}
+	f(&sws[0], "tls", "https://"+hostTLS+"/metrics", 0)
+	f(&sws[1], "plain", "http://"+hostPlain+"/metrics", 1)
+}
+
+func TestScrapeWorkSchemeAutoInvalidCertificate(t *testing.T) {
+	var requests uint64
+	sTLS := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer sTLS.Close()
+
+	// The server accepts both https and http connections on the same port, while its certificate is self-signed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start listener: %s", err)
+	}
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddUint64(&requests, 1)
+			fmt.Fprintf(w, "foo 1\n")
+		}),
+	}
+	go func() {
+		_ = s.Serve(&tlsSniffListener{
+			Listener:  ln,
+			tlsConfig: sTLS.TLS,
+		})
+	}()
+	defer s.Close()
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: self-signed
+  scheme: auto
+  static_configs:
+  - targets: [%q]
+`, ln.Addr().String())
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+	fallbacksStart := schemeAutoFallbacks.Get()
+	timestamp := int64(123000)
+	if err := sc.sw.scrapeInternal(timestamp, timestamp); err == nil {
+		t.Fatalf("expecting non-nil error for target with self-signed certificate")
+	}
+	if n := schemeAutoFallbacks.Get() - fallbacksStart; n != 0 {
+		t.Fatalf("unexpected number of fallbacks to http; got %d; want 0", n)
+	}
+	if n := atomic.LoadUint64(&requests); n != 0 {
+		t.Fatalf("unexpected number of requests; got %d; want 0", n)
+	}
+}
+
+// tlsSniffListener serves TLS for connections starting with TLS handshake record and plain http for the remaining connections.
+type tlsSniffListener struct {
+	net.Listener
+	tlsConfig *tls.Config
+}
+
+func (ln *tlsSniffListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(c)
+	b, err := br.Peek(1)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	bc := &bufferedConn{
+		Conn: c,
+		br:   br,
+	}
+	if b[0] == 0x16 {
+		return tls.Server(bc, ln.tlsConfig), nil
+	}
+	return bc, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.br.Read(p)
+}
+
+func TestGetStaticScrapeWorkSchemeAutoInvalidTargets(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		sws, err := getStaticScrapeWork([]byte(data), "non-existing-file")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(sws) != 0 {
+			t.Fatalf("expecting the target to be skipped; got %d scrape works", len(sws))
+		}
+	}
+
+	// Missing port
+	f(`
+scrape_configs:
+- job_name: x
+  scheme: auto
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// __scheme__ set to auto via relabeling for target with __addresses__
+	f(`
+scrape_configs:
+- job_name: x
+  relabel_configs:
+  - target_label: __scheme__
+    replacement: auto
+  static_configs:
+  - targets: ["foo:1234"]
+    labels:
+      __addresses__: "foo-1:1234,foo-2:1234"
+`)
+}
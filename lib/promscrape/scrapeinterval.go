@@ -0,0 +1,104 @@
+package promscrape
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+// getScrapeIntervalTimeout returns scrape_interval and scrape_timeout for sc.
+//
+// Job-level values take precedence over values from `global` section, which take precedence over defaultScrapeInterval and defaultScrapeTimeout.
+// scrape_timeout isn't limited by scrape_interval, but a warning is logged if explicitly set scrape_timeout exceeds scrape_interval,
+// since slow targets may miss scrapes in this case.
+func getScrapeIntervalTimeout(sc *ScrapeConfig, globalCfg *GlobalConfig) (time.Duration, time.Duration) {
+	scrapeInterval := sc.ScrapeInterval
+	if scrapeInterval <= 0 {
+		scrapeInterval = globalCfg.ScrapeInterval
+		if scrapeInterval <= 0 {
+			scrapeInterval = defaultScrapeInterval
+		}
+	}
+	scrapeTimeout := sc.ScrapeTimeout
+	if scrapeTimeout <= 0 {
+		scrapeTimeout = globalCfg.ScrapeTimeout
+		if scrapeTimeout <= 0 {
+			scrapeTimeout = defaultScrapeTimeout
+		}
+	}
+	if scrapeTimeout > scrapeInterval && (sc.ScrapeTimeout > 0 || globalCfg.ScrapeTimeout > 0) {
+		logger.Warnf("`scrape_timeout`=%s exceeds `scrape_interval`=%s for `job_name` %q; slow targets may miss scrapes; "+
+			"consider reducing `scrape_timeout` or increasing `scrape_interval`", scrapeTimeout, scrapeInterval, sc.JobName)
+	}
+	return scrapeInterval, scrapeTimeout
+}
+
+// getScrapeTimeoutOffset returns the offset to subtract from scrapeTimeout when limiting the duration of scrape requests.
+//
+// offset is the explicitly set `scrape_timeout_offset`. defaultScrapeTimeoutOffset capped by 10% of scrapeTimeout is used if it is nil.
+func getScrapeTimeoutOffset(offset *time.Duration, scrapeTimeout time.Duration) (time.Duration, error) {
+	if offset == nil {
+		scrapeTimeoutOffset := defaultScrapeTimeoutOffset
+		if scrapeTimeoutOffset > scrapeTimeout/10 {
+			scrapeTimeoutOffset = scrapeTimeout / 10
+		}
+		return scrapeTimeoutOffset, nil
+	}
+	if *offset < 0 {
+		return 0, fmt.Errorf("`scrape_timeout_offset` cannot be negative; got %s", *offset)
+	}
+	if *offset >= scrapeTimeout {
+		return 0, fmt.Errorf("`scrape_timeout_offset` must be smaller than `scrape_timeout`; got %s vs %s", *offset, scrapeTimeout)
+	}
+	return *offset, nil
+}
+
+// getTargetScrapeIntervalTimeout returns scrape interval, timeout and timeout offset for the target with the given labels after relabeling.
+//
+// `__scrape_interval__` and `__scrape_timeout__` labels take precedence over the job-level values from swc.
+// `__scrape_timeout__` exceeding the scrape interval is an error, while the inherited scrape timeout is used as is.
+func getTargetScrapeIntervalTimeout(swc *scrapeWorkConfig, labels []prompbmarshal.Label) (time.Duration, time.Duration, time.Duration, error) {
+	scrapeInterval := swc.scrapeInterval
+	scrapeTimeout := swc.scrapeTimeout
+	intervalLabel := promrelabel.GetLabelValueByName(labels, "__scrape_interval__")
+	timeoutLabel := promrelabel.GetLabelValueByName(labels, "__scrape_timeout__")
+	if intervalLabel == "" && timeoutLabel == "" {
+		return scrapeInterval, scrapeTimeout, swc.scrapeTimeoutOffset, nil
+	}
+	if intervalLabel != "" {
+		d, err := parsePositiveDurationLabel("__scrape_interval__", intervalLabel)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		scrapeInterval = d
+	}
+	if timeoutLabel != "" {
+		d, err := parsePositiveDurationLabel("__scrape_timeout__", timeoutLabel)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if d > scrapeInterval {
+			return 0, 0, 0, fmt.Errorf("`__scrape_timeout__`=%s cannot exceed scrape interval %s", d, scrapeInterval)
+		}
+		scrapeTimeout = d
+	}
+	scrapeTimeoutOffset, err := getScrapeTimeoutOffset(swc.timeoutOffsetCfg, scrapeTimeout)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return scrapeInterval, scrapeTimeout, scrapeTimeoutOffset, nil
+}
+
+func parsePositiveDurationLabel(labelName, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse `%s` label: %w", labelName, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("`%s` label must be positive; got %s", labelName, d)
+	}
+	return d, nil
+}
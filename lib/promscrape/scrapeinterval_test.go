@@ -0,0 +1,235 @@
+package promscrape
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScrapeIntervalTimeoutPrecedence(t *testing.T) {
+	f := func(data string, intervalExpected, timeoutExpected, offsetExpected time.Duration) {
+		t.Helper()
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sw := &sws[0]
+		if sw.ScrapeInterval != intervalExpected {
+			t.Fatalf("unexpected scrape interval; got %s; want %s", sw.ScrapeInterval, intervalExpected)
+		}
+		if sw.ScrapeTimeout != timeoutExpected {
+			t.Fatalf("unexpected scrape timeout; got %s; want %s", sw.ScrapeTimeout, timeoutExpected)
+		}
+		if sw.ScrapeTimeoutOffset != offsetExpected {
+			t.Fatalf("unexpected scrape timeout offset; got %s; want %s", sw.ScrapeTimeoutOffset, offsetExpected)
+		}
+	}
+
+	// Hardcoded defaults are used if neither global nor job-level values are set.
+	f(`
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+`, defaultScrapeInterval, defaultScrapeTimeout, defaultScrapeTimeoutOffset)
+
+	// Global values override hardcoded defaults.
+	f(`
+global:
+  scrape_interval: 30s
+  scrape_timeout: 5s
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+`, 30*time.Second, 5*time.Second, defaultScrapeTimeoutOffset)
+
+	// The default timeout isn't limited by global scrape_interval.
+	f(`
+global:
+  scrape_interval: 5s
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+`, 5*time.Second, defaultScrapeTimeout, defaultScrapeTimeoutOffset)
+
+	// Global scrape_timeout exceeding global scrape_interval isn't limited by it.
+	f(`
+global:
+  scrape_interval: 10s
+  scrape_timeout: 20s
+scrape_configs:
+- job_name: x
+  scrape_interval: 1m
+  static_configs:
+  - targets: ["foo"]
+`, time.Minute, 20*time.Second, defaultScrapeTimeoutOffset)
+
+	// Job-level scrape_interval overrides global value, while scrape_timeout is inherited from global section.
+	f(`
+global:
+  scrape_interval: 30s
+  scrape_timeout: 5s
+scrape_configs:
+- job_name: x
+  scrape_interval: 20s
+  static_configs:
+  - targets: ["foo"]
+`, 20*time.Second, 5*time.Second, defaultScrapeTimeoutOffset)
+
+	// Job-level values override global values.
+	f(`
+global:
+  scrape_interval: 30s
+  scrape_timeout: 5s
+scrape_configs:
+- job_name: x
+  scrape_interval: 20s
+  scrape_timeout: 15s
+  static_configs:
+  - targets: ["foo"]
+`, 20*time.Second, 15*time.Second, defaultScrapeTimeoutOffset)
+
+	// The inherited scrape_timeout isn't limited by job-level scrape_interval.
+	f(`
+global:
+  scrape_timeout: 30s
+scrape_configs:
+- job_name: x
+  scrape_interval: 500ms
+  static_configs:
+  - targets: ["foo"]
+`, 500*time.Millisecond, 30*time.Second, defaultScrapeTimeoutOffset)
+
+	// Job-level scrape_timeout exceeding job-level scrape_interval isn't limited by it.
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_interval: 5s
+  scrape_timeout: 8s
+  static_configs:
+  - targets: ["foo"]
+`, 5*time.Second, 8*time.Second, defaultScrapeTimeoutOffset)
+
+	// `__scrape_interval__` set via relabeling overrides job-level scrape_interval, while scrape_timeout is inherited.
+	f(`
+global:
+  scrape_interval: 30s
+scrape_configs:
+- job_name: x
+  scrape_interval: 20s
+  scrape_timeout: 15s
+  relabel_configs:
+  - target_label: __scrape_interval__
+    replacement: 2m
+  static_configs:
+  - targets: ["foo"]
+`, 2*time.Minute, 15*time.Second, defaultScrapeTimeoutOffset)
+
+	// `__scrape_interval__` and `__scrape_timeout__` set via relabeling override job-level and global values.
+	f(`
+global:
+  scrape_interval: 30s
+  scrape_timeout: 5s
+scrape_configs:
+- job_name: x
+  scrape_interval: 20s
+  scrape_timeout: 15s
+  relabel_configs:
+  - target_label: __scrape_interval__
+    replacement: 40s
+  - target_label: __scrape_timeout__
+    replacement: 30s
+  static_configs:
+  - targets: ["foo"]
+`, 40*time.Second, 30*time.Second, defaultScrapeTimeoutOffset)
+
+	// The inherited scrape_timeout isn't limited by `__scrape_interval__`.
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_interval: 20s
+  scrape_timeout: 15s
+  relabel_configs:
+  - target_label: __scrape_interval__
+    replacement: 500ms
+  static_configs:
+  - targets: ["foo"]
+`, 500*time.Millisecond, 15*time.Second, defaultScrapeTimeoutOffset)
+
+	// Explicitly set scrape_timeout_offset is preserved for `__scrape_timeout__`.
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_timeout_offset: 1s
+  relabel_configs:
+  - target_label: __scrape_timeout__
+    replacement: 3s
+  static_configs:
+  - targets: ["foo"]
+`, defaultScrapeInterval, 3*time.Second, time.Second)
+}
+
+func TestScrapeIntervalTimeoutLabelsInvalid(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		// Targets with invalid `__scrape_interval__` or `__scrape_timeout__` must be skipped.
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 0 {
+			t.Fatalf("unexpected non-empty sws:\n%#v", sws)
+		}
+	}
+
+	// Invalid __scrape_interval__
+	f(`
+scrape_configs:
+- job_name: x
+  static_configs:
+  - targets: ["foo"]
+    labels:
+      __scrape_interval__: foo
+`)
+
+	// Non-positive __scrape_timeout__
+	f(`
+scrape_configs:
+- job_name: x
+  relabel_configs:
+  - target_label: __scrape_timeout__
+    replacement: 0s
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// __scrape_timeout__ exceeding scrape interval
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_interval: 10s
+  relabel_configs:
+  - target_label: __scrape_timeout__
+    replacement: 20s
+  static_configs:
+  - targets: ["foo"]
+`)
+
+	// scrape_timeout_offset exceeding __scrape_timeout__
+	f(`
+scrape_configs:
+- job_name: x
+  scrape_timeout_offset: 5s
+  relabel_configs:
+  - target_label: __scrape_timeout__
+    replacement: 3s
+  static_configs:
+  - targets: ["foo"]
+`)
+}
@@ -0,0 +1,134 @@
+package promscrape
+
+import (
+	"flag"
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var maxConcurrentScrapes = flag.Int("promscrape.maxConcurrentScrapes", 0, "The maximum number of concurrent scrapes across all the targets. "+
+	"Pending scrapes are queued until the in-flight scrapes complete. Scrapes for targets with bigger `priority` from scrape_config are started first. "+
+	"This may be useful for limiting resource usage on vmagent with big number of targets. By default the number of concurrent scrapes isn't limited")
+
+// scrapeLimiter limits the number of concurrent scrapes.
+//
+// Pending scrapes obtain free slots in the order of their priority. Scrapes with the same priority obtain free slots in FIFO order.
+type scrapeLimiter struct {
+	mu sync.Mutex
+
+	// maxConcurrency is the maximum number of concurrent scrapes.
+	maxConcurrency int
+
+	// concurrency is the number of in-flight scrapes.
+	concurrency int
+
+	// waiters contains pending scrapes sorted by priority in descending order.
+	waiters []*scrapeWaiter
+}
+
+type scrapeWaiter struct {
+	priority int
+
+	// ch is closed when the slot is passed to the waiter.
+	ch chan struct{}
+}
+
+func newScrapeLimiter(maxConcurrency int) *scrapeLimiter {
+	return &scrapeLimiter{
+		maxConcurrency: maxConcurrency,
+	}
+}
+
+var (
+	scrapeLimiterGlobal     *scrapeLimiter
+	scrapeLimiterGlobalOnce sync.Once
+)
+
+// getScrapeLimiter returns the limiter for the number of concurrent scrapes. Nil is returned if -promscrape.maxConcurrentScrapes isn't set.
+func getScrapeLimiter() *scrapeLimiter {
+	scrapeLimiterGlobalOnce.Do(func() {
+		if *maxConcurrentScrapes <= 0 {
+			return
+		}
+		sl := newScrapeLimiter(*maxConcurrentScrapes)
+		metrics.NewGauge(`vm_promscrape_scrapes_pending`, func() float64 {
+			return float64(sl.pendingScrapes())
+		})
+		scrapeLimiterGlobal = sl
+	})
+	return scrapeLimiterGlobal
+}
+
+// acquire waits until a slot for the scrape with the given priority is available. It is no-op if sl is nil.
+//
+// False is returned if stopCh is closed before the slot is obtained. The pending scrape is removed from the queue then.
+// Otherwise release must be called when the scrape is finished.
+func (sl *scrapeLimiter) acquire(priority int, stopCh <-chan struct{}) bool {
+	if sl == nil {
+		return true
+	}
+	sl.mu.Lock()
+	if sl.concurrency < sl.maxConcurrency && len(sl.waiters) == 0 {
+		sl.concurrency++
+		sl.mu.Unlock()
+		return true
+	}
+	w := &scrapeWaiter{
+		priority: priority,
+		ch:       make(chan struct{}),
+	}
+	// Put w after the waiters with the same or bigger priority.
+	n := len(sl.waiters)
+	for n > 0 && sl.waiters[n-1].priority < priority {
+		n--
+	}
+	sl.waiters = append(sl.waiters, nil)
+	copy(sl.waiters[n+1:], sl.waiters[n:])
+	sl.waiters[n] = w
+	sl.mu.Unlock()
+
+	select {
+	case <-w.ch:
+		return true
+	case <-stopCh:
+	}
+	sl.mu.Lock()
+	for i, x := range sl.waiters {
+		if x == w {
+			copy(sl.waiters[i:], sl.waiters[i+1:])
+			sl.waiters[len(sl.waiters)-1] = nil
+			sl.waiters = sl.waiters[:len(sl.waiters)-1]
+			sl.mu.Unlock()
+			return false
+		}
+	}
+	sl.mu.Unlock()
+	// The slot has been passed to w concurrently with stopCh closing. Pass it to the next pending scrape.
+	sl.release()
+	return false
+}
+
+// release releases the slot obtained via acquire. The slot is passed to the pending scrape with the biggest priority if any.
+func (sl *scrapeLimiter) release() {
+	if sl == nil {
+		return
+	}
+	sl.mu.Lock()
+	if len(sl.waiters) > 0 {
+		w := sl.waiters[0]
+		sl.waiters[0] = nil
+		sl.waiters = sl.waiters[1:]
+		close(w.ch)
+	} else {
+		sl.concurrency--
+	}
+	sl.mu.Unlock()
+}
+
+func (sl *scrapeLimiter) pendingScrapes() int {
+	sl.mu.Lock()
+	n := len(sl.waiters)
+	sl.mu.Unlock()
+	return n
+}
@@ -0,0 +1,167 @@
+package promscrape
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestScrapeLimiterPriority(t *testing.T) {
+	sl := newScrapeLimiter(1)
+
+	var mu sync.Mutex
+	var scraped []string
+	blockerStarted := make(chan struct{})
+	blockerRelease := make(chan struct{})
+	newScrapeWork := func(name string, priority int) *scrapeWork {
+		sw := &scrapeWork{
+			limiter: sl,
+		}
+		sw.Config = ScrapeWork{
+			ID:        atomic.AddUint64(&nextScrapeWorkID, 1),
+			ScrapeURL: fmt.Sprintf("http://%s/metrics", name),
+			Priority:  priority,
+		}
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			if name == "blocker" {
+				close(blockerStarted)
+				<-blockerRelease
+			}
+			mu.Lock()
+			scraped = append(scraped, name)
+			mu.Unlock()
+			return append(dst, "foo 1\n"...), nil
+		}
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {}
+		return sw
+	}
+	var wg sync.WaitGroup
+	scrape := func(sw *scrapeWork) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer tsmGlobal.Unregister(&sw.Config)
+			timestamp, ok := sw.acquireScrapeSlot(time.Now().UnixNano()/1e6, nil)
+			if !ok {
+				t.Errorf("cannot acquire scrape slot for %s", sw.Config.ScrapeURL)
+				return
+			}
+			sw.scrapeAndLogError(timestamp, timestamp)
+			sw.limiter.release()
+		}()
+	}
+	waitPending := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for sl.pendingScrapes() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout when waiting for %d pending scrapes; got %d", n, sl.pendingScrapes())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The blocker occupies the only slot, so the remaining scrapes must wait.
+	scrape(newScrapeWork("blocker", 0))
+	<-blockerStarted
+	scrape(newScrapeWork("low-1", -1))
+	waitPending(1)
+	scrape(newScrapeWork("default", 0))
+	waitPending(2)
+	scrape(newScrapeWork("high", 10))
+	waitPending(3)
+	scrape(newScrapeWork("low-2", -1))
+	waitPending(4)
+	mu.Lock()
+	scrapedBeforeRelease := append([]string{}, scraped...)
+	mu.Unlock()
+	if len(scrapedBeforeRelease) != 0 {
+		t.Fatalf("unexpected scrapes while the slot is occupied: %s", scrapedBeforeRelease)
+	}
+
+	// Pending scrapes must obtain the slot in the order of their priority and then in FIFO order.
+	close(blockerRelease)
+	wg.Wait()
+	scrapedExpected := []string{"blocker", "high", "default", "low-1", "low-2"}
+	if !reflect.DeepEqual(scraped, scrapedExpected) {
+		t.Fatalf("unexpected scrape order; got %s; want %s", scraped, scrapedExpected)
+	}
+	if n := sl.pendingScrapes(); n != 0 {
+		t.Fatalf("unexpected number of pending scrapes; got %d; want 0", n)
+	}
+	if sl.concurrency != 0 {
+		t.Fatalf("unexpected number of in-flight scrapes; got %d; want 0", sl.concurrency)
+	}
+}
+
+func TestScrapeLimiterStop(t *testing.T) {
+	sl := newScrapeLimiter(1)
+	if !sl.acquire(0, nil) {
+		t.Fatalf("cannot acquire free slot")
+	}
+
+	// The pending scrape must be dropped from the queue when stopCh is closed.
+	stopCh := make(chan struct{})
+	resultCh := make(chan bool)
+	go func() {
+		resultCh <- sl.acquire(0, stopCh)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for sl.pendingScrapes() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for pending scrape")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stopCh)
+	select {
+	case ok := <-resultCh:
+		if ok {
+			t.Fatalf("acquire must return false after stopCh is closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout when waiting for acquire to return after stopCh is closed")
+	}
+	if n := sl.pendingScrapes(); n != 0 {
+		t.Fatalf("unexpected number of pending scrapes; got %d; want 0", n)
+	}
+
+	// The released slot mustn't be passed to the dropped scrape.
+	sl.release()
+	if sl.concurrency != 0 {
+		t.Fatalf("unexpected number of in-flight scrapes; got %d; want 0", sl.concurrency)
+	}
+	if !sl.acquire(0, nil) {
+		t.Fatalf("cannot acquire free slot")
+	}
+	sl.release()
+}
+
+func TestScrapeWorkAcquireScrapeSlotTimestamp(t *testing.T) {
+	sl := newScrapeLimiter(1)
+	sw := &scrapeWork{
+		limiter: sl,
+	}
+	if !sl.acquire(0, nil) {
+		t.Fatalf("cannot acquire free slot")
+	}
+	scheduled := time.Now().UnixNano() / 1e6
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		sl.release()
+	}()
+	timestamp, ok := sw.acquireScrapeSlot(scheduled, nil)
+	if !ok {
+		t.Fatalf("cannot acquire scrape slot")
+	}
+	defer sl.release()
+	// The timestamp must be obtained after waiting for the free slot.
+	if d := timestamp - scheduled; d < 200 {
+		t.Fatalf("the timestamp must account for the time spent waiting for the slot; got %dms after the scheduled time", d)
+	}
+}
@@ -0,0 +1,134 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+// ScrapeOptions contains options for ScrapeOnce.
+type ScrapeOptions struct {
+	// JobName is used as `job` label value for the scraped series.
+	//
+	// `scrape_once` is used if JobName is empty.
+	JobName string
+
+	// ScrapeTimeout is the timeout for scraping every url.
+	//
+	// The default scrape_timeout is used if ScrapeTimeout is zero.
+	ScrapeTimeout time.Duration
+
+	// HonorLabels and HonorTimestamps have the same meaning as `honor_labels` and `honor_timestamps` options in `scrape_config`.
+	HonorLabels     bool
+	HonorTimestamps bool
+
+	// MetricRelabelConfigs are applied to the scraped series in the same way as `metric_relabel_configs` in `scrape_config`.
+	MetricRelabelConfigs []promrelabel.ParsedRelabelConfig
+}
+
+// ScrapeResult is the result of scraping a single url by ScrapeOnce.
+type ScrapeResult struct {
+	// URL is the scraped url.
+	URL string
+
+	// Timeseries contains the scraped series including `up` and `scrape_*` series.
+	Timeseries []prompbmarshal.TimeSeries
+
+	// Err contains scrape error if any.
+	Err error
+}
+
+// ScrapeOnce scrapes every url from urls once and returns the scraped series for every url.
+//
+// Scrapes are performed concurrently. The response from every url is read into memory, since stream parsing isn't used by ScrapeOnce.
+// Service discovery isn't performed and scraped targets aren't shown at `/targets` page.
+// An error is returned only for invalid urls, while scrape errors are returned in ScrapeResult.Err.
+func ScrapeOnce(urls []string, opts ScrapeOptions) ([]ScrapeResult, error) {
+	jobName := opts.JobName
+	if jobName == "" {
+		jobName = "scrape_once"
+	}
+	scrapeTimeout := opts.ScrapeTimeout
+	if scrapeTimeout <= 0 {
+		scrapeTimeout = defaultScrapeTimeout
+	}
+	sws := make([]ScrapeWork, len(urls))
+	for i, scrapeURL := range urls {
+		u, err := url.Parse(scrapeURL)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse url %q: %w", scrapeURL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("unsupported scheme in url %q: %q; supported schemes: http, https", scrapeURL, u.Scheme)
+		}
+		labels := []prompbmarshal.Label{
+			{
+				Name:  "instance",
+				Value: u.Host,
+			},
+			{
+				Name:  "job",
+				Value: jobName,
+			},
+		}
+		sws[i] = ScrapeWork{
+			ID:                   atomic.AddUint64(&nextScrapeWorkID, 1),
+			ScrapeURL:            scrapeURL,
+			ScrapeInterval:       scrapeTimeout,
+			ScrapeTimeout:        scrapeTimeout,
+			HonorLabels:          opts.HonorLabels,
+			HonorTimestamps:      opts.HonorTimestamps,
+			Labels:               labels,
+			AuthConfig:           &promauth.Config{},
+			MetricRelabelConfigs: opts.MetricRelabelConfigs,
+			jobNameOriginal:      jobName,
+		}
+	}
+
+	results := make([]ScrapeResult, len(sws))
+	var wg sync.WaitGroup
+	for i := range sws {
+		wg.Add(1)
+		go func(sw *ScrapeWork, result *ScrapeResult) {
+			defer wg.Done()
+			*result = scrapeOnce(sw)
+		}(&sws[i], &results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func scrapeOnce(cfg *ScrapeWork) ScrapeResult {
+	var tss []prompbmarshal.TimeSeries
+	c := newClient(cfg)
+	sw := &scrapeWork{
+		Config:             *cfg,
+		ReadData:           c.ReadData,
+		ReadAdditionalData: c.ReadAdditionalData,
+		PushData: func(wr *prompbmarshal.WriteRequest) {
+			// wr is re-used after the return, so its contents must be copied.
+			for _, ts := range wr.Timeseries {
+				tss = append(tss, prompbmarshal.TimeSeries{
+					Labels:  append([]prompbmarshal.Label{}, ts.Labels...),
+					Samples: append([]prompbmarshal.Sample{}, ts.Samples...),
+				})
+			}
+		},
+		ScrapeGroup:        "scrape_once",
+		skipTargetStatus:   true,
+		disableStreamParse: true,
+	}
+	timestamp := time.Now().UnixNano() / 1e6
+	err := sw.scrapeInternal(timestamp, timestamp)
+	return ScrapeResult{
+		URL:        cfg.ScrapeURL,
+		Timeseries: tss,
+		Err:        err,
+	}
+}
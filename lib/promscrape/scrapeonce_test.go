@@ -0,0 +1,144 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeOnce(t *testing.T) {
+	newServer := func(data string) (*httptest.Server, string) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s", data)
+		}))
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s.URL, err)
+		}
+		return s, u.Host
+	}
+	s1, host1 := newServer("foo{bar=\"baz\"} 1\nxxx 10\n")
+	defer s1.Close()
+	s2, host2 := newServer("foo 2\nsecret 3\n")
+	defer s2.Close()
+
+	prcs, err := promrelabel.ParseRelabelConfigs(nil, []promrelabel.RelabelConfig{{
+		Action:       "drop",
+		SourceLabels: []string{"__name__"},
+		Regex:        strPtr("secret|xxx"),
+	}})
+	if err != nil {
+		t.Fatalf("cannot parse relabel configs: %s", err)
+	}
+	urls := []string{s1.URL + "/metrics", s2.URL + "/metrics"}
+	results, err := ScrapeOnce(urls, ScrapeOptions{
+		JobName:              "once",
+		MetricRelabelConfigs: prcs,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(results) != len(urls) {
+		t.Fatalf("unexpected number of results; got %d; want %d", len(results), len(urls))
+	}
+	f := func(result *ScrapeResult, url, host, dataExpected string) {
+		t.Helper()
+		if result.URL != url {
+			t.Fatalf("unexpected url; got %q; want %q", result.URL, url)
+		}
+		if result.Err != nil {
+			t.Fatalf("unexpected error when scraping %q: %s", url, result.Err)
+		}
+		// Reset timestamps, since they depend on the current time.
+		tss := result.Timeseries
+		for i := range tss {
+			for j := range tss[i].Samples {
+				if tss[i].Samples[j].Timestamp <= 0 {
+					t.Fatalf("unexpected timestamp for %s: %d", timeseriesToString(&tss[i]), tss[i].Samples[j].Timestamp)
+				}
+				tss[i].Samples[j].Timestamp = 123000
+			}
+		}
+		dataExpected = strings.ReplaceAll(dataExpected, "HOST", host)
+		timeseriesExpected := parseData(dataExpected)
+		if err := expectEqualTimeseries(tss, timeseriesExpected); err != nil {
+			t.Fatalf("unexpected data scraped from %q: %s\ngot\n%v\nwant\n%v", url, err, tss, timeseriesExpected)
+		}
+	}
+	f(&results[0], urls[0], host1, `
+		foo{bar="baz",instance="HOST",job="once"} 1 123
+		up{instance="HOST",job="once"} 1 123
+		scrape_samples_scraped{instance="HOST",job="once"} 2 123
+		scrape_duration_seconds{instance="HOST",job="once"} 0 123
+		scrape_samples_post_metric_relabeling{instance="HOST",job="once"} 1 123
+		scrape_series_added{instance="HOST",job="once"} 1 123
+		scrape_body_size_bytes{instance="HOST",job="once"} 24 123
+`)
+	f(&results[1], urls[1], host2, `
+		foo{instance="HOST",job="once"} 2 123
+		up{instance="HOST",job="once"} 1 123
+		scrape_samples_scraped{instance="HOST",job="once"} 2 123
+		scrape_duration_seconds{instance="HOST",job="once"} 0 123
+		scrape_samples_post_metric_relabeling{instance="HOST",job="once"} 1 123
+		scrape_series_added{instance="HOST",job="once"} 1 123
+		scrape_body_size_bytes{instance="HOST",job="once"} 15 123
+`)
+
+	// One-shot scrapes mustn't be shown at /targets page.
+	if n := tsmGlobal.StatusByGroup("scrape_once", true) + tsmGlobal.StatusByGroup("scrape_once", false); n != 0 {
+		t.Fatalf("unexpected number of target statuses registered for one-shot scrapes; got %d; want 0", n)
+	}
+
+	// Invalid urls
+	if _, err := ScrapeOnce([]string{"foo:bar/metrics"}, ScrapeOptions{}); err == nil {
+		t.Fatalf("expecting non-nil error for invalid url")
+	}
+}
+
+func TestScrapeOnceStreamParse(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+
+	// Unmarshal workers aren't started here, so ScrapeOnce must ignore -promscrape.streamParse.
+	streamParseOrig := *streamParse
+	*streamParse = true
+	defer func() {
+		*streamParse = streamParseOrig
+	}()
+	resultsCh := make(chan []ScrapeResult, 1)
+	go func() {
+		results, err := ScrapeOnce([]string{s.URL + "/metrics"}, ScrapeOptions{})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		resultsCh <- results
+	}()
+	var results []ScrapeResult
+	select {
+	case results = <-resultsCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout when scraping with -promscrape.streamParse")
+	}
+	if len(results) != 1 {
+		t.Fatalf("unexpected number of results; got %d; want 1", len(results))
+	}
+	if err := results[0].Err; err != nil {
+		t.Fatalf("unexpected scrape error: %s", err)
+	}
+	tss := results[0].Timeseries
+	if len(tss) == 0 || !strings.HasPrefix(timeseriesToString(&tss[0]), `{__name__="foo",`) {
+		t.Fatalf("missing foo series in the scraped data: %v", tss)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
 	"github.com/VictoriaMetrics/metrics"
+	"github.com/cespare/xxhash/v2"
 )
 
 var (
@@ -37,6 +40,9 @@ var (
 	ec2SDCheckInterval = flag.Duration("promscrape.ec2SDCheckInterval", time.Minute, "Interval for checking for changes in ec2. "+
 		"This works only if `ec2_sd_configs` is configured in '-promscrape.config' file. "+
 		"See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#ec2_sd_config for details")
+	ecsSDCheckInterval = flag.Duration("promscrape.ecsSDCheckInterval", time.Minute, "Interval for checking for changes in ecs. "+
+		"This works only if `ecs_sd_configs` is configured in '-promscrape.config' file. "+
+		"See https://victoriametrics.github.io/vmagent.html#how-to-collect-metrics-in-prometheus-format for details")
 	gceSDCheckInterval = flag.Duration("promscrape.gceSDCheckInterval", time.Minute, "Interval for checking for changes in gce. "+
 		"This works only if `gce_sd_configs` is configured in '-promscrape.config' file. "+
 		"See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#gce_sd_config for details")
@@ -44,9 +50,21 @@ var (
 		"This works only if `dockerswarm_sd_configs` is configured in '-promscrape.config' file. "+
 		"See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#dockerswarm_sd_config for details")
 	promscrapeConfigFile = flag.String("promscrape.config", "", "Optional path to Prometheus config file with 'scrape_configs' section containing targets to scrape. "+
+		"It may point to http or https url; in this case the config is re-fetched every '-promscrape.configCheckInterval'. "+
 		"See https://victoriametrics.github.io/#how-to-scrape-prometheus-exporters-such-as-node-exporter for details")
 	suppressDuplicateScrapeTargetErrors = flag.Bool("promscrape.suppressDuplicateScrapeTargetErrors", false, "Whether to suppress `duplicate scrape target` errors; "+
 		"see https://victoriametrics.github.io/vmagent.html#troubleshooting for details")
+	clusterMembersCount = flag.Int("promscrape.cluster.membersCount", 0, "The number of members in the cluster of vmagent instances, which share scrape targets. "+
+		"Each member scrapes only the targets assigned to it by consistent hashing of target labels. "+
+		"By default all the targets are scraped by every instance. See also -promscrape.cluster.memberNum")
+	clusterMemberNum = flag.Int("promscrape.cluster.memberNum", 0, "The number of vmagent instance in the cluster of scrapers. "+
+		"It must be in the range 0 ... -promscrape.cluster.membersCount-1. Each instance in the cluster must have unique memberNum")
+	clusterReplicationFactor = flag.Int("promscrape.cluster.replicationFactor", 1, "The number of members in the cluster of scrapers, which scrape each target. "+
+		"Values bigger than 1 may be used for HA scraping. The scraped data must be de-duplicated at remote storage in this case. "+
+		"See also -promscrape.cluster.membersCount")
+	maxConcurrentDiscoveryRefreshes = flag.Int("promscrape.discovery.maxConcurrentRefreshes", 0, "The maximum number of concurrent service discovery refreshes "+
+		"across all the scrape configs. Pending refreshes are queued until the in-flight refreshes complete. Scraping of already discovered targets isn't affected. "+
+		"This may be useful for reducing load on service discovery APIs after vmagent restart or config reload. By default the number of concurrent refreshes isn't limited")
 )
 
 // CheckConfig checks -promscrape.config for errors and unsupported options.
@@ -62,14 +80,76 @@ func CheckConfig() error {
 //
 // Scraped data is passed to pushData.
 func Init(pushData func(wr *prompbmarshal.WriteRequest)) {
+	if *clusterMembersCount > 1 && (*clusterMemberNum < 0 || *clusterMemberNum >= *clusterMembersCount) {
+		logger.Fatalf("-promscrape.cluster.memberNum must be in the range 0 ... %d; got %d", *clusterMembersCount-1, *clusterMemberNum)
+	}
+	if *clusterReplicationFactor < 1 {
+		logger.Fatalf("-promscrape.cluster.replicationFactor must be bigger than 0; got %d", *clusterReplicationFactor)
+	}
 	globalStopCh = make(chan struct{})
+	if *maxPushSamplesPerSecond > 0 {
+		rl := newPushRateLimiter(*maxPushSamplesPerSecond, *dropSamplesOnPushRateLimit, globalStopCh)
+		pushData = rl.wrapPushData(pushData)
+	}
 	scraperWG.Add(1)
 	go func() {
 		defer scraperWG.Done()
 		runScraper(*promscrapeConfigFile, pushData, globalStopCh)
 	}()
+	if *exportDroppedTargets {
+		scraperWG.Add(1)
+		go func() {
+			defer scraperWG.Done()
+			runDroppedTargetsExporter(pushData, globalStopCh)
+		}()
+	}
+	if *targetsDumpFile != "" {
+		scraperWG.Add(1)
+		go func() {
+			defer scraperWG.Done()
+			runTargetsDumper(*targetsDumpFile, globalStopCh)
+		}()
+	}
+	if *secretsFileCheckInterval > 0 {
+		scraperWG.Add(1)
+		go func() {
+			defer scraperWG.Done()
+			runSecretsFileWatcher(globalStopCh)
+		}()
+	}
+}
+
+// PushDataInterceptor must return a wrapper for pushData, which is used for the scrape pool with the given jobName.
+//
+// The wrapper may modify wr before passing it to pushData or may skip calling pushData at all.
+type PushDataInterceptor func(jobName string, pushData func(wr *prompbmarshal.WriteRequest)) func(wr *prompbmarshal.WriteRequest)
+
+// RegisterPushDataInterceptor registers the interceptor for scraped data from targets with the given job_name.
+//
+// The interceptor is applied to newly started scrapers, so it must be registered before Init call.
+// Pass nil interceptor in order to unregister the previously registered interceptor for the given jobName.
+func RegisterPushDataInterceptor(jobName string, interceptor PushDataInterceptor) {
+	pushDataInterceptorsLock.Lock()
+	if interceptor == nil {
+		delete(pushDataInterceptors, jobName)
+	} else {
+		pushDataInterceptors[jobName] = interceptor
+	}
+	pushDataInterceptorsLock.Unlock()
 }
 
+func getPushDataInterceptor(jobName string) PushDataInterceptor {
+	pushDataInterceptorsLock.Lock()
+	interceptor := pushDataInterceptors[jobName]
+	pushDataInterceptorsLock.Unlock()
+	return interceptor
+}
+
+var (
+	pushDataInterceptorsLock sync.Mutex
+	pushDataInterceptors     = make(map[string]PushDataInterceptor)
+)
+
 // Stop stops Prometheus scraper.
 func Stop() {
 	close(globalStopCh)
@@ -96,17 +176,17 @@ func runScraper(configFile string, pushData func(wr *prompbmarshal.WriteRequest)
 		logger.Fatalf("cannot read %q: %s", configFile, err)
 	}
 
+	setActiveConfig(cfg)
+	defer setActiveConfig(nil)
+
 	scs := newScrapeConfigs(pushData)
-	scs.add("static_configs", 0, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getStaticScrapeWork() })
-	scs.add("file_sd_configs", *fileSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getFileSDScrapeWork(swsPrev) })
-	scs.add("kubernetes_sd_configs", *kubernetesSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getKubernetesSDScrapeWork(swsPrev) })
-	scs.add("openstack_sd_configs", *openstackSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getOpenStackSDScrapeWork(swsPrev) })
-	scs.add("consul_sd_configs", *consulSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getConsulSDScrapeWork(swsPrev) })
-	scs.add("eureka_sd_configs", *eurekaSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getEurekaSDScrapeWork(swsPrev) })
-	scs.add("dns_sd_configs", *dnsSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getDNSSDScrapeWork(swsPrev) })
-	scs.add("ec2_sd_configs", *ec2SDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getEC2SDScrapeWork(swsPrev) })
-	scs.add("gce_sd_configs", *gceSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getGCESDScrapeWork(swsPrev) })
-	scs.add("dockerswarm_sd_configs", *dockerswarmSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getDockerSwarmSDScrapeWork(swsPrev) })
+	for _, sd := range getBuiltinDiscoveries() {
+		scs.add(sd.name, sd.checkInterval, sd.getScrapeWork)
+	}
+	addSDProviders(scs)
+
+	setActiveScrapeConfigs(scs)
+	defer setActiveScrapeConfigs(nil)
 
 	sighupCh := procutil.NewSighupChan()
 
@@ -127,7 +207,7 @@ func runScraper(configFile string, pushData func(wr *prompbmarshal.WriteRequest)
 				logger.Errorf("cannot read %q on SIGHUP: %s; continuing with the previous config", configFile, err)
 				goto waitForChans
 			}
-			if bytes.Equal(data, dataNew) {
+			if bytes.Equal(data, dataNew) && bytes.Equal(cfg.relabelFilesData, cfgNew.relabelFilesData) {
 				logger.Infof("nothing changed in %q", configFile)
 				goto waitForChans
 			}
@@ -139,7 +219,7 @@ func runScraper(configFile string, pushData func(wr *prompbmarshal.WriteRequest)
 				logger.Errorf("cannot read %q: %s; continuing with the previous config", configFile, err)
 				goto waitForChans
 			}
-			if bytes.Equal(data, dataNew) {
+			if bytes.Equal(data, dataNew) && bytes.Equal(cfg.relabelFilesData, cfgNew.relabelFilesData) {
 				// Nothing changed since the previous loadConfig
 				goto waitForChans
 			}
@@ -153,10 +233,88 @@ func runScraper(configFile string, pushData func(wr *prompbmarshal.WriteRequest)
 			return
 		}
 		logger.Infof("found changes in %q; applying these changes", configFile)
+		setActiveConfig(cfg)
 		configReloads.Inc()
 	}
 }
 
+// serviceDiscovery obtains targets from the given section of `scrape_config` every checkInterval.
+type serviceDiscovery struct {
+	name          string
+	checkInterval time.Duration
+	getScrapeWork func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork
+}
+
+// getBuiltinDiscoveries returns service discoveries for all the built-in sections of `scrape_config`.
+func getBuiltinDiscoveries() []serviceDiscovery {
+	return []serviceDiscovery{
+		{"static_configs", 0, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getStaticScrapeWork() }},
+		{"file_sd_configs", *fileSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getFileSDScrapeWork(swsPrev) }},
+		{"kubernetes_sd_configs", *kubernetesSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getKubernetesSDScrapeWork(swsPrev) }},
+		{"openstack_sd_configs", *openstackSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getOpenStackSDScrapeWork(swsPrev) }},
+		{"consul_sd_configs", *consulSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getConsulSDScrapeWork(swsPrev) }},
+		{"eureka_sd_configs", *eurekaSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getEurekaSDScrapeWork(swsPrev) }},
+		{"dns_sd_configs", *dnsSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getDNSSDScrapeWork(swsPrev) }},
+		{"ec2_sd_configs", *ec2SDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getEC2SDScrapeWork(swsPrev) }},
+		{"ecs_sd_configs", *ecsSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getECSSDScrapeWork(swsPrev) }},
+		{"gce_sd_configs", *gceSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getGCESDScrapeWork(swsPrev) }},
+		{"dockerswarm_sd_configs", *dockerswarmSDCheckInterval, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork { return cfg.getDockerSwarmSDScrapeWork(swsPrev) }},
+	}
+}
+
+var (
+	activeConfigLock sync.Mutex
+	activeConfig     *Config
+)
+
+func setActiveConfig(cfg *Config) {
+	activeConfigLock.Lock()
+	activeConfig = cfg
+	activeConfigLock.Unlock()
+}
+
+var (
+	activeScrapeConfigsLock sync.Mutex
+	activeScrapeConfigs     *scrapeConfigs
+)
+
+func setActiveScrapeConfigs(scs *scrapeConfigs) {
+	activeScrapeConfigsLock.Lock()
+	activeScrapeConfigs = scs
+	activeScrapeConfigsLock.Unlock()
+}
+
+// RefreshSD triggers immediate service discovery for the scrape configs with the given poolName.
+//
+// poolName is the name of service discovery section such as `kubernetes_sd_configs`. It matches `type` label in `vm_promscrape_*` metrics.
+// The discovery is performed asynchronously. Calls made while the previously triggered discovery is pending are merged into a single discovery.
+// It is safe calling RefreshSD from concurrently running goroutines.
+func RefreshSD(poolName string) error {
+	activeScrapeConfigsLock.Lock()
+	scs := activeScrapeConfigs
+	activeScrapeConfigsLock.Unlock()
+	if scs == nil {
+		return fmt.Errorf("cannot refresh %q, since scraper isn't running", poolName)
+	}
+	return scs.refresh(poolName)
+}
+
+// ConfiguredScrapePools returns `job_name/type` entries for all the enabled scrape configs from the active `-promscrape.config`.
+//
+// An entry is returned per each service discovery type configured in every scrape config, where type is the name of the corresponding section
+// such as `static_configs` or `kubernetes_sd_configs`. It matches `type` label in `vm_promscrape_*` metrics.
+// Entries are returned even if the corresponding scrape configs have no discovered targets.
+// Nil is returned if scraper isn't running.
+func ConfiguredScrapePools() []string {
+	activeConfigLock.Lock()
+	cfg := activeConfig
+	activeConfigLock.Unlock()
+	if cfg == nil {
+		return nil
+	}
+	return cfg.getScrapePools()
+}
+
 var configReloads = metrics.NewCounter(`vm_promscrape_config_reloads_total`)
 
 type scrapeConfigs struct {
@@ -164,12 +322,21 @@ type scrapeConfigs struct {
 	wg       sync.WaitGroup
 	stopCh   chan struct{}
 	scfgs    []*scrapeConfig
+
+	// refreshLimitCh limits the number of concurrent getScrapeWork calls across scfgs.
+	// It is nil if the number of concurrent calls isn't limited.
+	refreshLimitCh chan struct{}
 }
 
 func newScrapeConfigs(pushData func(wr *prompbmarshal.WriteRequest)) *scrapeConfigs {
+	var refreshLimitCh chan struct{}
+	if *maxConcurrentDiscoveryRefreshes > 0 {
+		refreshLimitCh = make(chan struct{}, *maxConcurrentDiscoveryRefreshes)
+	}
 	return &scrapeConfigs{
-		pushData: pushData,
-		stopCh:   make(chan struct{}),
+		pushData:       pushData,
+		stopCh:         make(chan struct{}),
+		refreshLimitCh: refreshLimitCh,
 	}
 }
 
@@ -181,8 +348,16 @@ func (scs *scrapeConfigs) add(name string, checkInterval time.Duration, getScrap
 		getScrapeWork: getScrapeWork,
 		checkInterval: checkInterval,
 		cfgCh:         make(chan *Config, 1),
+		refreshCh:     make(chan struct{}, 1),
 		stopCh:        scs.stopCh,
+
+		refreshLimitCh: scs.refreshLimitCh,
+
+		discoveryDuration: metrics.GetOrCreateHistogram(fmt.Sprintf(`vm_promscrape_discovery_duration_seconds{type=%q}`, name)),
 	}
+	metrics.NewGauge(fmt.Sprintf(`vm_promscrape_discovered_targets{type=%q}`, name), func() float64 {
+		return float64(atomic.LoadUint64(&scfg.discoveredTargets))
+	})
 	scs.wg.Add(1)
 	go func() {
 		defer scs.wg.Done()
@@ -191,6 +366,25 @@ func (scs *scrapeConfigs) add(name string, checkInterval time.Duration, getScrap
 	scs.scfgs = append(scs.scfgs, scfg)
 }
 
+func (scs *scrapeConfigs) refresh(name string) error {
+	for _, scfg := range scs.scfgs {
+		if scfg.name != name {
+			continue
+		}
+		select {
+		case scfg.refreshCh <- struct{}{}:
+		default:
+			// The refresh is already pending.
+		}
+		return nil
+	}
+	names := make([]string, len(scs.scfgs))
+	for i, scfg := range scs.scfgs {
+		names[i] = scfg.name
+	}
+	return fmt.Errorf("unknown service discovery type %q; supported values: %s", name, strings.Join(names, ", "))
+}
+
 func (scs *scrapeConfigs) updateConfig(cfg *Config) {
 	for _, scfg := range scs.scfgs {
 		scfg.cfgCh <- cfg
@@ -204,12 +398,22 @@ func (scs *scrapeConfigs) stop() {
 }
 
 type scrapeConfig struct {
+	// The number of targets returned by the last getScrapeWork call.
+	//
+	// It is put at the beginning of the struct in order to guarantee 64-bit alignment for atomic access on 32-bit architectures.
+	discoveredTargets uint64
+
 	name          string
 	pushData      func(wr *prompbmarshal.WriteRequest)
 	getScrapeWork func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork
 	checkInterval time.Duration
 	cfgCh         chan *Config
+	refreshCh     chan struct{}
 	stopCh        <-chan struct{}
+
+	refreshLimitCh chan struct{}
+
+	discoveryDuration *metrics.Histogram
 }
 
 func (scfg *scrapeConfig) run() {
@@ -225,12 +429,19 @@ func (scfg *scrapeConfig) run() {
 
 	cfg := <-scfg.cfgCh
 	var swsPrev []ScrapeWork
-	updateScrapeWork := func(cfg *Config) {
-		sws := scfg.getScrapeWork(cfg, swsPrev)
+	updateScrapeWork := func(cfg *Config) bool {
+		sws, ok := scfg.refreshScrapeWork(cfg, swsPrev)
+		if !ok {
+			return false
+		}
+		// Update scrapers after releasing the refresh slot, so other scrape configs could proceed with their refreshes.
 		sg.update(sws)
 		swsPrev = sws
+		return true
+	}
+	if !updateScrapeWork(cfg) {
+		return
 	}
-	updateScrapeWork(cfg)
 	atomic.AddInt32(&PendingScrapeConfigs, -1)
 
 	for {
@@ -240,18 +451,75 @@ func (scfg *scrapeConfig) run() {
 			return
 		case cfg = <-scfg.cfgCh:
 		case <-tickerCh:
+		case <-scfg.refreshCh:
+		}
+		if !updateScrapeWork(cfg) {
+			return
+		}
+	}
+}
+
+// refreshScrapeWork returns the discovered targets for cfg.
+//
+// It waits for a free slot in scfg.refreshLimitCh if -promscrape.discovery.maxConcurrentRefreshes is set.
+// false is returned if scfg is stopped while waiting.
+func (scfg *scrapeConfig) refreshScrapeWork(cfg *Config, swsPrev []ScrapeWork) ([]ScrapeWork, bool) {
+	if scfg.refreshLimitCh != nil {
+		select {
+		case scfg.refreshLimitCh <- struct{}{}:
+		default:
+			discoveryRefreshesPending.Inc()
+			select {
+			case scfg.refreshLimitCh <- struct{}{}:
+				discoveryRefreshesPending.Dec()
+			case <-scfg.stopCh:
+				discoveryRefreshesPending.Dec()
+				return nil, false
+			}
 		}
-		updateScrapeWork(cfg)
+		defer func() { <-scfg.refreshLimitCh }()
 	}
+	startTime := time.Now()
+	sws := scfg.getScrapeWork(cfg, swsPrev)
+	scfg.discoveryDuration.UpdateDuration(startTime)
+	atomic.StoreUint64(&scfg.discoveredTargets, uint64(len(sws)))
+	sws = targetLimiterGlobal.apply(cfg, scfg.name, sws)
+	return sws, true
 }
 
+var discoveryRefreshesPending = metrics.NewCounter(`vm_promscrape_discovery_refreshes_pending`)
+
 type scraperGroup struct {
+	// generation is incremented on every update call. It must be the first field for proper alignment on 32-bit archs.
+	generation uint64
+
+	// lastSuccessfulScrape is the timestamp in milliseconds of the last successful scrape among all the targets in the group.
+	// It must be placed after generation for proper alignment on 32-bit archs.
+	lastSuccessfulScrape uint64
+
 	name         string
 	wg           sync.WaitGroup
+	updateLock   sync.Mutex
 	mLock        sync.Mutex
 	m            map[string]*scraper
 	pushData     func(wr *prompbmarshal.WriteRequest)
 	changesCount *metrics.Counter
+
+	// duplicatesCount is incremented for each scrape target skipped because of duplicate labels.
+	duplicatesCount *metrics.Counter
+
+	// churn tracks the number of added and removed targets.
+	churn *targetChurn
+
+	// membersCount, memberNum and replicationFactor are used for scraping only the targets assigned to the given cluster member.
+	// See -promscrape.cluster.* command-line flags.
+	membersCount      int
+	memberNum         int
+	replicationFactor int
+
+	// batchApplied is called by update with the number of added or removed targets after applying every batch of changes.
+	// It is used in tests.
+	batchApplied func(changes int)
 }
 
 func newScraperGroup(name string, pushData func(wr *prompbmarshal.WriteRequest)) *scraperGroup {
@@ -260,6 +528,13 @@ func newScraperGroup(name string, pushData func(wr *prompbmarshal.WriteRequest))
 		m:            make(map[string]*scraper),
 		pushData:     pushData,
 		changesCount: metrics.NewCounter(fmt.Sprintf(`vm_promscrape_config_changes_total{type=%q}`, name)),
+
+		duplicatesCount: metrics.NewCounter(fmt.Sprintf(`vm_promscrape_duplicate_targets_total{type=%q}`, name)),
+		churn:           newTargetChurn(name),
+
+		membersCount:      *clusterMembersCount,
+		memberNum:         *clusterMemberNum,
+		replicationFactor: *clusterReplicationFactor,
 	}
 	metrics.NewGauge(fmt.Sprintf(`vm_promscrape_targets{type=%q, status="up"}`, name), func() float64 {
 		return float64(tsmGlobal.StatusByGroup(sg.name, true))
@@ -267,6 +542,9 @@ func newScraperGroup(name string, pushData func(wr *prompbmarshal.WriteRequest))
 	metrics.NewGauge(fmt.Sprintf(`vm_promscrape_targets{type=%q, status="down"}`, name), func() float64 {
 		return float64(tsmGlobal.StatusByGroup(sg.name, false))
 	})
+	metrics.NewGauge(fmt.Sprintf(`vm_promscrape_last_successful_scrape_timestamp_seconds{type=%q}`, name), func() float64 {
+		return float64(atomic.LoadUint64(&sg.lastSuccessfulScrape)) / 1e3
+	})
 	return sg
 }
 
@@ -280,60 +558,189 @@ func (sg *scraperGroup) stop() {
 	sg.wg.Wait()
 }
 
+// scraperGroupUpdateBatchSize is the maximum number of targets, which are added or removed
+// by scraperGroup.update while holding scraperGroup.mLock.
+//
+// This prevents from blocking concurrent readers of scraperGroup state on big service discovery changes.
+const scraperGroupUpdateBatchSize = 1000
+
 func (sg *scraperGroup) update(sws []ScrapeWork) {
-	sg.mLock.Lock()
-	defer sg.mLock.Unlock()
+	generation := atomic.AddUint64(&sg.generation, 1)
+	sg.updateLock.Lock()
+	defer sg.updateLock.Unlock()
 
 	additionsCount := 0
 	deletionsCount := 0
+	prevTargetsCount := sg.targetsCount()
+	defer func() {
+		if additionsCount > 0 || deletionsCount > 0 {
+			sg.changesCount.Add(additionsCount + deletionsCount)
+			sg.churn.register(fasttime.UnixTimestamp(), additionsCount, deletionsCount, prevTargetsCount)
+			targetsCount := sg.targetsCount()
+			logger.WithFields(
+				logger.Field{Key: "type", Value: sg.name},
+				logger.Field{Key: "added_targets", Value: additionsCount},
+				logger.Field{Key: "removed_targets", Value: deletionsCount},
+				logger.Field{Key: "total_targets", Value: targetsCount},
+			).Infof("%s: added targets: %d, removed targets: %d; total targets: %d", sg.name, additionsCount, deletionsCount, targetsCount)
+		}
+	}()
+
 	swsMap := make(map[string][]prompbmarshal.Label, len(sws))
+	duplicatesByJob := make(map[string]int)
+	keys := make([]string, 0, len(sws))
+	swsUnique := make([]*ScrapeWork, 0, len(sws))
 	for i := range sws {
 		sw := &sws[i]
+		if sg.membersCount > 1 && !isClusterMemberTarget(sw, sg.membersCount, sg.memberNum, sg.replicationFactor) {
+			// The target must be scraped by another cluster member.
+			droppedTargetsMap.Register(sw.OriginalLabels, "other_shard")
+			continue
+		}
 		key := sw.key()
-		originalLabels := swsMap[key]
-		if originalLabels != nil {
+		originalLabels, ok := swsMap[key]
+		if ok {
 			if !*suppressDuplicateScrapeTargetErrors {
-				logger.Errorf("skipping duplicate scrape target with identical labels; endpoint=%s, labels=%s; "+
+				logger.WithFields(
+					logger.Field{Key: "scrape_pool", Value: sw.Job()},
+					logger.Field{Key: "type", Value: sg.name},
+					logger.Field{Key: "target_url", Value: sw.ScrapeURL},
+					logger.Field{Key: "labels", Value: sw.LabelsString()},
+					logger.Field{Key: "error_reason", Value: "duplicate"},
+				).Errorf("skipping duplicate scrape target with identical labels; endpoint=%s, labels=%s; "+
 					"make sure service discovery and relabeling is set up properly; "+
 					"see also https://victoriametrics.github.io/vmagent.html#troubleshooting; "+
 					"original labels for target1: %s; original labels for target2: %s",
 					sw.ScrapeURL, sw.LabelsString(), promLabelsString(originalLabels), promLabelsString(sw.OriginalLabels))
 			}
-			droppedTargetsMap.Register(sw.OriginalLabels)
+			droppedTargetsMap.Register(sw.OriginalLabels, "duplicate")
+			sg.duplicatesCount.Inc()
+			duplicatesByJob[sw.Job()]++
 			continue
 		}
 		swsMap[key] = sw.OriginalLabels
-		if sg.m[key] != nil {
-			// The scraper for the given key already exists.
-			continue
-		}
+		keys = append(keys, key)
+		swsUnique = append(swsUnique, sw)
+	}
+	tsmGlobal.SetDuplicates(sg.name, duplicatesByJob)
 
-		// Start a scraper for the missing key.
-		sc := newScraper(sw, sg.name, sg.pushData)
-		sg.wg.Add(1)
-		go func() {
-			defer sg.wg.Done()
-			sc.sw.run(sc.stopCh)
-			tsmGlobal.Unregister(sw)
-		}()
-		tsmGlobal.Register(sw)
-		sg.m[key] = sc
-		additionsCount++
+	// Start scrapers for missing keys in batches, so concurrent readers aren't blocked for long time.
+	for len(swsUnique) > 0 {
+		n := scraperGroupUpdateBatchSize
+		if n > len(swsUnique) {
+			n = len(swsUnique)
+		}
+		sg.mLock.Lock()
+		if sg.m == nil || atomic.LoadUint64(&sg.generation) != generation {
+			// The group has been stopped or a newer update is pending. The newer update applies the latest targets.
+			sg.mLock.Unlock()
+			return
+		}
+		batchAdditions := 0
+		for i, sw := range swsUnique[:n] {
+			key := keys[i]
+			if sg.m[key] != nil {
+				// The scraper for the given key already exists.
+				continue
+			}
+			sc := newScraper(sw, sg.name, sg.pushData)
+			sc.sw.lastSuccessfulScrape = &sg.lastSuccessfulScrape
+			// Register sc before starting the scraper, since its sharedFetchKey is read during scrapes.
+			sharedFetchesGlobal.register(&sc.sw)
+			sg.wg.Add(1)
+			go func(sw *ScrapeWork) {
+				defer sg.wg.Done()
+				sc.sw.run(sc.stopCh)
+				secretsFileWatcherGlobal.unsubscribe(&sc.sw)
+				sharedFetchesGlobal.unregister(&sc.sw)
+				healthPoolsGlobal.unregister(&sc.sw)
+				tsmGlobal.Unregister(sw)
+			}(sw)
+			tsmGlobal.Register(sw)
+			secretsFileWatcherGlobal.subscribe(&sc.sw)
+			sg.m[key] = sc
+			batchAdditions++
+		}
+		sg.mLock.Unlock()
+		additionsCount += batchAdditions
+		if sg.batchApplied != nil {
+			sg.batchApplied(batchAdditions)
+		}
+		swsUnique = swsUnique[n:]
+		keys = keys[n:]
 	}
 
 	// Stop deleted scrapers, which are missing in sws.
-	for key, sc := range sg.m {
+	sg.mLock.Lock()
+	var keysToDelete []string
+	for key := range sg.m {
 		if _, ok := swsMap[key]; !ok {
-			close(sc.stopCh)
-			delete(sg.m, key)
-			deletionsCount++
+			keysToDelete = append(keysToDelete, key)
+		}
+	}
+	sg.mLock.Unlock()
+	for len(keysToDelete) > 0 {
+		n := scraperGroupUpdateBatchSize
+		if n > len(keysToDelete) {
+			n = len(keysToDelete)
 		}
+		sg.mLock.Lock()
+		if sg.m == nil || atomic.LoadUint64(&sg.generation) != generation {
+			sg.mLock.Unlock()
+			return
+		}
+		batchDeletions := 0
+		for _, key := range keysToDelete[:n] {
+			if sc := sg.m[key]; sc != nil {
+				close(sc.stopCh)
+				delete(sg.m, key)
+				batchDeletions++
+			}
+		}
+		sg.mLock.Unlock()
+		deletionsCount += batchDeletions
+		if sg.batchApplied != nil {
+			sg.batchApplied(batchDeletions)
+		}
+		keysToDelete = keysToDelete[n:]
 	}
+}
 
-	if additionsCount > 0 || deletionsCount > 0 {
-		sg.changesCount.Add(additionsCount + deletionsCount)
-		logger.Infof("%s: added targets: %d, removed targets: %d; total targets: %d", sg.name, additionsCount, deletionsCount, len(sg.m))
+// isClusterMemberTarget returns true if the cluster member with the given memberNum must scrape sw.
+//
+// Every target is scraped by replicationFactor consecutive members starting from getClusterMemberNum.
+// The scraped data must be de-duplicated by remote storage if replicationFactor is bigger than 1.
+func isClusterMemberTarget(sw *ScrapeWork, membersCount, memberNum, replicationFactor int) bool {
+	n := getClusterMemberNum(sw, membersCount)
+	for i := 0; i < replicationFactor && i < membersCount; i++ {
+		if (n+i)%membersCount == memberNum {
+			return true
+		}
 	}
+	return false
+}
+
+// getClusterMemberNum returns the number of cluster member, which must scrape sw.
+//
+// Jump consistent hash is used, so only the minimum number of targets is moved between members
+// when membersCount changes. See https://arxiv.org/abs/1406.2294 .
+func getClusterMemberNum(sw *ScrapeWork, membersCount int) int {
+	h := xxhash.Sum64([]byte(sw.LabelsString()))
+	b, j := int64(-1), int64(0)
+	for j < int64(membersCount) {
+		b = j
+		h = h*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((h>>33)+1)))
+	}
+	return int(b)
+}
+
+// targetsCount returns the number of active scrapers in sg.
+func (sg *scraperGroup) targetsCount() int {
+	sg.mLock.Lock()
+	n := len(sg.m)
+	sg.mLock.Unlock()
+	return n
 }
 
 type scraper struct {
@@ -345,11 +752,57 @@ func newScraper(sw *ScrapeWork, group string, pushData func(wr *prompbmarshal.Wr
 	sc := &scraper{
 		stopCh: make(chan struct{}),
 	}
-	c := newClient(sw)
 	sc.sw.Config = *sw
 	sc.sw.ScrapeGroup = group
-	sc.sw.ReadData = c.ReadData
-	sc.sw.GetStreamReader = c.GetStreamReader
+	sc.sw.initClients()
+	sc.sw.limiter = getScrapeLimiter()
+	if interceptor := getPushDataInterceptor(sw.jobNameOriginal); interceptor != nil {
+		pushData = interceptor(sw.jobNameOriginal, pushData)
+	}
 	sc.sw.PushData = pushData
 	return sc
 }
+
+// initClients creates scrape clients for sw.Config.
+//
+// It may be called multiple times, e.g. after the auth config for sw.Config is changed. See applyPendingAuthConfig.
+func (sw *scrapeWork) initClients() {
+	cfg := &sw.Config
+	c := newClient(cfg)
+	if *traceTimings {
+		c.phaseTimings = newScrapePhaseTimings(sw.ScrapeGroup)
+	}
+	sw.intervalHint = newScrapeIntervalHint(cfg)
+	c.intervalHint = sw.intervalHint
+	sw.ReadData = c.ReadData
+	sw.ReadAdditionalData = c.ReadAdditionalData
+	sw.GetStreamReader = c.GetStreamReader
+	sw.GetAdditionalStreamReader = c.GetAdditionalStreamReader
+	sw.schemeAuto = nil
+	sw.fallback = nil
+	sw.backends = sw.backends[:0]
+	if cfg.GRPCMethod != "" && newGRPCReadData != nil {
+		// `__addresses__` and `__fallback_scrape_urls__` aren't supported for gRPC targets.
+		sw.ReadData = newGRPCReadData(cfg)
+		return
+	}
+	if cfg.SchemeAuto {
+		sw.schemeAuto = newSchemeAutoClient(cfg, c)
+		sw.ReadData = sw.schemeAuto.ReadData
+		sw.GetStreamReader = sw.schemeAuto.GetStreamReader
+	}
+	if len(cfg.FallbackScrapeURLs) > 0 {
+		sw.fallback = newFallbackClient(cfg, c)
+		sw.ReadData = sw.fallback.ReadData
+		sw.GetStreamReader = sw.fallback.GetStreamReader
+	}
+	for _, backendURL := range cfg.BackendScrapeURLs {
+		swCopy := *cfg
+		swCopy.ScrapeURL = backendURL
+		swCopy.AdditionalScrapeURLs = nil
+		bc := newClient(&swCopy)
+		bc.phaseTimings = c.phaseTimings
+		bc.intervalHint = c.intervalHint
+		sw.backends = append(sw.backends, newScrapeBackend(cfg.Labels, bc.host, bc))
+	}
+}
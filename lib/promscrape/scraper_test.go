@@ -0,0 +1,655 @@
+package promscrape
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func TestPushDataInterceptor(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\nbar 2\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	const jobName = "test_push_data_interceptor"
+	RegisterPushDataInterceptor(jobName, func(jobNameLocal string, pushData func(wr *prompbmarshal.WriteRequest)) func(wr *prompbmarshal.WriteRequest) {
+		if jobNameLocal != jobName {
+			t.Fatalf("unexpected jobName passed to interceptor; got %q; want %q", jobNameLocal, jobName)
+		}
+		return func(wr *prompbmarshal.WriteRequest) {
+			tss := wr.Timeseries[:0]
+			for _, ts := range wr.Timeseries {
+				if promrelabel.GetLabelValueByName(ts.Labels, "__name__") != "foo" {
+					tss = append(tss, ts)
+				}
+			}
+			wr.Timeseries = tss
+			pushData(wr)
+		}
+	})
+	defer RegisterPushDataInterceptor(jobName, nil)
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: %s
+  static_configs:
+  - targets: [%q]
+- job_name: other
+  static_configs:
+  - targets: [%q]
+`, jobName, u.Host, u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+	metricsByJob := make(map[string]map[string]bool)
+	pushData := func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			job := promrelabel.GetLabelValueByName(ts.Labels, "job")
+			if metricsByJob[job] == nil {
+				metricsByJob[job] = make(map[string]bool)
+			}
+			metricsByJob[job][promrelabel.GetLabelValueByName(ts.Labels, "__name__")] = true
+		}
+	}
+	for i := range sws {
+		sc := newScraper(&sws[i], "test", pushData)
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if metricsByJob[jobName]["foo"] {
+		t.Fatalf("the metric foo must be dropped by interceptor for job %q", jobName)
+	}
+	if !metricsByJob[jobName]["bar"] || !metricsByJob[jobName]["up"] {
+		t.Fatalf("missing metrics for job %q: %v", jobName, metricsByJob[jobName])
+	}
+	if !metricsByJob["other"]["foo"] || !metricsByJob["other"]["bar"] {
+		t.Fatalf("missing metrics for job %q: %v", "other", metricsByJob["other"])
+	}
+}
+
+func TestScrapeConfigsDiscoveryMetrics(t *testing.T) {
+	var targetsCount uint64 = 3
+	scs := newScrapeConfigs(func(wr *prompbmarshal.WriteRequest) {})
+	scs.add("test_discovery_metrics", 0, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork {
+		sws := make([]ScrapeWork, atomic.LoadUint64(&targetsCount))
+		for i := range sws {
+			sws[i] = ScrapeWork{
+				ScrapeURL:      fmt.Sprintf("http://foo%d:1234/metrics", i),
+				ScrapeInterval: time.Hour,
+				ScrapeTimeout:  time.Second,
+				Labels: []prompbmarshal.Label{
+					{
+						Name:  "instance",
+						Value: fmt.Sprintf("foo%d:1234", i),
+					},
+				},
+				AuthConfig: &promauth.Config{},
+			}
+		}
+		return sws
+	})
+	defer scs.stop()
+	scfg := scs.scfgs[0]
+
+	f := func(targetsExpected uint64) {
+		t.Helper()
+		atomic.StoreUint64(&targetsCount, targetsExpected)
+		scs.updateConfig(&Config{})
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadUint64(&scfg.discoveredTargets) != targetsExpected {
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected number of discovered targets; got %d; want %d", atomic.LoadUint64(&scfg.discoveredTargets), targetsExpected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		var bb bytes.Buffer
+		metrics.WritePrometheus(&bb, false)
+		gaugeExpected := fmt.Sprintf(`vm_promscrape_discovered_targets{type="test_discovery_metrics"} %d`+"\n", targetsExpected)
+		if !strings.Contains(bb.String(), gaugeExpected) {
+			t.Fatalf("missing %q in the exposed metrics", gaugeExpected)
+		}
+		if !strings.Contains(bb.String(), `vm_promscrape_discovery_duration_seconds_count{type="test_discovery_metrics"}`) {
+			t.Fatalf("missing vm_promscrape_discovery_duration_seconds histogram in the exposed metrics")
+		}
+	}
+	f(3)
+	f(1)
+}
+
+func TestScrapeConfigsMaxConcurrentRefreshes(t *testing.T) {
+	const maxRefreshes = 3
+	const scrapeConfigsCount = 10
+	maxConcurrentDiscoveryRefreshesOrig := *maxConcurrentDiscoveryRefreshes
+	*maxConcurrentDiscoveryRefreshes = maxRefreshes
+	defer func() {
+		*maxConcurrentDiscoveryRefreshes = maxConcurrentDiscoveryRefreshesOrig
+	}()
+
+	var inflight, maxInflight, refreshes int64
+	scs := newScrapeConfigs(func(wr *prompbmarshal.WriteRequest) {})
+	for i := 0; i < scrapeConfigsCount; i++ {
+		scs.add(fmt.Sprintf("test_max_concurrent_refreshes_%d", i), 0, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork {
+			n := atomic.AddInt64(&inflight, 1)
+			for {
+				m := atomic.LoadInt64(&maxInflight)
+				if n <= m || atomic.CompareAndSwapInt64(&maxInflight, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt64(&inflight, -1)
+			atomic.AddInt64(&refreshes, 1)
+			return nil
+		})
+	}
+	scs.updateConfig(&Config{})
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&refreshes) < scrapeConfigsCount {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout while waiting for refreshes; got %d; want %d", atomic.LoadInt64(&refreshes), scrapeConfigsCount)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	scs.stop()
+	if n := atomic.LoadInt64(&maxInflight); n > maxRefreshes {
+		t.Fatalf("too many concurrent refreshes; got %d; want no more than %d", n, maxRefreshes)
+	}
+	if n := atomic.LoadInt64(&maxInflight); n < 1 {
+		t.Fatalf("unexpected number of concurrent refreshes: %d", n)
+	}
+}
+
+func TestRefreshSD(t *testing.T) {
+	if err := RefreshSD("kubernetes_sd_configs"); err == nil {
+		t.Fatalf("expecting non-nil error when scraper isn't running")
+	}
+
+	var refreshes uint64
+	scs := newScrapeConfigs(func(wr *prompbmarshal.WriteRequest) {})
+	scs.add("test_refresh_sd", time.Hour, func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork {
+		atomic.AddUint64(&refreshes, 1)
+		return nil
+	})
+	setActiveScrapeConfigs(scs)
+	defer setActiveScrapeConfigs(nil)
+	defer scs.stop()
+
+	waitForRefreshes := func(nExpected uint64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadUint64(&refreshes) < nExpected {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout while waiting for refreshes; got %d; want %d", atomic.LoadUint64(&refreshes), nExpected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	scs.updateConfig(&Config{})
+	waitForRefreshes(1)
+
+	// The refresh must be performed without waiting for the check interval.
+	if err := RefreshSD("test_refresh_sd"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	waitForRefreshes(2)
+
+	if err := RefreshSD("unknown_sd_configs"); err == nil {
+		t.Fatalf("expecting non-nil error for unknown service discovery type")
+	}
+	if n := atomic.LoadUint64(&refreshes); n != 2 {
+		t.Fatalf("unexpected number of refreshes after the refresh for unknown type; got %d; want 2", n)
+	}
+}
+
+func TestScrapeConfigRefreshMerge(t *testing.T) {
+	// Refreshes requested while the previous refresh is pending must be merged into a single refresh.
+	scfg := &scrapeConfig{
+		name:      "test_refresh_merge",
+		refreshCh: make(chan struct{}, 1),
+	}
+	scs := &scrapeConfigs{
+		scfgs: []*scrapeConfig{scfg},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := scs.refresh("test_refresh_merge"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(scfg.refreshCh); n != 1 {
+		t.Fatalf("unexpected number of pending refreshes; got %d; want 1", n)
+	}
+}
+
+func TestScraperGroupUpdateBatches(t *testing.T) {
+	sg := newScraperGroup("test_update_batches", func(wr *prompbmarshal.WriteRequest) {})
+	defer sg.stop()
+
+	newScrapeWorks := func(prefix string, n int) []ScrapeWork {
+		sws := make([]ScrapeWork, n)
+		for i := range sws {
+			sws[i] = ScrapeWork{
+				ScrapeURL:      fmt.Sprintf("http://%s%d:1234/metrics", prefix, i),
+				ScrapeInterval: time.Hour,
+				ScrapeTimeout:  time.Second,
+				Labels: []prompbmarshal.Label{
+					{
+						Name:  "instance",
+						Value: fmt.Sprintf("%s%d:1234", prefix, i),
+					},
+				},
+				AuthConfig: &promauth.Config{},
+			}
+		}
+		return sws
+	}
+
+	// Record the changes applied per batch and the number of targets visible to concurrent readers after every batch.
+	var batches, counts []int
+	sg.batchApplied = func(changes int) {
+		batches = append(batches, changes)
+		// sg.targetsCount would block if update didn't release the lock between batches.
+		counts = append(counts, sg.targetsCount())
+	}
+	f := func(sws []ScrapeWork, batchesExpected, countsExpected []int) {
+		t.Helper()
+		batches = batches[:0]
+		counts = counts[:0]
+		sg.update(sws)
+		if !reflect.DeepEqual(batches, batchesExpected) {
+			t.Fatalf("unexpected changes per batch\ngot\n%v\nwant\n%v", batches, batchesExpected)
+		}
+		if !reflect.DeepEqual(counts, countsExpected) {
+			t.Fatalf("unexpected number of targets after every batch\ngot\n%v\nwant\n%v", counts, countsExpected)
+		}
+	}
+
+	const batchesCount = 20
+	const targetsCount = batchesCount * scraperGroupUpdateBatchSize
+
+	// Targets addition
+	var batchesExpected, countsExpected []int
+	for i := 1; i <= batchesCount; i++ {
+		batchesExpected = append(batchesExpected, scraperGroupUpdateBatchSize)
+		countsExpected = append(countsExpected, i*scraperGroupUpdateBatchSize)
+	}
+	f(newScrapeWorks("foo", targetsCount), batchesExpected, countsExpected)
+
+	// Targets deletion. The new target is added before deleting the old targets.
+	batchesExpected = []int{1}
+	countsExpected = []int{targetsCount + 1}
+	for i := batchesCount - 1; i >= 0; i-- {
+		batchesExpected = append(batchesExpected, scraperGroupUpdateBatchSize)
+		countsExpected = append(countsExpected, i*scraperGroupUpdateBatchSize+1)
+	}
+	f(newScrapeWorks("bar", 1), batchesExpected, countsExpected)
+}
+
+func TestScraperGroupUpdateChurn(t *testing.T) {
+	ratioOrig := *targetChurnWarnRatio
+	*targetChurnWarnRatio = 0.5
+	defer func() {
+		*targetChurnWarnRatio = ratioOrig
+	}()
+
+	var bb bytes.Buffer
+	restore := logger.SetOutputForTests(&bb, "json")
+	defer restore()
+	sg := newScraperGroup("test_update_churn", func(wr *prompbmarshal.WriteRequest) {})
+	defer sg.stop()
+
+	const targetsCount = 1000
+	newScrapeWorks := func(prefix string) []ScrapeWork {
+		sws := make([]ScrapeWork, targetsCount)
+		for i := range sws {
+			sws[i] = ScrapeWork{
+				ScrapeURL:      fmt.Sprintf("http://%s%d:1234/metrics", prefix, i),
+				ScrapeInterval: time.Hour,
+				ScrapeTimeout:  time.Second,
+				Labels: []prompbmarshal.Label{
+					{
+						Name:  "instance",
+						Value: fmt.Sprintf("%s%d:1234", prefix, i),
+					},
+				},
+				AuthConfig: &promauth.Config{},
+			}
+		}
+		return sws
+	}
+	f := func(totalExpected int, warningExpected bool) {
+		t.Helper()
+		if n := sg.churn.total.Get(); n != uint64(totalExpected) {
+			t.Fatalf("unexpected vm_promscrape_target_churn_total; got %d; want %d", n, totalExpected)
+		}
+		if n := sg.churn.recent(fasttime.UnixTimestamp()); n != totalExpected {
+			t.Fatalf("unexpected vm_promscrape_recent_target_churn; got %d; want %d", n, totalExpected)
+		}
+		if hasWarning := strings.Contains(bb.String(), "too big target churn"); hasWarning != warningExpected {
+			t.Fatalf("unexpected warning presence; got %v; want %v; logs:\n%s", hasWarning, warningExpected, bb.String())
+		}
+	}
+
+	// The initial discovery mustn't trigger the warning.
+	sg.update(newScrapeWorks("foo"))
+	f(targetsCount, false)
+
+	// Replace all the targets.
+	sg.update(newScrapeWorks("bar"))
+	f(3*targetsCount, true)
+
+	// The recent churn must exclude updates older than targetChurnWindow.
+	if n := sg.churn.recent(fasttime.UnixTimestamp() + targetChurnWindow); n != 0 {
+		t.Fatalf("unexpected recent churn after targetChurnWindow; got %d; want 0", n)
+	}
+}
+
+func TestScraperGroupUpdateDuplicates(t *testing.T) {
+	// The counter must be updated independently of the log suppression.
+	suppressOrig := *suppressDuplicateScrapeTargetErrors
+	*suppressDuplicateScrapeTargetErrors = true
+	defer func() {
+		*suppressDuplicateScrapeTargetErrors = suppressOrig
+	}()
+
+	sg := newScraperGroup("test_update_duplicates", func(wr *prompbmarshal.WriteRequest) {})
+	defer sg.stop()
+
+	sws := make([]ScrapeWork, 2)
+	for i := range sws {
+		sws[i] = ScrapeWork{
+			ID:             atomic.AddUint64(&nextScrapeWorkID, 1),
+			ScrapeURL:      "http://foo:1234/metrics",
+			ScrapeInterval: time.Hour,
+			ScrapeTimeout:  time.Second,
+			Labels: []prompbmarshal.Label{
+				{
+					Name:  "instance",
+					Value: "foo:1234",
+				},
+				{
+					Name:  "job",
+					Value: "test_update_duplicates",
+				},
+			},
+			AuthConfig: &promauth.Config{},
+		}
+	}
+	duplicatesBefore := sg.duplicatesCount.Get()
+	sg.update(sws)
+	if n := sg.duplicatesCount.Get() - duplicatesBefore; n != 1 {
+		t.Fatalf("unexpected number of duplicate targets; got %d; want 1", n)
+	}
+	if n := sg.targetsCount(); n != 1 {
+		t.Fatalf("unexpected number of targets; got %d; want 1", n)
+	}
+	var bb bytes.Buffer
+	WriteHumanReadableTargetsStatus(&bb, false)
+	statusExpected := `job="test_update_duplicates" (0/1 up, 1 duplicates skipped)`
+	if !strings.Contains(bb.String(), statusExpected) {
+		t.Fatalf("missing %q in targets status:\n%s", statusExpected, bb.String())
+	}
+}
+
+func newTestClusterScrapeWorks(jobName string, n int) []ScrapeWork {
+	sws := make([]ScrapeWork, n)
+	for i := range sws {
+		sws[i] = ScrapeWork{
+			ID:             atomic.AddUint64(&nextScrapeWorkID, 1),
+			ScrapeURL:      fmt.Sprintf("http://foo%d:1234/metrics", i),
+			ScrapeInterval: time.Hour,
+			ScrapeTimeout:  time.Second,
+			OriginalLabels: []prompbmarshal.Label{
+				{
+					Name:  "__address__",
+					Value: fmt.Sprintf("foo%d:1234", i),
+				},
+			},
+			Labels: []prompbmarshal.Label{
+				{
+					Name:  "instance",
+					Value: fmt.Sprintf("foo%d:1234", i),
+				},
+				{
+					Name:  "job",
+					Value: jobName,
+				},
+			},
+			AuthConfig: &promauth.Config{},
+		}
+	}
+	return sws
+}
+
+func TestScraperGroupUpdateClusterMembers(t *testing.T) {
+	sws := newTestClusterScrapeWorks("test_update_cluster_members", 100)
+	keysByMember := make(map[string]int)
+	for memberNum := 0; memberNum < 2; memberNum++ {
+		sg := newScraperGroup(fmt.Sprintf("test_update_cluster_members_%d", memberNum), func(wr *prompbmarshal.WriteRequest) {})
+		sg.membersCount = 2
+		sg.memberNum = memberNum
+		sg.update(sws)
+		n := sg.targetsCount()
+		if n == 0 || n == len(sws) {
+			t.Fatalf("unexpected number of targets for member %d; got %d; want balanced subset of %d targets", memberNum, n, len(sws))
+		}
+		sg.mLock.Lock()
+		for key := range sg.m {
+			if prevMemberNum, ok := keysByMember[key]; ok {
+				t.Fatalf("the target %s is scraped by members %d and %d", key, prevMemberNum, memberNum)
+			}
+			keysByMember[key] = memberNum
+		}
+		sg.mLock.Unlock()
+		sg.stop()
+	}
+	if len(keysByMember) != len(sws) {
+		t.Fatalf("unexpected number of targets scraped by all the members; got %d; want %d", len(keysByMember), len(sws))
+	}
+	var bb bytes.Buffer
+	droppedTargetsMap.WriteDroppedTargetsJSON(&bb)
+	if !strings.Contains(bb.String(), `"dropReason":"other_shard"`) {
+		t.Fatalf("missing targets dropped because of other_shard in %s", bb.String())
+	}
+
+	// Targets must move only to the added member when the number of members increases.
+	for i := range sws {
+		sw := &sws[i]
+		prevMemberNum := getClusterMemberNum(sw, 2)
+		if memberNum := getClusterMemberNum(sw, 3); memberNum != prevMemberNum && memberNum != 2 {
+			t.Fatalf("unexpected move of target %s from member %d to member %d", sw.LabelsString(), prevMemberNum, memberNum)
+		}
+	}
+}
+
+func TestScraperGroupUpdateClusterReplication(t *testing.T) {
+	sws := newTestClusterScrapeWorks("test_update_cluster_replication", 100)
+	ownersCount := make(map[string]int)
+	for memberNum := 0; memberNum < 3; memberNum++ {
+		sg := newScraperGroup(fmt.Sprintf("test_update_cluster_replication_%d", memberNum), func(wr *prompbmarshal.WriteRequest) {})
+		sg.membersCount = 3
+		sg.memberNum = memberNum
+		sg.replicationFactor = 2
+		sg.update(sws)
+		sg.mLock.Lock()
+		for key := range sg.m {
+			ownersCount[key]++
+		}
+		sg.mLock.Unlock()
+		sg.stop()
+	}
+	if len(ownersCount) != len(sws) {
+		t.Fatalf("unexpected number of targets scraped by all the members; got %d; want %d", len(ownersCount), len(sws))
+	}
+	for key, n := range ownersCount {
+		if n != 2 {
+			t.Fatalf("unexpected number of owners for target %s; got %d; want 2", key, n)
+		}
+	}
+}
+
+func TestConfiguredScrapePools(t *testing.T) {
+	if pools := ConfiguredScrapePools(); pools != nil {
+		t.Fatalf("expecting nil scrape pools when scraper isn't running; got %q", pools)
+	}
+	var cfg Config
+	if err := cfg.parse([]byte(`
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo:1234"]
+  file_sd_configs:
+  - files: ["non-existing-file.yml"]
+- job_name: k8s
+  kubernetes_sd_configs:
+  - role: pod
+    api_server: "http://127.0.0.1:1"
+- job_name: disabled
+  enabled: false
+  static_configs:
+  - targets: ["bar:1234"]
+`), "non-existing-file"); err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+	setActiveConfig(&cfg)
+	defer setActiveConfig(nil)
+
+	// The k8s job has no discovered targets, but it must be returned.
+	if sws := cfg.getKubernetesSDScrapeWork(nil); len(sws) != 0 {
+		t.Fatalf("unexpected number of discovered kubernetes targets; got %d; want 0", len(sws))
+	}
+	pools := ConfiguredScrapePools()
+	poolsExpected := []string{"foo/static_configs", "foo/file_sd_configs", "k8s/kubernetes_sd_configs"}
+	if strings.Join(pools, ",") != strings.Join(poolsExpected, ",") {
+		t.Fatalf("unexpected scrape pools; got %q; want %q", pools, poolsExpected)
+	}
+}
+
+func TestScraperGroupUpdateStructuredLogs(t *testing.T) {
+	var bb bytes.Buffer
+	restore := logger.SetOutputForTests(&bb, "json")
+	sg := newScraperGroup("test_structured_logs", func(wr *prompbmarshal.WriteRequest) {})
+	sg.update(newTestClusterScrapeWorks("test_structured_logs", 3))
+	sg.stop()
+	restore()
+
+	var event map[string]interface{}
+	for _, line := range strings.Split(bb.String(), "\n") {
+		if !strings.Contains(line, "added targets") {
+			continue
+		}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("cannot parse log line %q: %s", line, err)
+		}
+		break
+	}
+	if event == nil {
+		t.Fatalf("missing log event for added targets in logs:\n%s", bb.String())
+	}
+	fieldsExpected := map[string]interface{}{
+		"level":           "info",
+		"msg":             "test_structured_logs: added targets: 3, removed targets: 0; total targets: 3",
+		"type":            "test_structured_logs",
+		"added_targets":   float64(3),
+		"removed_targets": float64(0),
+		"total_targets":   float64(3),
+	}
+	for k, vExpected := range fieldsExpected {
+		if v := event[k]; v != vExpected {
+			t.Fatalf("unexpected value for %q field in log event %v; got %v; want %v", k, event, v, vExpected)
+		}
+	}
+}
+
+func TestScraperGroupLastSuccessfulScrape(t *testing.T) {
+	const group = "test_last_successful_scrape"
+	sg := newScraperGroup(group, func(wr *prompbmarshal.WriteRequest) {})
+	var sw scrapeWork
+	sw.Config = ScrapeWork{
+		ScrapeURL:      "http://foo.bar:1234/metrics",
+		ScrapeInterval: time.Second,
+		Labels: []prompbmarshal.Label{{
+			Name:  "job",
+			Value: group,
+		}},
+	}
+	sw.ScrapeGroup = group
+	sw.skipTargetStatus = true
+	sw.lastSuccessfulScrape = &sg.lastSuccessfulScrape
+	var readErr error
+	sw.ReadData = func(dst []byte) ([]byte, error) {
+		if readErr != nil {
+			return dst, readErr
+		}
+		return append(dst, "foo 1\n"...), nil
+	}
+	sw.PushData = func(wr *prompbmarshal.WriteRequest) {}
+
+	metricName := fmt.Sprintf(`vm_promscrape_last_successful_scrape_timestamp_seconds{type=%q}`, group)
+	f := func(timestamp int64, valueExpected float64) {
+		t.Helper()
+		sw.scrapeAndLogError(timestamp, timestamp)
+		var bb bytes.Buffer
+		metrics.WritePrometheus(&bb, false)
+		for _, line := range strings.Split(bb.String(), "\n") {
+			if !strings.HasPrefix(line, metricName+" ") {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, metricName+" "), 64)
+			if err != nil {
+				t.Fatalf("cannot parse %q: %s", line, err)
+			}
+			if value != valueExpected {
+				t.Fatalf("unexpected %s; got %v; want %v", metricName, value, valueExpected)
+			}
+			return
+		}
+		t.Fatalf("missing %s in metrics:\n%s", metricName, bb.String())
+	}
+
+	// The timestamp advances after successful scrapes.
+	f(1600000000000, 1600000000)
+	f(1600000001500, 1600000001.5)
+
+	// The timestamp doesn't advance after failed scrapes.
+	readErr = fmt.Errorf("cannot connect")
+	f(1600000003000, 1600000001.5)
+	f(1600000004000, 1600000001.5)
+
+	readErr = nil
+	f(1600000005000, 1600000005)
+}
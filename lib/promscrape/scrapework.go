@@ -1,22 +1,28 @@
 package promscrape
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"math"
 	"math/bits"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/leveledbytebufferpool"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
 	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
 	"github.com/VictoriaMetrics/metrics"
 	xxhash "github.com/cespare/xxhash/v2"
 )
@@ -24,6 +30,12 @@ import (
 var (
 	suppressScrapeErrors = flag.Bool("promscrape.suppressScrapeErrors", false, "Whether to suppress scrape errors logging. "+
 		"The last error for each target is always available at '/targets' page even if scrape errors logging is suppressed")
+	timestampTolerance = flag.Duration("promscrape.timestampTolerance", 0, "If set to positive value, then timestamps for scraped samples are aligned to scrape interval boundaries "+
+		"when the actual scrape time deviates from the boundary by less than the given value. This results in evenly-spaced samples for targets scraped with small jitter. "+
+		"By default the timestamp is adjusted only if the jitter exceeds 10% of scrape_interval")
+	startupRampDuration = flag.Duration("promscrape.startupRampDuration", 0, "The duration for spreading the first scrapes of targets after the start. "+
+		"This may be useful for reducing resource usage spikes when scraping big number of targets after the start. "+
+		"By default the first scrapes are spread only over scrape_interval")
 )
 
 // ScrapeWork represents a unit of work for scraping Prometheus metrics.
@@ -34,12 +46,50 @@ type ScrapeWork struct {
 	// Full URL (including query args) for the scrape.
 	ScrapeURL string
 
+	// Additional URLs to scrape together with ScrapeURL during each scrape.
+	//
+	// They are obtained from `metrics_paths` option in `scrape_config`.
+	// Metrics scraped from these urls contain `__metrics_path__` label with the corresponding path,
+	// so it can be used in `metric_relabel_configs`.
+	AdditionalScrapeURLs []string
+
+	// PathsConcurrency limits the number of concurrent requests to ScrapeURL and AdditionalScrapeURLs during each scrape.
+	//
+	// It is obtained from `metrics_paths_concurrency` option in `scrape_config`. defaultMetricsPathsConcurrency is used if it isn't set.
+	PathsConcurrency int
+
+	// BackendScrapeURLs contains urls for backends from `__addresses__` label.
+	//
+	// If it isn't empty, then every scrape of ScrapeURL is performed against the next backend in round-robin manner.
+	// Automatically generated series get `scrape_backend` label with the selected backend address.
+	BackendScrapeURLs []string
+
+	// FallbackScrapeURLs contains urls from `__fallback_scrape_urls__` label.
+	//
+	// They are scraped in order if the scrape of ScrapeURL fails. Automatically generated series get `scrape_url` label with the scraped url.
+	FallbackScrapeURLs []string
+
+	// SchemeAuto is set to true for targets with `scheme: auto`.
+	//
+	// ScrapeURL has https scheme in this case. The target is scraped via http instead if it fails TLS handshake.
+	SchemeAuto bool
+
 	// Interval for scraping the ScrapeURL.
 	ScrapeInterval time.Duration
 
 	// Timeout for scraping the ScrapeURL.
 	ScrapeTimeout time.Duration
 
+	// ScrapeTimeoutOffset is subtracted from ScrapeTimeout when limiting the duration of requests to ScrapeURL.
+	//
+	// This leaves time for processing the scraped response before ScrapeTimeout.
+	ScrapeTimeoutOffset time.Duration
+
+	// ParseTimeout limits the duration of parsing responses in Prometheus text exposition format.
+	//
+	// The parsing duration isn't limited if ParseTimeout is zero. It isn't applied in stream parsing mode.
+	ParseTimeout time.Duration
+
 	// How to deal with conflicting labels.
 	// See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#scrape_config
 	HonorLabels bool
@@ -48,6 +98,9 @@ type ScrapeWork struct {
 	// See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#scrape_config
 	HonorTimestamps bool
 
+	// Limits for timestamps of the scraped samples if HonorTimestamps is set.
+	TimestampLimits *TimestampLimitsConfig
+
 	// OriginalLabels contains original labels before relabeling.
 	//
 	// These labels are needed for relabeling troubleshooting at /targets page.
@@ -86,28 +139,258 @@ type ScrapeWork struct {
 	// Whether to parse target responses in a streaming manner.
 	StreamParse bool
 
+	// The maximum number of retries for failed scrapes because of transient errors.
+	//
+	// Retries are performed only until the next scrape according to ScrapeInterval.
+	ScrapeRetries int
+
+	// Whether to drop scraped samples with NaN and Inf values.
+	DropNaNInf bool
+
+	// Whether to send conditional requests to ScrapeURL with `If-None-Match` and `If-Modified-Since` headers.
+	//
+	// The previously scraped data is re-used if ScrapeURL responds with `304 Not Modified`.
+	ConditionalScrape bool
+
+	// Whether to fail scrapes for responses with unrecognized Content-Type if their body doesn't look like metrics in text exposition format.
+	//
+	// This prevents from parsing HTML error pages returned with 200 status code.
+	CheckContentType bool
+
+	// The gRPC method in the form `/package.Service/Method` to call at the ScrapeURL host instead of the http request.
+	//
+	// This requires building with `grpc` build tag.
+	GRPCMethod string
+
+	// The http method for scrape requests. It is either empty for GET requests or POST.
+	Method string
+
+	// The request body for POST scrape requests.
+	Body string
+
+	// The Content-Type header for POST scrape requests.
+	ContentType string
+
+	// Settings for reading metrics from `s3://bucket/prefix` ScrapeURL.
+	ObjectStore *ObjectStoreConfig
+
+	// Settings for consuming metrics from `kafka://topic` ScrapeURL.
+	//
+	// This requires building with `kafka` build tag.
+	KafkaConsumer *KafkaConsumerConfig
+
+	// Settings for dialing ScrapeURL via SSH tunnel.
+	//
+	// This requires building with `ssh` build tag.
+	SSHTunnel *SSHTunnelConfig
+
+	// Settings for obtaining session cookie before scraping ScrapeURL. Session cookie isn't obtained if Login is nil.
+	Login *LoginConfig
+
+	// Credentials for scraping targets protected by Cloudflare Access. Cloudflare Access headers aren't sent if CloudflareAccess is nil.
+	CloudflareAccess *CloudflareAccessConfig
+
+	// The format of data exposed at ScrapeURL.
+	//
+	// Prometheus text exposition format is expected if ExpositionFormat is empty.
+	// Snappy-compressed prompb.WriteRequest is expected if ExpositionFormat is "promremotewrite".
+	ExpositionFormat string
+
+	// Absolute path to `secrets_file` with AuthConfig. Scrape clients are re-created when the file changes.
+	//
+	// It is empty if AuthConfig isn't loaded from `secrets_file`.
+	SecretsFile string
+
+	// The name of response header with the scrape interval hint from the target.
+	//
+	// If it is set, then the target is scraped at the interval from the header limited by MinInterval and MaxInterval.
+	IntervalHeader string
+	MinInterval    time.Duration
+	MaxInterval    time.Duration
+
+	// The number of consecutive connection failures after which the target isn't scraped for BreakerCooldown.
+	//
+	// The circuit breaker is disabled if BreakerFailures is zero.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+
+	// Label names to keep and to drop in the scraped series after MetricRelabelConfigs.
+	//
+	// All the labels are kept if KeepLabelNames is nil. `__name__` label is always kept.
+	KeepLabelNames *regexp.Regexp
+	DropLabelNames *regexp.Regexp
+
+	// Whether to validate metric names according to legacy Prometheus naming rules. See metricNameValidation* constants.
+	//
+	// Metric names are passed as is if MetricNameValidation is empty. MetricNameAction defines what to do with invalid names.
+	MetricNameValidation string
+	MetricNameAction     string
+
+	// Whether to add per-target `up`, `scrape_duration_seconds`, etc. series or pool-level `scrape_pool_*` series. See healthMetrics* constants.
+	//
+	// Per-target series are added if HealthMetrics is empty. HealthLabels are added to these series.
+	HealthMetrics string
+	HealthLabels  []prompbmarshal.Label
+
+	// How to handle OpenMetrics `<name>_created` series. See createdSeries* constants.
+	//
+	// `_created` series are forwarded as is if CreatedSeries is empty. Stream parsing is disabled if it is set to drop or attach.
+	CreatedSeries string
+
+	// How to collapse samples for the same series exposed multiple times in a single scrape response. See dedupWithinScrape* constants.
+	//
+	// All the samples are pushed if DedupWithinScrape is empty. Stream parsing is disabled if it is set.
+	DedupWithinScrape string
+
+	// Metric names, which must be present in the scraped response. The scrape fails if any of them is missing.
+	//
+	// Stream parsing is disabled if RequireMetrics is set.
+	RequireMetrics []string
+
+	// The number of metric names with the biggest number of series per scrape to show at /api/v1/targets.
+	// The number of series per metric name isn't tracked if CardinalityTopN is zero.
+	CardinalityTopN int
+
+	// The maximum size of decompressed gzip or zstd response in bytes. -promscrape.maxScrapeSize is used if it is zero.
+	MaxDecompressedSize int
+
+	// The priority for obtaining a free slot for the scrape if -promscrape.maxConcurrentScrapes is set. Bigger values mean higher priority.
+	Priority int
+
+	// The charset of responses from ScrapeURL and AdditionalScrapeURLs, which are transcoded to UTF-8 before parsing.
+	// Responses are parsed as is if it is empty. Stream parsing is disabled if ResponseCharset isn't UTF-8.
+	ResponseCharset string
+
+	// The maximum number of redirects followed per scrape. The default limit is used if it is nil. See redirectPolicy.
+	MaxRedirects *int
+
+	// Hosts in the form `host` or `host:port`, which are allowed in redirects in addition to the host from ScrapeURL.
+	// Redirects to any host are allowed if RedirectHosts is empty.
+	RedirectHosts []string
+
+	// Exposition formats to negotiate with ScrapeURL via `Accept` header in the order of preference.
+	//
+	// The default `Accept` header is used if ScrapeProtocols is empty.
+	ScrapeProtocols []string
+
+	// Optional proxy for connecting to ScrapeURL.
+	ProxyURL *proxy.URL
+
 	// The original 'job_name'
 	jobNameOriginal string
 }
 
 // key returns unique identifier for the given sw.
 //
-// it can be used for comparing for equality for two ScrapeWork objects.
+// It can be used for comparing for equality for two ScrapeWork objects.
+// All the exported fields except of ID and OriginalLabels must be added to the key,
+// since the scraper for the target isn't restarted on changes in the missing fields. See TestScrapeWorkKeyCoversAllFields.
 func (sw *ScrapeWork) key() string {
-	// Do not take into account OriginalLabels.
-	key := fmt.Sprintf("ScrapeURL=%s, ScrapeInterval=%s, ScrapeTimeout=%s, HonorLabels=%v, HonorTimestamps=%v, Labels=%s, "+
-		"AuthConfig=%s, MetricRelabelConfigs=%s, SampleLimit=%d, DisableCompression=%v, DisableKeepAlive=%v, StreamParse=%v",
-		sw.ScrapeURL, sw.ScrapeInterval, sw.ScrapeTimeout, sw.HonorLabels, sw.HonorTimestamps, sw.LabelsString(),
-		sw.AuthConfig.String(), sw.metricRelabelConfigsString(), sw.SampleLimit, sw.DisableCompression, sw.DisableKeepAlive, sw.StreamParse)
-	return key
+	// Do not take into account ID and OriginalLabels.
+	var kb scrapeWorkKeyBuilder
+	kb.addString("ScrapeURL", sw.ScrapeURL)
+	kb.addStrings("AdditionalScrapeURLs", sw.AdditionalScrapeURLs)
+	kb.addInt("PathsConcurrency", int64(sw.PathsConcurrency))
+	kb.addStrings("BackendScrapeURLs", sw.BackendScrapeURLs)
+	kb.addStrings("FallbackScrapeURLs", sw.FallbackScrapeURLs)
+	kb.addBool("SchemeAuto", sw.SchemeAuto)
+	kb.addInt("ScrapeInterval", int64(sw.ScrapeInterval))
+	kb.addInt("ScrapeTimeout", int64(sw.ScrapeTimeout))
+	kb.addInt("ScrapeTimeoutOffset", int64(sw.ScrapeTimeoutOffset))
+	kb.addInt("ParseTimeout", int64(sw.ParseTimeout))
+	kb.addBool("HonorLabels", sw.HonorLabels)
+	kb.addBool("HonorTimestamps", sw.HonorTimestamps)
+	kb.addString("TimestampLimits", sw.TimestampLimits.String())
+	kb.addString("Labels", sw.LabelsString())
+	kb.addString("AuthConfig", sw.AuthConfig.String())
+	for i := range sw.MetricRelabelConfigs {
+		kb.addString("MetricRelabelConfigs", sw.MetricRelabelConfigs[i].String())
+	}
+	kb.addInt("SampleLimit", int64(sw.SampleLimit))
+	kb.addBool("DisableCompression", sw.DisableCompression)
+	kb.addBool("DisableKeepAlive", sw.DisableKeepAlive)
+	kb.addBool("StreamParse", sw.StreamParse)
+	kb.addInt("ScrapeRetries", int64(sw.ScrapeRetries))
+	kb.addBool("DropNaNInf", sw.DropNaNInf)
+	kb.addBool("ConditionalScrape", sw.ConditionalScrape)
+	kb.addBool("CheckContentType", sw.CheckContentType)
+	kb.addString("GRPCMethod", sw.GRPCMethod)
+	kb.addString("Method", sw.Method)
+	kb.addString("Body", sw.Body)
+	kb.addString("ContentType", sw.ContentType)
+	kb.addString("ObjectStore", sw.ObjectStore.String())
+	kb.addString("KafkaConsumer", sw.KafkaConsumer.String())
+	kb.addString("SSHTunnel", sw.SSHTunnel.String())
+	kb.addString("Login", sw.Login.String())
+	kb.addString("CloudflareAccess", sw.CloudflareAccess.String())
+	kb.addString("ExpositionFormat", sw.ExpositionFormat)
+	kb.addString("SecretsFile", sw.SecretsFile)
+	kb.addString("IntervalHeader", sw.IntervalHeader)
+	kb.addInt("MinInterval", int64(sw.MinInterval))
+	kb.addInt("MaxInterval", int64(sw.MaxInterval))
+	kb.addInt("BreakerFailures", int64(sw.BreakerFailures))
+	kb.addInt("BreakerCooldown", int64(sw.BreakerCooldown))
+	kb.addString("KeepLabelNames", regexpString(sw.KeepLabelNames))
+	kb.addString("DropLabelNames", regexpString(sw.DropLabelNames))
+	kb.addString("MetricNameValidation", sw.MetricNameValidation)
+	kb.addString("MetricNameAction", sw.MetricNameAction)
+	kb.addString("HealthMetrics", sw.HealthMetrics)
+	kb.addString("HealthLabels", promLabelsString(sw.HealthLabels))
+	kb.addString("CreatedSeries", sw.CreatedSeries)
+	kb.addString("DedupWithinScrape", sw.DedupWithinScrape)
+	kb.addStrings("RequireMetrics", sw.RequireMetrics)
+	kb.addInt("CardinalityTopN", int64(sw.CardinalityTopN))
+	kb.addInt("MaxDecompressedSize", int64(sw.MaxDecompressedSize))
+	kb.addInt("Priority", int64(sw.Priority))
+	kb.addString("ResponseCharset", sw.ResponseCharset)
+	kb.addString("MaxRedirects", maxRedirectsString(sw.MaxRedirects))
+	kb.addStrings("RedirectHosts", sw.RedirectHosts)
+	kb.addStrings("ScrapeProtocols", sw.ScrapeProtocols)
+	kb.addString("ProxyURL", sw.ProxyURL.String())
+	return kb.String()
+}
+
+// scrapeWorkKeyBuilder builds keys for ScrapeWork without the overhead of fmt.Sprintf.
+//
+// Every value is prefixed with its length, so distinct values cannot result in the same key.
+type scrapeWorkKeyBuilder struct {
+	b []byte
 }
 
-func (sw *ScrapeWork) metricRelabelConfigsString() string {
-	var sb strings.Builder
-	for _, prc := range sw.MetricRelabelConfigs {
-		fmt.Fprintf(&sb, "%s", prc.String())
+func (kb *scrapeWorkKeyBuilder) addName(name string) {
+	if len(kb.b) > 0 {
+		kb.b = append(kb.b, ", "...)
 	}
-	return sb.String()
+	kb.b = append(kb.b, name...)
+	kb.b = append(kb.b, '=')
+}
+
+func (kb *scrapeWorkKeyBuilder) addString(name, value string) {
+	kb.addName(name)
+	kb.b = strconv.AppendInt(kb.b, int64(len(value)), 10)
+	kb.b = append(kb.b, ':')
+	kb.b = append(kb.b, value...)
+}
+
+func (kb *scrapeWorkKeyBuilder) addStrings(name string, values []string) {
+	for _, value := range values {
+		kb.addString(name, value)
+	}
+}
+
+func (kb *scrapeWorkKeyBuilder) addInt(name string, n int64) {
+	kb.addName(name)
+	kb.b = strconv.AppendInt(kb.b, n, 10)
+}
+
+func (kb *scrapeWorkKeyBuilder) addBool(name string, v bool) {
+	kb.addName(name)
+	kb.b = strconv.AppendBool(kb.b, v)
+}
+
+// String returns the built key.
+func (kb *scrapeWorkKeyBuilder) String() string {
+	return string(kb.b)
 }
 
 // Job returns job for the ScrapeWork
@@ -149,9 +432,15 @@ type scrapeWork struct {
 	// ReadData is called for reading the data.
 	ReadData func(dst []byte) ([]byte, error)
 
+	// ReadAdditionalData is called for reading the data from Config.AdditionalScrapeURLs[idx].
+	ReadAdditionalData func(idx int, dst []byte) ([]byte, error)
+
 	// GetStreamReader is called if Config.StreamParse is set.
 	GetStreamReader func() (*streamReader, error)
 
+	// GetAdditionalStreamReader is called for Config.AdditionalScrapeURLs[idx] if Config.StreamParse is set.
+	GetAdditionalStreamReader func(idx int) (*streamReader, error)
+
 	// PushData is called for pushing collected data.
 	PushData func(wr *prompbmarshal.WriteRequest)
 
@@ -159,6 +448,24 @@ type scrapeWork struct {
 	// scrapeWork belongs to
 	ScrapeGroup string
 
+	// lastSuccessfulScrape points to the timestamp in milliseconds of the last successful scrape in ScrapeGroup.
+	// It is nil for scrapeWork outside scraperGroup.
+	lastSuccessfulScrape *uint64
+
+	// notModifiedRows contains rows parsed from notModifiedBody during the last successful scrape if Config.ConditionalScrape is set.
+	// They are re-used when the target responds with `304 Not Modified`.
+	notModifiedRows parser.Rows
+	notModifiedBody *bytesutil.ByteBuffer
+
+	// skipTargetStatus disables updating the target status shown at `/targets` page.
+	// It is set for one-shot scrapes performed by ScrapeOnce.
+	skipTargetStatus bool
+
+	// disableStreamParse disables stream parsing regardless of -promscrape.streamParse and Config.StreamParse.
+	// It is set for one-shot scrapes performed by ScrapeOnce, since stream parsing requires unmarshal workers,
+	// which may be not started by ScrapeOnce callers.
+	disableStreamParse bool
+
 	tmpRow parser.Row
 
 	// the seriesMap, seriesAdded and labelsHashBuf are used for fast calculation of `scrape_series_added` metric.
@@ -173,9 +480,103 @@ type scrapeWork struct {
 	// prevRowsLen contains the number rows scraped during the previous scrape.
 	// It is used as a hint in order to reduce memory usage when parsing scrape responses.
 	prevRowsLen int
+
+	// prevSamplesScraped contains the number of samples scraped during the previous scrape.
+	// It is used for detecting sudden spikes in the number of scraped samples.
+	prevSamplesScraped int
+
+	// lastSamplesSpikeWarnTime contains the last time in seconds when a warning about samples spike has been logged.
+	// It is used for throttling such warnings.
+	lastSamplesSpikeWarnTime uint64
+
+	// additionalScrapes contains the state for scraping Config.AdditionalScrapeURLs.
+	additionalScrapes []additionalScrape
+
+	// backends contains backends for Config.BackendScrapeURLs. They are selected in round-robin manner by selectBackend.
+	backends    []scrapeBackend
+	nextBackend int
+
+	// autoLabels contains labels for automatically generated series during the current scrape.
+	// Config.Labels are used if it is nil.
+	autoLabels []prompbmarshal.Label
+
+	// healthLabels is a buffer for autoLabels plus Config.HealthLabels.
+	healthLabels []prompbmarshal.Label
+
+	// schemeAuto is set for targets with `scheme: auto`.
+	schemeAuto *schemeAutoClient
+
+	// intervalHint receives Config.IntervalHeader values from the target responses. It is nil if Config.IntervalHeader isn't set.
+	intervalHint *scrapeIntervalHint
+
+	// fallback is set for targets with Config.FallbackScrapeURLs.
+	fallback *fallbackClient
+
+	// pendingAuthConfig contains auth config from the changed Config.SecretsFile, which must be applied before the next scrape.
+	pendingAuthConfig pendingAuthConfig
+
+	// metricNames counts series per metric name during the scrape if Config.CardinalityTopN is set.
+	metricNames metricNamesCounter
+
+	// limiter limits the number of concurrent scrapes if -promscrape.maxConcurrentScrapes is set.
+	limiter *scrapeLimiter
+
+	// sharedFetchKey is the key for sharing the scraped response with other targets if -promscrape.dedupIdenticalTargets is set.
+	// It is set by sharedFetches.register.
+	sharedFetchKey string
+
+	// circuitBreaker stops scraping the target after Config.BreakerFailures consecutive connection failures.
+	// It is initialized lazily by getCircuitBreaker. It is nil if Config.BreakerFailures isn't set.
+	circuitBreaker *circuitBreaker
+
+	// labelNamesCache contains the results of matching label names against Config.KeepLabelNames and Config.DropLabelNames.
+	labelNamesCache map[string]bool
+
+	// poolLabels contains labels for pool-level series if Config.HealthMetrics is set to aggregated.
+	// It is initialized lazily by addPoolTimeseries.
+	poolLabels []prompbmarshal.Label
+
+	// createdSeries contains the state for handling `_created` series if Config.CreatedSeries is set.
+	// It is initialized lazily by processCreatedRows.
+	createdSeries *createdSeriesState
+
+	// dedupState contains the state for collapsing duplicate series if Config.DedupWithinScrape is set.
+	// It is initialized lazily by dedupRows.
+	dedupState *dedupRowsState
+
+	// Per-job histograms. They are initialized lazily by initJobMetrics.
+	jobScrapeResponseSize *metrics.Histogram
+	jobScrapedSamples     *metrics.Histogram
 }
 
+// getStartupRampDelay returns the minimum delay for the first scrape of the target with the given key.
+//
+// The first scrapes of all the targets are spread evenly over -promscrape.startupRampDuration,
+// which starts when the first target is scraped.
+func getStartupRampDelay(key string) time.Duration {
+	if *startupRampDuration <= 0 {
+		return 0
+	}
+	startupRampOnce.Do(func() {
+		startupRampStartTime = time.Now()
+	})
+	// Use the upper 32 bits of the hash, since the lower bits are used for calculating scrape offset.
+	h := uint32(xxhash.Sum64([]byte(key)) >> 32)
+	rampOffset := time.Duration(float64(*startupRampDuration) * (float64(h) / (1 << 32)))
+	return time.Until(startupRampStartTime.Add(rampOffset))
+}
+
+var (
+	startupRampOnce      sync.Once
+	startupRampStartTime time.Time
+)
+
 func (sw *scrapeWork) run(stopCh <-chan struct{}) {
+	if isKafkaTarget(sw.Config.ScrapeURL) && runKafkaConsumer != nil {
+		// Messages from Kafka topic are processed as soon as they are consumed instead of periodic scrapes.
+		runKafkaConsumer(sw, stopCh)
+		return
+	}
 	// Calculate start time for the first scrape from ScrapeURL and labels.
 	// This should spread load when scraping many targets with different
 	// scrape urls and labels.
@@ -184,50 +585,203 @@ func (sw *scrapeWork) run(stopCh <-chan struct{}) {
 	scrapeInterval := sw.Config.ScrapeInterval
 	key := fmt.Sprintf("ScrapeURL=%s, Labels=%s", sw.Config.ScrapeURL, sw.Config.LabelsString())
 	h := uint32(xxhash.Sum64([]byte(key)))
-	randSleep := uint64(float64(scrapeInterval) * (float64(h) / (1 << 32)))
+	scrapeOffset := uint64(float64(scrapeInterval) * (float64(h) / (1 << 32)))
+	randSleep := scrapeOffset
 	sleepOffset := uint64(time.Now().UnixNano()) % uint64(scrapeInterval)
 	if randSleep < sleepOffset {
 		randSleep += uint64(scrapeInterval)
 	}
 	randSleep -= sleepOffset
+	if rampDelay := getStartupRampDelay(key); rampDelay > 0 {
+		// Postpone the first scrape to the scrape time after rampDelay, so scrape timestamps stay consistent.
+		for time.Duration(randSleep) < rampDelay {
+			randSleep += uint64(scrapeInterval)
+		}
+	}
 	timer := time.NewTimer(time.Duration(randSleep))
+	scrapeOffsetMsecs := int64(scrapeOffset / 1e6)
+	tolerance := timestampTolerance.Milliseconds()
 	var timestamp int64
-	var ticker *time.Ticker
+	var ss *scrapeScheduler
 	select {
 	case <-stopCh:
 		timer.Stop()
 		return
-	case <-timer.C:
-		ticker = time.NewTicker(scrapeInterval)
-		timestamp = time.Now().UnixNano() / 1e6
-		sw.scrapeAndLogError(timestamp, timestamp)
+	case tt := <-timer.C:
+		ss = newScrapeScheduler(tt, scrapeInterval)
+		t, ok := sw.acquireScrapeSlot(time.Now().UnixNano()/1e6, stopCh)
+		if !ok {
+			return
+		}
+		timestamp = t
+		if tolerance > 0 {
+			timestamp = alignScrapeTimestamp(t, scrapeOffsetMsecs, scrapeInterval.Milliseconds(), tolerance)
+		}
+		sw.scrapeAndLogError(timestamp, t)
+		sw.limiter.release()
+		if d := sw.getAdaptiveInterval(scrapeInterval); d != scrapeInterval {
+			scrapeInterval = d
+			ss = newScrapeScheduler(tt, scrapeInterval)
+		}
 	}
-	defer ticker.Stop()
 	for {
+		// Schedule the next scrape against absolute schedule, so the time spent on scraping doesn't accumulate into drift.
+		nextTime, skipped := ss.next(time.Now())
+		if skipped > 0 {
+			scrapesSkipped.Add(int(skipped))
+			timestamp += int64(skipped) * scrapeInterval.Milliseconds()
+		}
 		timestamp += scrapeInterval.Milliseconds()
+		timer.Reset(time.Until(nextTime))
 		select {
 		case <-stopCh:
+			timer.Stop()
 			return
-		case tt := <-ticker.C:
-			t := tt.UnixNano() / 1e6
-			if d := math.Abs(float64(t - timestamp)); d > 0 && d/float64(scrapeInterval.Milliseconds()) > 0.1 {
+		case tt := <-timer.C:
+			t, ok := sw.acquireScrapeSlot(tt.UnixNano()/1e6, stopCh)
+			if !ok {
+				return
+			}
+			if tolerance > 0 {
+				timestamp = alignScrapeTimestamp(t, scrapeOffsetMsecs, scrapeInterval.Milliseconds(), tolerance)
+			} else if d := math.Abs(float64(t - timestamp)); d > 0 && d/float64(scrapeInterval.Milliseconds()) > 0.1 {
 				// Too big jitter. Adjust timestamp
 				timestamp = t
 			}
 			sw.scrapeAndLogError(timestamp, t)
+			sw.limiter.release()
+			if d := sw.getAdaptiveInterval(scrapeInterval); d != scrapeInterval {
+				// Re-start the schedule from the current scrape time with the interval from the target response.
+				scrapeInterval = d
+				ss = newScrapeScheduler(tt, scrapeInterval)
+			}
 		}
 	}
 }
 
+// scrapeScheduler calculates scrape times according to absolute schedule start+N*interval.
+type scrapeScheduler struct {
+	start    time.Time
+	interval time.Duration
+	n        int64
+}
+
+func newScrapeScheduler(start time.Time, interval time.Duration) *scrapeScheduler {
+	return &scrapeScheduler{
+		start:    start,
+		interval: interval,
+	}
+}
+
+// next returns the next scrape time after the current scrape, which has been finished at now.
+//
+// If the current scrape overruns one or more scrape times, then they are skipped, so the returned time stays aligned with the schedule.
+// The number of skipped scrape times is returned in skipped.
+func (ss *scrapeScheduler) next(now time.Time) (time.Time, uint64) {
+	ss.n++
+	nextTime := ss.start.Add(time.Duration(ss.n) * ss.interval)
+	if !now.After(nextTime) {
+		return nextTime, 0
+	}
+	skipped := uint64(now.Sub(nextTime)/ss.interval) + 1
+	ss.n += int64(skipped)
+	return ss.start.Add(time.Duration(ss.n) * ss.interval), skipped
+}
+
+// alignScrapeTimestamp aligns timestamp t to the nearest scrape interval boundary if t deviates from the boundary by no more than tolerance.
+//
+// Scrape interval boundaries are located at offset+N*interval. All the args are in milliseconds.
+// The original t is returned if it deviates from the nearest boundary by more than tolerance.
+func alignScrapeTimestamp(t, offset, interval, tolerance int64) int64 {
+	if interval <= 0 {
+		return t
+	}
+	d := (t - offset) % interval
+	if d < 0 {
+		d += interval
+	}
+	aligned := t - d
+	if d > interval/2 {
+		aligned += interval
+		d = interval - d
+	}
+	if d > tolerance {
+		return t
+	}
+	return aligned
+}
+
 func (sw *scrapeWork) logError(s string) {
+	// logError is called only for lines, which cannot be parsed.
+	sw.registerScrapeError(scrapeErrorReasonParse)
 	if !*suppressScrapeErrors {
-		logger.ErrorfSkipframes(1, "error when scraping %q from job %q with labels %s: %s", sw.Config.ScrapeURL, sw.Config.Job(), sw.Config.LabelsString(), s)
+		sw.withLogFields(logger.Field{Key: "error_reason", Value: scrapeErrorReasonParse}, logger.Field{Key: "error", Value: s}).
+			ErrorfSkipframes(1, "error when scraping %q from job %q with labels %s: %s", sw.Config.ScrapeURL, sw.Config.Job(), sw.Config.LabelsString(), s)
+	}
+}
+
+// withLogFields returns logger with structured fields for sw plus the given extra fields.
+//
+// The fields are visible only when `-loggerFormat=json` is set.
+func (sw *scrapeWork) withLogFields(extra ...logger.Field) *logger.FieldsLogger {
+	fields := []logger.Field{
+		{Key: "scrape_pool", Value: sw.Config.Job()},
+		{Key: "type", Value: sw.ScrapeGroup},
+		{Key: "target_url", Value: sw.Config.ScrapeURL},
+		{Key: "labels", Value: sw.Config.LabelsString()},
+	}
+	fields = append(fields, extra...)
+	return logger.WithFields(fields...)
+}
+
+// registerScrapeError increments `vm_promscrape_scrape_errors_total` metric for the given reason.
+//
+// The reason must be one of scrapeErrorReason* constants.
+func (sw *scrapeWork) registerScrapeError(reason string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`vm_promscrape_scrape_errors_total{type=%q, reason=%q}`, sw.ScrapeGroup, reason)).Inc()
+}
+
+// acquireScrapeSlot waits for a free slot in sw.limiter for the scrape started at the given timestamp t in milliseconds.
+//
+// It returns the timestamp when the slot has been obtained, so the scrape timestamp is calculated after waiting for the slot.
+// False is returned if stopCh is closed while waiting. The scrape must be dropped then.
+// Otherwise sw.limiter.release must be called after the scrape.
+func (sw *scrapeWork) acquireScrapeSlot(t int64, stopCh <-chan struct{}) (int64, bool) {
+	if sw.limiter == nil {
+		return t, true
+	}
+	if !sw.limiter.acquire(sw.Config.Priority, stopCh) {
+		return 0, false
 	}
+	// Do not take into account the time spent waiting for a free slot in the scrape duration.
+	return time.Now().UnixNano() / 1e6, true
 }
 
 func (sw *scrapeWork) scrapeAndLogError(scrapeTimestamp, realTimestamp int64) {
-	if err := sw.scrapeInternal(scrapeTimestamp, realTimestamp); err != nil && !*suppressScrapeErrors {
-		logger.Errorf("error when scraping %q from job %q with labels %s: %s", sw.Config.ScrapeURL, sw.Config.Job(), sw.Config.LabelsString(), err)
+	err := sw.scrapeInternal(scrapeTimestamp, realTimestamp)
+	if err == nil {
+		sw.registerSuccessfulScrape(realTimestamp)
+		return
+	}
+	if !*suppressScrapeErrors {
+		sw.withLogFields(logger.Field{Key: "error_reason", Value: getScrapeErrorReason(err)}, logger.Field{Key: "error", Value: err.Error()}).
+			Errorf("error when scraping %q from job %q with labels %s: %s", sw.Config.ScrapeURL, sw.Config.Job(), sw.Config.LabelsString(), err)
+	}
+}
+
+// registerSuccessfulScrape updates `vm_promscrape_last_successful_scrape_timestamp_seconds` for sw.ScrapeGroup with the given timestamp in milliseconds.
+//
+// The timestamp is never moved backwards, since concurrently running scrapers may finish their scrapes out of order.
+func (sw *scrapeWork) registerSuccessfulScrape(timestamp int64) {
+	p := sw.lastSuccessfulScrape
+	if p == nil {
+		return
+	}
+	for {
+		prev := atomic.LoadUint64(p)
+		if uint64(timestamp) <= prev || atomic.CompareAndSwapUint64(p, prev, uint64(timestamp)) {
+			return
+		}
 	}
 }
 
@@ -236,12 +790,67 @@ var (
 	scrapeResponseSize          = metrics.NewHistogram("vm_promscrape_scrape_response_size_bytes")
 	scrapedSamples              = metrics.NewHistogram("vm_promscrape_scraped_samples")
 	scrapesSkippedBySampleLimit = metrics.NewCounter("vm_promscrape_scrapes_skipped_by_sample_limit_total")
+	scrapesSkipped              = metrics.NewCounter("vm_promscrape_scrapes_skipped_total")
 	scrapesFailed               = metrics.NewCounter("vm_promscrape_scrapes_failed_total")
 	pushDataDuration            = metrics.NewHistogram("vm_promscrape_push_data_duration_seconds")
+	scrapedSamplesSpikes        = metrics.NewCounter("vm_promscrape_scraped_samples_spikes_total")
+	droppedNaNInf               = metrics.NewCounter("vm_promscrape_dropped_nan_inf_total")
+)
+
+func (sw *scrapeWork) initJobMetrics() {
+	if sw.jobScrapeResponseSize != nil {
+		return
+	}
+	job := sw.Config.jobNameOriginal
+	sw.jobScrapeResponseSize = metrics.GetOrCreateHistogram(fmt.Sprintf(`vm_promscrape_scrape_response_size_bytes{job=%q}`, job))
+	sw.jobScrapedSamples = metrics.GetOrCreateHistogram(fmt.Sprintf(`vm_promscrape_scraped_samples{job=%q}`, job))
+}
+
+func (sw *scrapeWork) updateScrapeSizeMetrics(responseSize int64, samplesScraped int) {
+	sw.initJobMetrics()
+	scrapeResponseSize.Update(float64(responseSize))
+	sw.jobScrapeResponseSize.Update(float64(responseSize))
+	scrapedSamples.Update(float64(samplesScraped))
+	sw.jobScrapedSamples.Update(float64(samplesScraped))
+}
+
+// checkSamplesSpike logs a warning if the number of scraped samples exceeds
+// the number of samples from the previous scrape by more than samplesSpikeRatio times.
+//
+// Such spikes usually mean cardinality explosion at the target.
+// Warnings are logged at most once per samplesSpikeWarnInterval seconds per target.
+//
+// It returns true if the warning has been logged.
+func (sw *scrapeWork) checkSamplesSpike(samplesScraped int) bool {
+	prev := sw.prevSamplesScraped
+	sw.prevSamplesScraped = samplesScraped
+	if prev <= 0 || samplesScraped <= samplesSpikeRatio*prev {
+		return false
+	}
+	scrapedSamplesSpikes.Inc()
+	currentTime := fasttime.UnixTimestamp()
+	if sw.lastSamplesSpikeWarnTime > 0 && currentTime-sw.lastSamplesSpikeWarnTime < samplesSpikeWarnInterval {
+		return false
+	}
+	sw.lastSamplesSpikeWarnTime = currentTime
+	sw.withLogFields(logger.Field{Key: "samples_scraped_prev", Value: prev}, logger.Field{Key: "samples_scraped", Value: samplesScraped}).
+		Warnf("the number of samples scraped from %q from job %q with labels %s jumped from %d to %d; this may indicate cardinality explosion at the target",
+			sw.Config.ScrapeURL, sw.Config.Job(), sw.Config.LabelsString(), prev, samplesScraped)
+	return true
+}
+
+const (
+	samplesSpikeRatio        = 2
+	samplesSpikeWarnInterval = 60
 )
 
 func (sw *scrapeWork) scrapeInternal(scrapeTimestamp, realTimestamp int64) error {
-	if *streamParse || sw.Config.StreamParse {
+	sw.applyPendingAuthConfig()
+	sw.selectBackend()
+	isRemoteWrite := sw.Config.ExpositionFormat == expositionFormatRemoteWrite
+	if (*streamParse || sw.Config.StreamParse) && !sw.disableStreamParse && !isRemoteWrite && sw.Config.GRPCMethod == "" && sw.Config.DedupWithinScrape == "" && len(sw.Config.RequireMetrics) == 0 &&
+		getResponseCharset(sw.Config.ResponseCharset) == nil && !needCreatedSeriesProcessing(sw.Config.CreatedSeries) &&
+		!isKafkaTarget(sw.Config.ScrapeURL) {
 		// Read data from scrape targets in streaming manner.
 		// This case is optimized for targets exposing millions and more of metrics per target.
 		return sw.scrapeStream(scrapeTimestamp, realTimestamp)
@@ -252,36 +861,187 @@ func (sw *scrapeWork) scrapeInternal(scrapeTimestamp, realTimestamp int64) error
 	// up to a few thousand metrics.
 	body := leveledbytebufferpool.Get(sw.prevBodyLen)
 	var err error
-	body.B, err = sw.ReadData(body.B[:0])
+	cb := sw.getCircuitBreaker()
+	cbOpen := !cb.allow(realTimestamp)
+	// Additional urls are scraped concurrently with ScrapeURL, so the scrape duration is close to the duration for the slowest url.
+	waitAdditionalData := sw.readAdditionalData(cbOpen)
+	if cbOpen {
+		err = errCircuitBreakerOpen
+	} else {
+		body.B, err = sharedFetchesGlobal.readData(sw, body.B[:0])
+		cb.registerResult(err, realTimestamp)
+		sw.selectFallbackLabels()
+	}
+	notModified := false
+	if err == errNotModified {
+		// The target responded with `304 Not Modified` to conditional scrape. Re-use the rows parsed during the previous scrape.
+		err = nil
+		notModified = true
+	}
+	if err != nil {
+		sw.registerScrapeError(getScrapeErrorReason(err))
+	}
+	needCacheRows := sw.Config.ConditionalScrape && err == nil && !notModified
+	waitAdditionalData()
 	endTimestamp := time.Now().UnixNano() / 1e6
 	duration := float64(endTimestamp-realTimestamp) / 1e3
 	scrapeDuration.Update(duration)
-	scrapeResponseSize.Update(float64(len(body.B)))
-	up := 1
 	wc := writeRequestCtxPool.Get(sw.prevRowsLen)
-	if err != nil {
-		up = 0
-		scrapesFailed.Inc()
-	} else {
-		bodyString := bytesutil.ToUnsafeString(body.B)
-		wc.rows.UnmarshalWithErrLogger(bodyString, sw.logError)
+	if err == nil && !notModified {
+		if isRemoteWrite {
+			if err = wc.ru.unmarshal(&wc.rows, body.B); err != nil {
+				sw.registerScrapeError(scrapeErrorReasonParse)
+				err = fmt.Errorf("cannot parse response from %q: %w", sw.Config.ScrapeURL, err)
+			}
+		} else {
+			body.B = getResponseCharset(sw.Config.ResponseCharset).decode(body.B)
+			err = sw.unmarshalRows(&wc.rows, body.B, sw.Config.ScrapeURL)
+		}
+	}
+	if !notModified {
+		// Rows cached for `304 Not Modified` responses are already processed.
+		wc.rows.Rows = sw.processCreatedRows(wc.rows.Rows)
+		// Duplicate series are collapsed before counting scrape_samples_scraped.
+		wc.rows.Rows = sw.dedupRows(wc.rows.Rows)
 	}
 	srcRows := wc.rows.Rows
+	if notModified {
+		srcRows = sw.notModifiedRows.Rows
+	}
 	samplesScraped := len(srcRows)
-	scrapedSamples.Update(float64(samplesScraped))
+	responseSize := len(body.B)
+	// bodySize is the size of successfully scraped responses for `scrape_body_size_bytes` metric.
+	bodySize := int64(0)
+	if err == nil {
+		bodySize = int64(len(body.B))
+		if notModified {
+			// The body isn't read for `304 Not Modified` responses, so report the size of the previously read body.
+			bodySize = int64(sw.prevBodyLen)
+		}
+	}
+	for i := range sw.additionalScrapes {
+		as := &sw.additionalScrapes[i]
+		responseSize += len(as.body.B)
+		if as.err == nil {
+			if isRemoteWrite {
+				if as.err = as.ru.unmarshal(&as.rows, as.body.B); as.err != nil {
+					sw.registerScrapeError(scrapeErrorReasonParse)
+					as.err = fmt.Errorf("cannot parse response from %q: %w", sw.Config.AdditionalScrapeURLs[i], as.err)
+				}
+			} else {
+				as.body.B = getResponseCharset(sw.Config.ResponseCharset).decode(as.body.B)
+				as.err = sw.unmarshalRows(&as.rows, as.body.B, sw.Config.AdditionalScrapeURLs[i])
+			}
+			as.rows.Rows = sw.processCreatedRows(as.rows.Rows)
+			as.rows.Rows = sw.dedupRows(as.rows.Rows)
+			samplesScraped += len(as.rows.Rows)
+			if as.err == nil {
+				bodySize += int64(len(as.body.B))
+			}
+		}
+	}
+	var topMetricNames []metricNameSeries
+	if sw.Config.CardinalityTopN > 0 {
+		sw.metricNames.add(srcRows)
+		for i := range sw.additionalScrapes {
+			sw.metricNames.add(sw.additionalScrapes[i].rows.Rows)
+		}
+		topMetricNames = sw.metricNames.top(sw.Config.CardinalityTopN)
+	}
+	up, err := sw.getScrapeStatus(err)
+	if cbOpen {
+		// Do not repeat the same error for every additional url.
+		err = errCircuitBreakerOpen
+	}
+	if up == 0 {
+		scrapesFailed.Inc()
+	}
+	sw.updateScrapeSizeMetrics(int64(responseSize), samplesScraped)
+	sw.checkSamplesSpike(samplesScraped)
+	needRows := true
+	if up == 1 {
+		if errLocal := sw.checkRequiredMetrics(srcRows); errLocal != nil {
+			// Do not ingest the response, since it doesn't look like the expected metrics.
+			sw.registerScrapeError(scrapeErrorReasonSchemaValidation)
+			scrapesFailed.Inc()
+			needRows = false
+			up = 0
+			err = errLocal
+		}
+	}
 	if sw.Config.SampleLimit > 0 && samplesScraped > sw.Config.SampleLimit {
-		srcRows = srcRows[:0]
+		needRows = false
 		up = 0
 		scrapesSkippedBySampleLimit.Inc()
 	}
 	samplesPostRelabeling := 0
-	for i := range srcRows {
-		sw.addRowToTimeseries(wc, &srcRows[i], scrapeTimestamp, true)
+	if needRows {
+		samplesPostRelabeling += sw.addRowsToTimeseries(wc, srcRows, sw.Config.Labels, scrapeTimestamp)
+		for i := range sw.additionalScrapes {
+			as := &sw.additionalScrapes[i]
+			samplesPostRelabeling += sw.addRowsToTimeseries(wc, as.rows.Rows, as.labels, scrapeTimestamp)
+		}
+	}
+	samplesPostRelabeling += len(wc.writeRequest.Timeseries)
+	if sw.createdSeries != nil {
+		sw.createdSeries.finalizeScrape(notModified)
+	}
+	sw.updateSeriesAdded(wc)
+	seriesAdded := sw.finalizeSeriesAdded(samplesPostRelabeling)
+	if up == 0 {
+		bodySize = 0
+	}
+	sw.addHealthTimeseries(wc, &targetHealth{
+		up:                    up == 1,
+		duration:              duration,
+		samplesScraped:        samplesScraped,
+		samplesPostRelabeling: samplesPostRelabeling,
+		seriesAdded:           seriesAdded,
+		bodySize:              bodySize,
+	}, scrapeTimestamp)
+	startTime := time.Now()
+	sw.PushData(&wc.writeRequest)
+	pushDataDuration.UpdateDuration(startTime)
+	sw.prevRowsLen = samplesScraped
+	if !notModified {
+		sw.prevBodyLen = len(body.B)
+	}
+	if needCacheRows {
+		// Keep the parsed rows together with the body they refer to, so they could be re-used on `304 Not Modified` response.
+		sw.notModifiedRows, wc.rows = wc.rows, sw.notModifiedRows
+		sw.notModifiedBody, body = body, sw.notModifiedBody
+	}
+	wc.reset()
+	writeRequestCtxPool.Put(wc)
+	// body must be released only after wc is released, since wc refers to body.
+	if body != nil {
+		leveledbytebufferpool.Put(body)
+	}
+	sw.releaseAdditionalData()
+	if !sw.skipTargetStatus {
+		tsmGlobal.Update(sw.getStatusConfig(), sw.ScrapeGroup, up == 1, realTimestamp, int64(duration*1000), samplesScraped, err, sw.circuitBreaker.getState(), topMetricNames)
+	}
+	if up == 1 && err != nil {
+		// Partial scrape - some of metrics paths have been scraped successfully.
+		sw.logPartialScrapeError(err)
+		return nil
+	}
+	return err
+}
+
+// addRowsToTimeseries adds rows with the given targetLabels to wc.
+//
+// It pushes the collected time series if wc becomes too big.
+// It returns the number of pushed time series.
+func (sw *scrapeWork) addRowsToTimeseries(wc *writeRequestCtx, rows []parser.Row, targetLabels []prompbmarshal.Label, timestamp int64) int {
+	samplesPushed := 0
+	for i := range rows {
+		sw.addRowToTimeseries(wc, &rows[i], targetLabels, timestamp, true)
 		if len(wc.labels) > 40000 {
 			// Limit the maximum size of wc.writeRequest.
 			// This should reduce memory usage when scraping targets with millions of metrics and/or labels.
 			// For example, when scraping /federate handler from Prometheus - see https://prometheus.io/docs/prometheus/latest/federation/
-			samplesPostRelabeling += len(wc.writeRequest.Timeseries)
+			samplesPushed += len(wc.writeRequest.Timeseries)
 			sw.updateSeriesAdded(wc)
 			startTime := time.Now()
 			sw.PushData(&wc.writeRequest)
@@ -289,42 +1049,305 @@ func (sw *scrapeWork) scrapeInternal(scrapeTimestamp, realTimestamp int64) error
 			wc.resetNoRows()
 		}
 	}
-	samplesPostRelabeling += len(wc.writeRequest.Timeseries)
-	sw.updateSeriesAdded(wc)
+	return samplesPushed
+}
+
+// additionalScrape contains the state for scraping ScrapeWork.AdditionalScrapeURLs entry.
+type additionalScrape struct {
+	// labels contains ScrapeWork.Labels with `__metrics_path__` label set to the path for the additional url.
+	labels []prompbmarshal.Label
+
+	body        *bytesutil.ByteBuffer
+	rows        parser.Rows
+	ru          remoteWriteUnmarshaler
+	err         error
+	prevBodyLen int
+}
+
+func (sw *scrapeWork) initAdditionalScrapes() {
+	if len(sw.additionalScrapes) == len(sw.Config.AdditionalScrapeURLs) {
+		return
+	}
+	sw.additionalScrapes = make([]additionalScrape, len(sw.Config.AdditionalScrapeURLs))
+	for i, scrapeURL := range sw.Config.AdditionalScrapeURLs {
+		metricsPath := "/metrics"
+		if u, err := url.Parse(scrapeURL); err == nil {
+			metricsPath = u.Path
+		}
+		labels := append([]prompbmarshal.Label{}, sw.Config.Labels...)
+		if label := promrelabel.GetLabelByName(labels, "__metrics_path__"); label != nil {
+			label.Value = metricsPath
+		} else {
+			labels = append(labels, prompbmarshal.Label{
+				Name:  "__metrics_path__",
+				Value: metricsPath,
+			})
+		}
+		sw.additionalScrapes[i].labels = labels
+	}
+}
+
+// defaultMetricsPathsConcurrency is the default number of concurrent requests per target when scraping `metrics_paths`.
+const defaultMetricsPathsConcurrency = 4
+
+// readAdditionalData starts reading data from sw.Config.AdditionalScrapeURLs.
+//
+// The urls are read concurrently by up to sw.Config.PathsConcurrency-1 workers, since one more request is made to sw.Config.ScrapeURL
+// by the caller in the meantime. The urls are read sequentially after the returned func is called if sw.Config.PathsConcurrency is 1.
+// The returned func must be called before accessing the data. It returns after all the urls are read.
+//
+// The urls aren't scraped if cbOpen is set, since the target is unreachable according to its circuit breaker.
+// The data must be released with releaseAdditionalData when it is no longer needed.
+func (sw *scrapeWork) readAdditionalData(cbOpen bool) func() {
+	if len(sw.Config.AdditionalScrapeURLs) == 0 {
+		return func() {}
+	}
+	sw.initAdditionalScrapes()
+	for i := range sw.additionalScrapes {
+		as := &sw.additionalScrapes[i]
+		as.body = leveledbytebufferpool.Get(as.prevBodyLen)
+		if cbOpen {
+			as.err = errCircuitBreakerOpen
+		}
+	}
+	if cbOpen {
+		return func() {}
+	}
+	concurrency := sw.Config.PathsConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultMetricsPathsConcurrency
+	}
+	workers := concurrency - 1
+	if workers <= 0 {
+		return func() {
+			for i := range sw.additionalScrapes {
+				sw.readAdditionalDataAt(i)
+			}
+		}
+	}
+	if workers > len(sw.additionalScrapes) {
+		workers = len(sw.additionalScrapes)
+	}
+	idxCh := make(chan int, len(sw.additionalScrapes))
+	for i := range sw.additionalScrapes {
+		idxCh <- i
+	}
+	close(idxCh)
+	var wg sync.WaitGroup
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				sw.readAdditionalDataAt(i)
+			}
+		}()
+	}
+	return wg.Wait
+}
+
+// readAdditionalDataAt reads data from sw.Config.AdditionalScrapeURLs[idx].
+//
+// It may be called concurrently for distinct idx values, since the client shared among the urls is safe for concurrent use.
+func (sw *scrapeWork) readAdditionalDataAt(idx int) {
+	as := &sw.additionalScrapes[idx]
+	as.body.B, as.err = sw.ReadAdditionalData(idx, as.body.B[:0])
+	if as.err != nil {
+		sw.registerScrapeError(getScrapeErrorReason(as.err))
+	}
+}
+
+func (sw *scrapeWork) releaseAdditionalData() {
+	for i := range sw.additionalScrapes {
+		as := &sw.additionalScrapes[i]
+		as.rows.Reset()
+		as.ru.reset()
+		as.prevBodyLen = len(as.body.B)
+		leveledbytebufferpool.Put(as.body)
+		as.body = nil
+		as.err = nil
+	}
+}
+
+// unmarshalRows parses body obtained from scrapeURL in Prometheus text exposition format into rows.
+//
+// An error is returned if the parsing takes longer than Config.ParseTimeout. rows are reset in this case,
+// so partially parsed responses aren't pushed to remote storage.
+func (sw *scrapeWork) unmarshalRows(rows *parser.Rows, body []byte, scrapeURL string) error {
+	bodyString := bytesutil.ToUnsafeString(body)
+	if sw.Config.ParseTimeout <= 0 {
+		rows.UnmarshalWithErrLogger(bodyString, sw.logError)
+		return nil
+	}
+	if !rows.UnmarshalWithDeadline(bodyString, sw.logError, time.Now().Add(sw.Config.ParseTimeout)) {
+		rows.Reset()
+		sw.registerScrapeError(scrapeErrorReasonParseTimeout)
+		return fmt.Errorf("cannot parse response from %q in parse_timeout=%s: %w", scrapeURL, sw.Config.ParseTimeout, errParseTimeout)
+	}
+	return nil
+}
+
+// errParseTimeout is returned if the scraped response cannot be parsed in ScrapeWork.ParseTimeout.
+var errParseTimeout = errors.New("parse timeout exceeded")
+
+// getScrapeStatus returns up status and scrape error for the scrape of sw.Config.ScrapeURL, which finished with err,
+// and for the scrapes of sw.Config.AdditionalScrapeURLs.
+//
+// The returned up status is 1 if at least a single url has been scraped successfully.
+func (sw *scrapeWork) getScrapeStatus(err error) (int, error) {
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for i := range sw.additionalScrapes {
+		if as := &sw.additionalScrapes[i]; as.err != nil {
+			errs = append(errs, as.err)
+		}
+	}
+	if len(errs) == 0 {
+		return 1, nil
+	}
+	err = joinScrapeErrors(errs)
+	if len(errs) == 1+len(sw.additionalScrapes) {
+		return 0, err
+	}
+	return 1, err
+}
+
+// joinScrapeErrors joins errs obtained when scraping distinct paths of the target into a single error.
+//
+// A single error is returned as is, so it could be inspected with errors.Is.
+func joinScrapeErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		a := make([]string, len(errs))
+		for i, err := range errs {
+			a[i] = err.Error()
+		}
+		return fmt.Errorf("%s", strings.Join(a, "; "))
+	}
+}
+
+func (sw *scrapeWork) logPartialScrapeError(err error) {
+	if !*suppressScrapeErrors {
+		sw.withLogFields(logger.Field{Key: "error", Value: err.Error()}).Warnf("partial scrape for %q from job %q with labels %s: %s", sw.Config.ScrapeURL, sw.Config.Job(), sw.Config.LabelsString(), err)
+	}
+}
+
+func (sw *scrapeWork) scrapeStream(scrapeTimestamp, realTimestamp int64) error {
+	cb := sw.getCircuitBreaker()
+	if !cb.allow(realTimestamp) {
+		sw.registerScrapeError(getScrapeErrorReason(errCircuitBreakerOpen))
+		if !sw.skipTargetStatus {
+			tsmGlobal.Update(sw.getStatusConfig(), sw.ScrapeGroup, false, realTimestamp, 0, 0, errCircuitBreakerOpen, cb.getState(), nil)
+		}
+		return errCircuitBreakerOpen
+	}
+	samplesScraped := 0
+	samplesPostRelabeling := 0
+	bytesRead := int64(0)
+	wc := writeRequestCtxPool.Get(sw.prevRowsLen)
+	// The target is up if at least a single path is scraped successfully in the same way as scrapeInternal does.
+	// A path is scraped successfully if it is read without errors or if some samples are read from it before the error.
+	up := 0
+	var errs []error
+	scrapePath := func(getStreamReader func() (*streamReader, error), targetLabels []prompbmarshal.Label, isPrimary bool) {
+		sr, err := getStreamReader()
+		if isPrimary {
+			cb.registerResult(err, realTimestamp)
+			sw.selectFallbackLabels()
+		}
+		if err != nil {
+			sw.registerScrapeError(getScrapeErrorReason(err))
+			errs = append(errs, fmt.Errorf("cannot read data: %w", err))
+			return
+		}
+		n, m, err := sw.parseStream(wc, sr, targetLabels, scrapeTimestamp)
+		samplesScraped += n
+		samplesPostRelabeling += m
+		bytesRead += sr.bytesRead
+		sr.MustClose()
+		if err != nil {
+			sw.registerScrapeError(getScrapeErrorReason(err))
+			errs = append(errs, err)
+			if n == 0 {
+				return
+			}
+		}
+		up = 1
+	}
+	scrapePath(sw.GetStreamReader, sw.Config.Labels, true)
+	sw.initAdditionalScrapes()
+	for i := range sw.additionalScrapes {
+		idx := i
+		scrapePath(func() (*streamReader, error) {
+			return sw.GetAdditionalStreamReader(idx)
+		}, sw.additionalScrapes[i].labels, false)
+	}
+	err := joinScrapeErrors(errs)
+	if up == 0 {
+		scrapesFailed.Inc()
+	} else if err != nil {
+		// Partial scrape - some of metrics paths have been scraped successfully.
+		sw.logPartialScrapeError(err)
+	}
+	endTimestamp := time.Now().UnixNano() / 1e6
+	duration := float64(endTimestamp-realTimestamp) / 1e3
+	scrapeDuration.Update(duration)
+	sw.updateScrapeSizeMetrics(bytesRead, samplesScraped)
+	sw.checkSamplesSpike(samplesScraped)
+	var topMetricNames []metricNameSeries
+	if sw.Config.CardinalityTopN > 0 {
+		topMetricNames = sw.metricNames.top(sw.Config.CardinalityTopN)
+	}
 	seriesAdded := sw.finalizeSeriesAdded(samplesPostRelabeling)
-	sw.addAutoTimeseries(wc, "up", float64(up), scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_duration_seconds", duration, scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_samples_scraped", float64(samplesScraped), scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_samples_post_metric_relabeling", float64(samplesPostRelabeling), scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_series_added", float64(seriesAdded), scrapeTimestamp)
+	bodySize := int64(0)
+	if up == 1 {
+		bodySize = bytesRead
+	}
+	sw.addHealthTimeseries(wc, &targetHealth{
+		up:                    up == 1,
+		duration:              duration,
+		samplesScraped:        samplesScraped,
+		samplesPostRelabeling: samplesPostRelabeling,
+		seriesAdded:           seriesAdded,
+		bodySize:              bodySize,
+	}, scrapeTimestamp)
 	startTime := time.Now()
 	sw.PushData(&wc.writeRequest)
 	pushDataDuration.UpdateDuration(startTime)
-	sw.prevRowsLen = samplesScraped
+	sw.prevRowsLen = len(wc.rows.Rows)
 	wc.reset()
 	writeRequestCtxPool.Put(wc)
-	// body must be released only after wc is released, since wc refers to body.
-	sw.prevBodyLen = len(body.B)
-	leveledbytebufferpool.Put(body)
-	tsmGlobal.Update(&sw.Config, sw.ScrapeGroup, up == 1, realTimestamp, int64(duration*1000), err)
-	return err
+	if !sw.skipTargetStatus {
+		tsmGlobal.Update(sw.getStatusConfig(), sw.ScrapeGroup, up == 1, realTimestamp, int64(duration*1000), samplesScraped, err, sw.circuitBreaker.getState(), topMetricNames)
+	}
+	if up == 0 {
+		return err
+	}
+	return nil
 }
 
-func (sw *scrapeWork) scrapeStream(scrapeTimestamp, realTimestamp int64) error {
-	sr, err := sw.GetStreamReader()
-	if err != nil {
-		return fmt.Errorf("cannot read data: %s", err)
-	}
+// parseStream parses data from sr and pushes it with the given targetLabels.
+//
+// It returns the number of scraped samples and the number of pushed samples.
+func (sw *scrapeWork) parseStream(wc *writeRequestCtx, sr *streamReader, targetLabels []prompbmarshal.Label, scrapeTimestamp int64) (int, int, error) {
 	samplesScraped := 0
 	samplesPostRelabeling := 0
-	wc := writeRequestCtxPool.Get(sw.prevRowsLen)
 	var mu sync.Mutex
-	err = parser.ParseStream(sr, scrapeTimestamp, false, func(rows []parser.Row) error {
+	err := parser.ParseStream(sr, scrapeTimestamp, false, func(rows []parser.Row) error {
 		mu.Lock()
 		defer mu.Unlock()
 		samplesScraped += len(rows)
+		if sw.Config.CardinalityTopN > 0 {
+			sw.metricNames.add(rows)
+		}
 		for i := range rows {
-			sw.addRowToTimeseries(wc, &rows[i], scrapeTimestamp, true)
+			sw.addRowToTimeseries(wc, &rows[i], targetLabels, scrapeTimestamp, true)
 		}
 		// Push the collected rows to sw before returning from the callback, since they cannot be held
 		// after returning from the callback - this will result in data race.
@@ -337,33 +1360,7 @@ func (sw *scrapeWork) scrapeStream(scrapeTimestamp, realTimestamp int64) error {
 		wc.resetNoRows()
 		return nil
 	})
-	scrapedSamples.Update(float64(samplesScraped))
-	endTimestamp := time.Now().UnixNano() / 1e6
-	duration := float64(endTimestamp-realTimestamp) / 1e3
-	scrapeDuration.Update(duration)
-	scrapeResponseSize.Update(float64(sr.bytesRead))
-	sr.MustClose()
-	up := 1
-	if err != nil {
-		if samplesScraped == 0 {
-			up = 0
-		}
-		scrapesFailed.Inc()
-	}
-	seriesAdded := sw.finalizeSeriesAdded(samplesPostRelabeling)
-	sw.addAutoTimeseries(wc, "up", float64(up), scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_duration_seconds", duration, scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_samples_scraped", float64(samplesScraped), scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_samples_post_metric_relabeling", float64(samplesPostRelabeling), scrapeTimestamp)
-	sw.addAutoTimeseries(wc, "scrape_series_added", float64(seriesAdded), scrapeTimestamp)
-	startTime := time.Now()
-	sw.PushData(&wc.writeRequest)
-	pushDataDuration.UpdateDuration(startTime)
-	sw.prevRowsLen = len(wc.rows.Rows)
-	wc.reset()
-	writeRequestCtxPool.Put(wc)
-	tsmGlobal.Update(&sw.Config, sw.ScrapeGroup, up == 1, realTimestamp, int64(duration*1000), err)
-	return nil
+	return samplesScraped, samplesPostRelabeling, err
 }
 
 // leveledWriteRequestCtxPool allows reducing memory usage when writeRequesCtx
@@ -412,6 +1409,7 @@ func (lwp *leveledWriteRequestCtxPool) getPoolIDAndCapacity(size int) (int, int)
 
 type writeRequestCtx struct {
 	rows         parser.Rows
+	ru           remoteWriteUnmarshaler
 	writeRequest prompbmarshal.WriteRequest
 	labels       []prompbmarshal.Label
 	samples      []prompbmarshal.Sample
@@ -419,6 +1417,7 @@ type writeRequestCtx struct {
 
 func (wc *writeRequestCtx) reset() {
 	wc.rows.Reset()
+	wc.ru.reset()
 	wc.resetNoRows()
 }
 
@@ -474,25 +1473,138 @@ func (sw *scrapeWork) addAutoTimeseries(wc *writeRequestCtx, name string, value
 	sw.tmpRow.Tags = nil
 	sw.tmpRow.Value = value
 	sw.tmpRow.Timestamp = timestamp
-	sw.addRowToTimeseries(wc, &sw.tmpRow, timestamp, false)
+	labels := sw.autoLabels
+	if labels == nil {
+		labels = sw.Config.Labels
+	}
+	if len(sw.Config.HealthLabels) > 0 {
+		sw.healthLabels = append(append(sw.healthLabels[:0], labels...), sw.Config.HealthLabels...)
+		labels = sw.healthLabels
+	}
+	sw.addRowToTimeseries(wc, &sw.tmpRow, labels, timestamp, false)
+}
+
+// getCircuitBreaker returns circuit breaker for sw. nil is returned if the circuit breaker is disabled.
+func (sw *scrapeWork) getCircuitBreaker() *circuitBreaker {
+	if sw.circuitBreaker == nil && sw.Config.BreakerFailures > 0 {
+		sw.circuitBreaker = newCircuitBreaker(sw.Config.BreakerFailures, sw.Config.BreakerCooldown)
+	}
+	return sw.circuitBreaker
+}
+
+// getStatusConfig returns sw.Config for registering in target status.
+//
+// ScrapeURL in the returned config has the working scheme for targets with `scheme: auto`
+// and the url used for the last scrape for targets with fallback urls.
+func (sw *scrapeWork) getStatusConfig() *ScrapeWork {
+	if sw.schemeAuto == nil && sw.fallback == nil {
+		return &sw.Config
+	}
+	cfg := sw.Config
+	if sw.schemeAuto != nil {
+		cfg.ScrapeURL = sw.schemeAuto.scrapeURL()
+	} else {
+		cfg.ScrapeURL = sw.fallback.scrapeURL()
+	}
+	return &cfg
+}
+
+// scrapeBackend is a backend from `__addresses__` label.
+type scrapeBackend struct {
+	ReadData        func(dst []byte) ([]byte, error)
+	GetStreamReader func() (*streamReader, error)
+
+	// autoLabels contains target labels plus `scrape_backend` label for automatically generated series.
+	autoLabels []prompbmarshal.Label
+}
+
+// newScrapeBackend returns scrapeBackend with the given address and client c for reading data from it.
+func newScrapeBackend(targetLabels []prompbmarshal.Label, address string, c *client) scrapeBackend {
+	labels := make([]prompbmarshal.Label, 0, len(targetLabels)+1)
+	for _, label := range targetLabels {
+		if label.Name != "scrape_backend" {
+			labels = append(labels, label)
+		}
+	}
+	labels = append(labels, prompbmarshal.Label{
+		Name:  "scrape_backend",
+		Value: address,
+	})
+	promrelabel.SortLabels(labels)
+	return scrapeBackend{
+		ReadData:        c.ReadData,
+		GetStreamReader: c.GetStreamReader,
+		autoLabels:      labels,
+	}
+}
+
+// selectBackend switches sw to the next backend from Config.BackendScrapeURLs.
+//
+// Backends are switched on every scrape, so a failing backend results in a failed scrape,
+// while the next scrape is performed against the next backend.
+func (sw *scrapeWork) selectBackend() {
+	if len(sw.backends) == 0 {
+		return
+	}
+	b := &sw.backends[sw.nextBackend%len(sw.backends)]
+	sw.nextBackend++
+	sw.ReadData = b.ReadData
+	sw.GetStreamReader = b.GetStreamReader
+	sw.autoLabels = b.autoLabels
+}
+
+// selectFallbackLabels sets labels for automatically generated series to labels with the url used for the last scrape of Config.FallbackScrapeURLs.
+func (sw *scrapeWork) selectFallbackLabels() {
+	if sw.fallback == nil {
+		return
+	}
+	sw.autoLabels = sw.fallback.getAutoLabels()
 }
 
-func (sw *scrapeWork) addRowToTimeseries(wc *writeRequestCtx, r *parser.Row, timestamp int64, needRelabel bool) {
+func (sw *scrapeWork) addRowToTimeseries(wc *writeRequestCtx, r *parser.Row, targetLabels []prompbmarshal.Label, timestamp int64, needRelabel bool) {
+	if needRelabel && sw.Config.DropNaNInf && (math.IsNaN(r.Value) || math.IsInf(r.Value, 0)) {
+		// Drop the scraped sample with NaN or Inf value. Auto-generated series are added with needRelabel=false, so they are never dropped.
+		droppedNaNInf.Inc()
+		return
+	}
 	labelsLen := len(wc.labels)
-	wc.labels = appendLabels(wc.labels, r.Metric, r.Tags, sw.Config.Labels, sw.Config.HonorLabels)
+	wc.labels = appendLabels(wc.labels, r.Metric, r.Tags, targetLabels, sw.Config.HonorLabels)
 	if needRelabel {
 		wc.labels = promrelabel.ApplyRelabelConfigs(wc.labels, labelsLen, sw.Config.MetricRelabelConfigs, true)
 	} else {
 		wc.labels = promrelabel.FinalizeLabels(wc.labels[:labelsLen], wc.labels[labelsLen:])
 		promrelabel.SortLabels(wc.labels[labelsLen:])
 	}
+	if needRelabel {
+		wc.labels = sw.filterLabelNames(wc.labels, labelsLen)
+	}
 	if len(wc.labels) == labelsLen {
 		// Skip row without labels.
 		return
 	}
+	if needRelabel && !sw.validateMetricName(wc.labels[labelsLen:]) {
+		wc.labels = wc.labels[:labelsLen]
+		return
+	}
 	sampleTimestamp := r.Timestamp
 	if !sw.Config.HonorTimestamps || sampleTimestamp == 0 {
 		sampleTimestamp = timestamp
+	} else if needRelabel {
+		ts, ok := sw.Config.TimestampLimits.apply(sampleTimestamp, timestamp)
+		if !ok {
+			wc.labels = wc.labels[:labelsLen]
+			return
+		}
+		sampleTimestamp = ts
+	}
+	samplesLen := len(wc.samples)
+	if needRelabel && sw.createdSeries != nil {
+		if startTimestamp, ok := sw.createdSeries.getStartTimestamp(r, sampleTimestamp); ok {
+			// Attach zero sample at the counter start time according to `created_series: attach`.
+			wc.samples = append(wc.samples, prompbmarshal.Sample{
+				Timestamp: startTimestamp,
+			})
+		}
 	}
 	wc.samples = append(wc.samples, prompbmarshal.Sample{
 		Value:     r.Value,
@@ -501,7 +1613,7 @@ func (sw *scrapeWork) addRowToTimeseries(wc *writeRequestCtx, r *parser.Row, tim
 	wr := &wc.writeRequest
 	wr.Timeseries = append(wr.Timeseries, prompbmarshal.TimeSeries{
 		Labels:  wc.labels[labelsLen:],
-		Samples: wc.samples[len(wc.samples)-1:],
+		Samples: wc.samples[samplesLen:],
 	})
 }
 
@@ -514,7 +1626,9 @@ func appendLabels(dst []prompbmarshal.Label, metric string, src []parser.Tag, ex
 	for i := range src {
 		tag := &src[i]
 		dst = append(dst, prompbmarshal.Label{
-			Name:  tag.Key,
+			// Intern the label name, since the same names are repeated in many scraped series,
+			// while tag.Key refers to the response body, which is re-used by the next scrape.
+			Name:  bytesutil.InternString(tag.Key),
 			Value: tag.Value,
 		})
 	}
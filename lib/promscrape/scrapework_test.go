@@ -1,14 +1,40 @@
 package promscrape
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+	"unsafe"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
 	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/proxy"
+	"github.com/VictoriaMetrics/fasthttp"
+	"github.com/VictoriaMetrics/metrics"
+	kzstd "github.com/klauspost/compress/zstd"
 )
 
 func TestPromLabelsString(t *testing.T) {
@@ -38,6 +64,188 @@ func TestPromLabelsString(t *testing.T) {
 	}, `{foo="bar",a="\"b\""}`)
 }
 
+func TestAppendLabelsInternsNames(t *testing.T) {
+	stringData := func(s string) uintptr {
+		return (*(*[2]uintptr)(unsafe.Pointer(&s)))[0]
+	}
+	var names [2]string
+	for i := range names {
+		// Every tag refers to distinct buffer like tags for distinct scrape responses do.
+		buf := []byte("cluster")
+		tags := []parser.Tag{{
+			Key:   bytesutil.ToUnsafeString(buf),
+			Value: "prod",
+		}}
+		labels := appendLabels(nil, "foo", tags, nil, false)
+		label := promrelabel.GetLabelByName(labels, "cluster")
+		if label == nil {
+			t.Fatalf("missing `cluster` label in %s", promLabelsString(labels))
+		}
+		names[i] = label.Name
+		buf[0] = 'x'
+		if label.Name != "cluster" {
+			t.Fatalf("the label name refers to the scraped data; got %q; want %q", label.Name, "cluster")
+		}
+	}
+	if stringData(names[0]) != stringData(names[1]) {
+		t.Fatalf("the label name isn't interned")
+	}
+}
+
+func TestScrapeWorkKeyCoversAllFields(t *testing.T) {
+	prcs, err := promrelabel.ParseRelabelConfigs(nil, []promrelabel.RelabelConfig{{
+		Action:       "drop",
+		SourceLabels: []string{"foo"},
+	}})
+	if err != nil {
+		t.Fatalf("cannot parse relabel configs: %s", err)
+	}
+	proxyURL, err := proxy.NewURL("socks5://proxy:1080")
+	if err != nil {
+		t.Fatalf("cannot parse proxy url: %s", err)
+	}
+	nonZeroValues := map[reflect.Type]interface{}{
+		reflect.TypeOf(prcs):                  prcs,
+		reflect.TypeOf(proxyURL):              proxyURL,
+		reflect.TypeOf(&regexp.Regexp{}):      regexp.MustCompile("foo"),
+		reflect.TypeOf(prompbmarshal.Label{}): prompbmarshal.Label{Name: "foo", Value: "bar"},
+	}
+	var nonZeroValue func(typ reflect.Type) (reflect.Value, bool)
+	nonZeroValue = func(typ reflect.Type) (reflect.Value, bool) {
+		if v, ok := nonZeroValues[typ]; ok {
+			return reflect.ValueOf(v), true
+		}
+		switch typ.Kind() {
+		case reflect.String:
+			return reflect.ValueOf("foo").Convert(typ), true
+		case reflect.Bool:
+			return reflect.ValueOf(true).Convert(typ), true
+		case reflect.Int, reflect.Int64:
+			return reflect.ValueOf(1).Convert(typ), true
+		case reflect.Slice:
+			elem, ok := nonZeroValue(typ.Elem())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			return reflect.Append(reflect.MakeSlice(typ, 0, 1), elem), true
+		case reflect.Ptr:
+			v := reflect.New(typ.Elem())
+			if typ.Elem().Kind() != reflect.Struct {
+				elem, ok := nonZeroValue(typ.Elem())
+				if !ok {
+					return reflect.Value{}, false
+				}
+				v.Elem().Set(elem)
+			}
+			return v, true
+		default:
+			return reflect.Value{}, false
+		}
+	}
+
+	var swBase ScrapeWork
+	keyBase := swBase.key()
+	typ := reflect.TypeOf(swBase)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || field.Name == "ID" || field.Name == "OriginalLabels" {
+			// Unexported fields, ID and OriginalLabels mustn't be taken into account in the key.
+			continue
+		}
+		v, ok := nonZeroValue(field.Type)
+		if !ok {
+			t.Fatalf("cannot generate non-zero value of type %s for ScrapeWork.%s; add it to the test", field.Type, field.Name)
+		}
+		var sw ScrapeWork
+		reflect.ValueOf(&sw).Elem().Field(i).Set(v)
+		if sw.key() == keyBase {
+			t.Fatalf("ScrapeWork.%s isn't taken into account in ScrapeWork.key()", field.Name)
+		}
+	}
+}
+
+func TestScrapeScheduler(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	interval := 15 * time.Second
+	ss := newScrapeScheduler(start, interval)
+
+	// Scrape times must stay aligned to start+N*interval over many iterations with processing delay.
+	now := start
+	for i := 1; i <= 10000; i++ {
+		now = now.Add(20 * time.Millisecond)
+		nextTime, skipped := ss.next(now)
+		if skipped != 0 {
+			t.Fatalf("unexpected skipped scrapes at iteration %d; got %d; want 0", i, skipped)
+		}
+		nextTimeExpected := start.Add(time.Duration(i) * interval)
+		if !nextTime.Equal(nextTimeExpected) {
+			t.Fatalf("unexpected next scrape time at iteration %d; got %s; want %s", i, nextTime, nextTimeExpected)
+		}
+		now = nextTime
+	}
+
+	// A scrape overrunning the interval must skip to the next aligned time.
+	start = now
+	nextTime, skipped := ss.next(now.Add(interval + time.Second))
+	if skipped != 1 {
+		t.Fatalf("unexpected skipped scrapes; got %d; want 1", skipped)
+	}
+	if nextTimeExpected := start.Add(2 * interval); !nextTime.Equal(nextTimeExpected) {
+		t.Fatalf("unexpected next scrape time; got %s; want %s", nextTime, nextTimeExpected)
+	}
+	nextTime, skipped = ss.next(nextTime.Add(3*interval + time.Second))
+	if skipped != 3 {
+		t.Fatalf("unexpected skipped scrapes; got %d; want 3", skipped)
+	}
+	if nextTimeExpected := start.Add(6 * interval); !nextTime.Equal(nextTimeExpected) {
+		t.Fatalf("unexpected next scrape time; got %s; want %s", nextTime, nextTimeExpected)
+	}
+}
+
+func TestAlignScrapeTimestamp(t *testing.T) {
+	f := func(ts, offset, interval, tolerance, resultExpected int64) {
+		t.Helper()
+		result := alignScrapeTimestamp(ts, offset, interval, tolerance)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for alignScrapeTimestamp(%d, %d, %d, %d); got %d; want %d", ts, offset, interval, tolerance, result, resultExpected)
+		}
+	}
+	// Exact boundary
+	f(30000, 0, 10000, 50, 30000)
+	f(30123, 123, 10000, 50, 30123)
+
+	// Small jitter in both directions
+	f(30020, 0, 10000, 50, 30000)
+	f(29970, 0, 10000, 50, 30000)
+	f(30143, 123, 10000, 50, 30123)
+	f(30093, 123, 10000, 50, 30123)
+
+	// Jitter exceeding tolerance
+	f(30051, 0, 10000, 50, 30051)
+	f(29949, 0, 10000, 50, 29949)
+
+	// Offset bigger than t
+	f(5010, 9000, 4000, 50, 5000)
+
+	// Zero interval
+	f(12345, 0, 0, 50, 12345)
+
+	// A series of scrapes with small jitter must result in evenly-spaced timestamps
+	jitters := []int64{3, -12, 25, 0, -49, 17, 8, -1}
+	prevTimestamp := int64(0)
+	for i, jitter := range jitters {
+		ts := 1599999990000 + 777 + int64(i)*15000 + jitter
+		aligned := alignScrapeTimestamp(ts, 777, 15000, 50)
+		if (aligned-777)%15000 != 0 {
+			t.Fatalf("unexpected aligned timestamp %d for %d", aligned, ts)
+		}
+		if i > 0 && aligned-prevTimestamp != 15000 {
+			t.Fatalf("unexpected interval between aligned timestamps; got %d; want %d", aligned-prevTimestamp, 15000)
+		}
+		prevTimestamp = aligned
+	}
+}
+
 func TestScrapeWorkScrapeInternalFailure(t *testing.T) {
 	dataExpected := `
 		up 0 123
@@ -45,6 +253,7 @@ func TestScrapeWorkScrapeInternalFailure(t *testing.T) {
 		scrape_duration_seconds 0 123
 		scrape_samples_post_metric_relabeling 0 123
 		scrape_series_added 0 123
+		scrape_body_size_bytes 0 123
 `
 	timeseriesExpected := parseData(dataExpected)
 
@@ -133,6 +342,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds 0 123
 		scrape_samples_post_metric_relabeling 0 123
 		scrape_series_added 0 123
+		scrape_body_size_bytes 0 123
 	`)
 	f(`
 		foo{bar="baz",empty_label=""} 34.45 3
@@ -145,6 +355,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds 0 123
 		scrape_samples_post_metric_relabeling 2 123
 		scrape_series_added 2 123
+		scrape_body_size_bytes 51 123
 	`)
 	f(`
 		foo{bar="baz"} 34.45 3
@@ -165,6 +376,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds{foo="x"} 0 123
 		scrape_samples_post_metric_relabeling{foo="x"} 2 123
 		scrape_series_added{foo="x"} 2 123
+		scrape_body_size_bytes{foo="x"} 36 123
 	`)
 	f(`
 		foo{job="orig",bar="baz"} 34.45
@@ -185,6 +397,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds{job="override"} 0 123
 		scrape_samples_post_metric_relabeling{job="override"} 2 123
 		scrape_series_added{job="override"} 2 123
+		scrape_body_size_bytes{job="override"} 89 123
 	`)
 	// Empty instance override. See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/453
 	f(`
@@ -210,6 +423,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds{instance="foobar",job="xxx"} 0 123
 		scrape_samples_post_metric_relabeling{instance="foobar",job="xxx"} 2 123
 		scrape_series_added{instance="foobar",job="xxx"} 2 123
+		scrape_body_size_bytes{instance="foobar",job="xxx"} 158 123
 	`)
 	f(`
 		no_instance{instance="",job="some_job",label="val1",test=""} 5555
@@ -234,6 +448,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds{instance="foobar",job="xxx"} 0 123
 		scrape_samples_post_metric_relabeling{instance="foobar",job="xxx"} 2 123
 		scrape_series_added{instance="foobar",job="xxx"} 2 123
+		scrape_body_size_bytes{instance="foobar",job="xxx"} 158 123
 	`)
 	f(`
 		foo{job="orig",bar="baz"} 34.45
@@ -254,6 +469,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds{job="override"} 0 123
 		scrape_samples_post_metric_relabeling{job="override"} 2 123
 		scrape_series_added{job="override"} 2 123
+		scrape_body_size_bytes{job="override"} 77 123
 	`)
 	f(`
 		foo{bar="baz"} 34.44
@@ -292,6 +508,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds{job="xx"} 0 123
 		scrape_samples_post_metric_relabeling{job="xx"} 2 123
 		scrape_series_added{job="xx"} 2 123
+		scrape_body_size_bytes{job="xx"} 49 123
 	`)
 	f(`
 		foo{bar="baz"} 34.44
@@ -329,6 +546,7 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds{job="xx",instance="foo.com"} 0 123
 		scrape_samples_post_metric_relabeling{job="xx",instance="foo.com"} 1 123
 		scrape_series_added{job="xx",instance="foo.com"} 1 123
+		scrape_body_size_bytes{job="xx",instance="foo.com"} 106 123
 	`)
 	f(`
 		foo{bar="baz"} 34.44
@@ -342,7 +560,267 @@ func TestScrapeWorkScrapeInternalSuccess(t *testing.T) {
 		scrape_duration_seconds 0 123
 		scrape_samples_post_metric_relabeling 0 123
 		scrape_series_added 0 123
+		scrape_body_size_bytes 0 123
+	`)
+	f(`
+		foo NaN
+		bar +Inf
+		baz -Inf
+		x 1
+	`, &ScrapeWork{}, `
+		foo NaN 123
+		bar +Inf 123
+		baz -Inf 123
+		x 1 123
+		up 1 123
+		scrape_samples_scraped 4 123
+		scrape_duration_seconds 0 123
+		scrape_samples_post_metric_relabeling 4 123
+		scrape_series_added 4 123
+		scrape_body_size_bytes 40 123
+	`)
+	droppedBefore := droppedNaNInf.Get()
+	f(`
+		foo NaN
+		bar +Inf
+		baz -Inf
+		x 1
+	`, &ScrapeWork{
+		DropNaNInf: true,
+	}, `
+		x 1 123
+		up 1 123
+		scrape_samples_scraped 4 123
+		scrape_duration_seconds 0 123
+		scrape_samples_post_metric_relabeling 1 123
+		scrape_series_added 1 123
+		scrape_body_size_bytes 40 123
 	`)
+	if n := droppedNaNInf.Get() - droppedBefore; n != 3 {
+		t.Fatalf("unexpected number of dropped NaN and Inf samples; got %d; want 3", n)
+	}
+}
+
+func TestScrapeWorkSamplesSpike(t *testing.T) {
+	var sw scrapeWork
+	data := "foo 1\nbar 2\n"
+	sw.ReadData = func(dst []byte) ([]byte, error) {
+		return append(dst, data...), nil
+	}
+	sw.PushData = func(wr *prompbmarshal.WriteRequest) {}
+	scrape := func() {
+		t.Helper()
+		timestamp := int64(123000)
+		if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	spikesPrev := scrapedSamplesSpikes.Get()
+	scrape()
+	scrape()
+	if n := scrapedSamplesSpikes.Get() - spikesPrev; n != 0 {
+		t.Fatalf("unexpected number of samples spikes for stable scrapes; got %d; want 0", n)
+	}
+	if sw.prevSamplesScraped != 2 {
+		t.Fatalf("unexpected prevSamplesScraped; got %d; want 2", sw.prevSamplesScraped)
+	}
+
+	// Sudden spike in the number of samples must be detected.
+	data = "foo 1\nbar 2\nbaz 3\nx 4\ny 5\n"
+	scrape()
+	if n := scrapedSamplesSpikes.Get() - spikesPrev; n != 1 {
+		t.Fatalf("unexpected number of samples spikes; got %d; want 1", n)
+	}
+	if sw.lastSamplesSpikeWarnTime == 0 {
+		t.Fatalf("expecting the warning to be logged on samples spike")
+	}
+
+	// The warning must be throttled for subsequent spikes.
+	if sw.checkSamplesSpike(100) {
+		t.Fatalf("expecting throttled warning for the subsequent samples spike")
+	}
+	if n := scrapedSamplesSpikes.Get() - spikesPrev; n != 2 {
+		t.Fatalf("unexpected number of samples spikes; got %d; want 2", n)
+	}
+
+	// Decrease in the number of samples mustn't be treated as spike.
+	if sw.checkSamplesSpike(1) {
+		t.Fatalf("unexpected warning when the number of samples decreases")
+	}
+}
+
+func TestScrapeWorkMultipleMetricsPaths(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			fmt.Fprintf(w, "foo 1\nbar 2\n")
+		case "/admin/metrics":
+			fmt.Fprintf(w, "baz 3\n")
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "error")
+		}
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, metricsPaths, dataExpected string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: multi
+  stream_parse: %v
+  metrics_paths: %s
+  static_configs:
+  - targets: [%q]
+  metric_relabel_configs:
+  - source_labels: [__metrics_path__]
+    target_label: path
+`, streamParse, metricsPaths, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		var tss []prompbmarshal.TimeSeries
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				labels := append([]prompbmarshal.Label{}, ts.Labels...)
+				samples := append([]prompbmarshal.Sample{}, ts.Samples...)
+				tss = append(tss, prompbmarshal.TimeSeries{
+					Labels:  labels,
+					Samples: samples,
+				})
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		dataExpected = strings.ReplaceAll(dataExpected, "HOST", u.Host)
+		timeseriesExpected := parseData(dataExpected)
+		if err := expectEqualTimeseries(tss, timeseriesExpected); err != nil {
+			t.Fatalf("unexpected data pushed: %s\ngot\n%v\nwant\n%v", err, tss, timeseriesExpected)
+		}
+	}
+	dataExpected := `
+		foo{instance="HOST",job="multi",path="/metrics"} 1 123
+		bar{instance="HOST",job="multi",path="/metrics"} 2 123
+		baz{instance="HOST",job="multi",path="/admin/metrics"} 3 123
+		up{instance="HOST",job="multi"} 1 123
+		scrape_samples_scraped{instance="HOST",job="multi"} 3 123
+		scrape_duration_seconds{instance="HOST",job="multi"} 0 123
+		scrape_samples_post_metric_relabeling{instance="HOST",job="multi"} 3 123
+		scrape_series_added{instance="HOST",job="multi"} 3 123
+		scrape_body_size_bytes{instance="HOST",job="multi"} 18 123
+`
+	f(false, `[/metrics, /admin/metrics]`, dataExpected)
+	f(true, `[/metrics, /admin/metrics]`, dataExpected)
+
+	// Partial scrape - the failed path mustn't break scraping of other paths.
+	dataExpected = `
+		foo{instance="HOST",job="multi",path="/metrics"} 1 123
+		bar{instance="HOST",job="multi",path="/metrics"} 2 123
+		up{instance="HOST",job="multi"} 1 123
+		scrape_samples_scraped{instance="HOST",job="multi"} 2 123
+		scrape_duration_seconds{instance="HOST",job="multi"} 0 123
+		scrape_samples_post_metric_relabeling{instance="HOST",job="multi"} 2 123
+		scrape_series_added{instance="HOST",job="multi"} 2 123
+		scrape_body_size_bytes{instance="HOST",job="multi"} 12 123
+`
+	f(false, `[/metrics, /missing]`, dataExpected)
+	f(true, `[/metrics, /missing]`, dataExpected)
+
+	// Partial scrape - the failed first path mustn't break scraping of other paths.
+	f(false, `[/missing, /metrics]`, dataExpected)
+	f(true, `[/missing, /metrics]`, dataExpected)
+}
+
+func TestScrapeWorkMetricsPathsConcurrency(t *testing.T) {
+	delays := map[string]time.Duration{
+		"/a": 200 * time.Millisecond,
+		"/b": 300 * time.Millisecond,
+		"/c": 400 * time.Millisecond,
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delays[r.URL.Path])
+		if r.URL.Path == "/c" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "error")
+			return
+		}
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	f := func(concurrency int) time.Duration {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: multi
+  metrics_paths: [/a, /b, /c]
+  metrics_paths_concurrency: %d
+  static_configs:
+  - targets: [%q]
+`, concurrency, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		values := make(map[string]float64)
+		foos := 0
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if name == "foo" {
+					foos++
+				}
+				values[name] = ts.Samples[0].Value
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := time.Now().UnixNano() / 1e6
+		// The failed path must result in partial scrape.
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if values["up"] != 1 || foos != 2 {
+			t.Fatalf("unexpected partial scrape results; up=%v, foo samples=%d; want up=1, foo samples=2", values["up"], foos)
+		}
+		return time.Duration(values["scrape_duration_seconds"] * float64(time.Second))
+	}
+
+	// The paths are scraped concurrently, so the scrape duration must be closer to the slowest path than to the sum for all the paths.
+	d := f(3)
+	if d < 400*time.Millisecond || d >= 650*time.Millisecond {
+		t.Fatalf("unexpected scrape duration for concurrent scrape of metrics_paths; got %s; want [400ms ... 650ms)", d)
+	}
+
+	// The paths are scraped sequentially.
+	d = f(1)
+	if d < 900*time.Millisecond {
+		t.Fatalf("unexpected scrape duration for sequential scrape of metrics_paths; got %s; want at least 900ms", d)
+	}
 }
 
 func parseData(data string) []prompbmarshal.TimeSeries {
@@ -439,3 +917,1907 @@ func timeseriesToString(ts *prompbmarshal.TimeSeries) string {
 	fmt.Fprintf(&sb, "%g %d", s.Value, s.Timestamp)
 	return sb.String()
 }
+
+func TestScrapeWorkRetries(t *testing.T) {
+	var requests uint64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddUint64(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "temporarily unavailable")
+			return
+		}
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, retries int, upExpected float64) {
+		t.Helper()
+		atomic.StoreUint64(&requests, 0)
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: retry
+  stream_parse: %v
+  scrape_retries: %d
+  static_configs:
+  - targets: [%q]
+`, streamParse, retries, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		var up float64 = -1
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				for _, label := range ts.Labels {
+					if label.Name == "__name__" && label.Value == "up" {
+						up = ts.Samples[0].Value
+					}
+				}
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		retriesBefore := scrapeRetries.Get()
+		timestamp := int64(123000)
+		err := sc.sw.scrapeInternal(timestamp, timestamp)
+		if upExpected == 1 && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if upExpected == 0 && err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if up != upExpected {
+			t.Fatalf("unexpected up value; got %v; want %v", up, upExpected)
+		}
+		requestsExpected := uint64(1)
+		if upExpected == 1 {
+			requestsExpected = 2
+		}
+		if n := atomic.LoadUint64(&requests); n != requestsExpected {
+			t.Fatalf("unexpected number of requests; got %d; want %d", n, requestsExpected)
+		}
+		if n := scrapeRetries.Get() - retriesBefore; n != requestsExpected-1 {
+			t.Fatalf("unexpected number of retries; got %d; want %d", n, requestsExpected-1)
+		}
+	}
+
+	// No retries by default.
+	f(false, 0, 0)
+	f(true, 0, 0)
+
+	// The first attempt fails with 503, while the retry succeeds.
+	f(false, 1, 1)
+	f(true, 1, 1)
+	f(false, 3, 1)
+}
+
+func TestScrapeWorkRetriesLimitedByScrapeTimeout(t *testing.T) {
+	var requests uint64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&requests, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "temporarily unavailable")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool) {
+		t.Helper()
+		atomic.StoreUint64(&requests, 0)
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: retry
+  stream_parse: %v
+  scrape_interval: 1m
+  scrape_timeout: 350ms
+  scrape_retries: 100
+  static_configs:
+  - targets: [%q]
+`, streamParse, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+		timestamp := int64(123000)
+		startTime := time.Now()
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		// Retries mustn't exceed scrape_timeout, even if scrape_interval allows more retries.
+		if d := time.Since(startTime); d > time.Second {
+			t.Fatalf("too long scrape with retries; got %s; want less than 1s", d)
+		}
+		// The last attempts may be interrupted by the scrape deadline, so the exact number of requests isn't checked.
+		if n := atomic.LoadUint64(&requests); n < 2 || n > 10 {
+			t.Fatalf("unexpected number of requests; got %d; want from 2 to 10", n)
+		}
+	}
+	f(false)
+	f(true)
+}
+
+func TestScrapeWorkCompressedResponse(t *testing.T) {
+	const body = "foo{bar=\"baz\"} 1\nabc 2\n"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding := r.Header.Get("Accept-Encoding")
+		switch r.URL.Path {
+		case "/zstd":
+			if !strings.Contains(acceptEncoding, "zstd") {
+				t.Errorf("missing zstd in Accept-Encoding header: %q", acceptEncoding)
+			}
+			w.Header().Set("Content-Encoding", "zstd")
+			w.Write(zstd.CompressLevel(nil, []byte(body), 1))
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			fmt.Fprintf(zw, "%s", body)
+			_ = zw.Close()
+		default:
+			// The server ignores Accept-Encoding header.
+			fmt.Fprintf(w, "%s", body)
+		}
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, metricsPath string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: compressed
+  stream_parse: %v
+  metrics_path: %s
+  static_configs:
+  - targets: [%q]
+`, streamParse, metricsPath, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		var tss []prompbmarshal.TimeSeries
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				labels := append([]prompbmarshal.Label{}, ts.Labels...)
+				samples := append([]prompbmarshal.Sample{}, ts.Samples...)
+				tss = append(tss, prompbmarshal.TimeSeries{
+					Labels:  labels,
+					Samples: samples,
+				})
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		dataExpected := strings.ReplaceAll(`
+			foo{bar="baz",instance="HOST",job="compressed"} 1 123
+			abc{instance="HOST",job="compressed"} 2 123
+			up{instance="HOST",job="compressed"} 1 123
+			scrape_samples_scraped{instance="HOST",job="compressed"} 2 123
+			scrape_duration_seconds{instance="HOST",job="compressed"} 0 123
+			scrape_samples_post_metric_relabeling{instance="HOST",job="compressed"} 2 123
+			scrape_series_added{instance="HOST",job="compressed"} 2 123
+			scrape_body_size_bytes{instance="HOST",job="compressed"} 23 123
+`, "HOST", u.Host)
+		timeseriesExpected := parseData(dataExpected)
+		if err := expectEqualTimeseries(tss, timeseriesExpected); err != nil {
+			t.Fatalf("unexpected data pushed: %s\ngot\n%v\nwant\n%v", err, tss, timeseriesExpected)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		f(streamParse, "/zstd")
+		f(streamParse, "/gzip")
+		f(streamParse, "/plain")
+	}
+}
+
+func TestScrapeWorkGzipDecompressedSizeLimit(t *testing.T) {
+	// The body is highly compressible, so its compressed size is much smaller than the decompressed size.
+	body := strings.Repeat("# padding\n", 100*1024) + "foo 1\n"
+	var bb bytes.Buffer
+	zw := gzip.NewWriter(&bb)
+	fmt.Fprintf(zw, "%s", body)
+	_ = zw.Close()
+	compressedBody := bb.Bytes()
+	if len(compressedBody) > 64*1024 {
+		t.Fatalf("too big compressed body; got %d bytes", len(compressedBody))
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressedBody)
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, maxDecompressedSize int, upExpected float64) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: gzip_bomb
+  stream_parse: %v
+  max_decompressed_size: %d
+  static_configs:
+  - targets: [%q]
+`, streamParse, maxDecompressedSize, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		up := float64(-1)
+		foos := 0
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				switch promrelabel.GetLabelValueByName(ts.Labels, "__name__") {
+				case "up":
+					up = ts.Samples[0].Value
+				case "foo":
+					foos++
+				}
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		err := sc.sw.scrapeInternal(timestamp, timestamp)
+		if upExpected == 1 && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if upExpected == 0 && err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if up != upExpected {
+			t.Fatalf("unexpected up value for max_decompressed_size=%d, stream_parse=%v; got %v; want %v", maxDecompressedSize, streamParse, up, upExpected)
+		}
+		foosExpected := 0
+		if upExpected == 1 {
+			foosExpected = 1
+		}
+		if foos != foosExpected {
+			t.Fatalf("unexpected number of scraped foo series; got %d; want %d", foos, foosExpected)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		// The decompressed body exceeds the limit, so the scrape fails.
+		f(streamParse, 64*1024, 0)
+
+		// The decompressed body fits the limit.
+		f(streamParse, 2*1024*1024, 1)
+
+		// The limit is 10x of -promscrape.maxScrapeSize by default, so the response, which exceeds -promscrape.maxScrapeSize
+		// only after decompression, is processed as usual.
+		maxScrapeSizeOrig := maxScrapeSize.N
+		maxScrapeSize.N = 512 * 1024
+		f(streamParse, 0, 1)
+		maxScrapeSize.N = maxScrapeSizeOrig
+
+		// -promscrape.maxDecompressedSize is used by default if it is set.
+		maxDecompressedSize.N = 64 * 1024
+		f(streamParse, 0, 0)
+		maxDecompressedSize.N = 0
+	}
+}
+
+func TestScrapeWorkZstdDecompressedSizeLimit(t *testing.T) {
+	// The body is highly compressible, so its compressed size is much smaller than the decompressed size.
+	body := strings.Repeat("# padding\n", 100*1024) + "foo 1\n"
+	frameBody := zstd.CompressLevel(nil, []byte(body), 1)
+	// Streaming encoder doesn't put the decompressed size into frame header, so the decoder cannot reject the response in advance.
+	var bb bytes.Buffer
+	zw, err := kzstd.NewWriter(&bb)
+	if err != nil {
+		t.Fatalf("cannot create zstd writer: %s", err)
+	}
+	fmt.Fprintf(zw, "%s", body)
+	_ = zw.Close()
+	streamBody := bb.Bytes()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "zstd")
+		if r.URL.Path == "/stream" {
+			w.Write(streamBody)
+			return
+		}
+		w.Write(frameBody)
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, metricsPath string, maxSize, maxDecompressedSize int, upExpected float64) {
+		t.Helper()
+		maxScrapeSizeOrig := maxScrapeSize.N
+		maxScrapeSize.N = maxSize
+		defer func() {
+			maxScrapeSize.N = maxScrapeSizeOrig
+		}()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: zstd_bomb
+  stream_parse: %v
+  metrics_path: %s
+  max_decompressed_size: %d
+  static_configs:
+  - targets: [%q]
+`, streamParse, metricsPath, maxDecompressedSize, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		up := float64(-1)
+		foos := 0
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				switch promrelabel.GetLabelValueByName(ts.Labels, "__name__") {
+				case "up":
+					up = ts.Samples[0].Value
+				case "foo":
+					foos++
+				}
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		err := sc.sw.scrapeInternal(timestamp, timestamp)
+		if upExpected == 1 && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if upExpected == 0 && err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if up != upExpected {
+			t.Fatalf("unexpected up value for -promscrape.maxScrapeSize=%d, max_decompressed_size=%d, stream_parse=%v, path=%s; got %v; want %v",
+				maxSize, maxDecompressedSize, streamParse, metricsPath, up, upExpected)
+		}
+		foosExpected := 0
+		if upExpected == 1 {
+			foosExpected = 1
+		}
+		if foos != foosExpected {
+			t.Fatalf("unexpected number of scraped foo series; got %d; want %d", foos, foosExpected)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		for _, metricsPath := range []string{"/frame", "/stream"} {
+			// The decompressed body exceeds the default max_decompressed_size, so the scrape fails.
+			f(streamParse, metricsPath, 64*1024, 0, 0)
+
+			// The decompressed body exceeds -promscrape.maxScrapeSize, but it fits the default max_decompressed_size like for gzip responses.
+			f(streamParse, metricsPath, 512*1024, 0, 1)
+
+			// The decompressed body exceeds the explicitly set max_decompressed_size.
+			f(streamParse, metricsPath, 2*1024*1024, 64*1024, 0)
+
+			// The decompressed body fits both limits.
+			f(streamParse, metricsPath, 2*1024*1024, 0, 1)
+			f(streamParse, metricsPath, 2*1024*1024, 2*1024*1024, 1)
+		}
+	}
+}
+
+func TestAppendZstdBytesLimited(t *testing.T) {
+	data := bytes.Repeat([]byte("foo 1\n"), 1000)
+	src := zstd.CompressLevel(nil, data, 1)
+	f := func(maxSize int, resultExpected []byte) {
+		t.Helper()
+		result, err := appendZstdBytesLimited([]byte("prefix"), src, maxSize)
+		if resultExpected == nil {
+			if err == nil {
+				t.Fatalf("expecting non-nil error for maxSize=%d", maxSize)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for maxSize=%d: %s", maxSize, err)
+		}
+		if !bytes.Equal(result, resultExpected) {
+			t.Fatalf("unexpected result for maxSize=%d; got %d bytes; want %d bytes", maxSize, len(result), len(resultExpected))
+		}
+	}
+	resultExpected := append([]byte("prefix"), data...)
+	f(len(data), resultExpected)
+	f(2*len(data), resultExpected)
+	f(len(data)-1, nil)
+	f(100, nil)
+}
+
+func TestGetMaxDecompressedSize(t *testing.T) {
+	f := func(configLimit, flagLimit, resultExpected int) {
+		t.Helper()
+		maxDecompressedSize.N = flagLimit
+		defer func() {
+			maxDecompressedSize.N = 0
+		}()
+		sw := &ScrapeWork{
+			MaxDecompressedSize: configLimit,
+		}
+		result := getMaxDecompressedSize(sw)
+		if result != resultExpected {
+			t.Fatalf("unexpected limit for max_decompressed_size=%d, -promscrape.maxDecompressedSize=%d; got %d; want %d", configLimit, flagLimit, result, resultExpected)
+		}
+	}
+	f(0, 0, 10*maxScrapeSize.N)
+	f(0, 1000, 1000)
+	f(2000, 1000, 2000)
+	f(2000, 0, 2000)
+}
+
+func TestScrapeWorkScrapeProtocols(t *testing.T) {
+	acceptCh := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptCh <- r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/openmetrics-text;version=1.0.0")
+		fmt.Fprintf(w, "foo 1\n# EOF\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, scrapeProtocols, acceptExpected string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: protocols
+  stream_parse: %v
+  %s
+  static_configs:
+  - targets: [%q]
+`, streamParse, scrapeProtocols, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		accept := <-acceptCh
+		if accept != acceptExpected {
+			t.Fatalf("unexpected Accept header;\ngot\n%s\nwant\n%s", accept, acceptExpected)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		// The default Accept header
+		f(streamParse, "", "text/plain;version=0.0.4;q=1,*/*;q=0.1")
+
+		// OpenMetrics first
+		f(streamParse, "scrape_protocols: [OpenMetricsText1.0.0, PrometheusText0.0.4]",
+			"application/openmetrics-text;version=1.0.0;q=0.4,text/plain;version=0.0.4;q=0.3,*/*;q=0.2")
+
+		// Text first
+		f(streamParse, "scrape_protocols: [PrometheusText0.0.4, OpenMetricsText0.0.1, OpenMetricsText1.0.0]",
+			"text/plain;version=0.0.4;q=0.4,application/openmetrics-text;version=0.0.1;q=0.3,application/openmetrics-text;version=1.0.0;q=0.2,*/*;q=0.1")
+	}
+}
+
+func TestScrapeWorkPostMethod(t *testing.T) {
+	type request struct {
+		method      string
+		contentType string
+		body        string
+	}
+	requestCh := make(chan request, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requestCh <- request{
+			method:      r.Method,
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(body),
+		}
+		fmt.Fprintf(w, "foo{subset=\"a\"} 1\nfoo{subset=\"b\"} 2\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	bodyFile, err := ioutil.TempFile("", "scrape_body_file")
+	if err != nil {
+		t.Fatalf("cannot create body file: %s", err)
+	}
+	defer func() {
+		_ = os.Remove(bodyFile.Name())
+	}()
+	if _, err := bodyFile.WriteString(`{"query":"from_file"}`); err != nil {
+		t.Fatalf("cannot write body file: %s", err)
+	}
+	_ = bodyFile.Close()
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, options string, requestExpected request) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: post
+  stream_parse: %v
+  %s
+  static_configs:
+  - targets: [%q]
+`, streamParse, options, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		var samplesScraped float64
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "scrape_samples_scraped" {
+					samplesScraped = ts.Samples[0].Value
+				}
+			}
+		})
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		req := <-requestCh
+		if req != requestExpected {
+			t.Fatalf("unexpected request;\ngot\n%+v\nwant\n%+v", req, requestExpected)
+		}
+		if samplesScraped != 2 {
+			t.Fatalf("unexpected number of scraped samples; got %v; want 2", samplesScraped)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		// GET without body by default
+		f(streamParse, "", request{
+			method: "GET",
+		})
+
+		// POST with body and the default content-type
+		f(streamParse, `method: POST
+  body: '{"query":"subset"}'`, request{
+			method:      "POST",
+			contentType: "application/json",
+			body:        `{"query":"subset"}`,
+		})
+
+		// POST with body_file and custom content-type
+		f(streamParse, fmt.Sprintf(`method: post
+  body_file: %q
+  content_type: text/plain`, bodyFile.Name()), request{
+			method:      "POST",
+			contentType: "text/plain",
+			body:        `{"query":"from_file"}`,
+		})
+	}
+}
+
+func TestScrapeWorkSOCKS5Proxy(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	_, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", u.Host, err)
+	}
+	ps := newTestSOCKS5Server(t, "user", "pass")
+	defer ps.close()
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, proxyURL, addrExpected string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: proxy
+  stream_parse: %v
+  proxy_url: %q
+  static_configs:
+  - targets: ["localhost:%s"]
+`, streamParse, proxyURL, port)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		var samples int
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "foo" {
+					samples += len(ts.Samples)
+				}
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if samples != 1 {
+			t.Fatalf("unexpected number of scraped samples; got %d; want 1", samples)
+		}
+		if addr := ps.lastAddr(); addr != addrExpected {
+			t.Fatalf("unexpected addr requested via proxy; got %q; want %q", addr, addrExpected)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		// The target name is resolved by the proxy.
+		f(streamParse, fmt.Sprintf("socks5h://user:pass@%s", ps.addr()), "localhost:"+port)
+
+		// The target name is resolved locally.
+		f(streamParse, fmt.Sprintf("socks5://user:pass@%s", ps.addr()), "127.0.0.1:"+port)
+	}
+
+	// Invalid credentials
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: proxy
+  proxy_url: "socks5h://user:bad@%s"
+  static_configs:
+  - targets: [%q]
+`, ps.addr(), u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+	if err := sc.sw.scrapeInternal(123000, 123000); err == nil {
+		t.Fatalf("expecting non-nil error for invalid proxy credentials")
+	}
+}
+
+func TestScrapeWorkSeparateDiscoveryAndScrapeProxies(t *testing.T) {
+	var port string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/eureka/apps":
+			fmt.Fprintf(w, `<applications><application><name>foo</name><instance>
+<hostName>localhost</hostName><port enabled="true">%s</port><instanceId>foo-1</instanceId>
+</instance></application></applications>`, port)
+		case "/metrics":
+			fmt.Fprintf(w, "foo 1\n")
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	_, port, err = net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", u.Host, err)
+	}
+
+	f := func(sdViaProxy, scrapeViaProxy bool) {
+		t.Helper()
+		sdProxy := newTestSOCKS5Server(t, "sd", "pass")
+		defer sdProxy.close()
+		scrapeProxy := newTestSOCKS5Server(t, "scrape", "pass")
+		defer scrapeProxy.close()
+		sdProxyURL := ""
+		if sdViaProxy {
+			sdProxyURL = fmt.Sprintf("proxy_url: socks5h://sd:pass@%s", sdProxy.addr())
+		}
+		scrapeProxyURL := ""
+		if scrapeViaProxy {
+			scrapeProxyURL = fmt.Sprintf("proxy_url: socks5h://scrape:pass@%s", scrapeProxy.addr())
+		}
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: eureka
+  %s
+  eureka_sd_configs:
+  - server: "127.0.0.1:%s/eureka"
+    %s
+`, scrapeProxyURL, port, sdProxyURL)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getEurekaSDScrapeWork(nil)
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sdAddrExpected := ""
+		if sdViaProxy {
+			sdAddrExpected = "127.0.0.1:" + port
+		}
+		if addr := sdProxy.lastAddr(); addr != sdAddrExpected {
+			t.Fatalf("unexpected addr requested via discovery proxy; got %q; want %q", addr, sdAddrExpected)
+		}
+		if addr := scrapeProxy.lastAddr(); addr != "" {
+			t.Fatalf("unexpected discovery request via scrape proxy to %q", addr)
+		}
+
+		var samples int
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "foo" {
+					samples += len(ts.Samples)
+				}
+			}
+		})
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if samples != 1 {
+			t.Fatalf("unexpected number of scraped samples; got %d; want 1", samples)
+		}
+		scrapeAddrExpected := ""
+		if scrapeViaProxy {
+			scrapeAddrExpected = "localhost:" + port
+		}
+		if addr := scrapeProxy.lastAddr(); addr != scrapeAddrExpected {
+			t.Fatalf("unexpected addr requested via scrape proxy; got %q; want %q", addr, scrapeAddrExpected)
+		}
+		if addr := sdProxy.lastAddr(); addr != sdAddrExpected {
+			t.Fatalf("unexpected scrape request via discovery proxy to %q", addr)
+		}
+	}
+
+	// Discovery and scrape requests are routed via distinct proxies.
+	f(true, true)
+
+	// Only discovery requests are routed via proxy.
+	f(true, false)
+
+	// Only scrape requests are routed via proxy.
+	f(false, true)
+}
+
+func TestScrapeWorkOpenStackSDProxy(t *testing.T) {
+	var port string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			if r.Method != "POST" {
+				http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("X-Subject-Token", "test-token")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token":{"expires_at":%q,"catalog":[{"type":"compute","endpoints":[{"interface":"public","url":"http://localhost:%s/compute"}]}]}}`,
+				time.Now().Add(time.Hour).Format(time.RFC3339), port)
+		case "/compute/servers/detail":
+			if r.Header.Get("X-Auth-Token") != "test-token" {
+				http.Error(w, "unexpected X-Auth-Token", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `{"servers":[{"id":"foo-1","name":"foo","status":"ACTIVE","addresses":{"private":[{"addr":"127.0.0.1","version":4,"OS-EXT-IPS:type":"fixed"}]}}]}`)
+		case "/metrics":
+			fmt.Fprintf(w, "foo 1\n")
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	_, port, err = net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", u.Host, err)
+	}
+
+	f := func(password string, addrsExpected []string) {
+		t.Helper()
+		sdProxy := newTestSOCKS5Server(t, "sd", "pass")
+		defer sdProxy.close()
+		scrapeProxy := newTestSOCKS5Server(t, "scrape", "pass")
+		defer scrapeProxy.close()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: openstack
+  proxy_url: socks5h://scrape:pass@%s
+  openstack_sd_configs:
+  - role: instance
+    identity_endpoint: "http://127.0.0.1:%s/identity/v3"
+    username: user
+    password: secret
+    domain_name: default
+    port: %s
+    proxy_url: socks5h://sd:%s@%s
+`, scrapeProxy.addr(), port, port, password, sdProxy.addr())
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getOpenStackSDScrapeWork(nil)
+		if addrs := sdProxy.addrs(); !reflect.DeepEqual(addrs, addrsExpected) {
+			t.Fatalf("unexpected addrs requested via discovery proxy; got %q; want %q", addrs, addrsExpected)
+		}
+		if addrs := scrapeProxy.addrs(); len(addrs) > 0 {
+			t.Fatalf("unexpected discovery requests via scrape proxy to %q", addrs)
+		}
+		if len(addrsExpected) == 0 {
+			// Discovery must fail if identity requests cannot be sent via the proxy.
+			if len(sws) != 0 {
+				t.Fatalf("unexpected number of scrape works; got %d; want 0", len(sws))
+			}
+			return
+		}
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if addr := scrapeProxy.lastAddr(); addr != "127.0.0.1:"+port {
+			t.Fatalf("unexpected addr requested via scrape proxy; got %q; want %q", addr, "127.0.0.1:"+port)
+		}
+		if addrs := sdProxy.addrs(); !reflect.DeepEqual(addrs, addrsExpected) {
+			t.Fatalf("unexpected scrape requests via discovery proxy; got %q; want %q", addrs, addrsExpected)
+		}
+	}
+
+	// Both identity and compute api requests are routed via the discovery proxy.
+	f("pass", []string{"127.0.0.1:" + port, "localhost:" + port})
+
+	// Identity requests fail if the discovery proxy rejects credentials.
+	f("bad", nil)
+}
+
+// testSOCKS5Server is a minimal SOCKS5 server with username/password auth, which supports only CONNECT command.
+type testSOCKS5Server struct {
+	ln       net.Listener
+	username string
+	password string
+
+	mu    sync.Mutex
+	dests []string
+}
+
+func newTestSOCKS5Server(t *testing.T, username, password string) *testSOCKS5Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start SOCKS5 server: %s", err)
+	}
+	ps := &testSOCKS5Server{
+		ln:       ln,
+		username: username,
+		password: password,
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ps.serveConn(c)
+		}
+	}()
+	return ps
+}
+
+func (ps *testSOCKS5Server) addr() string {
+	return ps.ln.Addr().String()
+}
+
+func (ps *testSOCKS5Server) close() {
+	_ = ps.ln.Close()
+}
+
+func (ps *testSOCKS5Server) lastAddr() string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if len(ps.dests) == 0 {
+		return ""
+	}
+	return ps.dests[len(ps.dests)-1]
+}
+
+// addrs returns all the addresses requested via ps in the order of requests.
+func (ps *testSOCKS5Server) addrs() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return append([]string(nil), ps.dests...)
+}
+
+func (ps *testSOCKS5Server) serveConn(c net.Conn) {
+	defer func() {
+		_ = c.Close()
+	}()
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	// Method negotiation: VER, NMETHODS, METHODS. Always require username/password auth.
+	hdr := readBytes(2)
+	if hdr == nil || hdr[0] != 5 || readBytes(int(hdr[1])) == nil {
+		return
+	}
+	if _, err := c.Write([]byte{5, 2}); err != nil {
+		return
+	}
+
+	// Username/password auth according to RFC 1929.
+	hdr = readBytes(2)
+	if hdr == nil {
+		return
+	}
+	username := readBytes(int(hdr[1]))
+	hdr = readBytes(1)
+	if username == nil || hdr == nil {
+		return
+	}
+	password := readBytes(int(hdr[0]))
+	if string(username) != ps.username || string(password) != ps.password {
+		_, _ = c.Write([]byte{1, 1})
+		return
+	}
+	if _, err := c.Write([]byte{1, 0}); err != nil {
+		return
+	}
+
+	// Request: VER, CMD, RSV, ATYP, DST.ADDR, DST.PORT
+	hdr = readBytes(4)
+	if hdr == nil || hdr[1] != 1 {
+		return
+	}
+	var host string
+	switch hdr[3] {
+	case 1:
+		host = net.IP(readBytes(4)).String()
+	case 3:
+		n := readBytes(1)
+		if n == nil {
+			return
+		}
+		host = string(readBytes(int(n[0])))
+	case 4:
+		host = net.IP(readBytes(16)).String()
+	default:
+		return
+	}
+	portBuf := readBytes(2)
+	if portBuf == nil {
+		return
+	}
+	port := strconv.Itoa(int(portBuf[0])<<8 | int(portBuf[1]))
+	dest := net.JoinHostPort(host, port)
+	ps.mu.Lock()
+	ps.dests = append(ps.dests, dest)
+	ps.mu.Unlock()
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+	dc, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		_, _ = c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() {
+		_ = dc.Close()
+	}()
+	if _, err := c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(dc, c)
+		_ = dc.Close()
+	}()
+	_, _ = io.Copy(c, dc)
+}
+
+func TestScrapeWorkScrapePoolLabel(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\nbar{scrape_pool=\"scraped\"} 2\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	f := func(globalCfg, jobCfg string, resultExpected map[string]string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+global:
+  %s
+scrape_configs:
+- job_name: pool
+  %s
+  static_configs:
+  - targets: [%q]
+`, globalCfg, jobCfg, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		result := make(map[string]string)
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if name != "foo" && name != "bar" && name != "up" {
+					continue
+				}
+				var labels []string
+				for _, label := range ts.Labels {
+					if strings.Contains(label.Name, "pool") {
+						labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+					}
+				}
+				result[name] = strings.Join(labels, ",")
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected labels;\ngot\n%v\nwant\n%v", result, resultExpected)
+		}
+	}
+
+	// Disabled by default
+	f("", "", map[string]string{
+		"foo": ``,
+		"bar": `scrape_pool="scraped"`,
+		"up":  ``,
+	})
+
+	// Enabled globally
+	f("add_scrape_pool_label: true", "", map[string]string{
+		"foo": `scrape_pool="pool"`,
+		"bar": `exported_scrape_pool="scraped",scrape_pool="pool"`,
+		"up":  `scrape_pool="pool"`,
+	})
+
+	// Enabled globally with honor_labels
+	f("add_scrape_pool_label: true", "honor_labels: true", map[string]string{
+		"foo": `scrape_pool="pool"`,
+		"bar": `scrape_pool="scraped"`,
+		"up":  `scrape_pool="pool"`,
+	})
+
+	// Disabled per job
+	f("add_scrape_pool_label: true", "add_scrape_pool_label: false", map[string]string{
+		"foo": ``,
+		"bar": `scrape_pool="scraped"`,
+		"up":  ``,
+	})
+
+	// Enabled per job with custom label name
+	f("scrape_pool_label_name: vm_pool", "add_scrape_pool_label: true", map[string]string{
+		"foo": `vm_pool="pool"`,
+		"bar": `scrape_pool="scraped",vm_pool="pool"`,
+		"up":  `vm_pool="pool"`,
+	})
+}
+
+func TestScrapeWorkAuthProfiles(t *testing.T) {
+	authCh := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCh <- r.Header.Get("Authorization")
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: profiles
+  bearer_token: default-token
+  auth_profiles:
+    bearer:
+      bearer_token: xyz
+    basic:
+      basic_auth:
+        username: user
+        password: pass
+  relabel_configs:
+  - source_labels: [auth]
+    target_label: __auth_profile__
+  static_configs:
+  - targets: [%q]
+    labels:
+      auth: bearer
+  - targets: [%q]
+    labels:
+      auth: basic
+  - targets: [%q]
+`, u.Host, u.Host, u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 3 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 3", len(sws))
+	}
+	authExpected := []string{
+		"Bearer xyz",
+		"Basic dXNlcjpwYXNz",
+		"Bearer default-token",
+	}
+	for i := range sws {
+		sc := newScraper(&sws[i], "test", func(wr *prompbmarshal.WriteRequest) {})
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if auth := <-authCh; auth != authExpected[i] {
+			t.Fatalf("unexpected Authorization header for target #%d; got %q; want %q", i, auth, authExpected[i])
+		}
+	}
+}
+
+func TestScrapeWorkMetricRelabelProfiles(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\nbar 2\nbaz 3\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: profiles
+  metric_relabel_configs:
+  - action: drop
+    source_labels: [__name__]
+    regex: baz
+  metric_relabel_profiles:
+    no_foo:
+    - action: drop
+      source_labels: [__name__]
+      regex: foo
+    no_bar:
+    - action: drop
+      source_labels: [__name__]
+      regex: bar
+  relabel_configs:
+  - source_labels: [profile]
+    target_label: __metric_relabel_profile__
+  static_configs:
+  - targets: [%q]
+    labels:
+      profile: no_foo
+  - targets: [%q]
+    labels:
+      profile: no_bar
+  - targets: [%q]
+  - targets: [%q]
+    labels:
+      profile: missing
+`, u.Host, u.Host, u.Host, u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	// The target with unknown profile must be skipped.
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 3 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 3", len(sws))
+	}
+	metricsExpected := []string{
+		"bar",
+		"foo",
+		"bar,foo",
+	}
+	for i := range sws {
+		var metrics []string
+		sc := newScraper(&sws[i], "test", func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				name := promrelabel.GetLabelValueByName(ts.Labels, "__name__")
+				if name != "up" && !strings.HasPrefix(name, "scrape_") {
+					metrics = append(metrics, name)
+				}
+			}
+		})
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sort.Strings(metrics)
+		if s := strings.Join(metrics, ","); s != metricsExpected[i] {
+			t.Fatalf("unexpected metrics for target #%d; got %q; want %q", i, s, metricsExpected[i])
+		}
+	}
+}
+
+func TestScrapeWorkBackends(t *testing.T) {
+	newBackend := func(name string) (*httptest.Server, string) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "requests{backend=%q} 1\n", name)
+		}))
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s.URL, err)
+		}
+		return s, u.Host
+	}
+	s1, host1 := newBackend("first")
+	defer s1.Close()
+	s2, host2 := newBackend("second")
+	defer s2.Close()
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: backends
+  static_configs:
+  - targets: [logical-target]
+    labels:
+      __addresses__: "%s, %s"
+`, host1, host2)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	backendURLsExpected := []string{"http://" + host1 + "/metrics", "http://" + host2 + "/metrics"}
+	if !reflect.DeepEqual(sws[0].BackendScrapeURLs, backendURLsExpected) {
+		t.Fatalf("unexpected BackendScrapeURLs; got %q; want %q", sws[0].BackendScrapeURLs, backendURLsExpected)
+	}
+
+	var tss []prompbmarshal.TimeSeries
+	sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			tss = append(tss, prompbmarshal.TimeSeries{
+				Labels:  append([]prompbmarshal.Label{}, ts.Labels...),
+				Samples: append([]prompbmarshal.Sample{}, ts.Samples...),
+			})
+		}
+	})
+	f := func(hostExpected, backendExpected string, upExpected float64) {
+		t.Helper()
+		tss = tss[:0]
+		timestamp := int64(123000)
+		err := sc.sw.scrapeInternal(timestamp, timestamp)
+		if upExpected == 1 && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if upExpected == 0 && err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		backend := ""
+		up := float64(-1)
+		for i := range tss {
+			ts := &tss[i]
+			switch promrelabel.GetLabelValueByName(ts.Labels, "__name__") {
+			case "requests":
+				backend = promrelabel.GetLabelValueByName(ts.Labels, "backend")
+				if v := promrelabel.GetLabelValueByName(ts.Labels, "scrape_backend"); v != "" {
+					t.Fatalf("unexpected scrape_backend label for scraped series: %q", v)
+				}
+			case "up":
+				up = ts.Samples[0].Value
+				if v := promrelabel.GetLabelValueByName(ts.Labels, "scrape_backend"); v != hostExpected {
+					t.Fatalf("unexpected scrape_backend label; got %q; want %q", v, hostExpected)
+				}
+				if v := promrelabel.GetLabelValueByName(ts.Labels, "instance"); v != "logical-target:80" {
+					t.Fatalf("unexpected instance label; got %q; want %q", v, "logical-target:80")
+				}
+			}
+		}
+		if backend != backendExpected {
+			t.Fatalf("unexpected backend; got %q; want %q", backend, backendExpected)
+		}
+		if up != upExpected {
+			t.Fatalf("unexpected up; got %v; want %v", up, upExpected)
+		}
+	}
+
+	// Backends must be selected in round-robin manner.
+	f(host1, "first", 1)
+	f(host2, "second", 1)
+	f(host1, "first", 1)
+	f(host2, "second", 1)
+
+	// Failing backend results in failed scrape, while the next scrape goes to the next backend.
+	s2.Close()
+	f(host1, "first", 1)
+	f(host2, "", 0)
+	f(host1, "first", 1)
+}
+
+func TestScrapeWorkMetricsPathEscaping(t *testing.T) {
+	requestURICh := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURICh <- r.RequestURI
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool, tenant, requestURIExpected string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: tenants
+  stream_parse: %v
+  relabel_configs:
+  - source_labels: [tenant]
+    target_label: __tmp_tenant
+  - source_labels: [__tmp_tenant]
+    regex: "/"
+    replacement: "%%2F"
+    action: replace_all
+    target_label: __tmp_tenant
+  - source_labels: [__tmp_tenant]
+    regex: "(.+)"
+    replacement: "/tenant/$1/metrics"
+    target_label: __metrics_path__
+  static_configs:
+  - targets: [%q]
+    labels:
+      tenant: %q
+`, streamParse, u.Host, tenant)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		sc := newScraper(&sws[0], "test", func(wr *prompbmarshal.WriteRequest) {})
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if requestURI := <-requestURICh; requestURI != requestURIExpected {
+			t.Fatalf("unexpected request uri; got %q; want %q", requestURI, requestURIExpected)
+		}
+	}
+	for _, streamParse := range []bool{false, true} {
+		f(streamParse, "foo", "/tenant/foo/metrics")
+		f(streamParse, "foo bar", "/tenant/foo%20bar/metrics")
+		f(streamParse, "foo/bar", "/tenant/foo%2Fbar/metrics")
+		f(streamParse, "a#b c/d", "/tenant/a%23b%20c%2Fd/metrics")
+	}
+}
+
+func TestScrapeWorkScrapeErrorsMetric(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "unavailable")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	f := func(streamParse bool) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: errors
+  stream_parse: %v
+  static_configs:
+  - targets: [%q]
+`, streamParse, u.Host)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		const group = "test_scrape_errors"
+		sc := newScraper(&sws[0], group, func(wr *prompbmarshal.WriteRequest) {})
+		c := metrics.GetOrCreateCounter(fmt.Sprintf(`vm_promscrape_scrape_errors_total{type=%q, reason="http_5xx"}`, group))
+		errorsBefore := c.Get()
+		timestamp := int64(123000)
+		// The returned error is ignored, since only the registered error reason is verified.
+		_ = sc.sw.scrapeInternal(timestamp, timestamp)
+		if n := c.Get() - errorsBefore; n != 1 {
+			t.Fatalf("unexpected number of http_5xx scrape errors; got %d; want 1", n)
+		}
+	}
+	f(false)
+	f(true)
+}
+
+func TestGetScrapeErrorReason(t *testing.T) {
+	testCases := []struct {
+		err            error
+		reasonExpected string
+	}{
+		{newStatusCodeError("http://foo/metrics", 404, nil), scrapeErrorReasonHTTP4xx},
+		{newStatusCodeError("http://foo/metrics", 503, nil), scrapeErrorReasonHTTP5xx},
+		{newStatusCodeError("http://foo/metrics", 302, nil), scrapeErrorReasonOther},
+		{fmt.Errorf("error when scraping: %w", &net.DNSError{Err: "no such host", Name: "foo"}), scrapeErrorReasonDNS},
+		{fmt.Errorf("error when scraping: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), scrapeErrorReasonConnect},
+		{fmt.Errorf("error when scraping: %w", fasthttp.ErrTimeout), scrapeErrorReasonTimeout},
+		{fmt.Errorf("error when scraping: %w", x509.UnknownAuthorityError{}), scrapeErrorReasonTLS},
+		{fmt.Errorf("tls: handshake failure"), scrapeErrorReasonTLS},
+		{fmt.Errorf("cannot parse response: %w", errParseTimeout), scrapeErrorReasonParseTimeout},
+		{fmt.Errorf("skipping scrape: %w", errCircuitBreakerOpen), scrapeErrorReasonCircuitOpen},
+		{fmt.Errorf("invalid response: %w", errRequiredMetricsMissing), scrapeErrorReasonSchemaValidation},
+		{fmt.Errorf("invalid response: %w", errUnexpectedContentType), scrapeErrorReasonUnexpectedContentType},
+		{fmt.Errorf("foo bar"), scrapeErrorReasonOther},
+	}
+	reasonsSeen := map[string]bool{
+		// Parse errors are registered directly when parsing the response, so they aren't returned from getScrapeErrorReason.
+		scrapeErrorReasonParse: true,
+	}
+	for _, tc := range testCases {
+		reason := getScrapeErrorReason(tc.err)
+		if reason != tc.reasonExpected {
+			t.Fatalf("unexpected reason for %q; got %q; want %q", tc.err, reason, tc.reasonExpected)
+		}
+		reasonsSeen[reason] = true
+	}
+	for _, reason := range scrapeErrorReasons {
+		if !reasonsSeen[reason] {
+			t.Fatalf("missing test case for reason %q", reason)
+		}
+	}
+	if len(reasonsSeen) != len(scrapeErrorReasons) {
+		t.Fatalf("unexpected number of reasons; got %d; want %d", len(reasonsSeen), len(scrapeErrorReasons))
+	}
+}
+
+func TestScrapeWorkScrapeTimeoutOffset(t *testing.T) {
+	f := func(scrapeTimeoutOffset string, requestTimeoutExpected time.Duration) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: offset
+  scrape_timeout: 2s
+  stream_parse: true
+  %s
+  static_configs:
+  - targets: ["foo"]
+`, scrapeTimeoutOffset)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		c := newClient(&sws[0])
+		if c.hc.ReadTimeout != requestTimeoutExpected {
+			t.Fatalf("unexpected request timeout; got %s; want %s", c.hc.ReadTimeout, requestTimeoutExpected)
+		}
+		if c.sc.Timeout != requestTimeoutExpected {
+			t.Fatalf("unexpected stream request timeout; got %s; want %s", c.sc.Timeout, requestTimeoutExpected)
+		}
+		// Verify the deadline passed to the request context.
+		now := time.Now()
+		deadline := getAttemptDeadline(c.hc.ReadTimeout, now.Add(time.Hour))
+		if d := deadline.Sub(now); d < requestTimeoutExpected || d > requestTimeoutExpected+time.Second {
+			t.Fatalf("unexpected request deadline; got now+%s; want now+%s", d, requestTimeoutExpected)
+		}
+	}
+	f("", 2*time.Second-defaultScrapeTimeoutOffset)
+	f("scrape_timeout_offset: 0s", 2*time.Second)
+	f("scrape_timeout_offset: 500ms", 1500*time.Millisecond)
+}
+
+func TestScrapeWorkParseTimeout(t *testing.T) {
+	// Generate the response, which is parsed in multiple chunks, so the parse timeout is checked during the parsing.
+	var bb bytes.Buffer
+	for i := 0; bb.Len() < 1024*1024; i++ {
+		fmt.Fprintf(&bb, "foo{bar=\"baz\",i=\"%d\"} %d\n", i, i)
+	}
+	body := bb.Bytes()
+	const group = "test_parse_timeout"
+	f := func(parseTimeout time.Duration, upExpected float64) {
+		t.Helper()
+		var sw scrapeWork
+		sw.Config = ScrapeWork{
+			ScrapeURL:    "http://foo.bar/metrics",
+			ParseTimeout: parseTimeout,
+		}
+		sw.ScrapeGroup = group
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			return append(dst, body...), nil
+		}
+		up := float64(-1)
+		samplesPushed := 0
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				switch promrelabel.GetLabelValueByName(ts.Labels, "__name__") {
+				case "up":
+					up = ts.Samples[0].Value
+				case "foo":
+					samplesPushed++
+				}
+			}
+		}
+		c := metrics.GetOrCreateCounter(fmt.Sprintf(`vm_promscrape_scrape_errors_total{type=%q, reason="parse_timeout"}`, group))
+		errorsBefore := c.Get()
+		startTime := time.Now()
+		timestamp := int64(123000)
+		err := sw.scrapeInternal(timestamp, timestamp)
+		if d := time.Since(startTime); d > 10*time.Second {
+			t.Fatalf("too long scrape duration: %s", d)
+		}
+		if up != upExpected {
+			t.Fatalf("unexpected up; got %v; want %v", up, upExpected)
+		}
+		if upExpected == 1 {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if samplesPushed == 0 {
+				t.Fatalf("missing scraped samples")
+			}
+			return
+		}
+		if !errors.Is(err, errParseTimeout) {
+			t.Fatalf("expecting parse timeout error; got %v", err)
+		}
+		if samplesPushed != 0 {
+			t.Fatalf("partially parsed samples mustn't be pushed; got %d samples", samplesPushed)
+		}
+		if n := c.Get() - errorsBefore; n != 1 {
+			t.Fatalf("unexpected number of parse_timeout scrape errors; got %d; want 1", n)
+		}
+	}
+	f(time.Nanosecond, 0)
+	f(time.Hour, 1)
+	f(0, 1)
+}
+
+func TestScrapeWorkFileScrape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promscrape-file-scrape")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	data := "foo{bar=\"baz\"} 1\nqwe 2.5\n"
+	if err := ioutil.WriteFile(dir+"/metrics.prom", []byte(data), 0600); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+	var bb bytes.Buffer
+	zw := gzip.NewWriter(&bb)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatalf("cannot compress data: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close gzip writer: %s", err)
+	}
+	if err := ioutil.WriteFile(dir+"/metrics.prom.gz", bb.Bytes(), 0600); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+
+	// Unmarshal workers are needed for stream parsing.
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	// File targets must be skipped until -promscrape.allowFileTargets is set.
+	sws, err := getStaticScrapeWork([]byte(fmt.Sprintf(`
+scrape_configs:
+- job_name: file
+  static_configs:
+  - targets: [%q]
+`, "file://"+dir+"/metrics.prom")), "non-existing-file")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sws) != 0 {
+		t.Fatalf("expecting the file target to be skipped without -promscrape.allowFileTargets; got %d scrape works", len(sws))
+	}
+	allowFileTargetsOrig := *allowFileTargets
+	*allowFileTargets = true
+	defer func() {
+		*allowFileTargets = allowFileTargetsOrig
+	}()
+
+	f := func(streamParse bool, fileName string) {
+		t.Helper()
+		address := "file://" + dir + "/" + fileName
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: file
+  stream_parse: %v
+  static_configs:
+  - targets: [%q]
+`, streamParse, address)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		if sws[0].ScrapeURL != address {
+			t.Fatalf("unexpected scrape url; got %q; want %q", sws[0].ScrapeURL, address)
+		}
+		var tss []prompbmarshal.TimeSeries
+		pushData := func(wr *prompbmarshal.WriteRequest) {
+			for _, ts := range wr.Timeseries {
+				labels := append([]prompbmarshal.Label{}, ts.Labels...)
+				samples := append([]prompbmarshal.Sample{}, ts.Samples...)
+				tss = append(tss, prompbmarshal.TimeSeries{
+					Labels:  labels,
+					Samples: samples,
+				})
+			}
+		}
+		sc := newScraper(&sws[0], "test", pushData)
+		timestamp := int64(123000)
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		dataExpected := strings.ReplaceAll(`
+		foo{bar="baz",instance="ADDRESS",job="file"} 1 123
+		qwe{instance="ADDRESS",job="file"} 2.5 123
+		up{instance="ADDRESS",job="file"} 1 123
+		scrape_samples_scraped{instance="ADDRESS",job="file"} 2 123
+		scrape_duration_seconds{instance="ADDRESS",job="file"} 0 123
+		scrape_samples_post_metric_relabeling{instance="ADDRESS",job="file"} 2 123
+		scrape_series_added{instance="ADDRESS",job="file"} 2 123
+		scrape_body_size_bytes{instance="ADDRESS",job="file"} 25 123
+`, "ADDRESS", address)
+		timeseriesExpected := parseData(dataExpected)
+		if err := expectEqualTimeseries(tss, timeseriesExpected); err != nil {
+			t.Fatalf("unexpected data pushed: %s\ngot\n%v\nwant\n%v", err, tss, timeseriesExpected)
+		}
+	}
+	f(false, "metrics.prom")
+	f(true, "metrics.prom")
+	f(false, "metrics.prom.gz")
+	f(true, "metrics.prom.gz")
+}
+
+func TestScrapeWorkStartupRamp(t *testing.T) {
+	rampDurationOrig := *startupRampDuration
+	*startupRampDuration = time.Second
+	startupRampOnce = sync.Once{}
+	defer func() {
+		*startupRampDuration = rampDurationOrig
+		startupRampOnce = sync.Once{}
+	}()
+
+	const targetsCount = 50
+	startTime := time.Now()
+	firstScrapeCh := make(chan time.Duration, targetsCount)
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < targetsCount; i++ {
+		sw := &scrapeWork{}
+		sw.Config.ScrapeURL = fmt.Sprintf("http://foo%d/metrics", i)
+		sw.Config.ScrapeInterval = 20 * time.Millisecond
+		var once sync.Once
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			once.Do(func() {
+				firstScrapeCh <- time.Since(startTime)
+			})
+			return dst, nil
+		}
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sw.run(stopCh)
+		}()
+	}
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+
+	// Without the ramp all the first scrapes would occur during the first scrape_interval.
+	var minDelay, maxDelay time.Duration
+	earlyScrapes := 0
+	for i := 0; i < targetsCount; i++ {
+		select {
+		case d := <-firstScrapeCh:
+			if i == 0 || d < minDelay {
+				minDelay = d
+			}
+			if d > maxDelay {
+				maxDelay = d
+			}
+			if d < 200*time.Millisecond {
+				earlyScrapes++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout when waiting for the first scrapes")
+		}
+	}
+	if maxDelay-minDelay < 500*time.Millisecond {
+		t.Fatalf("the first scrapes aren't spread over the ramp duration; min delay: %s, max delay: %s", minDelay, maxDelay)
+	}
+	if earlyScrapes > targetsCount/2 {
+		t.Fatalf("too many early scrapes; got %d out of %d", earlyScrapes, targetsCount)
+	}
+}
+
+func TestScrapeWorkConditionalScrape(t *testing.T) {
+	var notModifiedResponses uint64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddUint64(&notModifiedResponses, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintf(w, "foo{bar=\"baz\"} 1\nqwe 2\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: conditional
+  conditional_scrape: true
+  static_configs:
+  - targets: [%q]
+`, u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	var tss []prompbmarshal.TimeSeries
+	pushData := func(wr *prompbmarshal.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			labels := append([]prompbmarshal.Label{}, ts.Labels...)
+			samples := append([]prompbmarshal.Sample{}, ts.Samples...)
+			tss = append(tss, prompbmarshal.TimeSeries{
+				Labels:  labels,
+				Samples: samples,
+			})
+		}
+	}
+	sc := newScraper(&sws[0], "test", pushData)
+	f := func(timestamp int64, dataExpected string) {
+		t.Helper()
+		tss = tss[:0]
+		if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		dataExpected = strings.ReplaceAll(dataExpected, "HOST", u.Host)
+		timeseriesExpected := parseData(dataExpected)
+		if err := expectEqualTimeseries(tss, timeseriesExpected); err != nil {
+			t.Fatalf("unexpected data pushed: %s\ngot\n%v\nwant\n%v", err, tss, timeseriesExpected)
+		}
+	}
+	f(123000, `
+		foo{bar="baz",instance="HOST",job="conditional"} 1 123
+		qwe{instance="HOST",job="conditional"} 2 123
+		up{instance="HOST",job="conditional"} 1 123
+		scrape_samples_scraped{instance="HOST",job="conditional"} 2 123
+		scrape_duration_seconds{instance="HOST",job="conditional"} 0 123
+		scrape_samples_post_metric_relabeling{instance="HOST",job="conditional"} 2 123
+		scrape_series_added{instance="HOST",job="conditional"} 2 123
+		scrape_body_size_bytes{instance="HOST",job="conditional"} 23 123
+`)
+	if n := atomic.LoadUint64(&notModifiedResponses); n != 0 {
+		t.Fatalf("unexpected number of 304 responses for the first scrape; got %d; want 0", n)
+	}
+
+	// The previously scraped series must be re-emitted with new timestamps on `304 Not Modified` response.
+	for i := 0; i < 2; i++ {
+		f(456000, `
+		foo{bar="baz",instance="HOST",job="conditional"} 1 456
+		qwe{instance="HOST",job="conditional"} 2 456
+		up{instance="HOST",job="conditional"} 1 456
+		scrape_samples_scraped{instance="HOST",job="conditional"} 2 456
+		scrape_duration_seconds{instance="HOST",job="conditional"} 0 456
+		scrape_samples_post_metric_relabeling{instance="HOST",job="conditional"} 2 456
+		scrape_series_added{instance="HOST",job="conditional"} 0 456
+		scrape_body_size_bytes{instance="HOST",job="conditional"} 23 456
+`)
+	}
+	if n := atomic.LoadUint64(&notModifiedResponses); n != 2 {
+		t.Fatalf("unexpected number of 304 responses; got %d; want 2", n)
+	}
+}
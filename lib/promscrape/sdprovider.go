@@ -0,0 +1,165 @@
+package promscrape
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+)
+
+var customSDCheckInterval = flag.Duration("promscrape.customSDCheckInterval", 30*time.Second, "Interval for checking for changes in custom service discovery "+
+	"providers registered via promscrape.RegisterSDProvider by the application embedding the scraper")
+
+// SDProvider is a custom service discovery, which may be registered via RegisterSDProvider.
+type SDProvider interface {
+	// GetScrapeWork must return targets to scrape. swsPrev contains targets returned by the previous call.
+	//
+	// The previously discovered targets continue to be scraped if error is returned.
+	//
+	// ScrapeURL, ScrapeInterval and Labels must be set in the returned targets. Labels must contain `job` and `instance` labels.
+	// ScrapeTimeout is set to ScrapeInterval if it is zero. AuthConfig without auth is used if it is nil.
+	GetScrapeWork(swsPrev []ScrapeWork) ([]ScrapeWork, error)
+}
+
+// RegisterSDProvider registers custom service discovery with the given name.
+//
+// factory is called on every load of `-promscrape.config`. It may return nil if the config has no targets for the provider.
+// The returned provider is queried for targets every `-promscrape.customSDCheckInterval`.
+// name is used as `type` label in `vm_promscrape_*` metrics and may be passed to RefreshSD. An error is returned if name is empty,
+// if it clashes with the names of built-in service discovery sections such as `kubernetes_sd_configs`
+// or if a provider with the given name is already registered.
+//
+// Providers must be registered before Init call. Pass nil factory in order to unregister the previously registered provider with the given name.
+func RegisterSDProvider(name string, factory func(cfg *Config) SDProvider) error {
+	sdProvidersLock.Lock()
+	defer sdProvidersLock.Unlock()
+	if factory == nil {
+		delete(sdProviders, name)
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("custom service discovery name cannot be empty")
+	}
+	if isBuiltinSDName(name) {
+		return fmt.Errorf("custom service discovery name %q clashes with built-in service discovery", name)
+	}
+	if _, ok := sdProviders[name]; ok {
+		return fmt.Errorf("custom service discovery %q is already registered", name)
+	}
+	sdProviders[name] = factory
+	return nil
+}
+
+// isBuiltinSDName returns true if name is used by built-in service discovery.
+func isBuiltinSDName(name string) bool {
+	for _, sd := range getBuiltinDiscoveries() {
+		if sd.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	sdProvidersLock sync.Mutex
+	sdProviders     = make(map[string]func(cfg *Config) SDProvider)
+)
+
+// addSDProviders adds the registered custom service discovery providers to scs in the order of their names.
+func addSDProviders(scs *scrapeConfigs) {
+	for _, sd := range getSDProviderDiscoveries() {
+		scs.add(sd.name, sd.checkInterval, sd.getScrapeWork)
+	}
+}
+
+// getSDProviderDiscoveries returns service discoveries for the registered custom service discovery providers in the order of their names.
+func getSDProviderDiscoveries() []serviceDiscovery {
+	sdProvidersLock.Lock()
+	names := make([]string, 0, len(sdProviders))
+	for name := range sdProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	factories := make([]func(cfg *Config) SDProvider, len(names))
+	for i, name := range names {
+		factories[i] = sdProviders[name]
+	}
+	sdProvidersLock.Unlock()
+
+	sds := make([]serviceDiscovery, len(names))
+	for i, name := range names {
+		sds[i] = serviceDiscovery{
+			name:          name,
+			checkInterval: *customSDCheckInterval,
+			getScrapeWork: newSDProviderScrapeWork(name, factories[i]),
+		}
+	}
+	return sds
+}
+
+// newSDProviderScrapeWork returns getScrapeWork function for scrapeConfig with the custom service discovery created by factory.
+//
+// The returned function must be called only from a single goroutine.
+func newSDProviderScrapeWork(name string, factory func(cfg *Config) SDProvider) func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork {
+	var cfgPrev *Config
+	var p SDProvider
+	return func(cfg *Config, swsPrev []ScrapeWork) []ScrapeWork {
+		if cfg != cfgPrev {
+			// The provider is re-created only when the config is changed, so it could keep the state between calls.
+			p = factory(cfg)
+			cfgPrev = cfg
+		}
+		if p == nil {
+			return nil
+		}
+		sws, err := p.GetScrapeWork(swsPrev)
+		if err != nil {
+			logger.Errorf("error when discovering targets via custom service discovery %q: %s; continuing scraping the previously discovered targets", name, err)
+			return swsPrev
+		}
+		return normalizeSDProviderScrapeWork(name, sws)
+	}
+}
+
+// normalizeSDProviderScrapeWork sets the missing fields in sws returned by the custom service discovery with the given name.
+//
+// Invalid targets are skipped with an error. sws isn't modified, since it may be owned by the provider.
+func normalizeSDProviderScrapeWork(name string, sws []ScrapeWork) []ScrapeWork {
+	dst := make([]ScrapeWork, 0, len(sws))
+	for i := range sws {
+		sw := sws[i]
+		if err := sw.normalizeForSDProvider(); err != nil {
+			logger.Errorf("skipping target %s from custom service discovery %q: %s", sw.LabelsString(), name, err)
+			continue
+		}
+		dst = append(dst, sw)
+	}
+	return dst
+}
+
+func (sw *ScrapeWork) normalizeForSDProvider() error {
+	if sw.ScrapeURL == "" {
+		return fmt.Errorf("missing ScrapeURL")
+	}
+	if sw.ScrapeInterval <= 0 {
+		return fmt.Errorf("ScrapeInterval must be positive; got %s", sw.ScrapeInterval)
+	}
+	if sw.ScrapeTimeout <= 0 {
+		sw.ScrapeTimeout = sw.ScrapeInterval
+	}
+	if sw.AuthConfig == nil {
+		sw.AuthConfig = &promauth.Config{}
+	}
+	if sw.ID == 0 {
+		sw.ID = atomic.AddUint64(&nextScrapeWorkID, 1)
+	}
+	if sw.jobNameOriginal == "" {
+		sw.jobNameOriginal = sw.Job()
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+type fakeSDProvider struct {
+	targets []string
+}
+
+func (p *fakeSDProvider) GetScrapeWork(swsPrev []ScrapeWork) ([]ScrapeWork, error) {
+	var sws []ScrapeWork
+	for _, target := range p.targets {
+		sws = append(sws, ScrapeWork{
+			ScrapeURL:      "http://" + target + "/metrics",
+			ScrapeInterval: 100 * time.Millisecond,
+			Labels: []prompbmarshal.Label{
+				{
+					Name:  "instance",
+					Value: target,
+				},
+				{
+					Name:  "job",
+					Value: "fake_sd",
+				},
+			},
+		})
+	}
+	// Invalid target must be skipped.
+	sws = append(sws, ScrapeWork{
+		ScrapeURL: "http://invalid/metrics",
+	})
+	return sws, nil
+}
+
+func TestRegisterSDProvider(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	target := s.Listener.Addr().String()
+
+	var cfgsLock sync.Mutex
+	var cfgs []*Config
+	err := RegisterSDProvider("test_sd_provider", func(cfg *Config) SDProvider {
+		cfgsLock.Lock()
+		cfgs = append(cfgs, cfg)
+		cfgsLock.Unlock()
+		return &fakeSDProvider{
+			targets: []string{target},
+		}
+	})
+	if err != nil {
+		t.Fatalf("cannot register custom service discovery: %s", err)
+	}
+	defer func() {
+		_ = RegisterSDProvider("test_sd_provider", nil)
+	}()
+
+	var mu sync.Mutex
+	scraped := make(map[string]bool)
+	scs := newScrapeConfigs(func(wr *prompbmarshal.WriteRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ts := range wr.Timeseries {
+			if promrelabel.GetLabelValueByName(ts.Labels, "__name__") == "foo" {
+				scraped[promrelabel.GetLabelValueByName(ts.Labels, "instance")] = true
+			}
+		}
+	})
+	addSDProviders(scs)
+	defer scs.stop()
+	if len(scs.scfgs) != 1 || scs.scfgs[0].name != "test_sd_provider" {
+		t.Fatalf("the registered provider must be added to scrape configs")
+	}
+	cfg := &Config{}
+	scs.updateConfig(cfg)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		ok := scraped[target]
+		mu.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout while waiting for scraping the target from the registered provider")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadUint64(&scs.scfgs[0].discoveredTargets); n != 1 {
+		t.Fatalf("unexpected number of discovered targets; got %d; want 1", n)
+	}
+	cfgsLock.Lock()
+	defer cfgsLock.Unlock()
+	if len(cfgs) != 1 || cfgs[0] != cfg {
+		t.Fatalf("the provider must be created once for the loaded config; got %d providers", len(cfgs))
+	}
+}
+
+func TestRegisterSDProviderInvalidName(t *testing.T) {
+	factory := func(cfg *Config) SDProvider {
+		return nil
+	}
+	f := func(name string) {
+		t.Helper()
+		if err := RegisterSDProvider(name, factory); err == nil {
+			_ = RegisterSDProvider(name, nil)
+			t.Fatalf("expecting non-nil error when registering custom service discovery %q", name)
+		}
+	}
+
+	// Empty name
+	f("")
+
+	// Names of built-in service discoveries
+	for _, sd := range getBuiltinDiscoveries() {
+		f(sd.name)
+	}
+
+	// Already registered name
+	if err := RegisterSDProvider("test_sd_provider_duplicate", factory); err != nil {
+		t.Fatalf("cannot register custom service discovery: %s", err)
+	}
+	defer func() {
+		_ = RegisterSDProvider("test_sd_provider_duplicate", nil)
+	}()
+	f("test_sd_provider_duplicate")
+
+	// The name can be registered again after unregistering
+	if err := RegisterSDProvider("test_sd_provider_duplicate", nil); err != nil {
+		t.Fatalf("cannot unregister custom service discovery: %s", err)
+	}
+	if err := RegisterSDProvider("test_sd_provider_duplicate", factory); err != nil {
+		t.Fatalf("cannot register custom service discovery after unregistering: %s", err)
+	}
+}
@@ -0,0 +1,189 @@
+package promscrape
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var secretsFileCheckInterval = flag.Duration("promscrape.secretsFileCheckInterval", 10*time.Second, "Interval for checking for changes in files "+
+	"referred by `secrets_file` option in `scrape_configs`. Scrape clients are re-created only for the targets, which use the changed secrets file, "+
+	"without reloading '-promscrape.config'. The checking is disabled if the interval is zero")
+
+// loadSecretsFile reads auth config from `secrets_file` at the given path.
+//
+// The file may contain `basic_auth`, `bearer_token`, `bearer_token_file` and `tls_config` sections in the same format as `auth_profiles` entries.
+// Relative paths in the file are resolved against the directory with the file.
+func loadSecretsFile(path string) (*promauth.Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read `secrets_file` %q: %w", path, err)
+	}
+	var ap AuthProfile
+	if err := unmarshalMaybeStrict(data, &ap); err != nil {
+		return nil, fmt.Errorf("cannot parse `secrets_file` %q: %w", path, err)
+	}
+	ac, err := promauth.NewConfig(filepath.Dir(path), ap.BasicAuth, ap.BearerToken, ap.BearerTokenFile, ap.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse auth config from `secrets_file` %q: %w", path, err)
+	}
+	return ac, nil
+}
+
+// secretsFileWatcher tracks changes in `secrets_file` files used by the running scrapers.
+//
+// The auth config from the changed file is passed only to the scrapers subscribed to this file,
+// so they re-create their scrape clients before the next scrape.
+type secretsFileWatcher struct {
+	mu sync.Mutex
+	m  map[string]*secretsFileEntry
+}
+
+type secretsFileEntry struct {
+	ac    *promauth.Config
+	acKey string
+
+	subscribers map[*scrapeWork]struct{}
+}
+
+var secretsFileWatcherGlobal = newSecretsFileWatcher()
+
+func newSecretsFileWatcher() *secretsFileWatcher {
+	return &secretsFileWatcher{
+		m: make(map[string]*secretsFileEntry),
+	}
+}
+
+// subscribe subscribes sw to changes in sw.Config.SecretsFile. It is no-op if sw.Config.SecretsFile is empty.
+func (sfw *secretsFileWatcher) subscribe(sw *scrapeWork) {
+	path := sw.Config.SecretsFile
+	if path == "" {
+		return
+	}
+	acKey := sw.Config.AuthConfig.String()
+	sfw.mu.Lock()
+	e := sfw.m[path]
+	if e == nil {
+		e = &secretsFileEntry{
+			ac:          sw.Config.AuthConfig,
+			acKey:       acKey,
+			subscribers: make(map[*scrapeWork]struct{}),
+		}
+		sfw.m[path] = e
+	}
+	e.subscribers[sw] = struct{}{}
+	if e.acKey != acKey {
+		// The file has been changed after sw.Config has been loaded.
+		sw.pendingAuthConfig.set(e.ac)
+	}
+	sfw.mu.Unlock()
+}
+
+// unsubscribe unsubscribes sw from changes in sw.Config.SecretsFile.
+func (sfw *secretsFileWatcher) unsubscribe(sw *scrapeWork) {
+	path := sw.Config.SecretsFile
+	if path == "" {
+		return
+	}
+	sfw.mu.Lock()
+	if e := sfw.m[path]; e != nil {
+		delete(e.subscribers, sw)
+		if len(e.subscribers) == 0 {
+			delete(sfw.m, path)
+		}
+	}
+	sfw.mu.Unlock()
+}
+
+// check re-reads all the subscribed secrets files and notifies subscribers of the changed files.
+func (sfw *secretsFileWatcher) check() {
+	sfw.mu.Lock()
+	paths := make([]string, 0, len(sfw.m))
+	for path := range sfw.m {
+		paths = append(paths, path)
+	}
+	sfw.mu.Unlock()
+
+	for _, path := range paths {
+		ac, err := loadSecretsFile(path)
+		if err != nil {
+			secretsFileReloadErrors.Inc()
+			logger.Errorf("%s; continuing using the previously loaded secrets", err)
+			continue
+		}
+		acKey := ac.String()
+		sfw.mu.Lock()
+		e := sfw.m[path]
+		if e == nil || e.acKey == acKey {
+			sfw.mu.Unlock()
+			continue
+		}
+		e.ac = ac
+		e.acKey = acKey
+		for sw := range e.subscribers {
+			sw.pendingAuthConfig.set(ac)
+		}
+		n := len(e.subscribers)
+		sfw.mu.Unlock()
+		secretsFileReloads.Inc()
+		logger.Infof("`secrets_file` %q has been changed; re-creating scrape clients for %d targets", path, n)
+	}
+}
+
+func runSecretsFileWatcher(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(*secretsFileCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			secretsFileWatcherGlobal.check()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// pendingAuthConfig holds auth config, which must be applied to the scrape clients before the next scrape.
+type pendingAuthConfig struct {
+	mu sync.Mutex
+	ac *promauth.Config
+}
+
+func (pac *pendingAuthConfig) set(ac *promauth.Config) {
+	pac.mu.Lock()
+	pac.ac = ac
+	pac.mu.Unlock()
+}
+
+// take returns the pending auth config and resets it. nil is returned if there is no pending auth config.
+func (pac *pendingAuthConfig) take() *promauth.Config {
+	pac.mu.Lock()
+	ac := pac.ac
+	pac.ac = nil
+	pac.mu.Unlock()
+	return ac
+}
+
+// applyPendingAuthConfig re-creates scrape clients for sw if the auth config from sw.Config.SecretsFile has been changed.
+func (sw *scrapeWork) applyPendingAuthConfig() {
+	ac := sw.pendingAuthConfig.take()
+	if ac == nil {
+		return
+	}
+	sw.Config.AuthConfig = ac
+	sw.initClients()
+	secretsFileClientRebuilds.Inc()
+}
+
+var (
+	secretsFileReloads        = metrics.NewCounter(`vm_promscrape_secrets_file_reloads_total`)
+	secretsFileReloadErrors   = metrics.NewCounter(`vm_promscrape_secrets_file_reload_errors_total`)
+	secretsFileClientRebuilds = metrics.NewCounter(`vm_promscrape_secrets_file_client_rebuilds_total`)
+)
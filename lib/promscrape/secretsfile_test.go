@@ -0,0 +1,141 @@
+package promscrape
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestSecretsFileRotation(t *testing.T) {
+	var authHeadersLock sync.Mutex
+	authHeaders := make(map[string]string)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeadersLock.Lock()
+		authHeaders[r.URL.Path] = r.Header.Get("Authorization")
+		authHeadersLock.Unlock()
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "secrets_file_test")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	writeFile := func(name, data string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("cannot write %q: %s", path, err)
+		}
+		return path
+	}
+	pathA := writeFile("a.yml", "basic_auth:\n  username: user\n  password: pass1\n")
+	pathB := writeFile("b.yml", "bearer_token: token-b\n")
+
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: a
+  metrics_path: /a
+  secrets_file: %q
+  static_configs:
+  - targets: [%q]
+- job_name: b
+  metrics_path: /b
+  secrets_file: %q
+  static_configs:
+  - targets: [%q]
+`, pathA, s.Listener.Addr().String(), pathB, s.Listener.Addr().String())
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+	pushData := func(wr *prompbmarshal.WriteRequest) {}
+	scA := newScraper(&sws[0], "test", pushData)
+	scB := newScraper(&sws[1], "test", pushData)
+	sfw := newSecretsFileWatcher()
+	sfw.subscribe(&scA.sw)
+	sfw.subscribe(&scB.sw)
+
+	basicAuth := func(password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte("user:"+password))
+	}
+	f := func(authA, authB string) {
+		t.Helper()
+		timestamp := int64(123000)
+		for _, sc := range []*scraper{scA, scB} {
+			if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		authHeadersLock.Lock()
+		defer authHeadersLock.Unlock()
+		if authHeaders["/a"] != authA {
+			t.Fatalf("unexpected Authorization header for pool a; got %q; want %q", authHeaders["/a"], authA)
+		}
+		if authHeaders["/b"] != authB {
+			t.Fatalf("unexpected Authorization header for pool b; got %q; want %q", authHeaders["/b"], authB)
+		}
+	}
+	f(basicAuth("pass1"), "Bearer token-b")
+
+	// Unchanged files mustn't result in re-creating the clients.
+	rebuildsStart := secretsFileClientRebuilds.Get()
+	sfw.check()
+	f(basicAuth("pass1"), "Bearer token-b")
+	if n := secretsFileClientRebuilds.Get() - rebuildsStart; n != 0 {
+		t.Fatalf("unexpected number of client rebuilds for unchanged secrets files; got %d; want 0", n)
+	}
+
+	// Only the clients for the pool with the rotated file must be re-created.
+	acB := scB.sw.Config.AuthConfig
+	writeFile("a.yml", "basic_auth:\n  username: user\n  password: pass2\n")
+	sfw.check()
+	f(basicAuth("pass2"), "Bearer token-b")
+	if n := secretsFileClientRebuilds.Get() - rebuildsStart; n != 1 {
+		t.Fatalf("unexpected number of client rebuilds; got %d; want 1", n)
+	}
+	if scB.sw.Config.AuthConfig != acB {
+		t.Fatalf("auth config for the pool with unchanged secrets file mustn't be updated")
+	}
+
+	// Invalid secrets file must be ignored, so the previously loaded secrets continue to be used.
+	writeFile("a.yml", "basic_auth:\n  password: pass3\n")
+	sfw.check()
+	f(basicAuth("pass2"), "Bearer token-b")
+	if n := secretsFileClientRebuilds.Get() - rebuildsStart; n != 1 {
+		t.Fatalf("unexpected number of client rebuilds after invalid secrets file; got %d; want 1", n)
+	}
+
+	// Unsubscribed scrapers mustn't be notified.
+	sfw.unsubscribe(&scA.sw)
+	writeFile("a.yml", "basic_auth:\n  username: user\n  password: pass4\n")
+	sfw.check()
+	f(basicAuth("pass2"), "Bearer token-b")
+}
+
+func TestLoadSecretsFile(t *testing.T) {
+	// password_file must be resolved relative to the directory with secrets file.
+	ac, err := loadSecretsFile("testdata/secrets.yml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	authExpected := "Basic " + base64.StdEncoding.EncodeToString([]byte("foo:secret-pass"))
+	if ac.Authorization != authExpected {
+		t.Fatalf("unexpected Authorization; got %q; want %q", ac.Authorization, authExpected)
+	}
+}
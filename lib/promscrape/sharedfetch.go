@@ -0,0 +1,153 @@
+package promscrape
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var dedupIdenticalTargets = flag.Bool("promscrape.dedupIdenticalTargets", false, "Whether to share a single scrape request among targets with identical scrape url, "+
+	"labels and request options across all the scrape configs. Every target applies its own metric_relabel_configs and limits to the shared response. "+
+	"See https://docs.victoriametrics.com/vmagent.html#troubleshooting")
+
+// sharedFetchKey returns the key for sharing the scraped response among targets with identical sw.ScrapeURL, sw.Labels and request options.
+//
+// An empty key is returned for targets, which cannot share the response, since their clients keep per-target state between scrapes.
+func sharedFetchKey(sw *ScrapeWork) string {
+	if sw.ConditionalScrape || sw.SchemeAuto || len(sw.BackendScrapeURLs) > 0 || len(sw.FallbackScrapeURLs) > 0 || isKafkaTarget(sw.ScrapeURL) {
+		return ""
+	}
+	var kb scrapeWorkKeyBuilder
+	kb.addString("ScrapeURL", sw.ScrapeURL)
+	kb.addString("Labels", sw.LabelsString())
+	kb.addInt("ScrapeTimeout", int64(sw.ScrapeTimeout))
+	kb.addInt("ScrapeRetries", int64(sw.ScrapeRetries))
+	kb.addString("AuthConfig", sw.AuthConfig.String())
+	kb.addString("SecretsFile", sw.SecretsFile)
+	kb.addBool("DisableCompression", sw.DisableCompression)
+	kb.addString("ExpositionFormat", sw.ExpositionFormat)
+	kb.addString("GRPCMethod", sw.GRPCMethod)
+	kb.addString("Method", sw.Method)
+	kb.addString("Body", sw.Body)
+	kb.addString("ContentType", sw.ContentType)
+	kb.addString("ObjectStore", sw.ObjectStore.String())
+	kb.addString("SSHTunnel", sw.SSHTunnel.String())
+	kb.addString("Login", sw.Login.String())
+	kb.addString("CloudflareAccess", sw.CloudflareAccess.String())
+	kb.addBool("CheckContentType", sw.CheckContentType)
+	kb.addInt("MaxDecompressedSize", int64(sw.MaxDecompressedSize))
+	kb.addStrings("ScrapeProtocols", sw.ScrapeProtocols)
+	kb.addString("ProxyURL", sw.ProxyURL.String())
+	kb.addString("ResponseCharset", sw.ResponseCharset)
+	return kb.String()
+}
+
+// sharedFetches shares scraped responses among running targets with identical sharedFetchKey.
+//
+// Such targets are scraped at the same offsets, since the offset depends only on the scrape url and labels.
+// The first target, which starts the scrape, performs the request, while the remaining targets wait for its response
+// instead of performing their own requests.
+type sharedFetches struct {
+	mu sync.Mutex
+
+	// targets contains the number of running targets per key.
+	targets map[string]int
+
+	// m contains the latest fetch per key.
+	m map[string]*sharedFetch
+}
+
+type sharedFetch struct {
+	// wg is done when the response is ready.
+	wg sync.WaitGroup
+
+	// deadline is the time until the response may be used by the remaining targets.
+	deadline time.Time
+
+	// pending is the number of the remaining targets, which didn't obtain the response yet.
+	pending int
+
+	data []byte
+	err  error
+}
+
+var sharedFetchesGlobal = &sharedFetches{
+	targets: make(map[string]int),
+	m:       make(map[string]*sharedFetch),
+}
+
+// register registers sw for sharing scraped responses. It is no-op if -promscrape.dedupIdenticalTargets isn't set.
+func (sfs *sharedFetches) register(sw *scrapeWork) {
+	if !*dedupIdenticalTargets {
+		return
+	}
+	key := sharedFetchKey(&sw.Config)
+	if key == "" {
+		return
+	}
+	sw.sharedFetchKey = key
+	sfs.mu.Lock()
+	sfs.targets[key]++
+	sfs.mu.Unlock()
+}
+
+// unregister unregisters sw registered via register.
+func (sfs *sharedFetches) unregister(sw *scrapeWork) {
+	key := sw.sharedFetchKey
+	if key == "" {
+		return
+	}
+	sfs.mu.Lock()
+	sfs.targets[key]--
+	if sfs.targets[key] <= 0 {
+		delete(sfs.targets, key)
+		delete(sfs.m, key)
+	}
+	sfs.mu.Unlock()
+}
+
+// readData appends the response for sw to dst.
+//
+// The response is obtained via sw.ReadData if there are no other running targets with the same key
+// or if they didn't start the scrape during the last sw.Config.ScrapeInterval/10.
+func (sfs *sharedFetches) readData(sw *scrapeWork, dst []byte) ([]byte, error) {
+	key := sw.sharedFetchKey
+	if key == "" {
+		return sw.ReadData(dst)
+	}
+	now := time.Now()
+	sfs.mu.Lock()
+	n := sfs.targets[key]
+	if n < 2 {
+		sfs.mu.Unlock()
+		return sw.ReadData(dst)
+	}
+	if sf := sfs.m[key]; sf != nil && now.Before(sf.deadline) && sf.pending > 0 {
+		sf.pending--
+		if sf.pending == 0 {
+			delete(sfs.m, key)
+		}
+		sfs.mu.Unlock()
+		sf.wg.Wait()
+		sharedFetchesRequestsSaved.Inc()
+		return append(dst, sf.data...), sf.err
+	}
+	sf := &sharedFetch{
+		deadline: now.Add(sw.Config.ScrapeInterval / 10),
+		pending:  n - 1,
+	}
+	sf.wg.Add(1)
+	sfs.m[key] = sf
+	sfs.mu.Unlock()
+
+	dstLen := len(dst)
+	dst, err := sw.ReadData(dst)
+	sf.data = append([]byte{}, dst[dstLen:]...)
+	sf.err = err
+	sf.wg.Done()
+	return dst, err
+}
+
+var sharedFetchesRequestsSaved = metrics.NewCounter(`vm_promscrape_dedup_identical_targets_requests_saved_total`)
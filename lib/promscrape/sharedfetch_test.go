@@ -0,0 +1,114 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapeWorkDedupIdenticalTargets(t *testing.T) {
+	defer func(v bool) {
+		*dedupIdenticalTargets = v
+	}(*dedupIdenticalTargets)
+	*dedupIdenticalTargets = true
+
+	var requests uint64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&requests, 1)
+		fmt.Fprintf(w, "foo 1\nbar 2\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+
+	// Both pools have identical final labels for the target, while they apply distinct metric_relabel_configs and limits.
+	data := fmt.Sprintf(`
+scrape_configs:
+- job_name: pool-a
+  sample_limit: 1
+  static_configs:
+  - targets: [%q]
+  relabel_configs:
+  - target_label: job
+    replacement: shared
+  metric_relabel_configs:
+  - target_label: pool
+    replacement: a
+- job_name: pool-b
+  static_configs:
+  - targets: [%q]
+  relabel_configs:
+  - target_label: job
+    replacement: shared
+  metric_relabel_configs:
+  - action: drop
+    source_labels: [__name__]
+    regex: bar
+  - target_label: pool
+    replacement: b
+`, u.Host, u.Host)
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+
+	var mu sync.Mutex
+	pushed := make(map[string][]string)
+	var wg sync.WaitGroup
+	for i := range sws {
+		sw := &sws[i]
+		pool := sw.jobNameOriginal
+		sc := newScraper(sw, "test", func(wr *prompbmarshal.WriteRequest) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ts := range wr.Timeseries {
+				if promrelabel.GetLabelValueByName(ts.Labels, "pool") == "" {
+					// Skip auto-generated series.
+					continue
+				}
+				pushed[pool] = append(pushed[pool], promLabelsString(ts.Labels))
+			}
+		})
+		sharedFetchesGlobal.register(&sc.sw)
+		defer sharedFetchesGlobal.unregister(&sc.sw)
+		defer tsmGlobal.Unregister(sw)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timestamp := time.Now().UnixNano() / 1e6
+			if err := sc.sw.scrapeInternal(timestamp, timestamp); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadUint64(&requests); n != 1 {
+		t.Fatalf("unexpected number of requests; got %d; want 1", n)
+	}
+	// The sample_limit from pool-a must drop all the samples only for pool-a.
+	pushedExpected := map[string][]string{
+		"pool-a": nil,
+		"pool-b": {fmt.Sprintf(`{__name__="foo",instance=%q,job="shared",pool="b"}`, u.Host)},
+	}
+	for pool, seriesExpected := range pushedExpected {
+		series := pushed[pool]
+		if fmt.Sprint(series) != fmt.Sprint(seriesExpected) {
+			t.Fatalf("unexpected series pushed for %s; got %s; want %s", pool, series, seriesExpected)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package promscrape
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SSHTunnelConfig contains settings for dialing scrape targets via SSH tunnel.
+//
+// A single SSH connection is shared among all the targets with the same SSHTunnelConfig.
+type SSHTunnelConfig struct {
+	// Host is the address of SSH server in the form `host:port`. Port 22 is used if it is missing.
+	Host    string `yaml:"host"`
+	User    string `yaml:"user"`
+	KeyFile string `yaml:"key_file"`
+	// KnownHostsFile is the path to file with public keys of SSH servers in OpenSSH `known_hosts` format.
+	// The host key of SSH server isn't verified if it isn't set.
+	KnownHostsFile string `yaml:"known_hosts_file,omitempty"`
+}
+
+// String returns string representation for stc, which is used in ScrapeWork.key.
+func (stc *SSHTunnelConfig) String() string {
+	if stc == nil {
+		return ""
+	}
+	return fmt.Sprintf("host=%s, user=%s, key_file=%s, known_hosts_file=%s", stc.Host, stc.User, stc.KeyFile, stc.KnownHostsFile)
+}
+
+func (stc *SSHTunnelConfig) validate() error {
+	if stc.Host == "" {
+		return fmt.Errorf("missing `host`")
+	}
+	if stc.User == "" {
+		return fmt.Errorf("missing `user`")
+	}
+	if stc.KeyFile == "" {
+		return fmt.Errorf("missing `key_file`")
+	}
+	return nil
+}
+
+// getSSHTunnelConfig returns a copy of stc with the default port and with file paths relative to baseDir.
+func getSSHTunnelConfig(stc *SSHTunnelConfig, baseDir string) *SSHTunnelConfig {
+	stcCopy := *stc
+	if !strings.Contains(stcCopy.Host, ":") {
+		stcCopy.Host += ":22"
+	}
+	stcCopy.KeyFile = getFilepath(baseDir, stcCopy.KeyFile)
+	if stcCopy.KnownHostsFile != "" {
+		stcCopy.KnownHostsFile = getFilepath(baseDir, stcCopy.KnownHostsFile)
+	}
+	return &stcCopy
+}
+
+// newSSHTunnelDialContextFunc returns a function for dialing addresses via the SSH tunnel for stc.
+func newSSHTunnelDialContextFunc(stc *SSHTunnelConfig) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return sshTunnelDial(ctx, stc, addr)
+	}
+}
+
+// sshTunnelDial dials addr via the SSH tunnel for stc. The tunnel is established on the first call and it is re-established on failures.
+//
+// It is set only when building with `ssh` build tag in order to keep golang.org/x/crypto/ssh out of the default build.
+var sshTunnelDial func(ctx context.Context, stc *SSHTunnelConfig, addr string) (net.Conn, error)
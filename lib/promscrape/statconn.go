@@ -14,11 +14,20 @@ import (
 )
 
 func statStdDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialAddr, host, err := resolveDialAddr(addr)
+	if err != nil {
+		dialsTotal.Inc()
+		dialErrors.Inc()
+		return nil, err
+	}
 	d := getStdDialer()
-	conn, err := d.DialContext(ctx, network, addr)
+	conn, err := d.DialContext(ctx, network, dialAddr)
 	dialsTotal.Inc()
 	if err != nil {
 		dialErrors.Inc()
+		if host != "" {
+			dnsCacheGlobal.invalidate(host)
+		}
 		return nil, err
 	}
 	conns.Inc()
@@ -45,14 +54,23 @@ var (
 )
 
 func statDial(addr string) (conn net.Conn, err error) {
+	dialAddr, host, err := resolveDialAddr(addr)
+	if err != nil {
+		dialsTotal.Inc()
+		dialErrors.Inc()
+		return nil, err
+	}
 	if netutil.TCP6Enabled() {
-		conn, err = fasthttp.DialDualStack(addr)
+		conn, err = fasthttp.DialDualStack(dialAddr)
 	} else {
-		conn, err = fasthttp.Dial(addr)
+		conn, err = fasthttp.Dial(dialAddr)
 	}
 	dialsTotal.Inc()
 	if err != nil {
 		dialErrors.Inc()
+		if host != "" {
+			dnsCacheGlobal.invalidate(host)
+		}
 		if !netutil.TCP6Enabled() {
 			err = fmt.Errorf("%w; try -enableTCP6 command-line flag", err)
 		}
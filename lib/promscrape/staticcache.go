@@ -0,0 +1,127 @@
+package promscrape
+
+import (
+	"sort"
+	"sync"
+
+	xxhash "github.com/cespare/xxhash/v2"
+	"gopkg.in/yaml.v2"
+)
+
+var staticScrapeWorkCacheGlobal staticScrapeWorkCache
+
+// staticScrapeWorkCache holds ScrapeWork obtained from `static_configs` during the last getStaticScrapeWork call.
+//
+// It allows re-using ScrapeWork for configs with big number of static targets on config reload
+// if `static_configs` and the options affecting them remain unchanged.
+type staticScrapeWorkCache struct {
+	mu  sync.Mutex
+	key uint64
+	sws []ScrapeWork
+
+	// dts contains targets dropped during relabeling of `static_configs`.
+	// They are registered in droppedTargetsMap on every cache hit, so they remain visible at /targets and /service-discovery pages.
+	dts []droppedTarget
+}
+
+func (ssc *staticScrapeWorkCache) get(key uint64) ([]ScrapeWork, bool) {
+	ssc.mu.Lock()
+	defer ssc.mu.Unlock()
+	if ssc.sws == nil || ssc.key != key {
+		return nil, false
+	}
+	for _, dt := range ssc.dts {
+		droppedTargetsMap.Register(dt.originalLabels, dt.reason)
+	}
+	return ssc.sws, true
+}
+
+func (ssc *staticScrapeWorkCache) set(key uint64, sws []ScrapeWork, dts []droppedTarget) {
+	ssc.mu.Lock()
+	ssc.key = key
+	ssc.sws = sws
+	ssc.dts = dts
+	ssc.mu.Unlock()
+}
+
+// getStaticScrapeWorkKey returns the key for staticScrapeWorkCache for all the enabled `static_configs` in cfg.
+//
+// false is returned if ScrapeWork for `static_configs` cannot be re-used.
+func (cfg *Config) getStaticScrapeWorkKey() (uint64, bool) {
+	d := xxhash.New()
+	var buf [8]byte
+	for i := range cfg.ScrapeConfigs {
+		sc := &cfg.ScrapeConfigs[i]
+		if !sc.swc.enabled || len(sc.StaticConfigs) == 0 {
+			continue
+		}
+		if !sc.swc.staticHashOK {
+			return 0, false
+		}
+		h := sc.swc.staticHash
+		for j := range buf {
+			buf[j] = byte(h >> (8 * j))
+		}
+		_, _ = d.Write(buf[:])
+	}
+	return d.Sum64(), true
+}
+
+// getStaticConfigsHash returns hash for `static_configs` from sc and for all the options affecting ScrapeWork obtained from these static configs.
+//
+// false is returned if the ScrapeWork cannot be re-used, e.g. if `relabel_configs` refer to `__resolved_ip__`,
+// since the resolved ip may change between config reloads. The same applies to `__target_index__`, since its lifetime is prolonged only on target discovery.
+func getStaticConfigsHash(sc *ScrapeConfig, swc *scrapeWorkConfig, globalCfg *GlobalConfig) (uint64, bool) {
+	if swc.needResolvedIP || swc.needTargetIndex {
+		return 0, false
+	}
+	scCopy := *sc
+	scCopy.StaticConfigs = nil
+	scData, err := yaml.Marshal(&scCopy)
+	if err != nil {
+		return 0, false
+	}
+	globalData, err := yaml.Marshal(globalCfg)
+	if err != nil {
+		return 0, false
+	}
+	d := xxhash.New()
+	writeString := func(s string) {
+		_, _ = d.WriteString(s)
+		_, _ = d.Write([]byte{0})
+	}
+	writeString(string(globalData))
+	writeString(string(scData))
+	writeString(swc.baseDir)
+	// The following options depend on the contents of external files, which isn't reflected in scData.
+	writeString(swc.authConfig.String())
+	profileNames := make([]string, 0, len(swc.authProfiles))
+	for name := range swc.authProfiles {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+	for _, name := range profileNames {
+		writeString(name)
+		writeString(swc.authProfiles[name].String())
+	}
+	writeString(swc.body)
+	writeString(string(swc.relabelFilesData))
+	for i := range sc.StaticConfigs {
+		stc := &sc.StaticConfigs[i]
+		writeString("targets")
+		for _, target := range stc.Targets {
+			writeString(target)
+		}
+		labelNames := make([]string, 0, len(stc.Labels))
+		for name := range stc.Labels {
+			labelNames = append(labelNames, name)
+		}
+		sort.Strings(labelNames)
+		writeString("labels")
+		for _, name := range labelNames {
+			writeString(name)
+			writeString(stc.Labels[name])
+		}
+	}
+	return d.Sum64(), true
+}
@@ -0,0 +1,161 @@
+package promscrape
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestStaticScrapeWorkCache(t *testing.T) {
+	getStaticScrapeWork := func(data string) []ScrapeWork {
+		t.Helper()
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		return cfg.getStaticScrapeWork()
+	}
+	data := `
+scrape_configs:
+- job_name: static_cache
+  static_configs:
+  - targets: ["foo", "bar"]
+    labels:
+      a: b
+      c: d
+`
+	sws := getStaticScrapeWork(data)
+	if len(sws) != 2 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+	}
+
+	// Unchanged static_configs must re-use the previously obtained ScrapeWork.
+	swsNew := getStaticScrapeWork(data)
+	if &swsNew[0] != &sws[0] {
+		t.Fatalf("expecting re-used ScrapeWork for unchanged static_configs")
+	}
+
+	f := func(dataChanged string) {
+		t.Helper()
+		swsPrev := getStaticScrapeWork(data)
+		swsChanged := getStaticScrapeWork(dataChanged)
+		if len(swsChanged) > 0 && &swsChanged[0] == &swsPrev[0] {
+			t.Fatalf("unexpected re-use of ScrapeWork for changed config\n%s", dataChanged)
+		}
+	}
+
+	// Changed target
+	f(`
+scrape_configs:
+- job_name: static_cache
+  static_configs:
+  - targets: ["foo", "baz"]
+    labels:
+      a: b
+      c: d
+`)
+
+	// Changed label
+	f(`
+scrape_configs:
+- job_name: static_cache
+  static_configs:
+  - targets: ["foo", "bar"]
+    labels:
+      a: b
+      c: e
+`)
+
+	// Changed job option
+	f(`
+scrape_configs:
+- job_name: static_cache
+  scrape_interval: 12s
+  static_configs:
+  - targets: ["foo", "bar"]
+    labels:
+      a: b
+      c: d
+`)
+
+	// Changed global option
+	f(`
+global:
+  external_labels:
+    x: y
+scrape_configs:
+- job_name: static_cache
+  static_configs:
+  - targets: ["foo", "bar"]
+    labels:
+      a: b
+      c: d
+`)
+
+	// ScrapeWork isn't re-used if relabeling depends on the resolved ip.
+	data = `
+scrape_configs:
+- job_name: static_cache_resolved_ip
+  relabel_configs:
+  - source_labels: [__resolved_ip__]
+    target_label: ip
+  static_configs:
+  - targets: ["foo"]
+`
+	sws = getStaticScrapeWork(data)
+	swsNew = getStaticScrapeWork(data)
+	if &swsNew[0] == &sws[0] {
+		t.Fatalf("unexpected re-use of ScrapeWork for relabeling with __resolved_ip__")
+	}
+}
+
+func TestStaticScrapeWorkCacheDroppedTargets(t *testing.T) {
+	const job = "static_cache_dropped"
+	data := `
+scrape_configs:
+- job_name: static_cache_dropped
+  relabel_configs:
+  - source_labels: [__address__]
+    regex: bar
+    action: drop
+  static_configs:
+  - targets: ["foo", "bar"]
+`
+	resetDroppedTargets := func() {
+		droppedTargetsMap.mu.Lock()
+		for k, v := range droppedTargetsMap.m {
+			if promrelabel.GetLabelValueByName(v.originalLabels, "job") == job {
+				delete(droppedTargetsMap.m, k)
+			}
+		}
+		droppedTargetsMap.mu.Unlock()
+	}
+	defer resetDroppedTargets()
+	f := func() []ScrapeWork {
+		t.Helper()
+		resetDroppedTargets()
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 1 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+		}
+		dts := droppedTargetsMap.getTargetsByPool()[job]
+		if len(dts) != 1 {
+			t.Fatalf("unexpected number of dropped targets; got %d; want 1", len(dts))
+		}
+		if address := promrelabel.GetLabelValueByName(dts[0].originalLabels, "__address__"); address != "bar" || dts[0].reason != "relabeling" {
+			t.Fatalf("unexpected dropped target; got address=%q, reason=%q; want address=%q, reason=%q", address, dts[0].reason, "bar", "relabeling")
+		}
+		return sws
+	}
+	sws := f()
+
+	// Dropped targets must be registered again when ScrapeWork is re-used for unchanged static_configs.
+	swsNew := f()
+	if &swsNew[0] != &sws[0] {
+		t.Fatalf("expecting re-used ScrapeWork for unchanged static_configs")
+	}
+}
@@ -0,0 +1,41 @@
+package promscrape
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func BenchmarkGetStaticScrapeWorkUnchanged(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString(`
+scrape_configs:
+- job_name: static_bench
+  static_configs:
+  - targets:
+`)
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&sb, "    - host-%d:9100\n", i)
+	}
+	data := sb.String()
+	parseConfig := func() *Config {
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			panic(fmt.Errorf("cannot parse data: %w", err))
+		}
+		return &cfg
+	}
+	if sws := parseConfig().getStaticScrapeWork(); len(sws) != 50000 {
+		panic(fmt.Errorf("unexpected number of scrape works; got %d; want 50000", len(sws)))
+	}
+
+	// Measure the reload of unchanged config.
+	cfg := parseConfig()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if sws := cfg.getStaticScrapeWork(); len(sws) != 50000 {
+			panic(fmt.Errorf("unexpected number of scrape works; got %d; want 50000", len(sws)))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package promscrape
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var targetChurnWarnRatio = flag.Float64("promscrape.targetChurnWarnRatio", 0, "Log a warning if the number of added and removed targets in a single service discovery update "+
+	"exceeds the given fraction of the number of targets for the given service discovery type, e.g. 0.5. Big churn usually means flapping service discovery. "+
+	"See also vm_promscrape_target_churn_total and vm_promscrape_recent_target_churn metrics. The warning is disabled by default")
+
+// targetChurnWindow is the duration for calculating `vm_promscrape_recent_target_churn` metric.
+const targetChurnWindow = 10 * 60
+
+// targetChurn tracks the number of added and removed targets for scraperGroup.
+type targetChurn struct {
+	name string
+
+	// total is incremented by the number of added and removed targets on every update.
+	total *metrics.Counter
+
+	mu sync.Mutex
+
+	// updates contains the number of added and removed targets per each update during the last targetChurnWindow.
+	updates []targetChurnUpdate
+}
+
+type targetChurnUpdate struct {
+	timestamp uint64
+	n         int
+}
+
+func newTargetChurn(name string) *targetChurn {
+	tc := &targetChurn{
+		name:  name,
+		total: metrics.NewCounter(fmt.Sprintf(`vm_promscrape_target_churn_total{type=%q}`, name)),
+	}
+	metrics.NewGauge(fmt.Sprintf(`vm_promscrape_recent_target_churn{type=%q}`, name), func() float64 {
+		return float64(tc.recent(fasttime.UnixTimestamp()))
+	})
+	return tc
+}
+
+// register registers the given number of added and removed targets in a single update.
+//
+// prevTargetsCount is the number of targets before the update. It is used for detecting big churn according to -promscrape.targetChurnWarnRatio.
+func (tc *targetChurn) register(currentTime uint64, additionsCount, deletionsCount, prevTargetsCount int) {
+	n := additionsCount + deletionsCount
+	if n == 0 {
+		return
+	}
+	tc.total.Add(n)
+	tc.mu.Lock()
+	tc.updates = append(tc.updates, targetChurnUpdate{
+		timestamp: currentTime,
+		n:         n,
+	})
+	tc.removeStaleUpdatesLocked(currentTime)
+	tc.mu.Unlock()
+
+	// Do not warn on the initial discovery, since all the targets are added in this case.
+	ratio := *targetChurnWarnRatio
+	if ratio <= 0 || prevTargetsCount == 0 || float64(n) <= ratio*float64(prevTargetsCount) {
+		return
+	}
+	logger.WithFields(
+		logger.Field{Key: "type", Value: tc.name},
+		logger.Field{Key: "added_targets", Value: additionsCount},
+		logger.Field{Key: "removed_targets", Value: deletionsCount},
+		logger.Field{Key: "previous_targets", Value: prevTargetsCount},
+	).Warnf("%s: too big target churn in a single service discovery update: added targets: %d, removed targets: %d, while the number of targets before the update was %d; "+
+		"this exceeds -promscrape.targetChurnWarnRatio=%g; make sure service discovery isn't flapping", tc.name, additionsCount, deletionsCount, prevTargetsCount, ratio)
+}
+
+// recent returns the number of added and removed targets during the last targetChurnWindow.
+func (tc *targetChurn) recent(currentTime uint64) int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.removeStaleUpdatesLocked(currentTime)
+	n := 0
+	for _, u := range tc.updates {
+		n += u.n
+	}
+	return n
+}
+
+func (tc *targetChurn) removeStaleUpdatesLocked(currentTime uint64) {
+	i := 0
+	for i < len(tc.updates) && tc.updates[i].timestamp+targetChurnWindow <= currentTime {
+		i++
+	}
+	tc.updates = append(tc.updates[:0], tc.updates[i:]...)
+}
@@ -0,0 +1,153 @@
+package promscrape
+
+import (
+	"flag"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+var targetIndexTTL = flag.Duration("promscrape.targetIndexTTL", time.Hour, "The duration for keeping `__target_index__` assigned to targets, which are no longer discovered. "+
+	"The index may be assigned to new targets in the same scrape pool after that")
+
+const (
+	// scrapePoolLabel is a read-only meta label containing `job_name` of the target.
+	scrapePoolLabel = "__scrape_pool__"
+
+	// targetIndexLabel is a read-only meta label containing the index of the target in its scrape pool.
+	//
+	// The index is assigned on the first discovery of the target and it remains the same while the target is discovered.
+	// It is set only if it is referred in `source_labels` of `relabel_configs`.
+	targetIndexLabel = "__target_index__"
+)
+
+// needRelabelLabel returns true if prcs refer to the label with the given name in `source_labels`.
+func needRelabelLabel(prcs []promrelabel.ParsedRelabelConfig, name string) bool {
+	for i := range prcs {
+		for _, label := range prcs[i].SourceLabels {
+			if label == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setReadOnlyLabel sets the label with the given name to value in labels, overriding the label with the same name from target labels.
+func setReadOnlyLabel(labels []prompbmarshal.Label, name, value string) []prompbmarshal.Label {
+	if label := promrelabel.GetLabelByName(labels, name); label != nil {
+		label.Value = value
+		return labels
+	}
+	return append(labels, prompbmarshal.Label{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// getTargetIndex returns the index for the target with the given labels obtained from service discovery in the given scrape pool.
+//
+// The index depends on `__address__` and other labels except of `__meta_*` labels, since `__meta_*` labels may contain volatile values
+// such as pod phase or container state, which change while the target is discovered.
+func getTargetIndex(pool string, labels []prompbmarshal.Label) string {
+	labelsCopy := make([]prompbmarshal.Label, 0, len(labels))
+	for _, label := range labels {
+		if strings.HasPrefix(label.Name, "__meta_") {
+			continue
+		}
+		labelsCopy = append(labelsCopy, label)
+	}
+	promrelabel.SortLabels(labelsCopy)
+	idx := targetIndexesGlobal.get(pool, promLabelsString(labelsCopy))
+	return strconv.Itoa(idx)
+}
+
+// targetIndexes assigns indexes to targets per each scrape pool.
+//
+// The index of the target is kept for -promscrape.targetIndexTTL after the last discovery of the target,
+// so it remains stable across service discovery updates. Released indexes are re-used starting from the smallest one.
+type targetIndexes struct {
+	mu    sync.Mutex
+	pools map[string]*poolTargetIndexes
+
+	lastCleanupTime uint64
+}
+
+type poolTargetIndexes struct {
+	m map[string]*targetIndexEntry
+
+	// free contains released indexes in ascending order.
+	free []int
+
+	// next is the next index to assign if free is empty.
+	next int
+}
+
+type targetIndexEntry struct {
+	idx      int
+	deadline uint64
+}
+
+var targetIndexesGlobal = &targetIndexes{
+	pools: make(map[string]*poolTargetIndexes),
+}
+
+func (tis *targetIndexes) get(pool, key string) int {
+	currentTime := fasttime.UnixTimestamp()
+	tis.mu.Lock()
+	defer tis.mu.Unlock()
+	pti := tis.pools[pool]
+	if pti == nil {
+		pti = &poolTargetIndexes{
+			m: make(map[string]*targetIndexEntry),
+		}
+		tis.pools[pool] = pti
+	}
+	e := pti.m[key]
+	if e == nil {
+		e = &targetIndexEntry{
+			idx: pti.allocIndex(),
+		}
+		pti.m[key] = e
+	}
+	e.deadline = currentTime + uint64(targetIndexTTL.Seconds())
+	if currentTime-tis.lastCleanupTime > 60 {
+		for name, pti := range tis.pools {
+			for k, e := range pti.m {
+				if currentTime >= e.deadline {
+					pti.releaseIndex(e.idx)
+					delete(pti.m, k)
+				}
+			}
+			if len(pti.m) == 0 {
+				delete(tis.pools, name)
+			}
+		}
+		tis.lastCleanupTime = currentTime
+	}
+	return e.idx
+}
+
+func (pti *poolTargetIndexes) allocIndex() int {
+	if len(pti.free) > 0 {
+		idx := pti.free[0]
+		pti.free = pti.free[1:]
+		return idx
+	}
+	idx := pti.next
+	pti.next++
+	return idx
+}
+
+func (pti *poolTargetIndexes) releaseIndex(idx int) {
+	n := sort.SearchInts(pti.free, idx)
+	pti.free = append(pti.free, 0)
+	copy(pti.free[n+1:], pti.free[n:])
+	pti.free[n] = idx
+}
@@ -0,0 +1,137 @@
+package promscrape
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+)
+
+func TestScrapePoolLabel(t *testing.T) {
+	data := `
+scrape_configs:
+- job_name: pool-keep
+  static_configs:
+  - targets: ["foo.bar:1234"]
+  relabel_configs: &keep
+  - source_labels: [__scrape_pool__]
+    regex: pool-keep
+    action: keep
+  - source_labels: [__scrape_pool__]
+    target_label: pool
+- job_name: pool-drop
+  static_configs:
+  - targets: ["foo.bar:5678"]
+  relabel_configs: *keep
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	sw := &sws[0]
+	if sw.ScrapeURL != "http://foo.bar:1234/metrics" {
+		t.Fatalf("unexpected target kept; got %q; want %q", sw.ScrapeURL, "http://foo.bar:1234/metrics")
+	}
+	if pool := promrelabel.GetLabelValueByName(sw.Labels, "pool"); pool != "pool-keep" {
+		t.Fatalf("unexpected pool label; got %q; want %q", pool, "pool-keep")
+	}
+	// Read-only labels are removed together with other `__*` labels when target labels are finalized.
+	if label := promrelabel.GetLabelByName(promrelabel.FinalizeLabels(nil, sw.Labels), scrapePoolLabel); label != nil {
+		t.Fatalf("unexpected %s label in target labels", scrapePoolLabel)
+	}
+}
+
+func TestTargetIndexLabel(t *testing.T) {
+	f := func(targets string, indexesExpected map[string]string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: target-index
+  static_configs:
+  - targets: %s
+  relabel_configs:
+  - source_labels: [__target_index__]
+    target_label: idx
+`, targets)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		indexes := make(map[string]string)
+		for _, sw := range cfg.getStaticScrapeWork() {
+			instance := promrelabel.GetLabelValueByName(sw.Labels, "instance")
+			indexes[instance] = promrelabel.GetLabelValueByName(sw.Labels, "idx")
+		}
+		if !reflect.DeepEqual(indexes, indexesExpected) {
+			t.Fatalf("unexpected target indexes; got %v; want %v", indexes, indexesExpected)
+		}
+	}
+
+	f(`[a:80, b:80, c:80]`, map[string]string{
+		"a:80": "0",
+		"b:80": "1",
+		"c:80": "2",
+	})
+
+	// Unchanged targets must keep their indexes after the refresh, while new targets get new indexes.
+	f(`[d:80, c:80, a:80]`, map[string]string{
+		"a:80": "0",
+		"c:80": "2",
+		"d:80": "3",
+	})
+}
+
+func TestTargetIndexVolatileMetaLabels(t *testing.T) {
+	f := func(phase, idxExpected string) {
+		t.Helper()
+		data := fmt.Sprintf(`
+scrape_configs:
+- job_name: target-index-meta
+  static_configs:
+  - targets: [a:80, b:80]
+    labels:
+      __meta_phase: %s
+  relabel_configs:
+  - source_labels: [__target_index__]
+    target_label: idx
+`, phase)
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		sws := cfg.getStaticScrapeWork()
+		if len(sws) != 2 {
+			t.Fatalf("unexpected number of scrape works; got %d; want 2", len(sws))
+		}
+		if idx := promrelabel.GetLabelValueByName(sws[1].Labels, "idx"); idx != idxExpected {
+			t.Fatalf("unexpected index for b:80 with __meta_phase=%q; got %q; want %q", phase, idx, idxExpected)
+		}
+	}
+
+	// The index must remain the same when `__meta_*` labels change.
+	f("Pending", "1")
+	f("Running", "1")
+}
+
+func TestTargetIndexesReuse(t *testing.T) {
+	var pti poolTargetIndexes
+	for i := 0; i < 5; i++ {
+		if idx := pti.allocIndex(); idx != i {
+			t.Fatalf("unexpected index; got %d; want %d", idx, i)
+		}
+	}
+	pti.releaseIndex(3)
+	pti.releaseIndex(1)
+	var idxs []int
+	for i := 0; i < 3; i++ {
+		idxs = append(idxs, pti.allocIndex())
+	}
+	if !reflect.DeepEqual(idxs, []int{1, 3, 5}) {
+		t.Fatalf("unexpected indexes after re-use; got %v; want [1 3 5]", idxs)
+	}
+}
@@ -0,0 +1,207 @@
+package promscrape
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promrelabel"
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+// targetLimiterGlobal applies `target_limit` to the targets discovered by all the service discovery types running in scrapers.
+var targetLimiterGlobal = newTargetLimiter()
+
+// targetLimitWarnInterval is the minimum interval between warnings about dropped targets for the same job and service discovery type.
+const targetLimitWarnInterval = 60
+
+// targetLimiter applies `target_limit` to all the targets of a job discovered by distinct service discovery types.
+//
+// Service discovery types are refreshed independently, so targetLimiter remembers the latest discovered targets per each type.
+type targetLimiter struct {
+	mu sync.Mutex
+
+	// m contains the state per job name for jobs with `target_limit`.
+	m map[string]*targetLimitJob
+}
+
+type targetLimitJob struct {
+	// candidates contains the latest discovered targets per service discovery type. Candidates have nil sw.
+	candidates map[string][]targetLimitCandidate
+
+	// lastWarnTimes contains unix timestamps for the last warning about dropped targets per service discovery type.
+	lastWarnTimes map[string]uint64
+}
+
+func newTargetLimiter() *targetLimiter {
+	return &targetLimiter{
+		m: make(map[string]*targetLimitJob),
+	}
+}
+
+// register registers sws discovered by sdType for jobs with `target_limit` in cfg without dropping targets.
+//
+// This allows taking into account targets discovered by sdType when apply is called for other service discovery types.
+func (tl *targetLimiter) register(cfg *Config, sdType string, sws []ScrapeWork) {
+	limits := getTargetLimits(cfg)
+	tl.mu.Lock()
+	tl.registerLocked(limits, sdType, sws)
+	tl.mu.Unlock()
+}
+
+// apply drops targets exceeding `target_limit` for jobs in cfg from sws discovered by sdType and returns the remaining targets.
+//
+// The limit is applied per job to the targets discovered by all the service discovery types,
+// so it takes into account the latest targets passed to apply for other service discovery types.
+// Retained targets are selected in a stable manner, so the same targets are kept across service discovery refreshes
+// until the set of discovered targets changes. Targets with the biggest `target_limit_priority_label` value are kept.
+// Targets without the priority label or with non-numeric label value have the lowest priority.
+// Ties are resolved by the hash of target labels.
+//
+// sws isn't modified, since it may be shared with other callers.
+func (tl *targetLimiter) apply(cfg *Config, sdType string, sws []ScrapeWork) []ScrapeWork {
+	limits := getTargetLimits(cfg)
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	candidatesByJob := tl.registerLocked(limits, sdType, sws)
+	if len(limits) == 0 {
+		return sws
+	}
+	dropped := make(map[*ScrapeWork]bool)
+	for jobName, swc := range limits {
+		tlj := tl.m[jobName]
+		candidates := candidatesByJob[jobName]
+		all := candidates
+		for name, cs := range tlj.candidates {
+			if name != sdType {
+				all = append(all, cs...)
+			}
+		}
+		if len(all) <= swc.targetLimit {
+			continue
+		}
+		sort.Slice(all, func(i, j int) bool {
+			return all[i].less(&all[j])
+		})
+		droppedLocal := 0
+		for _, c := range all[swc.targetLimit:] {
+			if c.sw == nil {
+				// The target is discovered by other service discovery type.
+				continue
+			}
+			dropped[c.sw] = true
+			droppedTargetsMap.Register(c.sw.OriginalLabels, "target_limit")
+			droppedLocal++
+		}
+		if droppedLocal == 0 {
+			continue
+		}
+		ct := fasttime.UnixTimestamp()
+		if ct < tlj.lastWarnTimes[sdType]+targetLimitWarnInterval {
+			continue
+		}
+		tlj.lastWarnTimes[sdType] = ct
+		logger.Warnf("job_name %q: dropping %d out of %d targets discovered by %s, since the total number of targets discovered for the job is %d, "+
+			"which exceeds `target_limit: %d`", jobName, droppedLocal, len(candidates), sdType, len(all), swc.targetLimit)
+	}
+	if len(dropped) == 0 {
+		return sws
+	}
+	dst := make([]ScrapeWork, 0, len(sws)-len(dropped))
+	for i := range sws {
+		if !dropped[&sws[i]] {
+			dst = append(dst, sws[i])
+		}
+	}
+	return dst
+}
+
+// registerLocked remembers sws discovered by sdType for jobs from limits and returns candidates with non-nil sw for these jobs.
+//
+// The state for jobs missing in limits is dropped.
+func (tl *targetLimiter) registerLocked(limits map[string]*scrapeWorkConfig, sdType string, sws []ScrapeWork) map[string][]targetLimitCandidate {
+	for jobName := range tl.m {
+		if limits[jobName] == nil {
+			delete(tl.m, jobName)
+		}
+	}
+	candidatesByJob := make(map[string][]targetLimitCandidate)
+	for i := range sws {
+		sw := &sws[i]
+		swc := limits[sw.jobNameOriginal]
+		if swc == nil {
+			continue
+		}
+		candidatesByJob[sw.jobNameOriginal] = append(candidatesByJob[sw.jobNameOriginal], newTargetLimitCandidate(sw, swc.targetPriorityLabel))
+	}
+	for jobName := range limits {
+		tlj := tl.m[jobName]
+		if tlj == nil {
+			tlj = &targetLimitJob{
+				candidates:    make(map[string][]targetLimitCandidate),
+				lastWarnTimes: make(map[string]uint64),
+			}
+			tl.m[jobName] = tlj
+		}
+		candidates := candidatesByJob[jobName]
+		stored := make([]targetLimitCandidate, len(candidates))
+		for i, c := range candidates {
+			// Do not hold references to sws, since they may be large.
+			c.sw = nil
+			stored[i] = c
+		}
+		tlj.candidates[sdType] = stored
+	}
+	return candidatesByJob
+}
+
+func getTargetLimits(cfg *Config) map[string]*scrapeWorkConfig {
+	limits := make(map[string]*scrapeWorkConfig)
+	for i := range cfg.ScrapeConfigs {
+		swc := cfg.ScrapeConfigs[i].swc
+		if swc != nil && swc.targetLimit > 0 {
+			limits[swc.jobName] = swc
+		}
+	}
+	return limits
+}
+
+// targetLimitCandidate is a target, which competes for a slot under `target_limit`.
+type targetLimitCandidate struct {
+	sw          *ScrapeWork
+	hasPriority bool
+	priority    float64
+	hash        uint64
+}
+
+func newTargetLimitCandidate(sw *ScrapeWork, priorityLabel string) targetLimitCandidate {
+	c := targetLimitCandidate{
+		sw:   sw,
+		hash: xxhash.Sum64([]byte(sw.LabelsString())),
+	}
+	if priorityLabel != "" {
+		if v := promrelabel.GetLabelValueByName(sw.Labels, priorityLabel); v != "" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) {
+				c.hasPriority = true
+				c.priority = f
+			}
+		}
+	}
+	return c
+}
+
+// less returns true if c must be retained in favor of x.
+func (c *targetLimitCandidate) less(x *targetLimitCandidate) bool {
+	if c.hasPriority != x.hasPriority {
+		return c.hasPriority
+	}
+	if c.priority != x.priority {
+		return c.priority > x.priority
+	}
+	return c.hash < x.hash
+}
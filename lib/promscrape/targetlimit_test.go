@@ -0,0 +1,159 @@
+package promscrape
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestApplyTargetLimits(t *testing.T) {
+	getTargets := func(data string) []string {
+		t.Helper()
+		var cfg Config
+		if err := cfg.parse([]byte(data), "sss"); err != nil {
+			t.Fatalf("cannot parse data: %s", err)
+		}
+		tl := newTargetLimiter()
+		sws := tl.apply(&cfg, "static_configs", cfg.getStaticScrapeWork())
+		var targets []string
+		for i := range sws {
+			targets = append(targets, sws[i].ScrapeURL)
+		}
+		sort.Strings(targets)
+		return targets
+	}
+
+	// The same targets must be retained regardless of the order of discovered targets.
+	targets := getTargets(`
+scrape_configs:
+- job_name: foo
+  target_limit: 3
+  static_configs:
+  - targets: ["a1", "a2", "a3", "a4", "a5", "a6"]
+- job_name: bar
+  static_configs:
+  - targets: ["b1", "b2"]
+`)
+	if len(targets) != 5 {
+		t.Fatalf("unexpected number of targets; got %d; want 5; targets: %q", len(targets), targets)
+	}
+	targetsShuffled := getTargets(`
+scrape_configs:
+- job_name: foo
+  target_limit: 3
+  static_configs:
+  - targets: ["a6", "a3"]
+  - targets: ["a5", "a1", "a4", "a2"]
+- job_name: bar
+  static_configs:
+  - targets: ["b2", "b1"]
+`)
+	if !reflect.DeepEqual(targets, targetsShuffled) {
+		t.Fatalf("unexpected targets after refresh;\ngot\n%q\nwant\n%q", targetsShuffled, targets)
+	}
+
+	// Targets with the biggest priority must be retained. Targets without valid priority have the lowest priority.
+	targets = getTargets(`
+scrape_configs:
+- job_name: foo
+  target_limit: 2
+  target_limit_priority_label: priority
+  static_configs:
+  - targets: ["a1"]
+    labels:
+      priority: "10"
+  - targets: ["a2"]
+    labels:
+      priority: "-1"
+  - targets: ["a3"]
+    labels:
+      priority: "foo"
+  - targets: ["a4"]
+  - targets: ["a5"]
+    labels:
+      priority: "2.5"
+`)
+	targetsExpected := []string{"http://a1:80/metrics", "http://a5:80/metrics"}
+	if !reflect.DeepEqual(targets, targetsExpected) {
+		t.Fatalf("unexpected targets;\ngot\n%q\nwant\n%q", targets, targetsExpected)
+	}
+
+	// Targets under the limit must be kept as is.
+	targets = getTargets(`
+scrape_configs:
+- job_name: foo
+  target_limit: 2
+  static_configs:
+  - targets: ["a1", "a2"]
+`)
+	targetsExpected = []string{"http://a1:80/metrics", "http://a2:80/metrics"}
+	if !reflect.DeepEqual(targets, targetsExpected) {
+		t.Fatalf("unexpected targets;\ngot\n%q\nwant\n%q", targets, targetsExpected)
+	}
+}
+
+func TestApplyTargetLimitsMultipleDiscoveryTypes(t *testing.T) {
+	data := `
+scrape_configs:
+- job_name: foo
+  target_limit: 3
+  static_configs:
+  - targets: ["a1", "a2", "a3", "a4", "a5", "a6"]
+`
+	var cfg Config
+	if err := cfg.parse([]byte(data), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	sws := cfg.getStaticScrapeWork()
+	getTargets := func(sws []ScrapeWork) []string {
+		var targets []string
+		for i := range sws {
+			targets = append(targets, sws[i].ScrapeURL)
+		}
+		return targets
+	}
+	tl := newTargetLimiter()
+	targetsExpected := getTargets(tl.apply(&cfg, "static_configs", sws))
+	sort.Strings(targetsExpected)
+
+	// Split targets between two service discovery types. The limit must be applied to all the targets of the job.
+	tl = newTargetLimiter()
+	tl.apply(&cfg, "static_configs", sws[:3])
+	targets := getTargets(tl.apply(&cfg, "file_sd_configs", sws[3:]))
+	targets = append(targets, getTargets(tl.apply(&cfg, "static_configs", sws[:3]))...)
+	sort.Strings(targets)
+	if !reflect.DeepEqual(targets, targetsExpected) {
+		t.Fatalf("unexpected targets;\ngot\n%q\nwant\n%q", targets, targetsExpected)
+	}
+
+	// Targets discovered by the other type must be taken into account after register call.
+	tl = newTargetLimiter()
+	tl.register(&cfg, "file_sd_configs", sws[3:])
+	targets = getTargets(tl.apply(&cfg, "static_configs", sws[:3]))
+	targets = append(targets, getTargets(tl.apply(&cfg, "file_sd_configs", sws[3:]))...)
+	sort.Strings(targets)
+	if !reflect.DeepEqual(targets, targetsExpected) {
+		t.Fatalf("unexpected targets after register;\ngot\n%q\nwant\n%q", targets, targetsExpected)
+	}
+
+	// All the targets must be returned after the other type stops discovering targets.
+	targets = getTargets(tl.apply(&cfg, "file_sd_configs", nil))
+	if len(targets) != 0 {
+		t.Fatalf("unexpected targets for file_sd_configs: %q", targets)
+	}
+	targets = getTargets(tl.apply(&cfg, "static_configs", sws[:3]))
+	if len(targets) != 3 {
+		t.Fatalf("unexpected number of targets for static_configs; got %d; want 3; targets: %q", len(targets), targets)
+	}
+
+	// The state must be dropped for jobs without target_limit.
+	var cfgNoLimit Config
+	if err := cfgNoLimit.parse([]byte(strings.Replace(data, "target_limit: 3", "", 1)), "sss"); err != nil {
+		t.Fatalf("cannot parse data: %s", err)
+	}
+	tl.apply(&cfgNoLimit, "static_configs", sws)
+	if len(tl.m) != 0 {
+		t.Fatalf("unexpected non-empty state for jobs without target_limit: %v", tl.m)
+	}
+}
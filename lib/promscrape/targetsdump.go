@@ -0,0 +1,51 @@
+package promscrape
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var (
+	targetsDumpFile = flag.String("promscrape.targetsDumpFile", "", "Optional path to file for periodic dumping of active and dropped scrape targets in JSON format. "+
+		"The file has the same format as /api/v1/targets response and it is updated atomically every -promscrape.targetsDumpInterval")
+	targetsDumpInterval = flag.Duration("promscrape.targetsDumpInterval", time.Minute, "The interval for dumping scrape targets to -promscrape.targetsDumpFile")
+)
+
+func runTargetsDumper(path string, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(*targetsDumpInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := dumpTargets(path); err != nil {
+				logger.Errorf("cannot dump scrape targets to -promscrape.targetsDumpFile=%q: %s", path, err)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// dumpTargets writes active and dropped targets to the file at path in /api/v1/targets format.
+//
+// The file is replaced atomically, so readers never see partially written contents.
+func dumpTargets(path string) error {
+	var bb bytes.Buffer
+	WriteAPIV1Targets(&bb, "any")
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, bb.Bytes(), 0644); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("cannot write %d bytes to %q: %w", bb.Len(), tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("cannot move %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
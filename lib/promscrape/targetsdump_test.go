@@ -0,0 +1,102 @@
+package promscrape
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestDumpTargets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "targets-dump")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "targets.json")
+
+	sw := &ScrapeWork{
+		ID:        atomic.AddUint64(&nextScrapeWorkID, 1),
+		ScrapeURL: "http://dump-active:1234/metrics",
+		Labels: []prompbmarshal.Label{
+			{
+				Name:  "instance",
+				Value: "dump-active:1234",
+			},
+			{
+				Name:  "job",
+				Value: "test_dump_targets",
+			},
+		},
+	}
+	tsmGlobal.Register(sw)
+	defer tsmGlobal.Unregister(sw)
+
+	f := func(droppedAddress string) {
+		t.Helper()
+		droppedTargetsMap.Register([]prompbmarshal.Label{
+			{
+				Name:  "__address__",
+				Value: droppedAddress,
+			},
+			{
+				Name:  "job",
+				Value: "test_dump_targets",
+			},
+		}, "relabeling")
+		if err := dumpTargets(path); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Fatalf("temporary file must be removed after the dump; stat error: %v", err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("cannot read dump file: %s", err)
+		}
+		var resp struct {
+			Status string
+			Data   struct {
+				ActiveTargets []struct {
+					ScrapeURL string `json:"scrapeUrl"`
+				}
+				DroppedTargets []struct {
+					DiscoveredLabels map[string]string
+					DropReason       string
+				}
+			}
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			t.Fatalf("cannot parse dump file: %s; contents:\n%s", err, data)
+		}
+		if resp.Status != "success" {
+			t.Fatalf("unexpected status; got %q; want %q", resp.Status, "success")
+		}
+		foundActive := false
+		for _, at := range resp.Data.ActiveTargets {
+			if at.ScrapeURL == sw.ScrapeURL {
+				foundActive = true
+			}
+		}
+		if !foundActive {
+			t.Fatalf("missing active target %q in the dump:\n%s", sw.ScrapeURL, data)
+		}
+		foundDropped := false
+		for _, dt := range resp.Data.DroppedTargets {
+			if dt.DiscoveredLabels["__address__"] == droppedAddress && dt.DropReason == "relabeling" {
+				foundDropped = true
+			}
+		}
+		if !foundDropped {
+			t.Fatalf("missing dropped target %q in the dump:\n%s", droppedAddress, data)
+		}
+	}
+	f("dump-dropped-1:1234")
+
+	// The existing dump file must be replaced.
+	f("dump-dropped-2:1234")
+}
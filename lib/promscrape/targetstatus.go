@@ -44,20 +44,134 @@ func WriteAPIV1Targets(w io.Writer, state string) {
 	fmt.Fprintf(w, `}}`)
 }
 
+// WriteScrapePoolsResponse writes /api/v1/scrape_pools to w according to https://prometheus.io/docs/prometheus/latest/querying/api/#scrape-pools
+//
+// Scrape pools are `job_name` values for all the enabled scrape configs from the active `-promscrape.config`.
+// An empty list is written if scraper isn't running.
+func WriteScrapePoolsResponse(w io.Writer) {
+	activeConfigLock.Lock()
+	cfg := activeConfig
+	activeConfigLock.Unlock()
+	var names []string
+	if cfg != nil {
+		names = cfg.getScrapePoolNames()
+	}
+	fmt.Fprintf(w, `{"status":"success","data":{"scrapePools":[`)
+	for i, name := range names {
+		fmt.Fprintf(w, "%q", name)
+		if i+1 < len(names) {
+			fmt.Fprintf(w, `,`)
+		}
+	}
+	fmt.Fprintf(w, `]}}`)
+}
+
+// WriteServiceDiscoveryResponse writes discovered targets per each scrape pool to w in JSON.
+//
+// Every active target contains `discoveredLabels` obtained from service discovery before relabeling and the resulting `labels`,
+// while every dropped target contains `discoveredLabels` and `dropReason`. Scrape pools are `job_name` values from the active `-promscrape.config`.
+// Up to -promscrape.maxDroppedTargets dropped targets are written. `discoveredLabels` are empty if -promscrape.dropOriginalLabels is set.
+func WriteServiceDiscoveryResponse(w io.Writer) {
+	activeConfigLock.Lock()
+	cfg := activeConfig
+	activeConfigLock.Unlock()
+	var names []string
+	if cfg != nil {
+		names = cfg.getScrapePoolNames()
+	}
+	activeByPool := tsmGlobal.getTargetsByPool()
+	droppedByPool := droppedTargetsMap.getTargetsByPool()
+	fmt.Fprintf(w, `{"status":"success","data":{"scrapePools":[`)
+	for i, name := range names {
+		fmt.Fprintf(w, `{"scrapePool":%q,"activeTargets":[`, name)
+		sws := activeByPool[name]
+		for j, sw := range sws {
+			fmt.Fprintf(w, `{"discoveredLabels":`)
+			writeLabelsJSON(w, sw.OriginalLabels)
+			fmt.Fprintf(w, `,"labels":`)
+			writeLabelsJSON(w, promrelabel.FinalizeLabels(nil, sw.Labels))
+			fmt.Fprintf(w, `}`)
+			if j+1 < len(sws) {
+				fmt.Fprintf(w, `,`)
+			}
+		}
+		fmt.Fprintf(w, `],"droppedTargets":[`)
+		dts := droppedByPool[name]
+		for j, dt := range dts {
+			fmt.Fprintf(w, `{"discoveredLabels":`)
+			writeLabelsJSON(w, dt.originalLabels)
+			fmt.Fprintf(w, `,"dropReason":%q}`, dt.reason)
+			if j+1 < len(dts) {
+				fmt.Fprintf(w, `,`)
+			}
+		}
+		fmt.Fprintf(w, `]}`)
+		if i+1 < len(names) {
+			fmt.Fprintf(w, `,`)
+		}
+	}
+	fmt.Fprintf(w, `]}}`)
+}
+
+// TargetStatus contains the current status for a single scrape target.
+type TargetStatus struct {
+	// ScrapeURL is the url for scraping the target.
+	ScrapeURL string
+
+	// Labels contains the final labels for the target.
+	Labels []prompbmarshal.Label
+
+	// Up is set to true if the last scrape was successful.
+	Up bool
+
+	// LastError contains the error for the last scrape if it has been failed.
+	LastError error
+
+	// LastScrapeTime is the time of the last scrape. It is zero if the target wasn't scraped yet.
+	LastScrapeTime time.Time
+
+	// LastScrapeDuration is the duration of the last scrape.
+	LastScrapeDuration time.Duration
+
+	// CircuitBreakerState contains the circuit breaker state for the target - closed, open or half-open.
+	// It is empty if the circuit breaker is disabled for the target.
+	CircuitBreakerState string
+}
+
+// TargetStatusByLabels returns the current status for the target with the given final labels.
+//
+// The labels are matched against the labels used in the internal target key, i.e. without labels starting with `__`.
+// false is returned if there is no target with the given labels or if multiple targets with distinct scrape settings have the given labels.
+func TargetStatusByLabels(labels []prompbmarshal.Label) (TargetStatus, bool) {
+	return tsmGlobal.StatusByLabels(labels)
+}
+
 type targetStatusMap struct {
 	mu sync.Mutex
 	m  map[uint64]targetStatus
+
+	// duplicates contains the number of targets skipped because of duplicate labels per each job per each scrape group.
+	duplicates map[string]map[string]int
 }
 
 func newTargetStatusMap() *targetStatusMap {
 	return &targetStatusMap{
-		m: make(map[uint64]targetStatus),
+		m:          make(map[uint64]targetStatus),
+		duplicates: make(map[string]map[string]int),
 	}
 }
 
 func (tsm *targetStatusMap) Reset() {
 	tsm.mu.Lock()
 	tsm.m = make(map[uint64]targetStatus)
+	tsm.duplicates = make(map[string]map[string]int)
+	tsm.mu.Unlock()
+}
+
+// SetDuplicates sets the number of duplicate targets per each job for the given scrape group.
+func (tsm *targetStatusMap) SetDuplicates(group string, duplicatesByJob map[string]int) {
+	tsm.mu.Lock()
+	tsm.duplicates[group] = duplicatesByJob
 	tsm.mu.Unlock()
 }
 
@@ -75,19 +189,74 @@ func (tsm *targetStatusMap) Unregister(sw *ScrapeWork) {
 	tsm.mu.Unlock()
 }
 
-func (tsm *targetStatusMap) Update(sw *ScrapeWork, group string, up bool, scrapeTime, scrapeDuration int64, err error) {
+func (tsm *targetStatusMap) Update(sw *ScrapeWork, group string, up bool, scrapeTime, scrapeDuration int64, samplesScraped int, err error, circuitBreakerState string,
+	topMetricNames []metricNameSeries) {
 	tsm.mu.Lock()
 	tsm.m[sw.ID] = targetStatus{
-		sw:             *sw,
-		up:             up,
-		scrapeGroup:    group,
-		scrapeTime:     scrapeTime,
-		scrapeDuration: scrapeDuration,
-		err:            err,
+		sw:                  *sw,
+		up:                  up,
+		scrapeGroup:         group,
+		scrapeTime:          scrapeTime,
+		scrapeDuration:      scrapeDuration,
+		samplesScraped:      samplesScraped,
+		err:                 err,
+		circuitBreakerState: circuitBreakerState,
+		topMetricNames:      topMetricNames,
 	}
 	tsm.mu.Unlock()
 }
 
+// StatusByLabels returns the status for the target with the given labels.
+//
+// Targets are identified by ScrapeWork.key(), so multiple ScrapeWork entries with the same key are treated as a single target.
+// false is returned if targets with distinct keys have the given labels, since it is impossible to determine the needed target then.
+func (tsm *targetStatusMap) StatusByLabels(labels []prompbmarshal.Label) (TargetStatus, bool) {
+	labelsSorted := append([]prompbmarshal.Label{}, labels...)
+	promrelabel.SortLabels(labelsSorted)
+	labelsStr := promLabelsString(promrelabel.FinalizeLabels(nil, labelsSorted))
+
+	tsm.mu.Lock()
+	var stFound *targetStatus
+	keyFound := ""
+	for id := range tsm.m {
+		st := tsm.m[id]
+		if st.sw.LabelsString() != labelsStr {
+			continue
+		}
+		key := st.sw.key()
+		if stFound != nil && key != keyFound {
+			// Targets with the same labels and distinct scrape settings collide.
+			tsm.mu.Unlock()
+			return TargetStatus{}, false
+		}
+		// Prefer the target with the smallest ID in order to return consistent results for duplicate targets.
+		if stFound == nil || st.sw.ID < stFound.sw.ID {
+			stFound = &st
+			keyFound = key
+		}
+	}
+	tsm.mu.Unlock()
+
+	if stFound == nil {
+		return TargetStatus{}, false
+	}
+	var lastScrapeTime time.Time
+	if stFound.scrapeTime > 0 {
+		lastScrapeTime = time.Unix(stFound.scrapeTime/1000, (stFound.scrapeTime%1000)*1e6)
+	}
+	ts := TargetStatus{
+		ScrapeURL:          stFound.sw.ScrapeURL,
+		Labels:             promrelabel.FinalizeLabels(nil, stFound.sw.Labels),
+		Up:                 stFound.up,
+		LastError:          stFound.err,
+		LastScrapeTime:     lastScrapeTime,
+		LastScrapeDuration: time.Duration(stFound.scrapeDuration) * time.Millisecond,
+
+		CircuitBreakerState: stFound.circuitBreakerState,
+	}
+	return ts, true
+}
+
 // StatusByGroup returns the number of targets with status==up
 // for the given group name
 func (tsm *targetStatusMap) StatusByGroup(group string, up bool) int {
@@ -143,7 +312,15 @@ func (tsm *targetStatusMap) WriteActiveTargetsJSON(w io.Writer) {
 		if !st.up {
 			state = "down"
 		}
-		fmt.Fprintf(w, `,"health":%q}`, state)
+		fmt.Fprintf(w, `,"health":%q`, state)
+		if st.circuitBreakerState != "" {
+			fmt.Fprintf(w, `,"circuitBreakerState":%q`, st.circuitBreakerState)
+		}
+		if st.sw.CardinalityTopN > 0 {
+			fmt.Fprintf(w, `,"topMetricNames":`)
+			writeTopMetricNamesJSON(w, st.topMetricNames)
+		}
+		fmt.Fprintf(w, `}`)
 		if i+1 < len(kss) {
 			fmt.Fprintf(w, `,`)
 		}
@@ -151,6 +328,23 @@ func (tsm *targetStatusMap) WriteActiveTargetsJSON(w io.Writer) {
 	fmt.Fprintf(w, `]`)
 }
 
+// getTargetsByPool returns the registered targets per each scrape pool sorted by discovered labels.
+func (tsm *targetStatusMap) getTargetsByPool() map[string][]ScrapeWork {
+	m := make(map[string][]ScrapeWork)
+	tsm.mu.Lock()
+	for _, st := range tsm.m {
+		pool := st.sw.jobNameOriginal
+		m[pool] = append(m[pool], st.sw)
+	}
+	tsm.mu.Unlock()
+	for _, sws := range m {
+		sort.Slice(sws, func(i, j int) bool {
+			return promLabelsString(sws[i].OriginalLabels) < promLabelsString(sws[j].OriginalLabels)
+		})
+	}
+	return m
+}
+
 func writeLabelsJSON(w io.Writer, labels []prompbmarshal.Label) {
 	fmt.Fprintf(w, `{`)
 	for i, label := range labels {
@@ -169,6 +363,12 @@ func (tsm *targetStatusMap) WriteHumanReadable(w io.Writer, showOriginalLabels b
 		job := st.sw.Job()
 		byJob[job] = append(byJob[job], st)
 	}
+	duplicatesByJob := make(map[string]int)
+	for _, m := range tsm.duplicates {
+		for job, n := range m {
+			duplicatesByJob[job] += n
+		}
+	}
 	tsm.mu.Unlock()
 
 	var jss []jobStatus
@@ -193,7 +393,11 @@ func (tsm *targetStatusMap) WriteHumanReadable(w io.Writer, showOriginalLabels b
 				ups++
 			}
 		}
-		fmt.Fprintf(w, "job=%q (%d/%d up)\n", js.job, ups, len(sts))
+		if n := duplicatesByJob[js.job]; n > 0 {
+			fmt.Fprintf(w, "job=%q (%d/%d up, %d duplicates skipped)\n", js.job, ups, len(sts), n)
+		} else {
+			fmt.Fprintf(w, "job=%q (%d/%d up)\n", js.job, ups, len(sts))
+		}
 		for _, st := range sts {
 			state := "up"
 			if !st.up {
@@ -208,8 +412,12 @@ func (tsm *targetStatusMap) WriteHumanReadable(w io.Writer, showOriginalLabels b
 			if st.err != nil {
 				errMsg = st.err.Error()
 			}
-			fmt.Fprintf(w, "\tstate=%s, endpoint=%s, labels=%s, last_scrape=%.3fs ago, scrape_duration=%.3fs, error=%q\n",
-				state, st.sw.ScrapeURL, labelsStr, lastScrape.Seconds(), float64(st.scrapeDuration)/1000, errMsg)
+			circuitBreaker := ""
+			if st.circuitBreakerState != "" {
+				circuitBreaker = ", circuit_breaker=" + st.circuitBreakerState
+			}
+			fmt.Fprintf(w, "\tstate=%s, endpoint=%s, labels=%s, last_scrape=%.3fs ago, scrape_duration=%.3fs, samples_scraped=%d%s, error=%q\n",
+				state, st.sw.ScrapeURL, labelsStr, lastScrape.Seconds(), float64(st.scrapeDuration)/1000, st.samplesScraped, circuitBreaker, errMsg)
 		}
 	}
 	fmt.Fprintf(w, "\n")
@@ -226,7 +434,14 @@ type targetStatus struct {
 	scrapeGroup    string
 	scrapeTime     int64
 	scrapeDuration int64
+	samplesScraped int
 	err            error
+
+	// circuitBreakerState is the state of the target circuit breaker. It is empty if the circuit breaker is disabled.
+	circuitBreakerState string
+
+	// topMetricNames contains metric names with the biggest number of series during the last scrape if ScrapeWork.CardinalityTopN is set.
+	topMetricNames []metricNameSeries
 }
 
 func (st *targetStatus) getDurationFromLastScrape() time.Duration {
@@ -241,20 +456,23 @@ type droppedTargets struct {
 
 type droppedTarget struct {
 	originalLabels []prompbmarshal.Label
+	reason         string
 	deadline       uint64
 }
 
-func (dt *droppedTargets) Register(originalLabels []prompbmarshal.Label) {
-
+// Register registers the target with the given originalLabels as dropped because of the given reason.
+func (dt *droppedTargets) Register(originalLabels []prompbmarshal.Label, reason string) {
 	key := promLabelsString(originalLabels)
 	currentTime := fasttime.UnixTimestamp()
 	dt.mu.Lock()
 	if k, ok := dt.m[key]; ok {
+		k.reason = reason
 		k.deadline = currentTime + 10*60
 		dt.m[key] = k
 	} else if len(dt.m) < *maxDroppedTargets {
 		dt.m[key] = droppedTarget{
 			originalLabels: originalLabels,
+			reason:         reason,
 			deadline:       currentTime + 10*60,
 		}
 	}
@@ -269,12 +487,26 @@ func (dt *droppedTargets) Register(originalLabels []prompbmarshal.Label) {
 	dt.mu.Unlock()
 }
 
+// registerDroppedTarget registers the target with the given originalLabels as dropped because of the given reason.
+//
+// The target is also appended to dts if it isn't nil, so it could be registered again without re-applying relabeling.
+func registerDroppedTarget(dts *[]droppedTarget, originalLabels []prompbmarshal.Label, reason string) {
+	droppedTargetsMap.Register(originalLabels, reason)
+	if dts != nil {
+		*dts = append(*dts, droppedTarget{
+			originalLabels: originalLabels,
+			reason:         reason,
+		})
+	}
+}
+
 // WriteDroppedTargetsJSON writes `droppedTargets` contents to w according to https://prometheus.io/docs/prometheus/latest/querying/api/#targets
 func (dt *droppedTargets) WriteDroppedTargetsJSON(w io.Writer) {
 	dt.mu.Lock()
 	type keyStatus struct {
 		key            string
 		originalLabels []prompbmarshal.Label
+		reason         string
 	}
 	kss := make([]keyStatus, 0, len(dt.m))
 	for _, v := range dt.m {
@@ -282,6 +514,7 @@ func (dt *droppedTargets) WriteDroppedTargetsJSON(w io.Writer) {
 		kss = append(kss, keyStatus{
 			key:            key,
 			originalLabels: v.originalLabels,
+			reason:         v.reason,
 		})
 	}
 	dt.mu.Unlock()
@@ -293,7 +526,7 @@ func (dt *droppedTargets) WriteDroppedTargetsJSON(w io.Writer) {
 	for i, ks := range kss {
 		fmt.Fprintf(w, `{"discoveredLabels":`)
 		writeLabelsJSON(w, ks.originalLabels)
-		fmt.Fprintf(w, `}`)
+		fmt.Fprintf(w, `,"dropReason":%q}`, ks.reason)
 		if i+1 < len(kss) {
 			fmt.Fprintf(w, `,`)
 		}
@@ -301,6 +534,54 @@ func (dt *droppedTargets) WriteDroppedTargetsJSON(w io.Writer) {
 	fmt.Fprintf(w, `]`)
 }
 
+// WriteHumanReadable writes the registered dropped targets with drop reasons to w in human-readable form.
+func (dt *droppedTargets) WriteHumanReadable(w io.Writer) {
+	dt.mu.Lock()
+	lines := make([]string, 0, len(dt.m))
+	for _, v := range dt.m {
+		lines = append(lines, fmt.Sprintf("\treason=%q, discoveredLabels=%s\n", v.reason, promLabelsString(v.originalLabels)))
+	}
+	dt.mu.Unlock()
+
+	sort.Strings(lines)
+	fmt.Fprintf(w, "dropped targets (%d)\n", len(lines))
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s", line)
+	}
+}
+
+// getTargetsByPool returns the registered dropped targets per each scrape pool sorted by original labels.
+//
+// The scrape pool is obtained from `job` label in original labels, since it is set to `job_name` before relabeling.
+func (dt *droppedTargets) getTargetsByPool() map[string][]droppedTarget {
+	m := make(map[string][]droppedTarget)
+	dt.mu.Lock()
+	for _, v := range dt.m {
+		pool := promrelabel.GetLabelValueByName(v.originalLabels, "job")
+		m[pool] = append(m[pool], v)
+	}
+	dt.mu.Unlock()
+	for _, dts := range m {
+		sort.Slice(dts, func(i, j int) bool {
+			return promLabelsString(dts[i].originalLabels) < promLabelsString(dts[j].originalLabels)
+		})
+	}
+	return m
+}
+
+// getOriginalLabels returns original labels for the registered targets dropped because of the given reason.
+func (dt *droppedTargets) getOriginalLabels(reason string) [][]prompbmarshal.Label {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	var result [][]prompbmarshal.Label
+	for _, v := range dt.m {
+		if v.reason == reason {
+			result = append(result, v.originalLabels)
+		}
+	}
+	return result
+}
+
 var droppedTargetsMap = &droppedTargets{
 	m: make(map[string]droppedTarget),
 }
@@ -0,0 +1,222 @@
+package promscrape
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestTargetStatusByLabels(t *testing.T) {
+	sw := &ScrapeWork{
+		ID:        atomic.AddUint64(&nextScrapeWorkID, 1),
+		ScrapeURL: "http://foo.bar:1234/metrics",
+		Labels: []prompbmarshal.Label{
+			{
+				Name:  "__address__",
+				Value: "foo.bar:1234",
+			},
+			{
+				Name:  "instance",
+				Value: "foo.bar:1234",
+			},
+			{
+				Name:  "job",
+				Value: "test_target_status_by_labels",
+			},
+		},
+	}
+	tsmGlobal.Register(sw)
+	defer tsmGlobal.Unregister(sw)
+
+	// Registered, but not scraped yet target
+	labels := []prompbmarshal.Label{
+		{
+			Name:  "job",
+			Value: "test_target_status_by_labels",
+		},
+		{
+			Name:  "instance",
+			Value: "foo.bar:1234",
+		},
+	}
+	ts, ok := TargetStatusByLabels(labels)
+	if !ok {
+		t.Fatalf("cannot find target by labels %s", promLabelsString(labels))
+	}
+	if ts.Up || ts.LastError != nil || !ts.LastScrapeTime.IsZero() {
+		t.Fatalf("unexpected status for target, which wasn't scraped yet: %+v", ts)
+	}
+
+	// Failed scrape
+	scrapeTime := int64(1600000000123)
+	tsmGlobal.Update(sw, "test", false, scrapeTime, 456, 0, fmt.Errorf("connection refused"), "", nil)
+	ts, ok = TargetStatusByLabels(labels)
+	if !ok {
+		t.Fatalf("cannot find target by labels %s", promLabelsString(labels))
+	}
+	if ts.Up {
+		t.Fatalf("unexpected up status for failed target")
+	}
+	if ts.LastError == nil || ts.LastError.Error() != "connection refused" {
+		t.Fatalf("unexpected last error; got %v; want %q", ts.LastError, "connection refused")
+	}
+	if ts.LastScrapeTime.UnixNano()/1e6 != scrapeTime {
+		t.Fatalf("unexpected last scrape time; got %d; want %d", ts.LastScrapeTime.UnixNano()/1e6, scrapeTime)
+	}
+	if ts.LastScrapeDuration != 456*time.Millisecond {
+		t.Fatalf("unexpected last scrape duration; got %s; want %s", ts.LastScrapeDuration, 456*time.Millisecond)
+	}
+	if ts.ScrapeURL != sw.ScrapeURL {
+		t.Fatalf("unexpected scrape url; got %q; want %q", ts.ScrapeURL, sw.ScrapeURL)
+	}
+	if s := promLabelsString(ts.Labels); s != `{instance="foo.bar:1234",job="test_target_status_by_labels"}` {
+		t.Fatalf("unexpected labels: %s", s)
+	}
+
+	// Successful scrape
+	tsmGlobal.Update(sw, "test", true, scrapeTime+1000, 10, 5, nil, "", nil)
+	ts, ok = TargetStatusByLabels(labels)
+	if !ok {
+		t.Fatalf("cannot find target by labels %s", promLabelsString(labels))
+	}
+	if !ts.Up || ts.LastError != nil {
+		t.Fatalf("unexpected status for successfully scraped target: %+v", ts)
+	}
+
+	// Duplicate target with the same key
+	swDup := *sw
+	swDup.ID = atomic.AddUint64(&nextScrapeWorkID, 1)
+	tsmGlobal.Register(&swDup)
+	ts, ok = TargetStatusByLabels(labels)
+	tsmGlobal.Unregister(&swDup)
+	if !ok {
+		t.Fatalf("cannot find target by labels %s when duplicate target is registered", promLabelsString(labels))
+	}
+	if !ts.Up {
+		t.Fatalf("unexpected status for the duplicate target; got %+v; want the status of the target with the smallest ID", ts)
+	}
+
+	// Targets with the same labels and distinct scrape settings
+	swOther := *sw
+	swOther.ID = atomic.AddUint64(&nextScrapeWorkID, 1)
+	swOther.ScrapeInterval = time.Minute
+	tsmGlobal.Register(&swOther)
+	_, ok = TargetStatusByLabels(labels)
+	tsmGlobal.Unregister(&swOther)
+	if ok {
+		t.Fatalf("unexpected target found for labels shared by targets with distinct scrape settings")
+	}
+
+	// Labels mismatch
+	if _, ok := TargetStatusByLabels(labels[:1]); ok {
+		t.Fatalf("unexpected target found for partial labels")
+	}
+	if _, ok := TargetStatusByLabels([]prompbmarshal.Label{{Name: "job", Value: "missing"}}); ok {
+		t.Fatalf("unexpected target found for missing job")
+	}
+}
+
+func TestWriteScrapePoolsResponse(t *testing.T) {
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		WriteScrapePoolsResponse(&bb)
+		var resp struct {
+			Status string `json:"status"`
+			Data   struct {
+				ScrapePools []string `json:"scrapePools"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(bb.Bytes(), &resp); err != nil {
+			t.Fatalf("cannot parse response %q: %s", bb.String(), err)
+		}
+		if resp.Status != "success" || resp.Data.ScrapePools == nil {
+			t.Fatalf("unexpected response: %s", bb.String())
+		}
+		if bb.String() != resultExpected {
+			t.Fatalf("unexpected response\ngot\n%s\nwant\n%s", bb.String(), resultExpected)
+		}
+	}
+
+	// Scraper isn't running.
+	f(`{"status":"success","data":{"scrapePools":[]}}`)
+
+	var cfg Config
+	if err := cfg.parse([]byte(`
+scrape_configs:
+- job_name: foo
+  static_configs:
+  - targets: ["foo:1234"]
+  file_sd_configs:
+  - files: ["non-existing-file.yml"]
+- job_name: disabled
+  enabled: false
+  static_configs:
+  - targets: ["bar:1234"]
+- job_name: bar
+  static_configs:
+  - targets: ["bar:1234"]
+- job_name: empty
+`), "non-existing-file"); err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+	setActiveConfig(&cfg)
+	defer setActiveConfig(nil)
+
+	// Every enabled scrape config must be returned once, even if it has no targets.
+	f(`{"status":"success","data":{"scrapePools":["bar","empty","foo"]}}`)
+}
+
+func TestWriteServiceDiscoveryResponse(t *testing.T) {
+	var cfg Config
+	if err := cfg.parse([]byte(`
+scrape_configs:
+- job_name: service-discovery
+  static_configs:
+  - targets: ["foo:1234", "bar:1234"]
+    labels:
+      __meta_env: prod
+  relabel_configs:
+  - action: drop
+    source_labels: [__address__]
+    regex: "bar:.+"
+  - source_labels: [__meta_env]
+    target_label: env
+`), "sss"); err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+	setActiveConfig(&cfg)
+	defer setActiveConfig(nil)
+	sws := cfg.getStaticScrapeWork()
+	if len(sws) != 1 {
+		t.Fatalf("unexpected number of scrape works; got %d; want 1", len(sws))
+	}
+	tsmGlobal.Register(&sws[0])
+	defer tsmGlobal.Unregister(&sws[0])
+
+	var bb bytes.Buffer
+	WriteServiceDiscoveryResponse(&bb)
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(bb.Bytes(), &resp); err != nil {
+		t.Fatalf("cannot parse response %q: %s", bb.String(), err)
+	}
+	if resp.Status != "success" {
+		t.Fatalf("unexpected response: %s", bb.String())
+	}
+	poolExpected := `{"scrapePool":"service-discovery",` +
+		`"activeTargets":[{"discoveredLabels":{"__address__":"foo:1234","__meta_env":"prod","__metrics_path__":"/metrics","__scheme__":"http","job":"service-discovery"},` +
+		`"labels":{"env":"prod","instance":"foo:1234","job":"service-discovery"}}],` +
+		`"droppedTargets":[{"discoveredLabels":{"__address__":"bar:1234","__meta_env":"prod","__metrics_path__":"/metrics","__scheme__":"http","job":"service-discovery"},` +
+		`"dropReason":"relabeling"}]}`
+	if !strings.Contains(bb.String(), poolExpected) {
+		t.Fatalf("missing scrape pool in the response\ngot\n%s\nwant\n%s", bb.String(), poolExpected)
+	}
+}
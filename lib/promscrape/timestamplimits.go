@@ -0,0 +1,89 @@
+package promscrape
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// TimestampLimitsConfig contains settings for samples with timestamps out of the allowed window around the scrape time.
+//
+// The limits are applied only to samples with timestamps exposed by the target when `honor_timestamps: true` is set.
+type TimestampLimitsConfig struct {
+	// MaxFuture is the maximum allowed difference between the sample timestamp and the scrape time for samples in the future.
+	// Zero value means no limit.
+	MaxFuture time.Duration `yaml:"max_future,omitempty"`
+	// MaxPast is the maximum allowed difference between the scrape time and the sample timestamp for samples in the past.
+	// Zero value means no limit.
+	MaxPast time.Duration `yaml:"max_past,omitempty"`
+	// Action is the action for samples out of the allowed window. Supported values: drop and clamp. Samples are dropped by default.
+	Action string `yaml:"action,omitempty"`
+}
+
+// Supported values for `action` option in `timestamp_limits`.
+const (
+	// timestampLimitsDrop drops samples with timestamps out of the allowed window. This is the default.
+	timestampLimitsDrop = "drop"
+
+	// timestampLimitsClamp sets the timestamp for samples out of the allowed window to the nearest window boundary.
+	timestampLimitsClamp = "clamp"
+)
+
+// String returns string representation for tlc, which is used in ScrapeWork.key.
+func (tlc *TimestampLimitsConfig) String() string {
+	if tlc == nil {
+		return ""
+	}
+	return fmt.Sprintf("max_future=%s, max_past=%s, action=%s", tlc.MaxFuture, tlc.MaxPast, tlc.Action)
+}
+
+func (tlc *TimestampLimitsConfig) validate() error {
+	if tlc.MaxFuture < 0 {
+		return fmt.Errorf("`max_future` cannot be negative; got %s", tlc.MaxFuture)
+	}
+	if tlc.MaxPast < 0 {
+		return fmt.Errorf("`max_past` cannot be negative; got %s", tlc.MaxPast)
+	}
+	if tlc.MaxFuture == 0 && tlc.MaxPast == 0 {
+		return fmt.Errorf("at least `max_future` or `max_past` must be set")
+	}
+	switch tlc.Action {
+	case "", timestampLimitsDrop, timestampLimitsClamp:
+		return nil
+	default:
+		return fmt.Errorf("unsupported `action` %q; supported values: %q, %q", tlc.Action, timestampLimitsDrop, timestampLimitsClamp)
+	}
+}
+
+// apply applies tlc to sampleTimestamp for the sample scraped at scrapeTimestamp. Timestamps are in milliseconds.
+//
+// It returns the timestamp to use for the sample and false if the sample must be dropped.
+func (tlc *TimestampLimitsConfig) apply(sampleTimestamp, scrapeTimestamp int64) (int64, bool) {
+	if tlc == nil {
+		return sampleTimestamp, true
+	}
+	if tlc.MaxFuture > 0 {
+		maxTimestamp := scrapeTimestamp + tlc.MaxFuture.Milliseconds()
+		if sampleTimestamp > maxTimestamp {
+			return tlc.outOfBounds(maxTimestamp)
+		}
+	}
+	if tlc.MaxPast > 0 {
+		minTimestamp := scrapeTimestamp - tlc.MaxPast.Milliseconds()
+		if sampleTimestamp < minTimestamp {
+			return tlc.outOfBounds(minTimestamp)
+		}
+	}
+	return sampleTimestamp, true
+}
+
+func (tlc *TimestampLimitsConfig) outOfBounds(boundaryTimestamp int64) (int64, bool) {
+	samplesTimestampOutOfBounds.Inc()
+	if tlc.Action == timestampLimitsClamp {
+		return boundaryTimestamp, true
+	}
+	return 0, false
+}
+
+var samplesTimestampOutOfBounds = metrics.NewCounter(`vm_promscrape_samples_timestamp_out_of_bounds_total`)
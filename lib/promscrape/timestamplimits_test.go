@@ -0,0 +1,70 @@
+package promscrape
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestScrapeWorkTimestampLimits(t *testing.T) {
+	f := func(action, dataExpected string) {
+		t.Helper()
+		var sw scrapeWork
+		sw.Config = ScrapeWork{
+			HonorTimestamps: true,
+			TimestampLimits: &TimestampLimitsConfig{
+				MaxFuture: 10 * time.Minute,
+				MaxPast:   time.Hour,
+				Action:    action,
+			},
+		}
+		// Timestamps are in seconds, while the scrape timestamp is 10000000 seconds. See below.
+		data := `
+			in_window 1 10000300
+			far_future 2 20000000
+			far_past 3 1000000
+			without_timestamp 4
+		`
+		sw.ReadData = func(dst []byte) ([]byte, error) {
+			return append(dst, data...), nil
+		}
+		var pushDataErr error
+		sw.PushData = func(wr *prompbmarshal.WriteRequest) {
+			tss := wr.Timeseries
+			// Drop automatically generated series, since they aren't affected by timestamp_limits.
+			tss = tss[:len(tss)-6]
+			if err := expectEqualTimeseries(tss, parseData(dataExpected)); err != nil {
+				pushDataErr = fmt.Errorf("unexpected data pushed: %w\ngot\n%v", err, wr.Timeseries)
+			}
+		}
+		outOfBoundsPrev := samplesTimestampOutOfBounds.Get()
+		timestamp := int64(10000000000)
+		if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if pushDataErr != nil {
+			t.Fatalf("%s", pushDataErr)
+		}
+		if n := samplesTimestampOutOfBounds.Get() - outOfBoundsPrev; n != 2 {
+			t.Fatalf("unexpected number of samples with out of bounds timestamps; got %d; want 2", n)
+		}
+	}
+
+	// Drop samples with future and past timestamps. The drop action is the default.
+	dataExpected := `
+		in_window 1 10000300
+		without_timestamp 4 10000000
+	`
+	f("", dataExpected)
+	f("drop", dataExpected)
+
+	// Clamp future and past timestamps to the window boundaries.
+	f("clamp", `
+		in_window 1 10000300
+		far_future 2 10000600
+		far_past 3 9996400
+		without_timestamp 4 10000000
+	`)
+}
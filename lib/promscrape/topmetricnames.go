@@ -0,0 +1,72 @@
+package promscrape
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
+)
+
+// metricNameSeries contains the number of series for the metric name in a single scrape.
+type metricNameSeries struct {
+	name   string
+	series int
+}
+
+// metricNamesCounter counts the number of series per metric name during a single scrape.
+//
+// Only the top metric names are kept after the scrape, so the memory usage doesn't depend on the number of metric names between scrapes.
+type metricNamesCounter struct {
+	m map[string]int
+}
+
+// add counts series per metric name in rows.
+func (mc *metricNamesCounter) add(rows []parser.Row) {
+	if mc.m == nil {
+		mc.m = make(map[string]int)
+	}
+	for i := range rows {
+		name := rows[i].Metric
+		if n, ok := mc.m[name]; ok {
+			mc.m[name] = n + 1
+			continue
+		}
+		// Metric names may refer to the scraped response, which is re-used after the parsing, so they must be copied.
+		mc.m[bytesutil.InternString(name)] = 1
+	}
+}
+
+// top returns up to n metric names with the biggest number of series counted since the previous call and resets mc.
+func (mc *metricNamesCounter) top(n int) []metricNameSeries {
+	a := make([]metricNameSeries, 0, len(mc.m))
+	for name, series := range mc.m {
+		a = append(a, metricNameSeries{
+			name:   name,
+			series: series,
+		})
+		delete(mc.m, name)
+	}
+	sort.Slice(a, func(i, j int) bool {
+		if a[i].series != a[j].series {
+			return a[i].series > a[j].series
+		}
+		return a[i].name < a[j].name
+	})
+	if len(a) > n {
+		a = append([]metricNameSeries{}, a[:n]...)
+	}
+	return a
+}
+
+func writeTopMetricNamesJSON(w io.Writer, a []metricNameSeries) {
+	fmt.Fprintf(w, `[`)
+	for i, ms := range a {
+		fmt.Fprintf(w, `{"name":%q,"series":%d}`, ms.name, ms.series)
+		if i+1 < len(a) {
+			fmt.Fprintf(w, `,`)
+		}
+	}
+	fmt.Fprintf(w, `]`)
+}
@@ -0,0 +1,60 @@
+package promscrape
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+)
+
+func TestScrapeWorkCardinalityTopN(t *testing.T) {
+	var sw scrapeWork
+	sw.Config = ScrapeWork{
+		ID:              atomic.AddUint64(&nextScrapeWorkID, 1),
+		ScrapeURL:       "http://foo.bar/metrics",
+		CardinalityTopN: 2,
+	}
+	defer tsmGlobal.Unregister(&sw.Config)
+	sw.ReadData = func(dst []byte) ([]byte, error) {
+		data := `
+			http_requests_total{path="/a"} 1
+			http_requests_total{path="/b"} 2
+			http_requests_total{path="/c"} 3
+			http_requests_total{path="/d"} 4
+			process_cpu_seconds_total 5
+			go_goroutines 6
+			go_threads 7
+			go_threads 8
+		`
+		return append(dst, data...), nil
+	}
+	sw.PushData = func(wr *prompbmarshal.WriteRequest) {}
+	timestamp := int64(1700000000000)
+	if err := sw.scrapeInternal(timestamp, timestamp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tsmGlobal.mu.Lock()
+	st := tsmGlobal.m[sw.Config.ID]
+	tsmGlobal.mu.Unlock()
+	topExpected := []metricNameSeries{
+		{"http_requests_total", 4},
+		{"go_threads", 2},
+	}
+	if !reflect.DeepEqual(st.topMetricNames, topExpected) {
+		t.Fatalf("unexpected top metric names; got %v; want %v", st.topMetricNames, topExpected)
+	}
+	if len(sw.metricNames.m) != 0 {
+		t.Fatalf("metric names must be reset after the scrape; got %d entries", len(sw.metricNames.m))
+	}
+
+	var bb bytes.Buffer
+	tsmGlobal.WriteActiveTargetsJSON(&bb)
+	jsonExpected := `"topMetricNames":[{"name":"http_requests_total","series":4},{"name":"go_threads","series":2}]`
+	if !strings.Contains(bb.String(), jsonExpected) {
+		t.Fatalf("missing %s in /api/v1/targets response:\n%s", jsonExpected, bb.String())
+	}
+}
@@ -0,0 +1,81 @@
+package promscrape
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var traceTimings = flag.Bool("promscrape.traceTimings", false, "Whether to expose timings for scrape phases such as dns lookup, connect, tls handshake, "+
+	"time to first byte and body read via vm_promscrape_scrape_phase_seconds histograms. This may be useful for debugging scrape latency. "+
+	"Note that this switches scrape clients to net/http with per-request tracing, which increases CPU usage and memory allocations")
+
+// scrapePhaseTimings contains histograms for scrape phases for a scrape group.
+type scrapePhaseTimings struct {
+	dns      *metrics.Histogram
+	connect  *metrics.Histogram
+	tls      *metrics.Histogram
+	ttfb     *metrics.Histogram
+	bodyRead *metrics.Histogram
+}
+
+func newScrapePhaseTimings(group string) *scrapePhaseTimings {
+	newHistogram := func(phase string) *metrics.Histogram {
+		return metrics.GetOrCreateHistogram(fmt.Sprintf(`vm_promscrape_scrape_phase_seconds{phase=%q, type=%q}`, phase, group))
+	}
+	return &scrapePhaseTimings{
+		dns:      newHistogram("dns"),
+		connect:  newHistogram("connect"),
+		tls:      newHistogram("tls"),
+		ttfb:     newHistogram("ttfb"),
+		bodyRead: newHistogram("body_read"),
+	}
+}
+
+// withClientTrace returns ctx with httptrace.ClientTrace, which updates pt histograms.
+//
+// dns, connect and tls phases are registered only for new connections.
+// The returned onClose func must be called after reading the response body in order to register body_read phase.
+func (pt *scrapePhaseTimings) withClientTrace(ctx context.Context) (context.Context, func()) {
+	requestStart := time.Now()
+	var dnsStart, connectStart, tlsStart, firstByteTime time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			pt.dns.UpdateDuration(dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				pt.connect.UpdateDuration(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				pt.tls.UpdateDuration(tlsStart)
+			}
+		},
+		GotFirstResponseByte: func() {
+			firstByteTime = time.Now()
+			pt.ttfb.UpdateDuration(requestStart)
+		},
+	}
+	onClose := func() {
+		if !firstByteTime.IsZero() {
+			pt.bodyRead.UpdateDuration(firstByteTime)
+		}
+	}
+	return httptrace.WithClientTrace(ctx, trace), onClose
+}
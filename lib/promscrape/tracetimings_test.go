@@ -0,0 +1,70 @@
+package promscrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func TestScrapePhaseTimings(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "foo 1\n")
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s.URL, err)
+	}
+	ac, err := promauth.NewConfig(".", nil, "", "", &promauth.TLSConfig{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("cannot create auth config: %s", err)
+	}
+
+	traceTimingsOrig := *traceTimings
+	*traceTimings = true
+	defer func() {
+		*traceTimings = traceTimingsOrig
+	}()
+
+	// Use localhost instead of ip address in order to trigger dns lookup.
+	sw := &ScrapeWork{
+		ScrapeURL:      fmt.Sprintf("https://localhost:%s/metrics", u.Port()),
+		ScrapeInterval: 10 * time.Second,
+		ScrapeTimeout:  5 * time.Second,
+		AuthConfig:     ac,
+	}
+	c := newClient(sw)
+	c.phaseTimings = newScrapePhaseTimings("trace_test")
+	data, err := c.ReadData(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != "foo 1\n" {
+		t.Fatalf("unexpected data; got %q; want %q", data, "foo 1\n")
+	}
+
+	pt := c.phaseTimings
+	f := func(phase string, h *metrics.Histogram) {
+		t.Helper()
+		var n uint64
+		h.VisitNonZeroBuckets(func(vmrange string, count uint64) {
+			n += count
+		})
+		if n != 1 {
+			t.Fatalf("unexpected number of samples for phase %q; got %d; want 1", phase, n)
+		}
+	}
+	f("dns", pt.dns)
+	f("connect", pt.connect)
+	f("tls", pt.tls)
+	f("ttfb", pt.ttfb)
+	f("body_read", pt.bodyRead)
+}
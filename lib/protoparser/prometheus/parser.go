@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/metrics"
@@ -55,6 +56,35 @@ func (rs *Rows) UnmarshalWithErrLogger(s string, errLogger func(s string)) {
 	rs.Rows, rs.tagsPool = unmarshalRows(rs.Rows[:0], s, rs.tagsPool[:0], noEscapes, errLogger)
 }
 
+// UnmarshalWithDeadline unmarshals Prometheus exposition text rows from s until the given deadline.
+//
+// It calls errLogger for logging parsing errors.
+// The deadline is checked after parsing every deadlineCheckChunkSize bytes of s.
+// false is returned if the deadline is exceeded before s is fully parsed. rs contains rows parsed so far in this case.
+//
+// s shouldn't be modified while rs is in use.
+func (rs *Rows) UnmarshalWithDeadline(s string, errLogger func(s string), deadline time.Time) bool {
+	noEscapes := strings.IndexByte(s, '\\') < 0
+	rs.Rows = rs.Rows[:0]
+	rs.tagsPool = rs.tagsPool[:0]
+	for len(s) > 0 {
+		chunk := s
+		if len(chunk) > deadlineCheckChunkSize {
+			if n := strings.IndexByte(chunk[deadlineCheckChunkSize:], '\n'); n >= 0 {
+				chunk = chunk[:deadlineCheckChunkSize+n+1]
+			}
+		}
+		rs.Rows, rs.tagsPool = unmarshalRows(rs.Rows, chunk, rs.tagsPool, noEscapes, errLogger)
+		s = s[len(chunk):]
+		if len(s) > 0 && time.Now().After(deadline) {
+			return false
+		}
+	}
+	return true
+}
+
+const deadlineCheckChunkSize = 64 * 1024
+
 // Row is a single Prometheus row.
 type Row struct {
 	Metric    string
@@ -1,9 +1,12 @@
 package prometheus
 
 import (
+	"fmt"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPrevBackslashesCount(t *testing.T) {
@@ -413,3 +416,36 @@ cassandra_token_ownership_ratio 78.9`, &Rows{
 		},
 	})
 }
+
+func TestRowsUnmarshalWithDeadline(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; sb.Len() < 3*deadlineCheckChunkSize; i++ {
+		fmt.Fprintf(&sb, "foo{bar=\"baz\",i=\"%d\"} %d\n", i, i)
+	}
+	s := sb.String()
+	var rowsExpected Rows
+	rowsExpected.Unmarshal(s)
+	errLogger := func(s string) {
+		t.Fatalf("unexpected error: %s", s)
+	}
+
+	// The deadline isn't exceeded
+	var rows Rows
+	if !rows.UnmarshalWithDeadline(s, errLogger, time.Now().Add(time.Hour)) {
+		t.Fatalf("unexpected deadline exceeding")
+	}
+	if !reflect.DeepEqual(rows.Rows, rowsExpected.Rows) {
+		t.Fatalf("unexpected rows; got %d rows; want %d rows", len(rows.Rows), len(rowsExpected.Rows))
+	}
+
+	// The deadline is exceeded. Parsing must stop after the first chunk.
+	if rows.UnmarshalWithDeadline(s, errLogger, time.Now().Add(-time.Second)) {
+		t.Fatalf("expecting deadline exceeding")
+	}
+	if len(rows.Rows) == 0 || len(rows.Rows) >= len(rowsExpected.Rows) {
+		t.Fatalf("unexpected number of rows parsed before the deadline; got %d; want (0 ... %d)", len(rows.Rows), len(rowsExpected.Rows))
+	}
+	if !reflect.DeepEqual(rows.Rows, rowsExpected.Rows[:len(rows.Rows)]) {
+		t.Fatalf("unexpected rows parsed before the deadline")
+	}
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
+	"golang.org/x/net/proxy"
+)
+
+// URL implements YAML.Marshaler and yaml.Unmarshaler interfaces for `proxy_url` option.
+//
+// Only `socks5://` and `socks5h://` urls are supported for now.
+// Names for `socks5://` urls are resolved locally, while names for `socks5h://` urls are resolved by the proxy.
+type URL struct {
+	url *url.URL
+}
+
+// NewURL returns URL for the given u.
+func NewURL(u string) (*URL, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse proxy_url %q: %w", u, err)
+	}
+	switch pu.Scheme {
+	case "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported scheme for proxy_url %q: %q; supported schemes: socks5, socks5h", u, pu.Scheme)
+	}
+	if pu.Host == "" {
+		return nil, fmt.Errorf("missing host in proxy_url %q", u)
+	}
+	return &URL{
+		url: pu,
+	}, nil
+}
+
+// String returns string representation of u with the hidden password.
+func (u *URL) String() string {
+	if u == nil || u.url == nil {
+		return ""
+	}
+	pu := *u.url
+	if pu.User != nil {
+		if _, ok := pu.User.Password(); ok {
+			pu.User = url.UserPassword(pu.User.Username(), "secret")
+		}
+	}
+	return pu.String()
+}
+
+// MarshalYAML implements yaml.Marshaler interface.
+func (u *URL) MarshalYAML() (interface{}, error) {
+	if u == nil || u.url == nil {
+		return nil, nil
+	}
+	return u.url.String(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler interface.
+func (u *URL) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	pu, err := NewURL(s)
+	if err != nil {
+		return err
+	}
+	*u = *pu
+	return nil
+}
+
+// DialContextFunc is a function for establishing network connections.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// NewDialContextFunc returns a function, which establishes connections via u.
+//
+// Connections to the proxy are established with dialContext.
+// dialContext is returned as is if u is nil.
+func (u *URL) NewDialContextFunc(dialContext DialContextFunc) DialContextFunc {
+	if u == nil || u.url == nil {
+		return dialContext
+	}
+	var auth *proxy.Auth
+	if u.url.User != nil {
+		password, _ := u.url.User.Password()
+		auth = &proxy.Auth{
+			User:     u.url.User.Username(),
+			Password: password,
+		}
+	}
+	// proxy.SOCKS5 never returns errors.
+	d, _ := proxy.SOCKS5("tcp", u.url.Host, auth, forwardDialer(dialContext))
+	cd := d.(proxy.ContextDialer)
+	resolveLocally := u.url.Scheme == "socks5"
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if resolveLocally {
+			resolvedAddr, err := resolveAddr(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			addr = resolvedAddr
+		}
+		conn, err := cd.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to %q via proxy %q: %w", addr, u.String(), err)
+		}
+		return conn, nil
+	}
+}
+
+// NewDialFunc returns a function compatible with fasthttp.DialFunc, which establishes connections via u.
+//
+// Connections to the proxy are established with dialContext. Every connection attempt is limited by timeout.
+func (u *URL) NewDialFunc(dialContext DialContextFunc, timeout time.Duration) func(addr string) (net.Conn, error) {
+	dialContextFunc := u.NewDialContextFunc(dialContext)
+	return func(addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return dialContextFunc(ctx, "tcp", addr)
+	}
+}
+
+func resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse addr %q: %w", addr, err)
+	}
+	ipStr := host
+	if n := strings.IndexByte(ipStr, '%'); n >= 0 {
+		// Strip IPv6 zone identifier.
+		ipStr = ipStr[:n]
+	}
+	if net.ParseIP(ipStr) != nil {
+		return addr, nil
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %q: %w", host, err)
+	}
+	for _, ip := range ips {
+		if ip.IP.To4() != nil || netutil.TCP6Enabled() {
+			return net.JoinHostPort(ip.IP.String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("cannot find IPv4 address for %q; try -enableTCP6 command-line flag", host)
+}
+
+type forwardDialer DialContextFunc
+
+// Dial implements proxy.Dialer interface.
+func (fd forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return fd(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer interface.
+func (fd forwardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return fd(ctx, network, addr)
+}